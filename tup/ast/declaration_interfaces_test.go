@@ -11,6 +11,7 @@ var _ TopLevelItem = &ExportTypeQualifiedDeclaration{}
 var _ TopLevelItem = &ExportTypeDeclaration{}
 var _ TopLevelItem = &ExportFunctionDeclaration{}
 var _ TopLevelItem = &ExportAssignment{}
+var _ TopLevelItem = &MetaExpression{}
 
 var _ Statement = &Assignment{}
 var _ Statement = &FunctionDeclaration{}
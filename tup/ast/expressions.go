@@ -30,6 +30,7 @@ func (n *Identifier) expressionNode()            {}
 func (n *ItExpression) expressionNode()          {}
 func (n *FunctionIdentifier) expressionNode()    {}
 func (n *Block) expressionNode()                 {}
+func (n *FunctionBlock) expressionNode()         {}
 func (n *IfExpression) expressionNode()          {}
 func (n *SwitchExpression) expressionNode()      {}
 func (n *ForExpression) expressionNode()         {}
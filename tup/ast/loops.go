@@ -76,11 +76,12 @@ func (s *StepExpression) String() string {
 	return s.Expression.String()
 }
 
-// iterable = expression .
+// iterable = type_reference | expression .
 
 type Iterable struct {
 	BaseNode
-	Expression Expression
+	Expression    Expression     // nil if iterating over a type
+	TypeReference *TypeReference // the enum type iterated over, or nil
 }
 
 func NewIterable(expression Expression) *Iterable {
@@ -90,7 +91,17 @@ func NewIterable(expression Expression) *Iterable {
 	}
 }
 
+func NewIterableType(typeRef *TypeReference) *Iterable {
+	return &Iterable{
+		BaseNode:      BaseNode{Type: NodeIterable},
+		TypeReference: typeRef,
+	}
+}
+
 func (i *Iterable) String() string {
+	if i.TypeReference != nil {
+		return i.TypeReference.String()
+	}
 	return i.Expression.String()
 }
 
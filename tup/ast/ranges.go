@@ -35,7 +35,15 @@ func NewRange(start, end *RangeBound) *Range {
 }
 
 func (r *Range) String() string {
-	return r.StartBound.String() + ".." + r.EndBound.String()
+	// the bounds of ranges built other than by the parser may be missing
+	var start, end string
+	if r.StartBound != nil {
+		start = r.StartBound.String()
+	}
+	if r.EndBound != nil {
+		end = r.EndBound.String()
+	}
+	return start + ".." + end
 }
 
 // RestOperator represents the rest/spread operator (...)
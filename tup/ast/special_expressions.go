@@ -2,11 +2,12 @@ package ast
 
 import "strings"
 
-// typeof_expression = "typeof" "(" expression ")" .
+// typeof_expression = "typeof" "(" ( expression | type ) ")" .
 
 type TypeofExpression struct {
 	BaseNode
-	Expression Expression // The expression to get the type of
+	Expression Expression // The expression to get the type of, or nil
+	TypeArg    TypeNode   // The type to describe, or nil
 }
 
 func NewTypeofExpression(expression Expression) *TypeofExpression {
@@ -16,7 +17,17 @@ func NewTypeofExpression(expression Expression) *TypeofExpression {
 	}
 }
 
+func NewTypeofType(typ TypeNode) *TypeofExpression {
+	return &TypeofExpression{
+		BaseNode: BaseNode{Type: NodeTypeofExpression},
+		TypeArg:  typ,
+	}
+}
+
 func (t *TypeofExpression) String() string {
+	if t.TypeArg != nil {
+		return "typeof(" + t.TypeArg.String() + ")"
+	}
 	return "typeof(" + t.Expression.String() + ")"
 }
 
@@ -140,31 +151,35 @@ func (t *TryExpression) String() string {
 // MetaExpression represents a compile-time meta expression (e.g., $(key: value))
 type MetaExpression struct {
 	BaseNode
-	KeyValues map[string]Node // The key-value pairs
+	Args []*LabeledArgument // The key-value pairs, in source order
 }
 
-func NewMetaExpression(keyValues map[string]Node) *MetaExpression {
+func NewMetaExpression(args []*LabeledArgument) *MetaExpression {
 	return &MetaExpression{
-		BaseNode:  BaseNode{Type: NodeMetaExpression},
-		KeyValues: keyValues,
+		BaseNode: BaseNode{Type: NodeMetaExpression},
+		Args:     args,
 	}
 }
 
+// Lookup returns the value of the first argument labeled key, or nil if there is none.
+func (m *MetaExpression) Lookup(key string) *Argument {
+	for _, arg := range m.Args {
+		if arg.Identifier.Name == key {
+			return arg.Argument
+		}
+	}
+	return nil
+}
+
 func (m *MetaExpression) String() string {
 	var builder strings.Builder
 	builder.WriteString("$(")
-
-	i := 0
-	for key, value := range m.KeyValues {
+	for i, arg := range m.Args {
 		if i > 0 {
 			builder.WriteString(", ")
 		}
-		builder.WriteString(key)
-		builder.WriteString(": ")
-		builder.WriteString(value.String())
-		i++
+		builder.WriteString(arg.String())
 	}
-
 	builder.WriteString(")")
 	return builder.String()
 }
@@ -6,6 +6,7 @@ package ast
 //                  | function_type_declaration
 //                  | function_declaration
 //                  | assignment
+//                  | meta_expression
 //                  | export_declaration
 //                  ) .
 
@@ -20,6 +21,7 @@ func (n *FunctionTypeDeclaration) topLevelItemNode()          {}
 func (n *TypeDeclaration) topLevelItemNode()                  {}
 func (n *TypeQualifiedDeclaration) topLevelItemNode()         {}
 func (n *TypeQualifiedFunctionDeclaration) topLevelItemNode() {}
+func (n *MetaExpression) topLevelItemNode()                   {}
 
 // ExportDeclaration
 func (n *ExportTypeQualifiedFunctionDeclaration) topLevelItemNode() {}
@@ -4,11 +4,13 @@ import "strings"
 
 // tuple_member = expression .
 // labeled_tuple_member = identifier ":" tuple_member .
+// spread_argument = spread_op expression .
 
 type TupleMember struct {
 	BaseNode
-	Label *Identifier
-	Value Expression
+	Label  *Identifier
+	Value  Expression
+	Spread bool // True if the member spreads the fields of another tuple
 }
 
 func NewTupleMember(label *Identifier, value Expression) *TupleMember {
@@ -19,8 +21,19 @@ func NewTupleMember(label *Identifier, value Expression) *TupleMember {
 	}
 }
 
+func NewSpreadTupleMember(value Expression) *TupleMember {
+	return &TupleMember{
+		BaseNode: BaseNode{Type: NodeTupleMember},
+		Value:    value,
+		Spread:   true,
+	}
+}
+
 func (t *TupleMember) String() string {
 	var builder strings.Builder
+	if t.Spread {
+		builder.WriteString("...")
+	}
 	if t.Label != nil {
 		builder.WriteString(t.Label.String())
 		builder.WriteString(": ")
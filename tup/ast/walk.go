@@ -0,0 +1,198 @@
+package ast
+
+import (
+	"reflect"
+	"sort"
+)
+
+var nodeInterface = reflect.TypeOf((*Node)(nil)).Elem()
+
+// Children returns the immediate child nodes of node in field order.
+// Nil children are omitted.
+func Children(node Node) []Node {
+	var children []Node
+	if node == nil {
+		return children
+	}
+	v := reflect.ValueOf(node)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return children
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		children = appendFieldChildren(children, v)
+	}
+	return children
+}
+
+func appendFieldChildren(children []Node, v reflect.Value) []Node {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !v.Type().Field(i).IsExported() || field.Type() == reflect.TypeOf(BaseNode{}) {
+			continue
+		}
+		children = appendChildren(children, field)
+	}
+	return children
+}
+
+func appendChildren(children []Node, v reflect.Value) []Node {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return children
+		}
+		if node, ok := v.Interface().(Node); ok {
+			return append(children, node)
+		}
+	case reflect.Struct:
+		if v.CanAddr() && v.Addr().Type().Implements(nodeInterface) {
+			return append(children, v.Addr().Interface().(Node))
+		}
+		// embedded node structs, such as ArrayType in DynamicArrayType
+		return appendFieldChildren(children, v)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			children = appendChildren(children, v.Index(i))
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			children = appendChildren(children, v.MapIndex(key))
+		}
+	}
+	return children
+}
+
+// Inspect traverses the tree rooted at node in depth-first order. It calls
+// f(node) and, if f returns true, inspects each of the node's children.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	for _, child := range Children(node) {
+		Inspect(child, f)
+	}
+}
+
+// PosOf returns the position of node or, for nodes that do not record a
+// position of their own, the position of their first descendant that does.
+func PosOf(node Node) (pos Position) {
+	Inspect(node, func(n Node) bool {
+		if pos.Line > 0 {
+			return false
+		}
+		if p := n.Pos(); p.Line > 0 {
+			pos = p
+			return false
+		}
+		return true
+	})
+	return pos
+}
+
+// Rewrite traverses the tree rooted at node in depth-first order, calling
+// f for each node before its children. If f returns a different node, the
+// replacement takes the original's place and its children are rewritten
+// in turn. Nodes are never modified in place: any node with a replaced
+// descendant is shallow-copied, so the original tree is left intact.
+func Rewrite(node Node, f func(Node) Node) Node {
+	if node == nil {
+		return nil
+	}
+	node = f(node)
+	if node == nil {
+		return nil
+	}
+	v := reflect.ValueOf(node)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return node
+	}
+	if copied, changed := rewriteStruct(v.Elem(), f); changed {
+		return copied.Addr().Interface().(Node)
+	}
+	return node
+}
+
+// rewriteStruct rewrites the node fields of the struct v. If any field
+// changed, it returns an addressable copy of v holding the new fields.
+func rewriteStruct(v reflect.Value, f func(Node) Node) (reflect.Value, bool) {
+	var copied reflect.Value
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !v.Type().Field(i).IsExported() || field.Type() == reflect.TypeOf(BaseNode{}) {
+			continue
+		}
+		if newField, changed := rewriteValue(field, f); changed {
+			if !copied.IsValid() {
+				copied = reflect.New(v.Type()).Elem()
+				copied.Set(v)
+			}
+			copied.Field(i).Set(newField)
+		}
+	}
+	return copied, copied.IsValid()
+}
+
+func rewriteValue(v reflect.Value, f func(Node) Node) (reflect.Value, bool) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			break
+		}
+		node, ok := v.Interface().(Node)
+		if !ok {
+			break
+		}
+		newNode := Rewrite(node, f)
+		if newNode == node {
+			break
+		}
+		newValue := reflect.ValueOf(newNode)
+		if newNode == nil {
+			newValue = reflect.Zero(v.Type())
+		}
+		if !newValue.Type().AssignableTo(v.Type()) {
+			// the replacement cannot take the original's place
+			break
+		}
+		return newValue, true
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(BaseNode{}) {
+			break
+		}
+		return rewriteStruct(v, f)
+	case reflect.Slice:
+		var copied reflect.Value
+		for i := 0; i < v.Len(); i++ {
+			if newElem, changed := rewriteValue(v.Index(i), f); changed {
+				if !copied.IsValid() {
+					copied = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+					reflect.Copy(copied, v)
+				}
+				copied.Index(i).Set(newElem)
+			}
+		}
+		if copied.IsValid() {
+			return copied, true
+		}
+	}
+	return v, false
+}
+
+// Clone returns a deep copy of the tree rooted at node. Identifiers and
+// other leaves are copied too, so no node is shared with the original.
+func Clone(node Node) Node {
+	return Rewrite(node, func(n Node) Node {
+		v := reflect.ValueOf(n)
+		if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			return n
+		}
+		copied := reflect.New(v.Elem().Type())
+		copied.Elem().Set(v.Elem())
+		return copied.Interface().(Node)
+	})
+}
@@ -0,0 +1,63 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/rowland/tuppence/tup/source"
+)
+
+func TestChildren(t *testing.T) {
+	src := source.NewSource([]byte("x + 1"), "test.tup")
+	x := NewIdentifier("x", src, 0, 1)
+	one := NewDecimalLiteral("1", 1, src, 4, 1)
+	expr := NewAddSubExpression(x, OpAdd, one)
+
+	children := Children(expr)
+	if len(children) != 2 || children[0] != x || children[1] != one {
+		t.Fatalf("Children(%s) = %v, want [x 1]", expr, children)
+	}
+	if got := PosOf(expr); got.Line != 1 || got.Column != 1 {
+		t.Errorf("PosOf(%s) = %v, want 1:1", expr, got)
+	}
+	if got := PosOf(NewTupleLiteral(false, []*TupleMember{NewTupleMember(nil, one)})); got.Column != 5 {
+		t.Errorf("PosOf(tuple) = %v, want column 5", got)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	x := NewIdentifier("x", nil, 0, 0)
+	y := NewIdentifier("y", nil, 0, 0)
+	block := NewBlock(NewBlockBody(
+		[]Statement{NewAssignment(NewOrdinalAssignmentLHS([]*Identifier{y}, nil), Immutable, x)},
+		NewAddSubExpression(x, OpAdd, y),
+	))
+
+	rewritten := Rewrite(block, func(node Node) Node {
+		if ident, ok := node.(*Identifier); ok && ident.Name == "x" {
+			return NewDecimalLiteral("1", 1, nil, 0, 0)
+		}
+		return node
+	})
+
+	if got, want := rewritten.String(), NewBlock(NewBlockBody(
+		[]Statement{NewAssignment(NewOrdinalAssignmentLHS([]*Identifier{y}, nil), Immutable, NewDecimalLiteral("1", 1, nil, 0, 0))},
+		NewAddSubExpression(NewDecimalLiteral("1", 1, nil, 0, 0), OpAdd, y),
+	)).String(); got != want {
+		t.Errorf("Rewrite() = %s, want %s", got, want)
+	}
+	if got := block.Body.Expression.String(); got != "x + y" {
+		t.Errorf("Rewrite() modified the original tree: %s", got)
+	}
+}
+
+func TestClone(t *testing.T) {
+	x := NewIdentifier("x", nil, 0, 0)
+	expr := NewAddSubExpression(x, OpAdd, x)
+	clone := Clone(expr).(*AddSubExpression)
+	if clone == expr || clone.Left == Expression(x) || clone.Right == Expression(x) {
+		t.Error("Clone() shares nodes with the original")
+	}
+	if clone.String() != expr.String() {
+		t.Errorf("Clone() = %s, want %s", clone, expr)
+	}
+}
@@ -0,0 +1,256 @@
+// Package bind maps the arguments of a function call to the parameters of
+// the function called. The checker, the compile-time evaluator and the
+// backends all bind calls with it, so that they agree on the arguments
+// each parameter receives.
+//
+// Positional arguments, which include the receiver of a uniform function
+// call, are bound in order. A rest parameter collects the positional
+// arguments not bound to the parameters around it, each of which
+// contributes one element, or all of its elements if it is spread. A
+// trailing block is bound to the final parameter, which must be callable.
+// Labeled arguments are bound by name, and parameters receiving no
+// argument take their default values.
+package bind
+
+import (
+	"fmt"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Param describes a parameter of the function called.
+type Param struct {
+	Name string // "" for an unlabeled parameter
+	Rest bool
+	// Callable is set if the parameter takes a function, so that it may
+	// receive a trailing block.
+	Callable bool
+	// Default is the default value of the parameter, or nil if it has
+	// none.
+	Default ast.Expression
+}
+
+func (p *Param) describe(i int) string {
+	if p.Name == "" {
+		return fmt.Sprintf("parameter %d", i+1)
+	}
+	return "parameter " + p.Name
+}
+
+// Params returns the parameters of a function of type sig declared with
+// params. Either may be nil: the declaration supplies names, rest
+// parameters and default values, and the type tells which parameters are
+// callable.
+func Params(sig *types.Function, params []ast.FunctionTypeParameter) []Param {
+	var result []Param
+	if params == nil && sig != nil {
+		for i, param := range sig.Params {
+			result = append(result, Param{Name: param.Name, Rest: i == sig.Rest, Callable: isCallable(param.Type)})
+		}
+		return result
+	}
+	for i, param := range params {
+		var p Param
+		switch param := param.(type) {
+		case *ast.Parameter:
+			p.Default, _ = param.Type.(ast.Literal)
+			_, p.Callable = param.Type.(*ast.FunctionType)
+		case *ast.LabeledParameter:
+			p.Name = param.Identifier.Name
+			p.Default, _ = param.Type.(ast.Literal)
+			_, p.Callable = param.Type.(*ast.FunctionType)
+		case *ast.RestParameter:
+			p.Rest = true
+		case *ast.LabeledRestParameter:
+			p.Name = param.Identifier.Name
+			p.Rest = true
+		}
+		if sig != nil && i < len(sig.Params) {
+			p.Callable = isCallable(sig.Params[i].Type)
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+func isCallable(typ types.Type) bool {
+	_, ok := typ.Underlying().(*types.Function)
+	return ok
+}
+
+// Call describes the arguments of a call.
+type Call struct {
+	// Node is the call, at which errors about it as a whole are reported.
+	Node ast.Node
+	// Name is the name of the function called, used in errors.
+	Name string
+	// Positional holds the positional arguments, preceded by the receiver
+	// of a uniform function call if there is one.
+	Positional []*ast.Argument
+	Labeled    []*ast.LabeledArgument
+	// Block is the trailing block, or nil.
+	Block *ast.FunctionBlock
+	// Partial is set for a partial application f(args, *), which leaves
+	// the parameters receiving no argument to the closure it produces.
+	Partial bool
+}
+
+// NewCall returns the description of call, whose receiver is recv if it
+// is a uniform function call and nil otherwise.
+func NewCall(call *ast.FunctionCall, recv ast.Expression, name string) *Call {
+	c := &Call{Node: call, Name: name, Block: call.FunctionBlock}
+	if recv != nil {
+		c.Positional = append(c.Positional, ast.NewArgument(recv, false))
+	}
+	if args := call.Arguments; args != nil {
+		if args.Args != nil {
+			c.Positional = append(c.Positional, args.Args.Args...)
+		}
+		if args.LabeledArgs != nil {
+			c.Labeled = args.LabeledArgs.Args
+		}
+		c.Partial = args.PartialApplication
+	}
+	return c
+}
+
+// Binding holds the arguments bound to the parameters of a call.
+type Binding struct {
+	Params []Param
+	// Args holds the arguments bound to each parameter, in the order
+	// written. A rest parameter may receive any number; any other
+	// parameter receives at most one. A trailing block is bound as an
+	// argument whose expression is the block.
+	Args [][]*ast.Argument
+	// Defaults holds the default value taken by each parameter receiving
+	// no argument, or nil. Defaults are evaluated in the scope of the
+	// callee, after the arguments.
+	Defaults []ast.Expression
+}
+
+// Error reports an argument that cannot be bound.
+type Error struct {
+	Node ast.Node
+	Msg  string
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+// Bind binds the arguments of call to params. Arguments that cannot be
+// bound are reported by the returned errors and left out of the binding.
+func Bind(params []Param, call *Call) (*Binding, []*Error) {
+	b := &Binding{
+		Params:   params,
+		Args:     make([][]*ast.Argument, len(params)),
+		Defaults: make([]ast.Expression, len(params)),
+	}
+	var errs []*Error
+	errorf := func(node ast.Node, format string, args ...any) {
+		errs = append(errs, &Error{Node: node, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	rest := -1
+	for i, param := range params {
+		if param.Rest {
+			rest = i
+			break
+		}
+	}
+	last := len(params) - 1
+	if block := call.Block; block != nil {
+		if last < 0 || !params[last].Callable {
+			errorf(block, "cannot pass a trailing block to %s: its final parameter is not callable", call.Name)
+		} else {
+			b.Args[last] = []*ast.Argument{ast.NewArgument(block, false)}
+			last--
+		}
+	}
+
+	// the parameter receiving each positional argument
+	positional := call.Positional
+	n := len(positional)
+	var indices []int
+	if rest >= 0 && rest <= last {
+		// the parameters after the rest parameter take the final positional
+		// arguments, unless they are given by label
+		labeled := map[string]bool{}
+		for _, arg := range call.Labeled {
+			labeled[arg.Identifier.Name] = true
+		}
+		var after []int
+		for i := rest + 1; i <= last; i++ {
+			if !labeled[params[i].Name] {
+				after = append(after, i)
+			}
+		}
+		if call.Partial {
+			after = nil
+		}
+		for i := 0; i < rest && i < n; i++ {
+			indices = append(indices, i)
+		}
+		for range max(n-len(indices)-len(after), 0) {
+			indices = append(indices, rest)
+		}
+		for _, i := range after {
+			if len(indices) < n {
+				indices = append(indices, i)
+			}
+		}
+	} else {
+		for i := 0; i <= last && i < n; i++ {
+			indices = append(indices, i)
+		}
+	}
+	if n > len(indices) {
+		errorf(positional[len(indices)].Expr, "too many arguments in call to %s", call.Name)
+	}
+	spread := func(arg *ast.Argument, i int) bool {
+		if arg.Spread && !params[i].Rest {
+			errorf(arg.Expr, "cannot spread an argument into %s of %s: only a rest parameter collects its elements", params[i].describe(i), call.Name)
+			return false
+		}
+		return true
+	}
+	for j, i := range indices {
+		if spread(positional[j], i) {
+			b.Args[i] = append(b.Args[i], positional[j])
+		}
+	}
+
+	for _, arg := range call.Labeled {
+		name := arg.Identifier.Name
+		i := -1
+		for j, param := range params {
+			if param.Name == name {
+				i = j
+				break
+			}
+		}
+		switch {
+		case i < 0:
+			errorf(arg.Identifier, "%s has no parameter %s", call.Name, name)
+		case b.Args[i] != nil || i > last:
+			errorf(arg.Identifier, "parameter %s of %s is given more than once", name, call.Name)
+		default:
+			if spread(arg.Argument, i) {
+				b.Args[i] = append(b.Args[i], arg.Argument)
+			}
+		}
+	}
+
+	if call.Partial {
+		return b, errs
+	}
+	for i, param := range params {
+		switch {
+		case b.Args[i] != nil || param.Rest:
+		case param.Default != nil:
+			b.Defaults[i] = param.Default
+		default:
+			errorf(call.Node, "missing argument for %s in call to %s", param.describe(i), call.Name)
+		}
+	}
+	return b, errs
+}
@@ -0,0 +1,145 @@
+package bind
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+	"github.com/rowland/tuppence/tup/tok"
+)
+
+func parseCall(t *testing.T, input string) *ast.FunctionCall {
+	t.Helper()
+	tokens, err := tok.Tokenize([]byte(input), "test.tup")
+	if err != nil {
+		t.Fatalf("Tokenize(%q) = %v", input, err)
+	}
+	expr, _, err := parse.Expression(tokens)
+	if err != nil {
+		t.Fatalf("Expression(%q) = %v", input, err)
+	}
+	call, ok := expr.(*ast.FunctionCall)
+	if !ok {
+		t.Fatalf("Expression(%q) = %T, want *ast.FunctionCall", input, expr)
+	}
+	return call
+}
+
+// format returns the arguments bound to each parameter, as in
+// "a = 1; xs = [2, ...ys]; b = default 3".
+func format(b *Binding) string {
+	var parts []string
+	for i, param := range b.Params {
+		name := param.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		var args []string
+		for _, arg := range b.Args[i] {
+			switch {
+			case arg.Spread:
+				args = append(args, "..."+arg.Expr.String())
+			case isBlock(arg):
+				args = append(args, "block")
+			default:
+				args = append(args, arg.Expr.String())
+			}
+		}
+		switch {
+		case param.Rest:
+			parts = append(parts, fmt.Sprintf("%s = [%s]", name, strings.Join(args, ", ")))
+		case b.Defaults[i] != nil:
+			parts = append(parts, fmt.Sprintf("%s = default %s", name, b.Defaults[i]))
+		case len(args) == 0:
+			parts = append(parts, name+" = *")
+		default:
+			parts = append(parts, name+" = "+strings.Join(args, ", "))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func isBlock(arg *ast.Argument) bool {
+	_, ok := arg.Expr.(*ast.FunctionBlock)
+	return ok
+}
+
+// declParams returns the parameters of the function declared by decl.
+func declParams(t *testing.T, decl string) []Param {
+	t.Helper()
+	module, err := parse.Module(source.NewSource([]byte(decl), "test.tup"), ast.NewModule("test"))
+	if err != nil {
+		t.Fatalf("parse.Module(%q) = %v", decl, err)
+	}
+	fn := module.TopLevelItems[0].(*ast.FunctionDeclaration)
+	return Params(nil, fn.Type.Parameters)
+}
+
+func TestBind(t *testing.T) {
+	const (
+		add     = "add = fn(a: Int, b: Int) Int { a + b }"
+		hello   = "hello = fn(entity: \"World\", punctuation: \"!\") String { entity }"
+		sum     = "sum = fn(base: Int, xs: ...Int) Int { base }"
+		process = "process = fn(args: ...Int, transform: fn(Int) Int) Int { 0 }"
+		apply   = "apply = fn(x: Int, f: fn(Int) Int) Int { f(x) }"
+	)
+	tests := []struct {
+		name  string
+		decl  string
+		call  string
+		want  string
+		error string
+	}{
+		{"positional", add, "add(1, 2)", "a = 1; b = 2", ""},
+		{"labeled", add, "add(b: 1, a: 2)", "a = 2; b = 1", ""},
+		{"mixed", add, "add(1, b: 2)", "a = 1; b = 2", ""},
+		{"defaults", hello, "hello()", "entity = default \"World\"; punctuation = default \"!\"", ""},
+		{"default after positional", hello, "hello(\"John\")", "entity = \"John\"; punctuation = default \"!\"", ""},
+		{"default before labeled", hello, "hello(punctuation: \"?\")", "entity = default \"World\"; punctuation = \"?\"", ""},
+		{"rest", sum, "sum(1, 2, 3)", "base = 1; xs = [2, 3]", ""},
+		{"empty rest", sum, "sum(1)", "base = 1; xs = []", ""},
+		{"spread", sum, "sum(1, 2, ...ys)", "base = 1; xs = [2, ...ys]", ""},
+		{"labeled rest", sum, "sum(1, xs: ...ys)", "base = 1; xs = [...ys]", ""},
+		{"rest and callable", process, "process(1, 2, double)", "args = [1, 2]; transform = double", ""},
+		{"rest and labeled callable", process, "process(1, 2, 3, transform: double)", "args = [1, 2, 3]; transform = double", ""},
+		{"rest and trailing block", process, "process(1, 2) { it * 2 }", "args = [1, 2]; transform = block", ""},
+		{"trailing block", apply, "apply(1) { it }", "x = 1; f = block", ""},
+		{"partial", add, "add(1, *)", "a = 1; b = *", ""},
+		{"uniform call", add, "n.add(2)", "a = n; b = 2", ""},
+		{"missing argument", add, "add(1)", "", "missing argument for parameter b in call to add"},
+		{"too many arguments", add, "add(1, 2, 3)", "", "too many arguments in call to add"},
+		{"no such parameter", add, "add(1, c: 2)", "", "add has no parameter c"},
+		{"given twice", add, "add(1, a: 2)", "", "parameter a of add is given more than once"},
+		{"block given twice", apply, "apply(1, f: g) { it }", "", "parameter f of apply is given more than once"},
+		{"block not callable", add, "add(1) { it }", "", "cannot pass a trailing block to add: its final parameter is not callable"},
+		{"spread into other parameter", add, "add(...xs)", "", "cannot spread an argument into parameter a of add: only a rest parameter collects its elements"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := declParams(t, tt.decl)
+			expr := parseCall(t, tt.call)
+			var recv ast.Expression
+			name := expr.Function.String()
+			if member, ok := expr.Function.(*ast.MemberAccess); ok {
+				recv = member.Object.(ast.Expression)
+				name = member.Member.String()
+			}
+			b, errs := Bind(params, NewCall(expr, recv, name))
+			if tt.error != "" {
+				if len(errs) == 0 || errs[0].Msg != tt.error {
+					t.Fatalf("Bind(%s) errors = %v, want %q", tt.call, errs, tt.error)
+				}
+				return
+			}
+			if len(errs) > 0 {
+				t.Fatalf("Bind(%s) errors = %v", tt.call, errs)
+			}
+			if got := format(b); got != tt.want {
+				t.Errorf("Bind(%s) = %s, want %s", tt.call, got, tt.want)
+			}
+		})
+	}
+}
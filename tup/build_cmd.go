@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/bytecode"
+	"github.com/rowland/tuppence/tup/cgen"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/gogen"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/opt"
+	"github.com/rowland/tuppence/tup/wasm"
+	"github.com/rowland/tuppence/tup/wasmgen"
+	"github.com/spf13/pflag"
+)
+
+// buildCommand checks a module, lowers and optimizes it and translates it
+// for a target. The C target, the default, compiles the C program into an
+// executable with the local C compiler, $CC or cc; with --emit-c the C
+// program is written instead. The Go target writes a Go package to a
+// directory, with a go.mod unless the directory has one. The wasm target
+// writes a WebAssembly module, and beside it the module in the text format
+// with the extension .wat. The bytecode target writes a program for the VM,
+// with the extension .tupc, which tup run runs. The output is named after
+// the file unless -o is given:
+//
+//	tup build [-o output] [--target=c|go|wasm|bytecode] [--emit-c] [--cc=compiler] file.tup
+func buildCommand(args []string) error {
+	flags := pflag.NewFlagSet("build", pflag.ContinueOnError)
+	output := flags.StringP("output", "o", "", "Output file, or directory for the Go target")
+	target := flags.String("target", "c", "Target: c, go, wasm or bytecode")
+	emitC := flags.Bool("emit-c", false, "Write the C program rather than compiling it")
+	cc := flags.String("cc", cgen.CC(), "C compiler")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: tup build [-o output] [--target=c|go|wasm|bytecode] [--emit-c] [--cc=compiler] file.tup")
+	}
+	switch *target {
+	case "c", "go", "wasm", "bytecode":
+	default:
+		return fmt.Errorf("unknown target %q; want c, go, wasm or bytecode", *target)
+	}
+	if *emitC && *target != "c" {
+		return fmt.Errorf("--emit-c applies to the c target only")
+	}
+	filename := flags.Arg(0)
+	lower := ir.Lower
+	if *target == "go" {
+		// Go has type parameters of its own
+		lower = ir.LowerGeneric
+	}
+	m, err := lowerFile(filename, lower)
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(filepath.Base(filename), ".tup")
+	if *target == "bytecode" {
+		p, err := bytecode.Compile(m)
+		if err != nil {
+			return err
+		}
+		if *output == "" {
+			*output = base + ".tupc"
+		}
+		return os.WriteFile(*output, bytecode.Encode(p), 0o644)
+	}
+	if *target == "go" {
+		files, err := gogen.Generate(m)
+		if err != nil {
+			return err
+		}
+		if *output == "" {
+			*output = base
+		}
+		return gogen.WritePackage(*output, base, files)
+	}
+	if *target == "wasm" {
+		mod, err := wasmgen.Generate(m)
+		if err != nil {
+			return err
+		}
+		if *output == "" {
+			*output = base + ".wasm"
+		}
+		if err := os.WriteFile(*output, wasm.Encode(mod), 0o644); err != nil {
+			return err
+		}
+		wat := strings.TrimSuffix(*output, ".wasm") + ".wat"
+		return os.WriteFile(wat, []byte(wasm.Text(mod)), 0o644)
+	}
+	if m.Func("main") == nil && !*emitC {
+		return fmt.Errorf("no function main is declared in %s", filename)
+	}
+	var src bytes.Buffer
+	if err := cgen.Generate(&src, m); err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = base
+		if *emitC {
+			*output += ".c"
+		}
+	}
+	if *emitC {
+		return os.WriteFile(*output, src.Bytes(), 0o644)
+	}
+	return cgen.Compile(*cc, m.Name, src.Bytes(), *output)
+}
+
+// lowerFile checks a module, lowers it to the IR with lower and optimizes
+// it.
+func lowerFile(filename string, lower func(*ast.Module, *check.Info) (*ir.Module, error)) (*ir.Module, error) {
+	module, info, err := loadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m, err := lower(module, info)
+	if err != nil {
+		return nil, err
+	}
+	if err := ir.Verify(m); err != nil {
+		return nil, err
+	}
+	if err := opt.NewManager().Run(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,110 @@
+package bytecode
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/internal/testutil"
+	"github.com/rowland/tuppence/tup/interp"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+)
+
+// The benchmarks run the main function of a program on the interpreter and
+// on the VM, discarding what it prints: examples/fib.tup, and
+// testdata/list.tup, the operations of lib/list.tup specialized to lists
+// of Int and written in the part of the language the compiler supports.
+var benchmarks = map[string]string{
+	"Fib":  filepath.Join("..", "..", "examples", "fib.tup"),
+	"List": filepath.Join("testdata", "list.tup"),
+}
+
+func BenchmarkFib(b *testing.B) {
+	benchmark(b, benchmarks["Fib"])
+}
+
+func BenchmarkList(b *testing.B) {
+	benchmark(b, benchmarks["List"])
+}
+
+// TestBenchmarks checks that the programs benchmarked print the same on
+// the VM as on the interpreter.
+func TestBenchmarks(t *testing.T) {
+	for name, filename := range benchmarks {
+		t.Run(name, func(t *testing.T) {
+			contents := readFile(t, filename)
+			info := checkProgram(t, filename, contents)
+			var want strings.Builder
+			in := interp.New(info)
+			in.Stdout = &want
+			if _, err := in.Run("main"); err != nil {
+				t.Fatalf("interp: %v", err)
+			}
+			m, err := testutil.Lower(t, filename, string(contents))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := run(t, compile(t, m))
+			if err != nil {
+				t.Fatalf("vm: %v", err)
+			}
+			if got != want.String() {
+				t.Errorf("vm wrote:\n%s\ninterp wrote:\n%s", got, want.String())
+			}
+		})
+	}
+}
+
+func readFile(t testing.TB, filename string) []byte {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func checkProgram(t testing.TB, filename string, contents []byte) *check.Info {
+	module, err := parse.Module(source.NewSource(contents, filename), ast.NewModule(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := check.Module(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func benchmark(b *testing.B, filename string) {
+	contents := readFile(b, filename)
+	b.Run("interp", func(b *testing.B) {
+		in := interp.New(checkProgram(b, filename, contents))
+		in.Stdout = io.Discard
+		for b.Loop() {
+			if _, err := in.Run("main"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("vm", func(b *testing.B) {
+		m, err := testutil.Lower(b, filename, string(contents))
+		if err != nil {
+			b.Fatal(err)
+		}
+		vm, err := New(compile(b, m))
+		if err != nil {
+			b.Fatal(err)
+		}
+		vm.Stdout = io.Discard
+		for b.Loop() {
+			if err := vm.Run(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
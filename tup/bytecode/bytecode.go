@@ -0,0 +1,290 @@
+// Package bytecode compiles modules of the IR into a compact bytecode, and
+// runs it on a stack machine.
+//
+// A Program holds a compiled module: its type table, constant pool and
+// function table. Types are named by tags, as IMPLEMENTATION.md describes:
+// the index of the type's descriptor in the table shifted left one bit,
+// with the low-order bit set for types whose values are references. The
+// table begins with the predeclared error type, whose values, the errors
+// of checked arithmetic, are held as their messages. A constant is a value
+// of a type of the table; the function table holds the functions of the
+// module, with their code, and the host functions they call, which have
+// none.
+//
+// The code of a function is a sequence of instructions, each an opcode
+// byte followed by its operands: unsigned LEB128 numbers, but for jump
+// targets, which are 32-bit little-endian offsets into the code. The
+// instructions take their operands from the stack and push their results
+// onto it. Each value of the IR function is held in a local, the
+// parameters first, and each phi also in a copy, assigned on the edges into
+// its block so that the phis of a block are assigned all at once. A value
+// is moved out of its local at its last use, so that the machine, which
+// counts the references to the values it holds by reference, may update
+// or append to a value no longer referenced elsewhere in place. A line
+// table maps offsets in the code to the lines of the source they were
+// compiled from, which runtime errors report.
+//
+// Encode and Decode convert programs to and from the .tupc file format,
+// Disasm writes them as text, and a VM runs them.
+package bytecode
+
+import (
+	"strconv"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Program is a compiled module.
+type Program struct {
+	Name string
+	// File is the source file the module was compiled from, if known.
+	File string
+	// Types holds the descriptors of the types the program uses, indexed
+	// by tag. The descriptors of a decoded program have no Type.
+	Types  []*types.Descriptor
+	Consts []Const
+	Funcs  []*Func
+}
+
+// Func returns the index of the function named name, or -1.
+func (p *Program) Func(name string) int {
+	for i, fn := range p.Funcs {
+		if fn.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Const is a constant of a program.
+type Const struct {
+	Type  types.Tag
+	Value Value
+}
+
+// Func is a function of a program.
+type Func struct {
+	Name string
+	// Type is the tag of the function's signature, from which Params and
+	// Result are taken.
+	Type   types.Tag
+	Params int
+	Result bool
+	// Host is set for the host functions the program calls, which have no
+	// code.
+	Host   bool
+	Export bool
+	// Locals is the number of locals of the function, its parameters
+	// included, and MaxStack the greatest number of values its code keeps
+	// on the stack.
+	Locals   int
+	MaxStack int
+	Code     []byte
+	// Lines holds the line table of the function, in order of offset.
+	Lines []Line
+}
+
+// Line records that the code from offset PC on was compiled from line Line
+// of the source, until the next entry of the line table.
+type Line struct {
+	PC   int
+	Line int
+}
+
+// Line returns the line of the source the code at offset pc was compiled
+// from, or 0 if it is not known.
+func (fn *Func) Line(pc int) int {
+	line := 0
+	for _, l := range fn.Lines {
+		if l.PC > pc {
+			break
+		}
+		line = l.Line
+	}
+	return line
+}
+
+// Op is the opcode of an instruction. The operands of each are given in
+// its comment: k is the index of a constant, f of a function, l of a
+// local and t the tag of a type; n and i are numbers and a is the offset
+// of an instruction.
+type Op byte
+
+const (
+	OpInvalid Op = iota
+
+	// values
+	OpConst      // k: push constant k
+	OpZero       // push an unspecified value
+	OpFunc       // f: push function f
+	OpClosure    // f t n: pop n values, push a closure of type t of function f over them
+	OpRecClosure // f t n: pop n values, push a closure of type t of function f over them and itself
+	OpLoad       // l: push local l
+	OpMove       // l: push local l, leaving it unspecified, at its last use
+	OpStore      // l: pop into local l
+	OpPop        // pop a value
+
+	// arithmetic on values of type t, popping y, then x, and pushing the
+	// result; integer overflow and division by zero trap. add concatenates
+	// strings; and, or and xor apply to Bool as well as integers.
+	OpAdd // t
+	OpSub // t
+	OpMul // t
+	OpDiv // t
+	OpMod // t
+	OpPow // t
+	OpAnd // t
+	OpOr  // t
+	OpXor // t
+	OpShl // t
+	OpShr // t
+	OpNeg // t: pop x, push -x
+	OpNot // t: pop x, push !x for Bool, ~x for integers
+
+	// checked arithmetic on values of type t, pushing the result and
+	// whether it is valid in place of trapping
+	OpAddChecked // t
+	OpSubChecked // t
+	OpMulChecked // t
+	OpDivChecked // t
+	OpModChecked // t
+
+	// comparisons of values of type t, popping y, then x; cmp pushes -1, 0
+	// or 1
+	OpEq  // t
+	OpNe  // t
+	OpLt  // t
+	OpLe  // t
+	OpGt  // t
+	OpGe  // t
+	OpCmp // t
+
+	// conversions
+	OpStr  // t: pop a value of type t, push its text as print writes it
+	OpConv // t u: pop an integer of type u, push it as a value of the integer type t; traps if it does not fit
+
+	// aggregates
+	OpTuple  // t n: pop n fields, push a tuple of type t of them
+	OpField  // i: pop a tuple, push its field i
+	OpUpdate // i: pop a value, then a tuple, push the tuple with its field i replaced by the value
+	OpArray  // t n: pop n elements, push an array of type t of them
+	OpIndex  // t: pop an index, then an array or string of type t, push the element or byte; traps when out of range
+	OpLen    // t: pop an array or string of type t, push its length
+	OpAppend // t: pop an element, then an array of type t, push the array with the element appended
+	OpSlice  // t u: pop the last and the first index, then an array or string of type t, push the slice of type u; traps when out of range
+
+	// unions
+	OpWrap    // t i: pop a value, push a union of type t holding it as member i
+	OpTag     // pop a union, push the index of the member it holds
+	OpPayload // i k: pop a union, push member i it holds; traps with the message constant k if it holds another
+
+	// calls
+	OpCall      // f: pop the arguments of function f, call it and push its result, if it has one
+	OpCallValue // n: pop a function, then n arguments, call it and push its result, if it has one
+
+	// control
+	OpJump    // a: continue at a
+	OpBrFalse // a: pop a Bool, continuing at a if it is false
+	OpRet     // pop the result, if the function has one, and return it
+	OpTrap    // k: stop the program with the message constant k
+)
+
+// operand is the kind of an operand of an instruction.
+type operand byte
+
+const (
+	constOperand operand = iota + 1
+	funcOperand
+	localOperand
+	typeOperand
+	numOperand
+	addrOperand
+)
+
+type opInfo struct {
+	name     string
+	operands []operand
+}
+
+var (
+	noOperands = []operand{}
+	typed      = []operand{typeOperand}
+	typedCount = []operand{typeOperand, numOperand}
+)
+
+var opInfos = [...]opInfo{
+	OpInvalid:    {"invalid", noOperands},
+	OpConst:      {"const", []operand{constOperand}},
+	OpZero:       {"zero", noOperands},
+	OpFunc:       {"func", []operand{funcOperand}},
+	OpClosure:    {"closure", []operand{funcOperand, typeOperand, numOperand}},
+	OpRecClosure: {"closure.rec", []operand{funcOperand, typeOperand, numOperand}},
+	OpLoad:       {"load", []operand{localOperand}},
+	OpMove:       {"move", []operand{localOperand}},
+	OpStore:      {"store", []operand{localOperand}},
+	OpPop:        {"pop", noOperands},
+	OpAdd:        {"add", typed},
+	OpSub:        {"sub", typed},
+	OpMul:        {"mul", typed},
+	OpDiv:        {"div", typed},
+	OpMod:        {"mod", typed},
+	OpPow:        {"pow", typed},
+	OpAnd:        {"and", typed},
+	OpOr:         {"or", typed},
+	OpXor:        {"xor", typed},
+	OpShl:        {"shl", typed},
+	OpShr:        {"shr", typed},
+	OpNeg:        {"neg", typed},
+	OpNot:        {"not", typed},
+	OpAddChecked: {"add.checked", typed},
+	OpSubChecked: {"sub.checked", typed},
+	OpMulChecked: {"mul.checked", typed},
+	OpDivChecked: {"div.checked", typed},
+	OpModChecked: {"mod.checked", typed},
+	OpEq:         {"eq", typed},
+	OpNe:         {"ne", typed},
+	OpLt:         {"lt", typed},
+	OpLe:         {"le", typed},
+	OpGt:         {"gt", typed},
+	OpGe:         {"ge", typed},
+	OpCmp:        {"cmp", typed},
+	OpStr:        {"str", typed},
+	OpConv:       {"conv", []operand{typeOperand, typeOperand}},
+	OpTuple:      {"tuple", typedCount},
+	OpField:      {"field", []operand{numOperand}},
+	OpUpdate:     {"update", []operand{numOperand}},
+	OpArray:      {"array", typedCount},
+	OpIndex:      {"index", typed},
+	OpLen:        {"len", typed},
+	OpAppend:     {"append", typed},
+	OpSlice:      {"slice", []operand{typeOperand, typeOperand}},
+	OpWrap:       {"wrap", typedCount},
+	OpTag:        {"tag", noOperands},
+	OpPayload:    {"payload", []operand{numOperand, constOperand}},
+	OpCall:       {"call", []operand{funcOperand}},
+	OpCallValue:  {"call.value", []operand{numOperand}},
+	OpJump:       {"jump", []operand{addrOperand}},
+	OpBrFalse:    {"br.false", []operand{addrOperand}},
+	OpRet:        {"ret", noOperands},
+	OpTrap:       {"trap", []operand{constOperand}},
+}
+
+func (op Op) String() string {
+	if int(op) < len(opInfos) {
+		return opInfos[op].name
+	}
+	return "op" + strconv.Itoa(int(op))
+}
+
+// valid reports whether op is a defined opcode.
+func (op Op) valid() bool {
+	return op > OpInvalid && int(op) < len(opInfos)
+}
+
+// Error reports a module that cannot be compiled, or a program file that
+// cannot be decoded.
+type Error struct {
+	Msg string
+}
+
+func (err *Error) Error() string { return "bytecode: " + err.Msg }
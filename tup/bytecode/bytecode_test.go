@@ -0,0 +1,471 @@
+package bytecode
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/internal/testutil"
+	"github.com/rowland/tuppence/tup/ir"
+)
+
+// compile compiles m and checks that the program reads back from its file
+// format unchanged.
+func compile(t testing.TB, m *ir.Module) *Program {
+	t.Helper()
+	p, err := Compile(m)
+	if err != nil {
+		t.Fatalf("Compile() = %v", err)
+	}
+	data := Encode(p)
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() = %v\n%s", err, Disasm(p))
+	}
+	if !bytes.Equal(Encode(decoded), data) {
+		t.Errorf("the decoded program encodes differently:\n%s", Disasm(p))
+	}
+	return decoded
+}
+
+// run runs the main function of p, returning what it printed, and checks
+// that every object the program allocated was freed.
+func run(t testing.TB, p *Program) (string, error) {
+	t.Helper()
+	vm, err := New(p)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	var out strings.Builder
+	vm.Stdout = &out
+	err = vm.Run()
+	if s := vm.Stats(); s.Live != 0 {
+		t.Errorf("%d objects live after running, want none: %v\n%s", s.Live, s, Disasm(p))
+	}
+	return out.String(), err
+}
+
+const errorDecls = "E1 = error(message: String)\n" +
+	"E2 = error(code: Int)\n" +
+	"classify = fn(n: Int) !Int {\n\tif n < 0 { E1(\"negative\") } else if n == 0 { E2(0) } else { n }\n}\n"
+
+var programs = []struct {
+	name  string
+	input string
+	want  string
+}{
+	{"print", "main = fx() { print(1, \"a\", [1, 2], (x: 1)) }", "1 a [1, 2] (x: 1)\n"},
+	{"print in loop", "main = fx() {\n\tfor i in 1..3 { print(i) }\n}", "1\n2\n3\n"},
+	{"interpolation", "main = fx() {\n\tname = \"World\"\n\tprint(\"Hello, \\(name)!\")\n}", "Hello, World!\n"},
+	{"floats", "f = fx(x: Float) Float { x }\nmain = fx() { print(f(0.1) + f(0.2), f(1.5) * f(2.0), f(1e6), f(1e-5), f(123456.5), f(-0.25), f(2.0) ^ f(10.0)) }",
+		"0.30000000000000004 3.0 1e+06 1e-05 123456.5 -0.25 1024.0\n"},
+	{"Float32", "f = fx(x: Float32) Float32 { x }\nmain = fx() { print(f(1.5) + f(2.25), f(0.5) / f(4.0)) }", "3.75 0.125\n"},
+	{"integer types", "f = fx(x: Int8) Int8 { x }\ng = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(f(0) - f(100) - f(28), f(100) / (f(0) - f(3)), f(7) % (f(0) - f(2)), g(4294967296) * g(4294967295)) }",
+		"-128 -33 1 18446744069414584320\n"},
+	{"unsigned", "f = fx(x: UInt8) UInt8 { x }\ng = fx(x: UInt32) UInt32 { x }\nmain = fx() { print(f(200) + f(55), ~f(1), g(4000000000) + g(1), g(3) < g(4000000000), ~g(0)) }",
+		"255 254 4000000001 true 4294967295\n"},
+	{"power and shifts", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(3) ^ f(4), f(1) << f(62), f(-16) >> f(2)) }", "81 4611686018427387904 -4\n"},
+	{"bitwise", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(12) & f(10), f(12) | f(10), ~f(0)) }", "8 14 -1\n"},
+	{"nested strings are quoted", "main = fx() { print([\"a\\tb\", \"\\\"q\\\"\"], (\"x\", 1), [\"é\\u00a0\\xff\"]) }", "[\"a\\tb\", \"\\\"q\\\"\"] (\"x\", 1) [\"é\\u00a0\\xff\"]\n"},
+	{"strings", "f = fx(s: String) String { s }\nmain = fx() {\n\ts = f(\"abc\")\n\tprint(s + \"def\", len(s), s[1], s < \"abd\", s == \"abc\")\n}",
+		"abcdef 3 98 true true\n"},
+	{"symbols and nil", "main = fx() { print(:ok, nil, true) }", ":ok nil true\n"},
+	{"recursion", "fact = fn(n: Int) Int { if n <= 1 { 1 } else { n * fact(n - 1) } }\nmain = fx() { print(fact(20)) }", "2432902008176640000\n"},
+	{"function value", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\ninc = fn(n: Int) Int { n + 1 }\nmain = fx() { print(apply(41, inc), inc) }", "42 inc\n"},
+	{"function equality", "mk = fn(k: Int) fn(Int) Int {\n\taddk = fn(n: Int) Int { n + k }\n\taddk\n}\n" +
+		"inc = fn(n: Int) Int { n + 1 }\ndec = fn(n: Int) Int { n - 1 }\nsame = fx(f: fn(Int) Int, g: fn(Int) Int) Bool { f == g }\n" +
+		"main = fx() {\n\ta = mk(1)\n\tprint(same(a, a), same(inc, inc), same(inc, dec), same(a, inc))\n}", "true true false false\n"},
+	{"array initializer", "f = fn(k: Int) [4]Int { [4]Int{ |i| i * k } }\nmain = fx() { print(f(3), [2]String{ \"s\\(it)\" }) }",
+		"[0, 3, 6, 9] [\"s0\", \"s1\"]\n"},
+	{"enum conversion", "Fruit = enum(\n\tapple = 1\n\tbanana = 2\n\tcherry = 5\n)\nf = fx(n: Int) Int { n }\n" +
+		"main = fx() { print(Fruit(f(1)), Fruit(f(5)), Fruit(f(3))) }", "Fruit.apple Fruit.cherry nil\n"},
+	{"typeof", "Circle = type(r: Int)\nSquare = type(s: Int)\nShape = Circle | Square\nf = fx(s: Shape) Shape { s }\n" +
+		"is_circle = fn(s: Shape) Bool { typeof(s) == typeof(Circle) }\n" +
+		"main = fx() { print(is_circle(f(Circle(1))), is_circle(f(Square(2))), typeof(f(Square(2))), (typeof(Circle), 1)) }",
+		"true false typeof(Square) (typeof(Circle), 1)\n"},
+	{"slices", "f = fx(xs: []Int) []Int { xs }\ng = fx(s: String) String { s }\n" +
+		"main = fx() {\n\txs = f([1, 2, 3, 4])\n\tys = xs[1..2]\n\tprint(ys, xs[2..1], g(\"hello\")[1..3], [2]String[\"a\", \"b\"][0..0], ys << 5, xs)\n}",
+		"[2, 3] [] ell [\"a\"] [2, 3, 5] [1, 2, 3, 4]\n"},
+	{"closures", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\nadd = fn(a: Int, b: Int) Int { a + b }\n" +
+		"f = fn(k: Int) Int { apply(3) { apply(it) { |m| m * k + it } } }\n" +
+		"g = fn(k: Int) fn(Int) Int { add(k, *) }\n" +
+		"h = fn(k: Int) Int {\n\tsum = fn(n: Int) Int { if n == 0 { k } else { sum(n - 1) + n } }\n\tsum(4)\n}\n" +
+		"main = fx() {\n\tinc = g(1)\n\tprint(f(2), inc(41), h(10), inc, apply(1) { it + 1 })\n}",
+		"9 42 20 fn { ... } 2\n"},
+	{"closures in a list", "Cons = type(head: fn(Int) Int, tail: List)\n" +
+		"List = Nil | Cons\n" +
+		"add = fn(a: Int, b: Int) Int { a + b }\n" +
+		"empty = fn() List { nil }\n" +
+		"adders = fn(n: Int) List {\n" +
+		"\tfor i, acc = (0, empty()); i < n {\n" +
+		"\t\tnext = (i + 1, Cons(head: add(i, *), tail: acc))\n" +
+		"\t\tnext\n" +
+		"\t}.1\n" +
+		"}\n" +
+		"sum = fn(l: List, x: Int) Int {\n" +
+		"\tfor acc, current = (0, l); current != nil {\n" +
+		"\t\tswitch current {\n" +
+		"\t\t\tCons { |c|\n" +
+		"\t\t\t\t(acc + c.head(x), c.tail)\n" +
+		"\t\t\t}\n" +
+		"\t\t\tNil { (acc, current) }\n" +
+		"\t\t}\n" +
+		"\t}.0\n" +
+		"}\n" +
+		"main = fx() { print(sum(adders(4), 10)) }",
+		"46\n"},
+	{"labeled tuple", "Point = type(x: Int, y: Int)\nmove = fn(p: Point, dx: Int) Point { p.(x: p.x + dx) }\nmain = fx() { print(move(Point(1, 2), 3)) }", "(x: 4, y: 2)\n"},
+	{"tuple update", "Point = type(x: Int, y: Int)\nmain = fx() {\n\tp = Point(1, 2)\n\tq = p.(y: 5)\n\tprint(p)\n\tprint(q)\n}", "(x: 1, y: 2)\n(x: 1, y: 5)\n"},
+	{"tuple equality", "Point = type(x: Int, y: Int)\nf = fx(p: Point) Point { p }\nmain = fx() { print(f(Point(1, 2)) == Point(1, 2), f(Point(1, 2)) != Point(2, 1)) }", "true true\n"},
+	{"arrays", "f = fx(xs: []Int) []Int { xs }\nmain = fx() {\n\txs = f([1, 2, 3])\n\tys = xs << 4\n\tzs = xs << 5\n\tprint(ys, zs, len(ys), xs[2], xs == [1, 2, 3])\n}",
+		"[1, 2, 3, 4] [1, 2, 3, 5] 4 3 true\n"},
+	{"appends share", "f = fx(xs: []Int) []Int { xs }\nmain = fx() {\n\tys = f([1]) << 2\n\ta = ys << 3\n\tb = ys << 4\n\tprint(a, b, a << 5, ys)\n}",
+		"[1, 2, 3] [1, 2, 4] [1, 2, 3, 5] [1, 2]\n"},
+	{"arrays of tuples", "P = type(x: Int8, s: String)\nf = fx(ps: []P) []P { ps }\nmain = fx() {\n\tps = f([P(1, \"a\")]) << P(2, \"b\")\n\tprint(ps, ps[1].s, ps == [P(1, \"a\"), P(2, \"b\")])\n}",
+		"[(x: 1, s: \"a\"), (x: 2, s: \"b\")] b true\n"},
+	{"enum", "Color = enum(\n\tred\n\tgreen\n\tblue\n)\nf = fx(c: Color) Color { c }\nmain = fx() { print(f(Color.green), f(Color.blue).int(), f(Color.red).string()) }",
+		"Color.green 2 red\n"},
+	{"checked arithmetic", "f = fx(x: Int8) Int8 { x }\nmain = fx() { print(f(100) ?+ f(27), f(100) ?+ f(100), f(1) ?/ f(0)) }",
+		"127 error(\"integer overflow\") error(\"division by zero\")\n"},
+	{"integer conversions", "f = fx(x: Int) Int { x }\ng = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(Int8(f(-5)), UInt8(f(200)), Int(g(7)), UInt64(f(9)), Int16(Int8(f(-3))), UInt64(~g(0))) }",
+		"-5 200 7 9 -3 18446744073709551615\n"},
+	{"errors", errorDecls + "main = fx() { print(classify(-1), classify(0), classify(3)) }", "(message: \"negative\") (code: 0) 3\n"},
+	{"try", errorDecls + "f = fn(n: Int) !Int {\n\tv = try classify(n)\n\tv + 1\n}\nmain = fx() { print(f(41), f(-1)) }", "42 (message: \"negative\")\n"},
+	{"switch on array", "sum = fn(xs: []Int) Int { switch xs { [] { 0 } [_, ...] { |head, ...tail| head + sum(tail) } } }\n" +
+		"main = fx() { print(sum([1, 2, 3]), sum(Int[])) }", "6 0\n"},
+	{"switch on union", errorDecls + "f = fn(n: Int) String {\n\tv = classify(n)\n\tswitch v {\n\t\tE1 { \"E1\" }\n\t\tE2 { \"E2\" }\n\t\tInt { \"Int\" }\n\t}\n}\nmain = fx() { print(f(-1), f(0), f(1)) }",
+		"E1 E2 Int\n"},
+	{"union with nil", "Result = String | Nil\nf = fx(n: Int) Result { switch n { 1 { \"one\" } } }\nmain = fx() { print(f(1), f(2), f(2) == f(3)) }",
+		"one nil true\n"},
+	{"loop", "sum = fn(ns: ...Int) Int { for s = 0; n in ns { s + n } }\nmain = fx() { print(sum(1, 2, 3, 4)) }", "10\n"},
+	{"nested loops", "f = fn(n: Int) Int {\n\tfor s = 0; i in 0..n {\n\t\ts + (for t = 0; j in 0..i { if j % 2 == 0 { t + j } else { t } })\n\t}\n}\nmain = fx() { print(f(5)) }", "16\n"},
+	{"fib", "fib = fn(n: Int) []Int {\n\tfor (a, b, acc) = (0, 1, Int[]); len(acc) < n {\n\t\t(b, a + b, acc << a)\n\t}.2\n}\nmain = fx() { print(fib(12)) }",
+		"[0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89]\n"},
+	{"inline for", "ABC = type(a: Int, b: String, c: Float)\nshow = fn(abc: ABC) String {\n\tinline for acc = \"\"; name, value in abc {\n\t\tswitch name {\n\t\t\t:a { acc + \"a=\\(value) \" }\n\t\t\t:b { acc + \"b=\\(value) \" }\n\t\t\t:c { acc + \"c=\\(value)\" }\n\t\t}\n\t}\n}\nmain = fx() { print(show(ABC(1, \"Hello\", 5.5))) }",
+		"a=1 b=Hello c=5.5\n"},
+	{"phi swap", "f = fn(n: Int) Int {\n\tfor (a, b, i) = (0, 1, 0); i < n {\n\t\t(b, a, i + 1)\n\t}.0\n}\nmain = fx() { print(f(3), f(4)) }", "1 0\n"},
+	{"generics", "id[a]: fn(x: a) a { x }\npair[a, b]: fn(x: a, y: b) (a, b) { (x, y) }\nNumeric[a] = contract(\n\tmul[a] = fn(a, a) a\n)\nsqr[a]: fn(x: Numeric[a]) a { x * x }\nmain = fx() { print(id(1), id(\"s\"), pair(1, \"x\"), sqr(3), sqr(1.5)) }",
+		"1 s (1, \"x\") 9 2.25\n"},
+	{"generic list", "Cons[a] = type(head: a, tail: List[a])\nList[a] = Nil | Cons[a]\nprepend[a]: fn(list: List[a], head: a) List[a] { Cons(head: head, tail: list) }\nmap[a, b]: fn(list: List[a], f: fn(a) b) List[b] {\n\tswitch list {\n\t\tNil { nil }\n\t\tCons { |c| Cons(head: f(c.head), tail: map(c.tail, f)) }\n\t}\n}\nlength[a]: fn(list: List[a]) Int {\n\tswitch list {\n\t\tNil { 0 }\n\t\tCons { |c| 1 + length(c.tail) }\n\t}\n}\ndouble = fn(n: Int) Int { n * 2 }\nmain = fx() {\n\txs = map(prepend(prepend(nil, 2), 1), double)\n\tprint(xs, length(xs), length(prepend(nil, \"a\")))\n}",
+		"(head: 2, tail: (head: 4, tail: nil)) 2 1\n"},
+}
+
+// TestRun runs each program on the VM and checks what its main function
+// prints, which is what the interpreter prints.
+func TestRun(t *testing.T) {
+	for _, test := range programs {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := testutil.Lower(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			p := compile(t, m)
+			got, err := run(t, p)
+			if err != nil {
+				t.Fatalf("Run() = %v\n%s", err, Disasm(p))
+			}
+			if got != test.want {
+				t.Errorf("program wrote %q, want %q\n%s", got, test.want, Disasm(p))
+			}
+		})
+	}
+}
+
+// TestTraps checks that operations that trap stop the program with a
+// runtime error reporting the line of the operation.
+func TestTraps(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"overflow", "f = fx(x: Int8) Int8 { x }\nmain = fx() { print(f(100) + f(100)) }", "runtime error: integer overflow\n--> test.tup:2"},
+		{"negation overflow", "f = fx(x: Int) Int { x }\nmain = fx() { print(-(f(-9223372036854775807) - 1)) }", "runtime error: integer overflow"},
+		{"conversion overflow", "f = fx(x: Int) Int { x }\nmain = fx() { print(Int8(f(200))) }", "runtime error: integer overflow"},
+		{"negative to unsigned", "f = fx(x: Int) Int { x }\nmain = fx() { print(UInt(f(-1))) }", "runtime error: integer overflow"},
+		{"unsigned to signed", "g = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(Int(~g(0))) }", "runtime error: integer overflow"},
+		{"unsigned overflow", "f = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(f(1) - f(2)) }", "runtime error: integer overflow"},
+		{"division by zero", "f = fx(x: Int) Int { x }\nmain = fx() {\n\tprint(1)\n\tprint(f(1) / f(0))\n}", "runtime error: division by zero\n--> test.tup:4"},
+		{"index out of range", "f = fx(xs: []Int) []Int { xs }\nmain = fx() { print(f([1, 2])[2]) }", "runtime error: index 2 out of range [0:2]"},
+		{"slice out of range", "f = fx(xs: []Int) []Int { xs }\nmain = fx() { print(f([1, 2])[1..2]) }", "runtime error: slice [1..2] out of range [0:2]"},
+		{"float overflow", "f = fx(x: Float) Float { x }\nmain = fx() { print(f(1e308) * f(10.0)) }", "runtime error: floating-point overflow"},
+		{"nested call", "f = fx(x: Int) Int { x }\ng = fn(n: Int) Int {\n\tn / f(0)\n}\nmain = fx() { print(g(1)) }", "runtime error: division by zero\n--> test.tup:3"},
+		{"unbounded recursion", "f = fn(n: Int) Int { f(n + 1) + 1 }\nmain = fx() { print(f(0)) }", "runtime error: call stack exceeded 10000 nested calls"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := testutil.Lower(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			_, err = run(t, compile(t, m))
+			var rerr *RuntimeError
+			if !errors.As(err, &rerr) || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("Run() = %v, want runtime error %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestStats checks that loops updating a tuple or appending to an array
+// reuse its storage, in place or shared with the arrays appended to
+// before, rather than copying it.
+func TestStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		minReused int
+	}{
+		{"update", "Point = type(x: Int, y: Int)\nmain = fx() {\n\tp = for p = Point(0, 0); i in 1..100 { p.(x: p.x + i) }\n\tprint(p)\n}", 100},
+		{"append", "main = fx() {\n\txs = for xs = Int[]; i in 1..100 { xs << i }\n\tprint(len(xs))\n}", 99},
+		{"fib", "fib = fn(n: Int) []Int {\n\tfor (a, b, acc) = (0, 1, Int[]); len(acc) < n {\n\t\t(b, a + b, acc << a)\n\t}.2\n}\nmain = fx() { print(fib(12)) }", 11},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := testutil.Lower(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			p := compile(t, m)
+			vm, err := New(p)
+			if err != nil {
+				t.Fatalf("New() = %v", err)
+			}
+			vm.Stdout = &strings.Builder{}
+			if err := vm.Run(); err != nil {
+				t.Fatalf("Run() = %v", err)
+			}
+			if s := vm.Stats(); s.InPlace+s.Shared < test.minReused || s.Copies != 0 {
+				t.Errorf("Stats() = %v, want at least %d reused and no copies\n%s", s, test.minReused, Disasm(p))
+			}
+		})
+	}
+}
+
+// TestCycles checks that the closures of recursive local functions, which
+// capture themselves, are freed by the cycle collector.
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		collected int
+	}{
+		{"one", "h = fn(k: Int) Int {\n\tsum = fn(n: Int) Int { if n == 0 { k } else { sum(n - 1) + n } }\n\tsum(4)\n}\nmain = fx() { print(h(10)) }", 1},
+		{"returned", "mk = fn(k: Int) fn(Int) Int {\n\tsum = fn(n: Int) Int { if n == 0 { k } else { sum(n - 1) + n } }\n\tsum\n}\n" +
+			"main = fx() {\n\tt = for t = 0; i in 1..10 {\n\t\tf = mk(i)\n\t\tt + f(3)\n\t}\n\tprint(t)\n}", 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := testutil.Lower(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			p := compile(t, m)
+			vm, err := New(p)
+			if err != nil {
+				t.Fatalf("New() = %v", err)
+			}
+			vm.Stdout = &strings.Builder{}
+			if err := vm.Run(); err != nil {
+				t.Fatalf("Run() = %v", err)
+			}
+			if s := vm.Stats(); s.Collected != test.collected || s.Live != 0 {
+				t.Errorf("Stats() = %v, want %d collected and none live\n%s", s, test.collected, Disasm(p))
+			}
+		})
+	}
+}
+
+// TestCall checks calling exported functions with arguments.
+func TestCall(t *testing.T) {
+	m, err := testutil.Lower(t, "test.tup", "add: fn(x: Int, y: Int) Int { x + y }\nhalve: fn(x: Float) Float { x / 2.0 }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := New(compile(t, m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := vm.Call("add", Int(40), Int(2)); err != nil || v.Int() != 42 {
+		t.Errorf("Call(add, 40, 2) = %d, %v, want 42", v.Int(), err)
+	}
+	if v, err := vm.Call("halve", Float(3)); err != nil || v.Float() != 1.5 {
+		t.Errorf("Call(halve, 3.0) = %g, %v, want 1.5", v.Float(), err)
+	}
+	if _, err := vm.Call("add", Int(1)); err == nil {
+		t.Errorf("Call(add, 1) succeeded, want an error")
+	}
+	if _, err := vm.Call("sub"); err == nil {
+		t.Errorf("Call(sub) succeeded, want an error")
+	}
+}
+
+// TestDisasm checks the text of the code of programs.
+func TestDisasm(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string // substrings of the text
+	}{
+		{"print", "main = fx() { print(\"hi\") }",
+			[]string{"program test\n", "file test.tup\n", "host print fn(String)\n", "func main fn()\n", "const \"hi\"", "call print", "ret"}},
+		{"types", "Point: type(x: Int, y: Int)\nf: fn(p: Point) Int { p.x + p.y }",
+			[]string{" Point = (x: Int64, y: Int64)\n", "field 0", "add Int64", "export func f fn(p: Point) Int64\n", "locals 4, stack 2"}},
+		{"jumps", "f: fn(n: Int) Int { if n > 0 { n } else { -n } }",
+			[]string{"br.false ", "gt Int64", "neg Int64", "line 1"}},
+		{"checked", "f: fn(x: Int) Int { try x ?+ 1 }",
+			[]string{"add.checked Int64", "const error(\"integer overflow\")"}},
+		{"update", "Point: type(x: Int, y: Int)\nf: fn(p: Point) Point { p.(y: p.x) }",
+			[]string{"load 0\n", "move 0\n", "update 1\n"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := testutil.Lower(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			text := Disasm(compile(t, m))
+			for _, want := range test.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("text does not contain %q:\n%s", want, text)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"host function value", "module m\n\ndeclare fx @print(String)\n\nfx @f() fx(String) {\nb0:\n  %0 = func fx(String) @print\n  ret %0\n}\n",
+			"host function print cannot be used as a value"},
+		{"float mod", "module m\n\nfn @f(%x: Float, %y: Float) Float {\nb0:\n  %0 = mod Float %x, %y\n  ret %0\n}\n",
+			"mod of Float is not supported by the bytecode compiler"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ir.Parse(test.input)
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			_, err = Compile(m)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Compile() = %v, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	m, err := ir.Parse("module m\n\ndeclare fx @read() String\n\nfx @f() String {\nb0:\n  %0 = call fx String @read()\n  ret %0\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Compile(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(p); err == nil || !strings.Contains(err.Error(), "host function read is not provided by the VM") {
+		t.Errorf("New() = %v, want an error for read", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	m, err := testutil.Lower(t, "test.tup", "f: fn(n: Int) Int { if n > 0 { n } else { 0 } }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := compile(t, m)
+	data := Encode(p)
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"empty", nil, "not a program file"},
+		{"version", append([]byte("TUPC\x09"), data[5:]...), "unsupported version 9"},
+		{"truncated", data[:len(data)-3], "unexpected end of file"},
+		{"trailing", append(data[:len(data):len(data)], 0), "trailing data"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Decode(test.data)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Decode() = %v, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+	// a jump into the middle of an instruction
+	bad := *p
+	bad.Funcs = []*Func{{Name: "f", Type: p.Funcs[0].Type, Params: 1, Result: true, Locals: 1, MaxStack: 1,
+		Code: []byte{byte(OpJump), 1, 0, 0, 0}}}
+	if _, err := Decode(Encode(&bad)); err == nil || !strings.Contains(err.Error(), "jump to 1") {
+		t.Errorf("Decode() = %v, want an error for the jump", err)
+	}
+}
+
+// TestEncode checks that the parts of a program survive the file format.
+func TestEncode(t *testing.T) {
+	m, err := testutil.Lower(t, "test.tup", "Color: enum(\n\tred\n\tgreen\n)\nP: type(c: Color, x: Float32, s: String, b: Bool, u: UInt8)\n"+
+		"f: fn() P { P(Color.green, 1.5, \"s\", true, 200) }\nIS = Int | String\ng: fn(xs: [2]Int) IS { xs[1] }\nmain = fx() { print(f(), :sym, nil) }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Compile(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(Encode(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Consts, p.Consts) {
+		t.Errorf("decoded constants %v, want %v", decoded.Consts, p.Consts)
+	}
+	for i, fn := range decoded.Funcs {
+		want := *p.Funcs[i]
+		if !reflect.DeepEqual(*fn, want) {
+			t.Errorf("decoded function %v, want %v", *fn, want)
+		}
+	}
+	for i, d := range decoded.Types {
+		want := *p.Types[i]
+		want.Type = nil
+		if !reflect.DeepEqual(*d, want) {
+			t.Errorf("decoded type %+v, want %+v", *d, want)
+		}
+	}
+	if Disasm(decoded) != Disasm(p) {
+		t.Errorf("decoded program disassembles as:\n%s\nwant:\n%s", Disasm(decoded), Disasm(p))
+	}
+}
+
+// TestExamples compiles each example the IR can lower, and runs those
+// with a main function, comparing their output with the interpreter's
+// golden output in ../interp/testdata/<name>.out.
+func TestExamples(t *testing.T) {
+	testutil.Examples(t, testutil.Lower, func(t *testing.T, name string, m *ir.Module) {
+		p := compile(t, m)
+		if m.Func("main") == nil {
+			return
+		}
+		got, err := run(t, p)
+		if err != nil {
+			t.Fatalf("Run() = %v", err)
+		}
+		want, err := os.ReadFile(filepath.Join("..", "interp", "testdata", name+".out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s wrote:\n%s\nwant:\n%s", name, got, want)
+		}
+	})
+}
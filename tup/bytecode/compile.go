@@ -0,0 +1,592 @@
+package bytecode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// compileBailout is panicked with to abandon compilation after an error.
+type compileBailout struct{ err *Error }
+
+// Compile compiles m, which must have been verified, into a program.
+func Compile(m *ir.Module) (p *Program, err error) {
+	c := &compiler{
+		module: m,
+		prog:   &Program{Name: m.Name},
+		table:  types.NewTable(),
+		tags:   map[types.Type]types.Tag{},
+		consts: map[constKey]int{},
+		funcs:  map[string]int{},
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(compileBailout)
+			if !ok {
+				panic(r)
+			}
+			p, err = nil, b.err
+		}
+	}()
+	c.compile()
+	return c.prog, nil
+}
+
+type compiler struct {
+	module *ir.Module
+	prog   *Program
+	table  *types.Table
+	infos  []typeInfo
+	tags   map[types.Type]types.Tag
+	consts map[constKey]int
+	funcs  map[string]int
+}
+
+// constKey identifies a constant of the pool.
+type constKey struct {
+	typ types.Tag
+	n   uint64
+	s   string
+}
+
+func (c *compiler) errorf(format string, args ...any) {
+	panic(compileBailout{&Error{Msg: fmt.Sprintf(format, args...)}})
+}
+
+func (c *compiler) compile() {
+	// the error type comes first, so that the machine knows its values
+	c.tag(types.ErrorType)
+	for _, fn := range c.module.Funcs {
+		c.funcs[fn.Name] = len(c.prog.Funcs)
+		sig := c.tag(fn.Sig)
+		c.prog.Funcs = append(c.prog.Funcs, &Func{
+			Name:   fn.Name,
+			Type:   sig,
+			Params: len(fn.Sig.Params),
+			Result: fn.Sig.Result != nil,
+			Host:   fn.Extern(),
+			Export: fn.Export,
+		})
+	}
+	for _, fn := range c.module.Funcs {
+		if !fn.Extern() {
+			c.function(fn)
+		}
+	}
+	c.prog.Types = c.descriptors()
+}
+
+// tag returns the tag of t, adding it to the type table if need be.
+func (c *compiler) tag(t types.Type) types.Tag {
+	if tag, ok := c.tags[t]; ok {
+		return tag
+	}
+	tag := c.table.Add(t).Tag
+	c.tags[t] = tag
+	return tag
+}
+
+// descriptors returns the descriptors of the type table.
+func (c *compiler) descriptors() []*types.Descriptor {
+	descs := make([]*types.Descriptor, c.table.Len())
+	for i := range descs {
+		descs[i] = c.table.Descriptor(types.MakeTag(i, false))
+	}
+	return descs
+}
+
+// info returns the description of the type identified by tag, reporting
+// an error for types without a run-time form.
+func (c *compiler) info(t types.Type) (types.Tag, *typeInfo) {
+	tag := c.tag(t)
+	if tag.Index() >= len(c.infos) {
+		infos, err := resolve(c.descriptors())
+		if err != nil {
+			c.errorf("%v", err)
+		}
+		c.infos = infos
+	}
+	info := &c.infos[tag.Index()]
+	if info.kind == kindInvalid {
+		c.errorf("values of %s are not supported by the bytecode compiler", t)
+	}
+	return tag, info
+}
+
+// constant returns the index of the constant v of type t in the pool.
+func (c *compiler) constant(t types.Type, v Value) uint64 {
+	tag := c.tag(t)
+	key := constKey{typ: tag, n: v.N}
+	if s, ok := v.R.(string); ok {
+		key.s = s
+	}
+	if i, ok := c.consts[key]; ok {
+		return uint64(i)
+	}
+	c.consts[key] = len(c.prog.Consts)
+	c.prog.Consts = append(c.prog.Consts, Const{Type: tag, Value: v})
+	return uint64(len(c.prog.Consts) - 1)
+}
+
+// message returns the index of the String constant msg.
+func (c *compiler) message(msg string) uint64 {
+	return c.constant(types.Typ[types.String], String(msg))
+}
+
+// value returns the Value of the compile-time constant v of type t.
+func (c *compiler) value(t types.Type, v consteval.Value) Value {
+	_, info := c.info(t)
+	switch v := v.(type) {
+	case *consteval.Int:
+		switch info.kind {
+		case kindFloat:
+			f, _ := new(big.Float).SetInt(v.Val).Float64()
+			return c.float(info, f)
+		case kindUint:
+			return Value{N: v.Val.Uint64()}
+		}
+		return Int(v.Val.Int64())
+	case *consteval.Float:
+		return c.float(info, v.Val)
+	case consteval.Bool:
+		return Bool(bool(v))
+	case consteval.String:
+		return String(string(v))
+	case consteval.Symbol:
+		return String(string(v))
+	case consteval.Nil:
+		return Value{}
+	case *consteval.Enum:
+		return Int(v.Member.Value)
+	case *consteval.ErrorValue:
+		return String(v.Msg)
+	case *consteval.TypeValue:
+		return Value{N: uint64(c.tag(v.Typ))}
+	}
+	c.errorf("constant %s of %s is not supported by the bytecode compiler", v, t)
+	return Value{}
+}
+
+func (c *compiler) float(info *typeInfo, f float64) Value {
+	if info.bits == 32 {
+		f = float64(float32(f))
+	}
+	return Float(f)
+}
+
+// funcCompiler compiles the code of a function.
+type funcCompiler struct {
+	*compiler
+	ir     *ir.Func
+	fn     *Func
+	locals map[ir.Value]uint64
+	copies map[*ir.Instr]uint64
+	live   *liveness
+	// used holds the values the function uses, and uses the number of
+	// uses of each value in the block being compiled not yet compiled.
+	used  map[ir.Value]bool
+	uses  map[ir.Value]int
+	block *ir.Block
+	// starts holds the offsets of the blocks, and fixups the offsets of the
+	// jump targets to patch with them.
+	starts map[*ir.Block]int
+	fixups []fixup
+	depth  int
+	line   int
+}
+
+type fixup struct {
+	at    int
+	block *ir.Block
+}
+
+func (c *compiler) function(fn *ir.Func) {
+	f := &funcCompiler{
+		compiler: c,
+		ir:       fn,
+		fn:       c.prog.Funcs[c.funcs[fn.Name]],
+		locals:   map[ir.Value]uint64{},
+		copies:   map[*ir.Instr]uint64{},
+		starts:   map[*ir.Block]int{},
+		live:     live(fn),
+		used:     map[ir.Value]bool{},
+	}
+	for _, p := range fn.Params {
+		f.locals[p] = uint64(len(f.locals))
+	}
+	n := uint64(len(f.locals))
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Typ == nil {
+				continue
+			}
+			f.locals[instr] = n
+			n++
+			if instr.Op == ir.OpPhi {
+				f.copies[instr] = n
+				n++
+			}
+		}
+	}
+	f.fn.Locals = int(n)
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, arg := range instr.Args {
+				f.used[arg] = true
+			}
+		}
+	}
+	for i, b := range fn.Blocks {
+		var next *ir.Block
+		if i+1 < len(fn.Blocks) {
+			next = fn.Blocks[i+1]
+		}
+		f.starts[b] = len(f.fn.Code)
+		f.block, f.uses = b, map[ir.Value]int{}
+		for _, instr := range b.Instrs {
+			if instr.Op != ir.OpPhi {
+				for _, arg := range instr.Args {
+					f.uses[arg]++
+				}
+			}
+		}
+		for _, instr := range b.Instrs {
+			f.instr(instr, next)
+		}
+	}
+	for _, fix := range f.fixups {
+		binary.LittleEndian.PutUint32(f.fn.Code[fix.at:], uint32(f.starts[fix.block]))
+	}
+}
+
+// emit appends the instruction op with the given operands, which pops pop
+// values and pushes push.
+func (f *funcCompiler) emit(op Op, pop, push int, operands ...uint64) {
+	f.fn.Code = append(f.fn.Code, byte(op))
+	for _, x := range operands {
+		f.fn.Code = binary.AppendUvarint(f.fn.Code, x)
+	}
+	f.depth += push - pop
+	f.fn.MaxStack = max(f.fn.MaxStack, f.depth)
+}
+
+// jump appends the jump op to the block to.
+func (f *funcCompiler) jump(op Op, to *ir.Block, pop int) {
+	f.emit(op, pop, 0)
+	f.fixups = append(f.fixups, fixup{len(f.fn.Code), to})
+	f.fn.Code = binary.LittleEndian.AppendUint32(f.fn.Code, 0)
+}
+
+// load pushes v, moving it out of its local at its last use in the block
+// if it is not live at the end of the block.
+func (f *funcCompiler) load(v ir.Value) {
+	f.uses[v]--
+	f.push(v, f.uses[v] == 0 && !f.live.out[f.block][v])
+}
+
+// push pushes v, moving it out of its local if last is set.
+func (f *funcCompiler) push(v ir.Value, last bool) {
+	local, ok := f.locals[v]
+	if !ok {
+		f.errorf("unexpected value %s in %s", v, f.ir.Name)
+	}
+	if last {
+		f.emit(OpMove, 0, 1, local)
+	} else {
+		f.emit(OpLoad, 0, 1, local)
+	}
+}
+
+// store pops the value of instr into its local, or drops it if it is not
+// used.
+func (f *funcCompiler) store(instr *ir.Instr) {
+	if !f.used[instr] {
+		f.emit(OpPop, 1, 0)
+		return
+	}
+	f.emit(OpStore, 1, 0, f.locals[instr])
+}
+
+// at records that the code from here on was compiled from the line of
+// instr.
+func (f *funcCompiler) at(instr *ir.Instr) {
+	if instr.Pos.Line == 0 || instr.Pos.Line == f.line {
+		return
+	}
+	if f.prog.File == "" {
+		f.prog.File = instr.Pos.Filename
+	}
+	f.line = instr.Pos.Line
+	pc := len(f.fn.Code)
+	if n := len(f.fn.Lines); n > 0 && f.fn.Lines[n-1].PC == pc {
+		f.fn.Lines[n-1].Line = f.line
+		return
+	}
+	f.fn.Lines = append(f.fn.Lines, Line{PC: pc, Line: f.line})
+}
+
+// typed returns the tag of t for an instruction operating on it.
+func (f *funcCompiler) typed(t types.Type) uint64 {
+	tag, _ := f.info(t)
+	return uint64(tag)
+}
+
+var (
+	binaryOps = map[ir.Op]Op{
+		ir.OpAdd: OpAdd, ir.OpSub: OpSub, ir.OpMul: OpMul, ir.OpDiv: OpDiv, ir.OpMod: OpMod, ir.OpPow: OpPow,
+		ir.OpAnd: OpAnd, ir.OpOr: OpOr, ir.OpXor: OpXor, ir.OpShl: OpShl, ir.OpShr: OpShr,
+	}
+	checkedOps = map[ir.Op]Op{
+		ir.OpCheckedAdd: OpAddChecked, ir.OpCheckedSub: OpSubChecked, ir.OpCheckedMul: OpMulChecked,
+		ir.OpCheckedDiv: OpDivChecked, ir.OpCheckedMod: OpModChecked,
+	}
+	comparisonOps = map[ir.Op]Op{
+		ir.OpEq: OpEq, ir.OpNe: OpNe, ir.OpLt: OpLt, ir.OpLe: OpLe, ir.OpGt: OpGt, ir.OpGe: OpGe, ir.OpCmp: OpCmp,
+	}
+)
+
+// instr compiles instr, which is followed by the block next, or nil.
+func (f *funcCompiler) instr(instr *ir.Instr, next *ir.Block) {
+	f.at(instr)
+	args := func() {
+		for _, arg := range instr.Args {
+			f.load(arg)
+		}
+	}
+	n := len(instr.Args)
+	switch op := instr.Op; {
+	case op == ir.OpPhi:
+		f.emit(OpMove, 0, 1, f.copies[instr])
+	case op == ir.OpConst:
+		f.emit(OpConst, 0, 1, f.constant(instr.Typ, f.value(instr.Typ, instr.Const)))
+	case op == ir.OpFunc:
+		index, ok := f.funcs[instr.Callee]
+		if !ok {
+			f.errorf("undefined function %s", instr.Callee)
+		}
+		if f.prog.Funcs[index].Host {
+			f.errorf("host function %s cannot be used as a value", instr.Callee)
+		}
+		if n == 0 {
+			f.emit(OpFunc, 0, 1, uint64(index))
+			break
+		}
+		args()
+		op := OpClosure
+		if instr.Rec {
+			op = OpRecClosure
+		}
+		f.emit(op, n, 1, uint64(index), f.typed(instr.Typ), uint64(n))
+	case op == ir.OpUndef:
+		f.emit(OpZero, 0, 1)
+	case op.IsBinary():
+		f.arith(instr)
+		args()
+		f.emit(binaryOps[op], 2, 1, f.typed(instr.Typ))
+	case op == ir.OpNeg || op == ir.OpNot:
+		if _, info := f.info(instr.Typ); info.kind != kindInt && info.kind != kindUint &&
+			!(op == ir.OpNeg && info.kind == kindFloat) && !(op == ir.OpNot && info.kind == kindBool) {
+			f.errorf("%s of %s is not supported by the bytecode compiler", op, instr.Typ)
+		}
+		args()
+		f.emit(map[ir.Op]Op{ir.OpNeg: OpNeg, ir.OpNot: OpNot}[op], 1, 1, f.typed(instr.Typ))
+	case op.IsComparison():
+		args()
+		f.emit(comparisonOps[op], 2, 1, f.typed(instr.Args[0].Type()))
+	case op == ir.OpStr:
+		args()
+		f.emit(OpStr, 1, 1, f.typed(instr.Args[0].Type()))
+	case op == ir.OpOrd:
+		// enums are held as their values
+		args()
+	case op == ir.OpConv:
+		args()
+		f.emit(OpConv, 1, 1, f.typed(instr.Typ), f.typed(instr.Args[0].Type()))
+
+	case op == ir.OpTuple:
+		args()
+		f.emit(OpTuple, n, 1, f.typed(instr.Typ), uint64(n))
+	case op == ir.OpField:
+		args()
+		f.emit(OpField, 1, 1, uint64(instr.Index))
+	case op == ir.OpUpdate:
+		args()
+		f.emit(OpUpdate, 2, 1, uint64(instr.Index))
+	case op == ir.OpArray:
+		args()
+		f.emit(OpArray, n, 1, f.typed(instr.Typ), uint64(n))
+	case op == ir.OpIndex:
+		args()
+		f.emit(OpIndex, 2, 1, f.typed(instr.Args[0].Type()))
+	case op == ir.OpLen:
+		args()
+		f.emit(OpLen, 1, 1, f.typed(instr.Args[0].Type()))
+	case op == ir.OpAppend:
+		args()
+		f.emit(OpAppend, 2, 1, f.typed(instr.Typ))
+	case op == ir.OpSlice:
+		args()
+		f.emit(OpSlice, 3, 1, f.typed(instr.Args[0].Type()), f.typed(instr.Typ))
+
+	case op == ir.OpWrap:
+		args()
+		f.emit(OpWrap, 1, 1, f.typed(instr.Typ), uint64(instr.Index))
+	case op == ir.OpTag:
+		args()
+		f.emit(OpTag, 1, 1)
+	case op == ir.OpPayload:
+		union := instr.Args[0].Type()
+		member := union.Underlying().(*types.Union).Members[instr.Index]
+		args()
+		f.emit(OpPayload, 1, 1, uint64(instr.Index), f.message(union.String()+" does not hold "+member.String()))
+
+	case op == ir.OpCall:
+		f.call(instr)
+		return
+
+	case op == ir.OpJump:
+		f.edge(instr.Block, instr.Blocks[0], next)
+		return
+	case op == ir.OpBr:
+		args()
+		f.branch(instr, 1, nil, next)
+		return
+	case op.IsChecked():
+		if _, info := f.info(instr.Typ); !(info.kind == kindInt || info.kind == kindUint || info.kind == kindFloat && op != ir.OpCheckedMod) {
+			f.errorf("%s of %s is not supported by the bytecode compiler", op, instr.Typ)
+		}
+		args()
+		f.emit(checkedOps[op], 2, 2, f.typed(instr.Typ))
+		f.branch(instr, 2, func() { f.store(instr) }, next)
+		return
+	case op == ir.OpRet:
+		args()
+		f.emit(OpRet, n, 0)
+		return
+	case op == ir.OpTrap:
+		f.emit(OpTrap, 0, 0, f.message(instr.Msg))
+		return
+	default:
+		f.errorf("%s is not supported by the bytecode compiler", op)
+	}
+	f.store(instr)
+}
+
+// arith reports an error for the arithmetic instr if the machine does not
+// perform it.
+func (f *funcCompiler) arith(instr *ir.Instr) {
+	_, info := f.info(instr.Typ)
+	switch op := instr.Op; {
+	case info.kind == kindInt || info.kind == kindUint:
+		return
+	case info.kind == kindBool && (op == ir.OpAnd || op == ir.OpOr || op == ir.OpXor):
+		return
+	case info.kind == kindString && op == ir.OpAdd:
+		return
+	case info.kind == kindFloat && op != ir.OpMod && op <= ir.OpPow:
+		return
+	}
+	f.errorf("%s of %s is not supported by the bytecode compiler", instr.Op, instr.Typ)
+}
+
+// call compiles the call instr.
+func (f *funcCompiler) call(instr *ir.Instr) {
+	args := instr.Args
+	var result bool
+	if instr.Callee == "" {
+		sig := args[0].Type().Underlying().(*types.Function)
+		for _, arg := range args[1:] {
+			f.load(arg)
+		}
+		f.load(args[0])
+		result = sig.Result != nil
+		push := 0
+		if result {
+			push = 1
+		}
+		f.emit(OpCallValue, len(args), push, uint64(len(args)-1))
+	} else {
+		index, ok := f.funcs[instr.Callee]
+		if !ok {
+			f.errorf("undefined function %s", instr.Callee)
+		}
+		for _, arg := range args {
+			f.load(arg)
+		}
+		result = f.prog.Funcs[index].Result
+		push := 0
+		if result {
+			push = 1
+		}
+		f.emit(OpCall, len(args), push, uint64(index))
+	}
+	switch {
+	case instr.Typ != nil:
+		f.store(instr)
+	case result:
+		f.emit(OpPop, 1, 0)
+	}
+}
+
+// branch compiles the end of the block of the terminator instr, which
+// continues with its first successor if the Bool on top of the n values on
+// the stack is true and with its second otherwise. then takes the values
+// below the Bool from the stack on the way to the first successor; they are
+// dropped on the way to the second.
+func (f *funcCompiler) branch(instr *ir.Instr, n int, then func(), next *ir.Block) {
+	from, to, els := instr.Block, instr.Blocks[0], instr.Blocks[1]
+	rest := n - 1
+	if rest == 0 && len(f.copiesOn(from, els)) == 0 {
+		f.jump(OpBrFalse, els, 1)
+		f.edge(from, to, next)
+		return
+	}
+	f.emit(OpBrFalse, 1, 0)
+	at := len(f.fn.Code)
+	f.fn.Code = binary.LittleEndian.AppendUint32(f.fn.Code, 0)
+	depth := f.depth
+	if then != nil {
+		then()
+	}
+	f.edge(from, to, nil)
+	binary.LittleEndian.PutUint32(f.fn.Code[at:], uint32(len(f.fn.Code)))
+	f.depth = depth
+	for range rest {
+		f.emit(OpPop, 1, 0)
+	}
+	f.edge(from, els, next)
+}
+
+// copiesOn returns the phis of to with their values flowing in from from.
+func (f *funcCompiler) copiesOn(from, to *ir.Block) (copies [][2]ir.Value) {
+	for _, phi := range to.Phis() {
+		for i, pred := range phi.Blocks {
+			if pred == from {
+				copies = append(copies, [2]ir.Value{phi, phi.Args[i]})
+			}
+		}
+	}
+	return copies
+}
+
+// edge assigns the copies of the phis of to the values flowing in from
+// from, and jumps to to unless it is next. A value not live in to is moved
+// by its last copy.
+func (f *funcCompiler) edge(from, to, next *ir.Block) {
+	copies := f.copiesOn(from, to)
+	for i, c := range copies {
+		v := c[1]
+		last := f.uses[v] <= 0 && !f.live.in[to][v] &&
+			!slices.ContainsFunc(copies[i+1:], func(c [2]ir.Value) bool { return c[1] == v })
+		f.push(v, last)
+		f.emit(OpStore, 1, 0, f.copies[c[0].(*ir.Instr)])
+	}
+	if to != next {
+		f.jump(OpJump, to, 0)
+	}
+}
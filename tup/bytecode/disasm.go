@@ -0,0 +1,117 @@
+package bytecode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Disasm returns the text of p: its type table, with the types of values
+// held by reference marked ref, its constant pool and its functions, each
+// instruction with its offset and its operands written as the constants,
+// functions and types they name. The lines of the source code was compiled
+// from are written before the instructions they begin with.
+func Disasm(p *Program) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "program %s\n", p.Name)
+	if p.File != "" {
+		fmt.Fprintf(&b, "file %s\n", p.File)
+	}
+	typ := func(tag types.Tag) string { return typeString(p.Types, tag) }
+	b.WriteString("\ntypes\n")
+	for _, d := range p.Types {
+		fmt.Fprintf(&b, "  t%d %s", d.Tag.Index(), typ(d.Tag))
+		if d.Kind == types.NamedDescriptor {
+			fmt.Fprintf(&b, " = %s", typ(d.Underlying))
+		}
+		if d.Tag.IsRef() {
+			b.WriteString(" ref")
+		}
+		b.WriteString("\n")
+	}
+	// constants are written as print writes their values, if the types of
+	// the program can be resolved
+	infos, err := resolve(p.Types)
+	vm := &VM{prog: p, infos: infos}
+	konst := func(k uint64) string {
+		if k >= uint64(len(p.Consts)) {
+			return "k" + strconv.FormatUint(k, 10)
+		}
+		c := p.Consts[k]
+		if err != nil {
+			return fmt.Sprintf("%s %d", typ(c.Type), c.Value.N)
+		}
+		return vm.text(c.Type, c.Value, true)
+	}
+	if len(p.Consts) > 0 {
+		b.WriteString("\nconsts\n")
+		for i, c := range p.Consts {
+			fmt.Fprintf(&b, "  k%d %s %s\n", i, typ(c.Type), konst(uint64(i)))
+		}
+	}
+	for _, fn := range p.Funcs {
+		b.WriteString("\n")
+		switch {
+		case fn.Host:
+			b.WriteString("host ")
+		case fn.Export:
+			b.WriteString("export func ")
+		default:
+			b.WriteString("func ")
+		}
+		fmt.Fprintf(&b, "%s %s\n", fn.Name, typ(fn.Type))
+		if fn.Host {
+			continue
+		}
+		fmt.Fprintf(&b, "  locals %d, stack %d\n", fn.Locals, fn.MaxStack)
+		lines := fn.Lines
+		for pc := 0; pc < len(fn.Code); {
+			if len(lines) > 0 && lines[0].PC <= pc {
+				fmt.Fprintf(&b, "  line %d\n", lines[0].Line)
+				lines = lines[1:]
+			}
+			op := Op(fn.Code[pc])
+			fmt.Fprintf(&b, "  %5d  %s", pc, op)
+			pc++
+			if !op.valid() {
+				b.WriteString("\n")
+				continue
+			}
+			for _, kind := range opInfos[op].operands {
+				if kind == addrOperand {
+					if pc+4 > len(fn.Code) {
+						break
+					}
+					fmt.Fprintf(&b, " %d", binary.LittleEndian.Uint32(fn.Code[pc:]))
+					pc += 4
+					continue
+				}
+				x, n := binary.Uvarint(fn.Code[pc:])
+				if n <= 0 {
+					pc = len(fn.Code)
+					break
+				}
+				pc += n
+				switch kind {
+				case constOperand:
+					b.WriteString(" " + konst(x))
+				case funcOperand:
+					if x < uint64(len(p.Funcs)) {
+						b.WriteString(" " + p.Funcs[x].Name)
+					} else {
+						fmt.Fprintf(&b, " f%d", x)
+					}
+				case typeOperand:
+					b.WriteString(" " + typ(types.Tag(x)))
+				default:
+					fmt.Fprintf(&b, " %d", x)
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
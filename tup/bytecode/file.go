@@ -0,0 +1,416 @@
+package bytecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// magic and version begin every program file.
+var magic = []byte("TUPC")
+
+const version = 5
+
+// The flags of a function.
+const (
+	flagHost   = 1
+	flagExport = 2
+)
+
+// Encode returns p in the .tupc file format: the magic number and version,
+// then the name of the program and of its source file, its type table, its
+// constant pool and its function table. Numbers are LEB128, signed for the
+// values of signed integers and enums, and floats are 8 bytes little-
+// endian. The constants are written in the forms of their types. Functions
+// are written with their signatures, from which their parameters and
+// results are taken when they are read, and their line tables as the
+// differences between consecutive entries.
+func Encode(p *Program) []byte {
+	var e encoder
+	e.buf = append(e.buf, magic...)
+	e.buf = append(e.buf, version)
+	e.string(p.Name)
+	e.string(p.File)
+	e.uint(uint64(len(p.Types)))
+	for _, d := range p.Types {
+		e.descriptor(d)
+	}
+	infos, _ := resolve(p.Types)
+	e.uint(uint64(len(p.Consts)))
+	for _, c := range p.Consts {
+		e.uint(uint64(c.Type))
+		switch infos[c.Type.Index()].kind {
+		case kindInt, kindEnum:
+			e.int(c.Value.Int())
+		case kindUint, kindBool, kindType:
+			e.uint(c.Value.N)
+		case kindFloat:
+			e.buf = binary.LittleEndian.AppendUint64(e.buf, c.Value.N)
+		case kindString, kindSymbol, kindError:
+			e.string(c.Value.Str())
+		}
+	}
+	e.uint(uint64(len(p.Funcs)))
+	for _, fn := range p.Funcs {
+		e.string(fn.Name)
+		e.uint(uint64(fn.Type))
+		var flags byte
+		if fn.Host {
+			flags |= flagHost
+		}
+		if fn.Export {
+			flags |= flagExport
+		}
+		e.buf = append(e.buf, flags)
+		e.uint(uint64(fn.Locals))
+		e.uint(uint64(fn.MaxStack))
+		e.uint(uint64(len(fn.Code)))
+		e.buf = append(e.buf, fn.Code...)
+		e.uint(uint64(len(fn.Lines)))
+		var last Line
+		for _, l := range fn.Lines {
+			e.uint(uint64(l.PC - last.PC))
+			e.int(int64(l.Line - last.Line))
+			last = l
+		}
+	}
+	return e.buf
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint(x uint64) { e.buf = binary.AppendUvarint(e.buf, x) }
+func (e *encoder) int(x int64)   { e.buf = binary.AppendVarint(e.buf, x) }
+
+func (e *encoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) fields(fields []types.FieldDescriptor) {
+	e.uint(uint64(len(fields)))
+	for _, f := range fields {
+		e.string(f.Label)
+		e.uint(uint64(f.Type))
+	}
+}
+
+// descriptor writes d: its tag and kind, followed by the parts of the kind.
+func (e *encoder) descriptor(d *types.Descriptor) {
+	e.uint(uint64(d.Tag))
+	e.buf = append(e.buf, byte(d.Kind))
+	switch d.Kind {
+	case types.BasicDescriptor, types.TypeParamDescriptor:
+		e.string(d.Name)
+	case types.NamedDescriptor:
+		e.string(d.Name)
+		e.uint(uint64(d.Underlying))
+	case types.TupleDescriptor:
+		e.fields(d.Fields)
+	case types.ArrayDescriptor:
+		e.uint(uint64(d.Elem))
+		e.int(d.Len)
+	case types.UnionDescriptor:
+		e.uint(uint64(len(d.Members)))
+		for _, m := range d.Members {
+			e.uint(uint64(m))
+		}
+	case types.EnumDescriptor:
+		e.fields(d.Fields)
+		for _, v := range d.Values {
+			e.int(v)
+		}
+	case types.FunctionDescriptor:
+		e.fields(d.Fields)
+		if d.HasResult {
+			e.buf = append(e.buf, 1)
+			e.uint(uint64(d.Result))
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	}
+}
+
+// Decode reads a program in the .tupc file format, checking that its types
+// and constants are well formed and that the code of its functions
+// consists of whole instructions with operands in range, jumping only to
+// instructions and ending with a jump, return or trap. It does not check
+// the use of the stack.
+func Decode(b []byte) (p *Program, err error) {
+	d := &decoder{buf: b}
+	defer func() {
+		if r := recover(); r != nil {
+			bail, ok := r.(decodeBailout)
+			if !ok {
+				panic(r)
+			}
+			p, err = nil, bail.err
+		}
+	}()
+	if len(b) < len(magic) || !bytes.Equal(b[:len(magic)], magic) {
+		d.fail(0, "not a program file")
+	}
+	d.pos = len(magic)
+	if v := d.byte(); v != version {
+		d.fail(d.pos-1, "unsupported version %d", v)
+	}
+	p = &Program{Name: d.string(), File: d.string()}
+	p.Types = make([]*types.Descriptor, d.count())
+	for i := range p.Types {
+		p.Types[i] = d.descriptor()
+	}
+	infos, err := resolve(p.Types)
+	if err != nil {
+		d.fail(d.pos, "%v", err)
+	}
+	p.Consts = make([]Const, d.count())
+	for i := range p.Consts {
+		at := d.pos
+		c := &p.Consts[i]
+		c.Type = d.tag(p.Types)
+		switch infos[c.Type.Index()].kind {
+		case kindInt, kindEnum:
+			c.Value = Int(d.int())
+		case kindUint, kindBool:
+			c.Value.N = d.uint()
+		case kindType:
+			c.Value.N = uint64(d.tag(p.Types))
+		case kindFloat:
+			c.Value.N = binary.LittleEndian.Uint64(d.take(8))
+		case kindString, kindSymbol, kindError:
+			c.Value = String(d.string())
+		case kindNil:
+		default:
+			d.fail(at, "constant %d has type %s, which has no constants", i, typeString(p.Types, c.Type))
+		}
+	}
+	p.Funcs = make([]*Func, d.count())
+	for i := range p.Funcs {
+		at := d.pos
+		fn := &Func{Name: d.string(), Type: d.tag(p.Types)}
+		sig := p.Types[fn.Type.Index()]
+		if sig.Kind != types.FunctionDescriptor {
+			d.fail(at, "function %s has type %s, which is not a function type", fn.Name, typeString(p.Types, fn.Type))
+		}
+		fn.Params, fn.Result = len(sig.Fields), sig.HasResult
+		flags := d.byte()
+		fn.Host, fn.Export = flags&flagHost != 0, flags&flagExport != 0
+		fn.Locals, fn.MaxStack = d.count(), d.count()
+		if !fn.Host && fn.Locals < fn.Params {
+			d.fail(at, "function %s has fewer locals than parameters", fn.Name)
+		}
+		if n := d.count(); n > 0 {
+			fn.Code = d.take(n)
+		}
+		if n := d.count(); n > 0 {
+			fn.Lines = make([]Line, n)
+		}
+		var last Line
+		for j := range fn.Lines {
+			at := d.pos
+			l := Line{PC: last.PC + d.count(), Line: last.Line + int(d.int())}
+			if j > 0 && l.PC == last.PC || l.PC > len(fn.Code) || l.Line <= 0 {
+				d.fail(at, "function %s has a malformed line table", fn.Name)
+			}
+			fn.Lines[j], last = l, l
+		}
+		p.Funcs[i] = fn
+	}
+	if d.pos < len(d.buf) {
+		d.fail(d.pos, "trailing data")
+	}
+	for _, fn := range p.Funcs {
+		if err := checkCode(p, fn); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// checkCode checks the code of fn, a function of p.
+func checkCode(p *Program, fn *Func) error {
+	fail := func(format string, args ...any) error {
+		return &Error{Msg: fmt.Sprintf("function %s: %s", fn.Name, fmt.Sprintf(format, args...))}
+	}
+	if fn.Host {
+		if len(fn.Code) > 0 {
+			return fail("host function has code")
+		}
+		return nil
+	}
+	starts := map[int]bool{}
+	var targets []int
+	last := OpInvalid
+	for pc := 0; pc < len(fn.Code); {
+		starts[pc] = true
+		op := Op(fn.Code[pc])
+		if !op.valid() {
+			return fail("invalid opcode %d at %d", op, pc)
+		}
+		at := pc
+		pc++
+		for _, kind := range opInfos[op].operands {
+			if kind == addrOperand {
+				if pc+4 > len(fn.Code) {
+					return fail("truncated instruction at %d", at)
+				}
+				targets = append(targets, int(binary.LittleEndian.Uint32(fn.Code[pc:])))
+				pc += 4
+				continue
+			}
+			x, n := binary.Uvarint(fn.Code[pc:])
+			if n <= 0 {
+				return fail("truncated instruction at %d", at)
+			}
+			pc += n
+			var ok bool
+			switch kind {
+			case constOperand:
+				ok = x < uint64(len(p.Consts))
+			case funcOperand:
+				ok = x < uint64(len(p.Funcs)) && !((op == OpFunc || op == OpClosure || op == OpRecClosure) && p.Funcs[x].Host)
+			case localOperand:
+				ok = x < uint64(fn.Locals)
+			case typeOperand:
+				ok = x < uint64(2*len(p.Types)) && p.Types[types.Tag(x).Index()].Tag == types.Tag(x)
+			case numOperand:
+				ok = x <= math.MaxInt32
+			}
+			if !ok {
+				return fail("operand %d of %s at %d is out of range", x, op, at)
+			}
+		}
+		last = op
+	}
+	if last != OpJump && last != OpRet && last != OpTrap {
+		return fail("code does not end with a jump, return or trap")
+	}
+	for _, target := range targets {
+		if !starts[target] {
+			return fail("jump to %d is not to an instruction", target)
+		}
+	}
+	return nil
+}
+
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+type decodeBailout struct {
+	err error
+}
+
+func (d *decoder) fail(at int, format string, args ...any) {
+	panic(decodeBailout{&Error{Msg: fmt.Sprintf("offset 0x%x: %s", at, fmt.Sprintf(format, args...))}})
+}
+
+func (d *decoder) take(n int) []byte {
+	if n < 0 || d.pos+n > len(d.buf) {
+		d.fail(d.pos, "unexpected end of file")
+	}
+	b := d.buf[d.pos : d.pos+n : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) byte() byte { return d.take(1)[0] }
+
+func (d *decoder) uint() uint64 {
+	x, n := binary.Uvarint(d.buf[d.pos:])
+	if n == 0 {
+		d.fail(d.pos, "unexpected end of file")
+	}
+	if n < 0 {
+		d.fail(d.pos, "number too large")
+	}
+	d.pos += n
+	return x
+}
+
+func (d *decoder) int() int64 {
+	x, n := binary.Varint(d.buf[d.pos:])
+	if n == 0 {
+		d.fail(d.pos, "unexpected end of file")
+	}
+	if n < 0 {
+		d.fail(d.pos, "number too large")
+	}
+	d.pos += n
+	return x
+}
+
+// count reads a count or size, which must not exceed the rest of the file.
+func (d *decoder) count() int {
+	at := d.pos
+	n := d.uint()
+	if n > uint64(len(d.buf)) {
+		d.fail(at, "count %d is too large", n)
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string { return string(d.take(d.count())) }
+
+// tag reads the tag of a type of the table descs.
+func (d *decoder) tag(descs []*types.Descriptor) types.Tag {
+	at := d.pos
+	tag := types.Tag(d.uint())
+	if tag.Index() >= len(descs) || descs[tag.Index()].Tag != tag {
+		d.fail(at, "undefined type %d", tag)
+	}
+	return tag
+}
+
+func (d *decoder) fields() []types.FieldDescriptor {
+	fields := make([]types.FieldDescriptor, d.count())
+	for i := range fields {
+		fields[i] = types.FieldDescriptor{Label: d.string(), Type: types.Tag(d.uint())}
+	}
+	return fields
+}
+
+// descriptor reads a descriptor; the types it refers to are checked once
+// the table has been read.
+func (d *decoder) descriptor() *types.Descriptor {
+	at := d.pos
+	desc := &types.Descriptor{Tag: types.Tag(d.uint()), Kind: types.DescriptorKind(d.byte()), Len: -1}
+	switch desc.Kind {
+	case types.BasicDescriptor, types.TypeParamDescriptor:
+		desc.Name = d.string()
+	case types.NamedDescriptor:
+		desc.Name = d.string()
+		desc.Underlying = types.Tag(d.uint())
+	case types.TupleDescriptor:
+		desc.Fields = d.fields()
+	case types.ArrayDescriptor:
+		desc.Elem = types.Tag(d.uint())
+		desc.Len = d.int()
+	case types.UnionDescriptor:
+		for n := d.count(); n > 0; n-- {
+			desc.Members = append(desc.Members, types.Tag(d.uint()))
+		}
+	case types.EnumDescriptor:
+		if desc.Fields = d.fields(); len(desc.Fields) == 0 {
+			desc.Fields = nil
+		}
+		for range desc.Fields {
+			desc.Values = append(desc.Values, d.int())
+		}
+	case types.FunctionDescriptor:
+		desc.Fields = d.fields()
+		if d.byte() != 0 {
+			desc.HasResult = true
+			desc.Result = types.Tag(d.uint())
+		}
+	default:
+		d.fail(at, "unknown kind of type %d", desc.Kind)
+	}
+	return desc
+}
@@ -0,0 +1,68 @@
+package bytecode
+
+import "github.com/rowland/tuppence/tup/ir"
+
+// liveness holds the values of a function live at the starts and ends of
+// its blocks: those the code following may use. The operands of a phi are
+// live at the end of the block they flow in from, where they are copied,
+// rather than at the start of the phi's block.
+type liveness struct {
+	in, out map[*ir.Block]map[ir.Value]bool
+}
+
+func live(fn *ir.Func) *liveness {
+	l := &liveness{in: map[*ir.Block]map[ir.Value]bool{}, out: map[*ir.Block]map[ir.Value]bool{}}
+	// uses holds the values each block uses before defining them, and
+	// defs those it defines
+	uses := map[*ir.Block]map[ir.Value]bool{}
+	defs := map[*ir.Block]map[ir.Value]bool{}
+	for _, b := range fn.Blocks {
+		uses[b], defs[b] = map[ir.Value]bool{}, map[ir.Value]bool{}
+		for _, instr := range b.Instrs {
+			if instr.Op != ir.OpPhi {
+				for _, arg := range instr.Args {
+					if !defs[b][arg] {
+						uses[b][arg] = true
+					}
+				}
+			}
+			defs[b][instr] = true
+		}
+	}
+	// the sets only grow, until they stop changing
+	for changed := true; changed; {
+		changed = false
+		for i := len(fn.Blocks) - 1; i >= 0; i-- {
+			b := fn.Blocks[i]
+			out := map[ir.Value]bool{}
+			if term := b.Terminator(); term != nil {
+				for _, succ := range term.Blocks {
+					for v := range l.in[succ] {
+						out[v] = true
+					}
+					for _, phi := range succ.Phis() {
+						for j, pred := range phi.Blocks {
+							if pred == b {
+								out[phi.Args[j]] = true
+							}
+						}
+					}
+				}
+			}
+			in := map[ir.Value]bool{}
+			for v := range uses[b] {
+				in[v] = true
+			}
+			for v := range out {
+				if !defs[b][v] {
+					in[v] = true
+				}
+			}
+			if len(in) != len(l.in[b]) || len(out) != len(l.out[b]) {
+				changed = true
+			}
+			l.in[b], l.out[b] = in, out
+		}
+	}
+	return l
+}
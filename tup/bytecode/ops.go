@@ -0,0 +1,519 @@
+package bytecode
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+const (
+	overflow       = "integer overflow"
+	divisionByZero = "division by zero"
+	floatOverflow  = "floating-point overflow"
+)
+
+// arith returns the result of the arithmetic op on x and y, of the type
+// info describes, or the message of the trap the operation makes.
+func arith(op Op, info *typeInfo, x, y Value) (Value, string) {
+	switch info.kind {
+	case kindInt:
+		z, msg := intArith(op, x.Int(), y.Int(), info.bits)
+		if msg == "" && info.bits < 64 && (z < info.min || z > int64(info.max)) {
+			msg = overflow
+		}
+		return Int(z), msg
+	case kindUint:
+		z, msg := uintArith(op, x.N, y.N, info.bits)
+		if msg == "" && z > info.max {
+			msg = overflow
+		}
+		return Value{N: z}, msg
+	case kindFloat:
+		return floatArith(op, x.Float(), y.Float(), info.bits)
+	case kindBool:
+		switch op {
+		case OpAnd:
+			return Value{N: x.N & y.N}, ""
+		case OpOr:
+			return Value{N: x.N | y.N}, ""
+		case OpXor:
+			return Value{N: x.N ^ y.N}, ""
+		}
+	case kindString:
+		if op == OpAdd {
+			return String(x.Str() + y.Str()), ""
+		}
+	}
+	panic(trap(fmt.Sprintf("invalid operation %s", op)))
+}
+
+// intArith performs op on the signed integers x and y, which are bits
+// wide; the caller checks that results fit types narrower than 64 bits.
+func intArith(op Op, x, y int64, bits int) (int64, string) {
+	switch op {
+	case OpAdd:
+		z := x + y
+		if y > 0 && z < x || y < 0 && z > x {
+			return 0, overflow
+		}
+		return z, ""
+	case OpSub:
+		z := x - y
+		if y > 0 && z > x || y < 0 && z < x {
+			return 0, overflow
+		}
+		return z, ""
+	case OpMul:
+		if x == 0 || y == 0 {
+			return 0, ""
+		}
+		z := x * y
+		if z/y != x || x == -1 && y == math.MinInt64 || y == -1 && x == math.MinInt64 {
+			return 0, overflow
+		}
+		return z, ""
+	case OpDiv:
+		if y == 0 {
+			return 0, divisionByZero
+		}
+		if y == -1 && x == math.MinInt64 {
+			return 0, overflow
+		}
+		return x / y, ""
+	case OpMod:
+		if y == 0 {
+			return 0, divisionByZero
+		}
+		return x % y, ""
+	case OpPow:
+		if y < 0 {
+			return 0, "negative exponent"
+		}
+		z := int64(1)
+		for y > 0 {
+			var msg string
+			if y&1 != 0 {
+				if z, msg = powStep(z, x, bits); msg != "" {
+					return 0, msg
+				}
+			}
+			y >>= 1
+			if y > 0 {
+				if x, msg = powStep(x, x, bits); msg != "" {
+					return 0, msg
+				}
+			}
+		}
+		return z, ""
+	case OpAnd:
+		return x & y, ""
+	case OpOr:
+		return x | y, ""
+	case OpXor:
+		return x ^ y, ""
+	case OpShl:
+		if y < 0 {
+			return 0, "negative shift count"
+		}
+		if x == 0 {
+			return 0, ""
+		}
+		if y >= int64(bits) {
+			return 0, overflow
+		}
+		z := x << y
+		if z>>y != x {
+			return 0, overflow
+		}
+		return z, ""
+	case OpShr:
+		if y < 0 {
+			return 0, "negative shift count"
+		}
+		return x >> min(y, 63), ""
+	}
+	panic(trap(fmt.Sprintf("invalid operation %s", op)))
+}
+
+// powStep multiplies x by y for exponentiation, in which every product
+// must fit the type as well as the result.
+func powStep(x, y int64, bits int) (int64, string) {
+	z, msg := intArith(OpMul, x, y, 64)
+	if msg == "" && bits < 64 && (z < -1<<(bits-1) || z > 1<<(bits-1)-1) {
+		msg = overflow
+	}
+	return z, msg
+}
+
+// uintArith performs op on the unsigned integers x and y, which are bits
+// wide; the caller checks that results fit types narrower than 64 bits.
+func uintArith(op Op, x, y uint64, width int) (uint64, string) {
+	switch op {
+	case OpAdd:
+		z, carry := bits.Add64(x, y, 0)
+		if carry != 0 {
+			return 0, overflow
+		}
+		return z, ""
+	case OpSub:
+		if x < y {
+			return 0, overflow
+		}
+		return x - y, ""
+	case OpMul:
+		hi, lo := bits.Mul64(x, y)
+		if hi != 0 {
+			return 0, overflow
+		}
+		return lo, ""
+	case OpDiv:
+		if y == 0 {
+			return 0, divisionByZero
+		}
+		return x / y, ""
+	case OpMod:
+		if y == 0 {
+			return 0, divisionByZero
+		}
+		return x % y, ""
+	case OpPow:
+		limit := uint64(math.MaxUint64 >> (64 - width))
+		z := uint64(1)
+		for y > 0 {
+			var msg string
+			if y&1 != 0 {
+				if z, msg = uintArith(OpMul, z, x, 64); msg != "" || z > limit {
+					return 0, overflow
+				}
+			}
+			y >>= 1
+			if y > 0 {
+				if x, msg = uintArith(OpMul, x, x, 64); msg != "" || x > limit {
+					return 0, overflow
+				}
+			}
+		}
+		return z, ""
+	case OpAnd:
+		return x & y, ""
+	case OpOr:
+		return x | y, ""
+	case OpXor:
+		return x ^ y, ""
+	case OpShl:
+		if x == 0 {
+			return 0, ""
+		}
+		if y >= uint64(width) {
+			return 0, overflow
+		}
+		z := x << y
+		if z>>y != x {
+			return 0, overflow
+		}
+		return z, ""
+	case OpShr:
+		if y >= 64 {
+			return 0, ""
+		}
+		return x >> y, ""
+	}
+	panic(trap(fmt.Sprintf("invalid operation %s", op)))
+}
+
+// floatArith performs op on the floats x and y, rounding the result to
+// float32 for types 32 bits wide.
+func floatArith(op Op, x, y float64, bits int) (Value, string) {
+	var z float64
+	switch op {
+	case OpAdd:
+		z = x + y
+	case OpSub:
+		z = x - y
+	case OpMul:
+		z = x * y
+	case OpDiv:
+		if y == 0 {
+			return Value{}, divisionByZero
+		}
+		z = x / y
+	case OpPow:
+		z = math.Pow(x, y)
+	default:
+		panic(trap(fmt.Sprintf("invalid operation %s", op)))
+	}
+	if bits == 32 {
+		z = float64(float32(z))
+	}
+	if math.IsInf(z, 0) || math.IsNaN(z) {
+		return Value{}, floatOverflow
+	}
+	return Float(z), ""
+}
+
+// unary returns the result of the operation op on x, of the type info
+// describes, or the message of the trap it makes.
+func unary(op Op, info *typeInfo, x Value) (Value, string) {
+	switch {
+	case op == OpNot && info.kind == kindBool:
+		return Bool(!x.Bool()), ""
+	case op == OpNot && info.kind == kindInt:
+		return Int(^x.Int()), ""
+	case op == OpNot && info.kind == kindUint:
+		return Value{N: ^x.N & info.max}, ""
+	case op == OpNeg && info.kind == kindFloat:
+		return Float(-x.Float()), ""
+	case op == OpNeg && info.kind == kindInt, op == OpNeg && info.kind == kindUint:
+		return arith(OpSub, info, Value{}, x)
+	}
+	panic(trap(fmt.Sprintf("invalid operation %s", op)))
+}
+
+// convert returns the integer x, of the type from describes, as a value of
+// the integer type to describes, or the message of the trap it makes if
+// the value does not fit.
+func convert(to, from *typeInfo, x Value) (Value, string) {
+	if from.kind == kindInt && x.Int() < 0 {
+		if n := x.Int(); to.kind != kindInt || n < to.min {
+			return Value{}, overflow
+		}
+		return x, ""
+	}
+	// the value is not negative, so its bits are those of an unsigned
+	// integer
+	if x.N > to.max {
+		return Value{}, overflow
+	}
+	return x, ""
+}
+
+// compare returns -1, 0 or 1 as x is less than, equal to or greater than
+// y, numbers or strings of the type info describes.
+func compare(info *typeInfo, x, y Value) int {
+	switch info.kind {
+	case kindInt, kindEnum:
+		return cmp(x.Int(), y.Int())
+	case kindUint, kindBool:
+		return cmp(x.N, y.N)
+	case kindFloat:
+		return cmp(x.Float(), y.Float())
+	case kindString, kindSymbol:
+		return strings.Compare(x.Str(), y.Str())
+	}
+	panic(trap("invalid comparison"))
+}
+
+func cmp[T int64 | uint64 | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// equal reports whether x and y, of the type identified by tag, are equal.
+func (vm *VM) equal(tag types.Tag, x, y Value) bool {
+	info := &vm.infos[tag.Index()]
+	switch info.kind {
+	case kindNil:
+		return true
+	case kindFloat:
+		return x.Float() == y.Float()
+	case kindString, kindSymbol, kindError:
+		return x.Str() == y.Str()
+	case kindTuple:
+		xs, ys := x.Object().Elems, y.Object().Elems
+		for i, f := range info.fields {
+			if !vm.equal(f, xs[i], ys[i]) {
+				return false
+			}
+		}
+		return true
+	case kindArray, kindFixed:
+		xs, ys := elems(info, x), elems(info, y)
+		if len(xs) != len(ys) {
+			return false
+		}
+		for i := range xs {
+			if !vm.equal(info.elem, xs[i], ys[i]) {
+				return false
+			}
+		}
+		return true
+	case kindUnion:
+		return x.N == y.N && vm.equal(info.fields[x.N], x.Object().Elems[0], y.Object().Elems[0])
+	case kindFunc:
+		return x.R == y.R
+	}
+	return x.N == y.N
+}
+
+// elems returns the elements of the array x of the type info describes.
+func elems(info *typeInfo, x Value) []Value {
+	obj := x.Object()
+	if obj == nil {
+		return nil
+	}
+	if info.kind == kindFixed {
+		return obj.Elems
+	}
+	return obj.Elems[:x.N]
+}
+
+// index returns the element of the array, or byte of the string, x at
+// index i, trapping if there is none.
+func index(info *typeInfo, x Value, i int64) Value {
+	if info.kind == kindString {
+		s := x.Str()
+		checkIndex(i, len(s))
+		return Value{N: uint64(s[i])}
+	}
+	xs := elems(info, x)
+	checkIndex(i, len(xs))
+	return xs[i]
+}
+
+func checkIndex(i int64, n int) {
+	if i < 0 || i >= int64(n) {
+		panic(trap(fmt.Sprintf("index %d out of range [0:%d]", i, n)))
+	}
+}
+
+// slice returns elements lo through hi of the array, or bytes of the
+// string, x as a value of the type identified by tag, trapping if they do
+// not lie within it. It consumes x.
+func (vm *VM) slice(info *typeInfo, tag types.Tag, x Value, lo, hi int64) Value {
+	if info.kind == kindString {
+		s := x.Str()
+		checkSlice(lo, hi, len(s))
+		return String(s[lo : hi+1])
+	}
+	xs := elems(info, x)
+	checkSlice(lo, hi, len(xs))
+	obj := vm.heap.New(tag, int(hi+1-lo))
+	copy(obj.Elems, xs[lo:hi+1])
+	for _, e := range obj.Elems {
+		vm.heap.Retain(e)
+	}
+	vm.heap.Release(x)
+	return Value{N: uint64(len(obj.Elems)), R: obj}
+}
+
+func checkSlice(lo, hi int64, n int) {
+	if lo < 0 || hi < lo-1 || hi >= int64(n) {
+		panic(trap(fmt.Sprintf("slice [%d..%d] out of range [0:%d]", lo, hi, n)))
+	}
+}
+
+// length returns the length of the array or string x.
+func length(info *typeInfo, x Value) int64 {
+	switch info.kind {
+	case kindString:
+		return int64(len(x.Str()))
+	case kindFixed:
+		return info.n
+	}
+	return int64(x.N)
+}
+
+// appendValue returns the array a, of the dynamic array type identified by
+// tag, with e appended. Like the arrays of the Go backend, it shares the
+// elements of a when no other array has been appended to it yet.
+func appendValue(tag types.Tag, a, e Value) Value {
+	obj := a.Object()
+	if obj != nil && len(obj.Elems) == int(a.N) {
+		obj.Elems = append(obj.Elems, e)
+		return Value{N: a.N + 1, R: obj}
+	}
+	elems := make([]Value, a.N, max(2*a.N, 4))
+	if obj != nil {
+		copy(elems, obj.Elems[:a.N])
+	}
+	return Value{N: a.N + 1, R: &Object{Type: tag, Elems: append(elems, e)}}
+}
+
+// text returns the text of x, of the type identified by tag, as print
+// writes it, quoting strings if quote is set.
+func (vm *VM) text(tag types.Tag, x Value, quote bool) string {
+	var b strings.Builder
+	vm.format(&b, tag, x, quote)
+	return b.String()
+}
+
+func (vm *VM) format(b *strings.Builder, tag types.Tag, x Value, quote bool) {
+	info := &vm.infos[tag.Index()]
+	switch info.kind {
+	case kindNil:
+		b.WriteString("nil")
+	case kindBool:
+		b.WriteString(strconv.FormatBool(x.Bool()))
+	case kindInt:
+		b.WriteString(strconv.FormatInt(x.Int(), 10))
+	case kindUint:
+		b.WriteString(strconv.FormatUint(x.N, 10))
+	case kindFloat:
+		s := strconv.FormatFloat(x.Float(), 'g', -1, 64)
+		b.WriteString(s)
+		if !strings.ContainsAny(s, ".eEnN") {
+			b.WriteString(".0")
+		}
+	case kindEnum:
+		for i, v := range info.values {
+			if v == x.Int() {
+				b.WriteString(info.name + "." + info.members[i])
+				return
+			}
+		}
+		b.WriteString(strconv.FormatInt(x.Int(), 10))
+	case kindString:
+		if quote {
+			b.WriteString(strconv.Quote(x.Str()))
+		} else {
+			b.WriteString(x.Str())
+		}
+	case kindSymbol:
+		b.WriteString(":" + x.Str())
+	case kindError:
+		b.WriteString("error(" + strconv.Quote(x.Str()) + ")")
+	case kindTuple:
+		b.WriteString("(")
+		for i, f := range info.fields {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if info.labels[i] != "" {
+				b.WriteString(info.labels[i] + ": ")
+			}
+			vm.format(b, f, x.Object().Elems[i], true)
+		}
+		if len(info.fields) == 1 && info.labels[0] == "" {
+			b.WriteString(",")
+		}
+		b.WriteString(")")
+	case kindArray, kindFixed:
+		b.WriteString("[")
+		for i, e := range elems(info, x) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			vm.format(b, info.elem, e, true)
+		}
+		b.WriteString("]")
+	case kindUnion:
+		// the members of unions are written as the values they hold
+		vm.format(b, info.fields[x.N], x.Object().Elems[0], quote)
+	case kindFunc:
+		b.WriteString(ir.FuncText(vm.prog.Funcs[x.N].Name))
+	case kindType:
+		b.WriteString("typeof(" + typeString(vm.prog.Types, types.Tag(x.N)) + ")")
+	default:
+		panic(trap("invalid value"))
+	}
+}
@@ -0,0 +1,234 @@
+package bytecode
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// kind classifies types by the representation of their values.
+type kind byte
+
+const (
+	kindInvalid kind = iota
+	kindNil
+	kindBool
+	kindInt   // signed integers
+	kindUint  // unsigned integers
+	kindFloat // floats
+	kindEnum
+	kindString
+	kindSymbol
+	kindError
+	kindTuple
+	kindArray // dynamic arrays
+	kindFixed // fixed-size arrays
+	kindUnion
+	kindFunc
+	kindType // type descriptors, held as the tags of the types they describe
+)
+
+// typeInfo describes the values of a type of a program: named types are
+// described as their underlying types.
+type typeInfo struct {
+	kind kind
+	// bits is the width of integers and floats.
+	bits int
+	// min and max bound signed and unsigned integers.
+	min int64
+	max uint64
+	// fields holds the types of the fields of a tuple, the members of a
+	// union and the parameters of a function; labels holds the labels of
+	// the fields of a tuple.
+	fields []types.Tag
+	labels []string
+	// elem and n are the element type and length of fixed-size arrays.
+	elem types.Tag
+	n    int64
+	// result is set for functions with a result.
+	result bool
+	// name is the name an enum is written with, and members and values
+	// the names and values of its members.
+	name    string
+	members []string
+	values  []int64
+}
+
+var basicInfos = map[string]typeInfo{
+	"Nil":     {kind: kindNil},
+	"Bool":    {kind: kindBool},
+	"Int8":    {kind: kindInt, bits: 8},
+	"Int16":   {kind: kindInt, bits: 16},
+	"Int32":   {kind: kindInt, bits: 32},
+	"Int64":   {kind: kindInt, bits: 64},
+	"UInt8":   {kind: kindUint, bits: 8},
+	"UInt16":  {kind: kindUint, bits: 16},
+	"UInt32":  {kind: kindUint, bits: 32},
+	"UInt64":  {kind: kindUint, bits: 64},
+	"Float16": {kind: kindFloat, bits: 32},
+	"Float32": {kind: kindFloat, bits: 32},
+	"Float64": {kind: kindFloat, bits: 64},
+	"String":  {kind: kindString},
+	"Symbol":  {kind: kindSymbol},
+	"Type":    {kind: kindType},
+}
+
+// resolve describes the types of the table descs, whose first entry must
+// be the predeclared error type. Types without a run-time form, such as
+// type parameters, are described as invalid.
+func resolve(descs []*types.Descriptor) ([]typeInfo, error) {
+	if len(descs) == 0 || descs[0].Kind != types.NamedDescriptor || descs[0].Name != "error" {
+		return nil, fmt.Errorf("the type table does not begin with the error type")
+	}
+	infos := make([]typeInfo, len(descs))
+	valid := func(tag types.Tag) error {
+		if tag.Index() >= len(descs) {
+			return fmt.Errorf("type %d refers to undefined type %d", tag.Index(), tag.Index())
+		}
+		return nil
+	}
+	for i, d := range descs {
+		if d.Tag.Index() != i {
+			return nil, fmt.Errorf("type %d has tag %d", i, d.Tag)
+		}
+		var tags []types.Tag
+		info := &infos[i]
+		switch d.Kind {
+		case types.BasicDescriptor:
+			*info = basicInfos[d.Name]
+			switch info.kind {
+			case kindInt:
+				info.min = -1 << (info.bits - 1)
+				info.max = 1<<(info.bits-1) - 1
+			case kindUint:
+				info.max = math.MaxUint64 >> (64 - info.bits)
+			}
+		case types.TupleDescriptor:
+			info.kind = kindTuple
+			for _, f := range d.Fields {
+				info.fields = append(info.fields, f.Type)
+				info.labels = append(info.labels, f.Label)
+			}
+			tags = info.fields
+		case types.ArrayDescriptor:
+			info.kind, info.elem, info.n = kindArray, d.Elem, d.Len
+			if d.Len >= 0 {
+				info.kind = kindFixed
+			}
+			tags = []types.Tag{d.Elem}
+		case types.UnionDescriptor:
+			info.kind, info.fields = kindUnion, d.Members
+			tags = d.Members
+		case types.EnumDescriptor:
+			info.kind, info.values = kindEnum, d.Values
+			for _, f := range d.Fields {
+				info.members = append(info.members, f.Label)
+			}
+			info.name = typeString(descs, d.Tag)
+		case types.FunctionDescriptor:
+			info.kind, info.result = kindFunc, d.HasResult
+			for _, f := range d.Fields {
+				info.fields = append(info.fields, f.Type)
+			}
+			tags = info.fields
+			if d.HasResult {
+				tags = append(tags[:len(tags):len(tags)], d.Result)
+			}
+		case types.NamedDescriptor:
+			tags = []types.Tag{d.Underlying}
+		}
+		for _, tag := range tags {
+			if err := valid(tag); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// named types are described as their underlying types, which are not
+	// named, once those are
+	for i, d := range descs {
+		if d.Kind != types.NamedDescriptor {
+			continue
+		}
+		u := descs[d.Underlying.Index()]
+		if u.Kind == types.NamedDescriptor {
+			return nil, fmt.Errorf("named type %s has the named type %s as its underlying type", d.Name, u.Name)
+		}
+		infos[i] = infos[u.Tag.Index()]
+		if infos[i].kind == kindEnum {
+			infos[i].name = d.Name
+		}
+	}
+	infos[0] = typeInfo{kind: kindError}
+	return infos, nil
+}
+
+// typeString returns the text of the type identified by tag, as it would be
+// written in source.
+func typeString(descs []*types.Descriptor, tag types.Tag) string {
+	if tag.Index() >= len(descs) {
+		return "type" + strconv.Itoa(tag.Index())
+	}
+	d := descs[tag.Index()]
+	var b strings.Builder
+	list := func(tags []types.Tag, labels []string, sep string) {
+		for i, t := range tags {
+			if i > 0 {
+				b.WriteString(sep)
+			}
+			if labels != nil && labels[i] != "" {
+				b.WriteString(labels[i])
+				b.WriteString(": ")
+			}
+			b.WriteString(typeString(descs, t))
+		}
+	}
+	switch d.Kind {
+	case types.BasicDescriptor, types.NamedDescriptor, types.TypeParamDescriptor:
+		return d.Name
+	case types.TupleDescriptor:
+		b.WriteString("(")
+		tags := make([]types.Tag, len(d.Fields))
+		for i, f := range d.Fields {
+			tags[i] = f.Type
+		}
+		list(tags, d.Labels(), ", ")
+		if len(d.Fields) == 1 && d.Fields[0].Label == "" {
+			b.WriteString(",")
+		}
+		b.WriteString(")")
+	case types.ArrayDescriptor:
+		b.WriteString("[")
+		if d.Len >= 0 {
+			b.WriteString(strconv.FormatInt(d.Len, 10))
+		}
+		b.WriteString("]")
+		b.WriteString(typeString(descs, d.Elem))
+	case types.UnionDescriptor:
+		list(d.Members, nil, " | ")
+	case types.EnumDescriptor:
+		b.WriteString("enum(")
+		for i, f := range d.Fields {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%s = %d", f.Label, d.Values[i])
+		}
+		b.WriteString(")")
+	case types.FunctionDescriptor:
+		b.WriteString("fn(")
+		tags := make([]types.Tag, len(d.Fields))
+		for i, f := range d.Fields {
+			tags[i] = f.Type
+		}
+		list(tags, d.Labels(), ", ")
+		b.WriteString(")")
+		if d.HasResult {
+			b.WriteString(" ")
+			b.WriteString(typeString(descs, d.Result))
+		}
+	}
+	return b.String()
+}
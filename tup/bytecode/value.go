@@ -0,0 +1,33 @@
+package bytecode
+
+import (
+	"math"
+
+	"github.com/rowland/tuppence/tup/mem"
+)
+
+// Value is a value of a running program, as package mem represents it.
+// Functions are held as their index in N and their *Func in R, and
+// closures as the index of their function in N and an *Object in R
+// holding the values they capture.
+type Value = mem.Value
+
+// Object is a value held by reference, allocated from the heap of the VM.
+type Object = mem.Object
+
+// Int returns the Value of the signed integer x.
+func Int(x int64) Value { return Value{N: uint64(x)} }
+
+// Float returns the Value of the float x.
+func Float(x float64) Value { return Value{N: math.Float64bits(x)} }
+
+// Bool returns the Value of b.
+func Bool(b bool) Value {
+	if b {
+		return Value{N: 1}
+	}
+	return Value{}
+}
+
+// String returns the Value of the string s.
+func String(s string) Value { return Value{R: s} }
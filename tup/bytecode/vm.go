@@ -0,0 +1,456 @@
+package bytecode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rowland/tuppence/tup/mem"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// maxDepth bounds the depth of nested function calls, as the interpreter
+// does.
+const maxDepth = 10_000
+
+// RuntimeError reports a trap of a running program, such as an unchecked
+// overflow or an index out of range, at the line of the source the
+// trapping code was compiled from.
+type RuntimeError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (err *RuntimeError) Error() string {
+	if err.Line == 0 {
+		return "runtime error: " + err.Msg
+	}
+	return fmt.Sprintf("runtime error: %s\n--> %s:%d", err.Msg, err.File, err.Line)
+}
+
+// trap is panicked with by the operations of the machine to stop the
+// program; run recovers it into a RuntimeError.
+type trap string
+
+// hostFunc is a host function provided by the machine.
+type hostFunc func(vm *VM, args []Value) Value
+
+var hostFuncs = map[string]hostFunc{
+	"print": func(vm *VM, args []Value) Value {
+		io.WriteString(vm.Stdout, args[0].Str()+"\n")
+		return Value{}
+	},
+}
+
+// VM runs a program. It allocates the values it holds by reference from a
+// heap, counting the references its stack, its locals and other values
+// hold to them.
+type VM struct {
+	// Stdout receives the output of print.
+	Stdout io.Writer
+
+	prog  *Program
+	infos []typeInfo
+	host  []hostFunc // indexed by function
+	stack []Value
+	heap  *mem.Heap
+}
+
+// frame is the state of a call suspended by a nested one.
+type frame struct {
+	fn   *Func
+	pc   int
+	base int
+}
+
+// New returns a VM for p, reporting an error if p calls host functions the
+// machine does not provide.
+func New(p *Program) (*VM, error) {
+	infos, err := resolve(p.Types)
+	if err != nil {
+		return nil, &Error{Msg: err.Error()}
+	}
+	vm := &VM{
+		Stdout: os.Stdout,
+		prog:   p,
+		infos:  infos,
+		host:   make([]hostFunc, len(p.Funcs)),
+		stack:  make([]Value, 1024),
+		heap:   mem.NewHeap(p.Types),
+	}
+	for i, fn := range p.Funcs {
+		if !fn.Host {
+			continue
+		}
+		h, ok := hostFuncs[fn.Name]
+		if !ok {
+			return nil, &Error{Msg: fmt.Sprintf("host function %s is not provided by the VM", fn.Name)}
+		}
+		vm.host[i] = h
+	}
+	return vm, nil
+}
+
+// Run calls the function main, which takes no arguments.
+func (vm *VM) Run() error {
+	_, err := vm.Call("main")
+	vm.heap.Collect()
+	return err
+}
+
+// Stats returns the allocation statistics of the heap of vm.
+func (vm *VM) Stats() mem.Stats { return vm.heap.Stats() }
+
+// Call calls the function named name with args and returns its result, or
+// the zero Value if it has none. The references args hold pass to the
+// function, and the one its result holds to the caller.
+func (vm *VM) Call(name string, args ...Value) (Value, error) {
+	index := vm.prog.Func(name)
+	if index < 0 {
+		return Value{}, fmt.Errorf("no function %s is defined", name)
+	}
+	fn := vm.prog.Funcs[index]
+	if len(args) != fn.Params {
+		return Value{}, fmt.Errorf("%s takes %d arguments, not %d", name, fn.Params, len(args))
+	}
+	if fn.Host {
+		r := vm.host[index](vm, args)
+		for _, arg := range args {
+			vm.heap.Release(arg)
+		}
+		return r, nil
+	}
+	vm.grow(0, len(args)+fn.Locals+fn.MaxStack)
+	copy(vm.stack, args)
+	return vm.run(fn)
+}
+
+// grow makes room for n values above the first sp of the stack.
+func (vm *VM) grow(sp, n int) []Value {
+	if n > len(vm.stack) {
+		s := make([]Value, max(2*len(vm.stack), n))
+		copy(s, vm.stack[:sp])
+		vm.stack = s
+	}
+	return vm.stack
+}
+
+// decode decodes the unsigned LEB128 operand at offset pc of code, and
+// returns it with the offset that follows it.
+func decode(code []byte, pc int) (uint64, int) {
+	if b := code[pc]; b < 0x80 {
+		return uint64(b), pc + 1
+	}
+	x, n := binary.Uvarint(code[pc:])
+	return x, pc + n
+}
+
+// run runs fn, whose arguments are at the bottom of the stack. Each
+// value on the stack or in a local holds a reference to the object it
+// refers to; the instructions taking values from the stack consume their
+// references.
+func (vm *VM) run(fn *Func) (result Value, err error) {
+	var (
+		s      = vm.stack
+		heap   = vm.heap
+		consts = vm.prog.Consts
+		funcs  = vm.prog.Funcs
+		infos  = vm.infos
+		frames []frame
+		code   = fn.Code
+		base   = 0
+		sp     = fn.Locals
+		pc     = 0
+		at     = 0 // the offset of the instruction being run
+	)
+	clear(s[fn.Params:fn.Locals])
+	defer func() {
+		if r := recover(); r != nil {
+			msg, ok := r.(trap)
+			if !ok {
+				panic(r)
+			}
+			// the values of the frames abandoned are released
+			for _, v := range s[:sp] {
+				heap.Release(v)
+			}
+			result, err = Value{}, &RuntimeError{File: vm.prog.File, Line: fn.Line(at), Msg: string(msg)}
+		}
+	}()
+	for {
+		at = pc
+		op := Op(code[pc])
+		pc++
+		var (
+			x      uint64
+			callee int = -1
+		)
+		switch op {
+		case OpConst:
+			x, pc = decode(code, pc)
+			s[sp] = consts[x].Value
+			sp++
+		case OpZero:
+			s[sp] = Value{}
+			sp++
+		case OpFunc:
+			x, pc = decode(code, pc)
+			s[sp] = Value{N: x, R: funcs[x]}
+			sp++
+		case OpClosure:
+			var t, n uint64
+			x, pc = decode(code, pc)
+			t, pc = decode(code, pc)
+			n, pc = decode(code, pc)
+			sp -= int(n)
+			obj := heap.New(types.Tag(t), int(n))
+			copy(obj.Elems, s[sp:sp+int(n)])
+			s[sp] = Value{N: x, R: obj}
+			sp++
+		case OpRecClosure:
+			// the closure refers to itself, forming a cycle
+			var t, n uint64
+			x, pc = decode(code, pc)
+			t, pc = decode(code, pc)
+			n, pc = decode(code, pc)
+			sp -= int(n)
+			obj := heap.New(types.Tag(t), int(n)+1)
+			copy(obj.Elems, s[sp:sp+int(n)])
+			s[sp] = Value{N: x, R: obj}
+			obj.Elems[n] = s[sp]
+			heap.Retain(s[sp])
+			sp++
+		case OpLoad:
+			x, pc = decode(code, pc)
+			s[sp] = s[base+int(x)]
+			heap.Retain(s[sp])
+			sp++
+		case OpMove:
+			x, pc = decode(code, pc)
+			s[sp] = s[base+int(x)]
+			s[base+int(x)] = Value{}
+			sp++
+		case OpStore:
+			x, pc = decode(code, pc)
+			sp--
+			heap.Release(s[base+int(x)])
+			s[base+int(x)] = s[sp]
+		case OpPop:
+			sp--
+			heap.Release(s[sp])
+
+		case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpPow, OpAnd, OpOr, OpXor, OpShl, OpShr:
+			x, pc = decode(code, pc)
+			sp--
+			v, msg := arith(op, &infos[x>>1], s[sp-1], s[sp])
+			if msg != "" {
+				panic(trap(msg))
+			}
+			s[sp-1] = v
+		case OpNeg, OpNot:
+			x, pc = decode(code, pc)
+			v, msg := unary(op, &infos[x>>1], s[sp-1])
+			if msg != "" {
+				panic(trap(msg))
+			}
+			s[sp-1] = v
+		case OpAddChecked, OpSubChecked, OpMulChecked, OpDivChecked, OpModChecked:
+			x, pc = decode(code, pc)
+			v, msg := arith(op-OpAddChecked+OpAdd, &infos[x>>1], s[sp-2], s[sp-1])
+			s[sp-2], s[sp-1] = v, Bool(msg == "")
+		case OpEq, OpNe:
+			x, pc = decode(code, pc)
+			sp--
+			eq := vm.equal(types.Tag(x), s[sp-1], s[sp])
+			heap.Release(s[sp-1])
+			heap.Release(s[sp])
+			s[sp-1] = Bool(eq == (op == OpEq))
+		case OpLt, OpLe, OpGt, OpGe, OpCmp:
+			x, pc = decode(code, pc)
+			sp--
+			c := compare(&infos[x>>1], s[sp-1], s[sp])
+			var v Value
+			switch op {
+			case OpLt:
+				v = Bool(c < 0)
+			case OpLe:
+				v = Bool(c <= 0)
+			case OpGt:
+				v = Bool(c > 0)
+			case OpGe:
+				v = Bool(c >= 0)
+			default:
+				v = Int(int64(c))
+			}
+			s[sp-1] = v
+		case OpStr:
+			x, pc = decode(code, pc)
+			text := vm.text(types.Tag(x), s[sp-1], false)
+			heap.Release(s[sp-1])
+			s[sp-1] = String(text)
+
+		case OpConv:
+			var y uint64
+			x, pc = decode(code, pc)
+			y, pc = decode(code, pc)
+			v, msg := convert(&infos[x>>1], &infos[y>>1], s[sp-1])
+			if msg != "" {
+				panic(trap(msg))
+			}
+			s[sp-1] = v
+
+		case OpTuple, OpArray:
+			var n uint64
+			x, pc = decode(code, pc)
+			n, pc = decode(code, pc)
+			sp -= int(n)
+			obj := heap.New(types.Tag(x), int(n))
+			copy(obj.Elems, s[sp:sp+int(n)])
+			v := Value{R: obj}
+			if infos[x>>1].kind == kindArray {
+				v.N = n
+			}
+			s[sp] = v
+			sp++
+		case OpField:
+			x, pc = decode(code, pc)
+			v := s[sp-1].R.(*Object).Elems[x]
+			heap.Retain(v)
+			heap.Release(s[sp-1])
+			s[sp-1] = v
+		case OpUpdate:
+			x, pc = decode(code, pc)
+			sp--
+			s[sp-1] = heap.Update(s[sp-1], int(x), s[sp])
+		case OpIndex:
+			x, pc = decode(code, pc)
+			sp--
+			v := index(&infos[x>>1], s[sp-1], s[sp].Int())
+			heap.Retain(v)
+			heap.Release(s[sp-1])
+			s[sp-1] = v
+		case OpLen:
+			x, pc = decode(code, pc)
+			n := length(&infos[x>>1], s[sp-1])
+			heap.Release(s[sp-1])
+			s[sp-1] = Int(n)
+		case OpAppend:
+			x, pc = decode(code, pc)
+			sp--
+			s[sp-1] = heap.Append(types.Tag(x), s[sp-1], s[sp])
+		case OpSlice:
+			var y uint64
+			x, pc = decode(code, pc)
+			y, pc = decode(code, pc)
+			sp -= 2
+			s[sp-1] = vm.slice(&infos[x>>1], types.Tag(y), s[sp-1], s[sp].Int(), s[sp+1].Int())
+
+		case OpWrap:
+			var i uint64
+			x, pc = decode(code, pc)
+			i, pc = decode(code, pc)
+			obj := heap.New(types.Tag(x), 1)
+			obj.Elems[0] = s[sp-1]
+			s[sp-1] = Value{N: i, R: obj}
+		case OpTag:
+			v := Value{N: s[sp-1].N}
+			heap.Release(s[sp-1])
+			s[sp-1] = v
+		case OpPayload:
+			var k uint64
+			x, pc = decode(code, pc)
+			k, pc = decode(code, pc)
+			if s[sp-1].N != x {
+				panic(trap(consts[k].Value.Str()))
+			}
+			v := s[sp-1].R.(*Object).Elems[0]
+			heap.Retain(v)
+			heap.Release(s[sp-1])
+			s[sp-1] = v
+
+		case OpCall:
+			x, pc = decode(code, pc)
+			callee = int(x)
+		case OpCallValue:
+			x, pc = decode(code, pc)
+			sp--
+			f := s[sp]
+			callee = int(f.N)
+			if obj := f.Object(); obj != nil {
+				// a closure passes the values it captures ahead of the
+				// arguments
+				k, args := len(obj.Elems), sp-int(x)
+				s = vm.grow(sp, sp+k)
+				copy(s[args+k:], s[args:sp])
+				for i, v := range obj.Elems {
+					heap.Retain(v)
+					s[args+i] = v
+				}
+				sp += k
+				heap.Release(f)
+			}
+
+		case OpJump:
+			pc = int(binary.LittleEndian.Uint32(code[pc:]))
+		case OpBrFalse:
+			sp--
+			if s[sp].N == 0 {
+				pc = int(binary.LittleEndian.Uint32(code[pc:]))
+			} else {
+				pc += 4
+			}
+		case OpRet:
+			var r Value
+			if fn.Result {
+				sp--
+				r = s[sp]
+			}
+			for _, v := range s[base:sp] {
+				heap.Release(v)
+			}
+			if len(frames) == 0 {
+				return r, nil
+			}
+			sp = base
+			if fn.Result {
+				s[sp] = r
+				sp++
+			}
+			f := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			fn, pc, base, code = f.fn, f.pc, f.base, f.fn.Code
+		case OpTrap:
+			x, pc = decode(code, pc)
+			panic(trap(consts[x].Value.Str()))
+		default:
+			panic(trap(fmt.Sprintf("invalid opcode %d", op)))
+		}
+		if callee < 0 {
+			continue
+		}
+		next := funcs[callee]
+		if next.Host {
+			sp -= next.Params
+			args := s[sp : sp+next.Params]
+			r := vm.host[callee](vm, args)
+			for _, arg := range args {
+				heap.Release(arg)
+			}
+			if next.Result {
+				s[sp] = r
+				sp++
+			}
+			continue
+		}
+		if len(frames) >= maxDepth {
+			panic(trap(fmt.Sprintf("call stack exceeded %d nested calls", maxDepth)))
+		}
+		frames = append(frames, frame{fn, pc, base})
+		base = sp - next.Params
+		s = vm.grow(sp, base+next.Locals+next.MaxStack)
+		clear(s[sp : base+next.Locals])
+		fn, code, pc, sp = next, next.Code, 0, base+next.Locals
+	}
+}
@@ -0,0 +1,358 @@
+// Package cgen translates modules of the IR into C99 programs, which the C
+// compiler of the host turns into native executables.
+//
+// A program is a single C file: the runtime of runtime.h, which provides
+// strings, dynamic arrays, the heap, the text of values and the host
+// function print, followed by the types, constants and functions of the
+// module, and a C main function calling the module's main function if it
+// has one. Values are held as follows:
+//
+//   - integers, floats and Bools as the C types of their size, Float16 as
+//     float and enums as int64_t;
+//   - strings and symbols as pointers to their length and bytes, and the
+//     errors returned by checked arithmetic as their messages;
+//   - dynamic arrays as pointers to their length and elements, and
+//     fixed-size arrays as structs holding a C array;
+//   - tuples as structs whose fields are ordered as package layout orders
+//     them, which the C compiler is made to check;
+//   - unions as structs holding the index of the member held, the tag,
+//     then a C union of the members;
+//   - the values of recursive types that tuples, unions and arrays hold
+//     as boxes, pointers to objects holding copies of the values;
+//   - functions as pairs of a pointer to code and a pointer to the
+//     environment it runs in;
+//   - the values of typeof as int32_t indices into a table of the texts
+//     of the types they describe.
+//
+// The code of a function value is a trampoline taking the environment
+// ahead of the arguments and calling the function. The environment of a
+// closure holds the values it captured, which its trampoline passes ahead
+// of the arguments; the environment of any other function is null.
+// Tuples and unions are held by value, so the tuples the escape pass keeps
+// in the frame need nothing more. The phis of a block are assigned on each
+// edge into it, through a copy of each, so that they are assigned all at
+// once. Integer arithmetic detects overflow with the __builtin_*_overflow
+// functions of GCC and Clang; an overflow traps, or for the checked
+// operators takes the overflow edge.
+//
+// Strings, dynamic arrays, boxes and the environments of closures are
+// objects of the heap, whose references are counted: each variable holds a
+// reference to the objects its value refers to, which it releases when it
+// is assigned again or its function returns, and the visit helper of a
+// type finds the objects its values refer to. The runtime frees the
+// objects no longer referenced, and collects the cycles closures capturing
+// themselves form; the main function collects those left when it returns,
+// and writes the statistics of the heap to stderr if $TUP_MEMSTATS is set.
+package cgen
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+//go:embed runtime.h
+var runtime string
+
+// Error reports a module that cannot be translated, such as one using a
+// type that has no C form.
+type Error struct {
+	Msg string
+}
+
+func (err *Error) Error() string { return "cgen: " + err.Msg }
+
+// genBailout is panicked with to abandon generation after an error.
+type genBailout struct{ err *Error }
+
+// Generate writes the C program translating m, which must have been
+// verified, to w.
+func Generate(w io.Writer, m *ir.Module) (err error) {
+	g := &generator{module: m, literals: map[string]string{}, byName: map[string]*funcValue{}}
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(genBailout)
+			if !ok {
+				panic(r)
+			}
+			err = b.err
+		}
+	}()
+	g.generate()
+	_, err = io.WriteString(w, g.String())
+	return err
+}
+
+type generator struct {
+	module *ir.Module
+	// the C names of the types and helper functions defined, in order
+	types   []*typedef
+	helpers []*helper
+	// the names of the string constants, by their contents
+	literals map[string]string
+	// the functions taken as values, in order, by name
+	values []*funcValue
+	byName map[string]*funcValue
+	// the types described by the values of typeof, by index
+	described []types.Type
+
+	// the sections of the program, in order
+	typedefs, consts, protos, defs, funcs strings.Builder
+}
+
+func (g *generator) errorf(format string, args ...any) {
+	panic(genBailout{&Error{Msg: fmt.Sprintf(format, args...)}})
+}
+
+func (g *generator) generate() {
+	for _, fn := range g.module.Funcs {
+		if fn.Extern() {
+			if hostFuncs[fn.Name] == "" {
+				g.errorf("host function %s is not provided by the C runtime", fn.Name)
+			}
+			continue
+		}
+		fn.Update()
+		fmt.Fprintf(&g.protos, "%s;\n", g.signature(fn))
+	}
+	// the trampolines are defined before the functions, so that the text
+	// of a function value finds them all
+	for _, fn := range g.module.Funcs {
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Op == ir.OpFunc {
+					g.funcValue(instr)
+				}
+			}
+		}
+	}
+	for _, fn := range g.module.Funcs {
+		if !fn.Extern() {
+			g.function(fn)
+		}
+	}
+	if len(g.described) > 0 {
+		texts := make([]string, len(g.described))
+		for i, t := range g.described {
+			texts[i] = quote((&consteval.TypeValue{Typ: t}).String())
+		}
+		fmt.Fprintf(&g.consts, "static const char *const tup_types[] = {%s};\n", strings.Join(texts, ", "))
+	}
+	if main := g.module.Func("main"); main != nil {
+		if main.Extern() || len(main.Params) > 0 {
+			g.errorf("main must be a function without parameters")
+		}
+		fmt.Fprintf(&g.funcs, "\nint main(void)\n{\n\t%s();\n\ttup_exit();\n\treturn 0;\n}\n", funcName(main.Name))
+	}
+}
+
+// String returns the program generated.
+func (g *generator) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "/* Code generated by tup build from module %s. DO NOT EDIT. */\n\n", g.module.Name)
+	b.WriteString(runtime)
+	for _, section := range []*strings.Builder{&g.typedefs, &g.consts, &g.protos, &g.defs, &g.funcs} {
+		// functions begin with a blank line of their own
+		if s := section.String(); s != "" {
+			if !strings.HasPrefix(s, "\n") {
+				b.WriteString("\n")
+			}
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
+
+// hostFuncs maps the host functions the runtime provides to their C names.
+var hostFuncs = map[string]string{
+	"print": "tup_print",
+}
+
+// signature returns the C declarator of fn.
+func (g *generator) signature(fn *ir.Func) string {
+	params := make([]string, len(fn.Params))
+	for i, p := range fn.Params {
+		params[i] = g.ctype(p.Typ) + " " + paramName(p.Name)
+	}
+	if len(params) == 0 {
+		params = []string{"void"}
+	}
+	return fmt.Sprintf("static %s %s(%s)", g.result(fn.Sig), funcName(fn.Name), strings.Join(params, ", "))
+}
+
+// result returns the C type of the result of sig, or void.
+func (g *generator) result(sig *types.Function) string {
+	if sig.Result == nil {
+		return "void"
+	}
+	return g.ctype(sig.Result)
+}
+
+// descriptor returns the index of the type t among those described by the
+// values of typeof.
+func (g *generator) descriptor(t types.Type) int {
+	for i, d := range g.described {
+		if types.Identical(d, t) {
+			return i
+		}
+	}
+	g.described = append(g.described, t)
+	return len(g.described) - 1
+}
+
+// funcName returns the C name of the function named name.
+func funcName(name string) string { return mangle("f", name) }
+
+// callee returns the C name of the function named name, or of the host
+// function it declares.
+func (g *generator) callee(name string) string {
+	if fn := g.module.Func(name); fn != nil && fn.Extern() {
+		return hostFuncs[name]
+	}
+	return funcName(name)
+}
+
+// funcValue is a function of the module taken as a value: of type typ,
+// by OpFunc instructions passing the first captures parameters of fn.
+type funcValue struct {
+	fn       *ir.Func
+	typ      types.Type
+	captures int
+	// the C names of its trampoline and of the environment of a closure
+	code, env string
+	// the C name of the function visiting the references its environment
+	// holds, or NULL, and whether the environment may be part of a cycle
+	visit  string
+	cyclic bool
+}
+
+// funcValue returns the function value of the OpFunc instr, defining its
+// trampoline, and its environment if it is a closure, first if need be.
+func (g *generator) funcValue(instr *ir.Instr) *funcValue {
+	if v := g.byName[instr.Callee]; v != nil {
+		return v
+	}
+	fn := g.module.Func(instr.Callee)
+	v := &funcValue{fn: fn, typ: instr.Typ, captures: len(instr.Args), code: mangle("fw", fn.Name), visit: "NULL"}
+	if instr.Rec {
+		v.captures++
+	}
+	g.values = append(g.values, v)
+	g.byName[fn.Name] = v
+	params := []string{"void *env"}
+	args := make([]string, len(fn.Params))
+	for i, p := range fn.Params {
+		if i < v.captures {
+			args[i] = fmt.Sprintf("e->c%d", i)
+			continue
+		}
+		args[i] = paramName(p.Name)
+		params = append(params, g.ctype(p.Typ)+" "+args[i])
+	}
+	var body strings.Builder
+	if v.captures == 0 {
+		body.WriteString("\t(void)env;\n")
+	} else {
+		v.env = mangle("fe", fn.Name)
+		var fields, visits strings.Builder
+		for i, p := range fn.Params[:v.captures] {
+			fmt.Fprintf(&fields, "\t%s c%d;\n", g.ctype(p.Typ), i)
+			if stmt := g.visit(p.Typ, fmt.Sprintf("e->c%d", i), "f"); stmt != "" {
+				fmt.Fprintf(&visits, "\t%s\n", stmt)
+			}
+			v.cyclic = v.cyclic || cyclic(p.Typ)
+		}
+		fmt.Fprintf(&g.typedefs, "typedef struct {\n%s} %s;\n", fields.String(), v.env)
+		fmt.Fprintf(&body, "\t%s *e = env;\n", v.env)
+		if visits.Len() > 0 {
+			v.visit = mangle("fv", fn.Name)
+			proto := fmt.Sprintf("static void %s(const void *obj, tup_visitor f)", v.visit)
+			fmt.Fprintf(&g.protos, "%s;\n", proto)
+			fmt.Fprintf(&g.defs, "\n%s\n{\n\tconst %s *e = obj;\n%s}\n", proto, v.env, visits.String())
+		}
+	}
+	call := fmt.Sprintf("%s(%s)", g.callee(fn.Name), strings.Join(args, ", "))
+	if fn.Sig.Result == nil {
+		fmt.Fprintf(&body, "\t%s;\n", call)
+	} else {
+		fmt.Fprintf(&body, "\treturn %s;\n", call)
+	}
+	proto := fmt.Sprintf("static %s %s(%s)", g.result(fn.Sig), v.code, strings.Join(params, ", "))
+	fmt.Fprintf(&g.protos, "%s;\n", proto)
+	fmt.Fprintf(&g.defs, "\n%s\n{\n%s}\n", proto, body.String())
+	return v
+}
+
+// paramName returns the C name of the parameter named name.
+func paramName(name string) string { return mangle("a", name) }
+
+// mangle returns a C identifier for the Tuppence identifier name, which
+// may end with ? or !: prefix_name. Identifiers with other characters than
+// letters, digits and underscores are written prefixx followed by the name
+// with those characters and underscores escaped, so that no two names
+// collide.
+func mangle(prefix, name string) string {
+	clean := true
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i]) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return prefix + "_" + name
+	}
+	var b strings.Builder
+	b.WriteString(prefix)
+	b.WriteString("x")
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; isIdentChar(c) && c != '_' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// literal returns a pointer to a string constant holding s.
+func (g *generator) literal(s string) string {
+	name, ok := g.literals[s]
+	if !ok {
+		name = "tup_lit" + strconv.Itoa(len(g.literals))
+		g.literals[s] = name
+		fmt.Fprintf(&g.consts, "static struct tup_literal %s = {TUP_STATIC, {%d, %s}};\n", name, len(s), quote(s))
+	}
+	return "&" + name + ".s"
+}
+
+// quote returns s as a C string literal. Bytes other than printable ASCII
+// are written in octal, and question marks escaped, which could otherwise
+// begin trigraphs.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\' || c == '?':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "\\%03o", c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
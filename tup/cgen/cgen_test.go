@@ -0,0 +1,309 @@
+package cgen
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/internal/testutil"
+	"github.com/rowland/tuppence/tup/ir"
+)
+
+// generate returns the C program translating input.
+func generate(t *testing.T, input string) string {
+	t.Helper()
+	m, err := testutil.Lower(t, "test.tup", input)
+	if err != nil {
+		t.Fatalf("lower(%q) = %v", input, err)
+	}
+	var src bytes.Buffer
+	if err := Generate(&src, m); err != nil {
+		t.Fatalf("Generate() = %v", err)
+	}
+	return src.String()
+}
+
+// requireCC returns the C compiler, skipping the test if there is none.
+func requireCC(t *testing.T) string {
+	t.Helper()
+	cc, err := exec.LookPath(CC())
+	if err != nil {
+		t.Skipf("no C compiler: %v", err)
+	}
+	return cc
+}
+
+// run compiles the C program src and runs it, returning what it wrote to
+// stdout and stderr and how it exited. The program writes the statistics
+// of its heap to stderr when it ends.
+func run(t *testing.T, cc, src string) (stdout, stderr string, err error) {
+	t.Helper()
+	exe := filepath.Join(t.TempDir(), "prog")
+	if err := Compile(cc, "test", []byte(src), exe); err != nil {
+		t.Fatalf("Compile() = %v", err)
+	}
+	var out, errOut bytes.Buffer
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), "TUP_MEMSTATS=1")
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err = cmd.Run()
+	return out.String(), errOut.String(), err
+}
+
+const errorDecls = "E1 = error(message: String)\n" +
+	"E2 = error(code: Int)\n" +
+	"classify = fn(n: Int) !Int {\n\tif n < 0 { E1(\"negative\") } else if n == 0 { E2(0) } else { n }\n}\n"
+
+// TestRun compiles each program and checks what its main function prints,
+// which is what the interpreter prints.
+func TestRun(t *testing.T) {
+	cc := requireCC(t)
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"print", "main = fx() { print(1, \"a\", [1, 2], (x: 1)) }", "1 a [1, 2] (x: 1)\n"},
+		{"print in loop", "main = fx() {\n\tfor i in 1..3 { print(i) }\n}", "1\n2\n3\n"},
+		{"interpolation", "main = fx() {\n\tname = \"World\"\n\tprint(\"Hello, \\(name)!\")\n}", "Hello, World!\n"},
+		{"multi-line strings", "shout = fn(s: String) String { s + \"!\" }\ngreeting = ```\n    Hello,\n    world\n```\nloud = ```shout\n    hey\n```\nmain = fx() { print(greeting, loud) }", "Hello,\nworld\n hey\n!\n"},
+		{"floats", "f = fx(x: Float) Float { x }\nmain = fx() { print(f(0.1) + f(0.2), f(1.5) * f(2.0), f(1e6), f(1e-5), f(123456.5), f(-0.25)) }",
+			"0.30000000000000004 3.0 1e+06 1e-05 123456.5 -0.25\n"},
+		{"integer types", "f = fx(x: Int8) Int8 { x }\ng = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(f(0) - f(100) - f(28), f(100) / (f(0) - f(3)), f(7) % (f(0) - f(2)), g(4294967296) * g(4294967295)) }",
+			"-128 -33 1 18446744069414584320\n"},
+		{"power and shifts", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(3) ^ f(4), f(1) << f(62), f(-16) >> f(2)) }", "81 4611686018427387904 -4\n"},
+		{"bitwise", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(12) & f(10), f(12) | f(10), ~f(0)) }", "8 14 -1\n"},
+		{"nested strings are quoted", "main = fx() { print([\"a\\tb\", \"\\\"q\\\"\"], (\"x\", 1)) }", "[\"a\\tb\", \"\\\"q\\\"\"] (\"x\", 1)\n"},
+		{"strings", "f = fx(s: String) String { s }\nmain = fx() {\n\ts = f(\"abc\")\n\tprint(s + \"def\", len(s), s[1], s < \"abd\", s == \"abc\")\n}",
+			"abcdef 3 98 true true\n"},
+		{"symbols and nil", "main = fx() { print(:ok, nil, true) }", ":ok nil true\n"},
+		{"recursion", "fact = fn(n: Int) Int { if n <= 1 { 1 } else { n * fact(n - 1) } }\nmain = fx() { print(fact(20)) }", "2432902008176640000\n"},
+		{"function value", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\ninc = fn(n: Int) Int { n + 1 }\nmain = fx() { print(apply(41, inc), inc) }", "42 inc\n"},
+		{"typeof", "Circle = type(r: Int)\nSquare = type(s: Int)\nShape = Circle | Square\nf = fx(s: Shape) Shape { s }\n" +
+			"is_circle = fn(s: Shape) Bool { typeof(s) == typeof(Circle) }\n" +
+			"main = fx() { print(is_circle(f(Circle(1))), is_circle(f(Square(2))), typeof(f(Square(2))), (typeof(Circle), 1)) }",
+			"true false typeof(Square) (typeof(Circle), 1)\n"},
+		{"slices", "f = fx(xs: []Int) []Int { xs }\ng = fx(s: String) String { s }\n" +
+			"main = fx() {\n\txs = f([1, 2, 3, 4])\n\tys = xs[1..2]\n\tprint(ys, xs[2..1], g(\"hello\")[1..3], [2]String[\"a\", \"b\"][0..0], ys << 5, xs)\n}",
+			"[2, 3] [] ell [\"a\"] [2, 3, 5] [1, 2, 3, 4]\n"},
+		{"closures", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\nadd = fn(a: Int, b: Int) Int { a + b }\n" +
+			"f = fn(k: Int) Int { apply(3) { apply(it) { |m| m * k + it } } }\n" +
+			"g = fn(k: Int) fn(Int) Int { add(k, *) }\n" +
+			"h = fn(k: Int) Int {\n\tsum = fn(n: Int) Int { if n == 0 { k } else { sum(n - 1) + n } }\n\tsum(4)\n}\n" +
+			"main = fx() {\n\tinc = g(1)\n\tprint(f(2), inc(41), h(10), inc, apply(1) { it + 1 })\n}",
+			"9 42 20 fn { ... } 2\n"},
+		{"labeled tuple", "Point = type(x: Int, y: Int)\nmove = fn(p: Point, dx: Int) Point { p.(x: p.x + dx) }\nmain = fx() { print(move(Point(1, 2), 3)) }", "(x: 4, y: 2)\n"},
+		{"tuple update", "Point = type(x: Int, y: Int)\nmain = fx() {\n\tp = Point(1, 2)\n\tq = p.(y: 5)\n\tprint(p)\n\tprint(q)\n}", "(x: 1, y: 2)\n(x: 1, y: 5)\n"},
+		{"tuple layout", "Mixed = type(a: Int8, b: Int64, c: Int16, d: String)\nf = fx(m: Mixed) Mixed { m }\nmain = fx() { print(f(Mixed(1, 2, 3, \"d\"))) }",
+			"(a: 1, b: 2, c: 3, d: \"d\")\n"},
+		{"tuple equality", "Point = type(x: Int, y: Int)\nf = fx(p: Point) Point { p }\nmain = fx() { print(f(Point(1, 2)) == Point(1, 2), f(Point(1, 2)) != Point(2, 1)) }", "true true\n"},
+		{"arrays", "f = fx(xs: []Int) []Int { xs }\nmain = fx() {\n\txs = f([1, 2, 3])\n\tys = xs << 4\n\tzs = xs << 5\n\tprint(ys, zs, len(ys), xs[2], xs == [1, 2, 3])\n}",
+			"[1, 2, 3, 4] [1, 2, 3, 5] 4 3 true\n"},
+		{"nested arrays", "f = fx(xs: [][]Int) [][]Int { xs }\nmain = fx() { print(f([[1], [2, 3]])) }", "[[1], [2, 3]]\n"},
+		{"enum", "Color = enum(\n\tred\n\tgreen\n\tblue\n)\nf = fx(c: Color) Color { c }\nmain = fx() { print(f(Color.green), f(Color.blue).int(), f(Color.red).string()) }",
+			"Color.green 2 red\n"},
+		{"checked arithmetic", "f = fx(x: Int8) Int8 { x }\nmain = fx() { print(f(100) ?+ f(27), f(100) ?+ f(100), f(1) ?/ f(0)) }",
+			"127 error(\"integer overflow\") error(\"division by zero\")\n"},
+		{"integer conversions", "f = fx(x: Int) Int { x }\ng = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(Int8(f(-5)), UInt8(f(200)), Int(g(7)), UInt64(f(9)), Int16(Int8(f(-3))), UInt64(~g(0))) }",
+			"-5 200 7 9 -3 18446744073709551615\n"},
+		{"errors", errorDecls + "main = fx() { print(classify(-1), classify(0), classify(3)) }", "(message: \"negative\") (code: 0) 3\n"},
+		{"try", errorDecls + "f = fn(n: Int) !Int {\n\tv = try classify(n)\n\tv + 1\n}\nmain = fx() { print(f(41), f(-1)) }", "42 (message: \"negative\")\n"},
+		{"switch on union", errorDecls + "f = fn(n: Int) String {\n\tv = classify(n)\n\tswitch v {\n\t\tE1 { \"E1\" }\n\t\tE2 { \"E2\" }\n\t\tInt { \"Int\" }\n\t}\n}\nmain = fx() { print(f(-1), f(0), f(1)) }",
+			"E1 E2 Int\n"},
+		{"loop", "sum = fn(ns: ...Int) Int { for s = 0; n in ns { s + n } }\nmain = fx() { print(sum(1, 2, 3, 4)) }", "10\n"},
+		{"fib", "fib = fn(n: Int) []Int {\n\tfor (a, b, acc) = (0, 1, Int[]); len(acc) < n {\n\t\t(b, a + b, acc << a)\n\t}.2\n}\nmain = fx() { print(fib(12)) }",
+			"[0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89]\n"},
+		{"generics", "id[a]: fn(x: a) a { x }\npair[a, b]: fn(x: a, y: b) (a, b) { (x, y) }\nNumeric[a] = contract(\n\tmul[a] = fn(a, a) a\n)\nsqr[a]: fn(x: Numeric[a]) a { x * x }\nmain = fx() { print(id(1), id(\"s\"), pair(1, \"x\"), sqr(3), sqr(1.5)) }",
+			"1 s (1, \"x\") 9 2.25\n"},
+		{"recursive types", "IntCons = type(head: Int, tail: IntList)\nIntList = Nil | IntCons\n" +
+			"range = fn(n: Int) IntList { if n == 0 { nil } else { IntCons(n, range(n - 1)) } }\n" +
+			"sum = fn(l: IntList) Int {\n\tswitch l {\n\t\tIntCons { |c| c.head + sum(c.tail) }\n\t\tNil { 0 }\n\t}\n}\n" +
+			"main = fx() {\n\tl = range(3)\n\tprint(l, sum(l), l == range(3), [l] << range(1))\n}",
+			"(head: 3, tail: (head: 2, tail: (head: 1, tail: nil))) 6 true [(head: 3, tail: (head: 2, tail: (head: 1, tail: nil))), (head: 1, tail: nil)]\n"},
+		{"generic recursive types", "Cons[a] = type(head: a, tail: List[a])\nList[a] = Nil | Cons[a]\n" +
+			"map[a, b]: fn(list: List[a], f: fn(a) b) List[b] {\n\tswitch list {\n\t\tNil { nil }\n\t\tCons { |c| Cons(head: f(c.head), tail: map(c.tail, f)) }\n\t}\n}\n" +
+			"main = fx() { print(map(Cons(1, Cons(2, nil))) { \"<\\(it)>\" }) }",
+			"(head: \"<1>\", tail: (head: \"<2>\", tail: nil))\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := generate(t, test.input)
+			stdout, stderr, err := run(t, cc, src)
+			if err != nil {
+				t.Fatalf("program failed: %v\n%s", err, stderr)
+			}
+			if stdout != test.want {
+				t.Errorf("program wrote %q, want %q", stdout, test.want)
+			}
+			if !strings.Contains(stderr, " live 0 ") {
+				t.Errorf("objects live after running, want none: %s", stderr)
+			}
+		})
+	}
+}
+
+// TestMemory checks that programs free the objects they allocate, the
+// closures of recursive local functions, which capture themselves, by
+// collecting cycles.
+func TestMemory(t *testing.T) {
+	cc := requireCC(t)
+	tests := []struct {
+		name  string
+		input string
+		want  string // part of the statistics of the heap
+	}{
+		{"strings in a loop", "f = fx(s: String) String { s }\nmain = fx() {\n\ts = for s = f(\"\"); i in 1..100 { s + \"\\(i)\" }\n\tprint(len(s))\n}",
+			"live 0 (max 3), collections 0"},
+		{"arrays in a loop", "main = fx() {\n\txs = for xs = String[]; i in 1..100 { xs << \"\\(i)\" }\n\tprint(len(xs[10..20]))\n}", "live 0"},
+		{"cycles", "mk = fn(k: Int) fn(Int) Int {\n\tsum = fn(n: Int) Int { if n == 0 { k } else { sum(n - 1) + n } }\n\tsum\n}\n" +
+			"main = fx() {\n\tt = for t = 0; i in 1..10 {\n\t\tf = mk(i)\n\t\tt + f(3)\n\t}\n\tprint(t)\n}",
+			"live 0 (max 11), collections 1 (freed 10)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := generate(t, test.input)
+			_, stderr, err := run(t, cc, src)
+			if err != nil {
+				t.Fatalf("program failed: %v\n%s", err, stderr)
+			}
+			if !strings.Contains(stderr, test.want) {
+				t.Errorf("program wrote %q to stderr, want %q", stderr, test.want)
+			}
+		})
+	}
+}
+
+// TestTraps checks that operations that trap stop the program with a
+// runtime error.
+func TestTraps(t *testing.T) {
+	cc := requireCC(t)
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"overflow", "f = fx(x: Int8) Int8 { x }\nmain = fx() { print(f(100) + f(100)) }", "runtime error: integer overflow"},
+		{"negation overflow", "f = fx(x: Int) Int { x }\nmain = fx() { print(-(f(-9223372036854775807) - 1)) }", "runtime error: integer overflow"},
+		{"conversion overflow", "f = fx(x: Int) Int { x }\nmain = fx() { print(Int8(f(200))) }", "runtime error: integer overflow"},
+		{"negative to unsigned", "f = fx(x: Int) Int { x }\nmain = fx() { print(UInt(f(-1))) }", "runtime error: integer overflow"},
+		{"unsigned to signed", "g = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(Int(~g(0))) }", "runtime error: integer overflow"},
+		{"division by zero", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(1) / f(0)) }", "runtime error: division by zero"},
+		{"index out of range", "f = fx(xs: []Int) []Int { xs }\nmain = fx() { print(f([1, 2])[2]) }", "runtime error: index 2 out of range [0:2]"},
+		{"slice out of range", "f = fx(xs: []Int) []Int { xs }\nmain = fx() { print(f([1, 2])[1..2]) }", "runtime error: slice [1..2] out of range [0:2]"},
+		{"output before trap", "f = fx(x: Int) Int { x }\nmain = fx() {\n\tprint(1)\n\tprint(f(1) / f(0))\n}", "division by zero"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := generate(t, test.input)
+			_, stderr, err := run(t, cc, src)
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) {
+				t.Fatalf("program exited with %v, want a runtime error", err)
+			}
+			if !strings.Contains(stderr, test.wantErr) {
+				t.Errorf("program wrote %q to stderr, want %q", stderr, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestGenerate checks the C the types and operations of each program
+// translate to.
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string // substrings of the program
+	}{
+		{"fields by size", "Mixed = type(a: Int8, b: Int64, c: Int16)\nf = fn(m: Mixed) Int16 { m.c }",
+			[]string{"\tint64_t f1; /* b */\n\tint16_t f2; /* c */\n\tint8_t f0; /* a */\n} tn_Mixed;",
+				"TUP_LAYOUT(tn_Mixed, 16, offsetof(tn_Mixed, f1) == 0 && offsetof(tn_Mixed, f2) == 8 && offsetof(tn_Mixed, f0) == 10);"}},
+		{"fields by label", "P = type(y: Int, x: Int)\nf = fn(p: P) Int { p.x }", []string{"\tint64_t f1; /* x */\n\tint64_t f0; /* y */\n"}},
+		{"cstruct", "@cstruct\nC = type(a: Int8, b: Int64)\nf = fn(c: C) Int64 { c.b }",
+			[]string{"\tint8_t f0; /* a */\n\tint64_t f1; /* b */\n", "offsetof(tn_C, f1) == 8"}},
+		{"union", "IS = Int | String\nf = fn(x: Int, b: Bool) IS { if b { x } else { \"s\" } }",
+			[]string{"\tint32_t tag;\n\tunion {\n\t\tint64_t m0; /* Int */\n\t\ttup_string m1; /* String */\n\t} u;", "offsetof(tn_IS, u) == 8", ".tag = 1, .u.m1 ="}},
+		{"checked", "f = fn(a: Int, b: Int) Int | error { a ?* b }", []string{"if (__builtin_mul_overflow(a_a, a_b, &v"}},
+		{"phis", "f = fn(n: Int) Int { for s = 0; i in 0..n { s + i } }", []string{"\tint64_t p", "= p"}},
+		{"mangled names", "ok? = fx(n: Int) Bool { n > 0 }\nf = fx(n: Int) Bool { ok?(n) }", []string{"static bool fxok_3f(int64_t a_n);", "= fxok_3f(a_n);"}},
+		{"no main", "f = fn(n: Int) Int { n }", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := generate(t, test.input)
+			for _, want := range test.want {
+				if !strings.Contains(src, want) {
+					t.Errorf("program does not contain %q:\n%s", want, src[len(runtime):])
+				}
+			}
+			if strings.Contains(src, "int main(void)") {
+				t.Errorf("program without a main function has a C main function")
+			}
+		})
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"host function", "module m\n\ndeclare fx @read() String\n\nfx @f() String {\nb0:\n  %0 = call fx String @read()\n  ret %0\n}\n",
+			"host function read is not provided by the C runtime"},
+		{"main with parameters", "module m\n\nfx @main(%n: Int) {\nb0:\n  ret\n}\n", "main must be a function without parameters"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ir.Parse(test.input)
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			err = Generate(&bytes.Buffer{}, m)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Generate() = %v, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestExamples compiles each example the IR can lower, runs those with a
+// main function and compares their output with the interpreter's golden
+// output in ../interp/testdata/<name>.out.
+func TestExamples(t *testing.T) {
+	cc := requireCC(t)
+	testutil.Examples(t, testutil.Lower, func(t *testing.T, name string, m *ir.Module) {
+		var src bytes.Buffer
+		if err := Generate(&src, m); err != nil {
+			t.Fatalf("Generate() = %v", err)
+		}
+		if m.Func("main") == nil {
+			obj := filepath.Join(t.TempDir(), name+".c")
+			if err := os.WriteFile(obj, src.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if out, err := exec.Command(cc, "-std=c99", "-fsyntax-only", obj).CombinedOutput(); err != nil {
+				t.Fatalf("%s: %v\n%s", cc, err, out)
+			}
+			return
+		}
+		stdout, stderr, err := run(t, cc, src.String())
+		if err != nil {
+			t.Fatalf("%s failed: %v\n%s", name, err, stderr)
+		}
+		want, err := os.ReadFile(filepath.Join("..", "interp", "testdata", name+".out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stdout != string(want) {
+			t.Errorf("%s wrote:\n%s\nwant:\n%s", name, stdout, want)
+		}
+		if !strings.Contains(stderr, " live 0 ") {
+			t.Errorf("objects live after running, want none: %s", stderr)
+		}
+	})
+}
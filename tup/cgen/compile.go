@@ -0,0 +1,36 @@
+package cgen
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// CC returns the C compiler to use: $CC, or cc.
+func CC() string {
+	if cc := os.Getenv("CC"); cc != "" {
+		return cc
+	}
+	return "cc"
+}
+
+// Compile compiles the C program src, generated from the module named name,
+// into the executable output with the C compiler cc. The source is written
+// to a temporary directory, which is removed afterwards.
+func Compile(cc, name string, src []byte, output string) error {
+	dir, err := os.MkdirTemp("", "tup-build-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, name+".c")
+	if err := os.WriteFile(file, src, 0o644); err != nil {
+		return err
+	}
+	cmd := exec.Command(cc, "-std=c99", "-O2", "-o", output, file, "-lm")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v\n%s", cc, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,542 @@
+package cgen
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/layout"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// funcGen generates the C function translating a function of the module.
+// Each value is held in a local variable, v<id>, and each phi also in a
+// copy, p<id>, assigned on the edges into its block; each block begins
+// with the label b<index>.
+//
+// Each variable holds a reference to the objects its value refers to,
+// from its first assignment until it is assigned again or the function
+// returns; the copies of phis and the parameters, which the caller holds,
+// hold none. A function returns its result with references of its own.
+type funcGen struct {
+	*generator
+	fn *ir.Func
+	b  strings.Builder
+	// the values whose variables hold references, and the blocks in loops,
+	// whose variables may be assigned again
+	counted []*ir.Instr
+	loops   map[*ir.Block]bool
+}
+
+func (f *funcGen) printf(format string, args ...any) {
+	fmt.Fprintf(&f.b, format, args...)
+}
+
+func (g *generator) function(fn *ir.Func) {
+	f := &funcGen{generator: g, fn: fn, loops: ir.InLoops(fn)}
+	f.printf("\n%s\n{\n", g.signature(fn))
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Typ == nil {
+				continue
+			}
+			ct := g.ctype(instr.Typ)
+			if counted(instr.Typ) {
+				// the variable holds no reference until it is assigned
+				f.counted = append(f.counted, instr)
+				f.printf("\t%s v%d = %s;\n", ct, instr.ID, zero(instr.Typ))
+			} else {
+				f.printf("\t%s v%d;\n", ct, instr.ID)
+			}
+			if instr.Op == ir.OpPhi {
+				f.printf("\t%s p%d;\n", ct, instr.ID)
+			}
+		}
+	}
+	for _, b := range fn.Blocks {
+		f.block(b)
+	}
+	f.printf("}\n")
+	g.funcs.WriteString(f.b.String())
+}
+
+// zero returns a C initializer for a variable of the counted type t that
+// refers to no object.
+func zero(t types.Type) string {
+	if types.Identical(t, types.ErrorType) {
+		return "NULL"
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return "NULL"
+	case *types.Array:
+		if !u.Fixed() {
+			return "NULL"
+		}
+	}
+	return "{0}"
+}
+
+func (f *funcGen) block(b *ir.Block) {
+	if len(b.Preds) > 0 {
+		f.printf("%s:\n", b)
+	}
+	// the phis take their references before releasing those of their old
+	// values, which may be the new values of other phis
+	phis := b.Phis()
+	for _, phi := range phis {
+		f.stmt(f.visit(phi.Typ, fmt.Sprintf("p%d", phi.ID), "tup_retain"))
+	}
+	for _, phi := range phis {
+		d := fmt.Sprintf("v%d", phi.ID)
+		if f.loops[b] {
+			f.stmt(f.visit(phi.Typ, d, "tup_release"))
+		}
+		f.printf("\t%s = p%d;\n", d, phi.ID)
+	}
+	for _, instr := range b.Instrs[len(phis):] {
+		f.instr(instr)
+	}
+}
+
+// stmt writes the statement s, if any.
+func (f *funcGen) stmt(s string) {
+	if s != "" {
+		f.printf("\t%s\n", s)
+	}
+}
+
+// fresh reports whether the value instr defines comes with references of
+// its own: it is allocated, returned by a call, or constant, which is
+// static.
+func fresh(instr *ir.Instr) bool {
+	switch instr.Op {
+	case ir.OpConst, ir.OpUndef, ir.OpFunc, ir.OpStr, ir.OpCall, ir.OpAppend, ir.OpSlice, ir.OpAdd:
+		return true
+	case ir.OpArray:
+		return !instr.Typ.Underlying().(*types.Array).Fixed()
+	}
+	return false
+}
+
+// value returns the C expression for v.
+func (f *funcGen) value(v ir.Value) string {
+	switch v := v.(type) {
+	case *ir.Param:
+		return paramName(v.Name)
+	case *ir.Instr:
+		return "v" + strconv.Itoa(v.ID)
+	}
+	f.errorf("unexpected value %s", v)
+	return ""
+}
+
+// edge writes the assignments of the phis of to the values flowing in
+// from from, and the jump to to.
+func (f *funcGen) edge(indent string, from, to *ir.Block) {
+	for _, phi := range to.Phis() {
+		for i, pred := range phi.Blocks {
+			if pred == from {
+				f.printf("%sp%d = %s;\n", indent, phi.ID, f.value(phi.Args[i]))
+			}
+		}
+	}
+	f.printf("%sgoto %s;\n", indent, to)
+}
+
+func (f *funcGen) instr(instr *ir.Instr) {
+	var x, y string
+	if len(instr.Args) > 0 {
+		x = f.value(instr.Args[0])
+	}
+	if len(instr.Args) > 1 {
+		y = f.value(instr.Args[1])
+	}
+	d := fmt.Sprintf("v%d", instr.ID)
+	assign := func(format string, args ...any) {
+		f.printf("\t%s = %s;\n", d, fmt.Sprintf(format, args...))
+	}
+	owns := instr.Typ != nil && counted(instr.Typ)
+	if owns && f.loops[instr.Block] {
+		f.stmt(f.visit(instr.Typ, d, "tup_release"))
+	}
+
+	switch op := instr.Op; {
+	case op == ir.OpConst:
+		assign("%s", f.constant(instr))
+	case op == ir.OpFunc:
+		v := f.funcValue(instr)
+		if v.env == "" {
+			assign("(%s){%s, 0}", f.ctype(instr.Typ), v.code)
+			break
+		}
+		// the environment of a closure holds the values it captures
+		f.printf("\t{\n\t\t%s *e = tup_new(sizeof *e, %s, %t);\n", v.env, v.visit, v.cyclic)
+		for i, arg := range instr.Args {
+			f.printf("\t\te->c%d = %s;\n", i, f.value(arg))
+			if stmt := f.visit(arg.Type(), f.value(arg), "tup_retain"); stmt != "" {
+				f.printf("\t\t%s\n", stmt)
+			}
+		}
+		f.printf("\t\t%s = (%s){%s, e};\n", d, f.ctype(instr.Typ), v.code)
+		if instr.Rec {
+			f.printf("\t\te->c%d = %s;\n\t\ttup_retain(e);\n", len(instr.Args), d)
+		}
+		f.printf("\t}\n")
+	case op == ir.OpUndef:
+		assign("(%s){0}", f.ctype(instr.Typ))
+	case op.IsBinary():
+		f.arith(instr, d, x, y)
+	case op == ir.OpNeg:
+		if types.IsFloat(instr.Typ) {
+			assign("-%s", x)
+		} else {
+			f.printf("\tif (__builtin_sub_overflow((%s)0, %s, &%s))\n\t\ttup_overflow();\n", f.ctype(instr.Typ), x, d)
+		}
+	case op == ir.OpNot:
+		if types.IsBool(instr.Typ) {
+			assign("!%s", x)
+		} else {
+			assign("(%s)~%s", f.ctype(instr.Typ), x)
+		}
+	case op.IsComparison():
+		assign("%s", f.compare(instr, x, y))
+	case op == ir.OpStr:
+		f.printf("\t{\n\t\ttup_builder sb = {0};\n")
+		f.printf("\t\t%s\n", f.format(instr.Args[0].Type(), "&sb", x, "false"))
+		f.printf("\t\t%s = tup_builder_string(&sb);\n\t}\n", d)
+	case op == ir.OpOrd:
+		assign("%s", x)
+	case op == ir.OpConv:
+		// the builtin reports whether the sum does not fit the type of
+		// the result
+		f.printf("\tif (__builtin_add_overflow(%s, 0, &%s))\n\t\ttup_overflow();\n", x, d)
+
+	case op == ir.OpTuple:
+		tuple := instr.Typ.Underlying().(*types.Tuple)
+		fields := make([]string, len(instr.Args))
+		for i := range instr.Args {
+			fields[i] = fmt.Sprintf(".f%d = %s", i, f.box(tuple.Fields[i].Type, f.value(instr.Args[i])))
+		}
+		if len(fields) == 0 {
+			fields = []string{"0"}
+		}
+		assign("(%s){%s}", f.ctype(instr.Typ), strings.Join(fields, ", "))
+	case op == ir.OpField:
+		field := instr.Args[0].Type().Underlying().(*types.Tuple).Fields[instr.Index]
+		assign("%s", f.unbox(field.Type, fmt.Sprintf("%s.f%d", x, instr.Index)))
+	case op == ir.OpUpdate:
+		field := instr.Typ.Underlying().(*types.Tuple).Fields[instr.Index]
+		assign("%s", x)
+		f.printf("\t%s.f%d = %s;\n", d, instr.Index, f.box(field.Type, y))
+	case op == ir.OpArray:
+		array := instr.Typ.Underlying().(*types.Array)
+		elem := f.slot(array.Elem)
+		if array.Fixed() {
+			elems := make([]string, len(instr.Args))
+			for i, arg := range instr.Args {
+				elems[i] = f.box(array.Elem, f.value(arg))
+			}
+			if len(elems) == 0 {
+				elems = []string{"0"}
+			}
+			assign("(%s){{%s}}", f.ctype(instr.Typ), strings.Join(elems, ", "))
+			break
+		}
+		assign("tup_array_new(%d, %s)", len(instr.Args), f.slabType(array.Elem))
+		for i, arg := range instr.Args {
+			e := fmt.Sprintf("tup_elems(%s, %s)[%d]", d, elem, i)
+			f.printf("\t%s = %s;\n", e, f.box(array.Elem, f.value(arg)))
+			f.stmt(f.visitSlot(array.Elem, e, "tup_retain"))
+		}
+	case op == ir.OpIndex:
+		switch t := instr.Args[0].Type().Underlying().(type) {
+		case *types.Array:
+			if t.Fixed() {
+				assign("%s", f.unbox(t.Elem, fmt.Sprintf("%s.e[tup_index(%s, %d)]", x, y, t.Len)))
+			} else {
+				assign("%s", f.unbox(t.Elem, fmt.Sprintf("tup_elems(%s, %s)[tup_index(%s, %s->len)]", x, f.slot(t.Elem), y, x)))
+			}
+		default:
+			assign("(%s)%s->data[tup_index(%s, %s->len)]", f.ctype(instr.Typ), x, y, x)
+		}
+	case op == ir.OpLen:
+		if t, ok := instr.Args[0].Type().Underlying().(*types.Array); ok && t.Fixed() {
+			assign("INT64_C(%d)", t.Len)
+		} else {
+			assign("%s->len", x)
+		}
+	case op == ir.OpAppend:
+		elem := instr.Typ.Underlying().(*types.Array).Elem
+		if !layout.Recursive(elem) {
+			assign("tup_array_append(%s, &%s, %s)", x, y, f.slabType(elem))
+			f.stmt(f.visit(elem, y, "tup_retain"))
+			break
+		}
+		f.printf("\t{\n\t\ttup_box e = %s;\n", f.box(elem, y))
+		f.printf("\t\t%s = tup_array_append(%s, &e, %s);\n\t\ttup_retain(e);\n\t}\n", d, x, f.slabType(elem))
+	case op == ir.OpSlice:
+		lo, hi := f.value(instr.Args[1]), f.value(instr.Args[2])
+		switch t := instr.Args[0].Type().Underlying().(type) {
+		case *types.Array:
+			if t.Fixed() {
+				assign("tup_elems_slice(%s.e, %s, %s, %d, %s)", x, lo, hi, t.Len, f.slabType(t.Elem))
+			} else {
+				assign("tup_array_slice(%s, %s, %s, %s)", x, lo, hi, f.slabType(t.Elem))
+			}
+		default:
+			assign("tup_string_slice(%s, %s, %s)", x, lo, hi)
+		}
+
+	case op == ir.OpWrap:
+		member := instr.Typ.Underlying().(*types.Union).Members[instr.Index]
+		assign("(%s){.tag = %d, .u.m%d = %s}", f.ctype(instr.Typ), instr.Index, instr.Index, f.box(member, x))
+	case op == ir.OpTag:
+		assign("%s.tag", x)
+	case op == ir.OpPayload:
+		union := instr.Args[0].Type()
+		member := union.Underlying().(*types.Union).Members[instr.Index]
+		f.printf("\tif (%s.tag != %d)\n\t\ttup_panic(%s);\n", x, instr.Index, quote(fmt.Sprintf("%s does not hold %s", union, member)))
+		assign("%s", f.unbox(member, fmt.Sprintf("%s.u.m%d", x, instr.Index)))
+
+	case op == ir.OpCall:
+		f.call(instr, d)
+
+	case op == ir.OpJump:
+		f.edge("\t", instr.Block, instr.Blocks[0])
+	case op == ir.OpBr:
+		f.printf("\tif (%s) {\n", x)
+		f.edge("\t\t", instr.Block, instr.Blocks[0])
+		f.printf("\t}\n")
+		f.edge("\t", instr.Block, instr.Blocks[1])
+	case op == ir.OpRet:
+		// the result takes its references before the variables release
+		// theirs
+		if len(instr.Args) > 0 {
+			f.stmt(f.visit(instr.Args[0].Type(), x, "tup_retain"))
+		}
+		for _, v := range f.counted {
+			f.stmt(f.visit(v.Typ, f.value(v), "tup_release"))
+		}
+		if len(instr.Args) == 0 {
+			f.printf("\treturn;\n")
+		} else {
+			f.printf("\treturn %s;\n", x)
+		}
+	case op == ir.OpTrap:
+		f.printf("\ttup_panic(%s);\n", quote(instr.Msg))
+	case op.IsChecked():
+		f.checked(instr, d, x, y)
+	default:
+		f.errorf("%s is not supported by the C backend", op)
+	}
+	if owns && !fresh(instr) {
+		f.stmt(f.visit(instr.Typ, d, "tup_retain"))
+	}
+}
+
+// arith writes the arithmetic instr, which traps on overflow and division
+// by zero.
+func (f *funcGen) arith(instr *ir.Instr, d, x, y string) {
+	t := instr.Typ
+	assign := func(format string, args ...any) {
+		f.printf("\t%s = %s;\n", d, fmt.Sprintf(format, args...))
+	}
+	bitwise := map[ir.Op]string{ir.OpAnd: "&", ir.OpOr: "|", ir.OpXor: "^"}
+	switch op := instr.Op; {
+	case bitwise[op] != "" && (types.IsBool(t) || types.IsInteger(t)):
+		assign("%s %s %s", x, bitwise[op], y)
+	case op == ir.OpAdd && types.IsString(t):
+		assign("tup_string_concat(%s, %s)", x, y)
+	case types.IsFloat(t):
+		switch op {
+		case ir.OpAdd, ir.OpSub, ir.OpMul:
+			assign("tup_float(%s %s %s)", x, floatOps[op], y)
+		case ir.OpDiv:
+			assign("tup_div_float(%s, %s)", x, y)
+		case ir.OpPow:
+			assign("tup_float(pow(%s, %s))", x, y)
+		default:
+			f.errorf("%s of %s is not supported by the C backend", op, t)
+		}
+	case types.IsInteger(t):
+		min, max := bounds(t)
+		unsigned := types.IsUnsigned(t)
+		ct := f.ctype(t)
+		switch op {
+		case ir.OpAdd, ir.OpSub, ir.OpMul:
+			f.printf("\tif (__builtin_%s_overflow(%s, %s, &%s))\n\t\ttup_overflow();\n", op, x, y, d)
+		case ir.OpDiv:
+			if unsigned {
+				assign("(%s)tup_div_uint(%s, %s)", ct, x, y)
+			} else {
+				assign("(%s)tup_div_int(%s, %s, %s, %s)", ct, x, y, min, max)
+			}
+		case ir.OpMod:
+			if unsigned {
+				assign("(%s)tup_mod_uint(%s, %s)", ct, x, y)
+			} else {
+				assign("(%s)tup_mod_int(%s, %s)", ct, x, y)
+			}
+		case ir.OpPow:
+			if unsigned {
+				assign("(%s)tup_pow_uint(%s, %s, %s)", ct, x, y, max)
+			} else {
+				assign("(%s)tup_pow_int(%s, %s, %s, %s)", ct, x, y, min, max)
+			}
+		case ir.OpShl:
+			if unsigned {
+				assign("(%s)tup_shl_uint(%s, %s, %s)", ct, x, y, max)
+			} else {
+				assign("(%s)tup_shl_int(%s, %s, %s, %s)", ct, x, y, min, max)
+			}
+		case ir.OpShr:
+			if unsigned {
+				assign("(%s)tup_shr_uint(%s, %s)", ct, x, y)
+			} else {
+				assign("(%s)tup_shr_int(%s, %s)", ct, x, y)
+			}
+		}
+	default:
+		f.errorf("%s of %s is not supported by the C backend", instr.Op, t)
+	}
+}
+
+var floatOps = map[ir.Op]string{ir.OpAdd: "+", ir.OpSub: "-", ir.OpMul: "*", ir.OpDiv: "/"}
+
+// checked writes the checked arithmetic instr, which continues with its
+// overflow block in place of trapping.
+func (f *funcGen) checked(instr *ir.Instr, d, x, y string) {
+	t := instr.Typ
+	op := instr.Op.Unchecked()
+	from, ok, overflow := instr.Block, instr.Blocks[0], instr.Blocks[1]
+	fails := func(cond string) {
+		f.printf("\tif (%s) {\n", cond)
+		f.edge("\t\t", from, overflow)
+		f.printf("\t}\n")
+	}
+	switch {
+	case types.IsFloat(t):
+		if op == ir.OpMod {
+			f.errorf("%s of %s is not supported by the C backend", instr.Op, t)
+		}
+		expr := fmt.Sprintf("%s %s %s", x, floatOps[op], y)
+		if op == ir.OpDiv {
+			fails(fmt.Sprintf("%s == 0 || !isfinite(%s)", y, expr))
+		} else {
+			fails(fmt.Sprintf("!isfinite(%s)", expr))
+		}
+		f.printf("\t%s = %s;\n", d, expr)
+	case op == ir.OpDiv || op == ir.OpMod:
+		min, max := bounds(t)
+		switch {
+		case op == ir.OpDiv && min != "":
+			fails(fmt.Sprintf("!tup_div_int_ok(%s, %s, %s, %s)", x, y, min, max))
+			f.printf("\t%s = (%s)tup_div_int(%s, %s, %s, %s);\n", d, f.ctype(t), x, y, min, max)
+		case op == ir.OpMod && min != "":
+			fails(y + " == 0")
+			f.printf("\t%s = (%s)tup_mod_int(%s, %s);\n", d, f.ctype(t), x, y)
+		default:
+			fails(y + " == 0")
+			f.printf("\t%s = %s %s %s;\n", d, x, map[ir.Op]string{ir.OpDiv: "/", ir.OpMod: "%"}[op], y)
+		}
+	case types.IsInteger(t):
+		fails(fmt.Sprintf("__builtin_%s_overflow(%s, %s, &%s)", op, x, y, d))
+	default:
+		f.errorf("%s of %s is not supported by the C backend", instr.Op, t)
+	}
+	f.edge("\t", from, ok)
+}
+
+func (f *funcGen) compare(instr *ir.Instr, x, y string) string {
+	t := instr.Args[0].Type()
+	switch instr.Op {
+	case ir.OpEq:
+		return f.equal(t, x, y)
+	case ir.OpNe:
+		return "!(" + f.equal(t, x, y) + ")"
+	case ir.OpCmp:
+		if types.IsString(t) {
+			return fmt.Sprintf("tup_string_cmp(%s, %s)", x, y)
+		}
+		return fmt.Sprintf("(%s > %s) - (%s < %s)", x, y, x, y)
+	}
+	rel := map[ir.Op]string{ir.OpLt: "<", ir.OpLe: "<=", ir.OpGt: ">", ir.OpGe: ">="}[instr.Op]
+	if types.IsString(t) {
+		return fmt.Sprintf("tup_string_cmp(%s, %s) %s 0", x, y, rel)
+	}
+	return fmt.Sprintf("%s %s %s", x, rel, y)
+}
+
+func (f *funcGen) call(instr *ir.Instr, d string) {
+	args := instr.Args
+	var callee string
+	var values []string
+	if instr.Callee == "" {
+		// a function value runs its code in its environment
+		v := f.value(args[0])
+		callee, values, args = v+".fn", []string{v + ".env"}, args[1:]
+	} else {
+		callee = f.callee(instr.Callee)
+	}
+	for _, arg := range args {
+		values = append(values, f.value(arg))
+	}
+	call := fmt.Sprintf("%s(%s)", callee, strings.Join(values, ", "))
+	if instr.Typ == nil {
+		f.printf("\t%s;\n", call)
+	} else {
+		f.printf("\t%s = %s;\n", d, call)
+	}
+}
+
+// constant returns the C expression for the value of the const instr.
+func (f *funcGen) constant(instr *ir.Instr) string {
+	t := instr.Typ
+	switch c := instr.Const.(type) {
+	case *consteval.Int:
+		if types.IsFloat(t) {
+			return c.Val.String() + ".0"
+		}
+		return intLiteral(t, c.Val)
+	case *consteval.Float:
+		s := strconv.FormatFloat(c.Val, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case consteval.Bool:
+		return strconv.FormatBool(bool(c))
+	case consteval.String:
+		return f.literal(string(c))
+	case consteval.Symbol:
+		return f.literal(string(c))
+	case consteval.Nil:
+		return "0"
+	case *consteval.Enum:
+		return intLiteral(types.Typ[types.Int64], big.NewInt(c.Member.Value))
+	case *consteval.ErrorValue:
+		return f.literal(c.Msg)
+	case *consteval.TypeValue:
+		return strconv.Itoa(f.descriptor(c.Typ))
+	}
+	f.errorf("constant %s has no C form", instr.Const)
+	return ""
+}
+
+// intLiteral returns a C literal for v, of the integer type t.
+func intLiteral(t types.Type, v *big.Int) string {
+	switch {
+	case bits(t) < 64:
+		return v.String()
+	case types.IsUnsigned(t):
+		return "UINT64_C(" + v.String() + ")"
+	case v.Cmp(big.NewInt(-1<<63)) == 0:
+		// the literal 9223372036854775808 would not fit
+		return "INT64_MIN"
+	}
+	return "INT64_C(" + v.String() + ")"
+}
@@ -0,0 +1,903 @@
+/*
+ * The runtime of the programs generated by the Tuppence C backend, which
+ * is copied to the start of each of them. It provides strings, dynamic
+ * arrays and the heap they are allocated from, the text of values as print
+ * writes it, and the arithmetic that traps, which stops the program with a
+ * runtime error.
+ * It is C99, apart from the overflow builtins of GCC and Clang.
+ */
+#include <inttypes.h>
+#include <math.h>
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+/* TUP_LAYOUT fails to compile unless the C compiler lays out the struct T
+   as package layout does: with the size given and the fields at the
+   offsets checked. */
+#define TUP_LAYOUT(T, size, offsets) \
+	typedef char T##_layout[sizeof(T) == (size) && (offsets) ? 1 : -1]
+
+typedef uint8_t tup_nil;
+
+static void tup_panic(const char *msg) __attribute__((noreturn));
+
+static void tup_panic(const char *msg)
+{
+	fflush(stdout);
+	fprintf(stderr, "runtime error: %s\n", msg);
+	exit(2);
+}
+
+static void tup_overflow(void) __attribute__((noreturn));
+
+static void tup_overflow(void)
+{
+	tup_panic("integer overflow");
+}
+
+/* The heap. Strings, dynamic arrays and the environments of closures are
+   objects allocated from the heap, each behind a header counting the
+   references to it. Every variable holding a value holds a reference to
+   each object the value refers to, as does every object; an object is
+   freed once no reference to it is left, releasing those it holds, and
+   its block kept for reuse. Since values are immutable, no cycle can form
+   but through a closure holding itself, so the objects that may hold
+   closures are watched for cycles when a reference to them is released,
+   and the cycles no longer referenced from outside them are freed by trial
+   deletion, as described by Bacon and Rajan in "Concurrent Cycle
+   Collection in Reference Counted Systems". Constants have headers marking
+   them static, and are not counted. */
+
+/* tup_visitor is called with each object another refers to, or with
+   NULL. */
+typedef void (*tup_visitor)(const void *obj);
+
+/* the states of objects in cycle collection */
+enum {
+	TUP_BLACK,  /* in use, or free */
+	TUP_GRAY,   /* possibly part of a garbage cycle */
+	TUP_WHITE,  /* part of a garbage cycle */
+	TUP_PURPLE  /* a possible root of a cycle */
+};
+
+struct tup_header {
+	int64_t refs; /* negative for static objects */
+	/* visit calls f with each object obj refers to; null if none */
+	void (*visit)(const void *obj, tup_visitor f);
+	size_t size; /* of the block holding the object and its header */
+	uint8_t color;
+	bool buffered; /* among the possible roots of cycles */
+	bool cyclic;   /* may be part of a cycle */
+};
+
+#define TUP_STATIC {-1, NULL, 0, TUP_BLACK, false, false}
+
+/* tup_counted returns the header of obj, or NULL if obj is null or
+   static. */
+static struct tup_header *tup_counted(const void *obj)
+{
+	struct tup_header *h;
+	if (obj == NULL)
+		return NULL;
+	h = (struct tup_header *)obj - 1;
+	return h->refs < 0 ? NULL : h;
+}
+
+/* Blocks of up to TUP_CLASSES * 16 bytes are carved out of large chunks,
+   and kept on the free list of their size once freed; larger ones are
+   allocated with malloc. */
+#define TUP_CHUNK_SIZE ((size_t)1 << 20)
+#define TUP_CLASSES 32
+/* TUP_COLLECT_AT is the number of possible roots of cycles at which an
+   allocation collects cycles. */
+#define TUP_COLLECT_AT 10000
+
+static char *tup_chunk;
+static size_t tup_chunk_left;
+static struct tup_header *tup_free_blocks[TUP_CLASSES];
+
+struct tup_stack {
+	struct tup_header **objs;
+	size_t len, cap;
+};
+
+/* the possible roots of cycles, and the objects being released */
+static struct tup_stack tup_roots, tup_dead;
+static bool tup_releasing;
+
+static struct {
+	int64_t allocs, reused, frees, live, max_live;
+	int64_t collections, collected;
+} tup_stats;
+
+static void tup_push(struct tup_stack *s, struct tup_header *h)
+{
+	if (s->len == s->cap) {
+		s->cap = 2 * s->cap + 64;
+		if ((s->objs = realloc(s->objs, s->cap * sizeof *s->objs)) == NULL)
+			tup_panic("out of memory");
+	}
+	s->objs[s->len++] = h;
+}
+
+static void tup_collect(void);
+
+/* tup_new returns a new object of size bytes with a reference, whose
+   references visit visits, and which may be part of a cycle if cyclic. */
+static void *tup_new(size_t size, void (*visit)(const void *, tup_visitor), bool cyclic)
+{
+	struct tup_header *h;
+	size_t class;
+	if (tup_roots.len >= TUP_COLLECT_AT)
+		tup_collect();
+	size = (sizeof *h + size + 15) & ~(size_t)15;
+	class = size / 16;
+	if (class >= TUP_CLASSES) {
+		if ((h = malloc(size)) == NULL)
+			tup_panic("out of memory");
+	} else if (tup_free_blocks[class] != NULL) {
+		h = tup_free_blocks[class];
+		tup_free_blocks[class] = *(struct tup_header **)(h + 1);
+		tup_stats.reused++;
+	} else {
+		if (size > tup_chunk_left) {
+			if ((tup_chunk = malloc(TUP_CHUNK_SIZE)) == NULL)
+				tup_panic("out of memory");
+			tup_chunk_left = TUP_CHUNK_SIZE;
+		}
+		h = (struct tup_header *)tup_chunk;
+		tup_chunk += size;
+		tup_chunk_left -= size;
+	}
+	h->refs = 1;
+	h->visit = visit;
+	h->size = size;
+	h->color = TUP_BLACK;
+	h->buffered = false;
+	h->cyclic = cyclic;
+	tup_stats.allocs++;
+	if (++tup_stats.live > tup_stats.max_live)
+		tup_stats.max_live = tup_stats.live;
+	return h + 1;
+}
+
+static void tup_free(struct tup_header *h)
+{
+	size_t class = h->size / 16;
+	tup_stats.frees++;
+	tup_stats.live--;
+	if (class >= TUP_CLASSES) {
+		free(h);
+		return;
+	}
+	*(struct tup_header **)(h + 1) = tup_free_blocks[class];
+	tup_free_blocks[class] = h;
+}
+
+static void tup_retain(const void *obj)
+{
+	struct tup_header *h = tup_counted(obj);
+	if (h != NULL)
+		h->refs++;
+}
+
+/* tup_possible_root records that the object of h, which is still
+   referenced after one of its references was released, may be the root
+   of a garbage cycle. */
+static void tup_possible_root(struct tup_header *h)
+{
+	if (!h->cyclic || h->color == TUP_PURPLE)
+		return;
+	h->color = TUP_PURPLE;
+	if (!h->buffered) {
+		h->buffered = true;
+		tup_push(&tup_roots, h);
+	}
+}
+
+/* tup_release drops a reference to obj, freeing it once none is left.
+   The objects freed are released without recursion, as chains of
+   closures may be long. */
+static void tup_release(const void *obj)
+{
+	struct tup_header *h = tup_counted(obj);
+	if (h == NULL)
+		return;
+	if (--h->refs > 0) {
+		tup_possible_root(h);
+		return;
+	}
+	tup_push(&tup_dead, h);
+	if (tup_releasing)
+		return;
+	tup_releasing = true;
+	while (tup_dead.len > 0) {
+		h = tup_dead.objs[--tup_dead.len];
+		if (h->visit != NULL)
+			h->visit(h + 1, tup_release);
+		h->color = TUP_BLACK;
+		if (!h->buffered)
+			tup_free(h);
+	}
+	tup_releasing = false;
+}
+
+/* tup_mark_gray removes the references among the objects reachable from
+   the object of h. */
+static void tup_mark_gray(struct tup_header *h);
+
+static void tup_mark_gray_child(const void *obj)
+{
+	struct tup_header *h = tup_counted(obj);
+	if (h != NULL) {
+		h->refs--;
+		tup_mark_gray(h);
+	}
+}
+
+static void tup_mark_gray(struct tup_header *h)
+{
+	if (h->color == TUP_GRAY)
+		return;
+	h->color = TUP_GRAY;
+	if (h->visit != NULL)
+		h->visit(h + 1, tup_mark_gray_child);
+}
+
+/* tup_scan_black restores the references among the objects reachable
+   from the object of h, which is referenced from outside them. */
+static void tup_scan_black(struct tup_header *h);
+
+static void tup_scan_black_child(const void *obj)
+{
+	struct tup_header *h = tup_counted(obj);
+	if (h != NULL) {
+		h->refs++;
+		if (h->color != TUP_BLACK)
+			tup_scan_black(h);
+	}
+}
+
+static void tup_scan_black(struct tup_header *h)
+{
+	h->color = TUP_BLACK;
+	if (h->visit != NULL)
+		h->visit(h + 1, tup_scan_black_child);
+}
+
+/* tup_scan marks white the objects reachable from obj that are referenced
+   only from among them, and restores the references of the others. */
+static void tup_scan(const void *obj)
+{
+	struct tup_header *h = tup_counted(obj);
+	if (h == NULL || h->color != TUP_GRAY)
+		return;
+	if (h->refs > 0) {
+		tup_scan_black(h);
+		return;
+	}
+	h->color = TUP_WHITE;
+	if (h->visit != NULL)
+		h->visit(obj, tup_scan);
+}
+
+/* tup_collect_white frees the white objects reachable from obj. */
+static void tup_collect_white(const void *obj)
+{
+	struct tup_header *h = tup_counted(obj);
+	if (h == NULL || h->color != TUP_WHITE || h->buffered)
+		return;
+	h->color = TUP_BLACK;
+	if (h->visit != NULL)
+		h->visit(obj, tup_collect_white);
+	tup_stats.collected++;
+	tup_free(h);
+}
+
+/* tup_collect frees the garbage cycles reachable from the possible roots
+   of cycles: those that removing the references among their objects
+   leaves unreferenced. */
+static void tup_collect(void)
+{
+	size_t i, n = 0;
+	if (tup_roots.len == 0)
+		return;
+	tup_stats.collections++;
+	for (i = 0; i < tup_roots.len; i++) {
+		struct tup_header *h = tup_roots.objs[i];
+		if (h->color == TUP_PURPLE && h->refs > 0) {
+			tup_mark_gray(h);
+			tup_roots.objs[n++] = h;
+			continue;
+		}
+		h->buffered = false;
+		if (h->color == TUP_BLACK && h->refs == 0)
+			tup_free(h);
+	}
+	for (i = 0; i < n; i++)
+		tup_scan(tup_roots.objs[i] + 1);
+	for (i = 0; i < n; i++) {
+		tup_roots.objs[i]->buffered = false;
+		tup_collect_white(tup_roots.objs[i] + 1);
+	}
+	tup_roots.len = 0;
+}
+
+/* tup_exit collects the cycles left when the program ends, and writes the
+   statistics of the heap to stderr if TUP_MEMSTATS is set. */
+static void tup_exit(void)
+{
+	tup_collect();
+	if (getenv("TUP_MEMSTATS") == NULL)
+		return;
+	fflush(stdout);
+	fprintf(stderr, "memstats: allocs %" PRId64 " (reused %" PRId64 "), frees %" PRId64
+		", live %" PRId64 " (max %" PRId64 "), collections %" PRId64 " (freed %" PRId64 ")\n",
+		tup_stats.allocs, tup_stats.reused, tup_stats.frees, tup_stats.live,
+		tup_stats.max_live, tup_stats.collections, tup_stats.collected);
+}
+
+/* Strings. A String is a pointer to its length and its bytes, which are
+   never modified, so strings are shared freely. Symbols are held as the
+   strings of their names. String constants are static objects. */
+
+typedef const struct tup_string {
+	int64_t len;
+	const char *data;
+} *tup_string;
+
+struct tup_literal {
+	struct tup_header h;
+	struct tup_string s;
+};
+
+static tup_string tup_string_new(const char *data, int64_t len)
+{
+	struct tup_string *s = tup_new(sizeof *s + (size_t)len, NULL, false);
+	char *bytes = (char *)(s + 1);
+	memcpy(bytes, data, (size_t)len);
+	s->len = len;
+	s->data = bytes;
+	return s;
+}
+
+static tup_string tup_string_concat(tup_string x, tup_string y)
+{
+	struct tup_string *s;
+	char *bytes;
+	if (x->len == 0) {
+		tup_retain(y);
+		return y;
+	}
+	if (y->len == 0) {
+		tup_retain(x);
+		return x;
+	}
+	s = tup_new(sizeof *s + (size_t)(x->len + y->len), NULL, false);
+	bytes = (char *)(s + 1);
+	memcpy(bytes, x->data, (size_t)x->len);
+	memcpy(bytes + x->len, y->data, (size_t)y->len);
+	s->len = x->len + y->len;
+	s->data = bytes;
+	return s;
+}
+
+static int64_t tup_string_cmp(tup_string x, tup_string y)
+{
+	int64_t n = x->len < y->len ? x->len : y->len;
+	int c = memcmp(x->data, y->data, (size_t)n);
+	if (c != 0)
+		return c < 0 ? -1 : 1;
+	return (x->len > y->len) - (x->len < y->len);
+}
+
+static bool tup_string_eq(tup_string x, tup_string y)
+{
+	return x->len == y->len && memcmp(x->data, y->data, (size_t)x->len) == 0;
+}
+
+static int64_t tup_index(int64_t i, int64_t len)
+{
+	char msg[80];
+	if (i < 0 || i >= len) {
+		snprintf(msg, sizeof msg, "index %" PRId64 " out of range [0:%" PRId64 "]", i, len);
+		tup_panic(msg);
+	}
+	return i;
+}
+
+/* tup_slice returns the number of elements lo through hi of a string or
+   array of length len, trapping if they do not lie within it. */
+static int64_t tup_slice(int64_t lo, int64_t hi, int64_t len)
+{
+	char msg[100];
+	if (lo < 0 || hi < lo - 1 || hi >= len) {
+		snprintf(msg, sizeof msg, "slice [%" PRId64 "..%" PRId64 "] out of range [0:%" PRId64 "]", lo, hi, len);
+		tup_panic(msg);
+	}
+	return hi + 1 - lo;
+}
+
+static tup_string tup_string_slice(tup_string s, int64_t lo, int64_t hi)
+{
+	int64_t n = tup_slice(lo, hi, s->len);
+	return tup_string_new(n > 0 ? s->data + lo : "", n);
+}
+
+/* Boxes. The values of recursive types are held in boxes wherever other
+   values or arrays hold them: objects holding a copy of the value and
+   the references of the copy. */
+
+typedef const void *tup_box;
+
+#define tup_unbox(b, T) (*(const T *)(b))
+
+/* tup_box_new returns a new box holding a copy of the size bytes at v,
+   whose references visit visits, which may be part of a cycle if cyclic.
+   The box takes the references of the copy, but has none of its own until
+   the value holding it takes one. */
+static tup_box tup_box_new(const void *v, size_t size, void (*visit)(const void *, tup_visitor), bool cyclic)
+{
+	void *b = tup_new(size, visit, cyclic);
+	memcpy(b, v, size);
+	visit(b, tup_retain);
+	tup_counted(b)->refs = 0;
+	return b;
+}
+
+/* Dynamic arrays. An array is a pointer to its length and to the slab
+   holding its elements, which may hold more elements past the end of the
+   array. Appending to the array that ends where the slab's elements do
+   fills the slab in place, so that the arrays built by appending to each
+   other in turn share it; appending to any other array copies it. The
+   slab holds the references of all its elements, and is described by the
+   type of the arrays of its elements. */
+
+struct tup_slab {
+	int64_t used, cap;
+	char data[];
+};
+
+typedef const struct tup_array {
+	int64_t len;
+	struct tup_slab *slab;
+} *tup_array;
+
+/* tup_slab_type describes the slabs of elements of a type: the size of
+   the elements, the function visiting the references of a slab's
+   elements, if they have any, and whether the slab may be part of a
+   cycle. */
+struct tup_slab_type {
+	size_t size;
+	void (*visit)(const void *slab, tup_visitor f);
+	bool cyclic;
+};
+
+#define tup_elems(a, T) ((T *)(a)->slab->data)
+
+static struct {
+	struct tup_header h;
+	struct tup_array a;
+} tup_empty_array = {TUP_STATIC, {0, NULL}};
+
+static void tup_visit_array(const void *obj, tup_visitor f)
+{
+	f(((tup_array)obj)->slab);
+}
+
+/* tup_array_new returns an array of len elements described by t, which
+   the caller sets. */
+static tup_array tup_array_new(int64_t len, const struct tup_slab_type *t)
+{
+	struct tup_array *a;
+	struct tup_slab *slab;
+	if (len == 0)
+		return &tup_empty_array.a;
+	slab = tup_new(sizeof *slab + (size_t)len * t->size, t->visit, t->cyclic);
+	slab->used = slab->cap = len;
+	a = tup_new(sizeof *a, tup_visit_array, t->cyclic);
+	a->len = len;
+	a->slab = slab;
+	return a;
+}
+
+/* tup_array_append returns a with elem appended, the caller adding the
+   reference of the slab to the element. */
+static tup_array tup_array_append(tup_array a, const void *elem, const struct tup_slab_type *t)
+{
+	/* the array is allocated first, while the slab holds the references
+	   of all its elements, in case the allocation collects cycles */
+	struct tup_array *r = tup_new(sizeof *r, tup_visit_array, t->cyclic);
+	struct tup_slab *slab = a->slab;
+	if (slab == NULL || a->len != slab->used || slab->used == slab->cap) {
+		int64_t cap = a->len < 4 ? 8 : 2 * a->len;
+		struct tup_slab *grown = tup_new(sizeof *grown + (size_t)cap * t->size, t->visit, t->cyclic);
+		grown->cap = cap;
+		grown->used = a->len;
+		if (a->len > 0) {
+			memcpy(grown->data, slab->data, (size_t)a->len * t->size);
+			if (t->visit != NULL)
+				t->visit(grown, tup_retain);
+		}
+		slab = grown;
+	} else {
+		tup_retain(slab);
+	}
+	memcpy(slab->data + (size_t)slab->used * t->size, elem, t->size);
+	slab->used++;
+	r->len = slab->used;
+	r->slab = slab;
+	return r;
+}
+
+/* tup_elems_slice returns an array of elements lo through hi of the len
+   elements described by t at elems. */
+static tup_array tup_elems_slice(const void *elems, int64_t lo, int64_t hi, int64_t len, const struct tup_slab_type *t)
+{
+	int64_t n = tup_slice(lo, hi, len);
+	tup_array a = tup_array_new(n, t);
+	if (n > 0) {
+		memcpy(a->slab->data, (const char *)elems + (size_t)lo * t->size, (size_t)n * t->size);
+		if (t->visit != NULL)
+			t->visit(a->slab, tup_retain);
+	}
+	return a;
+}
+
+static tup_array tup_array_slice(tup_array a, int64_t lo, int64_t hi, const struct tup_slab_type *t)
+{
+	return tup_elems_slice(a->slab != NULL ? a->slab->data : NULL, lo, hi, a->len, t);
+}
+
+/* Arithmetic that may trap. Integers narrower than 64 bits are computed
+   in 64 bits and checked against the bounds of their type. */
+
+static int64_t tup_div_int(int64_t x, int64_t y, int64_t min, int64_t max)
+{
+	if (y == 0)
+		tup_panic("division by zero");
+	if (y == -1) {
+		if (x == INT64_MIN || -x < min || -x > max)
+			tup_overflow();
+		return -x;
+	}
+	return x / y;
+}
+
+static bool tup_div_int_ok(int64_t x, int64_t y, int64_t min, int64_t max)
+{
+	return y != 0 && (y != -1 || (x != INT64_MIN && -x >= min && -x <= max));
+}
+
+static int64_t tup_mod_int(int64_t x, int64_t y)
+{
+	if (y == 0)
+		tup_panic("division by zero");
+	return y == -1 ? 0 : x % y;
+}
+
+static uint64_t tup_div_uint(uint64_t x, uint64_t y)
+{
+	if (y == 0)
+		tup_panic("division by zero");
+	return x / y;
+}
+
+static uint64_t tup_mod_uint(uint64_t x, uint64_t y)
+{
+	if (y == 0)
+		tup_panic("division by zero");
+	return x % y;
+}
+
+static int64_t tup_pow_int(int64_t x, int64_t y, int64_t min, int64_t max)
+{
+	int64_t r = 1;
+	if (y < 0)
+		tup_panic("negative exponent");
+	while (y > 0) {
+		if ((y & 1) && __builtin_mul_overflow(r, x, &r))
+			tup_overflow();
+		y >>= 1;
+		/* once |x| is at least 2, a square that overflows would
+		   make the result overflow too */
+		if (y > 0 && __builtin_mul_overflow(x, x, &x))
+			tup_overflow();
+	}
+	if (r < min || r > max)
+		tup_overflow();
+	return r;
+}
+
+static uint64_t tup_pow_uint(uint64_t x, uint64_t y, uint64_t max)
+{
+	uint64_t r = 1;
+	while (y > 0) {
+		if ((y & 1) && __builtin_mul_overflow(r, x, &r))
+			tup_overflow();
+		y >>= 1;
+		if (y > 0 && __builtin_mul_overflow(x, x, &x))
+			tup_overflow();
+	}
+	if (r > max)
+		tup_overflow();
+	return r;
+}
+
+static int64_t tup_shl_int(int64_t x, int64_t n, int64_t min, int64_t max)
+{
+	int64_t r;
+	if (n < 0)
+		tup_panic("negative shift count");
+	if (x == 0)
+		return 0;
+	if (n >= 64)
+		tup_overflow();
+	r = (int64_t)((uint64_t)x << n);
+	if ((r >> n) != x || r < min || r > max)
+		tup_overflow();
+	return r;
+}
+
+static int64_t tup_shr_int(int64_t x, int64_t n)
+{
+	if (n < 0)
+		tup_panic("negative shift count");
+	if (n >= 64)
+		return x < 0 ? -1 : 0;
+	return x >> n;
+}
+
+static uint64_t tup_shl_uint(uint64_t x, uint64_t n, uint64_t max)
+{
+	uint64_t r;
+	if (x == 0)
+		return 0;
+	if (n >= 64)
+		tup_overflow();
+	r = x << n;
+	if ((r >> n) != x || r > max)
+		tup_overflow();
+	return r;
+}
+
+static uint64_t tup_shr_uint(uint64_t x, uint64_t n)
+{
+	return n >= 64 ? 0 : x >> n;
+}
+
+static double tup_float(double x)
+{
+	if (!isfinite(x))
+		tup_panic("floating-point overflow");
+	return x;
+}
+
+static double tup_div_float(double x, double y)
+{
+	if (y == 0)
+		tup_panic("division by zero");
+	return tup_float(x / y);
+}
+
+/* Text. The text of a value is written to a builder, and a String made of
+   it. Strings are quoted when they are part of a larger value. */
+
+typedef struct {
+	char *data;
+	int64_t len, cap;
+} tup_builder;
+
+static void tup_write(tup_builder *b, const char *data, int64_t len)
+{
+	if (b->len + len > b->cap) {
+		b->cap = 2 * (b->len + len) + 16;
+		if ((b->data = realloc(b->data, (size_t)b->cap)) == NULL)
+			tup_panic("out of memory");
+	}
+	memcpy(b->data + b->len, data, (size_t)len);
+	b->len += len;
+}
+
+static void tup_puts(tup_builder *b, const char *s)
+{
+	tup_write(b, s, (int64_t)strlen(s));
+}
+
+static tup_string tup_builder_string(tup_builder *b)
+{
+	tup_string s = tup_string_new(b->data, b->len);
+	free(b->data);
+	return s;
+}
+
+static void tup_fmt_int(tup_builder *b, int64_t v)
+{
+	char buf[24];
+	snprintf(buf, sizeof buf, "%" PRId64, v);
+	tup_puts(b, buf);
+}
+
+static void tup_fmt_uint(tup_builder *b, uint64_t v)
+{
+	char buf[24];
+	snprintf(buf, sizeof buf, "%" PRIu64, v);
+	tup_puts(b, buf);
+}
+
+static void tup_fmt_bool(tup_builder *b, bool v)
+{
+	tup_puts(b, v ? "true" : "false");
+}
+
+/* tup_fmt_float writes the shortest decimal that reads back as v, in
+   exponent form if its exponent is less than -4 or at least 6, and with a
+   ".0" added if it would otherwise read as an integer. */
+static void tup_fmt_float(tup_builder *b, double v)
+{
+	char buf[32], digits[20];
+	int prec, nd = 0, exp, i;
+	const char *p;
+	if (isnan(v)) {
+		tup_puts(b, "NaN");
+		return;
+	}
+	if (isinf(v)) {
+		tup_puts(b, v < 0 ? "-Inf" : "+Inf");
+		return;
+	}
+	if (v == 0) {
+		tup_puts(b, signbit(v) ? "-0.0" : "0.0");
+		return;
+	}
+	for (prec = 1; prec < 17; prec++) {
+		snprintf(buf, sizeof buf, "%.*e", prec - 1, v);
+		if (strtod(buf, NULL) == v)
+			break;
+	}
+	snprintf(buf, sizeof buf, "%.*e", prec - 1, v);
+	p = buf;
+	if (*p == '-') {
+		tup_puts(b, "-");
+		p++;
+	}
+	for (; *p != 'e'; p++) {
+		if (*p != '.')
+			digits[nd++] = *p;
+	}
+	exp = atoi(p + 1);
+	while (nd > 1 && digits[nd - 1] == '0')
+		nd--;
+	if (exp < -4 || exp >= 6) {
+		tup_write(b, digits, 1);
+		if (nd > 1) {
+			tup_puts(b, ".");
+			tup_write(b, digits + 1, nd - 1);
+		}
+		snprintf(buf, sizeof buf, "e%c%02d", exp < 0 ? '-' : '+', exp < 0 ? -exp : exp);
+		tup_puts(b, buf);
+		return;
+	}
+	if (exp < 0) {
+		tup_puts(b, "0.");
+		for (i = exp + 1; i < 0; i++)
+			tup_puts(b, "0");
+		tup_write(b, digits, nd);
+		return;
+	}
+	for (i = 0; i <= exp; i++)
+		tup_write(b, i < nd ? &digits[i] : "0", 1);
+	tup_puts(b, ".");
+	if (nd > exp + 1)
+		tup_write(b, digits + exp + 1, nd - exp - 1);
+	else
+		tup_puts(b, "0");
+}
+
+/* tup_utf8 returns the length of the valid UTF-8 encoding of a rune at
+   the start of s, n bytes long, storing the rune in r, or 0. */
+static int tup_utf8(const unsigned char *s, int64_t n, uint32_t *r)
+{
+	int len, i;
+	uint32_t min;
+	if (s[0] < 0xc2 || s[0] > 0xf4)
+		return 0;
+	if (s[0] < 0xe0)
+		len = 2, min = 0x80, *r = s[0] & 0x1f;
+	else if (s[0] < 0xf0)
+		len = 3, min = 0x800, *r = s[0] & 0x0f;
+	else
+		len = 4, min = 0x10000, *r = s[0] & 0x07;
+	if (n < len)
+		return 0;
+	for (i = 1; i < len; i++) {
+		if ((s[i] & 0xc0) != 0x80)
+			return 0;
+		*r = *r << 6 | (s[i] & 0x3f);
+	}
+	if (*r < min || *r > 0x10ffff || (*r >= 0xd800 && *r <= 0xdfff))
+		return 0;
+	return len;
+}
+
+/* tup_fmt_quoted writes s as a double-quoted string literal: control
+   characters, quotes, backslashes and invalid UTF-8 are escaped. */
+static void tup_fmt_quoted(tup_builder *b, tup_string s)
+{
+	const unsigned char *p = (const unsigned char *)s->data;
+	int64_t i = 0;
+	char buf[16];
+	tup_puts(b, "\"");
+	while (i < s->len) {
+		unsigned char c = p[i];
+		uint32_t r;
+		int n;
+		const char *esc = NULL;
+		switch (c) {
+		case '\a': esc = "\\a"; break;
+		case '\b': esc = "\\b"; break;
+		case '\f': esc = "\\f"; break;
+		case '\n': esc = "\\n"; break;
+		case '\r': esc = "\\r"; break;
+		case '\t': esc = "\\t"; break;
+		case '\v': esc = "\\v"; break;
+		case '"': esc = "\\\""; break;
+		case '\\': esc = "\\\\"; break;
+		}
+		if (esc != NULL) {
+			tup_puts(b, esc);
+			i++;
+		} else if (c >= 0x20 && c < 0x7f) {
+			tup_write(b, (const char *)&p[i], 1);
+			i++;
+		} else if (c >= 0x80 && (n = tup_utf8(&p[i], s->len - i, &r)) > 0) {
+			if (r <= 0xa0 || r == 0xad) {
+				snprintf(buf, sizeof buf, "\\u%04" PRIx32, r);
+				tup_puts(b, buf);
+			} else {
+				tup_write(b, (const char *)&p[i], n);
+			}
+			i += n;
+		} else {
+			snprintf(buf, sizeof buf, "\\x%02x", c);
+			tup_puts(b, buf);
+			i++;
+		}
+	}
+	tup_puts(b, "\"");
+}
+
+static void tup_fmt_string(tup_builder *b, tup_string s, bool quote)
+{
+	if (quote)
+		tup_fmt_quoted(b, s);
+	else
+		tup_write(b, s->data, s->len);
+}
+
+static void tup_fmt_symbol(tup_builder *b, tup_string name)
+{
+	tup_puts(b, ":");
+	tup_write(b, name->data, name->len);
+}
+
+/* tup_fmt_error writes an error returned by checked arithmetic, held as
+   its message. */
+static void tup_fmt_error(tup_builder *b, tup_string msg)
+{
+	tup_puts(b, "error(");
+	tup_fmt_quoted(b, msg);
+	tup_puts(b, ")");
+}
+
+/* Host functions. */
+
+static void tup_print(tup_string s)
+{
+	fwrite(s->data, 1, (size_t)s->len, stdout);
+	putchar('\n');
+}
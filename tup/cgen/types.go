@@ -0,0 +1,577 @@
+package cgen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/layout"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// typedef is a type the program gives a C name.
+type typedef struct {
+	typ  types.Type
+	name string
+	// defining is set while the components of the type are defined
+	defining bool
+}
+
+// ctype returns the C type holding values of type t, defining it first if
+// it needs a typedef.
+func (g *generator) ctype(t types.Type) string {
+	if types.Identical(t, types.ErrorType) {
+		return "tup_string"
+	}
+	switch t := t.(type) {
+	case *types.Basic:
+		return g.basic(t)
+	case *types.Array:
+		if !t.Fixed() {
+			return "tup_array"
+		}
+	}
+	for _, td := range g.types {
+		if types.Identical(td.typ, t) {
+			if td.defining {
+				g.errorf("%s contains itself, which C cannot hold", t)
+			}
+			return td.name
+		}
+	}
+	return g.define(t)
+}
+
+func (g *generator) basic(t *types.Basic) string {
+	switch t.Kind() {
+	case types.Nil:
+		return "tup_nil"
+	case types.Bool:
+		return "bool"
+	case types.Int8, types.Int16, types.Int32, types.Int64:
+		return fmt.Sprintf("int%d_t", bits(t))
+	case types.UInt8, types.UInt16, types.UInt32, types.UInt64:
+		return fmt.Sprintf("uint%d_t", bits(t))
+	case types.Float16, types.Float32:
+		return "float"
+	case types.Float64:
+		return "double"
+	case types.String, types.Symbol:
+		return "tup_string"
+	case types.TypeDescriptor:
+		return "int32_t"
+	}
+	g.errorf("%s has no C representation", t)
+	return ""
+}
+
+// bits returns the size in bits of the integer type t.
+func bits(t types.Type) int {
+	switch t.Underlying().(*types.Basic).Kind() {
+	case types.Int8, types.UInt8:
+		return 8
+	case types.Int16, types.UInt16:
+		return 16
+	case types.Int32, types.UInt32:
+		return 32
+	}
+	return 64
+}
+
+// bounds returns C expressions for the least and greatest values of the
+// integer type t; the least is empty for unsigned types.
+func bounds(t types.Type) (min, max string) {
+	n := strconv.Itoa(bits(t))
+	if types.IsUnsigned(t) {
+		return "", "UINT" + n + "_MAX"
+	}
+	return "INT" + n + "_MIN", "INT" + n + "_MAX"
+}
+
+// define defines a C type for t, after the types it is made of, and
+// returns its name.
+func (g *generator) define(t types.Type) string {
+	td := &typedef{typ: t, name: "tt_" + strconv.Itoa(len(g.types)), defining: true}
+	if named, ok := t.(*types.Named); ok {
+		td.name = mangle("tn", named.Name())
+		for _, other := range g.types {
+			if other.name == td.name {
+				td.name += "_" + strconv.Itoa(len(g.types))
+			}
+		}
+	}
+	g.types = append(g.types, td)
+	name := td.name
+
+	var b strings.Builder
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		fmt.Fprintf(&b, "typedef %s %s;\n", g.basic(u), name)
+	case *types.Enum:
+		fmt.Fprintf(&b, "typedef int64_t %s;\n", name)
+	case *types.Function:
+		// the code of a function value takes its environment first
+		params := []string{"void *"}
+		for _, p := range u.Params {
+			params = append(params, g.ctype(p.Type))
+		}
+		fmt.Fprintf(&b, "typedef struct {\n\t%s (*fn)(%s);\n\tvoid *env;\n} %s;\n", g.result(u), strings.Join(params, ", "), name)
+	case *types.Array:
+		// a C array cannot be empty, so an empty one holds an unused
+		// element
+		fmt.Fprintf(&b, "typedef struct {\n\t%s e[%d];\n} %s;\n", g.slot(u.Elem), max(u.Len, 1), name)
+	case *types.Tuple:
+		l := g.layout(t)
+		b.WriteString("typedef struct {\n")
+		for _, f := range l.Fields {
+			fmt.Fprintf(&b, "\t%s f%d;", g.slot(f.Type), f.Index)
+			if f.Name != "" {
+				fmt.Fprintf(&b, " /* %s */", f.Name)
+			}
+			b.WriteString("\n")
+		}
+		if len(l.Fields) == 0 {
+			b.WriteString("\tchar unused;\n")
+		}
+		fmt.Fprintf(&b, "} %s;\n", name)
+		if exact(t) {
+			offsets := make([]string, len(l.Fields))
+			for i, f := range l.Fields {
+				offsets[i] = fmt.Sprintf("offsetof(%s, f%d) == %d", name, f.Index, f.Offset)
+			}
+			fmt.Fprintf(&b, "TUP_LAYOUT(%s, %d, %s);\n", name, l.Size, strings.Join(offsets, " && "))
+		}
+	case *types.Union:
+		l := g.layout(t)
+		b.WriteString("typedef struct {\n\tint32_t tag;\n\tunion {\n")
+		for i, m := range u.Members {
+			fmt.Fprintf(&b, "\t\t%s m%d; /* %s */\n", g.slot(m), i, m)
+		}
+		fmt.Fprintf(&b, "\t} u;\n} %s;\n", name)
+		if exact(t) {
+			fmt.Fprintf(&b, "TUP_LAYOUT(%s, %d, offsetof(%s, u) == %d);\n", name, l.Size, name, l.Payload)
+		}
+	default:
+		g.errorf("%s has no C representation", t)
+	}
+	td.defining = false
+	g.typedefs.WriteString(b.String())
+	return name
+}
+
+// slot returns the C type holding the values of type t that other values
+// and arrays hold: tup_box if t is recursive, or the type of the values.
+func (g *generator) slot(t types.Type) string {
+	if layout.Recursive(t) {
+		return "tup_box"
+	}
+	return g.ctype(t)
+}
+
+// unbox returns the C expression for the value of type t held in the
+// slot s.
+func (g *generator) unbox(t types.Type, s string) string {
+	if layout.Recursive(t) {
+		return fmt.Sprintf("tup_unbox(%s, %s)", s, g.ctype(t))
+	}
+	return s
+}
+
+// box returns the C expression for the slot holding the value of the C
+// variable v, of type t: a new box if t is recursive, which has no
+// reference until the value holding it takes one.
+func (g *generator) box(t types.Type, v string) string {
+	if !layout.Recursive(t) {
+		return v
+	}
+	return fmt.Sprintf("tup_box_new(&%s, sizeof %s, %s, %t)", v, v, g.boxVisit(t), cyclic(t))
+}
+
+// boxVisit returns the name of the function visiting the references of
+// the boxes holding values of the recursive type t, defining it first if
+// need be.
+func (g *generator) boxVisit(t types.Type) string {
+	for _, h := range g.helpers {
+		if h.kind == "box" && types.Identical(h.typ, t) {
+			return h.name
+		}
+	}
+	name := "box_" + strconv.Itoa(len(g.helpers))
+	g.helpers = append(g.helpers, &helper{kind: "box", typ: t, name: name})
+	stmt := g.visit(t, fmt.Sprintf("tup_unbox(obj, %s)", g.ctype(t)), "f")
+	fmt.Fprintf(&g.defs, "\nstatic void %s(const void *obj, tup_visitor f)\n{\n\t%s\n}\n", name, stmt)
+	return name
+}
+
+func (g *generator) layout(t types.Type) *layout.Layout {
+	l, err := layout.Target64.Of(t)
+	if err != nil {
+		g.errorf("%s", err)
+	}
+	return l
+}
+
+// exact reports whether the C type holding values of type t has the size
+// and alignment package layout gives t. Nil, which takes no space, and
+// Float16, held as float, do not, nor do functions, held as two pointers,
+// nor the errors returned by checked arithmetic, held as their messages,
+// nor the types containing them other than in boxes.
+func exact(t types.Type) bool {
+	if types.Identical(t, types.ErrorType) {
+		return false
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return u.Kind() != types.Nil && u.Kind() != types.Float16
+	case *types.Tuple:
+		if len(u.Fields) == 0 {
+			return false
+		}
+		for _, f := range u.Fields {
+			if !exactSlot(f.Type) {
+				return false
+			}
+		}
+	case *types.Array:
+		return !u.Fixed() || u.Len > 0 && exactSlot(u.Elem)
+	case *types.Function:
+		return false
+	case *types.Union:
+		for _, m := range u.Members {
+			if !exactSlot(m) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// exactSlot is exact for the values of type t that other values hold,
+// whose boxes are pointers.
+func exactSlot(t types.Type) bool {
+	return layout.Recursive(t) || exact(t)
+}
+
+// counted reports whether values of type t refer to objects of the heap:
+// whether they are or hold strings, dynamic arrays, functions or boxes,
+// which the values of recursive types hold.
+func counted(t types.Type) bool {
+	return holds(t, func(t types.Type) bool {
+		if types.Identical(t, types.ErrorType) || layout.Recursive(t) {
+			return true
+		}
+		switch u := t.Underlying().(type) {
+		case *types.Basic:
+			return u.Kind() == types.String || u.Kind() == types.Symbol
+		case *types.Array:
+			return !u.Fixed()
+		case *types.Function:
+			return true
+		}
+		return false
+	})
+}
+
+// cyclic reports whether the objects values of type t refer to may be
+// part of a cycle: whether the values may hold closures.
+func cyclic(t types.Type) bool {
+	return holds(t, func(t types.Type) bool {
+		_, ok := t.Underlying().(*types.Function)
+		return ok
+	})
+}
+
+// holds reports whether values of type t are or hold, directly or through
+// the elements of arrays, values of a type for which leaf reports true.
+func holds(t types.Type, leaf func(types.Type) bool) bool {
+	seen := map[types.Type]bool{}
+	var walk func(t types.Type) bool
+	walk = func(t types.Type) bool {
+		if seen[t] {
+			return false
+		}
+		seen[t] = true
+		if leaf(t) {
+			return true
+		}
+		switch u := t.Underlying().(type) {
+		case *types.Tuple:
+			for _, f := range u.Fields {
+				if walk(f.Type) {
+					return true
+				}
+			}
+		case *types.Union:
+			for _, m := range u.Members {
+				if walk(m) {
+					return true
+				}
+			}
+		case *types.Array:
+			return walk(u.Elem)
+		}
+		return false
+	}
+	return walk(t)
+}
+
+// helper is a C function the program defines for values of a type.
+type helper struct {
+	kind string // fmt, eq, visit, slab or box
+	typ  types.Type
+	name string
+}
+
+// helper returns the name of the helper of the given kind for values of
+// type t, defining it first if need be. The fmt helper writes the text of
+// a value to a builder, quoting strings if asked to; the eq helper reports
+// whether two values are equal; the visit helper calls a tup_visitor with
+// each object a value refers to.
+func (g *generator) helper(kind string, t types.Type) string {
+	for _, h := range g.helpers {
+		if h.kind == kind && types.Identical(h.typ, t) {
+			return h.name
+		}
+	}
+	name := kind + "_" + strconv.Itoa(len(g.helpers))
+	g.helpers = append(g.helpers, &helper{kind: kind, typ: t, name: name})
+	ct := g.ctype(t)
+	var proto, body string
+	switch kind {
+	case "fmt":
+		proto = fmt.Sprintf("static void %s(tup_builder *b, %s v, bool quote)", name, ct)
+		body = g.fmtBody(t)
+	case "visit":
+		proto = fmt.Sprintf("static void %s(%s v, tup_visitor f)", name, ct)
+		body = g.visitBody(t)
+	default:
+		proto = fmt.Sprintf("static bool %s(%s x, %s y)", name, ct, ct)
+		body = g.eqBody(t)
+	}
+	fmt.Fprintf(&g.protos, "%s;\n", proto)
+	fmt.Fprintf(&g.defs, "\n%s\n{\n%s}\n", proto, body)
+	return name
+}
+
+// format returns a C statement writing the text of the C expression v, of
+// type t, to the builder b, quoting strings if the C expression quote is
+// true.
+func (g *generator) format(t types.Type, b, v, quote string) string {
+	if types.Identical(t, types.ErrorType) {
+		return fmt.Sprintf("tup_fmt_error(%s, %s);", b, v)
+	}
+	if basic, ok := t.Underlying().(*types.Basic); ok {
+		switch {
+		case basic.Kind() == types.Nil:
+			return fmt.Sprintf("tup_puts(%s, \"nil\");", b)
+		case basic.Kind() == types.Bool:
+			return fmt.Sprintf("tup_fmt_bool(%s, %s);", b, v)
+		case basic.Kind() == types.String:
+			return fmt.Sprintf("tup_fmt_string(%s, %s, %s);", b, v, quote)
+		case basic.Kind() == types.Symbol:
+			return fmt.Sprintf("tup_fmt_symbol(%s, %s);", b, v)
+		case basic.Kind() == types.TypeDescriptor:
+			return fmt.Sprintf("tup_puts(%s, tup_types[%s]);", b, v)
+		case types.IsUnsigned(basic):
+			return fmt.Sprintf("tup_fmt_uint(%s, %s);", b, v)
+		case types.IsInteger(basic):
+			return fmt.Sprintf("tup_fmt_int(%s, %s);", b, v)
+		case types.IsFloat(basic):
+			return fmt.Sprintf("tup_fmt_float(%s, %s);", b, v)
+		}
+	}
+	return fmt.Sprintf("%s(%s, %s, %s);", g.helper("fmt", t), b, v, quote)
+}
+
+func (g *generator) fmtBody(t types.Type) string {
+	var b strings.Builder
+	switch u := t.Underlying().(type) {
+	case *types.Tuple:
+		b.WriteString("\ttup_puts(b, \"(\");\n")
+		for i, f := range u.Fields {
+			prefix := f.Name
+			if prefix != "" {
+				prefix += ": "
+			}
+			if i > 0 {
+				prefix = ", " + prefix
+			}
+			if prefix != "" {
+				fmt.Fprintf(&b, "\ttup_puts(b, %s);\n", quote(prefix))
+			}
+			fmt.Fprintf(&b, "\t%s\n", g.format(f.Type, "b", g.unbox(f.Type, fmt.Sprintf("v.f%d", i)), "true"))
+		}
+		if len(u.Fields) == 1 && u.Fields[0].Name == "" {
+			b.WriteString("\ttup_puts(b, \",\");\n")
+		}
+		b.WriteString("\ttup_puts(b, \")\");\n")
+	case *types.Array:
+		elem, n := "v.e[i]", strconv.FormatInt(u.Len, 10)
+		if !u.Fixed() {
+			elem, n = fmt.Sprintf("tup_elems(v, %s)[i]", g.slot(u.Elem)), "v->len"
+		}
+		b.WriteString("\tint64_t i;\n\ttup_puts(b, \"[\");\n")
+		fmt.Fprintf(&b, "\tfor (i = 0; i < %s; i++) {\n", n)
+		b.WriteString("\t\tif (i > 0)\n\t\t\ttup_puts(b, \", \");\n")
+		fmt.Fprintf(&b, "\t\t%s\n\t}\n", g.format(u.Elem, "b", g.unbox(u.Elem, elem), "true"))
+		b.WriteString("\ttup_puts(b, \"]\");\n")
+	case *types.Union:
+		// the members of unions are written as the values they hold
+		b.WriteString("\tswitch (v.tag) {\n")
+		for i, m := range u.Members {
+			fmt.Fprintf(&b, "\tcase %d:\n\t\t%s\n\t\tbreak;\n", i, g.format(m, "b", g.unbox(m, fmt.Sprintf("v.u.m%d", i)), "quote"))
+		}
+		b.WriteString("\t}\n")
+	case *types.Enum:
+		for _, m := range u.Members {
+			fmt.Fprintf(&b, "\tif (v == %d) {\n\t\ttup_puts(b, %s);\n\t\treturn;\n\t}\n", m.Value, quote(t.String()+"."+m.Name))
+		}
+		b.WriteString("\ttup_fmt_int(b, v);\n")
+	case *types.Function:
+		// function values are written as the names of the functions,
+		// known by their trampolines, and closures as fn { ... }
+		for _, v := range g.values {
+			if text := ir.FuncText(v.fn.Name); text != "fn { ... }" && types.Identical(v.typ, t) {
+				fmt.Fprintf(&b, "\tif (v.fn == %s) {\n\t\ttup_puts(b, %s);\n\t\treturn;\n\t}\n", v.code, quote(text))
+			}
+		}
+		b.WriteString("\ttup_puts(b, \"fn { ... }\");\n")
+	default:
+		g.errorf("cannot convert %s to text", t)
+	}
+	return b.String()
+}
+
+// equal returns a C expression reporting whether the C expressions x and
+// y, of type t, are equal.
+func (g *generator) equal(t types.Type, x, y string) string {
+	if types.Identical(t, types.ErrorType) {
+		return fmt.Sprintf("tup_string_eq(%s, %s)", x, y)
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if u.Kind() == types.String || u.Kind() == types.Symbol {
+			return fmt.Sprintf("tup_string_eq(%s, %s)", x, y)
+		}
+		return fmt.Sprintf("%s == %s", x, y)
+	case *types.Enum:
+		return fmt.Sprintf("%s == %s", x, y)
+	case *types.Function:
+		return fmt.Sprintf("%s.fn == %s.fn && %s.env == %s.env", x, y, x, y)
+	}
+	return fmt.Sprintf("%s(%s, %s)", g.helper("eq", t), x, y)
+}
+
+func (g *generator) eqBody(t types.Type) string {
+	var b strings.Builder
+	switch u := t.Underlying().(type) {
+	case *types.Tuple:
+		conds := []string{"true"}
+		if len(u.Fields) > 0 {
+			conds = conds[:0]
+		}
+		for i, f := range u.Fields {
+			conds = append(conds, g.equal(f.Type, g.unbox(f.Type, fmt.Sprintf("x.f%d", i)), g.unbox(f.Type, fmt.Sprintf("y.f%d", i))))
+		}
+		fmt.Fprintf(&b, "\treturn %s;\n", strings.Join(conds, " &&\n\t\t"))
+	case *types.Array:
+		x, y, n := "x.e[i]", "y.e[i]", strconv.FormatInt(u.Len, 10)
+		b.WriteString("\tint64_t i;\n")
+		if !u.Fixed() {
+			elem := g.slot(u.Elem)
+			x, y, n = fmt.Sprintf("tup_elems(x, %s)[i]", elem), fmt.Sprintf("tup_elems(y, %s)[i]", elem), "x->len"
+			b.WriteString("\tif (x->len != y->len)\n\t\treturn false;\n")
+		}
+		fmt.Fprintf(&b, "\tfor (i = 0; i < %s; i++) {\n", n)
+		fmt.Fprintf(&b, "\t\tif (!(%s))\n\t\t\treturn false;\n\t}\n", g.equal(u.Elem, g.unbox(u.Elem, x), g.unbox(u.Elem, y)))
+		b.WriteString("\treturn true;\n")
+	case *types.Union:
+		b.WriteString("\tif (x.tag != y.tag)\n\t\treturn false;\n\tswitch (x.tag) {\n")
+		for i, m := range u.Members {
+			fmt.Fprintf(&b, "\tcase %d:\n\t\treturn %s;\n", i, g.equal(m, g.unbox(m, fmt.Sprintf("x.u.m%d", i)), g.unbox(m, fmt.Sprintf("y.u.m%d", i))))
+		}
+		b.WriteString("\t}\n\treturn true;\n")
+	default:
+		g.errorf("cannot compare values of %s", t)
+	}
+	return b.String()
+}
+
+// visit returns a C statement calling the tup_visitor f with each object
+// of the heap the C expression v, of type t, refers to, or nothing if
+// values of t refer to none.
+func (g *generator) visit(t types.Type, v, f string) string {
+	if !counted(t) {
+		return ""
+	}
+	if types.Identical(t, types.ErrorType) {
+		return fmt.Sprintf("%s(%s);", f, v)
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return fmt.Sprintf("%s(%s);", f, v)
+	case *types.Array:
+		if !u.Fixed() {
+			return fmt.Sprintf("%s(%s);", f, v)
+		}
+	case *types.Function:
+		return fmt.Sprintf("%s(%s.env);", f, v)
+	}
+	return fmt.Sprintf("%s(%s, %s);", g.helper("visit", t), v, f)
+}
+
+// visitSlot is visit for the slot v holding a value of type t, which is
+// itself the object to visit if it is a box.
+func (g *generator) visitSlot(t types.Type, v, f string) string {
+	if layout.Recursive(t) {
+		return fmt.Sprintf("%s(%s);", f, v)
+	}
+	return g.visit(t, v, f)
+}
+
+func (g *generator) visitBody(t types.Type) string {
+	var b strings.Builder
+	switch u := t.Underlying().(type) {
+	case *types.Tuple:
+		for i, field := range u.Fields {
+			if stmt := g.visitSlot(field.Type, fmt.Sprintf("v.f%d", i), "f"); stmt != "" {
+				fmt.Fprintf(&b, "\t%s\n", stmt)
+			}
+		}
+	case *types.Array:
+		fmt.Fprintf(&b, "\tint64_t i;\n\tfor (i = 0; i < %d; i++) {\n\t\t%s\n\t}\n", u.Len, g.visitSlot(u.Elem, "v.e[i]", "f"))
+	case *types.Union:
+		b.WriteString("\tswitch (v.tag) {\n")
+		for i, m := range u.Members {
+			if stmt := g.visitSlot(m, fmt.Sprintf("v.u.m%d", i), "f"); stmt != "" {
+				fmt.Fprintf(&b, "\tcase %d:\n\t\t%s\n\t\tbreak;\n", i, stmt)
+			}
+		}
+		b.WriteString("\t}\n")
+	default:
+		g.errorf("cannot visit values of %s", t)
+	}
+	return b.String()
+}
+
+// slabType returns a pointer to the tup_slab_type describing the slabs of
+// elements of type t, defining it first if need be.
+func (g *generator) slabType(t types.Type) string {
+	for _, h := range g.helpers {
+		if h.kind == "slab" && types.Identical(h.typ, t) {
+			return "&" + h.name
+		}
+	}
+	name := "slab_" + strconv.Itoa(len(g.helpers))
+	g.helpers = append(g.helpers, &helper{kind: "slab", typ: t, name: name})
+	ct := g.slot(t)
+	visit := "NULL"
+	if stmt := g.visitSlot(t, fmt.Sprintf("((const %s *)s->data)[i]", ct), "f"); stmt != "" {
+		visit = "visit_" + name
+		fmt.Fprintf(&g.defs, "\nstatic void %s(const void *obj, tup_visitor f)\n{\n", visit)
+		fmt.Fprintf(&g.defs, "\tconst struct tup_slab *s = obj;\n\tint64_t i;\n\tfor (i = 0; i < s->used; i++) {\n\t\t%s\n\t}\n}\n", stmt)
+	}
+	fmt.Fprintf(&g.defs, "\nstatic const struct tup_slab_type %s = {sizeof(%s), %s, %t};\n", name, ct, visit, cyclic(t))
+	return "&" + name
+}
@@ -0,0 +1,298 @@
+package check
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// bound is a limit on the value of an integer expression: the constant k,
+// or len(array) + k when array is not nil.
+type bound struct {
+	array *Object
+	k     int64
+}
+
+func (b bound) String() string {
+	if b.array == nil {
+		return strconv.FormatInt(b.k, 10)
+	}
+	switch {
+	case b.k > 0:
+		return fmt.Sprintf("len(%s) + %d", b.array.Name, b.k)
+	case b.k < 0:
+		return fmt.Sprintf("len(%s) - %d", b.array.Name, -b.k)
+	}
+	return fmt.Sprintf("len(%s)", b.array.Name)
+}
+
+// bounds holds what is known about the value of an integer expression: it
+// is at least each of lo and at most each of hi.
+type bounds struct {
+	lo, hi []bound
+}
+
+func exactly(b bound) bounds {
+	return bounds{lo: []bound{b}, hi: []bound{b}}
+}
+
+// add returns the bounds of the expression plus k. Bounds whose limit
+// would overflow are dropped.
+func (b bounds) add(k int64) bounds {
+	shift := func(bs []bound) []bound {
+		var shifted []bound
+		for _, b := range bs {
+			if k > 0 && b.k > math.MaxInt64-k || k < 0 && b.k < math.MinInt64-k {
+				continue
+			}
+			shifted = append(shifted, bound{b.array, b.k + k})
+		}
+		return shifted
+	}
+	return bounds{lo: shift(b.lo), hi: shift(b.hi)}
+}
+
+// nonNegative reports whether the bounds prove the value is at least 0.
+func (b bounds) nonNegative() bool {
+	for _, lo := range b.lo {
+		if lo.k >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// below reports whether the bounds prove the value is less than the
+// length of array, which is n if known is set.
+func (b bounds) below(array *Object, n int64, known bool) bool {
+	for _, hi := range b.hi {
+		switch {
+		case hi.array == nil:
+			if known && hi.k < n {
+				return true
+			}
+		case hi.array == array:
+			if hi.k < 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fact records bounds on an immutable integer variable that hold within the
+// block being checked.
+type fact struct {
+	obj    *Object
+	bounds bounds
+}
+
+// boundVar returns the object of expr if it is an immutable variable, the
+// only kind whose bounds are tracked.
+func (c *Checker) boundVar(expr ast.Expression) *Object {
+	var name string
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		name = e.Name
+	case *ast.FunctionIdentifier:
+		name = e.Name
+	default:
+		return nil
+	}
+	obj := c.scope.Lookup(name)
+	if obj == nil || obj.Kind != VarObject || obj.Mutable {
+		return nil
+	}
+	return obj
+}
+
+// intConst returns the value of expr if it is an integer constant.
+func (c *Checker) intConst(expr ast.Expression) (int64, bool) {
+	v, err := c.eval.Eval(expr)
+	if err != nil {
+		return 0, false
+	}
+	n, ok := v.(*consteval.Int)
+	if !ok {
+		return 0, false
+	}
+	return n.Int64()
+}
+
+// lenArg returns the variable whose length is taken by a call of the
+// builtin len, or nil if call is not such a call.
+func (c *Checker) lenArg(call *ast.FunctionCall) *Object {
+	arg := c.builtinArg(call, "len")
+	if arg == nil {
+		return nil
+	}
+	return c.boundVar(arg)
+}
+
+// boundsOf returns what is known about the value of the integer expression
+// expr from its constant parts and the facts in effect.
+func (c *Checker) boundsOf(expr ast.Expression) bounds {
+	if k, ok := c.intConst(expr); ok {
+		return exactly(bound{k: k})
+	}
+	switch e := expr.(type) {
+	case *ast.Identifier, *ast.FunctionIdentifier:
+		obj := c.boundVar(e)
+		var b bounds
+		for _, fact := range c.facts {
+			if fact.obj == obj {
+				b.lo = append(b.lo, fact.bounds.lo...)
+				b.hi = append(b.hi, fact.bounds.hi...)
+			}
+		}
+		return b
+	case *ast.AddSubExpression:
+		if e.Operator != ast.OpAdd && e.Operator != ast.OpSub {
+			break
+		}
+		if k, ok := c.intConst(e.Right); ok {
+			if e.Operator == ast.OpSub {
+				if k == math.MinInt64 {
+					break
+				}
+				k = -k
+			}
+			return c.boundsOf(e.Left).add(k)
+		}
+		if k, ok := c.intConst(e.Left); ok && e.Operator == ast.OpAdd {
+			return c.boundsOf(e.Right).add(k)
+		}
+	case *ast.FunctionCall:
+		if array := c.lenArg(e); array != nil {
+			b := exactly(bound{array: array})
+			b.lo = append(b.lo, bound{k: 0})
+			return b
+		}
+	}
+	return bounds{}
+}
+
+// assume adds the facts implied by cond being true, to be removed by
+// restoring the length of c.facts.
+func (c *Checker) assume(cond ast.Node) {
+	switch e := cond.(type) {
+	case *ast.LogicalAndExpression:
+		for _, operand := range e.Operands {
+			c.assume(operand)
+		}
+	case *ast.RelationalComparison:
+		switch e.Operator {
+		case ast.OpLt:
+			c.assumeLess(e.Left, e.Right, 1)
+		case ast.OpLte:
+			c.assumeLess(e.Left, e.Right, 0)
+		case ast.OpGt:
+			c.assumeLess(e.Right, e.Left, 1)
+		case ast.OpGte:
+			c.assumeLess(e.Right, e.Left, 0)
+		case ast.OpEq:
+			c.assumeLess(e.Left, e.Right, 0)
+			c.assumeLess(e.Right, e.Left, 0)
+		}
+	}
+}
+
+// assumeLess adds the facts implied by x + gap <= y.
+func (c *Checker) assumeLess(x, y ast.Expression, gap int64) {
+	xb, yb := c.boundsOf(x), c.boundsOf(y)
+	if obj := c.boundVar(x); obj != nil {
+		c.facts = append(c.facts, fact{obj, bounds{hi: yb.add(-gap).hi}})
+	}
+	if obj := c.boundVar(y); obj != nil {
+		c.facts = append(c.facts, fact{obj, bounds{lo: xb.add(gap).lo}})
+	}
+}
+
+// assumeRange adds the facts about the variable of a for loop iterating
+// over the inclusive range r.
+func (c *Checker) assumeRange(obj *Object, r *ast.Range) {
+	var b bounds
+	if r.StartBound != nil {
+		b.lo = c.boundsOf(r.StartBound.Value).lo
+	}
+	if r.EndBound != nil {
+		b.hi = c.boundsOf(r.EndBound.Value).hi
+	}
+	c.facts = append(c.facts, fact{obj, b})
+}
+
+// length returns the length of expr, a value of type typ, if it is known
+// at compile time, and the variable holding the value if there is one.
+func (c *Checker) length(expr ast.Expression, typ types.Type) (n int64, known bool, array *Object) {
+	array = c.boundVar(expr)
+	switch t := typ.Underlying().(type) {
+	case *types.Array:
+		if t.Fixed() {
+			return t.Len, true, array
+		}
+	case *types.Tuple:
+		return int64(len(t.Fields)), true, array
+	}
+	if v, err := c.eval.Eval(expr); err == nil {
+		switch v := v.(type) {
+		case *consteval.Array:
+			return int64(len(v.Elems)), true, array
+		case consteval.String:
+			return int64(len(v)), true, array
+		}
+	}
+	return 0, false, array
+}
+
+// safeIndex reports a safe indexed access whose index cannot be proven to
+// be within the bounds of the object from constant indices, fixed array
+// sizes, the ranges of enclosing loops and the conditions guarding it.
+func (c *Checker) safeIndex(e *ast.SafeIndexedAccess, object types.Type) {
+	if types.IsInvalid(object) {
+		return
+	}
+	if _, ok := e.Index.(*ast.Range); ok {
+		return
+	}
+	if !types.IsString(object) {
+		switch object.Underlying().(type) {
+		case *types.Array, *types.Tuple:
+		default:
+			c.errorf(e, "cannot use safe indexing on %s: its length is not known", object)
+			return
+		}
+	}
+	n, known, array := c.length(e.Object, object)
+	if k, ok := c.intConst(e.Index); ok {
+		switch {
+		case k < 0:
+			c.errorf(e.Index, "index %d out of bounds: it must not be negative", k)
+			return
+		case known:
+			if k >= n {
+				c.errorf(e.Index, "index %d out of bounds for %s of length %d", k, e.Object, n)
+			}
+			return
+		}
+	}
+	if !known && array == nil {
+		c.errorf(e, "cannot prove %s is within bounds: the length of %s is not known", e, e.Object)
+		return
+	}
+	b := c.boundsOf(e.Index)
+	if !b.nonNegative() {
+		c.errorf(e.Index, "cannot prove %s is within bounds: %s may be negative", e, e.Index)
+		return
+	}
+	if !b.below(array, n, known) {
+		limit := strconv.FormatInt(n, 10)
+		if !known {
+			limit = bound{array: array}.String()
+		}
+		c.errorf(e.Index, "cannot prove %s is within bounds: %s may not be less than %s", e, e.Index, limit)
+	}
+}
@@ -0,0 +1,45 @@
+package check
+
+import "testing"
+
+func TestSafeIndex(t *testing.T) {
+	const values = "values = [5]Int[10, 20, 30, 40, 50]\n"
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"constant index", values + "x = values[2]!", "x", "Int", ""},
+		{"last index", values + "x = values[4]!", "x", "Int", ""},
+		{"constant index out of bounds", values + "x = values[10]!", "x", "", "index 10 out of bounds for values of length 5"},
+		{"negative constant index", values + "x = values[0 - 1]!", "x", "", "index -1 out of bounds: it must not be negative"},
+		{"constant name", values + "i = 3\nx = values[i + 1]!", "x", "Int", ""},
+		{"constant array value", "values = [1, 2, 3]\nx = values[2]!", "x", "Int", ""},
+		{"constant out of bounds of array value", "values = [1, 2, 3]\nx = values[3]!", "x", "", "index 3 out of bounds for values of length 3"},
+		{"string", `s = "abc"` + "\nx = s[1]!", "x", "Byte", ""},
+		{"tuple", "t = (1, 2)\nx = t[1]!", "x", "Int", ""},
+		{"range loop", values + "x = for i in 0..4 { values[i]! }", "x", "Nil", ""},
+		{"range loop past end", values + "x = for i in 0..5 { values[i]! }", "x", "", "cannot prove values[i]! is within bounds: i may not be less than 5"},
+		{"range loop with offset", values + "x = for i in 1..4 { values[i - 1]! }", "x", "Nil", ""},
+		{"range loop with negative offset", values + "x = for i in 0..4 { values[i - 1]! }", "x", "", "i - 1 may be negative"},
+		{"guard with constants", values + "f = fn(i: Int) Int {\n\tif i >= 0 && i < 5 { values[i]! } else { 0 }\n}\nx = f(1)", "x", "Int", ""},
+		{"reversed guard", values + "f = fn(i: Int) Int {\n\tif 0 <= i && 4 >= i { values[i]! } else { 0 }\n}\nx = f(1)", "x", "Int", ""},
+		{"guard without lower bound", values + "f = fn(i: Int) Int {\n\tif i < 5 { values[i]! } else { 0 }\n}\nx = f(1)", "x", "", "cannot prove values[i]! is within bounds: i may be negative"},
+		{"guard in else branch", values + "f = fn(i: Int) Int {\n\tif i >= 0 && i < 5 { 0 } else { values[i]! }\n}\nx = f(1)", "x", "", "i may be negative"},
+		{"unguarded parameter", values + "f = fn(i: Int) Int { values[i]! }\nx = f(1)", "x", "", "i may be negative"},
+		{"guard with len", "f = fn(values: []Int, i: Int) Int {\n\tif i < len(values) && i >= 0 { values[i]! } else { 0 }\n}\nx = f([1], 0)", "x", "Int", ""},
+		{"guard with len of another array", "f = fn(a: []Int, b: []Int, i: Int) Int {\n\tif i < len(b) && i >= 0 { a[i]! } else { 0 }\n}\nx = f([1], [2], 0)", "x", "", "i may not be less than len(a)"},
+		{"range to len", "f = fn(values: []Int) Int {\n\tfor i in 0..(len(values) - 1) { values[i]! }\n\t0\n}\nx = f([1])", "x", "Int", ""},
+		{"for loop condition", "f = fn(values: []Int) Int {\n\tfor i = 0; i < 100; i + 1 {\n\t\tif i < len(values) && i >= 0 { values[i]! }\n\t}\n\t0\n}\nx = f([1])", "x", "Int", ""},
+		{"for loop variable is not constant", values + "x = for i = 0; i < 10; i + 1 { values[i]! }", "x", "", "i may be negative"},
+		{"mutable index", values + "f = fn() Int {\n\ti = mut 0\n\tif i >= 0 && i < 5 { values[i]! } else { 0 }\n}\nx = f()", "x", "", "i may be negative"},
+		{"dynamic array with constant index", "f = fn(values: []Int) Int { values[0]! }\nx = f([1])", "x", "", "cannot prove values[0]! is within bounds: 0 may not be less than len(values)"},
+		{"unknown length", "f = fx() []Int { [1] }\nx = f()[0]!", "x", "", "the length of f() is not known"},
+		{"shadowed len", "len = fn(a: []Int) Int { 10 }\nf = fn(values: []Int, i: Int) Int {\n\tif i < len(values) && i >= 0 { values[i]! } else { 0 }\n}\nx = f([1], 0)", "x", "", "i may not be less than len(values)"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
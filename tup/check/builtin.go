@@ -0,0 +1,144 @@
+package check
+
+import (
+	"math/big"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// builtinArg returns the argument of a call of the builtin function name
+// with a single argument, written either as name(x) or x.name(), or nil if
+// call is not such a call. Builtins follow normal scope resolution, so a
+// declaration of the same name shadows them.
+func (c *Checker) builtinArg(call *ast.FunctionCall, name string) ast.Expression {
+	var arg ast.Expression
+	args := call.Arguments
+	switch callee := call.Function.(type) {
+	case *ast.Identifier, *ast.FunctionIdentifier:
+		if calleeName(callee) != name {
+			return nil
+		}
+		if args == nil || args.Args == nil || len(args.Args.Args) != 1 || args.LabeledArgs != nil {
+			return nil
+		}
+		arg = args.Args.Args[0].Expr
+	case *ast.MemberAccess:
+		member, ok := callee.Member.(*ast.Identifier)
+		if !ok || member.Name != name {
+			return nil
+		}
+		if args != nil && (args.Args != nil && len(args.Args.Args) > 0 || args.LabeledArgs != nil) {
+			return nil
+		}
+		object, ok := callee.Object.(ast.Expression)
+		if !ok {
+			return nil
+		}
+		if typ := c.info.Types[object]; typ != nil {
+			if tuple, ok := typ.Underlying().(*types.Tuple); ok && tuple.FieldIndex(name) >= 0 {
+				return nil
+			}
+		}
+		arg = object
+	default:
+		return nil
+	}
+	if c.scope.Lookup(name) != nil {
+		return nil
+	}
+	return arg
+}
+
+func calleeName(callee ast.Expression) string {
+	switch callee := callee.(type) {
+	case *ast.Identifier:
+		return callee.Name
+	case *ast.FunctionIdentifier:
+		return callee.Name
+	}
+	return ""
+}
+
+// builtinCall checks a call of a builtin function or of a function
+// synthesized for enum types, reporting false if call is not such a call.
+func (c *Checker) builtinCall(call *ast.FunctionCall) (types.Type, bool) {
+	if arg := c.builtinArg(call, "sizeof"); arg != nil {
+		c.info.Builtins[call] = "sizeof"
+		return c.sizeof(call, arg), true
+	}
+	if arg := c.builtinArg(call, "len"); arg != nil {
+		c.info.Builtins[call] = "len"
+		return c.lenCall(arg), true
+	}
+	if name := calleeName(call.Function); name == "print" && c.scope.Lookup(name) == nil {
+		c.info.Builtins[call] = "print"
+		c.print(call)
+		return types.Typ[types.Nil], true
+	}
+	// e.int() and e.string() convert a member of an enum to its value
+	// and name
+	for name, result := range enumFuncs {
+		if arg := c.builtinArg(call, name); arg != nil && types.IsEnum(c.info.Types[arg]) {
+			c.info.Builtins[call] = name
+			return result, true
+		}
+	}
+	return nil, false
+}
+
+// enumFuncs holds the result types of the functions synthesized for enum
+// types.
+var enumFuncs = map[string]types.Type{
+	"int":    types.Int,
+	"string": types.Typ[types.String],
+}
+
+// sizeof checks a call of the builtin sizeof, whose value is the size of
+// the type of its argument on the target and is known at compile time
+// unless that type depends on a type parameter.
+func (c *Checker) sizeof(call *ast.FunctionCall, arg ast.Expression) types.Type {
+	typ := c.info.Types[arg]
+	if typ == nil || types.IsInvalid(typ) || types.IsTypeParam(typ) {
+		return types.Int
+	}
+	size, err := c.target.Sizeof(typ)
+	if err != nil {
+		c.errorf(arg, "cannot take the size of %s: %s", arg, err)
+		return types.Int
+	}
+	v := &consteval.Int{Val: big.NewInt(size), Typ: types.Int}
+	c.builtins[call] = v
+	c.info.Values[call] = v
+	return types.Int
+}
+
+// lenCall checks a call of the builtin len, whose value is the number of
+// elements of an array, bytes of a string or fields of a tuple.
+func (c *Checker) lenCall(arg ast.Expression) types.Type {
+	typ := c.info.Types[arg]
+	if typ == nil || types.IsInvalid(typ) || types.IsTypeParam(typ) || types.IsString(typ) {
+		return types.Int
+	}
+	switch typ.Underlying().(type) {
+	case *types.Array, *types.Tuple:
+		return types.Int
+	}
+	c.errorf(arg, "invalid argument %s (%s) for len", arg, types.Default(typ))
+	return types.Int
+}
+
+// print checks a call of the builtin print, which writes its arguments to
+// standard output. Writing output is an effect, so print may not be called
+// from an fn.
+func (c *Checker) print(call *ast.FunctionCall) {
+	if call.Arguments != nil && call.Arguments.LabeledArgs != nil {
+		c.errorf(call.Arguments.LabeledArgs, "print takes no labeled arguments")
+	}
+	if c.pure() {
+		name := c.fn.decl.LHS.Name.Name
+		err := c.errorf(call, "call of fx print in fn %s: printing is an effect; declare the function with fx", name)
+		err.note(c.fn.decl.LHS.Name, "%s is declared here", name)
+	}
+}
@@ -0,0 +1,88 @@
+package check
+
+import (
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+)
+
+func TestSizeof(t *testing.T) {
+	const abc = "ABC = type(a: Byte, b: Int, c: Byte)\nv = ABC(1, 2, 3)\n"
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"literal", "x = sizeof(1)", "8"},
+		{"tuple", "x = sizeof((a: 1, b: 2))", "16"},
+		{"fields ordered by size", abc + "x = sizeof(v)", "16"},
+		{"cstruct", "@cstruct\n" + abc + "x = sizeof(v)", "24"},
+		{"method call syntax", abc + "x = v.sizeof()", "16"},
+		{"string", `x = sizeof("abc")`, "8"},
+		{"fixed array", "x = sizeof([4]Int16[1, 2, 3, 4])", "8"},
+		{"constant expression", "x = sizeof(1) * 2", "16"},
+		{"shadowed", "sizeof = fn(x: Int) Int { 0 }\nx = sizeof(1)", "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := checkSource(t, tt.input)
+			if err != nil {
+				t.Fatalf("Module(%q) = %v", tt.input, err)
+			}
+			obj := info.Scope.Lookup("x")
+			if obj.Type.String() != "Int" {
+				t.Errorf("Module(%q): type of x = %s, want Int", tt.input, obj.Type)
+			}
+			decl := obj.Decl.(*ast.Assignment)
+			got := info.ValueOf(decl.Right)
+			if got == nil {
+				t.Fatalf("Module(%q): ValueOf(%s) = nil", tt.input, decl.Right)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Module(%q): ValueOf(%s) = %s, want %s", tt.input, decl.Right, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSizeofArraySize(t *testing.T) {
+	RunCheckTest(t, "array size", "n = sizeof(1) / 4\nx = [n]Int[1, 2]", "x", "[2]Int", "")
+}
+
+func TestLen(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantType string
+		wantErr  string
+	}{
+		{"array", "x = len([1, 2, 3])", "Int", ""},
+		{"string", `x = len("abc")`, "Int", ""},
+		{"tuple", "x = len((1, 2))", "Int", ""},
+		{"method call syntax", "a = [1, 2]\nx = a.len()", "Int", ""},
+		{"comparison", "f = fn(a: []Int, n: Int) Bool { len(a) < n }\nx = f([1], 2)", "Bool", ""},
+		{"not a collection", "x = len(1)", "", "invalid argument 1 (Int) for len"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, "x", tt.wantType, tt.wantErr)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"in fx", "f = fx() { print(1, \"a\") }", "f", "fx()", ""},
+		{"at top level", "x = print()", "x", "Nil", ""},
+		{"shadowed", "print = fn(n: Int) Int { n }\nf = fn() Int { print(1) }", "f", "fn() Int", ""},
+		{"in fn", "f = fn() Int {\n\tprint(1)\n\t0\n}", "f", "", "call of fx print in fn f: printing is an effect; declare the function with fx"},
+		{"labeled", "f = fx() { print(value: 1) }", "f", "", "print takes no labeled arguments"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
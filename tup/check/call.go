@@ -0,0 +1,383 @@
+package check
+
+import (
+	"fmt"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/bind"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Partial describes a partial application f(args, *), whose value is a
+// closure over the supplied arguments that takes the remaining parameters.
+type Partial struct {
+	// Func is the type of the function applied.
+	Func *types.Function
+	// Params holds the indices of the parameters of Func taken by the
+	// closure, in order.
+	Params []int
+	// Type is the type of the closure.
+	Type *types.Function
+	// Lowered is a block equivalent to the partial application. It binds
+	// the supplied arguments, which are evaluated once, in order, and ends
+	// with a function block that takes the remaining parameters and calls
+	// the function with all of them.
+	Lowered *ast.Block
+}
+
+func (c *Checker) call(e *ast.FunctionCall) types.Type {
+	invalid := types.Typ[types.Invalid]
+	c.arguments(e.Arguments)
+
+	var fn types.Type
+	var recv ast.Expression
+	name := e.Function.String()
+	switch callee := e.Function.(type) {
+	case *ast.FunctionIdentifier:
+		fn = c.overload(e, nil, callee.Name)
+	case *ast.Identifier:
+		fn = c.overload(e, nil, callee.Name)
+	case *ast.MemberAccess:
+		object := c.memberObject(callee)
+		if ident, ok := callee.Member.(*ast.Identifier); ok {
+			if tuple, ok := object.Underlying().(*types.Tuple); ok && tuple.FieldIndex(ident.Name) >= 0 {
+				fn = tuple.Fields[tuple.FieldIndex(ident.Name)].Type
+			} else {
+				// uniform function call syntax: recv.f(args) calls f(recv, args)
+				recv, _ = callee.Object.(ast.Expression)
+				name = ident.Name
+				fn = c.overload(e, recv, name)
+			}
+		}
+	default:
+		fn = c.expr(e.Function)
+	}
+	if typ, ok := c.builtinCall(e); ok {
+		return typ
+	}
+	sig, ok := fn.(*types.Function)
+	if !ok {
+		// calls to builtins and unresolved functions are not yet checked,
+		// but a trailing block still binds it
+		c.functionBlock(e.FunctionBlock, invalid)
+		return invalid
+	}
+	partial := e.Arguments != nil && e.Arguments.PartialApplication
+	generic := sig
+	var targs map[string]types.Type
+	if sig.TypeParams != nil && !partial {
+		sig, targs = c.inferCall(e, recv, name, sig)
+	}
+	b, ok := c.bindCall(e, recv, name, sig)
+	if partial {
+		return c.partial(e, recv, sig, b.Args)
+	}
+	if ok && targs != nil {
+		sig = c.instance(e, name, generic, targs)
+		ok = sig != nil
+	}
+	if !ok {
+		// the call is not evaluated at compile time
+		return invalid
+	}
+	if sig.Result == nil {
+		return types.Typ[types.Nil]
+	}
+	return sig.Result
+}
+
+// callee returns the type of the function named name, or the invalid type
+// if it is not declared. Unknown callees are not reported because the
+// builtin functions are not declared.
+func (c *Checker) callee(name string) types.Type {
+	obj := c.scope.Lookup(name)
+	if obj == nil || obj.Kind == TypeObject {
+		return types.Typ[types.Invalid]
+	}
+	c.resolve(obj)
+	c.fallible(obj)
+	return obj.Type
+}
+
+// overload returns the type of the function named name that call, with
+// the receiver recv of a uniform function call, calls. Of the overloads
+// of a name, the call calls the first declared whose parameters its
+// arguments bind to and have the types of, which is recorded; if there is
+// none, the call is checked against the first declared.
+func (c *Checker) overload(call *ast.FunctionCall, recv ast.Expression, name string) types.Type {
+	obj := c.scope.Lookup(name)
+	if obj == nil || obj.Kind != FuncObject || len(obj.Overloads) == 0 {
+		return c.callee(name)
+	}
+	for _, obj := range append([]*Object{obj}, obj.Overloads...) {
+		c.resolve(obj)
+		if sig, ok := obj.Type.(*types.Function); ok && c.takes(call, recv, name, obj, sig) {
+			c.info.Overloads[call] = obj
+			c.fallible(obj)
+			return sig
+		}
+	}
+	return c.callee(name)
+}
+
+// takes reports whether the arguments of call, preceded by the receiver
+// recv, bind to the parameters of the function obj of type sig and have
+// their types. Trailing blocks and values piped into the call, which are
+// checked against the parameters they bind to, are taken to match.
+func (c *Checker) takes(call *ast.FunctionCall, recv ast.Expression, name string, obj *Object, sig *types.Function) bool {
+	var declared []ast.FunctionTypeParameter
+	if decl, ok := obj.Decl.(*ast.FunctionDeclaration); ok && decl.Type != nil {
+		declared = decl.Type.Parameters
+	}
+	b, errs := bind.Bind(bind.Params(sig, declared), bind.NewCall(call, recv, name))
+	if len(errs) > 0 {
+		return false
+	}
+	for i, args := range b.Args {
+		for _, arg := range args {
+			if arg.Expr == call.FunctionBlock || c.piped[arg] != nil {
+				continue
+			}
+			want := sig.Params[i].Type
+			if i == sig.Rest && !arg.Spread {
+				if array, ok := want.Underlying().(*types.Array); ok {
+					want = array.Elem
+				}
+			}
+			if !types.IsGeneric(want) && !c.assignable(c.info.Types[arg.Expr], want) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *Checker) arguments(args *ast.FunctionArguments) {
+	if args == nil {
+		return
+	}
+	if args.Args != nil {
+		for _, arg := range args.Args.Args {
+			c.expr(arg.Expr)
+		}
+	}
+	if args.LabeledArgs != nil {
+		for _, arg := range args.LabeledArgs.Args {
+			c.expr(arg.Argument.Expr)
+		}
+	}
+}
+
+// bindCall binds the arguments of call, preceded by the receiver recv of
+// a uniform function call if there is one, to the parameters of the
+// function of type sig named name, and checks them against the types of
+// the parameters they are bound to. A trailing block bound to the final
+// parameter is checked as a closure of its type. It reports whether the
+// arguments are bound without error.
+func (c *Checker) bindCall(call *ast.FunctionCall, recv ast.Expression, name string, sig *types.Function) (*bind.Binding, bool) {
+	n := len(c.errors)
+	b, errs := bind.Bind(bind.Params(sig, c.declParams(call, name)), bind.NewCall(call, recv, name))
+	for _, err := range errs {
+		c.errorf(err.Node, "%s", err.Msg)
+	}
+	c.info.Calls[call] = b
+
+	block := call.FunctionBlock
+	if block != nil {
+		if last := len(b.Args) - 1; last >= 0 && len(b.Args[last]) == 1 && b.Args[last][0].Expr == block {
+			c.closure(block, sig.Params[last].Type.Underlying().(*types.Function))
+		} else {
+			c.functionBlock(block, types.Typ[types.Invalid])
+		}
+	}
+	for i, args := range b.Args {
+		for _, arg := range args {
+			switch {
+			case arg.Expr == block:
+			case c.piped[arg] != nil:
+				c.pipedArg(c.piped[arg], arg, sig, i)
+			default:
+				c.argument(arg, sig, i, name)
+			}
+		}
+	}
+	return b, len(c.errors) == n
+}
+
+// declParams returns the declared parameters of the function called by
+// call, or nil if it does not call a declared function. They supply the
+// default values of the parameters.
+func (c *Checker) declParams(call *ast.FunctionCall, name string) []ast.FunctionTypeParameter {
+	switch callee := call.Function.(type) {
+	case *ast.Identifier, *ast.FunctionIdentifier, *ast.MemberAccess:
+		if member, ok := callee.(*ast.MemberAccess); ok {
+			if ident, ok := member.Member.(*ast.Identifier); !ok || ident.Name != name {
+				return nil
+			}
+		}
+		obj := c.scope.Lookup(name)
+		if overload := c.info.Overloads[call]; overload != nil {
+			obj = overload
+		}
+		if obj == nil || obj.Kind != FuncObject {
+			return nil
+		}
+		if decl, ok := obj.Decl.(*ast.FunctionDeclaration); ok && decl.Type != nil {
+			return decl.Type.Parameters
+		}
+	}
+	return nil
+}
+
+// argument checks the type of an argument bound to the i'th parameter of
+// sig. Each argument bound to a rest parameter is an element of it, unless
+// it is spread.
+func (c *Checker) argument(arg *ast.Argument, sig *types.Function, i int, name string) {
+	want := sig.Params[i].Type
+	if i == sig.Rest && !arg.Spread {
+		if array, ok := want.Underlying().(*types.Array); ok {
+			want = array.Elem
+		}
+	}
+	typ := c.info.Types[arg.Expr]
+	if types.IsInvalid(typ) || types.IsInvalid(want) || types.IsGeneric(want) || c.assignable(typ, want) {
+		return
+	}
+	c.errorf(arg.Expr, "cannot use %s as %s in argument to %s", types.Default(typ), want, name)
+}
+
+func isCallable(typ types.Type) bool {
+	_, ok := typ.Underlying().(*types.Function)
+	return ok
+}
+
+// closure checks a block passed as a function of type sig. Its
+// parameters, or it if it declares none and sig takes a single parameter,
+// receive the arguments, and the value of its body is the result.
+func (c *Checker) closure(block *ast.FunctionBlock, sig *types.Function) {
+	c.openScope()
+	defer c.closeScope()
+	bind := func(ident *ast.Identifier, typ types.Type) {
+		c.scope.Insert(&Object{Kind: VarObject, Name: ident.Name, Type: typ, Decl: block, ident: ident, state: resolved})
+	}
+	switch {
+	case block.Parameters != nil && block.Parameters.Parameters != nil:
+		lhs := block.Parameters.Parameters
+		if len(sig.Params) == 1 {
+			c.bindLHS(lhs, sig.Params[0].Type, false, bind)
+			break
+		}
+		if ordinal, ok := lhs.(*ast.OrdinalAssignmentLHS); ok {
+			if n := len(ordinal.Identifiers); n > len(sig.Params) || ordinal.RestOperator == nil && n != len(sig.Params) {
+				c.errorf(lhs, "block declares %d parameters but %s takes %d", n, sig, len(sig.Params))
+				for _, ident := range lhsIdentifiers(lhs) {
+					bind(ident, types.Typ[types.Invalid])
+				}
+				break
+			}
+		}
+		c.bindLHS(lhs, types.NewTuple(sig.Params...), false, bind)
+	case len(sig.Params) == 1:
+		c.scope.Insert(&Object{Kind: VarObject, Name: "it", Type: sig.Params[0].Type, Decl: block, state: resolved})
+	}
+	typ := c.blockBody(block.Body)
+	if sig.Result != nil && types.IsGeneric(sig.Result) && !types.IsInvalid(typ) {
+		// the result of the block gives the type of a generic result
+		c.record(block, types.NewFunction(sig.Params, types.Default(typ), sig.HasSideEffects))
+	} else {
+		c.record(block, sig)
+	}
+	if sig.Result != nil && block.Body.Expression != nil && !c.assignable(typ, sig.Result) && !types.IsGeneric(sig.Result) {
+		c.errorf(block.Body.Expression, "cannot use %s as %s in result of block", typ, sig.Result)
+	}
+}
+
+// partial checks a partial application of the function of type sig,
+// whose value is a closure taking the parameters without arguments.
+func (c *Checker) partial(e *ast.FunctionCall, recv ast.Expression, sig *types.Function, bound [][]*ast.Argument) types.Type {
+	closure := types.NewFunction(nil, sig.Result, sig.HasSideEffects)
+	p := &Partial{Func: sig, Type: closure}
+	for i, param := range sig.Params {
+		if bound[i] != nil {
+			continue
+		}
+		if i == sig.Rest {
+			closure.Rest = len(closure.Params)
+		}
+		closure.Params = append(closure.Params, param)
+		p.Params = append(p.Params, i)
+	}
+	p.Lowered = lowerPartial(e, recv, bound, p.Params, sig.Rest)
+	c.info.Partials[e] = p
+	return closure
+}
+
+// lowerPartial returns a block equivalent to the partial application e,
+// whose closure takes the parameters params.
+func lowerPartial(e *ast.FunctionCall, recv ast.Expression, bound [][]*ast.Argument, params []int, rest int) *ast.Block {
+	var prelude []ast.Statement
+	temps := map[ast.Expression]string{}
+	bind := func(expr ast.Expression) {
+		name := fmt.Sprintf("partial#arg%d", len(prelude))
+		prelude = append(prelude, ast.NewAssignment(
+			ast.NewOrdinalAssignmentLHS([]*ast.Identifier{ast.NewIdentifier(name, nil, 0, 0)}, nil), ast.Immutable, expr))
+		temps[expr] = name
+	}
+
+	var callee ast.Expression
+	switch f := e.Function.(type) {
+	case *ast.Identifier, *ast.FunctionIdentifier:
+		callee = ast.Clone(f).(ast.Expression)
+	case *ast.MemberAccess:
+		if recv != nil {
+			callee = ast.Clone(f.Member).(ast.Expression)
+			break
+		}
+		bind(f)
+		callee = ast.NewIdentifier(temps[f], nil, 0, 0)
+	default:
+		bind(e.Function)
+		callee = ast.NewIdentifier(temps[e.Function], nil, 0, 0)
+	}
+
+	// the supplied arguments are evaluated in the order written
+	if recv != nil {
+		bind(recv)
+	}
+	if e.Arguments.Args != nil {
+		for _, arg := range e.Arguments.Args.Args {
+			bind(arg.Expr)
+		}
+	}
+	if e.Arguments.LabeledArgs != nil {
+		for _, arg := range e.Arguments.LabeledArgs.Args {
+			bind(arg.Argument.Expr)
+		}
+	}
+
+	var idents []*ast.Identifier
+	var args []*ast.Argument
+	for i, argsOf := range bound {
+		for _, arg := range argsOf {
+			if arg.Expr == e.FunctionBlock {
+				// the block is passed to the call as its trailing block
+				continue
+			}
+			args = append(args, ast.NewArgument(ast.NewIdentifier(temps[arg.Expr], nil, 0, 0), arg.Spread))
+		}
+		for _, p := range params {
+			if p == i {
+				name := fmt.Sprintf("partial#param%d", i)
+				idents = append(idents, ast.NewIdentifier(name, nil, 0, 0))
+				args = append(args, ast.NewArgument(ast.NewIdentifier(name, nil, 0, 0), i == rest))
+			}
+		}
+	}
+	call := ast.NewFunctionCall(callee, nil, ast.NewFunctionArguments(ast.NewArguments(args), nil, false), e.FunctionBlock)
+	var parameters *ast.BlockParameters
+	if len(idents) > 0 {
+		parameters = ast.NewBlockParameters(ast.NewOrdinalAssignmentLHS(idents, nil))
+	}
+	closure := ast.NewFunctionBlock(parameters, ast.NewBlockBody(nil, call))
+	return ast.NewBlock(ast.NewBlockBody(prelude, closure))
+}
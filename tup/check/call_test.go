@@ -0,0 +1,110 @@
+package check
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+)
+
+const callFuncs = "add = fn(a: Int, b: Int) Int { a + b }\n" +
+	"sum = fn(base: Int, xs: ...Int) Int { base }\n" +
+	"apply = fn(x: Int, f: fn(Int) Int) Int { f(x) }\n" +
+	"fold = fn(f: fn(Int, Int) Int) Int { f(1, 2) }\n" +
+	"double = fn(x: Int) Int { x * 2 }\n" +
+	"process_args = fn(args: ...Int, transform: fn(Int) Int) Int {\n\tfor acc = 0; v in args { acc + transform(v) }\n}\n" +
+	"hello = fn(entity: \"World\") String { \"Hello, \" + entity + \"!\" }\n"
+
+const overloads = "o = fn(n: Int) Int { n }\no = fn(s: String) String { s }\no = fn(a: Int, b: Int) Bool { a < b }\n"
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"partial", "x = add(1, *)", "x", "fn(b: Int) Int", ""},
+		{"partial labeled", "x = add(b: 2, *)", "x", "fn(a: Int) Int", ""},
+		{"partial of all", "x = add(1, 2, *)", "x", "fn() Int", ""},
+		{"partial rest", "x = sum(1, *)", "x", "fn(xs: ...Int) Int", ""},
+		{"partial rest and callable", "x = process_args(1, 2, *)", "x", "fn(transform: fn(Int) Int) Int", ""},
+		{"partial uniform call", "n = 1\nx = n.add(2, *)", "x", "fn() Int", ""},
+		{"rest and callable", "x = process_args(1, 2, 3, 4, double)", "x", "Int", ""},
+		{"rest and trailing block", "x = process_args(1, 2, 3, 4) { |x| x * 2 }", "x", "Int", ""},
+		{"rest and labeled callable", "x = process_args(1, 2, 3, transform: double)", "x", "Int", ""},
+		{"trailing block with it", "x = apply(2) { it * 3 }", "x", "Int", ""},
+		{"trailing block parameters", "x = fold() { |a, b| a + b }", "x", "Int", ""},
+		{"index initialization", "Indices = [8]Int\nx = Indices { it }", "x", "Indices", ""},
+		{"it in switch block", "x = switch 1 {\n\t1 { it }\n\telse { 0 }\n}", "x", "Int", ""},
+		{"it in unresolved call", "x = [1.5].map() { it.int() }", "x", "invalid type", ""},
+		{"block result", "x = apply(2) { \"s\" }", "x", "", "cannot use String as Int in result of block"},
+		{"element result", "Indices = [8]Int\nx = Indices { \"s\" }", "x", "", "cannot use String as Int in result of block"},
+		{"block arity", "x = fold() { |a| a }", "x", "", "block declares 1 parameters but fn(Int, Int) Int takes 2"},
+		{"it with two parameters", "x = fold() { it }", "x", "", "it is not defined"},
+		{"it outside block", "x = it", "x", "", "it is not defined"},
+		{"not callable", "x = add(1) { it }", "x", "", "cannot pass a trailing block to add: its final parameter is not callable"},
+		{"too many arguments", "x = add(1, 2, 3)", "x", "", "too many arguments in call to add"},
+		{"no such parameter", "x = add(1, c: 2)", "x", "", "add has no parameter c"},
+		{"parameter given twice", "x = add(1, a: 2)", "x", "", "parameter a of add is given more than once"},
+		{"block given twice", "x = apply(2, f: double) { it }", "x", "", "parameter f of apply is given more than once"},
+		{"overload by type", overloads + "x = o(\"a\")", "x", "String", ""},
+		{"overload by arity", overloads + "x = o(1, 2)", "x", "Bool", ""},
+		{"overload by receiver", overloads + "x = \"a\".o()", "x", "String", ""},
+		{"overload by label", overloads + "x = o(b: 1, a: 2)", "x", "Bool", ""},
+		{"no overload", overloads + "x = o(true)", "x", "", "cannot use Bool as Int in argument to o"},
+		{"default", "x = hello()", "x", "String", ""},
+		{"default overridden", "x = hello(\"John\")", "x", "String", ""},
+		{"spread", "xs = [2, 3]\nx = sum(1, ...xs)", "x", "Int", ""},
+		{"labeled rest", "x = sum(1, xs: 2)", "x", "Int", ""},
+		{"missing argument", "x = add(1)", "x", "", "missing argument for parameter b in call to add"},
+		{"argument type", "x = add(1, \"2\")", "x", "", "cannot use String as Int in argument to add"},
+		{"default type", "x = hello(1)", "x", "", "cannot use Int as String in argument to hello"},
+		{"rest element type", "x = sum(1, 2, \"3\")", "x", "", "cannot use String as Int in argument to sum"},
+		{"spread type", "xs = [\"a\"]\nx = sum(1, ...xs)", "x", "", "cannot use []String as []Int in argument to sum"},
+		{"spread into other parameter", "xs = [1, 2]\nx = add(...xs)", "x", "", "cannot spread an argument into parameter a of add"},
+		{"two rest parameters", "f = fn(a: ...Int, b: ...Int) Int { 0 }", "f", "", "a function may have at most one rest parameter"},
+		{"rest not last", "f = fn(a: ...Int, b: Int) Int { 0 }", "f", "", "a rest parameter must be the last parameter or be followed by a single callable parameter"},
+		{"rest followed by two", "f = fn(a: ...Int, b: fn(Int) Int, c: fn(Int) Int) Int { 0 }", "f", "", "a rest parameter must be the last parameter"},
+		{"rest in function type", "f = fn(g: fn(...Int, Int) Int) Int { 0 }", "f", "", "a rest parameter must be the last parameter"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, callFuncs+tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+func TestPartialLowered(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"positional", "x = add(1, *)", "partial#arg0 = 1; { |partial#param1| add(partial#arg0, partial#param1) }"},
+		{"labeled", "x = add(b: 2, *)", "partial#arg0 = 2; { |partial#param0| add(partial#param0, partial#arg0) }"},
+		{"rest", "x = sum(1, *)", "partial#arg0 = 1; { |partial#param1| sum(partial#arg0, ...partial#param1) }"},
+		{"uniform call", "n = 1\nx = n.add(2, *)", "partial#arg0 = n; partial#arg1 = 2; { add(partial#arg0, partial#arg1) }"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := callFuncs + tt.input
+			info, err := checkSource(t, input)
+			if err != nil {
+				t.Fatalf("Module(%q) = %v", input, err)
+			}
+			call := info.Scope.Lookup("x").Decl.(*ast.Assignment).Right.(*ast.FunctionCall)
+			partial := info.Partials[call]
+			if partial == nil {
+				t.Fatalf("Partials[%s] = nil", call)
+			}
+			var parts []string
+			for _, stmt := range partial.Lowered.Body.Statements {
+				parts = append(parts, stmt.String())
+			}
+			parts = append(parts, partial.Lowered.Body.Expression.String())
+			if got := strings.Join(parts, "; "); got != tt.want {
+				t.Errorf("Lowered = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
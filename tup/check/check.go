@@ -0,0 +1,327 @@
+// Package check implements the semantic analysis of Tuppence modules:
+// name resolution, type checking and compile-time expansion of
+// inline for loops.
+package check
+
+import (
+	"path/filepath"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/bind"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/layout"
+	"github.com/rowland/tuppence/tup/match"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Info holds the results of checking a module.
+type Info struct {
+	// Scope is the module scope holding the top-level declarations.
+	Scope *Scope
+	// Types maps each checked expression to its type, and each function
+	// declaration to its signature.
+	Types map[ast.Node]types.Type
+	// InlineFors maps each inline for expression to its unrolled form.
+	InlineFors map[*ast.InlineForExpression]*InlineFor
+	// Values maps the expressions evaluated at compile time to their values.
+	Values map[ast.Expression]consteval.Value
+	// Typeofs maps the typeof expressions whose type is known at compile
+	// time to that type.
+	Typeofs map[*ast.TypeofExpression]types.Type
+	// Builtins maps the calls of builtin functions, and of the functions
+	// synthesized for enum types, to the name of the function called.
+	Builtins map[*ast.FunctionCall]string
+	// Descriptors holds the descriptors of the types typeof may produce.
+	Descriptors *types.Table
+	// Matches maps each switch expression to the decision tree compiled
+	// from its cases.
+	Matches map[*ast.SwitchExpression]*match.Tree
+	// Partials maps each partial application to the closure it produces.
+	Partials map[*ast.FunctionCall]*Partial
+	// Pipelines maps each chained expression to the calls it is lowered to.
+	Pipelines map[*ast.ChainedExpression]*Pipeline
+	// Calls maps each call of a function whose type is known, including
+	// the calls that pipelines and processors are lowered to, to the
+	// binding of its arguments to the parameters of the function.
+	Calls map[*ast.FunctionCall]*bind.Binding
+	// Overloads maps each call of an overloaded function to the overload
+	// it calls.
+	Overloads map[*ast.FunctionCall]*Object
+	// Stringers maps the interpolations whose value is converted by a
+	// declared string function to that function. Values converted by a
+	// builtin have no entry.
+	Stringers map[*ast.Interpolation]*Object
+	// Warnings holds the problems found that do not prevent the module
+	// from being used, such as discarded errors.
+	Warnings Errors
+	// Processors maps each multi-line string literal with a processor to
+	// the call of the processor it is lowered to.
+	Processors map[*ast.MultiLineStringLiteral]*ast.FunctionCall
+	// Instances maps each call of a generic function to the instance it
+	// calls.
+	Instances map[*ast.FunctionCall]*Instance
+}
+
+// TypeOf returns the type recorded for node, or nil if there is none.
+func (info *Info) TypeOf(node ast.Node) types.Type {
+	return info.Types[node]
+}
+
+// ValueOf returns the compile-time value recorded for expr, or nil if
+// there is none.
+func (info *Info) ValueOf(expr ast.Expression) consteval.Value {
+	return info.Values[expr]
+}
+
+// Checker holds the state used while checking a module.
+type Checker struct {
+	info   *Info
+	errors Errors
+	scope  *Scope
+	eval   *consteval.Evaluator
+
+	// queue of function bodies, checked after all top-level
+	// declarations have been resolved
+	funcs []*funcBody
+	// function whose body is being checked, or nil
+	fn *funcBody
+	// for loops enclosing the expression being checked, innermost last
+	loops []*loop
+
+	// bounds on immutable integer variables implied by the enclosing
+	// conditions and loops, used to prove safe indexing
+	facts []fact
+
+	// target for which sizeof is computed
+	target *layout.Target
+	// values of the calls of builtins known at compile time
+	builtins map[*ast.FunctionCall]consteval.Value
+	// arguments holding the value piped into a stage of a pipeline, and
+	// the stage
+	piped map[*ast.Argument]*ast.FunctionCall
+	// bodies of the declared functions, checked or queued
+	bodies map[*ast.FunctionDeclaration]*funcBody
+
+	// instances of the core Range type, by name
+	ranges map[string]*types.Named
+
+	// directory of the module, to which meta functions are confined
+	dir string
+	// files being included, innermost last
+	including []string
+	// meta expressions already evaluated
+	metaSeen map[*ast.MetaExpression]bool
+}
+
+type funcBody struct {
+	decl  *ast.FunctionDeclaration
+	sig   *types.Function
+	scope *Scope
+	state objectState
+	// params is the scope binding the parameters, whose child is the
+	// outermost scope of the body
+	params *Scope
+
+	// infer is set if the result is declared as !T, whose error set is
+	// inferred from the body; errs collects the error types it may return
+	infer bool
+	errs  []types.Type
+}
+
+// NewChecker returns a new Checker for a single module.
+func NewChecker() *Checker {
+	c := &Checker{
+		info: &Info{
+			Scope:       NewScope(Universe),
+			Types:       map[ast.Node]types.Type{},
+			InlineFors:  map[*ast.InlineForExpression]*InlineFor{},
+			Values:      map[ast.Expression]consteval.Value{},
+			Typeofs:     map[*ast.TypeofExpression]types.Type{},
+			Builtins:    map[*ast.FunctionCall]string{},
+			Matches:     map[*ast.SwitchExpression]*match.Tree{},
+			Partials:    map[*ast.FunctionCall]*Partial{},
+			Pipelines:   map[*ast.ChainedExpression]*Pipeline{},
+			Calls:       map[*ast.FunctionCall]*bind.Binding{},
+			Overloads:   map[*ast.FunctionCall]*Object{},
+			Stringers:   map[*ast.Interpolation]*Object{},
+			Processors:  map[*ast.MultiLineStringLiteral]*ast.FunctionCall{},
+			Instances:   map[*ast.FunctionCall]*Instance{},
+			Descriptors: types.NewTable(),
+		},
+		target:   layout.Target64,
+		builtins: map[*ast.FunctionCall]consteval.Value{},
+		piped:    map[*ast.Argument]*ast.FunctionCall{},
+		bodies:   map[*ast.FunctionDeclaration]*funcBody{},
+		ranges:   map[string]*types.Named{},
+		metaSeen: map[*ast.MetaExpression]bool{},
+	}
+	c.eval = consteval.NewEvaluator(constResolver{c})
+	return c
+}
+
+// Module checks module and returns the information recorded about it.
+// If any errors are found, the returned error is of type Errors.
+func Module(module *ast.Module) (*Info, error) {
+	c := NewChecker()
+	c.module(module)
+	if len(c.errors) > 0 {
+		return c.info, c.errors
+	}
+	return c.info, nil
+}
+
+func (c *Checker) module(module *ast.Module) {
+	c.scope = c.info.Scope
+	c.dir = moduleDir(module)
+	for _, src := range module.Sources {
+		c.including = append(c.including, filepath.Base(src.Filename))
+	}
+
+	var items []ast.TopLevelItem
+	for _, item := range c.expandMeta(module.TopLevelItems) {
+		items = append(items, unexport(item))
+	}
+
+	for _, item := range items {
+		c.collect(item)
+	}
+	for _, item := range items {
+		switch item := item.(type) {
+		case *ast.Assignment:
+			for _, obj := range c.lhsObjects(item.Left) {
+				c.resolve(obj)
+			}
+		case *ast.TypeDeclaration:
+			c.resolve(c.scope.LookupLocal(item.LHS.Name.Name))
+		case *ast.FunctionDeclaration:
+			c.funcDecl(item)
+		}
+	}
+	for len(c.funcs) > 0 {
+		body := c.funcs[0]
+		c.funcs = c.funcs[1:]
+		if body.state == unresolved {
+			c.funcBody(body)
+		}
+	}
+}
+
+// unexport returns the declaration wrapped by an export declaration.
+func unexport(item ast.TopLevelItem) ast.TopLevelItem {
+	switch item := item.(type) {
+	case *ast.ExportAssignment:
+		return &item.Assignment
+	case *ast.ExportFunctionDeclaration:
+		return item.Function
+	case *ast.ExportTypeDeclaration:
+		return &item.Type
+	}
+	return item
+}
+
+// Declares returns the names declared by a top-level item, in the order
+// they are written.
+func Declares(item ast.TopLevelItem) []string {
+	var names []string
+	switch item := unexport(item).(type) {
+	case *ast.Assignment:
+		for _, ident := range lhsIdentifiers(item.Left) {
+			names = append(names, ident.Name)
+		}
+		for _, ident := range lhsTypeNames(item.Left) {
+			names = append(names, ident.Name)
+		}
+	case *ast.TypeDeclaration:
+		names = append(names, item.LHS.Name.Name)
+	case *ast.FunctionDeclaration:
+		names = append(names, item.LHS.Name.Name)
+	}
+	return names
+}
+
+// collect declares the names introduced by a top-level item without
+// checking it, so that declarations may refer to each other in any order.
+func (c *Checker) collect(item ast.TopLevelItem) {
+	switch item := item.(type) {
+	case *ast.Assignment:
+		for _, ident := range lhsIdentifiers(item.Left) {
+			c.declare(&Object{Kind: VarObject, Name: ident.Name, Decl: item, Mutable: item.Mut}, ident)
+		}
+		for _, ident := range lhsTypeNames(item.Left) {
+			// types imported from other modules are not yet resolved
+			c.declare(&Object{Kind: TypeObject, Name: ident.Name, Type: types.Typ[types.Invalid], Decl: item, state: resolved}, ident)
+		}
+	case *ast.TypeDeclaration:
+		named := types.NewNamed(item.LHS.Name.Name, nil, declAnnotations(item)...)
+		c.declare(&Object{Kind: TypeObject, Name: named.Name(), Type: named, Decl: item}, item.LHS.Name)
+	case *ast.FunctionDeclaration:
+		name := item.LHS.Name.Name
+		if prev := c.scope.LookupLocal(name); prev != nil && prev.Kind == FuncObject {
+			// overloads are distinguished by their parameter types
+			prev.Overloads = append(prev.Overloads, &Object{Kind: FuncObject, Name: name, Decl: item, ident: item.LHS.Name})
+			return
+		}
+		c.declare(&Object{Kind: FuncObject, Name: name, Decl: item}, item.LHS.Name)
+	}
+}
+
+func (c *Checker) declare(obj *Object, at ast.Node) {
+	if prev := c.scope.LookupLocal(obj.Name); prev != nil && prev.Decl != nil {
+		c.errorf(at, "%s redeclared in this module", obj.Name).note(prev.declNode(), "%s is declared here", obj.Name)
+		return
+	}
+	obj.ident = at
+	c.scope.Insert(obj)
+}
+
+// resolve completes a top-level object whose declaration has not yet been
+// checked.
+func (c *Checker) resolve(obj *Object) {
+	if obj == nil || obj.state == resolved {
+		return
+	}
+	if obj.state == resolving {
+		if obj.Kind == VarObject {
+			c.errorf(obj.Decl, "initialization cycle: %s refers to itself", obj.Name)
+			obj.Type = types.Typ[types.Invalid]
+		}
+		return
+	}
+	obj.state = resolving
+
+	saved := c.scope
+	c.scope = c.info.Scope
+	defer func() { c.scope = saved }()
+
+	switch decl := obj.Decl.(type) {
+	case *ast.Assignment:
+		typ := c.expr(decl.Right)
+		c.bindLHS(decl.Left, typ, decl.Mut, func(ident *ast.Identifier, typ types.Type) {
+			if obj := c.scope.LookupLocal(ident.Name); obj != nil {
+				obj.Type = typ
+				obj.state = resolved
+			}
+		})
+		c.constAssignment(decl)
+	case *ast.TypeDeclaration:
+		c.typeDecl(obj.Type.(*types.Named), decl)
+	case *ast.FunctionDeclaration:
+		sig := c.signature(decl.Type)
+		typeParams(decl, sig)
+		obj.Type = sig
+	}
+	if obj.Type == nil {
+		obj.Type = types.Typ[types.Invalid]
+	}
+	obj.state = resolved
+}
+
+func annotationNames(annotations []ast.Annotation) []string {
+	var names []string
+	for _, annotation := range annotations {
+		if simple, ok := annotation.(*ast.SimpleAnnotation); ok {
+			names = append(names, simple.Identifier)
+		}
+	}
+	return names
+}
@@ -0,0 +1,143 @@
+package check
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+)
+
+// checkSource parses and checks input as a module named "test".
+func checkSource(t *testing.T, input string) (*Info, error) {
+	t.Helper()
+	src := source.NewSource([]byte(input), "test.tup")
+	module, err := parse.Module(src, ast.NewModule("test"))
+	if err != nil {
+		t.Fatalf("parse.Module(%q) = %v", input, err)
+	}
+	return Module(module)
+}
+
+// RunCheckTest checks input and compares the type of the top-level value
+// named name with wantType, or the error with wantErr when it is not empty.
+func RunCheckTest(t *testing.T, subtestName, input, name, wantType, wantErr string) {
+	t.Helper()
+	t.Run(subtestName, func(t *testing.T) {
+		t.Helper()
+		info, err := checkSource(t, input)
+		if wantErr != "" {
+			if err == nil {
+				t.Fatalf("Module(%q) = nil, want error containing %q", input, wantErr)
+			}
+			if !strings.Contains(err.Error(), wantErr) {
+				t.Fatalf("Module(%q) = %v, want error containing %q", input, err, wantErr)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Module(%q) = %v, want nil", input, err)
+		}
+		obj := info.Scope.Lookup(name)
+		if obj == nil {
+			t.Fatalf("Module(%q): %s not declared", input, name)
+		}
+		if got := obj.Type.String(); got != wantType {
+			t.Errorf("Module(%q): type of %s = %s, want %s", input, name, got, wantType)
+		}
+	})
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"integer literal", "x = 1", "x", "Int", ""},
+		{"float literal", "x = 1.5", "x", "Float", ""},
+		{"string literal", `x = "hi"`, "x", "String", ""},
+		{"symbol literal", "x = :hi", "x", "Symbol", ""},
+		{"arithmetic with untyped operand", "a = 1.5\nx = a + 2", "x", "Float", ""},
+		{"string concatenation", `x = "a" + "b"`, "x", "String", ""},
+		{"comparison", "x = 1 < 2", "x", "Bool", ""},
+		{"labeled tuple", `x = (a: 1, b: "b")`, "x", "(a: Int, b: String)", ""},
+		{"spread tuple", "a = (b: 1)\nx = (...a, c: 2.0)", "x", "(b: Int, c: Float)", ""},
+		{"duplicate spread field", "a = (b: 1)\nx = (...a, b: 2)", "x", "", "duplicate field b"},
+		{"spread of non-tuple", "a = 1\nx = (...a, b: 2)", "x", "", "cannot spread Int"},
+		{"array literal", "x = [1, 2, 3]", "x", "[]Int", ""},
+		{"typed array literal", "x = Int[]", "x", "[]Int", ""},
+		{"empty array literal", "x = []", "x", "", "cannot infer element type of []"},
+		{"type constructor", "P = type(a: Int)\nx = P(1)", "x", "P", ""},
+		{"labeled constructor arguments", "P = type(x: Int, y: Int)\nx = P(y: 2, x: 1)", "x", "P", ""},
+		{"constructor argument type", "P = type(x: Int, y: Int)\nx = P(1, \"two\", 3.5, true)", "x", "", "cannot use String as Int in construction of P"},
+		{"extra constructor argument", "P = type(x: Int, y: Int)\nx = P(1, 2, 3)", "x", "", "too many arguments in construction of P"},
+		{"missing constructor argument", "P = type(x: Int, y: Int)\nx = P(1)", "x", "", "missing argument for field y in construction of P"},
+		{"unknown constructor field", "P = type(x: Int, y: Int)\nx = P(1, z: 2)", "x", "", "P has no field z"},
+		{"constructor field given twice", "P = type(x: Int, y: Int)\nx = P(1, 2, x: 3)", "x", "", "field x of P is given more than once"},
+		{"member access", "P = type(a: Int, b: String)\np = P(1, \"b\")\nx = p.b", "x", "String", ""},
+		{"missing field", "P = type(a: Int)\np = P(1)\nx = p.b", "x", "", "P has no field b"},
+		{"destructuring", "a, b = (1, \"b\")\nx = b", "x", "String", ""},
+		{"labeled destructuring", "(b, a) = (a: 1, b: \"b\")\nx = a", "x", "Int", ""},
+		{"rest destructuring", "a, ...b = (1, 2.0, \"c\")\nx = b", "x", "(Float, String)", ""},
+		{"forward reference", "x = y + 1\ny = 2", "x", "Int", ""},
+		{"initialization cycle", "x = y\ny = x", "x", "", "initialization cycle"},
+		{"mismatched types", `x = 1 + "a"`, "x", "", "mismatched types untyped Int and String in +"},
+		{"undefined", "x = y", "x", "", "undefined: y"},
+		{"undefined type", "x = Q(1)", "x", "", "undefined type: Q"},
+		{"redeclared", "x = 1\nx = 2", "x", "", "x redeclared in this module"},
+		{"if without else", "c = true\nx = if c { 1 }", "x", "Int | Nil", ""},
+		{"if with else", "c = true\nx = if c { 1 } else { \"a\" }", "x", "Int | String", ""},
+		{"function result", "f = fn(a: Int) Int { a }\nx = f(1)", "x", "Int", ""},
+		{"function return mismatch", "f = fn(a: Int) String { a }", "f", "", "cannot use Int as String in return value of f"},
+		{"fx function", "f = fx(s: String) { s }", "f", "fx(s: String)", ""},
+		{"range", "x = 1..5", "x", "Range[Int]", ""},
+		{"slice", "a = [1, 2, 3]\nx = a[1..2]", "x", "[]Int", ""},
+		{"slice of fixed array", "a = [3]Int[1, 2, 3]\nx = a[1..2]", "x", "[]Int", ""},
+		{"slice of string", "s = \"abc\"\nx = s[1..2]", "x", "String", ""},
+		{"slice index type", "a = [1, 2, 3]\nx = a[1.0..2.0]", "x", "", "invalid slice index type Float"},
+		{"slice of tuple", "a = (1, 2)\nx = a[0..1]", "x", "", "cannot slice (Int, Int)"},
+		{"error declaration", "E = error(code: Int)\nx = E(1)", "x", "E", ""},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	_, err := checkSource(t, "x = 1\ny = z + x\n")
+	if err == nil {
+		t.Fatal("Module() = nil, want error")
+	}
+	want := "error: undefined: z\n--> test.tup:2:5"
+	if err.Error() != want {
+		t.Errorf("Module() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestDeclares(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"x = 1", "x"},
+		{"a, _, ...b = (1, 2, 3)", "a b"},
+		{"(b, a) = (a: 1, b: 2)", "b a"},
+		{"f = fn(a: Int) Int { a }", "f"},
+		{"P = type(a: Int)", "P"},
+		{"x: 1", "x"},
+	}
+	for _, tt := range tests {
+		src := source.NewSource([]byte(tt.input), "test.tup")
+		module, err := parse.Module(src, ast.NewModule("test"))
+		if err != nil {
+			t.Fatalf("parse.Module(%q) = %v", tt.input, err)
+		}
+		if got := strings.Join(Declares(module.TopLevelItems[0]), " "); got != tt.want {
+			t.Errorf("Declares(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
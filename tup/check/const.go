@@ -0,0 +1,112 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// constResolver gives the compile-time evaluator access to the objects in
+// the checker's current scope.
+type constResolver struct {
+	c *Checker
+}
+
+func (r constResolver) Lookup(name string) consteval.Value {
+	obj := r.c.scope.Lookup(name)
+	if obj == nil {
+		return nil
+	}
+	r.c.resolve(obj)
+	switch obj.Kind {
+	case VarObject:
+		if !obj.Mutable {
+			return obj.Const
+		}
+	case FuncObject:
+		return r.function(obj)
+	}
+	return nil
+}
+
+// function returns the value of the declared function obj, or nil if it
+// has side effects.
+func (r constResolver) function(obj *Object) consteval.Value {
+	decl, ok := obj.Decl.(*ast.FunctionDeclaration)
+	if !ok {
+		return nil
+	}
+	sig, _ := obj.Type.(*types.Function)
+	if sig != nil && sig.HasSideEffects {
+		return nil
+	}
+	// the evaluation of a call relies on the types of the body
+	r.c.checkBody(obj)
+	return &consteval.Func{Decl: decl, Sig: sig}
+}
+
+func (r constResolver) TypeOf(expr ast.Expression) types.Type {
+	return r.c.info.Types[expr]
+}
+
+func (r constResolver) Builtin(call *ast.FunctionCall) (string, consteval.Value) {
+	return r.c.info.Builtins[call], r.c.builtins[call]
+}
+
+func (r constResolver) Described(e *ast.TypeofExpression) types.Type {
+	return r.c.info.Typeofs[e]
+}
+
+func (r constResolver) Overload(call *ast.FunctionCall) (consteval.Value, bool) {
+	if obj := r.c.info.Overloads[call]; obj != nil {
+		return r.function(obj), true
+	}
+	return nil, false
+}
+
+func (r constResolver) Processor(lit *ast.MultiLineStringLiteral) *ast.FunctionCall {
+	return r.c.info.Processors[lit]
+}
+
+// constant evaluates a checked expression at compile time. Failures other
+// than the expression not being constant, such as overflows, are reported.
+// Expressions found invalid by the checker are not evaluated.
+func (c *Checker) constant(expr ast.Expression) (consteval.Value, bool) {
+	if typ, ok := c.info.Types[expr]; ok && types.IsInvalid(typ) {
+		return nil, false
+	}
+	v, err := c.eval.Eval(expr)
+	if err != nil {
+		if err, ok := err.(*consteval.Error); ok && !err.NotConstant {
+			c.errorf(err.Node, "%s", err.Msg)
+		}
+		return nil, false
+	}
+	c.info.Values[expr] = v
+	return v, true
+}
+
+// constAssignment records the compile-time values of the names bound by an
+// immutable assignment whose right-hand side is constant. Destructuring
+// errors have already been reported by bindLHS.
+func (c *Checker) constAssignment(assignment *ast.Assignment) {
+	if assignment.Mut {
+		return
+	}
+	v, ok := c.constant(assignment.Right)
+	if !ok {
+		return
+	}
+	consteval.Bind(assignment.Left, v, func(ident *ast.Identifier, v consteval.Value) {
+		obj := c.scope.LookupLocal(ident.Name)
+		if obj == nil || obj.Kind != VarObject {
+			return
+		}
+		v, err := consteval.Convert(v, obj.Type)
+		if err != nil {
+			c.errorf(ident, "%s", err)
+			return
+		}
+		obj.Const = v
+	})
+}
@@ -0,0 +1,82 @@
+package check
+
+import (
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+)
+
+func TestConst(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"array size", "x = [2]Int[1, 2]", "x", "[2]Int", ""},
+		{"constant array size", "n = 4 / 2\nx = [n]Int[1, 2]", "x", "[2]Int", ""},
+		{"array size from pure function", "size = fn(n: Int) Int { n * n }\nn = size(3)\nA = [n]Byte", "A", "A", ""},
+		{"mutable array size", "n = mut 2\nx = [n]Int[1, 2]", "x", "", "array size n is not a compile-time constant"},
+		{"array size from fx", "size = fx() Int { 2 }\nn = size()\nx = [n]Int[1, 2]", "x", "", "array size n is not a compile-time constant"},
+		{"non-integer array size", "n = 1.5\nx = [n]Int[1, 2]", "x", "", "array size n must be an integer, not Float"},
+		{"negative array size", "n = 0 - 1\nx = [n]Int[1, 2]", "x", "", "invalid array size -1"},
+		{"overflow", "x = 9223372036854775807 + 1", "x", "", "constant 9223372036854775808 overflows Int"},
+		{"overflow through name", "n = 9223372036854775807\nx = n * 2", "x", "", "constant 18446744073709551614 overflows Int"},
+		{"overflow in pure function", "double = fn(n: Int) Int { n * 2 }\nx = double(9223372036854775807)", "x", "", "constant 18446744073709551614 overflows Int"},
+		{"overflow in pure function reported at call", "double = fn(n: Int) Int { n * 2 }\nx = 1 + double(9223372036854775807)", "x", "", "overflows Int\n--> test.tup:2:9"},
+		{"division by zero", "x = 1 / 0", "x", "", "division by zero"},
+		{"conversion", "x = Int8(100)", "x", "Int8", ""},
+		{"conversion overflow", "x = Int8(200)", "x", "", "constant 200 overflows Int8"},
+		{"conversion of negative to unsigned", "n = -1\nx = UInt8(n)", "x", "", "constant -1 overflows UInt8"},
+		{"conversion overflow in function", "f = fx() Int8 { Int8(300) }", "f", "", "constant 300 overflows Int8"},
+		{"conversion overflow in pure function", "narrow = fn(n: Int) Int8 { Int8(n) }\nx = narrow(200)", "x", "", "constant 200 overflows Int8"},
+		{"conversion of runtime value", "f = fn(n: Int) Int8 { Int8(n) }", "f", "fn(n: Int) Int8", ""},
+		{"conversion of non-integer", "x = Int8(1.5)", "x", "", "cannot convert Float to Int8"},
+		{"conversion to non-integer", "x = String(1)", "x", "", "cannot convert Int to String"},
+		{"conversion arguments", "x = Int8(1, 2)", "x", "", "conversion to Int8 requires a single integer argument"},
+		{"checked overflow", "x = 9223372036854775807 ?+ 1", "x", "Int", ""},
+		{"runtime value", "n = mut 1\nx = n + 1", "x", "Int", ""},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+func TestValueOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"literal", "x = 42", "42"},
+		{"arithmetic", "x = 2 ^ 10 - 1", "1023"},
+		{"string", `x = "a" + "b"`, `"ab"`},
+		{"tuple", "x = (a: 1, b: 2 + 3)", "(a: 1, b: 5)"},
+		{"pure function", "sum = fn(a: Int, b: Int) Int { a + b }\nx = sum(1, 2)", "3"},
+		{"checked overflow", "x = 9223372036854775807 ?+ 1", `error("integer overflow")`},
+		{"conversion", "x = Int16(300)", "300"},
+		{"multi-line string", "x = ```\n    a\n    b\n```", `"a\nb\n"`},
+		{"multi-line string with processor", "shout = fn(s: String) String { s + \"!\" }\nx = ```shout\n    hey\n```", `"hey\n!"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := checkSource(t, tt.input)
+			if err != nil {
+				t.Fatalf("Module(%q) = %v", tt.input, err)
+			}
+			obj := info.Scope.Lookup("x")
+			decl, ok := obj.Decl.(*ast.Assignment)
+			if !ok {
+				t.Fatalf("Module(%q): x declared by %T", tt.input, obj.Decl)
+			}
+			got := info.ValueOf(decl.Right)
+			if got == nil {
+				t.Fatalf("Module(%q): ValueOf(%s) = nil", tt.input, decl.Right)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Module(%q): ValueOf(%s) = %s, want %s", tt.input, decl.Right, got, tt.want)
+			}
+		})
+	}
+}
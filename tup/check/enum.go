@@ -0,0 +1,96 @@
+package check
+
+import (
+	"math"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// enumType returns the type declared by an enum declaration. Members
+// without a value take the value of the previous member plus one, starting
+// from 0.
+func (c *Checker) enumType(decl *ast.EnumDeclaration) types.Type {
+	enum := types.NewEnum()
+	if decl.Members == nil {
+		return enum
+	}
+	names := map[string]bool{}
+	values := map[int64]string{}
+	var next int64
+	overflow := false
+	for _, m := range decl.Members.Members {
+		value := next
+		if m.Value != nil {
+			value, overflow = m.Value.IntegerValue, false
+		} else if overflow {
+			c.errorf(m, "value of enum member %s overflows Int", m.Name.Name)
+			continue
+		}
+		if names[m.Name.Name] {
+			c.errorf(m.Name, "duplicate enum member %s", m.Name.Name)
+			continue
+		}
+		names[m.Name.Name] = true
+		if other, ok := values[value]; ok {
+			c.errorf(m, "duplicate value %d for enum member %s: already used by %s", value, m.Name.Name, other)
+		} else {
+			values[value] = m.Name.Name
+		}
+		enum.Members = append(enum.Members, &types.EnumMember{Name: m.Name.Name, Value: value})
+		next, overflow = value+1, value == math.MaxInt64
+	}
+	return enum
+}
+
+// enumMember returns the type of a member of an enum type, such as
+// Fruit.apple.
+func (c *Checker) enumMember(e *ast.MemberAccess, ident *ast.TypeIdentifier) types.Type {
+	typ := c.typeName(ident, ident.Name)
+	enum, ok := typ.Underlying().(*types.Enum)
+	if !ok {
+		// members of other types, such as the types of imported
+		// modules, are not yet resolved
+		return types.Typ[types.Invalid]
+	}
+	member, ok := e.Member.(*ast.Identifier)
+	if !ok {
+		c.errorf(e.Member, "invalid member %s of enum %s", e.Member, typ)
+		return types.Typ[types.Invalid]
+	}
+	if enum.Member(member.Name) == nil {
+		c.errorf(member, "%s has no member %s", typ, member.Name)
+		return types.Typ[types.Invalid]
+	}
+	return typ
+}
+
+// enumIterable returns the type of the values produced by iterating over
+// the type ref, which must be an enum type whose members are produced in
+// declaration order.
+func (c *Checker) enumIterable(ref *ast.TypeReference) types.Type {
+	typ := c.typExpr(ref)
+	if types.IsInvalid(typ) {
+		return typ
+	}
+	if !types.IsEnum(typ) {
+		c.errorf(ref, "cannot iterate over type %s", typ)
+		return types.Typ[types.Invalid]
+	}
+	return typ
+}
+
+// enumConversion checks the conversion of an Int to an enum type, such as
+// Fruit(1), which is nil if no member has the value.
+func (c *Checker) enumConversion(e *ast.TypeConstructorCall, typ types.Type) types.Type {
+	args := e.Arguments
+	if args == nil || args.Args == nil || len(args.Args.Args) != 1 || args.LabeledArgs != nil {
+		c.errorf(e, "conversion to %s requires a single Int argument", typ)
+		return types.Typ[types.Invalid]
+	}
+	arg := args.Args.Args[0].Expr
+	if argType := c.info.Types[arg]; !types.IsInvalid(argType) && !types.IsInteger(argType) {
+		c.errorf(arg, "cannot convert %s to %s", argType, typ)
+	}
+	return types.NewUnion(typ, types.Typ[types.Nil])
+}
@@ -0,0 +1,112 @@
+package check
+
+import (
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+const carEnum = "Car = enum(\n\taudi = 1000\n\tbmw\n\tchevrolet\n)\n"
+
+func TestEnum(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"member", carEnum + "x = Car.audi", "x", "Car", ""},
+		{"ordering", carEnum + "x = Car.audi < Car.bmw", "x", "Bool", ""},
+		{"difference", carEnum + "x = Car.chevrolet - Car.audi", "x", "Int", ""},
+		{"int", carEnum + "x = Car.bmw.int()", "x", "Int", ""},
+		{"string", carEnum + "x = Car.bmw.string()", "x", "String", ""},
+		{"int function", carEnum + "c = Car.bmw\nx = int(c)", "x", "Int", ""},
+		{"conversion", carEnum + "x = Car(1001)", "x", "Car | Nil", ""},
+		{"iteration", carEnum + "x = for c in Car { c.int() }", "x", "Nil", ""},
+		{"unknown member", carEnum + "x = Car.ford", "x", "", "Car has no member ford"},
+		{"sum", carEnum + "x = Car.audi + Car.bmw", "x", "", "operator + not defined on Car"},
+		{"different enums", carEnum + "Fruit = enum(\n\tapple\n)\nx = Car.audi < Fruit.apple", "x", "", "mismatched types Car and Fruit in <"},
+		{"conversion from String", carEnum + `x = Car("audi")`, "x", "", "cannot convert String to Car"},
+		{"iterating over a tuple type", "P = type(x: Int)\nx = for p in P { p }", "x", "", "cannot iterate over type P"},
+		{"duplicate name", "E = enum(\n\ta\n\tb\n\ta\n)", "E", "", "duplicate enum member a"},
+		{"duplicate value", "E = enum(\n\ta = 1\n\tb = 0\n\tc\n)", "E", "", "duplicate value 1 for enum member c: already used by a"},
+		{"overflow", "E = enum(\n\ta = 9223372036854775807\n\tb\n)", "E", "", "value of enum member b overflows Int"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+func TestEnumValues(t *testing.T) {
+	input := "CarMaker = enum(\n\tford = 1000\n\tchevrolet\n\tbmw = 2000\n\tvolkswagen\n\ttoyota = 3000\n)\nFruit = enum(\n\tapple\n\tbanana\n)"
+	info, err := checkSource(t, input)
+	if err != nil {
+		t.Fatalf("Module(%q) = %v", input, err)
+	}
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{"CarMaker", "enum(ford = 1000, chevrolet = 1001, bmw = 2000, volkswagen = 2001, toyota = 3000)"},
+		{"Fruit", "enum(apple = 0, banana = 1)"},
+	}
+	for _, tt := range tests {
+		if got := info.Scope.Lookup(tt.typ).Type.Underlying().String(); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestEnumConst(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"member", "x = Car.bmw", "Car.bmw"},
+		{"ordering", "x = Car.audi < Car.bmw", "true"},
+		{"equality", "x = Car.audi == Car.bmw", "false"},
+		{"difference", "x = Car.chevrolet - Car.audi", "2"},
+		{"int", "x = Car.bmw.int()", "1001"},
+		{"string", "x = Car.chevrolet.string()", `"chevrolet"`},
+		{"conversion", "x = Car(1002)", "Car.chevrolet"},
+		{"conversion without member", "x = Car(5000)", "nil"},
+		{"shadowed", "string = fn(c: Car) String { \"car\" }\nx = Car.bmw.string()", `"car"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := carEnum + tt.input
+			info, err := checkSource(t, input)
+			if err != nil {
+				t.Fatalf("Module(%q) = %v", input, err)
+			}
+			decl := info.Scope.Lookup("x").Decl.(*ast.Assignment)
+			got := info.ValueOf(decl.Right)
+			if got == nil {
+				t.Fatalf("Module(%q): ValueOf(%s) = nil", input, decl.Right)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Module(%q): ValueOf(%s) = %s, want %s", input, decl.Right, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnumBuiltins(t *testing.T) {
+	input := carEnum + "x = Car.bmw.int()\ny = string(Car.bmw)\nint = fn(c: Car) Int { 0 }\nz = int(Car.audi)"
+	info, err := checkSource(t, input)
+	if err != nil {
+		t.Fatalf("Module(%q) = %v", input, err)
+	}
+	for name, want := range map[string]string{"x": "", "y": "string", "z": ""} {
+		call := info.Scope.Lookup(name).Decl.(*ast.Assignment).Right.(*ast.FunctionCall)
+		if got := info.Builtins[call]; got != want {
+			t.Errorf("Builtins[%s] = %q, want %q", call, got, want)
+		}
+	}
+	if d := info.Descriptors.Add(info.Scope.Lookup("Car").Type.Underlying()); d.Kind != types.EnumDescriptor || len(d.Values) != 3 || d.Values[2] != 1002 {
+		t.Errorf("descriptor of Car = %+v", d)
+	}
+}
@@ -0,0 +1,81 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+)
+
+// Error describes a semantic error found while checking a module, or a
+// warning.
+type Error struct {
+	Pos ast.Position
+	Msg string
+	// Warning is set for a problem that does not prevent the module from
+	// being used.
+	Warning bool
+	// Notes point at related positions, such as the declaration of a
+	// variable that is wrongly assigned.
+	Notes []*Note
+}
+
+// Note is additional information attached to an Error.
+type Note struct {
+	Pos ast.Position
+	Msg string
+}
+
+func (err *Error) Error() string {
+	var builder strings.Builder
+	severity := "error"
+	if err.Warning {
+		severity = "warning"
+	}
+	fmt.Fprintf(&builder, "%s: %s\n--> %s", severity, err.Msg, err.Pos)
+	for _, note := range err.Notes {
+		fmt.Fprintf(&builder, "\nnote: %s\n--> %s", note.Msg, note.Pos)
+	}
+	return builder.String()
+}
+
+// note attaches a note at the position of node to err.
+func (err *Error) note(node ast.Node, format string, args ...any) *Error {
+	err.Notes = append(err.Notes, &Note{Pos: ast.PosOf(node), Msg: fmt.Sprintf(format, args...)})
+	return err
+}
+
+// Errors is a list of semantic errors, in the order they were found.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	var builder strings.Builder
+	for i, err := range errs {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		builder.WriteString(err.Error())
+	}
+	return builder.String()
+}
+
+// errorf records an error at the position of node.
+func (c *Checker) errorf(node ast.Node, format string, args ...any) *Error {
+	err := &Error{
+		Pos: ast.PosOf(node),
+		Msg: fmt.Sprintf(format, args...),
+	}
+	c.errors = append(c.errors, err)
+	return err
+}
+
+// warnf records a warning at the position of node.
+func (c *Checker) warnf(node ast.Node, format string, args ...any) *Error {
+	warning := &Error{
+		Pos:     ast.PosOf(node),
+		Msg:     fmt.Sprintf(format, args...),
+		Warning: true,
+	}
+	c.info.Warnings = append(c.info.Warnings, warning)
+	return warning
+}
@@ -0,0 +1,749 @@
+package check
+
+import (
+	"fmt"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+func (c *Checker) record(node ast.Node, typ types.Type) {
+	if typ != nil {
+		c.info.Types[node] = typ
+	}
+}
+
+// lookup returns the object named name, reporting an error at node if
+// there is none.
+func (c *Checker) lookup(node ast.Node, name string) *Object {
+	obj := c.scope.Lookup(name)
+	if obj == nil {
+		c.errorf(node, "undefined: %s", name)
+		return nil
+	}
+	c.resolve(obj)
+	return obj
+}
+
+// expr checks an expression and returns its type. Expressions whose type
+// cannot be determined, or that never produce a value, have the invalid
+// type; errors about them have already been reported.
+func (c *Checker) expr(expr ast.Expression) types.Type {
+	typ := c.exprInternal(expr)
+	if typ == nil {
+		typ = types.Typ[types.Invalid]
+	}
+	c.record(expr, typ)
+	return typ
+}
+
+func (c *Checker) exprInternal(expr ast.Expression) types.Type {
+	invalid := types.Typ[types.Invalid]
+
+	switch e := expr.(type) {
+	case nil:
+		return invalid
+
+	// literals
+	case *ast.IntegerLiteral:
+		return types.Typ[types.UntypedInt]
+	case *ast.FloatLiteral:
+		return types.Typ[types.UntypedFloat]
+	case *ast.BooleanLiteral:
+		return types.Typ[types.Bool]
+	case *ast.StringLiteral, *ast.RawStringLiteral:
+		return types.Typ[types.String]
+	case *ast.InterpolatedStringLiteral:
+		c.interpolatedString(e, e)
+		return types.Typ[types.String]
+	case *ast.MultiLineStringLiteral:
+		return c.multiLineString(e)
+	case *ast.SymbolLiteral:
+		return types.Typ[types.Symbol]
+	case *ast.RuneLiteral:
+		return types.Rune
+	case *ast.TupleLiteral:
+		return c.tupleLiteral(e)
+	case *ast.ArrayLiteral:
+		return c.arrayLiteral(e)
+
+	// names
+	case *ast.Identifier:
+		return c.ident(e, e.Name)
+	case *ast.FunctionIdentifier:
+		return c.ident(e, e.Name)
+	case *ast.ItExpression:
+		if c.scope.Lookup("it") == nil {
+			c.errorf(e, "it is not defined: it names the parameter of a block that takes a single parameter and declares none")
+			return invalid
+		}
+		return c.ident(e, "it")
+
+	// operators
+	case *ast.AddSubExpression:
+		return c.binary(e, e.Operator.String(), c.expr(e.Left), c.expr(e.Right))
+	case *ast.MulDivExpression:
+		return c.binary(e, e.Operator.String(), c.expr(e.Left), c.expr(e.Right))
+	case *ast.PowExpression:
+		var typ types.Type
+		for _, operand := range e.Operands {
+			if typ == nil {
+				typ = c.expr(operand)
+			} else {
+				typ = c.binary(e, "^", typ, c.expr(operand))
+			}
+		}
+		return typ
+	case *ast.RelationalComparison:
+		return c.comparison(e)
+	case *ast.TypeComparison:
+		c.expr(e.Left)
+		return types.Typ[types.Bool]
+	case *ast.LogicalOrExpression:
+		return c.logical(e, "||", e.Operands)
+	case *ast.LogicalAndExpression:
+		return c.logical(e, "&&", e.Operands)
+	case *ast.UnaryExpression:
+		return c.unary(e)
+
+	// control flow
+	case *ast.Block:
+		return c.block(e)
+	case *ast.IfExpression:
+		return c.ifExpr(e)
+	case *ast.SwitchExpression:
+		return c.switchExpr(e)
+	case *ast.ForExpression:
+		return c.forExpr(e)
+	case *ast.InlineForExpression:
+		return c.inlineFor(e)
+	case *ast.ReturnExpression:
+		if e.Expression != nil {
+			c.returns(c.expr(e.Expression))
+		}
+		return invalid
+	case *ast.BreakExpression:
+		c.breakExpr(e)
+		return invalid
+	case *ast.ContinueExpression:
+		c.continueExpr(e)
+		return invalid
+	case *ast.TryExpression:
+		return c.tryExpr(e)
+
+	// calls and access
+	case *ast.FunctionCall:
+		return c.call(e)
+	case *ast.TypeConstructorCall:
+		typ := c.typExpr(e.TypeReference)
+		c.arguments(e.Arguments)
+		c.functionBlock(e.FunctionBlock, invalid)
+		if types.IsEnum(typ) {
+			return c.enumConversion(e, typ)
+		}
+		if _, ok := typ.Underlying().(*types.Basic); ok && !types.IsInvalid(typ) {
+			return c.conversion(e, typ)
+		}
+		if named, ok := typ.(*types.Named); ok && named.TypeParams() != nil {
+			n := len(c.errors)
+			typ = c.construct(e, named)
+			if len(c.errors) > n {
+				return typ
+			}
+		}
+		c.fieldArgs(e, typ)
+		return typ
+	case *ast.MemberAccess:
+		return c.memberAccess(e)
+	case *ast.IndexedAccess:
+		return c.index(e, c.expr(e.Object), e.Index)
+	case *ast.SafeIndexedAccess:
+		object := c.expr(e.Object)
+		typ := c.index(e, object, e.Index)
+		c.safeIndex(e, object)
+		return typ
+	case *ast.TupleUpdateExpression:
+		return c.tupleUpdate(e)
+	case *ast.Range:
+		return c.rangeExpr(e)
+
+	case *ast.TypeofExpression:
+		return c.typeof(e)
+
+	case *ast.ChainedExpression:
+		return c.pipeline(e, nil)
+
+	// not yet supported
+	case *ast.ImportExpression, *ast.ArrayFunctionCall:
+		return invalid
+
+	// meta expressions are expanded before checking, so any left
+	// could not be evaluated and have been reported
+	case *ast.MetaExpression:
+		return invalid
+	}
+	return invalid
+}
+
+func (c *Checker) ident(node ast.Node, name string) types.Type {
+	obj := c.lookup(node, name)
+	if obj == nil {
+		return types.Typ[types.Invalid]
+	}
+	if obj.Kind == TypeObject {
+		c.errorf(node, "%s is a type, not a value", name)
+		return types.Typ[types.Invalid]
+	}
+	c.fallible(obj)
+	return obj.Type
+}
+
+// tupleLiteral returns the type of a tuple literal. Spread members
+// contribute all fields of the spread tuple, in order.
+func (c *Checker) tupleLiteral(lit *ast.TupleLiteral) types.Type {
+	fields := []*types.Field{}
+	seen := map[string]bool{}
+	add := func(node ast.Node, field *types.Field) {
+		if field.Name != "" {
+			if seen[field.Name] {
+				c.errorf(node, "duplicate field %s in tuple literal", field.Name)
+			}
+			seen[field.Name] = true
+		}
+		fields = append(fields, field)
+	}
+	for _, member := range lit.Members {
+		typ := c.expr(member.Value)
+		if member.Spread {
+			tuple, ok := typ.Underlying().(*types.Tuple)
+			if !ok {
+				if !types.IsInvalid(typ) {
+					c.errorf(member.Value, "cannot spread %s: not a tuple", typ)
+				}
+				continue
+			}
+			for _, field := range tuple.Fields {
+				add(member.Value, field)
+			}
+			continue
+		}
+		name := ""
+		if member.Label != nil {
+			name = member.Label.Name
+		}
+		add(member, types.NewField(name, types.Default(typ)))
+	}
+	return types.NewTuple(fields...)
+}
+
+func (c *Checker) arrayLiteral(lit *ast.ArrayLiteral) types.Type {
+	var array *types.Array
+	var typ types.Type
+	switch arrayType := lit.ArrayType.(type) {
+	case *ast.FixedSizeArrayType:
+		typ = c.typExpr(arrayType)
+	case *ast.TypeReference:
+		typ = c.typExpr(arrayType)
+		if _, ok := typ.Underlying().(*types.Array); !ok && !types.IsInvalid(typ) {
+			// Int[1, 2, 3] is an array of Int
+			typ = types.NewArray(typ)
+		}
+	}
+	if typ != nil {
+		array, _ = typ.Underlying().(*types.Array)
+	}
+
+	var elem types.Type
+	if array != nil {
+		elem = array.Elem
+	}
+	for _, element := range lit.Elements {
+		t := c.expr(element)
+		switch {
+		case elem == nil:
+			elem = t
+		case !c.assignable(t, elem):
+			if array != nil {
+				c.errorf(element, "cannot use %s as %s in array literal", t, elem)
+			} else {
+				elem = types.NewUnion(elem, types.Default(t))
+			}
+		}
+	}
+	if lit.Initializer != nil {
+		var elem types.Type = types.Typ[types.Invalid]
+		if array != nil {
+			elem = array.Elem
+		}
+		// each element is initialized from its index
+		c.closure(lit.Initializer, types.NewFunction([]*types.Field{types.NewField("", types.Int)}, elem, false))
+	}
+	if array != nil {
+		if array.Fixed() && lit.Initializer == nil && len(lit.Elements) > 0 && int64(len(lit.Elements)) != array.Len {
+			c.errorf(lit, "array literal has %d elements, want %d", len(lit.Elements), array.Len)
+		}
+		return typ
+	}
+	if elem == nil {
+		c.errorf(lit, "cannot infer element type of []")
+		return types.Typ[types.Invalid]
+	}
+	return types.NewArray(types.Default(elem))
+}
+
+func (c *Checker) binary(node ast.Node, op string, x, y types.Type) types.Type {
+	invalid := types.Typ[types.Invalid]
+	if types.IsInvalid(x) || types.IsInvalid(y) {
+		return invalid
+	}
+	if types.IsTypeParam(x) {
+		// operations on type parameters are checked when instantiated
+		return x
+	}
+	if types.IsTypeParam(y) {
+		return y
+	}
+	if array, ok := x.Underlying().(*types.Array); ok && op == "<<" {
+		// appending to an array
+		if !c.assignable(y, array.Elem) {
+			c.errorf(node, "cannot append %s to %s", y, x)
+		}
+		return x
+	}
+	typ, ok := unify(x, y)
+	if !ok {
+		c.errorf(node, "mismatched types %s and %s in %s", x, y, op)
+		return invalid
+	}
+	if op == "-" && types.IsEnum(typ) {
+		// the distance between members of an enum
+		return types.Int
+	}
+	switch op {
+	case "+":
+		ok = types.IsNumeric(typ) || types.IsString(typ)
+	case "-", "?-", "?+", "*", "?*", "/", "?/", "%", "?%", "^":
+		ok = types.IsNumeric(typ)
+	case "&", "|", "<<", ">>":
+		ok = types.IsInteger(typ)
+	}
+	if !ok {
+		c.errorf(node, "operator %s not defined on %s", op, typ)
+		return invalid
+	}
+	return typ
+}
+
+// unify returns the type that values of types x and y are converted to
+// when they are combined by a binary operator.
+func unify(x, y types.Type) (types.Type, bool) {
+	if types.Identical(x, y) {
+		if types.IsUntyped(x) {
+			return y, true
+		}
+		return x, true
+	}
+	switch {
+	case types.IsUntyped(x) && types.IsUntyped(y):
+		// untyped int and untyped float
+		return types.Typ[types.UntypedFloat], true
+	case types.IsUntyped(x):
+		return y, untypedFits(x, y)
+	case types.IsUntyped(y):
+		return x, untypedFits(y, x)
+	}
+	return nil, false
+}
+
+// untypedFits reports whether an untyped literal of type x can be given
+// type t.
+func untypedFits(x, t types.Type) bool {
+	if types.IsInteger(x) {
+		return types.IsNumeric(t)
+	}
+	return types.IsFloat(t)
+}
+
+// assignable reports whether a value of type from can be used where a
+// value of type to is expected.
+func (c *Checker) assignable(from, to types.Type) bool {
+	if types.IsInvalid(from) || types.IsInvalid(to) || types.Identical(from, to) {
+		return true
+	}
+	if to == types.ErrorType && isErrorType(from) {
+		// every error type satisfies the predeclared error type
+		return true
+	}
+	if _, ok := to.(*types.TypeParam); ok {
+		return true
+	}
+	if types.IsUntyped(from) && untypedFits(from, to) {
+		return true
+	}
+	if union, ok := to.Underlying().(*types.Union); ok {
+		if from, ok := from.Underlying().(*types.Union); ok {
+			for _, member := range from.Members {
+				if !c.assignable(member, to) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, member := range union.Members {
+			if c.assignable(from, member) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Checker) comparison(e *ast.RelationalComparison) types.Type {
+	x, y := c.expr(e.Left), c.expr(e.Right)
+	result := types.Typ[types.Bool]
+	if e.Operator == ast.OpCompare {
+		result = types.Int
+	}
+	if e.Operator == ast.OpMatch || types.IsInvalid(x) || types.IsInvalid(y) || types.IsTypeParam(x) || types.IsTypeParam(y) {
+		return result
+	}
+	switch e.Operator {
+	case ast.OpEq, ast.OpNeq:
+		if !c.assignable(x, y) && !c.assignable(y, x) {
+			c.errorf(e, "mismatched types %s and %s in %s", x, y, e.Operator)
+		}
+	default:
+		typ, ok := unify(x, y)
+		if !ok {
+			c.errorf(e, "mismatched types %s and %s in %s", x, y, e.Operator)
+		} else if !types.IsNumeric(typ) && !types.IsString(typ) && !types.IsInteger(typ.Underlying()) && !types.IsEnum(typ) {
+			c.errorf(e, "operator %s not defined on %s", e.Operator, typ)
+		}
+	}
+	c.typeofComparison(e)
+	return result
+}
+
+func (c *Checker) logical(node ast.Node, op string, operands []ast.Expression) types.Type {
+	for _, operand := range operands {
+		if typ := c.expr(operand); !types.IsInvalid(typ) && !types.IsBool(typ) {
+			c.errorf(operand, "operator %s not defined on %s", op, typ)
+		}
+	}
+	return types.Typ[types.Bool]
+}
+
+func (c *Checker) unary(e *ast.UnaryExpression) types.Type {
+	typ := c.expr(e.Expression)
+	if types.IsInvalid(typ) {
+		return typ
+	}
+	var ok bool
+	switch e.Operator {
+	case ast.OpPosSign, ast.OpNegSign:
+		ok = types.IsNumeric(typ)
+	case ast.OpLogicalNot:
+		ok = types.IsBool(typ)
+	case ast.OpBitNot:
+		ok = types.IsInteger(typ)
+	}
+	if !ok {
+		c.errorf(e, "operator %s not defined on %s", ast.UnaryOpStrings[e.Operator], typ)
+		return types.Typ[types.Invalid]
+	}
+	return typ
+}
+
+func (c *Checker) ifExpr(e *ast.IfExpression) types.Type {
+	for _, cond := range e.Conditions {
+		cond, ok := cond.(ast.Expression)
+		if !ok {
+			continue
+		}
+		if typ := c.expr(cond); !types.IsInvalid(typ) && !types.IsBool(typ) {
+			c.errorf(cond, "non-Bool %s used as condition", typ)
+		}
+	}
+	var typs []types.Type
+	for i, block := range e.Blocks {
+		saved := len(c.facts)
+		if i < len(e.Conditions) {
+			c.assume(e.Conditions[i])
+		}
+		typs = append(typs, types.Default(c.block(block)))
+		c.facts = c.facts[:saved]
+	}
+	if !e.HasElse {
+		typs = append(typs, types.Typ[types.Nil])
+	}
+	return types.NewUnion(typs...)
+}
+
+// functionBlock checks a block whose parameters, or it if it declares
+// none, receive a value of type arg.
+func (c *Checker) functionBlock(block *ast.FunctionBlock, arg types.Type) types.Type {
+	if block == nil {
+		return types.Typ[types.Nil]
+	}
+	c.openScope()
+	defer c.closeScope()
+	if block.Parameters != nil && block.Parameters.Parameters != nil {
+		c.bindLHS(block.Parameters.Parameters, arg, false, func(ident *ast.Identifier, typ types.Type) {
+			c.scope.Insert(&Object{Kind: VarObject, Name: ident.Name, Type: typ, Decl: block, state: resolved})
+		})
+	} else {
+		c.scope.Insert(&Object{Kind: VarObject, Name: "it", Type: types.Default(arg), Decl: block, state: resolved})
+	}
+	typ := c.blockBody(block.Body)
+	c.record(block, typ)
+	return typ
+}
+
+func (c *Checker) tryExpr(e *ast.TryExpression) types.Type {
+	expr, ok := e.Expression.(ast.Expression)
+	if !ok {
+		return types.Typ[types.Invalid]
+	}
+	if chain, ok := expr.(*ast.ChainedExpression); ok {
+		// try is distributed through the stages of a pipeline
+		typ := c.pipeline(chain, e)
+		c.record(chain, typ)
+		return typ
+	}
+	typ := c.expr(expr)
+	if e.Variant == ast.TryStandard {
+		// try returns the error from the enclosing function
+		c.returns(types.NewUnion(errorMembers(typ)...))
+	}
+	union, ok := typ.Underlying().(*types.Union)
+	if !ok {
+		return typ
+	}
+	var rest []types.Type
+	for _, member := range union.Members {
+		if !isErrorType(member) {
+			rest = append(rest, member)
+		}
+	}
+	return types.NewUnion(rest...)
+}
+
+// memberObject returns the type of the object of a member access.
+func (c *Checker) memberObject(e *ast.MemberAccess) types.Type {
+	if object, ok := e.Object.(ast.Expression); ok {
+		return c.expr(object)
+	}
+	return types.Typ[types.Invalid]
+}
+
+func (c *Checker) memberAccess(e *ast.MemberAccess) types.Type {
+	if ident, ok := e.Object.(*ast.TypeIdentifier); ok {
+		return c.enumMember(e, ident)
+	}
+	object := c.memberObject(e)
+	tuple, ok := object.Underlying().(*types.Tuple)
+	if !ok {
+		return types.Typ[types.Invalid]
+	}
+	switch member := e.Member.(type) {
+	case *ast.Identifier:
+		if i := tuple.FieldIndex(member.Name); i >= 0 {
+			return tuple.Fields[i].Type
+		}
+		c.errorf(member, "%s has no field %s", object, member.Name)
+	case *ast.IntegerLiteral:
+		if i := member.IntegerValue; i >= 0 && i < int64(len(tuple.Fields)) {
+			return tuple.Fields[i].Type
+		}
+		c.errorf(member, "index %d out of range for %s", member.IntegerValue, object)
+	}
+	return types.Typ[types.Invalid]
+}
+
+func (c *Checker) index(e ast.Node, object types.Type, index ast.Expression) types.Type {
+	indexType := c.expr(index)
+	if types.IsInvalid(object) {
+		return object
+	}
+	if _, ok := index.(*ast.Range); ok {
+		return c.slice(index, object, indexType)
+	}
+	if !types.IsInvalid(indexType) && !types.IsInteger(indexType) {
+		c.errorf(index, "invalid index type %s", indexType)
+	}
+	switch t := object.Underlying().(type) {
+	case *types.Array:
+		return t.Elem
+	case *types.Tuple:
+		if lit, ok := index.(*ast.IntegerLiteral); ok && lit.IntegerValue >= 0 && lit.IntegerValue < int64(len(t.Fields)) {
+			return t.Fields[lit.IntegerValue].Type
+		}
+	}
+	if types.IsString(object) {
+		return types.Byte
+	}
+	return types.Typ[types.Invalid]
+}
+
+// slice returns the type of a slice of object by the range index of type
+// rangeType: a dynamic array of the elements of an array, or a string.
+func (c *Checker) slice(index ast.Expression, object, rangeType types.Type) types.Type {
+	if bounds, ok := rangeType.Underlying().(*types.Tuple); ok && !types.IsInteger(bounds.Fields[0].Type) {
+		c.errorf(index, "invalid slice index type %s", bounds.Fields[0].Type)
+		return types.Typ[types.Invalid]
+	}
+	if array, ok := object.Underlying().(*types.Array); ok {
+		if array.Fixed() {
+			return types.NewArray(array.Elem)
+		}
+		return object
+	}
+	if !types.IsString(object) {
+		c.errorf(index, "cannot slice %s", object)
+		return types.Typ[types.Invalid]
+	}
+	return object
+}
+
+func (c *Checker) tupleUpdate(e *ast.TupleUpdateExpression) types.Type {
+	object := c.expr(e.Object)
+	tuple, ok := object.Underlying().(*types.Tuple)
+	if !ok {
+		if !types.IsInvalid(object) {
+			c.errorf(e.Object, "cannot update %s: not a tuple", object)
+		}
+		return types.Typ[types.Invalid]
+	}
+	for _, member := range e.Update.Members {
+		typ := c.expr(member.Value)
+		if member.Label == nil {
+			continue
+		}
+		i := tuple.FieldIndex(member.Label.Name)
+		if i < 0 {
+			c.errorf(member.Label, "%s has no field %s", object, member.Label.Name)
+			continue
+		}
+		if !c.assignable(typ, tuple.Fields[i].Type) {
+			c.errorf(member.Value, "cannot use %s as %s in update of field %s", typ, tuple.Fields[i].Type, member.Label.Name)
+		}
+	}
+	return object
+}
+
+// fieldArgs checks the arguments of a call of a tuple type, such as
+// P(1, "b"), against its fields: each field is given once, by position or
+// by label, with a value of its type.
+func (c *Checker) fieldArgs(e *ast.TypeConstructorCall, typ types.Type) {
+	tuple, ok := typ.Underlying().(*types.Tuple)
+	if !ok {
+		return
+	}
+	set := make([]bool, len(tuple.Fields))
+	field := func(expr ast.Expression, i int) {
+		set[i] = true
+		want := tuple.Fields[i].Type
+		if got := c.info.Types[expr]; !types.IsInvalid(got) && !types.IsInvalid(want) && !c.assignable(got, want) {
+			c.errorf(expr, "cannot use %s as %s in construction of %s", types.Default(got), want, typ)
+		}
+	}
+	args := e.Arguments
+	if args == nil {
+		args = &ast.FunctionArguments{}
+	}
+	if args.Args != nil {
+		for i, arg := range args.Args.Args {
+			switch {
+			case arg.Spread:
+				c.errorf(arg.Expr, "cannot spread an argument in construction of %s", typ)
+			case i >= len(tuple.Fields):
+				c.errorf(arg.Expr, "too many arguments in construction of %s", typ)
+				return
+			default:
+				field(arg.Expr, i)
+			}
+		}
+	}
+	if args.LabeledArgs != nil {
+		for _, arg := range args.LabeledArgs.Args {
+			name := arg.Identifier.Name
+			i := tuple.FieldIndex(name)
+			switch {
+			case i < 0:
+				c.errorf(arg.Identifier, "%s has no field %s", typ, name)
+			case set[i]:
+				c.errorf(arg.Identifier, "field %s of %s is given more than once", name, typ)
+			default:
+				field(arg.Argument.Expr, i)
+			}
+		}
+	}
+	for i, f := range tuple.Fields {
+		if !set[i] {
+			c.errorf(e, "missing argument for %s in construction of %s", fieldName(f, i), typ)
+			return
+		}
+	}
+}
+
+// fieldName describes the i'th field f of a tuple.
+func fieldName(f *types.Field, i int) string {
+	if f.Name != "" {
+		return "field " + f.Name
+	}
+	return fmt.Sprintf("field %d", i)
+}
+
+// conversion checks the conversion of an integer to an integer type, such
+// as Int8(n). A constant must be representable in the type; other values
+// are checked when the program runs.
+func (c *Checker) conversion(e *ast.TypeConstructorCall, typ types.Type) types.Type {
+	invalid := types.Typ[types.Invalid]
+	args := e.Arguments
+	if args == nil || args.Args == nil || len(args.Args.Args) != 1 || args.Args.Args[0].Spread || args.LabeledArgs != nil {
+		c.errorf(e, "conversion to %s requires a single integer argument", typ)
+		return invalid
+	}
+	arg := args.Args.Args[0].Expr
+	argType := c.info.Types[arg]
+	if types.IsInvalid(argType) {
+		return typ
+	}
+	if !types.IsInteger(argType) || !types.IsInteger(typ) {
+		c.errorf(arg, "cannot convert %s to %s", types.Default(argType), typ)
+		return typ
+	}
+	if v, err := c.eval.Eval(arg); err == nil {
+		if _, err := consteval.Convert(v, typ); err != nil {
+			c.errorf(arg, "%s", err)
+			return invalid
+		}
+	}
+	return typ
+}
+
+func (c *Checker) rangeExpr(e *ast.Range) types.Type {
+	var elem types.Type
+	for _, bound := range []*ast.RangeBound{e.StartBound, e.EndBound} {
+		if bound == nil {
+			continue
+		}
+		typ := c.expr(bound.Value)
+		if types.IsInvalid(typ) {
+			return typ
+		}
+		if elem == nil {
+			elem = typ
+		} else if unified, ok := unify(elem, typ); ok {
+			elem = unified
+		} else {
+			c.errorf(e, "mismatched types %s and %s in range", elem, typ)
+			return types.Typ[types.Invalid]
+		}
+	}
+	if elem == nil {
+		elem = types.Int
+	}
+	return c.rangeType(elem)
+}
@@ -0,0 +1,111 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// isErrorType reports whether typ was declared as an error type. The
+// predeclared error type is one, and every error type satisfies it.
+func isErrorType(typ types.Type) bool {
+	named, ok := typ.(*types.Named)
+	return ok && named.HasAnnotation("error")
+}
+
+// errorMembers returns the error types among the members of typ, or typ
+// itself if it is an error type.
+func errorMembers(typ types.Type) []types.Type {
+	var errs []types.Type
+	for _, member := range members(typ) {
+		if isErrorType(member) {
+			errs = append(errs, member)
+		}
+	}
+	return errs
+}
+
+// isFallible reports whether typ declares its result as !T, whose error
+// set is inferred from the body of the function.
+func isFallible(typ *ast.FunctionDeclarationType) bool {
+	if typ == nil {
+		return false
+	}
+	returnType, ok := typ.ReturnType.(*ast.ReturnType)
+	if !ok || returnType == nil {
+		return false
+	}
+	union, ok := returnType.Type.(*ast.UnionWithError)
+	return ok && union.IsExclamation
+}
+
+// returns notes that the function whose body is being checked may return
+// a value of type typ, through its final expression, a return expression
+// or a try expression, adding the error types among its members to the
+// error set being inferred.
+func (c *Checker) returns(typ types.Type) {
+	if c.fn == nil || !c.fn.infer || types.IsInvalid(typ) {
+		return
+	}
+	c.fn.errs = append(c.fn.errs, errorMembers(typ)...)
+}
+
+// inferErrors replaces the error member of the result !T of a checked
+// function body with the error set inferred from its return paths. A
+// function that returns no errors has the result T.
+func (c *Checker) inferErrors(body *funcBody) {
+	if body.decl.Body == nil {
+		// without a body, any error may be returned
+		return
+	}
+	var result []types.Type
+	for _, member := range members(body.sig.Result) {
+		if member != types.ErrorType {
+			result = append(result, member)
+		}
+	}
+	body.sig.Result = types.NewUnion(append(result, body.errs...)...)
+}
+
+// fallible checks the body of the function obj first if its error set is
+// inferred and has not yet been, so that its result is known. While the
+// body is being checked, as by a recursive call, the result is !T.
+func (c *Checker) fallible(obj *Object) {
+	decl, ok := obj.Decl.(*ast.FunctionDeclaration)
+	if !ok || obj.Kind != FuncObject || !isFallible(decl.Type) {
+		return
+	}
+	c.checkBody(obj)
+}
+
+// checkBody checks the body of the function obj first if it has not yet
+// been, so that the types within it are known.
+func (c *Checker) checkBody(obj *Object) {
+	decl, ok := obj.Decl.(*ast.FunctionDeclaration)
+	if !ok || obj.Kind != FuncObject {
+		return
+	}
+	body := c.bodies[decl]
+	if body == nil {
+		// the bodies of local functions are created when they are
+		// declared, so this is a top-level function not yet queued
+		sig, ok := obj.Type.(*types.Function)
+		if !ok || c.info.Scope.LookupLocal(obj.Name) != obj {
+			return
+		}
+		body = c.newFuncBody(decl, sig, c.info.Scope)
+	}
+	if body.state == unresolved {
+		c.funcBody(body)
+	}
+}
+
+// discarded warns about an expression statement whose value, of type typ,
+// may be an error, which would be silently discarded.
+func (c *Checker) discarded(stmt ast.Expression, typ types.Type) {
+	if types.IsInvalid(typ) {
+		return
+	}
+	if errs := errorMembers(typ); len(errs) > 0 {
+		c.warnf(stmt, "discarded error: %s may be %s; handle it or propagate it with try", stmt, types.NewUnion(errs...))
+	}
+}
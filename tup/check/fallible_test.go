@@ -0,0 +1,68 @@
+package check
+
+import (
+	"strings"
+	"testing"
+)
+
+const errorDecls = "E1 = error(message: String)\n" +
+	"E2 = error(code: Int)\n" +
+	"P = type(x: Int)\n" +
+	"handle = fn(e: E1) Int { 0 }\n" +
+	"classify = fn(n: Int) !Int {\n\tif n < 0 { E1(\"negative\") } else if n == 0 { E2(0) } else { n }\n}\n"
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"error argument", "x = handle(E1(\"a\"))", "x", "Int", ""},
+		{"error supertype", "f = fn() Int | error { E2(1) }", "f", "fn() Int | error", ""},
+		{"error constructor", "x = E1(\"a\")", "x", "E1", ""},
+		{"inferred error set", "x = classify", "x", "fn(n: Int) Int | E1 | E2", ""},
+		{"inferred before declaration", "x = later(1)\nlater = fn(n: Int) !Int { classify(n) }", "x", "Int | E1 | E2", ""},
+		{"propagated by try", "f = fn(n: Int) !Int {\n\tv = try classify(n)\n\tv + 1\n}", "f", "fn(n: Int) Int | E1 | E2", ""},
+		{"returned", "f = fn(n: Int) !Int {\n\tif n < 0 { return E2(n) }\n\tn\n}", "f", "fn(n: Int) Int | E2", ""},
+		{"no errors", "f = fn() !Int { 1 }", "f", "fn() Int", ""},
+		{"try_continue does not propagate", "f = fn() !Int {\n\tfor acc = 0; n in [1, 2] {\n\t\tv = try_continue classify(n)\n\t\tacc + v\n\t}\n}", "f", "fn() Int", ""},
+		{"not an error", "x = handle(P(1))", "x", "", "cannot use P as E1 in argument to handle"},
+		{"inferred result", "f = fn(n: Int) !Int { \"s\" }", "f", "", "cannot use String as Int | error in return value of f"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, errorDecls+tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+func TestDiscardedErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"discarded call", "f = fx() Int {\n\tclassify(1)\n\t0\n}", "discarded error: classify(1) may be E1 | E2; handle it or propagate it with try"},
+		{"discarded error value", "f = fx() Int {\n\tE1(\"a\")\n\t0\n}", "discarded error: E1(\"a\") may be E1"},
+		{"handled with try", "f = fx() !Int {\n\ttry classify(1)\n\t0\n}", ""},
+		{"no error", "f = fx() Int {\n\thandle(E1(\"a\"))\n\t0\n}", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := errorDecls + tt.input
+			info, err := checkSource(t, input)
+			if err != nil {
+				t.Fatalf("Module(%q) = %v", input, err)
+			}
+			if tt.want == "" {
+				if len(info.Warnings) > 0 {
+					t.Errorf("Warnings = %v, want none", info.Warnings)
+				}
+				return
+			}
+			if len(info.Warnings) != 1 || !strings.Contains(info.Warnings[0].Error(), "warning: "+tt.want) {
+				t.Errorf("Warnings = %v, want %q", info.Warnings, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package check
+
+import (
+	"fmt"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/bind"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Instance describes the instance of a generic function a call calls.
+type Instance struct {
+	// TypeArgs holds the types of the type parameters of the function,
+	// in the order of its TypeParams. Calls within a generic function
+	// may give them types that mention its own type parameters.
+	TypeArgs []types.Type
+	// Type is the signature of the instance.
+	Type *types.Function
+}
+
+// typeParams sets the type parameters of the generic function declared by
+// decl, whose signature is sig: those declared, such as the a and b of
+// map[a, b], followed by any others its signature mentions.
+func typeParams(decl *ast.FunctionDeclaration, sig *types.Function) {
+	mentioned := types.TypeParamsOf(sig)
+	var params []*types.TypeParam
+	declared := map[string]bool{}
+	if decl.LHS.ParameterTypes != nil {
+		for _, param := range decl.LHS.ParameterTypes.Parameters {
+			ident, ok := param.(*ast.Identifier)
+			if !ok || declared[ident.Name] {
+				continue
+			}
+			declared[ident.Name] = true
+			typ := types.NewTypeParam(ident.Name)
+			for _, m := range mentioned {
+				if m.Name() == ident.Name {
+					typ = m
+				}
+			}
+			params = append(params, typ)
+		}
+	}
+	for _, m := range mentioned {
+		if !declared[m.Name()] {
+			params = append(params, m)
+		}
+	}
+	sig.TypeParams = params
+}
+
+// inferCall infers the types of the type parameters of the generic
+// function of type sig called by call from the explicit type arguments of
+// the call and the types of its arguments. It returns sig with the types
+// inferred substituted, and the types by type parameter name. The trailing
+// block, whose type depends on that of its parameters, is not used.
+func (c *Checker) inferCall(call *ast.FunctionCall, recv ast.Expression, name string, sig *types.Function) (*types.Function, map[string]types.Type) {
+	targs := map[string]types.Type{}
+	if call.ParameterTypes != nil {
+		explicit := call.ParameterTypes.Parameters
+		if len(explicit) > len(sig.TypeParams) {
+			c.errorf(call.ParameterTypes, "%s takes %d type arguments, got %d", name, len(sig.TypeParams), len(explicit))
+			explicit = explicit[:len(sig.TypeParams)]
+		}
+		for i, arg := range explicit {
+			targs[sig.TypeParams[i].Name()] = c.typExpr(arg)
+		}
+	}
+
+	b, _ := bind.Bind(bind.Params(sig, c.declParams(call, name)), bind.NewCall(call, recv, name))
+	// typed arguments are matched first, so that an untyped constant takes
+	// the type of the parameter given by another argument
+	for _, untyped := range []bool{false, true} {
+		for i, args := range b.Args {
+			for _, arg := range args {
+				typ := c.info.Types[arg.Expr]
+				if arg.Expr == call.FunctionBlock || typ == nil || types.IsUntyped(typ) != untyped {
+					continue
+				}
+				want := sig.Params[i].Type
+				if i == sig.Rest && !arg.Spread {
+					if array, ok := want.Underlying().(*types.Array); ok {
+						want = array.Elem
+					}
+				}
+				if !types.Infer(want, typ, targs) {
+					c.errorf(arg.Expr, "cannot use %s as %s in argument to %s", types.Default(typ), types.Subst(want, targs), name)
+				}
+			}
+		}
+	}
+	inst, _ := types.Subst(sig, targs).(*types.Function)
+	return inst, targs
+}
+
+// instance records the instance of the generic function of type sig
+// called by call, once the types of its type parameters targs are known,
+// and checks that they satisfy the constraints of the type parameters. It
+// returns the signature of the instance, or nil if there is an error.
+func (c *Checker) instance(call *ast.FunctionCall, name string, sig *types.Function, targs map[string]types.Type) *types.Function {
+	if block := call.FunctionBlock; block != nil && len(sig.Params) > 0 {
+		// the result of the block gives the types of the type parameters
+		// only its result mentions
+		types.Infer(sig.Params[len(sig.Params)-1].Type, c.info.Types[block], targs)
+	}
+	args := make([]types.Type, len(sig.TypeParams))
+	ok := true
+	for i, param := range sig.TypeParams {
+		typ, found := targs[param.Name()]
+		if !found {
+			if !c.invalidArgs(call) {
+				c.errorf(call, "cannot infer type parameter %s in call to %s", param, name)
+			}
+			ok = false
+			continue
+		}
+		if err := c.info.Satisfies(typ, param); err != nil {
+			c.errorf(call, "%v in call to %s", err, name)
+			ok = false
+		}
+		args[i] = typ
+	}
+	if !ok {
+		return nil
+	}
+	inst := types.Subst(sig, targs).(*types.Function)
+	c.info.Instances[call] = &Instance{TypeArgs: args, Type: inst}
+	return inst
+}
+
+// invalidArgs reports whether the type of an argument of call is invalid,
+// an error already reported.
+func (c *Checker) invalidArgs(call *ast.FunctionCall) bool {
+	if b := c.info.Calls[call]; b != nil {
+		for _, args := range b.Args {
+			for _, arg := range args {
+				if types.IsInvalid(c.info.Types[arg.Expr]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// genericType returns the type denoted by a generic type expression: an
+// instance of a generic type, or a type parameter constrained by a
+// contract, such as the a of Numeric[a].
+func (c *Checker) genericType(node *ast.GenericType) types.Type {
+	invalid := types.Typ[types.Invalid]
+	var args []types.Type
+	if node.TypeArgs != nil {
+		for _, arg := range node.TypeArgs.Arguments {
+			args = append(args, c.typExpr(arg.Type))
+		}
+	}
+	if node.BaseType == nil || len(node.BaseType.Identifiers) > 0 {
+		// qualified references into other modules are not yet resolved
+		return invalid
+	}
+	name := node.BaseType.TypeIdentifier.Name
+	if name == "Range" && len(args) == 1 {
+		return c.rangeType(args[0])
+	}
+	base := c.typeName(node.BaseType, name)
+	named, ok := base.(*types.Named)
+	if !ok || named.TypeParams() == nil {
+		if !types.IsInvalid(base) {
+			c.errorf(node, "%s is not a generic type", name)
+		}
+		return invalid
+	}
+	if n := len(named.TypeParams()); len(args) != n {
+		c.errorf(node, "%s takes %d type arguments, got %d", name, n, len(args))
+		return invalid
+	}
+	if isContract(named) {
+		param, ok := args[0].(*types.TypeParam)
+		if !ok || len(args) != 1 {
+			c.errorf(node, "contract %s constrains a type parameter, not %s", name, args[0])
+			return invalid
+		}
+		return types.NewConstrainedTypeParam(param.Name(), types.Instantiate(named, args))
+	}
+	return types.Instantiate(named, args)
+}
+
+// isContract reports whether t is a contract or a union of contracts.
+func isContract(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Contract:
+		return true
+	case *types.Union:
+		for _, m := range u.Members {
+			if !isContract(m) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// contractType returns the contract declared by node.
+func (c *Checker) contractType(node *ast.ContractDeclaration) types.Type {
+	var funcs, fields []*types.Field
+	if node.Members != nil {
+		for _, member := range node.Members.Members {
+			switch member := member.(type) {
+			case *ast.ContractFunction:
+				funcs = append(funcs, types.NewField(member.LHS.Name.Name, c.typExpr(member.Type)))
+			case *ast.ContractField:
+				fields = append(fields, types.NewField(member.Name.Name, c.typExpr(member.Type)))
+			}
+		}
+	}
+	return types.NewContract(funcs, fields)
+}
+
+// construct returns the instance of the generic type named constructed by
+// e, whose type arguments are given by the selector types of e or inferred
+// from the fields its arguments initialize.
+func (c *Checker) construct(e *ast.TypeConstructorCall, named *types.Named) types.Type {
+	params := named.TypeParams()
+	targs := map[string]types.Type{}
+	if e.ParameterTypes != nil {
+		for i, arg := range e.ParameterTypes.Parameters {
+			if i < len(params) {
+				targs[params[i].Name()] = c.typExpr(arg)
+			}
+		}
+	}
+	if tuple, ok := named.Underlying().(*types.Tuple); ok && e.Arguments != nil {
+		infer := func(i int, expr ast.Expression) {
+			if i >= 0 && i < len(tuple.Fields) && !types.Infer(tuple.Fields[i].Type, c.info.Types[expr], targs) {
+				c.errorf(expr, "cannot use %s as %s in %s", c.info.Types[expr], types.Subst(tuple.Fields[i].Type, targs), named)
+			}
+		}
+		if e.Arguments.Args != nil {
+			for i, arg := range e.Arguments.Args.Args {
+				infer(i, arg.Expr)
+			}
+		}
+		if e.Arguments.LabeledArgs != nil {
+			for _, arg := range e.Arguments.LabeledArgs.Args {
+				infer(tuple.FieldIndex(arg.Identifier.Name), arg.Argument.Expr)
+			}
+		}
+	}
+	args := make([]types.Type, len(params))
+	for i, param := range params {
+		typ, ok := targs[param.Name()]
+		if !ok {
+			c.errorf(e, "cannot infer type parameter %s of %s", param, named)
+			return types.Typ[types.Invalid]
+		}
+		args[i] = typ
+	}
+	return types.Instantiate(named, args)
+}
+
+// Satisfies returns an error if t does not satisfy the constraint of the
+// type parameter param. Predeclared types provide the functions for their
+// operators, such as add for +; other types satisfy a contract by the
+// functions declared in the module and the fields of their tuples. A union
+// of contracts is satisfied by satisfying any of them. Type parameters are
+// checked once they are instantiated.
+func (info *Info) Satisfies(t types.Type, param *types.TypeParam) error {
+	if param.Constraint() == nil || types.IsTypeParam(t) || types.IsInvalid(t) {
+		return nil
+	}
+	if missing := info.missing(t, param.Name(), param.Constraint()); missing != "" {
+		return fmt.Errorf("%s does not satisfy %s (missing %s)", t, param.Constraint(), missing)
+	}
+	return nil
+}
+
+// missing returns the name of a function or field that t, standing for
+// the type parameter param, lacks to satisfy the contract constraint, or
+// the empty string.
+func (info *Info) missing(t types.Type, param string, constraint types.Type) string {
+	switch u := constraint.Underlying().(type) {
+	case *types.Union:
+		first := ""
+		for _, m := range u.Members {
+			missing := info.missing(t, param, m)
+			if missing == "" {
+				return ""
+			}
+			if first == "" {
+				first = missing
+			}
+		}
+		return first
+	case *types.Contract:
+		args := map[string]types.Type{param: t}
+		for _, f := range u.Funcs {
+			if !info.provides(t, f.Name, types.Subst(f.Type, args)) {
+				return f.Name
+			}
+		}
+		for _, f := range u.Fields {
+			tuple, ok := t.Underlying().(*types.Tuple)
+			if !ok || tuple.FieldIndex(f.Name) < 0 {
+				return f.Name
+			}
+			want := types.Subst(f.Type, args)
+			if !types.IsGeneric(want) && !types.Identical(tuple.Fields[tuple.FieldIndex(f.Name)].Type, want) {
+				return f.Name
+			}
+		}
+	}
+	return ""
+}
+
+// provides reports whether the function name of type sig is provided for
+// t, by its operators or by a declaration.
+func (info *Info) provides(t types.Type, name string, sig types.Type) bool {
+	if b, ok := t.(*types.Basic); ok && operatorFuncs(b)[name] {
+		return true
+	}
+	for node, typ := range info.Types {
+		if decl, ok := node.(*ast.FunctionDeclaration); ok && decl.LHS.Name.Name == name && types.Identical(typ, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// operatorFuncs returns the names of the functions a predeclared type
+// provides for its operators.
+func operatorFuncs(t *types.Basic) map[string]bool {
+	names := map[string]bool{}
+	add := func(list ...string) {
+		for _, name := range list {
+			names[name] = true
+		}
+	}
+	switch {
+	case types.IsNumeric(t):
+		add("eq?", "lt?", "gt?", "lte?", "gte?", "compare_to",
+			"add", "sub", "mul", "div", "mod", "pow",
+			"checked_add", "checked_sub", "checked_mul", "checked_div", "checked_mod", "checked_pow")
+		if !types.IsUnsigned(t) {
+			add("neg")
+		}
+	case types.IsString(t):
+		add("eq?", "lt?", "gt?", "lte?", "gte?", "compare_to", "add")
+	case types.IsBool(t):
+		add("eq?")
+	}
+	return names
+}
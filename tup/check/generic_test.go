@@ -0,0 +1,57 @@
+package check
+
+import "testing"
+
+const genericDecls = "Cons[a] = type(head: a, tail: List[a])\n" +
+	"List[a] = Nil | Cons[a]\n" +
+	"Box[a] = type(value: a)\n" +
+	"Numeric[a] = contract(\n\tadd[a] = fn(a, a) a\n\tmul[a] = fn(a, a) a\n)\n" +
+	"Named[a] = contract(\n\tname: String\n)\n" +
+	"id[a]: fn(x: a) a { x }\n" +
+	"same[a]: fn(x: a, y: a) a { x }\n" +
+	"pair[a, b]: fn(x: a, y: b) (a, b) { (x, y) }\n" +
+	"empty[a]: fn() List[a] { nil }\n" +
+	"prepend[a]: fn(list: List[a], head: a) List[a] { Cons(head: head, tail: list) }\n" +
+	"map[a, b]: fn(list: List[a], f: fn(a) b) List[b] { nil }\n" +
+	"apply[a, b]: fn(x: a, f: fn(a) b) b { f(x) }\n" +
+	"sqr[a]: fn(x: Numeric[a]) a { x * x }\n" +
+	"keep[a]: fn(x: Numeric[a]) a { x }\n" +
+	"greet[a]: fn(x: Named[a]) String { x.name }\n" +
+	"double: fn(x: Int) Int { x * 2 }\n"
+
+func TestGeneric(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"inferred", "x = id(1)", "x", "Int", ""},
+		{"inferred string", "x = id(\"s\")", "x", "String", ""},
+		{"two type parameters", "x = pair(1.5, true)", "x", "(Float, Bool)", ""},
+		{"untyped after typed", "x = same(1.5, 2)", "x", "Float", ""},
+		{"explicit", "x = empty[Int]()", "x", "List[Int]", ""},
+		{"generic type", "x = prepend(nil, 1)", "x", "List[Int]", ""},
+		{"function argument", "x = map(prepend(nil, 1), double)", "x", "List[Int]", ""},
+		{"trailing block", "x = apply(2) { it > 1 }", "x", "Bool", ""},
+		{"constructor", "x = Box(value: \"s\")", "x", "Box[String]", ""},
+		{"constructor positional", "x = Box(2.5)", "x", "Box[Float]", ""},
+		{"case of generic type", "xs = prepend(nil, 1)\nx = switch xs {\n\tCons { |c| c.head }\n\tNil { 0 }\n}", "x", "Int", ""},
+		{"contract", "x = sqr(3)", "x", "Int", ""},
+		{"contract field", "Person = type(name: String)\nx = greet(Person(name: \"Ann\"))", "x", "String", ""},
+		{"contract function", "V = type(x: Int)\nadd = fn(a: V, b: V) V { a }\nmul = fn(a: V, b: V) V { b }\nx = keep(V(1))", "x", "V", ""},
+		{"unsatisfied", "x = sqr(\"s\")", "x", "", "String does not satisfy Numeric[a] (missing mul) in call to sqr"},
+		{"unsatisfied field", "x = greet(Box(1))", "x", "", "Box[Int] does not satisfy Named[a] (missing name) in call to greet"},
+		{"conflict", "x = same(1, \"s\")", "x", "", "cannot use Int as String in argument to same"},
+		{"cannot infer", "x = empty()", "x", "", "cannot infer type parameter a in call to empty"},
+		{"too many type arguments", "x = empty[Int, Int]()", "x", "", "empty takes 1 type arguments, got 2"},
+		{"generic type arguments", "f = fn(x: List[Int, String]) Int { 0 }", "f", "", "List takes 1 type arguments, got 2"},
+		{"not generic", "f = fn(x: Int[String]) Int { 0 }", "f", "", "Int is not a generic type"},
+		{"contract as type", "f = fn(x: Numeric[Int]) Int { 0 }", "f", "", "contract Numeric constrains a type parameter, not Int"},
+		{"constructor cannot infer", "x = Box()", "x", "", "cannot infer type parameter a of Box"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, genericDecls+tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
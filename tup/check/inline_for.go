@@ -0,0 +1,426 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// InlineFor describes the compile-time expansion of an inline for loop.
+type InlineFor struct {
+	// Iterations holds one entry per field of the iterated tuple, in
+	// declaration order.
+	Iterations []*InlineForIteration
+	// Lowered is a block equivalent to the loop, with the loop unrolled
+	// and each body specialized for its field.
+	Lowered *ast.Block
+	// Type is the type of the loop's value: the type of the final
+	// accumulator, or Nil for loops without an initializer.
+	Type types.Type
+}
+
+// InlineForIteration is a single unrolled iteration of an inline for loop.
+type InlineForIteration struct {
+	Field *types.Field       // the field visited
+	Name  *ast.SymbolLiteral // the symbol bound to the loop's name
+	Body  *ast.Block         // the body specialized for Name
+	Type  types.Type         // the type of the body
+}
+
+// inlineEnv holds the names bound to compile-time symbols during the
+// specialization of an inline for body.
+type inlineEnv struct {
+	symbols map[string]string // name -> symbol, for name, value in ...
+	records map[string]string // field -> symbol, for field in ... (field.name)
+}
+
+// inlineFor unrolls an inline for loop over the fields of a tuple. Each
+// iteration's body is specialized for the field's name, so that switches
+// and comparisons on the name select a single branch at compile time, and
+// then checked with the accumulator bound to the result of the previous
+// iteration.
+func (c *Checker) inlineFor(e *ast.InlineForExpression) types.Type {
+	invalid := types.Typ[types.Invalid]
+	header := e.Header
+
+	c.openScope()
+	defer c.closeScope()
+
+	var init *ast.Assignment
+	var acc types.Type = types.Typ[types.Nil]
+	if header.Initializer != nil && header.Initializer.Assignment != nil {
+		init = header.Initializer.Assignment
+		acc = types.Default(c.expr(init.Right))
+	}
+	if header.StepExpr != nil {
+		c.errorf(header.StepExpr, "inline for does not allow a step expression")
+	}
+
+	if ref := header.Iterable.TypeReference; ref != nil {
+		c.errorf(header.Iterable, "inline for requires a tuple whose fields are known at compile time, got type %s", ref)
+		return invalid
+	}
+	iterable := c.expr(header.Iterable.Expression)
+	if types.IsInvalid(iterable) {
+		return invalid
+	}
+	tuple, ok := iterable.Underlying().(*types.Tuple)
+	if !ok {
+		c.errorf(header.Iterable, "inline for requires a tuple whose fields are known at compile time, got %s", iterable)
+		return invalid
+	}
+
+	// a non-identifier iterable is evaluated once, before the first iteration
+	var iterExpr ast.Expression = header.Iterable.Expression
+	var prelude []ast.Statement
+	if !isIdentifier(iterExpr) {
+		tmp := ast.NewIdentifier("inline_for#iterable", nil, 0, 0)
+		prelude = append(prelude, ast.NewAssignment(
+			ast.NewOrdinalAssignmentLHS([]*ast.Identifier{tmp}, nil), ast.Immutable, iterExpr))
+		iterExpr = ast.NewIdentifier(tmp.Name, nil, 0, 0)
+	}
+	if init != nil {
+		prelude = append(prelude, init)
+	}
+
+	result := &InlineFor{}
+	for i, field := range tuple.Fields {
+		if field.Name == "" {
+			c.errorf(header.Iterable, "inline for requires a labeled tuple, but field %d of %s has no label", i, iterable)
+			return invalid
+		}
+		symbol := ast.NewSymbolLiteral(":"+field.Name, nil, 0, 0)
+		env := c.inlineEnv(header.LoopVar, symbol.Value)
+
+		errs := len(c.errors)
+		body := c.specialize(forBlock(e.Block), env)
+		if len(c.errors) > errs {
+			return invalid
+		}
+
+		typ := c.inlineIteration(e, init, acc, field, symbol, body)
+		if types.IsInvalid(typ) {
+			return invalid
+		}
+		if init != nil {
+			if _, ok := typ.Underlying().(*types.Union); ok {
+				c.errorf(e.Block, "the result of inline for depends on a runtime value: iteration %s has type %s", symbol.Value, typ)
+				return invalid
+			}
+			acc = types.Default(typ)
+		}
+
+		result.Iterations = append(result.Iterations, &InlineForIteration{
+			Field: field,
+			Name:  symbol,
+			Body:  body,
+			Type:  typ,
+		})
+	}
+
+	result.Type = acc
+	result.Lowered = lowerInlineFor(e, init, prelude, iterExpr, result.Iterations)
+	// the lowered block is checked too, so that its nodes have types for
+	// the passes that evaluate or compile it
+	c.block(result.Lowered)
+	c.info.InlineFors[e] = result
+	return acc
+}
+
+// inlineIteration checks the specialized body of a single iteration.
+func (c *Checker) inlineIteration(e *ast.InlineForExpression, init *ast.Assignment, acc types.Type, field *types.Field, symbol *ast.SymbolLiteral, body *ast.Block) types.Type {
+	c.openScope()
+	defer c.closeScope()
+
+	if init != nil {
+		c.bindLHS(init.Left, acc, false, func(ident *ast.Identifier, typ types.Type) {
+			c.scope.Insert(&Object{Kind: VarObject, Name: ident.Name, Type: typ, Decl: init, state: resolved})
+		})
+	}
+	loopVar := types.NewTuple(
+		types.NewField("name", types.Typ[types.Symbol]),
+		types.NewField("value", field.Type),
+	)
+	nameIdent := loopNameIdentifier(e.Header.LoopVar)
+	c.bindLHS(e.Header.LoopVar, loopVar, false, func(ident *ast.Identifier, typ types.Type) {
+		obj := &Object{Kind: VarObject, Name: ident.Name, Type: typ, Decl: e.Header, state: resolved}
+		if ident == nameIdent {
+			obj.Const = consteval.Symbol(field.Name)
+		}
+		c.scope.Insert(obj)
+	})
+
+	typ := c.block(body)
+	if init != nil && body.Body.Expression == nil {
+		c.errorf(e.Block, "inline for body has no final expression")
+		return types.Typ[types.Invalid]
+	}
+	return typ
+}
+
+// loopNameIdentifier returns the identifier bound to the field name by the
+// loop variables of an inline for, or nil if the name is not bound directly.
+func loopNameIdentifier(lhs ast.AssignmentLHS) *ast.Identifier {
+	switch lhs := lhs.(type) {
+	case *ast.OrdinalAssignmentLHS:
+		if len(lhs.Identifiers) == 2 {
+			return lhs.Identifiers[0]
+		}
+	case *ast.LabeledAssignmentLHS:
+		for _, rename := range lhs.Renames {
+			if rename, ok := rename.(*ast.RenameIdentifier); ok {
+				if rename.Original != nil && rename.Original.Name == "name" ||
+					rename.Original == nil && rename.Identifier.Name == "name" {
+					return rename.Identifier
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Checker) inlineEnv(lhs ast.AssignmentLHS, symbol string) *inlineEnv {
+	env := &inlineEnv{symbols: map[string]string{}, records: map[string]string{}}
+	if ident := loopNameIdentifier(lhs); ident != nil {
+		env.symbols[ident.Name] = symbol
+	} else if lhs, ok := lhs.(*ast.OrdinalAssignmentLHS); ok && len(lhs.Identifiers) == 1 {
+		env.records[lhs.Identifiers[0].Name] = symbol
+	}
+	return env
+}
+
+func forBlock(block *ast.ForBlock) *ast.Block {
+	if block == nil {
+		return ast.NewBlock(ast.NewBlockBody([]ast.Statement{}, nil))
+	}
+	return ast.NewBlock(ast.NewBlockBody(block.Statements, block.Expression))
+}
+
+// specialize returns a copy of body in which switches and comparisons on
+// the compile-time symbol bound by env have been resolved.
+func (c *Checker) specialize(body *ast.Block, env *inlineEnv) *ast.Block {
+	copied := ast.Clone(body).(*ast.Block)
+	return ast.Rewrite(copied, func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.SwitchExpression:
+			return c.specializeSwitch(node, env)
+		case *ast.IfExpression:
+			return c.specializeIf(node, env)
+		}
+		return node
+	}).(*ast.Block)
+}
+
+// symbolValue returns the symbol an expression denotes at compile time.
+func (env *inlineEnv) symbolValue(expr ast.Node) (string, bool) {
+	switch expr := expr.(type) {
+	case *ast.SymbolLiteral:
+		return expr.Value, true
+	case *ast.Identifier:
+		symbol, ok := env.symbols[expr.Name]
+		return symbol, ok
+	case *ast.FunctionIdentifier:
+		symbol, ok := env.symbols[expr.Name]
+		return symbol, ok
+	case *ast.MemberAccess:
+		member, ok := expr.Member.(*ast.Identifier)
+		if !ok || member.Name != "name" {
+			break
+		}
+		switch object := expr.Object.(type) {
+		case *ast.Identifier:
+			symbol, ok := env.records[object.Name]
+			return symbol, ok
+		case *ast.FunctionIdentifier:
+			symbol, ok := env.records[object.Name]
+			return symbol, ok
+		}
+	}
+	return "", false
+}
+
+func (c *Checker) specializeSwitch(e *ast.SwitchExpression, env *inlineEnv) ast.Node {
+	symbol, ok := env.symbolValue(e.Expression)
+	if !ok {
+		return e
+	}
+	for _, switchCase := range e.Cases {
+		match, ok := matchSymbol(switchCase.Condition, symbol)
+		if !ok {
+			c.errorf(switchCase.Condition, "case %s cannot be matched against the compile-time symbol %s", switchCase.Condition, symbol)
+			return e
+		}
+		if match {
+			return caseBlock(switchCase.Body, e.Expression)
+		}
+	}
+	if e.ElseBlock != nil {
+		return caseBlock(e.ElseBlock, e.Expression)
+	}
+	c.errorf(e, "no case of switch matches %s", symbol)
+	return e
+}
+
+// matchSymbol reports whether a case condition matches symbol. It reports
+// ok == false if the condition cannot be decided at compile time.
+func matchSymbol(cond ast.MatchCondition, symbol string) (match, ok bool) {
+	switch cond := cond.(type) {
+	case *ast.Constant:
+		if lit, ok := cond.Value.(*ast.SymbolLiteral); ok {
+			return lit.Value == symbol, true
+		}
+		// other constants never equal a symbol
+		return false, true
+	case *ast.ListMatch:
+		for _, element := range cond.Elements {
+			condition, ok := element.(ast.MatchCondition)
+			if !ok {
+				return false, false
+			}
+			if match, ok := matchSymbol(condition, symbol); !ok || match {
+				return match, ok
+			}
+		}
+		return false, true
+	case *ast.WildcardPattern:
+		return true, true
+	}
+	return false, false
+}
+
+// caseBlock converts the body of a selected switch case to a block, binding
+// any block parameters to the switch subject.
+func caseBlock(body *ast.FunctionBlock, subject ast.Expression) *ast.Block {
+	if body.Parameters == nil || body.Parameters.Parameters == nil {
+		return ast.NewBlock(body.Body)
+	}
+	statements := []ast.Statement{ast.NewAssignment(body.Parameters.Parameters, ast.Immutable, subject)}
+	statements = append(statements, body.Body.Statements...)
+	return ast.NewBlock(ast.NewBlockBody(statements, body.Body.Expression))
+}
+
+func (c *Checker) specializeIf(e *ast.IfExpression, env *inlineEnv) ast.Node {
+	var conditions []ast.Node
+	var blocks []*ast.Block
+	for i, cond := range e.Conditions {
+		value, ok := env.boolValue(cond)
+		switch {
+		case !ok:
+			conditions = append(conditions, cond)
+			blocks = append(blocks, e.Blocks[i])
+		case value && len(conditions) == 0:
+			return e.Blocks[i]
+		case value:
+			return ast.NewIfExpression(conditions, append(blocks, e.Blocks[i]), true)
+		}
+	}
+	var elseBlock *ast.Block
+	if e.HasElse {
+		elseBlock = e.Blocks[len(e.Conditions)]
+	}
+	switch {
+	case len(conditions) == len(e.Conditions):
+		return e
+	case len(conditions) > 0 && elseBlock != nil:
+		return ast.NewIfExpression(conditions, append(blocks, elseBlock), true)
+	case len(conditions) > 0:
+		return ast.NewIfExpression(conditions, blocks, false)
+	case elseBlock != nil:
+		return elseBlock
+	}
+	return ast.NewIdentifier("nil", nil, 0, 0)
+}
+
+// boolValue returns the value of a comparison between compile-time symbols.
+func (env *inlineEnv) boolValue(cond ast.Node) (value, ok bool) {
+	switch cond := cond.(type) {
+	case *ast.BooleanLiteral:
+		return cond.BooleanValue, true
+	case *ast.RelationalComparison:
+		if cond.Operator != ast.OpEq && cond.Operator != ast.OpNeq {
+			break
+		}
+		left, ok := env.symbolValue(cond.Left)
+		if !ok {
+			break
+		}
+		right, ok := env.symbolValue(cond.Right)
+		if !ok {
+			break
+		}
+		return (left == right) == (cond.Operator == ast.OpEq), true
+	}
+	return false, false
+}
+
+func isIdentifier(expr ast.Expression) bool {
+	switch expr.(type) {
+	case *ast.Identifier, *ast.FunctionIdentifier:
+		return true
+	}
+	return false
+}
+
+// lowerInlineFor builds a block equivalent to an unrolled inline for loop:
+//
+//	{
+//	    acc = init
+//	    {
+//	        name, value = (name: :a, value: iterable.a)
+//	        acc = { specialized body }
+//	        { ... acc }
+//	    }
+//	}
+func lowerInlineFor(e *ast.InlineForExpression, init *ast.Assignment, prelude []ast.Statement, iterExpr ast.Expression, iterations []*InlineForIteration) *ast.Block {
+	var next ast.Expression = ast.NewIdentifier("nil", nil, 0, 0)
+	if init != nil {
+		next = lhsValue(init.Left)
+	}
+	for i := len(iterations) - 1; i >= 0; i-- {
+		iteration := iterations[i]
+		value := ast.NewMemberAccess(ast.Clone(iterExpr), ast.NewIdentifier(iteration.Field.Name, nil, 0, 0))
+		loopVar := ast.NewTupleLiteral(true, []*ast.TupleMember{
+			ast.NewTupleMember(ast.NewIdentifier("name", nil, 0, 0), iteration.Name),
+			ast.NewTupleMember(ast.NewIdentifier("value", nil, 0, 0), value),
+		})
+		statements := []ast.Statement{ast.NewAssignment(e.Header.LoopVar, ast.Immutable, loopVar)}
+		if init != nil {
+			statements = append(statements, ast.NewAssignment(init.Left, ast.Immutable, iteration.Body))
+		} else {
+			statements = append(statements, iteration.Body)
+		}
+		next = ast.NewBlock(ast.NewBlockBody(statements, next))
+	}
+	return ast.NewBlock(ast.NewBlockBody(prelude, next))
+}
+
+// lhsValue returns an expression that rebuilds the value bound by lhs.
+func lhsValue(lhs ast.AssignmentLHS) ast.Expression {
+	switch lhs := lhs.(type) {
+	case *ast.OrdinalAssignmentLHS:
+		if len(lhs.Identifiers) == 1 && lhs.RestOperator == nil {
+			return lhs.Identifiers[0]
+		}
+		var members []*ast.TupleMember
+		for _, ident := range lhs.Identifiers {
+			members = append(members, ast.NewTupleMember(nil, ident))
+		}
+		if lhs.RestOperator != nil && lhs.RestOperator.Identifier != nil {
+			members = append(members, ast.NewSpreadTupleMember(lhs.RestOperator.Identifier))
+		}
+		return ast.NewTupleLiteral(false, members)
+	case *ast.LabeledAssignmentLHS:
+		var members []*ast.TupleMember
+		for _, rename := range lhs.Renames {
+			if rename, ok := rename.(*ast.RenameIdentifier); ok {
+				label := rename.Identifier
+				if rename.Original != nil {
+					label = rename.Original
+				}
+				members = append(members, ast.NewTupleMember(label, rename.Identifier))
+			}
+		}
+		return ast.NewTupleLiteral(true, members)
+	}
+	return ast.NewIdentifier("nil", nil, 0, 0)
+}
@@ -0,0 +1,179 @@
+package check
+
+import (
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+)
+
+const abc = `ABC = type(a: Int, b: String, c: Float)
+abc = ABC(1, "Hello", 5.5)
+`
+
+func TestInlineFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantType string
+		wantErr  string
+	}{
+		{
+			name: "switch on name",
+			input: abc + `def = inline for acc = (); name, value in abc {
+    switch name {
+        :a { (...acc, d: value + 1) }
+        :b { (...acc, e: value + " World") }
+        :c { (...acc, f: value + 4.5) }
+    }
+}`,
+			wantType: "(d: Int, e: String, f: Float)",
+		},
+		{
+			name: "switch with else",
+			input: abc + `def = inline for acc = (); name, value in abc {
+    switch name {
+        :b { (...acc, e: value) }
+        else { acc }
+    }
+}`,
+			wantType: "(e: String)",
+		},
+		{
+			name: "switch with list match",
+			input: abc + `def = inline for acc = (); name, value in abc {
+    switch name {
+        :a, :c { (...acc, value) }
+        else { acc }
+    }
+}`,
+			wantType: "(Int, Float)",
+		},
+		{
+			name: "if on name",
+			input: abc + `def = inline for acc = (); name, value in abc {
+    if name == :b { acc } else { (...acc, value) }
+}`,
+			wantType: "(Int, Float)",
+		},
+		{
+			name: "name and value as a tuple",
+			input: abc + `def = inline for acc = (); field in abc {
+    switch field.name {
+        :a { (...acc, x: field.value) }
+        else { acc }
+    }
+}`,
+			wantType: "(x: Int)",
+		},
+		{
+			name: "statements in body",
+			input: abc + `def = inline for acc = (); name, value in abc {
+    v = (value: value)
+    (...acc, v)
+}`,
+			wantType: "((value: Int), (value: String), (value: Float))",
+		},
+		{
+			name:     "no initializer",
+			input:    abc + `def = inline for name, value in abc { value }`,
+			wantType: "Nil",
+		},
+		{
+			name:    "iterable is not a tuple",
+			input:   `def = inline for acc = (); name, value in [1, 2] { acc }`,
+			wantErr: "inline for requires a tuple whose fields are known at compile time, got []Int",
+		},
+		{
+			name:    "unlabeled tuple",
+			input:   `def = inline for acc = (); name, value in (1, 2) { acc }`,
+			wantErr: "inline for requires a labeled tuple, but field 0 of (Int, Int) has no label",
+		},
+		{
+			name: "no matching case",
+			input: abc + `def = inline for acc = (); name, value in abc {
+    switch name {
+        :a { (...acc, value) }
+    }
+}`,
+			wantErr: "no case of switch matches :b",
+		},
+		{
+			name: "case is not a symbol",
+			input: abc + `def = inline for acc = (); name, value in abc {
+    switch name {
+        Int { (...acc, value) }
+    }
+}`,
+			wantErr: "case Int cannot be matched against the compile-time symbol :a",
+		},
+		{
+			name: "shape depends on runtime value",
+			input: abc + `flag = abc.a > 0
+def = inline for acc = (); name, value in abc {
+    if flag { (...acc, value) } else { acc }
+}`,
+			wantErr: "the result of inline for depends on a runtime value: iteration :a has type (Int) | ()",
+		},
+		{
+			name: "body without final expression",
+			input: abc + `def = inline for acc = (); name, value in abc {
+    x = value
+}`,
+			wantErr: "inline for body has no final expression",
+		},
+		{
+			name: "step expression",
+			input: abc + `def = inline for acc = (); name, value in abc; acc {
+    acc
+}`,
+			wantErr: "inline for does not allow a step expression",
+		},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, "def", tt.wantType, tt.wantErr)
+	}
+}
+
+func TestInlineForLowered(t *testing.T) {
+	info, err := checkSource(t, abc+`def = inline for acc = (); name, value in abc {
+    switch name {
+        :a { (...acc, d: value + 1) }
+        :b { (...acc, e: value + " World") }
+        :c { (...acc, f: value + 4.5) }
+    }
+}`)
+	if err != nil {
+		t.Fatalf("Module() = %v", err)
+	}
+	if len(info.InlineFors) != 1 {
+		t.Fatalf("len(InlineFors) = %d, want 1", len(info.InlineFors))
+	}
+	for _, inlineFor := range info.InlineFors {
+		wantNames := []string{":a", ":b", ":c"}
+		wantTypes := []string{"(d: Int)", "(d: Int, e: String)", "(d: Int, e: String, f: Float)"}
+		if len(inlineFor.Iterations) != len(wantNames) {
+			t.Fatalf("len(Iterations) = %d, want %d", len(inlineFor.Iterations), len(wantNames))
+		}
+		for i, iteration := range inlineFor.Iterations {
+			if iteration.Name.Value != wantNames[i] {
+				t.Errorf("Iterations[%d].Name = %s, want %s", i, iteration.Name.Value, wantNames[i])
+			}
+			if iteration.Type.String() != wantTypes[i] {
+				t.Errorf("Iterations[%d].Type = %s, want %s", i, iteration.Type, wantTypes[i])
+			}
+			// the switch has been replaced by the selected case
+			ast.Inspect(iteration.Body, func(node ast.Node) bool {
+				if _, ok := node.(*ast.SwitchExpression); ok {
+					t.Errorf("Iterations[%d].Body contains a switch", i)
+				}
+				return true
+			})
+		}
+		if inlineFor.Lowered == nil {
+			t.Fatal("Lowered = nil")
+		}
+		if got := inlineFor.Lowered.Body.Statements[0].String(); got != "acc = ()" {
+			t.Errorf("Lowered.Body.Statements[0] = %s, want acc = ()", got)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// loop records the types of the values with which a for loop may be
+// exited by break.
+type loop struct {
+	breaks []types.Type
+}
+
+// forExpr checks a for loop and returns the type of its value.
+//
+// The variables bound by an initializer take the value of the step
+// expression, or of the block's final expression if there is none, at each
+// iteration, so that value must have the shape and type of the initializer.
+// A loop that ends when its condition fails or its iterable is exhausted
+// has the value of its initializer, or nil if it has none; any loop may
+// also be exited with the value of a break.
+func (c *Checker) forExpr(e *ast.ForExpression) types.Type {
+	c.openScope()
+	defer c.closeScope()
+	saved := len(c.facts)
+	defer func() { c.facts = c.facts[:saved] }()
+	l := &loop{}
+	c.loops = append(c.loops, l)
+	defer func() { c.loops = c.loops[:len(c.loops)-1] }()
+
+	var init *ast.Initializer
+	var step *ast.StepExpression
+	bounded := false
+	switch header := e.Header.(type) {
+	case *ast.ForHeader:
+		init, step = header.Initializer, header.StepExpr
+		if init != nil {
+			c.loopInitializer(init)
+		}
+		if header.Condition != nil {
+			if typ := c.expr(header.Condition); !types.IsInvalid(typ) && !types.IsBool(typ) {
+				c.errorf(header.Condition, "non-Bool %s used as loop condition", typ)
+			}
+			c.assume(header.Condition)
+			bounded = true
+		}
+	case *ast.ForInHeader:
+		init, step = header.Initializer, header.StepExpr
+		if init != nil {
+			c.loopInitializer(init)
+		}
+		elem := c.iterable(header.Iterable)
+		c.bindLHS(header.LoopVar, elem, false, func(ident *ast.Identifier, typ types.Type) {
+			c.scope.Insert(&Object{Kind: VarObject, Name: ident.Name, Type: typ, Decl: header, ident: ident, loop: true, state: resolved})
+		})
+		if r, ok := header.Iterable.Expression.(*ast.Range); ok {
+			if objs := c.lhsObjects(header.LoopVar); len(objs) == 1 {
+				c.assumeRange(objs[0], r)
+			}
+		}
+		bounded = true
+	}
+
+	var final types.Type
+	if e.Block != nil {
+		c.openScope()
+		final = c.statements(e.Block.Statements, e.Block.Expression)
+		c.closeScope()
+	}
+
+	var typ types.Type = types.Typ[types.Nil]
+	if init != nil {
+		typ = types.Default(c.info.Types[init.Assignment.Right])
+		switch {
+		case step != nil:
+			c.loopStep(step.Expression, typ)
+		case e.Block == nil || e.Block.Expression == nil:
+			c.errorf(e, "block has no final expression: the loop variables take its value at each iteration")
+		default:
+			c.loopValue(e.Block.Expression, final, typ)
+		}
+	}
+
+	var results []types.Type
+	if bounded {
+		results = append(results, typ)
+	}
+	results = append(results, l.breaks...)
+	if len(results) == 0 {
+		// a loop without a condition or break never ends
+		return types.Typ[types.Nil]
+	}
+	return types.NewUnion(results...)
+}
+
+// loopInitializer checks the initializer of a for loop. The bound variables
+// take a new value at each step, so they are not compile-time constants.
+func (c *Checker) loopInitializer(init *ast.Initializer) {
+	c.assignment(init.Assignment)
+	for _, obj := range c.lhsObjects(init.Assignment.Left) {
+		obj.Const = nil
+		obj.loop = true
+	}
+}
+
+// loopStep checks a step expression, which is evaluated in the scope of
+// the loop variables after the block.
+func (c *Checker) loopStep(step ast.Expression, init types.Type) {
+	c.loopValue(step, c.expr(step), init)
+}
+
+// loopValue checks that the value of type typ computed by e for the next
+// iteration matches the type init of the initializer.
+func (c *Checker) loopValue(e ast.Expression, typ, init types.Type) {
+	if types.IsInvalid(typ) || types.IsInvalid(init) {
+		return
+	}
+	if union, ok := typ.Underlying().(*types.Union); ok {
+		for _, m := range union.Members {
+			if !c.loopValueFits(m, init) {
+				c.errorf(e, "cannot use %s as %s in next iteration of loop", typ, init)
+				return
+			}
+		}
+		return
+	}
+	from, fromTuple := typ.Underlying().(*types.Tuple)
+	to, toTuple := init.Underlying().(*types.Tuple)
+	if fromTuple != toTuple || fromTuple && len(from.Fields) != len(to.Fields) {
+		c.errorf(e, "tuple shape %s does not match initializer %s", shape(typ), shape(init))
+		return
+	}
+	if !c.loopValueFits(typ, init) {
+		c.errorf(e, "cannot use %s as %s in next iteration of loop", typ, init)
+	}
+}
+
+// loopValueFits reports whether a loop value of type typ may be assigned to
+// the variables bound by an initializer of type init, field by field for
+// tuples.
+func (c *Checker) loopValueFits(typ, init types.Type) bool {
+	from, ok1 := typ.Underlying().(*types.Tuple)
+	to, ok2 := init.Underlying().(*types.Tuple)
+	if !ok1 || !ok2 {
+		return c.assignable(typ, init)
+	}
+	if len(from.Fields) != len(to.Fields) {
+		return false
+	}
+	for i, f := range from.Fields {
+		if !c.assignable(types.Default(f.Type), types.Default(to.Fields[i].Type)) {
+			return false
+		}
+	}
+	return true
+}
+
+// shape returns the field types of a tuple type, or the type itself as a
+// single field, for diagnostics.
+func shape(typ types.Type) string {
+	if tuple, ok := typ.Underlying().(*types.Tuple); ok {
+		return types.NewTuple(tuple.Fields...).String()
+	}
+	return "(" + typ.String() + ")"
+}
+
+// breakExpr checks a break, whose value, or nil, becomes a value of the
+// innermost enclosing loop.
+func (c *Checker) breakExpr(e *ast.BreakExpression) {
+	var typ types.Type = types.Typ[types.Nil]
+	if e.Expression != nil {
+		typ = types.Default(c.expr(e.Expression))
+	}
+	if len(c.loops) == 0 {
+		c.errorf(e, "break is not in a loop")
+		return
+	}
+	l := c.loops[len(c.loops)-1]
+	l.breaks = append(l.breaks, typ)
+}
+
+func (c *Checker) continueExpr(e *ast.ContinueExpression) {
+	if e.Expression != nil {
+		c.expr(e.Expression)
+	}
+	if len(c.loops) == 0 {
+		c.errorf(e, "continue is not in a loop")
+	}
+}
+
+// iterable checks the iterable of a for-in loop and returns the type of
+// the values it produces.
+func (c *Checker) iterable(it *ast.Iterable) types.Type {
+	if ref := it.TypeReference; ref != nil {
+		return c.enumIterable(ref)
+	}
+	typ := c.expr(it.Expression)
+	if types.IsInvalid(typ) {
+		return typ
+	}
+	elem := c.elemType(typ)
+	if types.IsInvalid(elem) {
+		typ = types.Default(typ)
+		c.errorf(it.Expression, "cannot iterate over %s: it is not an array, range or string and no function next(it: %s) is declared", typ, typ)
+	}
+	return elem
+}
+
+// elemType returns the type of the values produced by iterating over a
+// value of type typ, or the invalid type if it is not iterable.
+//
+// Arrays produce their elements, ranges their integers and strings their
+// runes. A tuple of iterables produces tuples of their values in lockstep,
+// ending with the shortest. Any other type T is iterable if there is a
+// function next(it: T) returning a union of Nil, when there are no more
+// values, and a tuple of the next value and the rest, such as (E, T).
+func (c *Checker) elemType(typ types.Type) types.Type {
+	invalid := types.Typ[types.Invalid]
+	switch t := typ.Underlying().(type) {
+	case *types.Array:
+		return t.Elem
+	case *types.Tuple:
+		if named, ok := typ.(*types.Named); ok && c.ranges[named.Name()] == named {
+			return t.Fields[0].Type
+		}
+		if _, ok := typ.(*types.Named); !ok && len(t.Fields) > 0 {
+			fields := make([]*types.Field, len(t.Fields))
+			for i, f := range t.Fields {
+				elem := c.elemType(f.Type)
+				if types.IsInvalid(elem) {
+					return invalid
+				}
+				fields[i] = types.NewField(f.Name, elem)
+			}
+			return types.NewTuple(fields...)
+		}
+	}
+	if types.IsString(typ) {
+		return types.Rune
+	}
+	return c.iterator(typ)
+}
+
+// iterator returns the type of the values produced by the function
+// next(it: T) for the iterator type T, or the invalid type if there is no
+// such function.
+func (c *Checker) iterator(typ types.Type) types.Type {
+	invalid := types.Typ[types.Invalid]
+	obj := c.scope.Lookup("next")
+	if obj == nil || obj.Kind != FuncObject {
+		return invalid
+	}
+	c.resolve(obj)
+	sig, ok := obj.Type.(*types.Function)
+	if !ok || len(sig.Params) != 1 || !types.Identical(sig.Params[0].Type, typ) || sig.Result == nil {
+		return invalid
+	}
+	union, ok := sig.Result.Underlying().(*types.Union)
+	if !ok || len(union.Members) != 2 || !union.Contains(types.Typ[types.Nil]) {
+		return invalid
+	}
+	for _, m := range union.Members {
+		if step, ok := m.Underlying().(*types.Tuple); ok && len(step.Fields) == 2 && types.Identical(step.Fields[1].Type, typ) {
+			return step.Fields[0].Type
+		}
+	}
+	return invalid
+}
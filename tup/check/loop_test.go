@@ -0,0 +1,44 @@
+package check
+
+import "testing"
+
+const counter = "Counter = type(n: Int)\nPair = type(value: Int, rest: Counter)\nStep = Pair | Nil\n" +
+	"next = fn(c: Counter) Step {\n\tif c.n > 0 { Pair(c.n, Counter(c.n - 1)) } else { nil }\n}\nstart = Counter(3)\n"
+
+func TestFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"no initializer", "x = for i in [1, 2] { i }", "x", "Nil", ""},
+		{"infinite", "x = for {\n\tbreak\n}", "x", "Nil", ""},
+		{"infinite with break value", "x = for i = 0 {\n\tif !(i < 10) { break i }\n\ti + 1\n}", "x", "Int", ""},
+		{"break with different type", "x = for acc, i = (0, 1) {\n\tif i > 10 { break acc }\n\tnext = (acc + i, i + 1)\n\tnext\n}", "x", "Int", ""},
+		{"condition", "x = for i = 0; i < 10 { i + 1 }", "x", "Int", ""},
+		{"condition and break", "x = for i = 0; i < 10 {\n\tif i == 5 { break \"five\" }\n\ti + 1\n}", "x", "Int | String", ""},
+		{"tuple initializer", "x = for acc, i = (0, 1); i <= 10 {\n\t(acc + i, i + 1)\n}", "x", "(Int, Int)", ""},
+		{"step expression", "x = for acc, i = (0, 1); i <= 10; (acc + i, i + 1) {}", "x", "(Int, Int)", ""},
+		{"step replaces block value", "x = for i = 0; i < 10; i + 1 { \"ignored\" }", "x", "Int", ""},
+		{"for-in with initializer", "x = for acc = 0; i in [1, 2, 3] { acc + i }", "x", "Int", ""},
+		{"for-in with step", "x = for acc = 0; i in 1..3; acc + i {}", "x", "Int", ""},
+		{"zip", "a = [1, 2]\nb = [\"x\", \"y\"]\nx = for acc = 0; n, s in (a, b) { acc + n }", "x", "Int", ""},
+		{"string", "x = for acc = 0; r in \"abc\" { acc + 1 }", "x", "Int", ""},
+		{"iterator", counter + "x = for acc = 0; v in start { acc + v }", "x", "Int", ""},
+		{"no final expression", "x = for i = 0; i < 10 {}", "x", "", "block has no final expression"},
+		{"tuple shape", "x = for i = 0; i < 10 { (i, 1) }", "x", "", "tuple shape (Int, Int) does not match initializer (Int)"},
+		{"tuple arity", "x = for acc, i = (0, 1); i < 10 { i }", "x", "", "tuple shape (Int) does not match initializer (Int, Int)"},
+		{"step shape", "x = for acc, i = (0, 1); i < 10; (acc, i, 1) {}", "x", "", "tuple shape (Int, Int, Int) does not match initializer (Int, Int)"},
+		{"value type", "x = for i = 0; i < 10 { \"s\" }", "x", "", "cannot use String as Int in next iteration of loop"},
+		{"condition type", "x = for i = 0; i + 1 { i }", "x", "", "non-Bool Int used as loop condition"},
+		{"not iterable", "x = for i in 5 { i }", "x", "", "cannot iterate over Int"},
+		{"not an iterator", "Counter = type(n: Int)\nc = Counter(1)\nx = for i in c { i }", "x", "", "no function next(it: Counter) is declared"},
+		{"break outside loop", "x = break 1", "x", "", "break is not in a loop"},
+		{"continue outside loop", "f = fn() Int {\n\tcontinue\n\t1\n}", "f", "", "continue is not in a loop"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
@@ -0,0 +1,335 @@
+package check
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+)
+
+// metaFuncs maps the key naming each meta function to the other keys it
+// accepts.
+var metaFuncs = map[string][]string{
+	"file":    nil,
+	"embed":   nil,
+	"env":     nil,
+	"hash":    {"algorithm"},
+	"include": nil,
+}
+
+// hashAlgorithms are the algorithms accepted by $(hash: ..., algorithm: ...).
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+const defaultHashAlgorithm = "sha256"
+
+// moduleDir returns the directory holding the source files of module.
+// Meta functions may only read files within it.
+func moduleDir(module *ast.Module) string {
+	for _, src := range module.Sources {
+		return filepath.Dir(src.Filename)
+	}
+	for _, item := range module.TopLevelItems {
+		if pos := ast.PosOf(item); pos.Filename != "" {
+			return filepath.Dir(pos.Filename)
+		}
+	}
+	return "."
+}
+
+// expandMeta evaluates the meta expressions in items, replacing each with
+// the value it computes. Top-level includes are replaced by the items of
+// the included file.
+func (c *Checker) expandMeta(items []ast.TopLevelItem) []ast.TopLevelItem {
+	var expanded []ast.TopLevelItem
+	for _, item := range items {
+		switch item := item.(type) {
+		case *ast.MetaExpression:
+			expanded = append(expanded, c.include(item)...)
+		case *ast.ExportAssignment:
+			if decl := c.embed(&item.Assignment); decl != nil {
+				expanded = append(expanded, ast.NewExportFunctionDeclaration(decl))
+				continue
+			}
+			expanded = append(expanded, ast.Rewrite(item, c.expandMetaNode).(ast.TopLevelItem))
+		default:
+			expanded = append(expanded, ast.Rewrite(item, c.expandMetaNode).(ast.TopLevelItem))
+		}
+	}
+	return expanded
+}
+
+func (c *Checker) expandMetaNode(node ast.Node) ast.Node {
+	switch node := node.(type) {
+	case *ast.Assignment:
+		if decl := c.embed(node); decl != nil {
+			return decl
+		}
+	case *ast.MetaExpression:
+		if value := c.metaValue(node); value != nil {
+			return value
+		}
+	}
+	return node
+}
+
+// metaValue returns the literal computed by a meta expression used as a
+// value, or nil if it cannot be evaluated.
+func (c *Checker) metaValue(e *ast.MetaExpression) ast.Expression {
+	fn, ok := c.metaFunc(e)
+	if !ok {
+		return nil
+	}
+	switch fn {
+	case "file":
+		name, ok := c.metaString(e, fn)
+		if !ok {
+			return nil
+		}
+		contents, ok := c.readModuleFile(e, name)
+		if !ok {
+			return nil
+		}
+		return metaLiteral(e, string(contents))
+	case "env":
+		name, ok := c.metaString(e, fn)
+		if !ok {
+			return nil
+		}
+		value, found := os.LookupEnv(name)
+		if !found {
+			c.errorf(e, "environment variable %s is not set", name)
+			return nil
+		}
+		return metaLiteral(e, value)
+	case "hash":
+		data, ok := c.metaString(e, fn)
+		if !ok {
+			return nil
+		}
+		algorithm := defaultHashAlgorithm
+		if e.Lookup("algorithm") != nil {
+			if algorithm, ok = c.metaString(e, "algorithm"); !ok {
+				return nil
+			}
+		}
+		newHash, found := hashAlgorithms[algorithm]
+		if !found {
+			c.errorf(e, "unsupported hash algorithm %s", algorithm)
+			return nil
+		}
+		h := newHash()
+		h.Write([]byte(data))
+		return metaLiteral(e, hex.EncodeToString(h.Sum(nil)))
+	case "embed":
+		c.errorf(e, "embed must be assigned to a function name")
+	case "include":
+		c.errorf(e, "include is only allowed at the top level")
+	}
+	return nil
+}
+
+// embed returns the function declaration that replaces an assignment of
+// $(embed: ...) to a name, or nil if assignment is not one.
+func (c *Checker) embed(assignment *ast.Assignment) *ast.FunctionDeclaration {
+	e, ok := assignment.Right.(*ast.MetaExpression)
+	if !ok || e.Lookup("embed") == nil {
+		return nil
+	}
+	lhs, ok := assignment.Left.(*ast.OrdinalAssignmentLHS)
+	if !ok || assignment.Mut || len(lhs.Identifiers) != 1 || lhs.RestOperator != nil {
+		return nil
+	}
+	if fn, ok := c.metaFunc(e); !ok || fn != "embed" {
+		return nil
+	}
+	name, ok := c.metaString(e, "embed")
+	if !ok {
+		return nil
+	}
+	contents, ok := c.readModuleFile(e, name)
+	if !ok {
+		return nil
+	}
+
+	ident := lhs.Identifiers[0]
+	key := e.Args[0].Identifier
+	// fn() String { contents }
+	return ast.NewFunctionDeclaration(
+		nil,
+		ast.NewFunctionDeclarationLHS(ast.NewFunctionIdentifier(ident.Name, ident.Source, ident.StartOffset, ident.Length), nil),
+		ast.NewFunctionDeclarationType(false, nil, ast.NewReturnType(
+			ast.NewTypeReference(nil, ast.NewTypeIdentifier("String", key.Source, key.StartOffset, key.Length), key.Source, key.StartOffset, key.Length),
+		), false),
+		ast.NewBlock(ast.NewBlockBody(nil, metaLiteral(e, string(contents)))),
+	)
+}
+
+// include returns the items of the file included by a top-level meta
+// expression, with their own meta expressions expanded. The name of the
+// file is relative to the directory of the including file.
+func (c *Checker) include(e *ast.MetaExpression) []ast.TopLevelItem {
+	fn, ok := c.metaFunc(e)
+	if !ok {
+		return nil
+	}
+	if fn != "include" {
+		c.errorf(e, "%s evaluated but not used", e)
+		return nil
+	}
+	name, ok := c.metaString(e, fn)
+	if !ok {
+		return nil
+	}
+	if path := filepath.FromSlash(name); !filepath.IsAbs(path) && len(c.including) > 0 {
+		name = filepath.ToSlash(filepath.Join(filepath.Dir(c.including[len(c.including)-1]), path))
+	}
+	contents, ok := c.readModuleFile(e, name)
+	if !ok {
+		return nil
+	}
+	name = filepath.Clean(filepath.FromSlash(name))
+	if slices.Contains(c.including, name) {
+		c.errorf(e, "include cycle: %s includes itself", name)
+		return nil
+	}
+
+	src := source.NewSource(contents, filepath.Join(c.dir, name))
+	module, err := parse.Module(src, ast.NewModule(strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))))
+	if err != nil {
+		c.errorf(e, "cannot include %s: %s", name, strings.TrimPrefix(err.Error(), "error: "))
+		return nil
+	}
+
+	c.including = append(c.including, name)
+	defer func() { c.including = c.including[:len(c.including)-1] }()
+	return c.expandMeta(module.TopLevelItems)
+}
+
+// metaFunc returns the name of the meta function called by e, reporting
+// duplicate, unknown and conflicting keys. Each meta expression is
+// evaluated at most once, so that its errors are reported only once.
+func (c *Checker) metaFunc(e *ast.MetaExpression) (string, bool) {
+	if c.metaSeen[e] {
+		return "", false
+	}
+	c.metaSeen[e] = true
+
+	var fn string
+	seen := map[string]bool{}
+	for _, arg := range e.Args {
+		key := arg.Identifier.Name
+		if seen[key] {
+			c.errorf(arg, "duplicate key %s in meta expression", key)
+			return "", false
+		}
+		seen[key] = true
+		if _, ok := metaFuncs[key]; !ok {
+			continue
+		}
+		if fn != "" {
+			c.errorf(arg, "meta expression calls both %s and %s", fn, key)
+			return "", false
+		}
+		fn = key
+	}
+	for _, arg := range e.Args {
+		key := arg.Identifier.Name
+		if key == fn || slices.Contains(metaFuncs[fn], key) {
+			continue
+		}
+		switch {
+		case !isMetaOption(key):
+			c.errorf(arg, "unknown meta function %s", key)
+		case fn == "":
+			c.errorf(arg, "%s is given without a meta function", key)
+		default:
+			c.errorf(arg, "%s is not an option of %s", key, fn)
+		}
+		return "", false
+	}
+	return fn, true
+}
+
+func isMetaOption(key string) bool {
+	for _, options := range metaFuncs {
+		if slices.Contains(options, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// metaString returns the value of the argument labeled key, which must be
+// a compile-time constant string.
+func (c *Checker) metaString(e *ast.MetaExpression, key string) (string, bool) {
+	arg := e.Lookup(key)
+	if !arg.Spread {
+		switch lit := arg.Expr.(type) {
+		case *ast.StringLiteral:
+			return lit.StringValue, true
+		case *ast.RawStringLiteral:
+			return lit.StringValue, true
+		}
+	}
+	c.errorf(arg, "%s: %s is not a compile-time constant string", key, arg)
+	return "", false
+}
+
+// readModuleFile reads the named file, which must lie within the module
+// directory.
+func (c *Checker) readModuleFile(at ast.Node, name string) ([]byte, bool) {
+	path := filepath.FromSlash(name)
+	if !filepath.IsLocal(path) {
+		c.errorf(at, "%s is outside the module directory", name)
+		return nil, false
+	}
+	root, err := os.OpenRoot(c.dir)
+	if err != nil {
+		c.errorf(at, "cannot read %s: %v", name, err)
+		return nil, false
+	}
+	defer root.Close()
+
+	f, err := root.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.errorf(at, "file %s not found", name)
+		return nil, false
+	} else if err != nil {
+		c.errorf(at, "cannot read %s: %v", name, err)
+		return nil, false
+	}
+	defer f.Close()
+
+	contents, err := io.ReadAll(f)
+	if err != nil {
+		c.errorf(at, "cannot read %s: %v", name, err)
+		return nil, false
+	}
+	return contents, true
+}
+
+// metaLiteral returns a string literal holding value, positioned at the
+// first key of e.
+func metaLiteral(e *ast.MetaExpression, value string) *ast.StringLiteral {
+	key := e.Args[0].Identifier
+	return ast.NewStringLiteral(strconv.Quote(value), value, key.Source, key.StartOffset, key.Length)
+}
@@ -0,0 +1,158 @@
+package check
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+)
+
+// checkFiles writes files to a temporary module directory and checks
+// input as the module's main source file.
+func checkFiles(t *testing.T, files map[string]string, input string) (*Info, error) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("MkdirAll(%q) = %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatalf("WriteFile(%q) = %v", path, err)
+		}
+	}
+	filename := filepath.Join(dir, "test.tup")
+	if err := os.WriteFile(filename, []byte(input), 0o644); err != nil {
+		t.Fatalf("WriteFile(%q) = %v", filename, err)
+	}
+	src := source.NewSource([]byte(input), filename)
+	module := ast.NewModule("test")
+	module.AddSource(src)
+	if _, err := parse.Module(src, module); err != nil {
+		t.Fatalf("parse.Module(%q) = %v", input, err)
+	}
+	return Module(module)
+}
+
+func TestMeta(t *testing.T) {
+	t.Setenv("TUP_META_TEST", "tuppence")
+
+	files := map[string]string{
+		"config.json":    `{"key": "value"}`,
+		"config.tup":     "answer = 42\n",
+		"nested.tup":     "$(include: \"config.tup\")\nquestion = \"?\"\n",
+		"cycle.tup":      "$(include: \"cycle.tup\")\n",
+		"broken.tup":     "answer = \n",
+		"data/notes.txt": "notes",
+		"embedded.tup":   "get_config = $(embed: \"config.json\")\n",
+		"inner.tup":      "inner = \"top\"\n",
+		"lib/outer.tup":  "$(include: \"inner.tup\")\n",
+		"lib/inner.tup":  "inner = 1\n",
+		"lib/up.tup":     "$(include: \"../config.tup\")\n",
+		"lib/escape.tup": "$(include: \"../../config.tup\")\n",
+		"lib/self.tup":   "$(include: \"self.tup\")\n",
+	}
+
+	tests := []struct {
+		name      string
+		input     string
+		value     string
+		wantType  string
+		wantValue string
+		wantErr   string
+	}{
+		{"file", `data = $(file: "config.json")`, "data", "String", `{"key": "value"}`, ""},
+		{"file in subdirectory", `data = $(file: "data/notes.txt")`, "data", "String", "notes", ""},
+		{"raw string path", "data = $(file: `config.json`)", "data", "String", `{"key": "value"}`, ""},
+		{"file in function body", "f = fn() String { $(file: \"data/notes.txt\") }\nx = f()", "x", "String", "", ""},
+		{"embed", `get_config = $(embed: "config.json")`, "get_config", "fn() String", "", ""},
+		{"embed call", "get_config = $(embed: \"config.json\")\nx = get_config()", "x", "String", "", ""},
+		{"env", `home = $(env: "TUP_META_TEST")`, "home", "String", "tuppence", ""},
+		{"hash", `h = $(hash: "hello")`, "h", "String", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", ""},
+		{"hash with algorithm", `h = $(hash: "hello", algorithm: "sha256")`, "h", "String", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", ""},
+		{"hash with algorithm first", `h = $(algorithm: "md5", hash: "hello")`, "h", "String", "5d41402abc4b2a76b9719d911017c592", ""},
+		{"include", "$(include: \"config.tup\")\nx = answer + 1", "x", "Int", "", ""},
+		{"nested include", "$(include: \"nested.tup\")\nx = answer", "x", "Int", "", ""},
+		{"include with embed", "$(include: \"embedded.tup\")\nx = get_config()", "x", "String", "", ""},
+		{"include relative to includer", "$(include: \"lib/outer.tup\")\nx = inner", "x", "Int", "", ""},
+		{"include of parent directory", "$(include: \"lib/up.tup\")\nx = answer", "x", "Int", "", ""},
+
+		{"unknown key", `x = $(flie: "config.json")`, "x", "", "", "unknown meta function flie"},
+		{"duplicate key", `x = $(env: "HOME", env: "USER")`, "x", "", "", "duplicate key env in meta expression"},
+		{"two functions", `x = $(file: "config.json", env: "HOME")`, "x", "", "", "meta expression calls both file and env"},
+		{"option of another function", `x = $(file: "config.json", algorithm: "md5")`, "x", "", "", "algorithm is not an option of file"},
+		{"option without function", `x = $(algorithm: "md5")`, "x", "", "", "algorithm is given without a meta function"},
+		{"missing file", `x = $(file: "missing.json")`, "x", "", "", "file missing.json not found"},
+		{"missing include", `$(include: "missing.tup")`, "x", "", "", "file missing.tup not found"},
+		{"file outside module", `x = $(file: "../config.json")`, "x", "", "", "../config.json is outside the module directory"},
+		{"absolute path", `x = $(file: "/etc/passwd")`, "x", "", "", "/etc/passwd is outside the module directory"},
+		{"non-constant argument", "name = \"config.json\"\nx = $(file: name)", "x", "", "", "file: name is not a compile-time constant string"},
+		{"interpolated argument", "n = 1\nx = $(env: \"HOME\\(n)\")", "x", "", "", "is not a compile-time constant string"},
+		{"unset env", `x = $(env: "TUP_META_TEST_UNSET")`, "x", "", "", "environment variable TUP_META_TEST_UNSET is not set"},
+		{"unsupported algorithm", `x = $(hash: "hello", algorithm: "crc32")`, "x", "", "", "unsupported hash algorithm crc32"},
+		{"include as value", `x = $(include: "config.tup")`, "x", "", "", "include is only allowed at the top level"},
+		{"embed as argument", "f = fn(s: String) String { s }\nx = f($(embed: \"config.json\"))", "x", "", "", "embed must be assigned to a function name"},
+		{"unused top-level value", `$(env: "HOME")`, "x", "", "", `$(env: "HOME") evaluated but not used`},
+		{"include cycle", `$(include: "cycle.tup")`, "x", "", "", "include cycle: cycle.tup includes itself"},
+		{"include cycle in subdirectory", `$(include: "lib/self.tup")`, "x", "", "", "include cycle: lib/self.tup includes itself"},
+		{"include outside module", `$(include: "lib/escape.tup")`, "x", "", "", "../config.tup is outside the module directory"},
+		{"include of main file", `$(include: "test.tup")`, "x", "", "", "include cycle: test.tup includes itself"},
+		{"include with parse error", `$(include: "broken.tup")`, "x", "", "", "cannot include broken.tup"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := checkFiles(t, files, tt.input)
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("Module(%q) = nil, want error containing %q", tt.input, tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Module(%q) = %v, want error containing %q", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Module(%q) = %v, want nil", tt.input, err)
+			}
+			obj := info.Scope.Lookup(tt.value)
+			if obj == nil {
+				t.Fatalf("Module(%q): %s not declared", tt.input, tt.value)
+			}
+			if got := obj.Type.String(); got != tt.wantType {
+				t.Errorf("Module(%q): type of %s = %s, want %s", tt.input, tt.value, got, tt.wantType)
+			}
+			if tt.wantValue == "" {
+				return
+			}
+			assignment, ok := obj.Decl.(*ast.Assignment)
+			if !ok {
+				t.Fatalf("Module(%q): %s declared by %T, want *ast.Assignment", tt.input, tt.value, obj.Decl)
+			}
+			lit, ok := assignment.Right.(*ast.StringLiteral)
+			if !ok {
+				t.Fatalf("Module(%q): %s = %T, want *ast.StringLiteral", tt.input, tt.value, assignment.Right)
+			}
+			if lit.StringValue != tt.wantValue {
+				t.Errorf("Module(%q): %s = %q, want %q", tt.input, tt.value, lit.StringValue, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestMetaErrorPosition(t *testing.T) {
+	_, err := checkFiles(t, nil, "x = 1\ny = $(file: \"missing.txt\")")
+	if err == nil {
+		t.Fatal("Module() = nil, want error")
+	}
+	if want := "error: file missing.txt not found\n--> "; !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("Module() = %q, want prefix %q", err, want)
+	}
+	if want := "test.tup:2:7"; !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("Module() = %q, want suffix %q", err, want)
+	}
+}
@@ -0,0 +1,91 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// declNode returns the node to point at when referring to the declaration
+// of obj, or nil if it has none, as for parameters.
+func (obj *Object) declNode() ast.Node {
+	if obj.ident != nil {
+		return obj.ident
+	}
+	return obj.Decl
+}
+
+// noteDecl attaches a note pointing at the declaration of obj to err.
+func noteDecl(err *Error, obj *Object, format string) {
+	if node := obj.declNode(); node != nil {
+		err.note(node, format, obj.Name)
+	}
+}
+
+// pure reports whether the function whose body is being checked is an fn,
+// whose body may not have effects.
+func (c *Checker) pure() bool {
+	return c.fn != nil && !c.fn.sig.HasSideEffects
+}
+
+// local reports whether obj is declared within the function whose body is
+// being checked, including its parameters.
+func (c *Checker) local(obj *Object) bool {
+	for s := c.scope; s != nil && s != c.fn.scope; s = s.parent {
+		if s.objects[obj.Name] == obj {
+			return true
+		}
+	}
+	return false
+}
+
+// mutBinding reports a mut binding where effects are not allowed.
+func (c *Checker) mutBinding(assignment *ast.Assignment) {
+	if c.pure() {
+		err := c.errorf(assignment, "mut binding in fn %s: mutable state is an effect; declare the function with fx", c.fn.decl.LHS.Name.Name)
+		err.note(c.fn.decl.LHS.Name, "%s is declared here", c.fn.decl.LHS.Name.Name)
+	}
+}
+
+// write checks an assignment to obj at node, reporting false if it is not
+// allowed. Only variables bound with mut may be assigned to, and in an fn
+// only if they are declared within it.
+func (c *Checker) write(node ast.Node, obj *Object) bool {
+	switch {
+	case obj.Kind != VarObject:
+		c.errorf(node, "cannot assign to %s: it is not a variable", obj.Name)
+	case obj.loop:
+		err := c.errorf(node, "cannot assign to loop variable %s: it is rebound at each iteration", obj.Name)
+		noteDecl(err, obj, "%s is bound here")
+	case !obj.Mutable:
+		err := c.errorf(node, "cannot assign to immutable %s", obj.Name)
+		noteDecl(err, obj, "%s is bound here without mut")
+	case c.pure() && !c.local(obj):
+		err := c.errorf(node, "cannot assign to %s in fn %s: it is declared outside the function", obj.Name, c.fn.decl.LHS.Name.Name)
+		noteDecl(err, obj, "%s is bound here")
+	default:
+		return true
+	}
+	return false
+}
+
+// paramObject returns the parameter named name of the function whose body is
+// being checked if the current scope is the outermost scope of the body,
+// where an assignment to the name rebinds the parameter rather than
+// shadowing it, and nil otherwise.
+func (c *Checker) paramObject(name string) *Object {
+	if c.fn == nil || c.fn.params == nil || c.scope.parent != c.fn.params {
+		return nil
+	}
+	return c.fn.params.LookupLocal(name)
+}
+
+// rebind checks an assignment to a variable already bound in the same
+// scope, which writes to it if it is mutable.
+func (c *Checker) rebind(ident *ast.Identifier, obj *Object, typ types.Type) {
+	if !c.write(ident, obj) {
+		return
+	}
+	if !types.IsInvalid(typ) && !types.IsInvalid(obj.Type) && !c.assignable(typ, obj.Type) {
+		c.errorf(ident, "cannot use %s as %s in assignment to %s", typ, obj.Type, obj.Name)
+	}
+}
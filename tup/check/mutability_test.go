@@ -0,0 +1,48 @@
+package check
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMutability(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"mutable reassignment", "f = fx(a: Int) Int {\n\tx = mut a\n\tx = x + 1\n\tx\n}", "f", "fx(a: Int) Int", ""},
+		{"mutable compound assignment", "f = fx(a: Int) Int {\n\tx = mut a\n\tx += 1\n\tx\n}", "f", "fx(a: Int) Int", ""},
+		{"shadowing in a nested block", "f = fx(a: Int) Int {\n\tx = a\n\tif a > 0 {\n\t\tx = 1\n\t\tx\n\t} else {\n\t\tx\n\t}\n}", "f", "fx(a: Int) Int", ""},
+		{"loop accumulator", "f = fn(n: Int) Int {\n\tfor i = 0; i < n; i + 1 {\n\t\ti\n\t}\n\tn\n}", "f", "fn(n: Int) Int", ""},
+		{"immutable reassignment", "f = fx(a: Int) Int {\n\tx = a\n\tx = 2\n\tx\n}", "f", "", "cannot assign to immutable x"},
+		{"immutable compound assignment", "f = fx(a: Int) Int {\n\tx = a\n\tx <<= 1\n\tx\n}", "f", "", "cannot assign to immutable x"},
+		{"parameter", "f = fx(a: Int) Int {\n\ta += 1\n\ta\n}", "f", "", "cannot assign to immutable a"},
+		{"parameter reassignment", "f = fx(a: Int) Int {\n\ta = 2\n\ta\n}", "f", "", "cannot assign to immutable a"},
+		{"parameter shadowed in a nested block", "f = fx(a: Int) Int {\n\tif a > 0 {\n\t\ta = 1\n\t\ta\n\t} else {\n\t\ta\n\t}\n}", "f", "fx(a: Int) Int", ""},
+		{"function", "g = fn() Int { 1 }\nf = fx(a: Int) Int {\n\tg += 1\n\ta\n}", "f", "", "cannot assign to g: it is not a variable"},
+		{"mismatched reassignment", "f = fx(a: Int) Int {\n\tx = mut a\n\tx = \"s\"\n\tx\n}", "f", "", "cannot use String as Int in assignment to x"},
+		{"mut in fn", "f = fn(a: Int) Int {\n\tx = mut a\n\tx\n}", "f", "", "mut binding in fn f"},
+		{"loop variable", "f = fx(n: Int) Int {\n\tfor i = 0; i < n; i + 1 {\n\t\ti += 1\n\t}\n\tn\n}", "f", "", "cannot assign to loop variable i"},
+		{"for-in variable", "f = fx(a: [3]Int) Int {\n\tfor x in a {\n\t\tx += 1\n\t}\n\t0\n}", "f", "", "cannot assign to loop variable x"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+func TestMutabilityNotes(t *testing.T) {
+	input := "f = fx(a: Int) Int {\n\tx = a\n\tx = 2\n\tx\n}"
+	_, err := checkSource(t, input)
+	if err == nil {
+		t.Fatalf("Module(%q) succeeded, want error", input)
+	}
+	msg := err.Error()
+	for _, want := range []string{"cannot assign to immutable x", "note: x is bound here without mut", "test.tup:2:2"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Module(%q) = %q, want it to contain %q", input, msg, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Pipeline describes a chained expression x |> f(a) |> g(b), which passes
+// the value of each stage to the call of the next.
+type Pipeline struct {
+	// Stages holds the call each stage is lowered to, whose arguments
+	// include the value of the previous stage.
+	Stages []*ast.FunctionCall
+	// Lowered is an expression equivalent to the pipeline: the nested
+	// calls g(f(x, a), b). If the pipeline is the operand of a try
+	// expression, the try is distributed to the initial value and to the
+	// result of each stage, as in try g(try f(try x, a), b).
+	Lowered ast.Expression
+}
+
+// pipeline checks a chained expression by checking the calls it is lowered
+// to. The value piped into each stage is passed as its first argument,
+// unless the stage names the first parameter with a labeled argument, in
+// which case it is passed by label to the first parameter the stage does
+// not name. If try is not nil, the pipeline is its operand.
+func (c *Checker) pipeline(e *ast.ChainedExpression, try *ast.TryExpression) types.Type {
+	initial, ok := e.Initial.(ast.Expression)
+	if !ok {
+		return types.Typ[types.Invalid]
+	}
+	distribute := func(expr ast.Expression) ast.Expression {
+		if try == nil {
+			return expr
+		}
+		tried := ast.NewTryExpression(try.Variant, expr)
+		tried.BaseNode = try.BaseNode
+		return tried
+	}
+
+	p := &Pipeline{}
+	value := distribute(initial)
+	for _, stage := range e.FunctionCalls {
+		call := c.pipeStage(stage, value)
+		p.Stages = append(p.Stages, call)
+		value = distribute(call)
+	}
+	p.Lowered = value
+	typ := c.expr(value)
+	for i, stage := range e.FunctionCalls {
+		c.record(stage, c.info.Types[p.Stages[i]])
+	}
+	c.info.Pipelines[e] = p
+	return typ
+}
+
+// pipeStage returns the call a stage of a pipeline is lowered to, which
+// passes value to the call written. The call keeps the position of the
+// stage, so errors in it point at the stage.
+func (c *Checker) pipeStage(stage *ast.FunctionCall, value ast.Expression) *ast.FunctionCall {
+	arg := ast.NewArgument(value, false)
+	c.piped[arg] = stage
+
+	var positional []*ast.Argument
+	var labeled []*ast.LabeledArgument
+	partial := false
+	if args := stage.Arguments; args != nil {
+		if args.Args != nil {
+			positional = args.Args.Args
+		}
+		if args.LabeledArgs != nil {
+			labeled = args.LabeledArgs.Args
+		}
+		partial = args.PartialApplication
+	}
+	if name := c.pipeLabel(stage, labeled); name != "" {
+		label := ast.NewLabeledArgument(ast.NewIdentifier(name, nil, 0, 0), arg)
+		labeled = append([]*ast.LabeledArgument{label}, labeled...)
+	} else {
+		positional = append([]*ast.Argument{arg}, positional...)
+	}
+
+	var positionalArgs *ast.Arguments
+	if len(positional) > 0 {
+		positionalArgs = ast.NewArguments(positional)
+	}
+	var labeledArgs *ast.LabeledArguments
+	if len(labeled) > 0 {
+		labeledArgs = ast.NewLabeledArguments(labeled)
+	}
+	args := ast.NewFunctionArguments(positionalArgs, labeledArgs, partial)
+	call := ast.NewFunctionCall(stage.Function, stage.ParameterTypes, args, stage.FunctionBlock)
+	call.BaseNode = stage.BaseNode
+	return call
+}
+
+// pipeLabel returns the name of the parameter that receives the value
+// piped into stage if the stage names the parameter that would otherwise
+// receive it, or "" if the value is passed as the first argument.
+func (c *Checker) pipeLabel(stage *ast.FunctionCall, labeled []*ast.LabeledArgument) string {
+	if len(labeled) == 0 {
+		return ""
+	}
+	first := 0
+	var fn types.Type
+	switch callee := stage.Function.(type) {
+	case *ast.Identifier:
+		fn = c.callee(callee.Name)
+	case *ast.FunctionIdentifier:
+		fn = c.callee(callee.Name)
+	case *ast.MemberAccess:
+		// the receiver of a uniform function call is the first argument
+		if ident, ok := callee.Member.(*ast.Identifier); ok {
+			fn = c.callee(ident.Name)
+			first = 1
+		}
+	}
+	sig, ok := fn.(*types.Function)
+	if !ok {
+		return ""
+	}
+	named := map[string]bool{}
+	for _, arg := range labeled {
+		named[arg.Identifier.Name] = true
+	}
+	for i := first; i < len(sig.Params); i++ {
+		if !named[sig.Params[i].Name] {
+			if i == first {
+				return ""
+			}
+			return sig.Params[i].Name
+		}
+	}
+	return ""
+}
+
+// pipedArg checks the type of the value piped into stage, which is bound
+// to the p'th parameter of sig.
+func (c *Checker) pipedArg(stage *ast.FunctionCall, arg *ast.Argument, sig *types.Function, p int) {
+	want := sig.Params[p].Type
+	if p == sig.Rest {
+		if array, ok := want.Underlying().(*types.Array); ok {
+			want = array.Elem
+		}
+	}
+	typ := c.info.Types[arg.Expr]
+	if typ == nil || types.IsGeneric(want) || c.assignable(typ, want) {
+		return
+	}
+	c.errorf(stage, "cannot pipe %s into %s: it expects %s", typ, stage, want)
+}
@@ -0,0 +1,75 @@
+package check
+
+import (
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+)
+
+const pipeFuncs = callFuncs +
+	"greet = fn(s: String) String { s }\n" +
+	"label = fn(n: Int, s: String) String { s }\n" +
+	"ParseError = error(message: String)\n" +
+	"Parsed = Int | ParseError\n" +
+	"parse_int = fn(s: String) Parsed { 1 }\n"
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"single stage", "x = 3 |> double()", "x", "Int", ""},
+		{"first argument", "x = 3 |> add(4)", "x", "Int", ""},
+		{"stages", "x = 3 |> double() |> add(1) |> label(\"s\")", "x", "String", ""},
+		{"labeled", "x = \"s\" |> label(n: 1)", "x", "String", ""},
+		{"partial", "x = 1 |> process_args(2, *)", "x", "fn(transform: fn(Int) Int) Int", ""},
+		{"trailing block", "x = 3 |> apply() { it * 2 }", "x", "Int", ""},
+		{"uniform call", "n = 1\nx = 3 |> n.add()", "x", "Int", ""},
+		{"try", "x = try \"1\" |> parse_int() |> double()", "x", "Int", ""},
+		{"argument type", "x = \"s\" |> double()", "x", "", "cannot pipe String into double(): it expects Int"},
+		{"later stage", "x = 3 |> double() |> greet()", "x", "", "cannot pipe Int into greet(): it expects String"},
+		{"error member", "x = \"1\" |> parse_int() |> double()", "x", "", "cannot pipe Parsed into double(): it expects Int"},
+		{"too many arguments", "x = 3 |> add(1, 2)", "x", "", "too many arguments in call to add"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, pipeFuncs+tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+func TestPipelineLowered(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"stages", "x = 3 |> double() |> add(1)", "add(double(3), 1)"},
+		{"labeled", "x = \"s\" |> label(n: 1)", "label(s: \"s\", n: 1)"},
+		{"try", "x = try \"1\" |> parse_int() |> double()", "try double(try parse_int(try \"1\"))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := pipeFuncs + tt.input
+			info, err := checkSource(t, input)
+			if err != nil {
+				t.Fatalf("Module(%q) = %v", input, err)
+			}
+			var chain *ast.ChainedExpression
+			ast.Inspect(info.Scope.Lookup("x").Decl, func(n ast.Node) bool {
+				if e, ok := n.(*ast.ChainedExpression); ok {
+					chain = e
+				}
+				return chain == nil
+			})
+			p := info.Pipelines[chain]
+			if p == nil {
+				t.Fatalf("Pipelines[%s] = nil", chain)
+			}
+			if got := p.Lowered.String(); got != tt.want {
+				t.Errorf("Lowered = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package check
+
+import (
+	"sort"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// ObjectKind describes what a name in a scope refers to.
+type ObjectKind int
+
+const (
+	VarObject  ObjectKind = iota // a value binding
+	FuncObject                   // a function declaration
+	TypeObject                   // a type declaration
+)
+
+type objectState int
+
+const (
+	unresolved objectState = iota
+	resolving
+	resolved
+)
+
+// Object is a named entity declared in a scope.
+type Object struct {
+	Kind    ObjectKind
+	Name    string
+	Type    types.Type
+	Decl    ast.Node // the declaring node; nil for predeclared objects
+	Mutable bool
+	// Const holds the value of an immutable binding known at compile
+	// time, such as the field name bound by an inline for loop.
+	Const consteval.Value
+	// Overloads holds the later declarations of an overloaded top-level
+	// function, which are distinguished by their parameter types.
+	Overloads []*Object
+
+	ident ast.Node // the identifier that declared the object, if any
+	loop  bool     // bound by the header of a for loop
+	state objectState
+}
+
+// Scope maps names to objects. Scopes nest: lookups that fail in a scope
+// continue in its parent.
+type Scope struct {
+	parent  *Scope
+	objects map[string]*Object
+}
+
+// NewScope returns a new, empty scope nested in parent.
+func NewScope(parent *Scope) *Scope {
+	return &Scope{parent: parent, objects: map[string]*Object{}}
+}
+
+// Parent returns the enclosing scope, or nil for the universe scope.
+func (s *Scope) Parent() *Scope { return s.parent }
+
+// Lookup returns the object with the given name in s or its parents,
+// or nil if there is none.
+func (s *Scope) Lookup(name string) *Object {
+	for ; s != nil; s = s.parent {
+		if obj, ok := s.objects[name]; ok {
+			return obj
+		}
+	}
+	return nil
+}
+
+// LookupLocal returns the object with the given name declared directly
+// in s, or nil if there is none.
+func (s *Scope) LookupLocal(name string) *Object {
+	return s.objects[name]
+}
+
+// Names returns the sorted names of the objects declared directly in s.
+func (s *Scope) Names() []string {
+	names := make([]string, 0, len(s.objects))
+	for name := range s.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Insert declares obj in s, replacing any object with the same name.
+func (s *Scope) Insert(obj *Object) {
+	s.objects[obj.Name] = obj
+}
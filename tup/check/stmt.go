@@ -0,0 +1,387 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// lhsIdentifiers returns the identifiers bound by the left-hand side of
+// an assignment, excluding the placeholder _.
+func lhsIdentifiers(lhs ast.AssignmentLHS) []*ast.Identifier {
+	var idents []*ast.Identifier
+	add := func(ident *ast.Identifier) {
+		if ident != nil && ident.Name != "_" {
+			idents = append(idents, ident)
+		}
+	}
+	switch lhs := lhs.(type) {
+	case *ast.OrdinalAssignmentLHS:
+		for _, ident := range lhs.Identifiers {
+			add(ident)
+		}
+		if lhs.RestOperator != nil {
+			add(lhs.RestOperator.Identifier)
+		}
+	case *ast.LabeledAssignmentLHS:
+		for _, rename := range lhs.Renames {
+			if rename, ok := rename.(*ast.RenameIdentifier); ok {
+				add(rename.Identifier)
+			}
+		}
+	}
+	return idents
+}
+
+// lhsTypeNames returns the type names bound by a labeled left-hand side,
+// as in (List, empty) = import("list").
+func lhsTypeNames(lhs ast.AssignmentLHS) []*ast.TypeIdentifier {
+	var idents []*ast.TypeIdentifier
+	if lhs, ok := lhs.(*ast.LabeledAssignmentLHS); ok {
+		for _, rename := range lhs.Renames {
+			if rename, ok := rename.(*ast.RenameType); ok {
+				idents = append(idents, rename.Identifier)
+			}
+		}
+	}
+	return idents
+}
+
+func (c *Checker) lhsObjects(lhs ast.AssignmentLHS) []*Object {
+	var objs []*Object
+	for _, ident := range lhsIdentifiers(lhs) {
+		if obj := c.scope.LookupLocal(ident.Name); obj != nil {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// bindLHS destructures a value of type typ according to lhs and calls bind
+// for each identifier with the type of the part it receives.
+func (c *Checker) bindLHS(lhs ast.AssignmentLHS, typ types.Type, mut bool, bind func(*ast.Identifier, types.Type)) {
+	invalid := types.Typ[types.Invalid]
+	bindOne := func(ident *ast.Identifier, typ types.Type) {
+		if ident != nil && ident.Name != "_" {
+			bind(ident, types.Default(typ))
+		}
+	}
+
+	switch lhs := lhs.(type) {
+	case *ast.OrdinalAssignmentLHS:
+		if len(lhs.Identifiers) == 1 && lhs.RestOperator == nil {
+			bindOne(lhs.Identifiers[0], typ)
+			return
+		}
+		if array, ok := typ.Underlying().(*types.Array); ok {
+			n := int64(len(lhs.Identifiers))
+			if array.Len >= 0 && (n > array.Len || lhs.RestOperator == nil && n != array.Len) {
+				c.errorf(lhs, "assignment mismatch: %d variables but %s has %d elements", n, typ, array.Len)
+			}
+			for _, ident := range lhs.Identifiers {
+				bindOne(ident, array.Elem)
+			}
+			if lhs.RestOperator != nil && lhs.RestOperator.Identifier != nil {
+				bindOne(lhs.RestOperator.Identifier, types.NewArray(array.Elem))
+			}
+			return
+		}
+		tuple, _ := typ.Underlying().(*types.Tuple)
+		if tuple == nil {
+			if !types.IsInvalid(typ) {
+				c.errorf(lhs, "cannot destructure %s: not a tuple", typ)
+			}
+			for _, ident := range lhsIdentifiers(lhs) {
+				bindOne(ident, invalid)
+			}
+			return
+		}
+		n := len(lhs.Identifiers)
+		if len(tuple.Fields) < n || lhs.RestOperator == nil && len(tuple.Fields) != n {
+			c.errorf(lhs, "assignment mismatch: %d variables but %s has %d fields", n, typ, len(tuple.Fields))
+		}
+		for i, ident := range lhs.Identifiers {
+			if i < len(tuple.Fields) {
+				bindOne(ident, tuple.Fields[i].Type)
+			} else {
+				bindOne(ident, invalid)
+			}
+		}
+		if lhs.RestOperator != nil && lhs.RestOperator.Identifier != nil {
+			var rest []*types.Field
+			if n < len(tuple.Fields) {
+				rest = tuple.Fields[n:]
+			}
+			bindOne(lhs.RestOperator.Identifier, types.NewTuple(rest...))
+		}
+	case *ast.LabeledAssignmentLHS:
+		tuple, _ := typ.Underlying().(*types.Tuple)
+		if tuple == nil && !types.IsInvalid(typ) {
+			c.errorf(lhs, "cannot destructure %s: not a tuple", typ)
+		}
+		for i, rename := range lhs.Renames {
+			rename, ok := rename.(*ast.RenameIdentifier)
+			if !ok {
+				continue
+			}
+			if tuple == nil {
+				bindOne(rename.Identifier, invalid)
+				continue
+			}
+			name := rename.Identifier.Name
+			if rename.Original != nil {
+				name = rename.Original.Name
+			}
+			index := tuple.FieldIndex(name)
+			if !tuple.Labeled() && index < 0 {
+				// unlabeled tuples are destructured by position
+				index = i
+			}
+			if index < 0 || index >= len(tuple.Fields) {
+				c.errorf(rename, "%s has no field %s", typ, name)
+				bindOne(rename.Identifier, invalid)
+				continue
+			}
+			bindOne(rename.Identifier, tuple.Fields[index].Type)
+		}
+	}
+}
+
+// block checks a block in a new scope and returns the type of its final
+// expression, or Nil if it has none.
+func (c *Checker) block(block *ast.Block) types.Type {
+	if block == nil {
+		return types.Typ[types.Nil]
+	}
+	typ := c.blockBody(block.Body)
+	c.record(block, typ)
+	return typ
+}
+
+func (c *Checker) blockBody(body *ast.BlockBody) types.Type {
+	c.openScope()
+	defer c.closeScope()
+	if body == nil {
+		return types.Typ[types.Nil]
+	}
+	return c.statements(body.Statements, body.Expression)
+}
+
+// statements checks a statement list followed by an optional final
+// expression in the current scope.
+func (c *Checker) statements(statements []ast.Statement, final ast.Expression) types.Type {
+	for _, stmt := range statements {
+		c.stmt(stmt)
+	}
+	if final == nil {
+		return types.Typ[types.Nil]
+	}
+	return c.expr(final)
+}
+
+func (c *Checker) stmt(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.Assignment:
+		c.assignment(stmt)
+	case *ast.CompoundAssignment:
+		c.compoundAssignment(stmt)
+	case *ast.FunctionDeclaration:
+		c.declare(&Object{Kind: FuncObject, Name: stmt.LHS.Name.Name, Decl: stmt}, stmt.LHS.Name)
+		c.funcDecl(stmt)
+	case *ast.TypeDeclaration:
+		named := types.NewNamed(stmt.LHS.Name.Name, nil, declAnnotations(stmt)...)
+		c.scope.Insert(&Object{Kind: TypeObject, Name: named.Name(), Type: named, Decl: stmt, state: resolved})
+		c.typeDecl(named, stmt)
+	case *ast.TypeQualifiedDeclaration, *ast.TypeQualifiedFunctionDeclaration:
+		// not yet supported
+	case ast.Expression:
+		c.discarded(stmt, c.expr(stmt))
+	}
+}
+
+// assignment binds the names on the left-hand side of an assignment in the
+// current scope. Names already bound in the same scope are written to, which
+// requires them to be mutable; names bound in enclosing scopes are shadowed.
+func (c *Checker) assignment(assignment *ast.Assignment) {
+	typ := c.expr(assignment.Right)
+	if assignment.Mut {
+		c.mutBinding(assignment)
+	}
+	for _, ident := range lhsTypeNames(assignment.Left) {
+		// types imported from other modules are not yet resolved
+		c.scope.Insert(&Object{Kind: TypeObject, Name: ident.Name, Type: types.Typ[types.Invalid], Decl: assignment, state: resolved})
+	}
+	c.bindLHS(assignment.Left, typ, assignment.Mut, func(ident *ast.Identifier, typ types.Type) {
+		if prev := c.scope.LookupLocal(ident.Name); prev != nil && prev.Decl != nil {
+			c.rebind(ident, prev, typ)
+			return
+		}
+		if param := c.paramObject(ident.Name); param != nil {
+			c.rebind(ident, param, typ)
+			return
+		}
+		c.scope.Insert(&Object{Kind: VarObject, Name: ident.Name, Type: typ, Decl: assignment, Mutable: assignment.Mut, ident: ident, state: resolved})
+	})
+	c.constAssignment(assignment)
+}
+
+func (c *Checker) compoundAssignment(assignment *ast.CompoundAssignment) {
+	right := c.expr(assignment.Right)
+	obj := c.lookup(assignment.Left, assignment.Left.Name)
+	if obj == nil {
+		return
+	}
+	c.record(assignment.Left, obj.Type)
+	if !c.write(assignment.Left, obj) {
+		return
+	}
+	if types.IsInvalid(obj.Type) || types.IsInvalid(right) {
+		return
+	}
+	if !c.assignable(right, obj.Type) {
+		c.errorf(assignment, "mismatched types %s and %s in %s", obj.Type, right, assignment.Operator)
+	}
+}
+
+// funcDecl resolves the signature of a function declaration and queues its
+// body to be checked.
+func (c *Checker) funcDecl(decl *ast.FunctionDeclaration) {
+	var sig *types.Function
+	if obj := c.scope.LookupLocal(decl.LHS.Name.Name); obj != nil {
+		for _, obj := range append([]*Object{obj}, obj.Overloads...) {
+			if obj.Decl == decl {
+				c.resolve(obj)
+				sig, _ = obj.Type.(*types.Function)
+			}
+		}
+	}
+	if sig == nil {
+		sig = c.signature(decl.Type)
+		typeParams(decl, sig)
+	}
+	c.record(decl, sig)
+	body := c.bodies[decl]
+	if body == nil {
+		body = c.newFuncBody(decl, sig, c.scope)
+	}
+	c.funcs = append(c.funcs, body)
+}
+
+// newFuncBody returns the body of the function declared by decl in scope,
+// whose type is sig.
+func (c *Checker) newFuncBody(decl *ast.FunctionDeclaration, sig *types.Function, scope *Scope) *funcBody {
+	body := &funcBody{decl: decl, sig: sig, scope: scope}
+	body.infer = isFallible(decl.Type) && sig.Result != nil
+	c.bodies[decl] = body
+	return body
+}
+
+// signature returns the function type declared by typ.
+func (c *Checker) signature(typ *ast.FunctionDeclarationType) *types.Function {
+	if typ == nil {
+		return types.NewFunction(nil, nil, false)
+	}
+	var result types.Type
+	if returnType, ok := typ.ReturnType.(*ast.ReturnType); ok && returnType != nil && returnType.Type != nil {
+		result = c.typExpr(returnType.Type)
+	}
+	return c.funcType(typ.Parameters, result, typ.HasSideEffects)
+}
+
+// funcType returns the function type with the given parameters, noting
+// the index of its rest parameter. A function may have at most one rest
+// parameter, which must be the last parameter or be followed by a single
+// callable parameter, since the final argument of a call may be supplied
+// by a trailing block.
+func (c *Checker) funcType(params []ast.FunctionTypeParameter, result types.Type, hasSideEffects bool) *types.Function {
+	sig := types.NewFunction(nil, result, hasSideEffects)
+	for i, param := range params {
+		switch param.(type) {
+		case *ast.RestParameter, *ast.LabeledRestParameter:
+			if sig.Rest >= 0 {
+				c.errorf(param, "a function may have at most one rest parameter")
+				break
+			}
+			sig.Rest = i
+		}
+		sig.Params = append(sig.Params, c.param(param))
+	}
+	if n := len(sig.Params); sig.Rest >= 0 && sig.Rest < n-1 {
+		if sig.Rest < n-2 || !isCallable(sig.Params[n-1].Type) {
+			c.errorf(params[sig.Rest], "a rest parameter must be the last parameter or be followed by a single callable parameter")
+		}
+	}
+	return sig
+}
+
+func (c *Checker) param(param ast.FunctionTypeParameter) *types.Field {
+	switch param := param.(type) {
+	case *ast.Parameter:
+		return types.NewField("", c.paramType(param.Type))
+	case *ast.LabeledParameter:
+		return types.NewField(param.Identifier.Name, c.paramType(param.Type))
+	case *ast.RestParameter:
+		return types.NewField("", types.NewArray(c.typExpr(param.Type)))
+	case *ast.LabeledRestParameter:
+		return types.NewField(param.Identifier.Name, types.NewArray(c.typExpr(param.RestType.Type)))
+	}
+	return types.NewField("", types.Typ[types.Invalid])
+}
+
+// paramType returns the type of a parameter, which is either written as a
+// type or implied by a default value.
+func (c *Checker) paramType(typ ast.FunctionTypeParameterType) types.Type {
+	if expr, ok := typ.(ast.Literal); ok {
+		return types.Default(c.expr(expr))
+	}
+	return c.typExpr(typ)
+}
+
+// funcBody checks the body of a function. Bodies are checked after the
+// top-level declarations, except that the body of a function whose error
+// set is inferred is checked when the function is first referred to, so
+// that its result is known.
+func (c *Checker) funcBody(body *funcBody) {
+	saved, savedFn, savedLoops, savedFacts := c.scope, c.fn, c.loops, c.facts
+	c.scope = NewScope(body.scope)
+	c.fn, c.loops, c.facts = body, nil, nil
+	body.params = c.scope
+	body.state = resolving
+	defer func() {
+		c.scope, c.fn, c.loops, c.facts = saved, savedFn, savedLoops, savedFacts
+		body.state = resolved
+		if body.infer {
+			c.inferErrors(body)
+		}
+	}()
+
+	for _, param := range body.sig.Params {
+		if param.Name != "" {
+			c.scope.Insert(&Object{Kind: VarObject, Name: param.Name, Type: param.Type, state: resolved})
+		}
+	}
+	if body.decl.Body == nil || body.decl.Body.Body == nil {
+		return
+	}
+	typ := c.block(body.decl.Body)
+	c.returns(typ)
+	if body.sig.Result == nil {
+		if body.decl.Type != nil && body.decl.Type.InferredReturn {
+			body.sig.Result = types.Default(typ)
+		}
+		return
+	}
+	if body.decl.Body.Body.Expression == nil {
+		return
+	}
+	if !types.IsInvalid(typ) && !types.IsInvalid(body.sig.Result) && !c.assignable(typ, body.sig.Result) {
+		c.errorf(body.decl.Body.Body.Expression, "cannot use %s as %s in return value of %s", typ, body.sig.Result, body.decl.LHS.Name.Name)
+	}
+}
+
+func (c *Checker) openScope() {
+	c.scope = NewScope(c.scope)
+}
+
+func (c *Checker) closeScope() {
+	c.scope = c.scope.Parent()
+}
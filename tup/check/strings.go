@@ -0,0 +1,188 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// interpolatedString checks the interpolations of lit, each of whose
+// values must be convertible to a string. Errors about an interpolation
+// without a position, as in a literal built rather than parsed, are
+// reported at the node at instead.
+func (c *Checker) interpolatedString(lit *ast.InterpolatedStringLiteral, at ast.Node) {
+	if lit == nil {
+		return
+	}
+	for _, part := range lit.Parts {
+		interpolation, ok := part.(*ast.Interpolation)
+		if !ok {
+			continue
+		}
+		node := at
+		if interpolation.Source != nil {
+			node = interpolation
+		}
+		c.stringer(interpolation, c.expr(interpolation.Expression), node)
+	}
+	c.record(lit, types.Typ[types.String])
+}
+
+// stringer checks that a value of type typ, interpolated by interpolation,
+// has a string function, satisfying the Stringer contract. A declared
+// function string(typ) String is preferred, and recorded; otherwise the
+// values of basic and enum types, and of unions of them, are converted by
+// builtins.
+func (c *Checker) stringer(interpolation *ast.Interpolation, typ types.Type, at ast.Node) {
+	if types.IsInvalid(typ) || types.IsGeneric(typ) {
+		// the conversion of values of a type parameter is known only once
+		// the function is instantiated
+		return
+	}
+	if obj := c.stringFunc(typ); obj != nil {
+		sig := obj.Type.(*types.Function)
+		if sig.Result == nil || !types.IsString(sig.Result) && !types.IsGeneric(sig.Result) {
+			c.errorf(at, "cannot interpolate %s: string(%s) returns %s, not String", typ, sig.Params[0].Type, resultString(sig))
+			return
+		}
+		c.info.Stringers[interpolation] = obj
+		return
+	}
+	if !builtinStringer(typ) {
+		c.errorf(at, "cannot interpolate %s: no function string(%s) String is declared", typ, typ)
+	}
+}
+
+// stringFunc returns the declared string function, of those overloading
+// the name, that converts values of type typ: one taking typ itself, or
+// else one taking a type typ is assignable to, or else a generic one. It
+// returns nil if there is none.
+func (c *Checker) stringFunc(typ types.Type) *Object {
+	obj := c.scope.Lookup("string")
+	if obj == nil || obj.Kind != FuncObject {
+		return nil
+	}
+	var assignable, generic *Object
+	for _, obj := range append([]*Object{obj}, obj.Overloads...) {
+		c.resolve(obj)
+		sig, ok := obj.Type.(*types.Function)
+		if !ok || len(sig.Params) != 1 {
+			continue
+		}
+		param := sig.Params[0].Type
+		switch {
+		case types.IsGeneric(param):
+			if generic == nil {
+				generic = obj
+			}
+		case types.Identical(typ, param):
+			return obj
+		case assignable == nil && c.assignable(typ, param):
+			assignable = obj
+		}
+	}
+	if assignable != nil {
+		return assignable
+	}
+	return generic
+}
+
+// builtinStringer reports whether the values of typ are converted to
+// strings by a builtin.
+func builtinStringer(typ types.Type) bool {
+	switch t := typ.Underlying().(type) {
+	case *types.Basic, *types.Enum:
+		return true
+	case *types.Union:
+		for _, member := range t.Members {
+			if !builtinStringer(member) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func resultString(sig *types.Function) string {
+	if sig.Result == nil {
+		return "nothing"
+	}
+	return sig.Result.String()
+}
+
+// multiLineString checks a multi-line string literal, whose contents have
+// been dedented by the parser. If the literal names a processor, its
+// value is the result of calling the processor with the contents followed
+// by the arguments of the processor, as in mustache(contents, context).
+func (c *Checker) multiLineString(lit *ast.MultiLineStringLiteral) types.Type {
+	str := types.Typ[types.String]
+	invalid := types.Typ[types.Invalid]
+	c.interpolatedString(lit.Contents, lit)
+	processor := lit.Processor
+	if processor == nil {
+		return str
+	}
+	c.arguments(processor.Arguments)
+
+	ident, ok := processor.Function.(*ast.FunctionIdentifier)
+	if !ok {
+		scoped, ok := processor.Function.(*ast.ScopedFunctionIdentifier)
+		if !ok || len(scoped.Scope) == 0 {
+			// the parser gives processors no other form, but built
+			// literals may
+			c.errorf(processor, "the processor of a multi-line string must name a function")
+			return invalid
+		}
+		if module := scoped.Scope[0]; c.lookup(module, module.Name) != nil {
+			// qualified references into other modules are not yet resolved
+			c.errorf(processor, "cannot use %s as a processor: functions of other modules are not yet supported", scoped)
+		}
+		return invalid
+	}
+	fn := c.ident(ident, ident.Name)
+	if types.IsInvalid(fn) {
+		return invalid
+	}
+	sig, ok := fn.Underlying().(*types.Function)
+	if !ok {
+		c.errorf(ident, "cannot use %s as a processor: it has type %s, not a function type", ident.Name, fn)
+		return invalid
+	}
+
+	call := lowerProcessor(lit)
+	c.info.Processors[lit] = call
+	if len(sig.Params) == 0 || sig.Rest == 0 {
+		c.errorf(ident, "cannot use %s as a processor: its first parameter must take the String contents", ident.Name)
+	} else {
+		c.bindCall(call, nil, ident.Name, sig)
+	}
+
+	typ := types.Type(types.Typ[types.Nil])
+	if sig.Result != nil {
+		typ = sig.Result
+	}
+	c.record(call, typ)
+	return typ
+}
+
+// lowerProcessor returns the call a multi-line string literal with a
+// processor is lowered to, which passes the contents of the literal
+// before the arguments of the processor. The call keeps the position of
+// the processor, so errors in it point at the processor.
+func lowerProcessor(lit *ast.MultiLineStringLiteral) *ast.FunctionCall {
+	processor := lit.Processor
+	positional := []*ast.Argument{ast.NewArgument(lit.Contents, false)}
+	var labeled *ast.LabeledArguments
+	if args := processor.Arguments; args != nil {
+		if args.Args != nil {
+			positional = append(positional, args.Args.Args...)
+		}
+		labeled = args.LabeledArgs
+	}
+	callee := processor.Function.(*ast.FunctionIdentifier)
+	args := ast.NewFunctionArguments(ast.NewArguments(positional), labeled, false)
+	call := ast.NewFunctionCall(callee, nil, args, nil)
+	call.BaseNode = processor.BaseNode
+	call.BaseNode.Type = ast.NodeFunctionCall
+	return call
+}
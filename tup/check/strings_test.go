@@ -0,0 +1,117 @@
+package check
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+)
+
+const stringFuncs = "Point = type(x: Int, y: Int)\n" +
+	"Color = enum(\n\tred\n\tgreen\n)\n" +
+	"json = fn(text: String) Int { 1 }\n" +
+	"mustache = fn(template: String, context: Int) String { template }\n" +
+	"count = fn(n: Int) Int { n }\n"
+
+func TestInterpolation(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"basic values", "n = 1\nf = 1.5\nx = \"\\(n) \\(f) \\(true) \\(:sym)\"", "x", "String", ""},
+		{"enum", "c = Color.red\nx = \"\\(c)\"", "x", "String", ""},
+		{"union of basic types", "U = Int | Nil\nf = fn(u: U) String { \"\\(u)\" }", "f", "fn(u: U) String", ""},
+		{"declared string", "string = fn(p: Point) String { \"point\" }\np = Point(x: 1, y: 2)\nx = \"\\(p)\"", "x", "String", ""},
+		{"declared string keeps builtins", "string = fn(p: Point) String { \"point\" }\nx = \"\\(1)\"", "x", "String", ""},
+		{"generic string", "string[a] = fn(v: a) String { \"v\" }\np = Point(x: 1, y: 2)\nx = \"\\(p)\"", "x", "String", ""},
+		{"multi-line", "n = 1\nx = ```\n\tn = \\(n)\n```", "x", "String", ""},
+		{"no string function", "p = Point(x: 1, y: 2)\nx = \"\\(p)\"", "x", "", "cannot interpolate Point: no function string(Point) String is declared"},
+		{"string of another type", "string = fn(c: Color) String { \"c\" }\nx = \"\\([1, 2])\"", "x", "", "cannot interpolate []Int: no function string([]Int) String is declared"},
+		{"string not returning String", "string = fn(p: Point) Int { 1 }\np = Point(x: 1, y: 2)\nx = \"\\(p)\"", "x", "", "cannot interpolate Point: string(Point) returns Int, not String"},
+		{"multi-line without string function", "p = Point(x: 1, y: 2)\nx = ```\n\t\\(p)\n```", "x", "", "cannot interpolate Point"},
+		{"overloaded string", "Size = type(w: Int, h: Int)\nstring = fn(p: Point) String { \"point\" }\nstring = fn(s: Size) String { \"size\" }\ns = Size(w: 1, h: 2)\nx = \"\\(s)\"", "x", "String", ""},
+		{"overloaded string of another type", "Size = type(w: Int, h: Int)\nstring = fn(p: Point) String { \"point\" }\nstring = fn(s: Size) String { \"size\" }\nx = \"\\([1])\"", "x", "", "cannot interpolate []Int: no function string([]Int) String is declared"},
+		{"error position", "x = \"a \\(y)\"", "x", "", "undefined: y\n--> test.tup:9:10"},
+		// the error points at the interpolation within the literal
+		{"multi-line error position", "p = Point(x: 1, y: 2)\nx = ```\n\tfirst\n\t\\(p)\n```", "x", "", "cannot interpolate Point: no function string(Point) String is declared\n--> test.tup:12:2"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, stringFuncs+tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+func TestProcessor(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"without arguments", "x = ```json\n\t{ \"a\": 1 }\n```", "x", "Int", ""},
+		{"with arguments", "x = ```mustache(1)\n\tHello, {{name}}\n```", "x", "String", ""},
+		{"with labeled argument", "x = ```mustache(context: 1)\n\tHello\n```", "x", "String", ""},
+		{"scoped", "templating = 1\nx = ```templating.mustache(1)\n\tHello\n```", "x", "", "cannot use templating.mustache as a processor: functions of other modules are not yet supported"},
+		{"scoped undefined", "x = ```templating.mustache(1)\n\tHello\n```", "x", "", "undefined: templating"},
+		{"undefined", "x = ```sql\n\tselect 1\n```", "x", "", "undefined: sql"},
+		{"not a function", "v = 1\nx = ```v\n\ttext\n```", "x", "", "cannot use v as a processor: it has type Int, not a function type"},
+		{"first parameter not String", "x = ```count\n\ttext\n```", "x", "", "cannot use String as Int in argument to count"},
+		{"missing argument", "x = ```mustache\n\ttext\n```", "x", "", "missing argument for parameter context in call to mustache"},
+		{"too many arguments", "x = ```mustache(1, 2)\n\ttext\n```", "x", "", "too many arguments in call to mustache"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, stringFuncs+tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+// TestProcessorNotAName checks the processors the parser does not give,
+// of literals built rather than parsed.
+func TestProcessorNotAName(t *testing.T) {
+	tests := []struct {
+		name     string
+		function ast.FunctionCallContextFunction
+	}{
+		{"none", nil},
+		{"empty scope", &ast.ScopedFunctionIdentifier{Identifier: ast.NewFunctionIdentifier("mustache", nil, 0, 0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := stringFuncs + "x = ```mustache(1)\n\tHello\n```"
+			module, err := parse.Module(source.NewSource([]byte(input), "test.tup"), ast.NewModule("test"))
+			if err != nil {
+				t.Fatalf("parse.Module(%q) = %v", input, err)
+			}
+			assignment := module.TopLevelItems[len(module.TopLevelItems)-1].(*ast.Assignment)
+			assignment.Right.(*ast.MultiLineStringLiteral).Processor.Function = tt.function
+			_, err = Module(module)
+			if want := "the processor of a multi-line string must name a function"; err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("Module() = %v, want error containing %q", err, want)
+			}
+		})
+	}
+}
+
+func TestProcessorLowered(t *testing.T) {
+	input := stringFuncs + "x = ```mustache(1)\n\tHello, {{name}}\n```"
+	info, err := checkSource(t, input)
+	if err != nil {
+		t.Fatalf("Module(%q) = %v", input, err)
+	}
+	lit := info.Scope.Lookup("x").Decl.(*ast.Assignment).Right.(*ast.MultiLineStringLiteral)
+	call := info.Processors[lit]
+	if call == nil {
+		t.Fatalf("Processors[%s] = nil", lit)
+	}
+	if got, want := call.String(), "mustache(\"Hello, {{name}}\n\", 1)"; got != want {
+		t.Errorf("Lowered = %s, want %s", got, want)
+	}
+	// the processor is positioned within the literal
+	if pos, want := ast.PosOf(call), strings.Count(stringFuncs, "\n")+1; pos.Line != want {
+		t.Errorf("position of lowered call = %v, want line %d", pos, want)
+	}
+}
@@ -0,0 +1,252 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/match"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// switchExpr checks a switch expression. The parameters of the block of
+// each case receive the subject narrowed to the values its condition
+// matches, and those of the else block the members of a union subject no
+// case selects by type. The cases are compiled into a decision tree, which
+// shows the cases no value reaches and whether a value may match no case,
+// in which case the switch may produce nil.
+func (c *Checker) switchExpr(e *ast.SwitchExpression) types.Type {
+	subject := c.expr(e.Expression)
+	valid := !types.IsInvalid(subject)
+	var typs []types.Type
+	for _, switchCase := range e.Cases {
+		narrowed := c.pattern(switchCase.Condition, subject)
+		valid = valid && !types.IsInvalid(narrowed)
+		typs = append(typs, types.Default(c.functionBlock(switchCase.Body, narrowed)))
+	}
+	if e.ElseBlock != nil {
+		typs = append(typs, types.Default(c.functionBlock(e.ElseBlock, match.ElseType(e, c.info))))
+	}
+	if !valid {
+		return types.NewUnion(typs...)
+	}
+	tree, err := match.Compile(e, c.info)
+	if err != nil {
+		c.errorf(e, "%s", err)
+		return types.NewUnion(typs...)
+	}
+	c.info.Matches[e] = tree
+	for i, switchCase := range e.Cases {
+		if !tree.Reachable(i) {
+			c.errorf(switchCase.Condition, "unreachable case %s: earlier cases match all its values", switchCase.Condition)
+		}
+	}
+	if e.ElseBlock == nil && !tree.Exhaustive() {
+		typs = append(typs, types.Typ[types.Nil])
+	}
+	return types.NewUnion(typs...)
+}
+
+// pattern checks a match condition or pattern against values of type typ
+// and returns the type of the values it matches: the members of a union
+// it selects, or typ itself.
+func (c *Checker) pattern(p ast.Node, typ types.Type) types.Type {
+	narrowed := c.patternType(p, typ)
+	c.record(p, narrowed)
+	return narrowed
+}
+
+func (c *Checker) patternType(p ast.Node, typ types.Type) types.Type {
+	invalid := types.Typ[types.Invalid]
+	switch p := p.(type) {
+	case *ast.ListMatch:
+		var typs []types.Type
+		for _, elem := range p.Elements {
+			typs = append(typs, c.pattern(elem, typ))
+		}
+		return types.NewUnion(typs...)
+	case *ast.WildcardPattern:
+		return typ
+	case *ast.TypeReference:
+		return c.caseType(p, c.typExpr(p), typ)
+	case *ast.TypedPattern:
+		t := c.caseType(p.Type, c.typExpr(p.Type), typ)
+		if types.IsInvalid(t) {
+			return t
+		}
+		c.pattern(p.Pattern, t)
+		return t
+	case *ast.InferredErrorType:
+		var errs []types.Type
+		for _, m := range members(typ) {
+			if isErrorType(m) {
+				errs = append(errs, m)
+			}
+		}
+		if len(errs) == 0 && !types.IsInvalid(typ) {
+			c.errorf(p, "%s has no error members", typ)
+		}
+		return types.NewUnion(errs...)
+	case *ast.Constant:
+		expr := match.ConstantExpr(p)
+		if expr == nil {
+			c.errorf(p, "unsupported case %s", p)
+			return invalid
+		}
+		return c.caseValue(p, c.caseConstant(expr), typ)
+	case *ast.Range:
+		lo := c.caseConstant(p.StartBound.Value)
+		hi := c.caseConstant(p.EndBound.Value)
+		if lo == nil || hi == nil {
+			return invalid
+		}
+		if cmp, err := consteval.Compare(lo, hi); err != nil {
+			c.errorf(p, "invalid range %s: %s", p, err)
+			return invalid
+		} else if cmp > 0 {
+			c.errorf(p, "empty range %s", p)
+		}
+		return c.caseValue(p, lo, typ)
+	case *ast.TuplePattern:
+		t := caseMember(typ, func(u types.Type) bool {
+			tuple, ok := u.(*types.Tuple)
+			return ok && len(tuple.Fields) == len(p.Elements)
+		})
+		if t == nil {
+			return c.caseMismatch(p, typ)
+		}
+		tuple := t.Underlying().(*types.Tuple)
+		for i, elem := range p.Elements {
+			c.pattern(elem, tuple.Fields[i].Type)
+		}
+		return t
+	case *ast.LabeledPattern:
+		t := caseMember(typ, func(u types.Type) bool {
+			tuple, ok := u.(*types.Tuple)
+			if !ok {
+				return false
+			}
+			for _, m := range p.Members {
+				if tuple.FieldIndex(m.Label.Name) < 0 {
+					return false
+				}
+			}
+			return true
+		})
+		if t == nil {
+			return c.caseMismatch(p, typ)
+		}
+		tuple := t.Underlying().(*types.Tuple)
+		for _, m := range p.Members {
+			c.pattern(m.Pattern, tuple.Fields[tuple.FieldIndex(m.Label.Name)].Type)
+		}
+		return t
+	case *ast.ArrayPattern:
+		t := caseMember(typ, func(u types.Type) bool {
+			_, ok := u.(*types.Array)
+			return ok
+		})
+		if t == nil {
+			return c.caseMismatch(p, typ)
+		}
+		array := t.Underlying().(*types.Array)
+		n := int64(len(p.Elements))
+		if array.Len >= 0 && (n > array.Len || !p.HasRest && n != array.Len) {
+			c.errorf(p, "case %s can never match a value of type %s", p, t)
+		}
+		for _, elem := range p.Elements {
+			c.pattern(elem, array.Elem)
+		}
+		return t
+	}
+	c.errorf(p, "unsupported case %s", p)
+	return invalid
+}
+
+// members returns the members of typ if it is a union, or typ itself.
+func members(typ types.Type) []types.Type {
+	if union, ok := typ.Underlying().(*types.Union); ok {
+		return union.Members
+	}
+	return []types.Type{typ}
+}
+
+// caseType checks that a case naming the type t may match a value of type
+// typ: t must be typ or one of its members. A case naming a generic type,
+// such as Cons, matches the member that is an instance of it, whose type
+// it returns.
+func (c *Checker) caseType(node ast.Node, t, typ types.Type) types.Type {
+	if types.IsInvalid(t) || types.IsInvalid(typ) {
+		return t
+	}
+	for _, m := range members(typ) {
+		if types.Identical(m, t) {
+			return t
+		}
+		if n, ok := m.(*types.Named); ok && n.Origin() != nil && n.Origin() == t {
+			return m
+		}
+	}
+	if types.Identical(t, typ) {
+		return t
+	}
+	c.errorf(node, "case %s can never match a value of type %s", t, typ)
+	return types.Typ[types.Invalid]
+}
+
+// caseMember returns typ or the first of its members whose underlying type
+// satisfies ok, or nil if there is none.
+func caseMember(typ types.Type, ok func(types.Type) bool) types.Type {
+	if types.IsInvalid(typ) {
+		return typ
+	}
+	if ok(typ.Underlying()) {
+		return typ
+	}
+	for _, m := range members(typ) {
+		if ok(m.Underlying()) {
+			return m
+		}
+	}
+	return nil
+}
+
+func (c *Checker) caseMismatch(p ast.Node, typ types.Type) types.Type {
+	c.errorf(p, "case %s can never match a value of type %s", p, typ)
+	return types.Typ[types.Invalid]
+}
+
+// caseConstant checks the value of a constant or range bound of a case,
+// which must be known at compile time.
+func (c *Checker) caseConstant(expr ast.Expression) consteval.Value {
+	if types.IsInvalid(c.expr(expr)) {
+		return nil
+	}
+	v, ok := c.constant(expr)
+	if !ok {
+		c.errorf(expr, "case value %s is not a compile-time constant", expr)
+		return nil
+	}
+	return v
+}
+
+// caseValue returns the member of typ a case matching the value v may
+// match, or typ itself.
+func (c *Checker) caseValue(p ast.Node, v consteval.Value, typ types.Type) types.Type {
+	if v == nil {
+		return types.Typ[types.Invalid]
+	}
+	if types.IsInvalid(typ) || c.assignable(v.Type(), typ) && !isUnion(typ) {
+		return typ
+	}
+	for _, m := range members(typ) {
+		if c.assignable(v.Type(), m) {
+			return m
+		}
+	}
+	c.errorf(p, "mismatched types %s and %s in case %s", types.Default(v.Type()), typ, p)
+	return types.Typ[types.Invalid]
+}
+
+func isUnion(typ types.Type) bool {
+	_, ok := typ.Underlying().(*types.Union)
+	return ok
+}
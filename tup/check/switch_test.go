@@ -0,0 +1,167 @@
+package check
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+)
+
+const cards = "Hearts = type(Int)\nSpades = type(Int)\nCard = Hearts | Spades\n"
+
+func TestSwitch(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"constants", "f = fn(v: Int) String {\n\tswitch v {\n\t\t1 { \"one\" }\n\t\t2, 3 { \"few\" }\n\t\telse { \"many\" }\n\t}\n}", "f", "fn(v: Int) String", ""},
+		{"exhaustive union", cards + "f = fn(c: Card) Int {\n\tswitch c {\n\t\tHearts { |h| 1 }\n\t\tSpades { 2 }\n\t}\n}", "f", "fn(c: Card) Int", ""},
+		{"narrowed parameter", cards + "f = fn(c: Card) Int {\n\tswitch c {\n\t\tHearts(2..10) { |n, ...| n }\n\t\telse { 0 }\n\t}\n}", "f", "fn(c: Card) Int", ""},
+		{"exhaustive Bool", "f = fn(b: Bool) Int {\n\tswitch b {\n\t\ttrue { 1 }\n\t\tfalse { 0 }\n\t}\n}", "f", "fn(b: Bool) Int", ""},
+		{"exhaustive array", "f = fn(v: []Int) Int {\n\tswitch v {\n\t\t[] { 0 }\n\t\t[_, ...] { |head, ...tail| head }\n\t}\n}", "f", "fn(v: []Int) Int", ""},
+		{"labeled tuple", "Point = type(x: Int, y: Int)\nf = fn(p: Point) Int {\n\tswitch p {\n\t\tPoint(x: 0, y: 0) { 0 }\n\t\tPoint { |x, y| x + y }\n\t}\n}", "f", "fn(p: Point) Int", ""},
+		{"narrowed else", "f = fn(v: Int | String | Nil) Int {\n\tswitch v {\n\t\tNil { 0 }\n\t\tString { 1 }\n\t\telse { |n| n + 1 }\n\t}\n}", "f", "fn(v: Int | String | Nil) Int", ""},
+		{"constant identifier", "a = 1\nf = fn(v: Int) Int {\n\tswitch v {\n\t\ta { 1 }\n\t\telse { 0 }\n\t}\n}", "f", "fn(v: Int) Int", ""},
+		{"not exhaustive", "x = 5\ny = switch x {\n\t1 { \"one\" }\n}", "y", "String | Nil", ""},
+		{"unreachable case", "f = fn(v: Int) Int {\n\tswitch v {\n\t\t0..9 { 1 }\n\t\t5 { 2 }\n\t\telse { 3 }\n\t}\n}", "f", "", "unreachable case 5: earlier cases match all its values"},
+		{"mismatched constant", "f = fn(v: Int) Int {\n\tswitch v {\n\t\t\"a\" { 1 }\n\t\telse { 0 }\n\t}\n}", "f", "", "mismatched types String and Int in case \"a\""},
+		{"type not in union", cards + "Clubs = type(Int)\nf = fn(c: Card) Int {\n\tswitch c {\n\t\tClubs { 1 }\n\t\telse { 0 }\n\t}\n}", "f", "", "case Clubs can never match a value of type Card"},
+		{"tuple arity", "Pair = type(Int, Int)\nf = fn(p: Pair) Int {\n\tswitch p {\n\t\t(1, 2, 3) { 1 }\n\t\telse { 0 }\n\t}\n}", "f", "", "case (1, 2, 3) can never match a value of type Pair"},
+		{"array against tuple", "Pair = type(Int, Int)\nf = fn(p: Pair) Int {\n\tswitch p {\n\t\t[1, ...] { 1 }\n\t\telse { 0 }\n\t}\n}", "f", "", "case [1, ...] can never match a value of type Pair"},
+		{"fixed array length", "f = fn(v: [2]Int) Int {\n\tswitch v {\n\t\t[1, 2, 3] { 1 }\n\t\telse { 0 }\n\t}\n}", "f", "", "case [1, 2, 3] can never match a value of type [2]Int"},
+		{"empty range", "f = fn(v: Int) Int {\n\tswitch v {\n\t\t9..0 { 1 }\n\t\telse { 0 }\n\t}\n}", "f", "", "empty range 9..0"},
+		{"non-constant case", "f = fn(v: Int, w: Int) Int {\n\tswitch v {\n\t\tw { 1 }\n\t\telse { 0 }\n\t}\n}", "f", "", "case value w is not a compile-time constant"},
+		{"missing else", "f = fn(v: Int) Int {\n\tswitch v {\n\t\t1 { 1 }\n\t}\n}", "f", "", "cannot use Int | Nil as Int in return value of f"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+func TestSwitchTree(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "constants and ranges",
+			input: "f = fn(v: Int) Int {\n\tswitch v {\n\t\t1 { 1 }\n\t\t2, 3 { 2 }\n\t\t0..9 { 3 }\n\t\telse { 4 }\n\t}\n}",
+			want: `switch v: Int
+  test $: Int
+    == 1:
+      match 0: 1
+    == 2:
+      match 1: 2, 3
+    == 3:
+      match 1: 2, 3
+    in 0..9:
+      match 2: 0..9
+    else:
+      match else
+`,
+		},
+		{
+			name:  "overlapping ranges",
+			input: "f = fn(v: Int) Int {\n\tswitch v {\n\t\t0..5 { 1 }\n\t\t3..9 { 2 }\n\t\telse { 3 }\n\t}\n}",
+			want: `switch v: Int
+  test $: Int
+    in 0..5:
+      match 0: 0..5
+    in 3..9:
+      match 1: 3..9
+    else:
+      match else
+`,
+		},
+		{
+			name:  "union tags",
+			input: cards + "f = fn(c: Card) Int {\n\tswitch c {\n\t\tHearts(1) { 1 }\n\t\tHearts(2..10) { |n, ...| n }\n\t\tHearts { 11 }\n\t\tSpades { 0 }\n\t}\n}",
+			want: `switch c: Card
+  test $: Card
+    is Hearts:
+      test $.(Hearts).0: Int
+        == 1:
+          match 0: Hearts(1)
+        in 2..10:
+          match 1: Hearts(2..10) with n = $.(Hearts).0
+        else:
+          match 2: Hearts
+    is Spades:
+      match 3: Spades
+`,
+		},
+		{
+			name:  "list of types",
+			input: "f = fn(v: Int | Float | String) Int {\n\tswitch v {\n\t\tInt, Float { |n| 1 }\n\t\telse { 0 }\n\t}\n}",
+			want: `switch v: Int | Float | String
+  test $: Int | Float | String
+    is Int:
+      match 0: Int, Float with n = $.(Int)
+    is Float:
+      match 0: Int, Float with n = $.(Float)
+    else:
+      match else
+`,
+		},
+		{
+			name:  "arrays",
+			input: "f = fx(v: []Int) {\n\tswitch v {\n\t\t[] { 0 }\n\t\t[1, 2] { 1 }\n\t\t[_, _, ...] { |x, y, ...rest| x + y }\n\t}\n}",
+			want: `switch v: []Int
+  test $: []Int
+    len == 0:
+      match 0: []
+    len == 2:
+      test $[0]: Int
+        == 1:
+          test $[1]: Int
+            == 2:
+              match 1: [1, 2]
+            else:
+              match 2: [_, _, ...] with x = $[0], y = $[1], rest = $[2:]
+        else:
+          match 2: [_, _, ...] with x = $[0], y = $[1], rest = $[2:]
+    len >= 2:
+      match 2: [_, _, ...] with x = $[0], y = $[1], rest = $[2:]
+    else:
+      no match
+`,
+		},
+		{
+			name:  "tuples",
+			input: "Point = type(x: Int, y: Int)\nf = fn(p: Point) Int {\n\tswitch p {\n\t\tPoint(y: 0) { |(x: x)| x }\n\t\t(0, _) { 1 }\n\t\telse { 2 }\n\t}\n}",
+			want: `switch p: Point
+  test $.1: Int
+    == 0:
+      match 0: Point(y: 0) with x = $.0
+    else:
+      test $.0: Int
+        == 0:
+          match 1: (0, _)
+        else:
+          match else
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := checkSource(t, tt.input)
+			if err != nil {
+				t.Fatalf("Module(%q) = %v", tt.input, err)
+			}
+			var got []string
+			ast.Inspect(info.Scope.Lookup("f").Decl, func(n ast.Node) bool {
+				if e, ok := n.(*ast.SwitchExpression); ok {
+					got = append(got, info.Matches[e].String())
+				}
+				return true
+			})
+			if strings.Join(got, "") != tt.want {
+				t.Errorf("Matches = \n%s\nwant\n%s", strings.Join(got, ""), tt.want)
+			}
+		})
+	}
+}
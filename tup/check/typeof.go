@@ -0,0 +1,45 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// typeof checks a typeof expression and adds the descriptors it may
+// produce to the descriptor table. The described type is known at compile
+// time unless the operand is a value of a union type, named or not, or of
+// a type parameter, in which case the descriptor of its value is taken at
+// run time.
+func (c *Checker) typeof(e *ast.TypeofExpression) types.Type {
+	var typ types.Type
+	static := true
+	if e.TypeArg != nil {
+		typ = c.typExpr(e.TypeArg)
+	} else {
+		typ = types.Default(c.expr(e.Expression))
+		switch typ.Underlying().(type) {
+		case *types.Union, *types.TypeParam:
+			static = false
+		}
+	}
+	if !types.IsInvalid(typ) {
+		c.info.Descriptors.Add(typ)
+		if static {
+			c.info.Typeofs[e] = typ
+		}
+	}
+	return types.Typ[types.TypeDescriptor]
+}
+
+// typeofComparison folds a comparison of type descriptors whose types are
+// known at compile time.
+func (c *Checker) typeofComparison(e *ast.RelationalComparison) {
+	if e.Operator != ast.OpEq && e.Operator != ast.OpNeq {
+		return
+	}
+	_, left := e.Left.(*ast.TypeofExpression)
+	_, right := e.Right.(*ast.TypeofExpression)
+	if left || right {
+		c.constant(e)
+	}
+}
@@ -0,0 +1,78 @@
+package check
+
+import (
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+func TestTypeof(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		value    string
+		wantType string
+		wantErr  string
+	}{
+		{"value", "x = typeof(1)", "x", "Type", ""},
+		{"type", "x = typeof([]Int)", "x", "Type", ""},
+		{"comparison", "x = typeof(1) == typeof(Int)", "x", "Bool", ""},
+		{"mismatched comparison", "x = typeof(1) == 1", "x", "", "mismatched types Type and untyped Int in =="},
+		{"undeclared type", "x = typeof(Point)", "x", "", "undefined type: Point"},
+	}
+	for _, tt := range tests {
+		RunCheckTest(t, tt.name, tt.input, tt.value, tt.wantType, tt.wantErr)
+	}
+}
+
+func TestTypeofFolding(t *testing.T) {
+	const point = "Point = type(x: Int, y: Int)\np = Point(1, 2)\n"
+	tests := []struct {
+		name  string
+		input string
+		want  string // "" if the comparison is left to run time
+	}{
+		{"literal", "x = typeof(1) == typeof(Int)", "true"},
+		{"alias", "x = typeof(Int) == typeof(Int64)", "true"},
+		{"different types", `x = typeof("a") != typeof(Int)`, "true"},
+		{"mutable variable", "n = mut 1\nx = typeof(n) == typeof(Int)", "true"},
+		{"named type", point + "x = typeof(p) == typeof(Point)", "true"},
+		{"named and anonymous", point + "x = typeof(p) == typeof((x: Int, y: Int))", "false"},
+		{"anonymous types are merged", "x = typeof((a: 1, b: 2)) == typeof((a: Int, b: Int))", "true"},
+		{"union", "f = fn(v: Int | String) Bool { typeof(v) == typeof(Int) }\nx = f(1)", ""},
+		{"named union", "Circle = type(r: Int)\nSquare = type(s: Int)\nShape = Circle | Square\nf = fn(s: Shape) Bool { typeof(s) == typeof(Circle) }\nx = f(Circle(1))", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := checkSource(t, tt.input)
+			if err != nil {
+				t.Fatalf("Module(%q) = %v", tt.input, err)
+			}
+			var got string
+			for expr, v := range info.Values {
+				if cmp, ok := expr.(*ast.RelationalComparison); ok {
+					if _, ok := cmp.Left.(*ast.TypeofExpression); ok {
+						got = v.String()
+					}
+				}
+			}
+			if got != tt.want {
+				t.Errorf("Module(%q): folded to %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescriptors(t *testing.T) {
+	input := "f = fn(v: Int | String) Bool { typeof(v) == typeof(Int) }"
+	info, err := checkSource(t, input)
+	if err != nil {
+		t.Fatalf("Module(%q) = %v", input, err)
+	}
+	for _, typ := range []types.Type{types.NewUnion(types.Int, types.Typ[types.String]), types.Int, types.Typ[types.String]} {
+		if info.Descriptors.Lookup(typ) == nil {
+			t.Errorf("Module(%q): no descriptor for %s", input, typ)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package check
+
+import (
+	"slices"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// typeDecl sets the underlying type of a named type, and the type
+// parameters of a generic one, from its declaration.
+func (c *Checker) typeDecl(named *types.Named, decl *ast.TypeDeclaration) {
+	if decl.LHS.TypeParameters != nil {
+		c.openScope()
+		defer c.closeScope()
+		var params []*types.TypeParam
+		for _, param := range decl.LHS.TypeParameters.Parameters {
+			typ := types.NewTypeParam(param.Identifier.Name)
+			params = append(params, typ)
+			c.scope.Insert(&Object{Kind: TypeObject, Name: typ.Name(), Type: typ, state: resolved})
+		}
+		named.SetTypeParams(params)
+	}
+	named.SetUnderlying(c.typExpr(decl.RHS))
+}
+
+// typExpr returns the type denoted by a type expression.
+func (c *Checker) typExpr(node ast.Node) types.Type {
+	invalid := types.Typ[types.Invalid]
+	switch node := node.(type) {
+	case nil:
+		return invalid
+	case *ast.ReturnType:
+		return c.typExpr(node.Type)
+	case *ast.TypeReference:
+		if len(node.Identifiers) > 0 {
+			// qualified references into other modules are not yet resolved
+			return invalid
+		}
+		return c.typeName(node, node.TypeIdentifier.Name)
+	case *ast.TypeIdentifier:
+		return c.typeName(node, node.Name)
+	case *ast.Identifier:
+		// lowercase type names are type parameters
+		if obj := c.scope.Lookup(node.Name); obj != nil && obj.Kind == TypeObject {
+			return obj.Type
+		}
+		return types.NewTypeParam(node.Name)
+	case *ast.DynamicArrayType:
+		return types.NewArray(c.typExpr(node.ElementType))
+	case *ast.FixedSizeArrayType:
+		size, ok := c.arraySize(node.Size)
+		if !ok {
+			return types.Typ[types.Invalid]
+		}
+		return types.NewFixedArray(c.typExpr(node.ElementType), size)
+	case *ast.NilableType:
+		return types.NewUnion(c.typExpr(node.InnerType), types.Typ[types.Nil])
+	case *ast.TypeTuple:
+		return c.tupleType(node.TupleType)
+	case *ast.ErrorTuple:
+		return c.tupleType(node.TupleType)
+	case *ast.TupleType:
+		return c.tupleType(node)
+	case *ast.NamedTuple:
+		return c.namedTuple(node)
+	case *ast.UnionType:
+		var members []types.Type
+		for _, member := range node.Members {
+			members = append(members, c.typExpr(member))
+		}
+		return types.NewUnion(members...)
+	case *ast.InlineUnion:
+		return c.typExpr(node.UnionType)
+	case *ast.UnionDeclaration:
+		return c.unionMembers(node.Members)
+	case *ast.UnionDeclarationWithError:
+		return types.NewUnion(c.unionMembers(node.Members), types.ErrorType)
+	case *ast.UnionWithError:
+		var members []types.Type
+		for _, member := range node.Members {
+			members = append(members, c.typExpr(member))
+		}
+		return types.NewUnion(append(members, types.ErrorType)...)
+	case *ast.FallibleType:
+		return types.NewUnion(c.typExpr(node.InnerType), types.ErrorType)
+	case *ast.InferredErrorType:
+		return types.ErrorType
+	case *ast.FunctionType:
+		var result types.Type
+		if node.ReturnType != nil && node.ReturnType.Type != nil {
+			result = c.typExpr(node.ReturnType)
+		}
+		return c.funcType(node.Parameters, result, node.HasSideEffects)
+	case *ast.GenericType:
+		return c.genericType(node)
+	case *ast.EnumDeclaration:
+		return c.enumType(node)
+	case *ast.ContractDeclaration:
+		return c.contractType(node)
+	case ast.Literal:
+		// default values stand in for their type in tuple type members
+		return types.Default(c.expr(node))
+	}
+	c.errorf(node, "%s is not a type", node)
+	return invalid
+}
+
+func (c *Checker) typeName(node ast.Node, name string) types.Type {
+	obj := c.scope.Lookup(name)
+	if obj == nil {
+		c.errorf(node, "undefined type: %s", name)
+		return types.Typ[types.Invalid]
+	}
+	if obj.Kind != TypeObject {
+		c.errorf(node, "%s is not a type", name)
+		return types.Typ[types.Invalid]
+	}
+	c.resolve(obj)
+	return obj.Type
+}
+
+// arraySize evaluates the size of a fixed-size array type, reporting false
+// if it is not a valid compile-time constant.
+func (c *Checker) arraySize(size ast.Size) (int64, bool) {
+	expr, ok := size.(ast.Expression)
+	if !ok {
+		return 0, false
+	}
+	if types.IsInvalid(c.expr(expr)) {
+		return 0, false
+	}
+	v, err := c.eval.Eval(expr)
+	if err != nil {
+		if err, ok := err.(*consteval.Error); ok && !err.NotConstant {
+			c.errorf(err.Node, "%s", err.Msg)
+		} else {
+			c.errorf(size, "array size %s is not a compile-time constant", size)
+		}
+		return 0, false
+	}
+	c.info.Values[expr] = v
+	n, ok := v.(*consteval.Int)
+	if !ok {
+		c.errorf(size, "array size %s must be an integer, not %s", size, v.Type())
+		return 0, false
+	}
+	if n.Val.Sign() < 0 || !n.Val.IsInt64() {
+		c.errorf(size, "invalid array size %s", n)
+		return 0, false
+	}
+	return n.Val.Int64(), true
+}
+
+func (c *Checker) tupleType(tuple *ast.TupleType) types.Type {
+	fields := []*types.Field{}
+	if tuple == nil {
+		return types.NewTuple(fields...)
+	}
+	for _, member := range tuple.Members {
+		switch member := member.(type) {
+		case *ast.TupleTypeMember:
+			fields = append(fields, types.NewField("", c.paramType(member.Type)))
+		case *ast.LabeledTupleTypeMember:
+			fields = append(fields, types.NewField(member.Identifier.Name, c.paramType(member.Type)))
+		}
+	}
+	return types.NewTuple(fields...)
+}
+
+func (c *Checker) namedTuple(node *ast.NamedTuple) types.Type {
+	name := node.TypeIdentifier.Name
+	if obj := c.scope.Lookup(name); obj != nil && obj.Kind == TypeObject {
+		return obj.Type
+	}
+	named := types.NewNamed(name, c.tupleType(node.TupleType))
+	c.info.Scope.Insert(&Object{Kind: TypeObject, Name: name, Type: named, Decl: node, state: resolved})
+	return named
+}
+
+func (c *Checker) unionMembers(members ast.UnionMembers) types.Type {
+	var typs []types.Type
+	for _, member := range members {
+		typs = append(typs, c.typExpr(member.Member))
+	}
+	return types.NewUnion(typs...)
+}
+
+// rangeType returns the instance of the core type
+// Range[a]: type(lo: a, hi: a) for the element type elem.
+func (c *Checker) rangeType(elem types.Type) types.Type {
+	elem = types.Default(elem)
+	name := "Range[" + elem.String() + "]"
+	if named, ok := c.ranges[name]; ok {
+		return named
+	}
+	named := types.NewNamed(name, types.NewTuple(
+		types.NewField("lo", elem),
+		types.NewField("hi", elem),
+	))
+	c.ranges[name] = named
+	return named
+}
+
+// declAnnotations returns the names of the simple annotations that apply
+// to a type declaration.
+func declAnnotations(decl *ast.TypeDeclaration) []string {
+	names := annotationNames(decl.LHS.Annotations)
+	if _, ok := decl.RHS.(*ast.ErrorTuple); ok {
+		// Name = error(...) is shorthand for @error @false Name = type(...)
+		for _, name := range []string{"error", "false"} {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
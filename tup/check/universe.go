@@ -0,0 +1,28 @@
+package check
+
+import (
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Universe is the scope of the predeclared types and values.
+var Universe = NewScope(nil)
+
+func init() {
+	for _, typ := range types.Typ {
+		if typ.Kind() == types.Invalid || types.IsUntyped(typ) {
+			continue
+		}
+		defineType(typ.Name(), typ)
+	}
+	for _, typ := range types.Aliases {
+		defineType(typ.Name(), typ)
+	}
+	defineType("error", types.ErrorType)
+
+	Universe.Insert(&Object{Kind: VarObject, Name: "nil", Type: types.Typ[types.Nil], Const: consteval.Nil{}, state: resolved})
+}
+
+func defineType(name string, typ types.Type) {
+	Universe.Insert(&Object{Kind: TypeObject, Name: name, Type: typ, state: resolved})
+}
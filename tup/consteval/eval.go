@@ -0,0 +1,967 @@
+// Package consteval evaluates Tuppence expressions at compile time: literals,
+// arithmetic, string concatenation, tuple and array construction, and calls
+// to pure functions.
+package consteval
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/bind"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// DefaultMaxSteps is the number of evaluation steps allowed for a single
+// call to Eval unless the Evaluator is configured otherwise.
+const DefaultMaxSteps = 100_000
+
+// maxDepth bounds the depth of nested function calls.
+const maxDepth = 256
+
+// Resolver provides an Evaluator with the values and types of the names
+// declared by the program being evaluated.
+type Resolver interface {
+	// Lookup returns the value of the name known at compile time, or nil
+	// if it is not known. Pure functions have *Func values.
+	Lookup(name string) Value
+	// TypeOf returns the type of an expression, or nil if it is not known.
+	TypeOf(expr ast.Expression) types.Type
+	// Builtin returns the name of the builtin function called by call, or
+	// "" if call does not call one, and the value of the call if it is
+	// known without evaluating the arguments.
+	Builtin(call *ast.FunctionCall) (name string, v Value)
+	// Described returns the type described by a typeof expression if it is
+	// known without evaluating the operand, or nil if it is not.
+	Described(e *ast.TypeofExpression) types.Type
+	// Overload reports whether call calls an overloaded function, and
+	// returns the value of the overload it calls, or nil if that is not
+	// known at compile time.
+	Overload(call *ast.FunctionCall) (v Value, overloaded bool)
+	// Processor returns the call of its processor a multi-line string
+	// literal is evaluated as, or nil if it is not known.
+	Processor(lit *ast.MultiLineStringLiteral) *ast.FunctionCall
+}
+
+// Error reports an expression that could not be evaluated at compile time.
+type Error struct {
+	Node ast.Node
+	Msg  string
+	// NotConstant is set when the value of Node is not known at compile
+	// time, as opposed to a constant whose evaluation failed, such as by
+	// overflowing or dividing by zero.
+	NotConstant bool
+}
+
+func (err *Error) Error() string { return err.Msg }
+
+// Evaluator evaluates expressions at compile time.
+type Evaluator struct {
+	// MaxSteps is the number of steps allowed for each call to Eval.
+	MaxSteps int
+
+	resolver Resolver
+	steps    int
+	depth    int
+}
+
+// NewEvaluator returns an Evaluator that looks up names with resolver.
+func NewEvaluator(resolver Resolver) *Evaluator {
+	return &Evaluator{MaxSteps: DefaultMaxSteps, resolver: resolver}
+}
+
+// Eval evaluates expr. If expr cannot be evaluated, the returned error is
+// an *Error.
+func (ev *Evaluator) Eval(expr ast.Expression) (Value, error) {
+	// Eval may be reentered by the resolver, which evaluates the
+	// declarations of the names it is asked for.
+	steps, depth := ev.steps, ev.depth
+	ev.steps, ev.depth = 0, 0
+	defer func() { ev.steps, ev.depth = steps, depth }()
+	return ev.eval(nil, expr)
+}
+
+// env holds the names bound by the blocks and calls being evaluated.
+type env struct {
+	vars   map[string]Value
+	parent *env
+}
+
+func newEnv(parent *env) *env {
+	return &env{vars: map[string]Value{}, parent: parent}
+}
+
+func (e *env) lookup(name string) (Value, bool) {
+	for ; e != nil; e = e.parent {
+		if v, ok := e.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// assign updates the innermost binding of name.
+func (e *env) assign(name string, v Value) bool {
+	for ; e != nil; e = e.parent {
+		if _, ok := e.vars[name]; ok {
+			e.vars[name] = v
+			return true
+		}
+	}
+	return false
+}
+
+func notConstant(node ast.Node, format string, args ...any) *Error {
+	return &Error{Node: node, Msg: fmt.Sprintf(format, args...), NotConstant: true}
+}
+
+func failure(node ast.Node, err error) *Error {
+	return &Error{Node: node, Msg: err.Error()}
+}
+
+func (ev *Evaluator) eval(env *env, expr ast.Expression) (Value, error) {
+	ev.steps++
+	if ev.steps > ev.MaxSteps {
+		return nil, notConstant(expr, "evaluation exceeded %d steps", ev.MaxSteps)
+	}
+
+	switch e := expr.(type) {
+	// literals
+	case *ast.IntegerLiteral:
+		return &Int{Val: big.NewInt(e.IntegerValue), Typ: types.Typ[types.UntypedInt]}, nil
+	case *ast.FloatLiteral:
+		return NewFloat(e.FloatValue), nil
+	case *ast.BooleanLiteral:
+		return Bool(e.BooleanValue), nil
+	case *ast.StringLiteral:
+		return String(e.StringValue), nil
+	case *ast.RawStringLiteral:
+		return String(e.StringValue), nil
+	case *ast.InterpolatedStringLiteral:
+		return ev.interpolatedString(env, e)
+	case *ast.MultiLineStringLiteral:
+		if e.Processor == nil {
+			return ev.interpolatedString(env, e.Contents)
+		}
+		if call := ev.resolver.Processor(e); call != nil {
+			return ev.call(env, call)
+		}
+		return nil, notConstant(e, "the processor %s is not known", e.Processor.Function)
+	case *ast.SymbolLiteral:
+		return Symbol(strings.TrimPrefix(e.Value, ":")), nil
+	case *ast.RuneLiteral:
+		return &Int{Val: big.NewInt(int64(e.RuneValue)), Typ: types.Rune}, nil
+	case *ast.TupleLiteral:
+		return ev.tupleLiteral(env, e)
+	case *ast.ArrayLiteral:
+		return ev.arrayLiteral(env, e)
+
+	// names
+	case *ast.Identifier:
+		return ev.ident(env, e, e.Name)
+	case *ast.FunctionIdentifier:
+		return ev.ident(env, e, e.Name)
+
+	// operators
+	case *ast.AddSubExpression:
+		return ev.binary(env, e, e.Operator.String(), e.Left, e.Right)
+	case *ast.MulDivExpression:
+		return ev.binary(env, e, e.Operator.String(), e.Left, e.Right)
+	case *ast.PowExpression:
+		return ev.pow(env, e, e.Operands)
+	case *ast.RelationalComparison:
+		if e.Operator == ast.OpMatch {
+			return nil, notConstant(e, "pattern matching is not evaluated at compile time")
+		}
+		x, y, err := ev.operands(env, e.Left, e.Right)
+		if err != nil {
+			return nil, err
+		}
+		v, err := compare(e.Operator.String(), x, y)
+		if err != nil {
+			return nil, failure(e, err)
+		}
+		return v, nil
+	case *ast.LogicalOrExpression:
+		return ev.logical(env, e.Operands, true)
+	case *ast.LogicalAndExpression:
+		return ev.logical(env, e.Operands, false)
+	case *ast.UnaryExpression:
+		x, err := ev.eval(env, e.Expression)
+		if err != nil {
+			return nil, err
+		}
+		v, err := unary(e.Operator.String(), x)
+		if err != nil {
+			return nil, failure(e, err)
+		}
+		return v, nil
+
+	// control flow
+	case *ast.Block:
+		return ev.block(env, e)
+	case *ast.IfExpression:
+		return ev.ifExpr(env, e)
+
+	// calls and access
+	case *ast.FunctionCall:
+		return ev.call(env, e)
+	case *ast.TypeConstructorCall:
+		return ev.construct(env, e)
+	case *ast.MemberAccess:
+		return ev.memberAccess(env, e)
+	case *ast.IndexedAccess:
+		return ev.index(env, e, e.Object, e.Index)
+	case *ast.SafeIndexedAccess:
+		return ev.index(env, e, e.Object, e.Index)
+	case *ast.TupleUpdateExpression:
+		return ev.tupleUpdate(env, e)
+	case *ast.TypeofExpression:
+		return ev.typeof(env, e)
+	}
+	return nil, notConstant(expr, "%s is not a compile-time constant", expr)
+}
+
+func (ev *Evaluator) ident(env *env, node ast.Node, name string) (Value, error) {
+	if v, ok := env.lookup(name); ok {
+		return v, nil
+	}
+	if v := ev.resolver.Lookup(name); v != nil {
+		return v, nil
+	}
+	return nil, notConstant(node, "%s is not a compile-time constant", name)
+}
+
+func (ev *Evaluator) operands(env *env, left, right ast.Expression) (Value, Value, error) {
+	x, err := ev.eval(env, left)
+	if err != nil {
+		return nil, nil, err
+	}
+	y, err := ev.eval(env, right)
+	if err != nil {
+		return nil, nil, err
+	}
+	return x, y, nil
+}
+
+// binary evaluates an arithmetic operator. Checked operators return an
+// error value in place of a result that overflows or divides by zero.
+func (ev *Evaluator) binary(env *env, node ast.Node, op string, left, right ast.Expression) (Value, error) {
+	x, y, err := ev.operands(env, left, right)
+	if err != nil {
+		return nil, err
+	}
+	v, err := Binary(op, x, y)
+	if err != nil {
+		return nil, failure(node, err)
+	}
+	return v, nil
+}
+
+// pow evaluates a chain of exponentiations, which group to the right.
+func (ev *Evaluator) pow(env *env, node ast.Node, operands []ast.Expression) (Value, error) {
+	values := make([]Value, len(operands))
+	for i, operand := range operands {
+		v, err := ev.eval(env, operand)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	v := values[len(values)-1]
+	for i := len(values) - 2; i >= 0; i-- {
+		var err error
+		if v, err = binary("^", values[i], v); err != nil {
+			return nil, failure(node, err)
+		}
+	}
+	return v, nil
+}
+
+func (ev *Evaluator) logical(env *env, operands []ast.Expression, or bool) (Value, error) {
+	for _, operand := range operands {
+		v, err := ev.eval(env, operand)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(Bool)
+		if !ok {
+			return nil, failure(operand, fmt.Errorf("non-Bool %s used in logical expression", v))
+		}
+		if bool(b) == or {
+			return b, nil
+		}
+	}
+	return Bool(!or), nil
+}
+
+func (ev *Evaluator) interpolatedString(env *env, lit *ast.InterpolatedStringLiteral) (Value, error) {
+	var builder strings.Builder
+	for _, part := range lit.Parts {
+		switch part := part.(type) {
+		case *ast.StringLiteral:
+			builder.WriteString(part.StringValue)
+		case *ast.Interpolation:
+			v, err := ev.eval(env, part.Expression)
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := v.(String); ok {
+				builder.WriteString(string(s))
+			} else {
+				builder.WriteString(v.String())
+			}
+		}
+	}
+	return String(builder.String()), nil
+}
+
+func (ev *Evaluator) tupleLiteral(env *env, lit *ast.TupleLiteral) (Value, error) {
+	tuple := &Tuple{}
+	for _, member := range lit.Members {
+		v, err := ev.eval(env, member.Value)
+		if err != nil {
+			return nil, err
+		}
+		if member.Spread {
+			spread, ok := v.(*Tuple)
+			if !ok {
+				return nil, failure(member.Value, fmt.Errorf("cannot spread %s: not a tuple", v))
+			}
+			tuple.Fields = append(tuple.Fields, spread.Fields...)
+			continue
+		}
+		if v, err = Convert(v, types.Default(v.Type())); err != nil {
+			return nil, failure(member.Value, err)
+		}
+		name := ""
+		if member.Label != nil {
+			name = member.Label.Name
+		}
+		tuple.Fields = append(tuple.Fields, Field{Name: name, Value: v})
+	}
+	return tuple, nil
+}
+
+func (ev *Evaluator) arrayLiteral(env *env, lit *ast.ArrayLiteral) (Value, error) {
+	if lit.Initializer != nil {
+		return nil, notConstant(lit, "array initializers are not evaluated at compile time")
+	}
+	typ := ev.resolver.TypeOf(lit)
+	var array, elem types.Type
+	if typ != nil {
+		if t, ok := typ.Underlying().(*types.Array); ok {
+			array, elem = typ, t.Elem
+		}
+	}
+	var elems []Value
+	for _, element := range lit.Elements {
+		v, err := ev.eval(env, element)
+		if err != nil {
+			return nil, err
+		}
+		if elem == nil {
+			elem = types.Default(v.Type())
+		}
+		if v, err = Convert(v, elem); err != nil {
+			return nil, failure(element, err)
+		}
+		elems = append(elems, v)
+	}
+	if array == nil {
+		if elem == nil {
+			return nil, notConstant(lit, "type of %s is not known", lit)
+		}
+		array = types.NewArray(elem)
+	}
+	return &Array{Elems: elems, Typ: array}, nil
+}
+
+func (ev *Evaluator) block(parent *env, block *ast.Block) (Value, error) {
+	if block == nil || block.Body == nil {
+		return Nil{}, nil
+	}
+	env := newEnv(parent)
+	for _, stmt := range block.Body.Statements {
+		if err := ev.stmt(env, stmt); err != nil {
+			return nil, err
+		}
+	}
+	if block.Body.Expression == nil {
+		return Nil{}, nil
+	}
+	return ev.eval(env, block.Body.Expression)
+}
+
+func (ev *Evaluator) stmt(env *env, stmt ast.Statement) error {
+	switch stmt := stmt.(type) {
+	case *ast.Assignment:
+		v, err := ev.eval(env, stmt.Right)
+		if err != nil {
+			return err
+		}
+		var convErr error
+		if err := Bind(stmt.Left, v, func(ident *ast.Identifier, v Value) {
+			if v, err = Convert(v, types.Default(v.Type())); err != nil {
+				convErr = err
+				return
+			}
+			env.vars[ident.Name] = v
+		}); err != nil {
+			return failure(stmt, err)
+		}
+		if convErr != nil {
+			return failure(stmt.Right, convErr)
+		}
+		return nil
+	case *ast.CompoundAssignment:
+		x, err := ev.ident(env, stmt.Left, stmt.Left.Name)
+		if err != nil {
+			return err
+		}
+		y, err := ev.eval(env, stmt.Right)
+		if err != nil {
+			return err
+		}
+		op := strings.TrimSuffix(stmt.Operator.String(), "=")
+		v, err := binary(op, x, y)
+		if err != nil {
+			return failure(stmt, err)
+		}
+		if !env.assign(stmt.Left.Name, v) {
+			return notConstant(stmt, "%s is not a compile-time constant", stmt.Left.Name)
+		}
+		return nil
+	case *ast.FunctionDeclaration:
+		if stmt.Type != nil && stmt.Type.HasSideEffects {
+			return nil
+		}
+		env.vars[stmt.LHS.Name.Name] = &Func{Decl: stmt, env: env}
+		return nil
+	case ast.Expression:
+		_, err := ev.eval(env, stmt)
+		return err
+	}
+	return notConstant(stmt, "%s is not evaluated at compile time", stmt)
+}
+
+func (ev *Evaluator) ifExpr(env *env, e *ast.IfExpression) (Value, error) {
+	for i, cond := range e.Conditions {
+		expr, ok := cond.(ast.Expression)
+		if !ok {
+			return nil, notConstant(cond, "%s is not a compile-time constant", cond)
+		}
+		v, err := ev.eval(env, expr)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(Bool)
+		if !ok {
+			return nil, failure(cond, fmt.Errorf("non-Bool %s used as condition", v))
+		}
+		if b {
+			return ev.block(env, e.Blocks[i])
+		}
+	}
+	if e.HasElse {
+		return ev.block(env, e.Blocks[len(e.Blocks)-1])
+	}
+	return Nil{}, nil
+}
+
+// call evaluates a call to a pure function.
+func (ev *Evaluator) call(env *env, e *ast.FunctionCall) (Value, error) {
+	if e.FunctionBlock != nil || e.Arguments != nil && e.Arguments.PartialApplication {
+		return nil, notConstant(e, "%s is not evaluated at compile time", e)
+	}
+	if name, v := ev.resolver.Builtin(e); v != nil {
+		return v, nil
+	} else if name != "" {
+		return ev.builtin(env, e, name)
+	}
+
+	var callee Value
+	var recv Value
+	var recvExpr ast.Expression
+	switch fn := e.Function.(type) {
+	case *ast.MemberAccess:
+		object, ok := fn.Object.(ast.Expression)
+		member, isIdent := fn.Member.(*ast.Identifier)
+		if !ok || !isIdent {
+			return nil, notConstant(e, "%s is not evaluated at compile time", e)
+		}
+		v, err := ev.eval(env, object)
+		if err != nil {
+			return nil, err
+		}
+		if tuple, ok := v.(*Tuple); ok && tuple.FieldIndex(member.Name) >= 0 {
+			callee = tuple.Fields[tuple.FieldIndex(member.Name)].Value
+		} else {
+			// uniform function call syntax: recv.f(args) calls f(recv, args)
+			recv, recvExpr = v, object
+			if callee, err = ev.ident(env, member, member.Name); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		var err error
+		if callee, err = ev.eval(env, e.Function); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := ev.resolver.Overload(e); ok {
+		if v == nil {
+			return nil, notConstant(e, "%s is not a pure function", e.Function)
+		}
+		callee = v
+	}
+	fn, ok := callee.(*Func)
+	if !ok {
+		return nil, notConstant(e, "%s is not a pure function", e.Function)
+	}
+
+	var params []ast.FunctionTypeParameter
+	if fn.Decl.Type != nil {
+		params = fn.Decl.Type.Parameters
+	}
+	call := bind.NewCall(e, recvExpr, fn.String())
+	b, errs := bind.Bind(bind.Params(fn.Sig, params), call)
+	if len(errs) > 0 {
+		return nil, failure(errs[0].Node, errs[0])
+	}
+
+	// the arguments are evaluated in the order written
+	values := map[*ast.Argument]Value{}
+	var written []*ast.Argument
+	if recv != nil {
+		values[call.Positional[0]] = recv
+	}
+	written = append(written, call.Positional...)
+	for _, arg := range call.Labeled {
+		written = append(written, arg.Argument)
+	}
+	for _, arg := range written {
+		if _, ok := values[arg]; ok {
+			continue
+		}
+		v, err := ev.eval(env, arg.Expr)
+		if err != nil {
+			return nil, err
+		}
+		values[arg] = v
+	}
+	args := make([][]Value, len(b.Args))
+	for i, bound := range b.Args {
+		for _, arg := range bound {
+			v := values[arg]
+			if !arg.Spread {
+				args[i] = append(args[i], v)
+				continue
+			}
+			array, ok := v.(*Array)
+			if !ok {
+				return nil, notConstant(arg, "spread of %s is not evaluated at compile time", v)
+			}
+			args[i] = append(args[i], array.Elems...)
+		}
+	}
+	return ev.apply(e, fn, b, args)
+}
+
+// builtin evaluates a call of the builtin function name with a single
+// argument, written either as name(x) or x.name().
+func (ev *Evaluator) builtin(env *env, e *ast.FunctionCall, name string) (Value, error) {
+	var arg ast.Expression
+	if member, ok := e.Function.(*ast.MemberAccess); ok {
+		arg, _ = member.Object.(ast.Expression)
+	} else if e.Arguments != nil && e.Arguments.Args != nil && len(e.Arguments.Args.Args) == 1 {
+		arg = e.Arguments.Args.Args[0].Expr
+	}
+	if arg == nil {
+		return nil, notConstant(e, "%s is not evaluated at compile time", e)
+	}
+	v, err := ev.eval(env, arg)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case *Enum:
+		switch name {
+		case "int":
+			return &Int{Val: big.NewInt(v.Member.Value), Typ: types.Int}, nil
+		case "string":
+			return String(v.Member.Name), nil
+		}
+	}
+	return nil, notConstant(e, "%s is not evaluated at compile time", e)
+}
+
+// apply calls fn with the values of the arguments bound to each of its
+// parameters by b. Parameters receiving no argument take their default
+// values, evaluated in the scope fn was declared in.
+func (ev *Evaluator) apply(node ast.Node, fn *Func, b *bind.Binding, args [][]Value) (Value, error) {
+	decl := fn.Decl
+	if decl.Type != nil && decl.Type.HasSideEffects {
+		return nil, notConstant(node, "call of fx %s is not evaluated at compile time", fn)
+	}
+	if decl.Body == nil {
+		return nil, notConstant(node, "%s has no body", fn)
+	}
+	if ev.depth >= maxDepth {
+		return nil, notConstant(node, "evaluation exceeded %d nested calls", maxDepth)
+	}
+	ev.depth++
+	defer func() { ev.depth-- }()
+
+	env := newEnv(fn.env)
+	for i, param := range b.Params {
+		var typ types.Type
+		if fn.Sig != nil && i < len(fn.Sig.Params) {
+			typ = fn.Sig.Params[i].Type
+		}
+		var v Value
+		switch {
+		case param.Rest:
+			if typ == nil {
+				return nil, notConstant(node, "type of rest parameter of %s is not known", fn)
+			}
+			v = &Array{Elems: args[i], Typ: typ}
+		case len(args[i]) > 0:
+			v = args[i][0]
+		case b.Defaults[i] != nil:
+			var err error
+			if v, err = ev.eval(fn.env, b.Defaults[i]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, notConstant(node, "%s is not evaluated at compile time", node)
+		}
+		if typ != nil {
+			var err error
+			if v, err = Convert(v, typ); err != nil {
+				return nil, failure(node, err)
+			}
+		}
+		if param.Name != "" {
+			env.vars[param.Name] = v
+		}
+	}
+
+	v, err := ev.block(env, decl.Body)
+	if err != nil {
+		// the body fails only for these arguments, so the failure is
+		// reported at the call rather than in the body
+		if err, ok := err.(*Error); ok && !err.NotConstant {
+			return nil, &Error{Node: node, Msg: err.Msg}
+		}
+		return nil, err
+	}
+	if fn.Sig != nil && fn.Sig.Result != nil {
+		if v, err = Convert(v, fn.Sig.Result); err != nil {
+			return nil, failure(node, err)
+		}
+	}
+	return v, nil
+}
+
+// construct evaluates a call of a tuple type, such as P(1, "b"), or the
+// conversion of an Int to an enum type, such as Fruit(1), or of an integer
+// to an integer type, such as Int8(n).
+func (ev *Evaluator) construct(env *env, e *ast.TypeConstructorCall) (Value, error) {
+	typ := ev.resolver.TypeOf(e)
+	if enum := enumResult(typ); enum != nil {
+		return ev.enumConversion(env, e, enum)
+	}
+	if typ != nil && types.IsInteger(typ) {
+		return ev.conversion(env, e, typ)
+	}
+	var tupleType *types.Tuple
+	if typ != nil {
+		tupleType, _ = typ.Underlying().(*types.Tuple)
+	}
+	if tupleType == nil || e.FunctionBlock != nil || e.Arguments == nil {
+		return nil, notConstant(e, "%s is not evaluated at compile time", e)
+	}
+	fields := make([]Field, len(tupleType.Fields))
+	set := make([]bool, len(fields))
+	next := 0
+	assign := func(node ast.Node, i int, expr ast.Expression) error {
+		v, err := ev.eval(env, expr)
+		if err != nil {
+			return err
+		}
+		if v, err = Convert(v, tupleType.Fields[i].Type); err != nil {
+			return failure(node, err)
+		}
+		fields[i], set[i] = Field{Name: tupleType.Fields[i].Name, Value: v}, true
+		return nil
+	}
+	if e.Arguments.Args != nil {
+		for _, arg := range e.Arguments.Args.Args {
+			if arg.Spread || next >= len(fields) {
+				return nil, notConstant(e, "%s is not evaluated at compile time", e)
+			}
+			if err := assign(arg, next, arg.Expr); err != nil {
+				return nil, err
+			}
+			next++
+		}
+	}
+	if e.Arguments.LabeledArgs != nil {
+		for _, arg := range e.Arguments.LabeledArgs.Args {
+			i := tupleType.FieldIndex(arg.Identifier.Name)
+			if i < 0 {
+				return nil, notConstant(e, "%s is not evaluated at compile time", e)
+			}
+			if err := assign(arg, i, arg.Argument.Expr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := range set {
+		if !set[i] {
+			return nil, notConstant(e, "%s is not evaluated at compile time", e)
+		}
+	}
+	return &Tuple{Fields: fields, Typ: typ}, nil
+}
+
+// enumResult returns the enum type converted to if typ is the type of a
+// conversion to an enum, the enum type or nil, and nil otherwise.
+func enumResult(typ types.Type) types.Type {
+	if u, ok := typ.(*types.Union); ok {
+		for _, m := range u.Members {
+			if types.IsEnum(m) {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// enumConversion evaluates the conversion of an Int to the enum type typ,
+// which is nil if no member has the value.
+func (ev *Evaluator) enumConversion(env *env, e *ast.TypeConstructorCall, typ types.Type) (Value, error) {
+	if e.Arguments == nil || e.Arguments.Args == nil || len(e.Arguments.Args.Args) != 1 {
+		return nil, notConstant(e, "%s is not evaluated at compile time", e)
+	}
+	v, err := ev.eval(env, e.Arguments.Args.Args[0].Expr)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := v.(*Int)
+	if !ok {
+		return nil, failure(e, fmt.Errorf("cannot convert %s to %s", v, typ))
+	}
+	if x, ok := n.Int64(); ok {
+		if member := typ.Underlying().(*types.Enum).MemberOf(x); member != nil {
+			return &Enum{Typ: typ, Member: member}, nil
+		}
+	}
+	return Nil{}, nil
+}
+
+// conversion evaluates the conversion of an integer to the integer type
+// typ, which fails if the value does not fit.
+func (ev *Evaluator) conversion(env *env, e *ast.TypeConstructorCall, typ types.Type) (Value, error) {
+	if e.Arguments == nil || e.Arguments.Args == nil || len(e.Arguments.Args.Args) != 1 {
+		return nil, notConstant(e, "%s is not evaluated at compile time", e)
+	}
+	arg := e.Arguments.Args.Args[0].Expr
+	v, err := ev.eval(env, arg)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = Convert(v, typ); err != nil {
+		return nil, failure(arg, err)
+	}
+	return v, nil
+}
+
+func (ev *Evaluator) memberAccess(env *env, e *ast.MemberAccess) (Value, error) {
+	if _, ok := e.Object.(*ast.TypeIdentifier); ok {
+		// a member of an enum type, such as Fruit.apple
+		if typ := ev.resolver.TypeOf(e); typ != nil {
+			if enum, ok := typ.Underlying().(*types.Enum); ok {
+				if member, ok := e.Member.(*ast.Identifier); ok && enum.Member(member.Name) != nil {
+					return &Enum{Typ: typ, Member: enum.Member(member.Name)}, nil
+				}
+			}
+		}
+	}
+	object, ok := e.Object.(ast.Expression)
+	if !ok {
+		return nil, notConstant(e, "%s is not a compile-time constant", e)
+	}
+	v, err := ev.eval(env, object)
+	if err != nil {
+		return nil, err
+	}
+	tuple, ok := v.(*Tuple)
+	if !ok {
+		return nil, notConstant(e, "%s is not a compile-time constant", e)
+	}
+	switch member := e.Member.(type) {
+	case *ast.Identifier:
+		if i := tuple.FieldIndex(member.Name); i >= 0 {
+			return tuple.Fields[i].Value, nil
+		}
+		return nil, failure(member, fmt.Errorf("%s has no field %s", tuple, member.Name))
+	case *ast.IntegerLiteral:
+		if i := member.IntegerValue; i >= 0 && i < int64(len(tuple.Fields)) {
+			return tuple.Fields[i].Value, nil
+		}
+		return nil, failure(member, fmt.Errorf("index %d out of range for %s", member.IntegerValue, tuple))
+	}
+	return nil, notConstant(e, "%s is not a compile-time constant", e)
+}
+
+func (ev *Evaluator) index(env *env, node ast.Node, object ast.Expression, index ast.Expression) (Value, error) {
+	if _, ok := index.(*ast.Range); ok {
+		return nil, notConstant(node, "slices are not evaluated at compile time")
+	}
+	x, i, err := ev.operands(env, object, index)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := i.(*Int)
+	if !ok {
+		return nil, failure(index, fmt.Errorf("invalid index %s", i))
+	}
+	var length int
+	switch x := x.(type) {
+	case *Array:
+		length = len(x.Elems)
+	case *Tuple:
+		length = len(x.Fields)
+	case String:
+		length = len(x)
+	default:
+		return nil, notConstant(node, "%s is not a compile-time constant", node)
+	}
+	if n.Val.Sign() < 0 || !n.Val.IsInt64() || n.Val.Int64() >= int64(length) {
+		return nil, failure(index, fmt.Errorf("index %s out of range [0:%d]", n, length))
+	}
+	switch x := x.(type) {
+	case *Array:
+		return x.Elems[n.Val.Int64()], nil
+	case *Tuple:
+		return x.Fields[n.Val.Int64()].Value, nil
+	case String:
+		return &Int{Val: big.NewInt(int64(x[n.Val.Int64()])), Typ: types.Byte}, nil
+	}
+	return nil, notConstant(node, "%s is not a compile-time constant", node)
+}
+
+// typeof returns the descriptor of the type described by e. When the
+// type is only known at run time, such as for a variable of a union type,
+// it is the type of the operand's value.
+func (ev *Evaluator) typeof(env *env, e *ast.TypeofExpression) (Value, error) {
+	if typ := ev.resolver.Described(e); typ != nil {
+		return &TypeValue{Typ: typ}, nil
+	}
+	if e.Expression == nil {
+		return nil, notConstant(e, "%s is not a compile-time constant", e)
+	}
+	v, err := ev.eval(env, e.Expression)
+	if err != nil {
+		return nil, err
+	}
+	typ := types.Default(v.Type())
+	if types.IsInvalid(typ) {
+		return nil, notConstant(e, "%s is not a compile-time constant", e)
+	}
+	return &TypeValue{Typ: typ}, nil
+}
+
+func (ev *Evaluator) tupleUpdate(env *env, e *ast.TupleUpdateExpression) (Value, error) {
+	v, err := ev.eval(env, e.Object)
+	if err != nil {
+		return nil, err
+	}
+	tuple, ok := v.(*Tuple)
+	if !ok {
+		return nil, notConstant(e, "%s is not a compile-time constant", e)
+	}
+	updated := &Tuple{Fields: append([]Field(nil), tuple.Fields...), Typ: tuple.Typ}
+	for _, member := range e.Update.Members {
+		if member.Label == nil {
+			return nil, notConstant(e, "%s is not a compile-time constant", e)
+		}
+		i := updated.FieldIndex(member.Label.Name)
+		if i < 0 {
+			return nil, failure(member.Label, fmt.Errorf("%s has no field %s", tuple, member.Label.Name))
+		}
+		v, err := ev.eval(env, member.Value)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = Convert(v, updated.Fields[i].Value.Type()); err != nil {
+			return nil, failure(member.Value, err)
+		}
+		updated.Fields[i].Value = v
+	}
+	return updated, nil
+}
+
+// Bind destructures v according to the left-hand side of an assignment and
+// calls bind for each identifier with the part of v it receives. It is an
+// error if v does not have the shape the left-hand side expects.
+func Bind(lhs ast.AssignmentLHS, v Value, bind func(*ast.Identifier, Value)) error {
+	bindOne := func(ident *ast.Identifier, v Value) {
+		if ident != nil && ident.Name != "_" {
+			bind(ident, v)
+		}
+	}
+
+	switch lhs := lhs.(type) {
+	case *ast.OrdinalAssignmentLHS:
+		if len(lhs.Identifiers) == 1 && lhs.RestOperator == nil {
+			bindOne(lhs.Identifiers[0], v)
+			return nil
+		}
+		tuple, ok := v.(*Tuple)
+		n := len(lhs.Identifiers)
+		if !ok || len(tuple.Fields) < n || lhs.RestOperator == nil && len(tuple.Fields) != n {
+			return fmt.Errorf("cannot destructure %s into %d variables", v, n)
+		}
+		for i, ident := range lhs.Identifiers {
+			bindOne(ident, tuple.Fields[i].Value)
+		}
+		if lhs.RestOperator != nil && lhs.RestOperator.Identifier != nil {
+			bind(lhs.RestOperator.Identifier, &Tuple{Fields: tuple.Fields[n:]})
+		}
+	case *ast.LabeledAssignmentLHS:
+		tuple, ok := v.(*Tuple)
+		if !ok {
+			return fmt.Errorf("cannot destructure %s: not a tuple", v)
+		}
+		labeled := false
+		for _, field := range tuple.Fields {
+			labeled = labeled || field.Name != ""
+		}
+		for i, rename := range lhs.Renames {
+			rename, ok := rename.(*ast.RenameIdentifier)
+			if !ok {
+				continue
+			}
+			name := rename.Identifier.Name
+			if rename.Original != nil {
+				name = rename.Original.Name
+			}
+			index := tuple.FieldIndex(name)
+			if !labeled && index < 0 {
+				// unlabeled tuples are destructured by position
+				index = i
+			}
+			if index < 0 || index >= len(tuple.Fields) {
+				return fmt.Errorf("%s has no field %s", tuple, name)
+			}
+			bindOne(rename.Identifier, tuple.Fields[index].Value)
+		}
+	default:
+		return fmt.Errorf("cannot destructure %s", v)
+	}
+	return nil
+}
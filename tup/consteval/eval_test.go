@@ -0,0 +1,289 @@
+package consteval
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+	"github.com/rowland/tuppence/tup/tok"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// testResolver resolves the names declared by a module, evaluating the
+// right-hand sides of its assignments on demand.
+type testResolver struct {
+	ev     *Evaluator
+	values map[string]Value
+	decls  map[string]ast.Node
+}
+
+func (r *testResolver) Lookup(name string) Value {
+	if v, ok := r.values[name]; ok {
+		return v
+	}
+	switch decl := r.decls[name].(type) {
+	case *ast.FunctionDeclaration:
+		return &Func{Decl: decl}
+	case *ast.Assignment:
+		v, err := r.ev.Eval(decl.Right)
+		if err != nil {
+			return nil
+		}
+		r.values[name] = v
+		return v
+	}
+	return nil
+}
+
+func (r *testResolver) TypeOf(expr ast.Expression) types.Type {
+	return nil
+}
+
+func (r *testResolver) Builtin(call *ast.FunctionCall) (string, Value) {
+	return "", nil
+}
+
+func (r *testResolver) Described(e *ast.TypeofExpression) types.Type {
+	return nil
+}
+
+func (r *testResolver) Overload(call *ast.FunctionCall) (Value, bool) {
+	return nil, false
+}
+
+func (r *testResolver) Processor(lit *ast.MultiLineStringLiteral) *ast.FunctionCall {
+	return nil
+}
+
+// newTestEvaluator returns an evaluator for the declarations in decls.
+// The names max8 and max hold the largest Int8 and Int values.
+func newTestEvaluator(t *testing.T, decls string) *Evaluator {
+	t.Helper()
+	module, err := parse.Module(source.NewSource([]byte(decls), "test.tup"), ast.NewModule("test"))
+	if err != nil {
+		t.Fatalf("parse.Module(%q) = %v", decls, err)
+	}
+	r := &testResolver{
+		values: map[string]Value{
+			"max8": &Int{Val: big.NewInt(math.MaxInt8), Typ: types.Typ[types.Int8]},
+			"max":  &Int{Val: big.NewInt(math.MaxInt64), Typ: types.Int},
+			"half": &Float{Val: 0.5, Typ: types.Float},
+		},
+		decls: map[string]ast.Node{},
+	}
+	for _, item := range module.TopLevelItems {
+		switch item := item.(type) {
+		case *ast.FunctionDeclaration:
+			r.decls[item.LHS.Name.Name] = item
+		case *ast.Assignment:
+			for _, ident := range item.Left.(*ast.OrdinalAssignmentLHS).Identifiers {
+				r.decls[ident.Name] = item
+			}
+		}
+	}
+	r.ev = NewEvaluator(r)
+	return r.ev
+}
+
+func parseExpression(t *testing.T, input string) ast.Expression {
+	t.Helper()
+	tokens, err := tok.Tokenize([]byte(input), "test.tup")
+	if err != nil {
+		t.Fatalf("Tokenize(%q) = %v", input, err)
+	}
+	expr, _, err := parse.Expression(tokens)
+	if err != nil {
+		t.Fatalf("Expression(%q) = %v", input, err)
+	}
+	return expr
+}
+
+const testDecls = `
+fact = fn(n: Int) Int {
+	if n <= 1 { 1 } else { n * fact(n - 1) }
+}
+fib = fn(n: Int) Int {
+	if n < 2 { n } else { fib(n - 1) + fib(n - 2) }
+}
+sum = fn(a: Int, b: Int) Int { a + b }
+greet = fn(name: String, greeting: "Hello") String { greeting + ", " + name }
+swap = fn(x: Int, y: Int) _ {
+	a, b = (x, y)
+	(b, a)
+}
+twice = fn(n: Int) Int {
+	double = fn(x: Int) Int { x * 2 }
+	double(double(n))
+}
+count = fn(n: Int) Int {
+	total = mut 0
+	total += n
+	total += n
+	total
+}
+forever = fn(n: Int) Int { forever(n + 1) }
+log = fx(s: String) String { s }
+point = (x: 1, y: 2)
+`
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"integer", "42", "42"},
+		{"float", "1.5", "1.5"},
+		{"string", `"hi"`, `"hi"`},
+		{"symbol", ":ok", ":ok"},
+		{"boolean", "true", "true"},
+		{"addition", "1 + 2", "3"},
+		{"precedence", "1 + 2 * 3", "7"},
+		{"integer division", "7 / 2", "3"},
+		{"modulo", "7 % 3", "1"},
+		{"float division", "7.0 / 2", "3.5"},
+		{"exponent", "2 ^ 10", "1024"},
+		{"exponent groups right", "2 ^ 3 ^ 2", "512"},
+		{"bitwise", "6 & 3 | 8", "10"},
+		{"shift", "1 << 10", "1024"},
+		{"untyped values are exact", "1 << 100", "1267650600228229401496703205376"},
+		{"negation", "-5", "-5"},
+		{"bitwise not", "~0", "-1"},
+		{"logical not", "!true", "false"},
+		{"string concatenation", `"a" + "b" + "c"`, `"abc"`},
+		{"interpolation", `"\(1 + 1) apples"`, `"2 apples"`},
+		{"multi-line string", "```\n    \\(1 + 1)\n    apples\n```", `"2\napples\n"`},
+		{"comparison", "1 < 2", "true"},
+		{"string comparison", `"a" < "b"`, "true"},
+		{"compare", "3 <=> 2", "1"},
+		{"equality of tuples", "(1, 2) == (1, 2)", "true"},
+		{"short circuit", "false && forever(1) == 1", "false"},
+		{"tuple literal", "(a: 1, b: 2 * 3)", "(a: 1, b: 6)"},
+		{"spread", "(...point, z: 3)", "(x: 1, y: 2, z: 3)"},
+		{"array literal", "[1, 2, 3]", "[1, 2, 3]"},
+		{"member access", "point.y", "2"},
+		{"ordinal member access", "point.0", "1"},
+		{"index", "[10, 20, 30][2]", "30"},
+		{"string index", `"abc"[1]`, "98"},
+		{"tuple update", "point.(x: 10)", "(x: 10, y: 2)"},
+		{"if", "if 1 > 2 { :a } else if 2 > 1 { :b } else { :c }", ":b"},
+		{"if without else", "if false { 1 }", "nil"},
+		{"block", "{ a = 2; b = a * 3; a + b }", "8"},
+		{"call", "sum(1, 2)", "3"},
+		{"labeled call", "sum(b: 1, a: 2)", "3"},
+		{"recursion", "fact(10)", "3628800"},
+		{"default argument", `greet("Bob")`, `"Hello, Bob"`},
+		{"overridden default", `greet("Bob", greeting: "Hi")`, `"Hi, Bob"`},
+		{"destructuring", "swap(1, 2)", "(2, 1)"},
+		{"nested function", "twice(3)", "12"},
+		{"compound assignment", "count(4)", "8"},
+		{"uniform function call syntax", "3.sum(4)", "7"},
+		{"typed arithmetic", "max8 - 27", "100"},
+		{"typed float", "half * 3", "1.5"},
+		{"checked addition", "max ?+ 1", `error("integer overflow")`},
+		{"checked multiplication", "max8 ?* 2", `error("integer overflow")`},
+		{"checked division by zero", "1 ?/ 0", `error("division by zero")`},
+		{"checked addition in range", "max8 ?+ 0", "127"},
+		{"typeof", "typeof(point)", "typeof((x: Int, y: Int))"},
+		{"typeof comparison", "typeof(1) == typeof(point.x)", "true"},
+		{"typeof mismatch", `typeof(1) == typeof("a")`, "false"},
+		{"checked error propagates", "(max ?+ 1) + 1", `error("integer overflow")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := newTestEvaluator(t, testDecls)
+			got, err := ev.Eval(parseExpression(t, tt.input))
+			if err != nil {
+				t.Fatalf("Eval(%q) = %v", tt.input, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Eval(%q) = %s, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		name            string
+		input           string
+		wantErr         string
+		wantNotConstant bool
+	}{
+		{"overflow", "max + 1", "constant 9223372036854775808 overflows Int", false},
+		{"Int8 overflow", "max8 * 2", "constant 254 overflows Int8", false},
+		{"negation overflow", "-max8 - 2", "constant -129 overflows Int8", false},
+		{"untyped overflow", "2 ^ 1000", "constant 2 overflows", false},
+		{"division by zero", "1 / 0", "division by zero", false},
+		{"modulo by zero", "1 % 0", "division by zero", false},
+		{"float division by zero", "1.0 / 0", "division by zero", false},
+		{"float truncated", "max + 0.5", "constant 0.5 truncated to Int", false},
+		{"negative shift", "1 << -1", "negative shift count -1", false},
+		{"index out of range", "[1, 2][2]", "index 2 out of range [0:2]", false},
+		{"missing field", "point.z", "(x: 1, y: 2) has no field z", false},
+		{"overflow in call", "fact(max8 - 120)", "constant 720 overflows Int8", false},
+		{"too many arguments", "sum(1, 2, 3)", "too many arguments in call to sum", false},
+		{"missing argument", "sum(1)", "missing argument for parameter b in call to sum", false},
+		{"unknown name", "x + 1", "x is not a compile-time constant", true},
+		{"side effects", `log("hi")`, "call of fx log is not evaluated at compile time", true},
+		{"unbounded recursion", "forever(1)", "evaluation exceeded 256 nested calls", true},
+		{"step budget", "fib(30)", "evaluation exceeded 100000 steps", true},
+		{"for loop", "for i in 1..3 { i }", "is not a compile-time constant", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := newTestEvaluator(t, testDecls)
+			got, err := ev.Eval(parseExpression(t, tt.input))
+			if err == nil {
+				t.Fatalf("Eval(%q) = %s, want error containing %q", tt.input, got, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Eval(%q) = %v, want error containing %q", tt.input, err, tt.wantErr)
+			}
+			if err, ok := err.(*Error); !ok || err.NotConstant != tt.wantNotConstant {
+				t.Errorf("Eval(%q) = %#v, want NotConstant %v", tt.input, err, tt.wantNotConstant)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   Value
+		typ     types.Type
+		want    string
+		wantErr string
+	}{
+		{"untyped to Int8", NewInt(100), types.Typ[types.Int8], "100", ""},
+		{"untyped overflows Int8", NewInt(200), types.Typ[types.Int8], "", "constant 200 overflows Int8"},
+		{"negative to unsigned", NewInt(-1), types.UInt, "", "constant -1 overflows UInt"},
+		{"untyped to Float", NewInt(2), types.Float, "2.0", ""},
+		{"integral float to Int", NewFloat(3), types.Int, "3", ""},
+		{"fraction to Int", NewFloat(3.5), types.Int, "", "constant 3.5 truncated to Int"},
+		{"Float32 overflow", NewFloat(1e300), types.Typ[types.Float32], "", "overflows Float32"},
+		{"default type", NewInt(1), types.Typ[types.String], "1", ""},
+		{"non-numeric", String("s"), types.Int, `"s"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Convert(tt.value, tt.typ)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Convert(%s, %s) = %v, want error containing %q", tt.value, tt.typ, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Convert(%s, %s) = %v", tt.value, tt.typ, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Convert(%s, %s) = %s, want %s", tt.value, tt.typ, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,470 @@
+package consteval
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// maxUntypedBits bounds the size of untyped integer values, which are
+// otherwise exact.
+const maxUntypedBits = 512
+
+// maxShift bounds the shift count of << and >>.
+const maxShift = 1024
+
+// opError is an error from an operation on values. Checked operators
+// return overflows and divisions by zero as error values instead of
+// reporting them.
+type opError struct {
+	msg       string
+	checkable bool
+	// runtime is the message of a checkable error as a running program
+	// reports it, which does not show the value that overflowed
+	runtime string
+}
+
+func (err *opError) Error() string { return err.msg }
+
+func errorf(format string, args ...any) *opError {
+	return &opError{msg: fmt.Sprintf(format, args...)}
+}
+
+func overflow(x fmt.Stringer, t types.Type) *opError {
+	runtime := "integer overflow"
+	if _, ok := x.(*Float); ok {
+		runtime = "floating-point overflow"
+	}
+	if types.IsUntyped(t) {
+		return &opError{msg: fmt.Sprintf("constant %s overflows", x), checkable: true, runtime: runtime}
+	}
+	return &opError{msg: fmt.Sprintf("constant %s overflows %s", x, t), checkable: true, runtime: runtime}
+}
+
+var errDivisionByZero = &opError{msg: "division by zero", checkable: true, runtime: "division by zero"}
+
+// RuntimeMessage returns the message with which a running program reports
+// err, an error from an operation on values. Overflows are reported as
+// the compiled program reports them, without the value that overflowed.
+func RuntimeMessage(err error) string {
+	if err, ok := err.(*opError); ok && err.runtime != "" {
+		return err.runtime
+	}
+	return err.Error()
+}
+
+func kindOf(t types.Type) types.BasicKind {
+	if t == nil {
+		return types.Invalid
+	}
+	if basic, ok := t.Underlying().(*types.Basic); ok {
+		return basic.Kind()
+	}
+	return types.Invalid
+}
+
+// intBounds holds the smallest and largest values of the sized integer kinds.
+var intBounds = map[types.BasicKind][2]*big.Int{}
+
+func init() {
+	signed := map[types.BasicKind]uint{types.Int8: 8, types.Int16: 16, types.Int32: 32, types.Int64: 64}
+	for kind, bits := range signed {
+		max := new(big.Int).Lsh(big.NewInt(1), bits-1)
+		min := new(big.Int).Neg(max)
+		intBounds[kind] = [2]*big.Int{min, max.Sub(max, big.NewInt(1))}
+	}
+	unsigned := map[types.BasicKind]uint{types.UInt8: 8, types.UInt16: 16, types.UInt32: 32, types.UInt64: 64}
+	for kind, bits := range unsigned {
+		max := new(big.Int).Lsh(big.NewInt(1), bits)
+		intBounds[kind] = [2]*big.Int{big.NewInt(0), max.Sub(max, big.NewInt(1))}
+	}
+}
+
+// Bounds returns the smallest and largest values of the sized integer type
+// t, or false if t is not one.
+func Bounds(t types.Type) (min, max *Int, ok bool) {
+	bounds, ok := intBounds[kindOf(t)]
+	if !ok {
+		return nil, nil, false
+	}
+	return &Int{Val: new(big.Int).Set(bounds[0]), Typ: t}, &Int{Val: new(big.Int).Set(bounds[1]), Typ: t}, true
+}
+
+// fitsInt reports whether x is representable as a value of type t.
+func fitsInt(x *big.Int, t types.Type) bool {
+	if bounds, ok := intBounds[kindOf(t)]; ok {
+		return x.Cmp(bounds[0]) >= 0 && x.Cmp(bounds[1]) <= 0
+	}
+	return x.BitLen() <= maxUntypedBits
+}
+
+// fitsFloat reports whether x is representable as a value of type t.
+func fitsFloat(x float64, t types.Type) bool {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return false
+	}
+	switch kindOf(t) {
+	case types.Float16:
+		return math.Abs(x) <= 65504
+	case types.Float32:
+		return math.Abs(x) <= math.MaxFloat32
+	}
+	return true
+}
+
+func newInt(x *big.Int, t types.Type) (Value, error) {
+	v := &Int{Val: x, Typ: t}
+	if !fitsInt(x, t) {
+		return nil, overflow(v, t)
+	}
+	return v, nil
+}
+
+func newFloat(x float64, t types.Type) (Value, error) {
+	v := &Float{Val: x, Typ: t}
+	if !fitsFloat(x, t) {
+		return nil, overflow(v, t)
+	}
+	return v, nil
+}
+
+// Convert returns v converted to type t. Untyped values are given type t,
+// or their default type if t is not a numeric type. It is an error if the
+// value cannot be represented by t.
+func Convert(v Value, t types.Type) (Value, error) {
+	switch v := v.(type) {
+	case *Int:
+		switch {
+		case types.IsInteger(t):
+			return newInt(v.Val, t)
+		case types.IsFloat(t):
+			f, _ := new(big.Float).SetInt(v.Val).Float64()
+			return newFloat(f, t)
+		case types.IsUntyped(v.Typ):
+			return newInt(v.Val, types.Int)
+		}
+	case *Float:
+		switch {
+		case types.IsFloat(t):
+			return newFloat(v.Val, t)
+		case types.IsInteger(t):
+			if v.Val != math.Trunc(v.Val) || math.IsInf(v.Val, 0) {
+				return nil, errorf("constant %s truncated to %s", v, t)
+			}
+			x, _ := big.NewFloat(v.Val).Int(nil)
+			return newInt(x, t)
+		case types.IsUntyped(v.Typ):
+			return newFloat(v.Val, types.Float)
+		}
+	}
+	return v, nil
+}
+
+// numericType returns the type that numbers x and y are converted to when
+// they are combined by a binary operator.
+func numericType(x, y Value) (types.Type, error) {
+	xt, yt := x.Type(), y.Type()
+	switch {
+	case types.IsUntyped(xt) && types.IsUntyped(yt):
+		if types.IsFloat(xt) || types.IsFloat(yt) {
+			return types.Typ[types.UntypedFloat], nil
+		}
+		return types.Typ[types.UntypedInt], nil
+	case types.IsUntyped(xt):
+		return yt, nil
+	case types.IsUntyped(yt), types.Identical(xt, yt):
+		return xt, nil
+	}
+	return nil, errorf("mismatched types %s and %s", xt, yt)
+}
+
+func isNumber(v Value) bool {
+	switch v.(type) {
+	case *Int, *Float:
+		return true
+	}
+	return false
+}
+
+// Binary applies the arithmetic or bitwise operator op to x and y, as the
+// evaluator does at compile time. An error value operand, produced by a
+// checked operator, propagates to the result. A checked operator, written
+// with a leading "?", returns an *ErrorValue in place of a result that
+// overflows or divides by zero, holding the message a running program
+// reports, so that a folded result is the value the program computes.
+func Binary(op string, x, y Value) (Value, error) {
+	for _, v := range []Value{x, y} {
+		if v, ok := v.(*ErrorValue); ok {
+			return v, nil
+		}
+	}
+	checked := strings.HasPrefix(op, "?")
+	if checked && types.IsUntyped(x.Type()) && types.IsUntyped(y.Type()) {
+		// checked operators report overflow of the type the result will
+		// have, so untyped operands take their default type
+		var err error
+		if x, err = Convert(x, types.Default(x.Type())); err != nil {
+			return nil, err
+		}
+	}
+	v, err := binary(strings.TrimPrefix(op, "?"), x, y)
+	if err != nil {
+		if err, ok := err.(*opError); ok && err.checkable && checked {
+			return &ErrorValue{Msg: err.runtime}, nil
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+// binary applies an arithmetic or bitwise operator to x and y. Checked
+// operators are given without their leading "?".
+func binary(op string, x, y Value) (Value, error) {
+	if x, ok := x.(*Array); ok && op == "<<" {
+		// appending to an array yields a new array
+		if elem, ok := x.Typ.Underlying().(*types.Array); ok {
+			var err error
+			if y, err = Convert(y, elem.Elem); err != nil {
+				return nil, err
+			}
+		}
+		elems := append(x.Elems[:len(x.Elems):len(x.Elems)], y)
+		return &Array{Elems: elems, Typ: x.Typ}, nil
+	}
+	if x, ok := x.(*Enum); ok {
+		// the difference between members of an enum is an Int
+		if y, ok := y.(*Enum); ok && op == "-" && types.Identical(x.Typ, y.Typ) {
+			return newInt(big.NewInt(0).Sub(big.NewInt(x.Member.Value), big.NewInt(y.Member.Value)), types.Int)
+		}
+	}
+	if op == "+" {
+		if x, ok := x.(String); ok {
+			if y, ok := y.(String); ok {
+				return x + y, nil
+			}
+		}
+	}
+	if !isNumber(x) || !isNumber(y) {
+		return nil, errorf("operator %s not defined on %s", op, x.Type())
+	}
+	t, err := numericType(x, y)
+	if err != nil {
+		return nil, err
+	}
+	if x, err = Convert(x, t); err != nil {
+		return nil, err
+	}
+	if y, err = Convert(y, t); err != nil {
+		return nil, err
+	}
+	if types.IsFloat(t) {
+		return floatOp(op, x.(*Float).Val, y.(*Float).Val, t)
+	}
+	return intOp(op, x.(*Int).Val, y.(*Int).Val, t)
+}
+
+func intOp(op string, x, y *big.Int, t types.Type) (Value, error) {
+	z := new(big.Int)
+	switch op {
+	case "+":
+		z.Add(x, y)
+	case "-":
+		z.Sub(x, y)
+	case "*":
+		z.Mul(x, y)
+	case "/", "%":
+		if y.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		if op == "/" {
+			z.Quo(x, y)
+		} else {
+			z.Rem(x, y)
+		}
+	case "^":
+		if y.Sign() < 0 {
+			return nil, errorf("negative exponent %s", y)
+		}
+		if x.CmpAbs(big.NewInt(1)) > 0 && (!y.IsInt64() || int64(x.BitLen()-1)*y.Int64() > maxUntypedBits) {
+			return nil, overflow(&Int{Val: new(big.Int).Set(x), Typ: t}, t)
+		}
+		z.Exp(x, y, nil)
+	case "&":
+		z.And(x, y)
+	case "|":
+		z.Or(x, y)
+	case "<<", ">>":
+		if y.Sign() < 0 {
+			return nil, errorf("negative shift count %s", y)
+		}
+		if !y.IsInt64() || y.Int64() > maxShift {
+			return nil, errorf("shift count %s too large", y)
+		}
+		if op == "<<" {
+			z.Lsh(x, uint(y.Int64()))
+		} else {
+			z.Rsh(x, uint(y.Int64()))
+		}
+	default:
+		return nil, errorf("operator %s not defined on %s", op, t)
+	}
+	return newInt(z, t)
+}
+
+func floatOp(op string, x, y float64, t types.Type) (Value, error) {
+	var z float64
+	switch op {
+	case "+":
+		z = x + y
+	case "-":
+		z = x - y
+	case "*":
+		z = x * y
+	case "/":
+		if y == 0 {
+			return nil, errDivisionByZero
+		}
+		z = x / y
+	case "%":
+		if y == 0 {
+			return nil, errDivisionByZero
+		}
+		z = math.Mod(x, y)
+	case "^":
+		z = math.Pow(x, y)
+	default:
+		return nil, errorf("operator %s not defined on %s", op, t)
+	}
+	return newFloat(z, t)
+}
+
+// Unary applies the unary operator op to x.
+func Unary(op string, x Value) (Value, error) {
+	return unary(op, x)
+}
+
+// unary applies a unary operator to x.
+func unary(op string, x Value) (Value, error) {
+	switch x := x.(type) {
+	case *Int:
+		switch op {
+		case "+":
+			return x, nil
+		case "-":
+			return newInt(new(big.Int).Neg(x.Val), x.Typ)
+		case "~":
+			if bounds, ok := intBounds[kindOf(x.Typ)]; ok && bounds[0].Sign() == 0 {
+				return newInt(new(big.Int).Xor(x.Val, bounds[1]), x.Typ)
+			}
+			return newInt(new(big.Int).Not(x.Val), x.Typ)
+		}
+	case *Float:
+		switch op {
+		case "+":
+			return x, nil
+		case "-":
+			return newFloat(-x.Val, x.Typ)
+		}
+	case Bool:
+		if op == "!" {
+			return !x, nil
+		}
+	}
+	return nil, errorf("operator %s not defined on %s", op, x.Type())
+}
+
+// Compare returns -1, 0 or +1 as x is less than, equal to or greater than
+// y, or an error if x and y are not ordered.
+func Compare(x, y Value) (int, error) {
+	v, err := compare("<=>", x, y)
+	if err != nil {
+		return 0, err
+	}
+	cmp, _ := v.(*Int).Int64()
+	return int(cmp), nil
+}
+
+// Relation applies the comparison operator op, such as < or ==, to x and
+// y.
+func Relation(op string, x, y Value) (Value, error) {
+	return compare(op, x, y)
+}
+
+// compare applies a comparison operator to x and y.
+func compare(op string, x, y Value) (Value, error) {
+	var cmp int
+	switch {
+	case isNumber(x) && isNumber(y):
+		t, err := numericType(x, y)
+		if err != nil {
+			return nil, err
+		}
+		if x, err = Convert(x, t); err != nil {
+			return nil, err
+		}
+		if y, err = Convert(y, t); err != nil {
+			return nil, err
+		}
+		if types.IsFloat(t) {
+			xf, yf := x.(*Float).Val, y.(*Float).Val
+			switch {
+			case xf < yf:
+				cmp = -1
+			case xf > yf:
+				cmp = 1
+			}
+		} else {
+			cmp = x.(*Int).Val.Cmp(y.(*Int).Val)
+		}
+	case isString(x) && isString(y):
+		cmp = strings.Compare(string(x.(String)), string(y.(String)))
+	case isEnum(x) && isEnum(y) && types.Identical(x.Type(), y.Type()):
+		cmp = cmpInt(x.(*Enum).Member.Value, y.(*Enum).Member.Value)
+	case op == "==":
+		return Bool(Equal(x, y)), nil
+	case op == "!=":
+		return Bool(!Equal(x, y)), nil
+	default:
+		return nil, errorf("operator %s not defined on %s", op, x.Type())
+	}
+	switch op {
+	case "==":
+		return Bool(cmp == 0), nil
+	case "!=":
+		return Bool(cmp != 0), nil
+	case "<":
+		return Bool(cmp < 0), nil
+	case "<=":
+		return Bool(cmp <= 0), nil
+	case ">":
+		return Bool(cmp > 0), nil
+	case ">=":
+		return Bool(cmp >= 0), nil
+	case "<=>":
+		return &Int{Val: big.NewInt(int64(cmp)), Typ: types.Int}, nil
+	}
+	return nil, errorf("operator %s not defined on %s", op, x.Type())
+}
+
+func isString(v Value) bool {
+	_, ok := v.(String)
+	return ok
+}
+
+func isEnum(v Value) bool {
+	_, ok := v.(*Enum)
+	return ok
+}
+
+func cmpInt(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
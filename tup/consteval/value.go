@@ -0,0 +1,259 @@
+package consteval
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Value is a value computed at compile time.
+type Value interface {
+	// Type returns the type of the value. Integer and float values computed
+	// only from literals have an untyped type.
+	Type() types.Type
+	// String returns the value as it would be written in source.
+	String() string
+}
+
+// Int is an integer value. Untyped integers are exact; typed integers are
+// within the range of their type.
+type Int struct {
+	Val *big.Int
+	Typ types.Type
+}
+
+// NewInt returns an untyped integer value.
+func NewInt(x int64) *Int {
+	return &Int{Val: big.NewInt(x), Typ: types.Typ[types.UntypedInt]}
+}
+
+func (v *Int) Type() types.Type { return v.Typ }
+func (v *Int) String() string   { return v.Val.String() }
+
+// Int64 returns the value of v and whether it fits in an int64.
+func (v *Int) Int64() (int64, bool) {
+	return v.Val.Int64(), v.Val.IsInt64()
+}
+
+// Float is a floating-point value.
+type Float struct {
+	Val float64
+	Typ types.Type
+}
+
+// NewFloat returns an untyped float value.
+func NewFloat(x float64) *Float {
+	return &Float{Val: x, Typ: types.Typ[types.UntypedFloat]}
+}
+
+func (v *Float) Type() types.Type { return v.Typ }
+func (v *Float) String() string {
+	s := strconv.FormatFloat(v.Val, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEnN") {
+		s += ".0"
+	}
+	return s
+}
+
+// Bool is a Bool value.
+type Bool bool
+
+func (v Bool) Type() types.Type { return types.Typ[types.Bool] }
+func (v Bool) String() string   { return strconv.FormatBool(bool(v)) }
+
+// String is a String value.
+type String string
+
+func (v String) Type() types.Type { return types.Typ[types.String] }
+func (v String) String() string   { return strconv.Quote(string(v)) }
+
+// Symbol is a Symbol value, held without the leading colon.
+type Symbol string
+
+func (v Symbol) Type() types.Type { return types.Typ[types.Symbol] }
+func (v Symbol) String() string   { return ":" + string(v) }
+
+// Nil is the value nil, produced by blocks and if expressions without a
+// final value.
+type Nil struct{}
+
+func (Nil) Type() types.Type { return types.Typ[types.Nil] }
+func (Nil) String() string   { return "nil" }
+
+// Field is a field of a tuple value. Name is empty for unlabeled fields.
+type Field struct {
+	Name  string
+	Value Value
+}
+
+// Tuple is a tuple value.
+type Tuple struct {
+	Fields []Field
+	// Typ is the named type the tuple was constructed as, or nil if the
+	// tuple was built from a literal.
+	Typ types.Type
+}
+
+func (v *Tuple) Type() types.Type {
+	if v.Typ != nil {
+		return v.Typ
+	}
+	fields := make([]*types.Field, len(v.Fields))
+	for i, field := range v.Fields {
+		fields[i] = types.NewField(field.Name, types.Default(field.Value.Type()))
+	}
+	return types.NewTuple(fields...)
+}
+
+func (v *Tuple) String() string {
+	var builder strings.Builder
+	builder.WriteString("(")
+	for i, field := range v.Fields {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		if field.Name != "" {
+			builder.WriteString(field.Name)
+			builder.WriteString(": ")
+		}
+		builder.WriteString(field.Value.String())
+	}
+	if len(v.Fields) == 1 && v.Fields[0].Name == "" {
+		builder.WriteString(",")
+	}
+	builder.WriteString(")")
+	return builder.String()
+}
+
+// FieldIndex returns the index of the field named name, or -1.
+func (v *Tuple) FieldIndex(name string) int {
+	for i, field := range v.Fields {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Array is an array value.
+type Array struct {
+	Elems []Value
+	Typ   types.Type
+}
+
+func (v *Array) Type() types.Type { return v.Typ }
+func (v *Array) String() string {
+	var builder strings.Builder
+	builder.WriteString("[")
+	for i, elem := range v.Elems {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(elem.String())
+	}
+	builder.WriteString("]")
+	return builder.String()
+}
+
+// ErrorValue is the error returned by checked arithmetic in place of a
+// result that overflows or divides by zero.
+type ErrorValue struct {
+	Msg string
+}
+
+func (v *ErrorValue) Type() types.Type { return types.ErrorType }
+func (v *ErrorValue) String() string   { return "error(" + strconv.Quote(v.Msg) + ")" }
+
+// Func is a pure function that may be called at compile time.
+type Func struct {
+	Decl *ast.FunctionDeclaration
+	// Sig is the checked signature of the function, used to convert
+	// arguments and results. It may be nil.
+	Sig *types.Function
+
+	env *env // the environment the function was declared in
+}
+
+func (v *Func) Type() types.Type {
+	if v.Sig == nil {
+		return types.Typ[types.Invalid]
+	}
+	return v.Sig
+}
+
+func (v *Func) String() string { return v.Decl.LHS.Name.Name }
+
+// Enum is a member of an enum type.
+type Enum struct {
+	Typ    types.Type // the declared enum type
+	Member *types.EnumMember
+}
+
+func (v *Enum) Type() types.Type { return v.Typ }
+func (v *Enum) String() string   { return v.Typ.String() + "." + v.Member.Name }
+
+// TypeValue is a type descriptor, the value of a typeof expression.
+type TypeValue struct {
+	Typ types.Type
+}
+
+func (v *TypeValue) Type() types.Type { return types.Typ[types.TypeDescriptor] }
+func (v *TypeValue) String() string   { return "typeof(" + v.Typ.String() + ")" }
+
+// Equal reports whether x and y are equal values.
+func Equal(x, y Value) bool {
+	switch x := x.(type) {
+	case *Int:
+		switch y := y.(type) {
+		case *Int:
+			return x.Val.Cmp(y.Val) == 0
+		case *Float:
+			return new(big.Float).SetInt(x.Val).Cmp(big.NewFloat(y.Val)) == 0
+		}
+	case *Float:
+		switch y := y.(type) {
+		case *Int:
+			return Equal(y, x)
+		case *Float:
+			return x.Val == y.Val
+		}
+	case *Tuple:
+		y, ok := y.(*Tuple)
+		if !ok || len(x.Fields) != len(y.Fields) {
+			return false
+		}
+		for i := range x.Fields {
+			if x.Fields[i].Name != y.Fields[i].Name || !Equal(x.Fields[i].Value, y.Fields[i].Value) {
+				return false
+			}
+		}
+		return true
+	case *Array:
+		y, ok := y.(*Array)
+		if !ok || len(x.Elems) != len(y.Elems) {
+			return false
+		}
+		for i := range x.Elems {
+			if !Equal(x.Elems[i], y.Elems[i]) {
+				return false
+			}
+		}
+		return true
+	case *ErrorValue:
+		y, ok := y.(*ErrorValue)
+		return ok && x.Msg == y.Msg
+	case *Func:
+		y, ok := y.(*Func)
+		return ok && x.Decl == y.Decl
+	case *Enum:
+		y, ok := y.(*Enum)
+		return ok && types.Identical(x.Typ, y.Typ) && x.Member.Value == y.Member.Value
+	case *TypeValue:
+		y, ok := y.(*TypeValue)
+		return ok && types.Identical(x.Typ, y.Typ)
+	}
+	return x == y
+}
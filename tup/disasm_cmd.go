@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/bytecode"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/spf13/pflag"
+)
+
+// disasmCommand prints the bytecode of a program, compiling it first if it
+// is given as source code:
+//
+//	tup disasm file.tup|file.tupc
+func disasmCommand(args []string) error {
+	flags := pflag.NewFlagSet("disasm", pflag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: tup disasm file.tup|file.tupc")
+	}
+	p, err := loadProgram(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Print(bytecode.Disasm(p))
+	return nil
+}
+
+// loadProgram reads the bytecode program in a .tupc file, or compiles the
+// module in any other file.
+func loadProgram(filename string) (*bytecode.Program, error) {
+	if strings.HasSuffix(filename, ".tupc") {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		return bytecode.Decode(b)
+	}
+	m, err := lowerFile(filename, ir.Lower)
+	if err != nil {
+		return nil, err
+	}
+	return bytecode.Compile(m)
+}
@@ -0,0 +1,456 @@
+package gogen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// funcGen generates the Go function translating a function of the module.
+// Each value that is used is held in a variable, v<id>, and each phi also
+// in a copy, p<id>, assigned on the edges into its block; the values no
+// instruction uses are assigned to the blank identifier, since Go rejects
+// variables that are never read. Each block that is jumped to begins with
+// the label b<index>.
+type funcGen struct {
+	*generator
+	fn   *ir.Func
+	used map[*ir.Instr]bool
+	b    strings.Builder
+}
+
+func (f *funcGen) printf(format string, args ...any) {
+	fmt.Fprintf(&f.b, format, args...)
+}
+
+func (g *generator) function(fn *ir.Func) {
+	f := &funcGen{generator: g, fn: fn, used: map[*ir.Instr]bool{}}
+	f.findUsed()
+	f.printf("\n%s {\n", g.signature(fn))
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			// checked operations take the address of their result
+			if instr.Typ == nil || !f.used[instr] && !instr.Op.IsChecked() {
+				continue
+			}
+			gt := g.gotype(instr.Typ)
+			f.printf("\tvar v%d %s\n", instr.ID, gt)
+			if instr.Op == ir.OpPhi {
+				f.printf("\tvar p%d %s\n", instr.ID, gt)
+			}
+		}
+	}
+	for _, b := range fn.Blocks {
+		f.block(b)
+	}
+	f.printf("}\n")
+	g.funcs.WriteString(f.b.String())
+}
+
+// findUsed records the values that are read: the operands of the
+// instructions other than phis, all of which are written, and the operands
+// of the phis that are read themselves. Wrapping nil reads nothing, since
+// nil is the nil interface.
+func (f *funcGen) findUsed() {
+	var phis []*ir.Instr
+	for _, b := range f.fn.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Op == ir.OpPhi {
+				phis = append(phis, instr)
+				continue
+			}
+			if instr.Op == ir.OpWrap && types.Identical(instr.Args[0].Type(), types.Typ[types.Nil]) {
+				continue
+			}
+			for _, arg := range instr.Args {
+				if arg, ok := arg.(*ir.Instr); ok {
+					f.used[arg] = true
+				}
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, phi := range phis {
+			if !f.used[phi] {
+				continue
+			}
+			for _, arg := range phi.Args {
+				if arg, ok := arg.(*ir.Instr); ok && !f.used[arg] {
+					f.used[arg] = true
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+func (f *funcGen) block(b *ir.Block) {
+	if len(b.Preds) > 0 {
+		f.printf("%s:\n", b)
+	}
+	for _, instr := range b.Instrs {
+		if instr.Op == ir.OpPhi {
+			if f.used[instr] {
+				f.printf("\tv%d = p%d\n", instr.ID, instr.ID)
+			}
+			continue
+		}
+		f.instr(instr)
+	}
+}
+
+// value returns the Go expression for v.
+func (f *funcGen) value(v ir.Value) string {
+	switch v := v.(type) {
+	case *ir.Param:
+		return paramName(v.Name)
+	case *ir.Instr:
+		return "v" + strconv.Itoa(v.ID)
+	}
+	f.errorf("unexpected value %s", v)
+	return ""
+}
+
+// edge writes the assignments of the phis of to the values flowing in
+// from from, and the jump to to.
+func (f *funcGen) edge(indent string, from, to *ir.Block) {
+	for _, phi := range to.Phis() {
+		if !f.used[phi] {
+			continue
+		}
+		for i, pred := range phi.Blocks {
+			if pred == from {
+				f.printf("%sp%d = %s\n", indent, phi.ID, f.value(phi.Args[i]))
+			}
+		}
+	}
+	f.printf("%sgoto %s\n", indent, to)
+}
+
+func (f *funcGen) instr(instr *ir.Instr) {
+	var x, y string
+	if len(instr.Args) > 0 {
+		x = f.value(instr.Args[0])
+	}
+	if len(instr.Args) > 1 {
+		y = f.value(instr.Args[1])
+	}
+	d := fmt.Sprintf("v%d", instr.ID)
+	if instr.Typ != nil && !f.used[instr] && !instr.Op.IsChecked() {
+		d = "_"
+	}
+	assign := func(format string, args ...any) {
+		f.printf("\t%s = %s\n", d, fmt.Sprintf(format, args...))
+	}
+
+	switch op := instr.Op; {
+	case op == ir.OpConst:
+		assign("%s", f.constant(instr))
+	case op == ir.OpFunc:
+		if v := f.funcValue(instr); v.closure != "" {
+			values := make([]string, len(instr.Args))
+			for i, arg := range instr.Args {
+				values[i] = f.value(arg)
+			}
+			assign("%s(%s)", v.closure, strings.Join(values, ", "))
+			break
+		}
+		assign("%s", f.funcName(instr.Callee))
+	case op == ir.OpUndef:
+		// the variable holds the zero value until it is assigned
+	case op.IsBinary():
+		f.arith(instr, assign, x, y)
+	case op == ir.OpNeg:
+		if types.IsFloat(instr.Typ) {
+			assign("-%s", x)
+		} else {
+			assign("tupNeg(%s)", x)
+		}
+	case op == ir.OpNot:
+		if types.IsBool(instr.Typ) {
+			assign("!%s", x)
+		} else {
+			assign("^%s", x)
+		}
+	case op.IsComparison():
+		assign("%s", f.compare(instr, x, y))
+	case op == ir.OpStr:
+		f.usesStrings = true
+		f.printf("\t{\n\t\tvar sb strings.Builder\n")
+		f.printf("\t\t%s\n", f.format(instr.Args[0].Type(), "&sb", x, "false"))
+		f.printf("\t\t%s = sb.String()\n\t}\n", d)
+	case op == ir.OpOrd:
+		assign("int64(%s)", x)
+	case op == ir.OpConv:
+		assign("tupConv[%s](%s)", f.gotype(instr.Typ), x)
+
+	case op == ir.OpTuple:
+		names := fields(instr.Typ)
+		values := make([]string, len(instr.Args))
+		for i, arg := range instr.Args {
+			values[i] = names[i] + ": " + f.value(arg)
+		}
+		assign("%s{%s}", f.gotype(instr.Typ), strings.Join(values, ", "))
+	case op == ir.OpField:
+		assign("%s.%s", x, fields(instr.Args[0].Type())[instr.Index])
+	case op == ir.OpUpdate:
+		assign("%s", x)
+		if d != "_" {
+			f.printf("\t%s.%s = %s\n", d, fields(instr.Typ)[instr.Index], y)
+		}
+	case op == ir.OpArray:
+		array := instr.Typ.Underlying().(*types.Array)
+		elems := make([]string, len(instr.Args))
+		for i, arg := range instr.Args {
+			elems[i] = f.value(arg)
+		}
+		if array.Fixed() {
+			assign("%s{%s}", f.gotype(instr.Typ), strings.Join(elems, ", "))
+		} else {
+			assign("TupArrayOf[%s](%s)", f.gotype(array.Elem), strings.Join(elems, ", "))
+		}
+	case op == ir.OpIndex:
+		switch t := instr.Args[0].Type().Underlying().(type) {
+		case *types.Array:
+			if t.Fixed() {
+				assign("%s[tupIndex(int64(%s), %d)]", x, y, t.Len)
+			} else {
+				assign("%s.At(int64(%s))", x, y)
+			}
+		default:
+			assign("%s(tupByte(string(%s), int64(%s)))", f.gotype(instr.Typ), x, y)
+		}
+	case op == ir.OpLen:
+		switch t := instr.Args[0].Type().Underlying().(type) {
+		case *types.Array:
+			if t.Fixed() {
+				assign("%d", t.Len)
+			} else {
+				assign("%s.Len()", x)
+			}
+		default:
+			assign("int64(len(%s))", x)
+		}
+	case op == ir.OpAppend:
+		assign("%s.Append(%s)", x, y)
+	case op == ir.OpSlice:
+		lo, hi := f.value(instr.Args[1]), f.value(instr.Args[2])
+		switch t := instr.Args[0].Type().Underlying().(type) {
+		case *types.Array:
+			if t.Fixed() {
+				assign("tupSliceOf(%s[:], int64(%s), int64(%s))", x, lo, hi)
+			} else {
+				assign("tupSliceOf(%s.Slice(), int64(%s), int64(%s))", x, lo, hi)
+			}
+		default:
+			assign("%s(tupSubstring(string(%s), int64(%s), int64(%s)))", f.gotype(instr.Typ), x, lo, hi)
+		}
+
+	case op == ir.OpWrap:
+		td := f.typedef(instr.Typ)
+		i := member(td, instr.Typ.Underlying().(*types.Union).Members[instr.Index])
+		switch {
+		case types.Identical(instr.Args[0].Type(), types.Typ[types.Nil]):
+			assign("%s(nil)", td.name)
+		case td.wrappers[i] != "":
+			assign("%s{%s}", td.wrappers[i], x)
+		default:
+			assign("%s", x)
+		}
+	case op == ir.OpTag:
+		assign("%s(%s)", f.helper("tag", instr.Args[0].Type()), x)
+	case op == ir.OpPayload:
+		union := instr.Args[0].Type()
+		m := union.Underlying().(*types.Union).Members[instr.Index]
+		td := f.typedef(union)
+		i := member(td, m)
+		msg := strconv.Quote(fmt.Sprintf("%s does not hold %s", union, m))
+		switch {
+		case types.Identical(m, types.Typ[types.Nil]):
+			f.printf("\tif %s != nil {\n\t\ttupPanic(%s)\n\t}\n", x, msg)
+			assign("TupNil{}")
+		case td.wrappers[i] != "":
+			assign("tupAs[%s](%s, %s).V", td.wrappers[i], x, msg)
+		default:
+			assign("tupAs[%s](%s, %s)", f.gotype(m), x, msg)
+		}
+
+	case op == ir.OpCall:
+		f.call(instr, d)
+
+	case op == ir.OpJump:
+		f.edge("\t", instr.Block, instr.Blocks[0])
+	case op == ir.OpBr:
+		f.printf("\tif %s {\n", x)
+		f.edge("\t\t", instr.Block, instr.Blocks[0])
+		f.printf("\t}\n")
+		f.edge("\t", instr.Block, instr.Blocks[1])
+	case op == ir.OpRet:
+		if len(instr.Args) == 0 {
+			f.printf("\treturn\n")
+		} else {
+			f.printf("\treturn %s\n", x)
+		}
+	case op == ir.OpTrap:
+		f.printf("\tpanic(TupRuntimeError(%s))\n", strconv.Quote(instr.Msg))
+	case op.IsChecked():
+		f.checked(instr, d, x, y)
+	default:
+		f.errorf("%s is not supported by the Go backend", op)
+	}
+}
+
+// arith writes the arithmetic instr, which traps on overflow and division
+// by zero.
+func (f *funcGen) arith(instr *ir.Instr, assign func(string, ...any), x, y string) {
+	t := instr.Typ
+	switch op := instr.Op; {
+	case types.IsBool(t):
+		switch op {
+		case ir.OpAnd:
+			assign("%s && %s", x, y)
+		case ir.OpOr:
+			assign("%s || %s", x, y)
+		case ir.OpXor:
+			assign("%s != %s", x, y)
+		default:
+			f.errorf("%s of %s is not supported by the Go backend", op, t)
+		}
+	case bitwise[op] != "" && types.IsInteger(t):
+		assign("%s %s %s", x, bitwise[op], y)
+	case op == ir.OpAdd && types.IsString(t):
+		assign("%s + %s", x, y)
+	case types.IsFloat(t):
+		switch op {
+		case ir.OpAdd, ir.OpSub, ir.OpMul:
+			assign("tupFloat(%s %s %s)", x, floatOps[op], y)
+		case ir.OpDiv:
+			assign("tupDivFloat(%s, %s)", x, y)
+		case ir.OpPow:
+			assign("tupPowFloat(%s, %s)", x, y)
+		default:
+			f.errorf("%s of %s is not supported by the Go backend", op, t)
+		}
+	case types.IsInteger(t) && intFuncs[op] != "":
+		assign("%s(%s, %s)", intFuncs[op], x, y)
+	default:
+		f.errorf("%s of %s is not supported by the Go backend", instr.Op, t)
+	}
+}
+
+var (
+	bitwise  = map[ir.Op]string{ir.OpAnd: "&", ir.OpOr: "|", ir.OpXor: "^"}
+	floatOps = map[ir.Op]string{ir.OpAdd: "+", ir.OpSub: "-", ir.OpMul: "*", ir.OpDiv: "/"}
+	intFuncs = map[ir.Op]string{
+		ir.OpAdd: "tupAdd", ir.OpSub: "tupSub", ir.OpMul: "tupMul", ir.OpDiv: "tupDiv",
+		ir.OpMod: "tupMod", ir.OpPow: "tupPow", ir.OpShl: "tupShl", ir.OpShr: "tupShr",
+	}
+)
+
+// checked writes the checked arithmetic instr, which continues with its
+// overflow block in place of trapping.
+func (f *funcGen) checked(instr *ir.Instr, d, x, y string) {
+	t := instr.Typ
+	op := instr.Op.Unchecked()
+	from, ok, overflow := instr.Block, instr.Blocks[0], instr.Blocks[1]
+	fails := func(cond string) {
+		f.printf("\tif %s {\n", cond)
+		f.edge("\t\t", from, overflow)
+		f.printf("\t}\n")
+	}
+	switch {
+	case types.IsFloat(t):
+		if op == ir.OpMod {
+			f.errorf("%s of %s is not supported by the Go backend", instr.Op, t)
+		}
+		cond := fmt.Sprintf("!tupFloatOK(%s %s %s, &%s)", x, floatOps[op], y, d)
+		if op == ir.OpDiv {
+			cond = y + " == 0 || " + cond
+		}
+		fails(cond)
+	case op == ir.OpMod:
+		fails(y + " == 0")
+		f.printf("\t%s = %s %% %s\n", d, x, y)
+	case types.IsInteger(t):
+		fails(fmt.Sprintf("!%sOK(%s, %s, &%s)", intFuncs[op], x, y, d))
+	default:
+		f.errorf("%s of %s is not supported by the Go backend", instr.Op, t)
+	}
+	f.edge("\t", from, ok)
+}
+
+func (f *funcGen) compare(instr *ir.Instr, x, y string) string {
+	t := instr.Args[0].Type()
+	switch instr.Op {
+	case ir.OpEq:
+		return f.equal(t, x, y)
+	case ir.OpNe:
+		return "!(" + f.equal(t, x, y) + ")"
+	case ir.OpCmp:
+		return fmt.Sprintf("tupCmp(%s, %s)", x, y)
+	}
+	rel := map[ir.Op]string{ir.OpLt: "<", ir.OpLe: "<=", ir.OpGt: ">", ir.OpGe: ">="}[instr.Op]
+	return fmt.Sprintf("%s %s %s", x, rel, y)
+}
+
+func (f *funcGen) call(instr *ir.Instr, d string) {
+	args := instr.Args
+	var callee string
+	if instr.Callee == "" {
+		callee, args = f.value(args[0]), args[1:]
+	} else {
+		callee = f.funcName(instr.Callee)
+	}
+	if instr.TypeArgs != nil {
+		targs := make([]string, len(instr.TypeArgs))
+		for i, t := range instr.TypeArgs {
+			targs[i] = f.gotype(t)
+		}
+		callee += "[" + strings.Join(targs, ", ") + "]"
+	}
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = f.value(arg)
+	}
+	call := fmt.Sprintf("%s(%s)", callee, strings.Join(values, ", "))
+	if instr.Typ == nil || d == "_" {
+		f.printf("\t%s\n", call)
+	} else {
+		f.printf("\t%s = %s\n", d, call)
+	}
+}
+
+// constant returns the Go expression for the value of the const instr.
+func (f *funcGen) constant(instr *ir.Instr) string {
+	switch c := instr.Const.(type) {
+	case *consteval.Int:
+		return c.Val.String()
+	case *consteval.Float:
+		s := strconv.FormatFloat(c.Val, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case consteval.Bool:
+		return strconv.FormatBool(bool(c))
+	case consteval.String:
+		return strconv.Quote(string(c))
+	case consteval.Symbol:
+		return "TupSymbol(" + strconv.Quote(string(c)) + ")"
+	case consteval.Nil:
+		return "TupNil{}"
+	case *consteval.Enum:
+		return mangle(f.gotype(instr.Typ), c.Member.Name)
+	case *consteval.ErrorValue:
+		return "TupError{Msg: " + strconv.Quote(c.Msg) + "}"
+	case *consteval.TypeValue:
+		return "TupType(" + strconv.Itoa(f.descriptor(c.Typ)) + ")"
+	}
+	f.errorf("constant %s has no Go form", instr.Const)
+	return ""
+}
@@ -0,0 +1,440 @@
+// Package gogen translates modules of the IR into Go packages, so that
+// Tuppence programs can be built wherever Go runs and called from Go.
+//
+// A package has two files: the module's, holding its types, helper
+// functions and functions, and the runtime's, a copy of package rt, which
+// provides dynamic arrays, the arithmetic that traps, the text of values
+// and the host function print. A module with a main function becomes a
+// command, package main; any other a package named after the module.
+// Values are held as follows:
+//
+//   - integers, floats, Bools and Strings as the Go types of their size,
+//     Float16 as float32, symbols as TupSymbol, nil as TupNil and the
+//     errors returned by checked arithmetic as TupError;
+//   - dynamic arrays as TupArray, which appends without copying where it
+//     can, and fixed-size arrays as Go arrays;
+//   - tuples as structs, whose fields are the labels of the tuple
+//     capitalized, or F0, F1 and so on for unlabeled tuples; named tuples
+//     as named structs, which are Go errors if they are Tuppence errors;
+//   - unions as sealed interfaces: the types of the module that are members
+//     get a method of the union's, and the others are wrapped in a struct
+//     of the union's, whose field V holds the value; nil is the nil
+//     interface;
+//   - enums as int64 types, with a constant for each member;
+//   - the values of typeof as TupType, the index of the type described
+//     in a table of the texts of the types the module describes;
+//   - functions as Go functions, whether fn or fx, and closures as Go
+//     closures calling the function with the values captured ahead of the
+//     arguments. Exported functions and named types keep their names,
+//     capitalized, so that Go can use them.
+//
+// A function is translated as its blocks in order, each labeled and each
+// jumping to its successors with goto, and with its values declared in
+// advance so that no goto jumps over a declaration. The phis of a block
+// are assigned on each edge into it, through a copy of each, so that they
+// are assigned all at once.
+//
+// Generic functions that only pass on the values of their type parameters
+// are lowered with ir.LowerGeneric as generic functions, which become Go
+// generic functions whose type parameters are constrained by any, and
+// their calls name the type arguments. The others, which need to know the
+// types of their values, reach the backend as their instances, which are
+// ordinary functions.
+package gogen
+
+import (
+	_ "embed"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+//go:embed rt/runtime.go
+var runtime string
+
+// Error reports a module that cannot be translated, such as one using a
+// type that has no Go form.
+type Error struct {
+	Msg string
+}
+
+func (err *Error) Error() string { return "gogen: " + err.Msg }
+
+// genBailout is panicked with to abandon generation after an error.
+type genBailout struct{ err *Error }
+
+// File is a file of a generated package.
+type File struct {
+	Name string
+	Src  []byte
+}
+
+// Generate returns the files of the Go package translating m, which must
+// have been verified: <name>.tup.go, after the module, and tup_runtime.go.
+func Generate(m *ir.Module) (files []File, err error) {
+	g := &generator{module: m, pkg: packageName(m), funcNames: map[string]string{}, byName: map[string]*funcValue{}}
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(genBailout)
+			if !ok {
+				panic(r)
+			}
+			files, err = nil, b.err
+		}
+	}()
+	g.generate()
+	src, err := format.Source([]byte(g.String()))
+	if err != nil {
+		return nil, &Error{Msg: fmt.Sprintf("generated invalid Go: %v", err)}
+	}
+	rt := fmt.Sprintf("// Code generated by tup build. DO NOT EDIT.\n\n%s", strings.Replace(runtime, "package rt", "package "+g.pkg, 1))
+	return []File{{Name: m.Name + ".tup.go", Src: src}, {Name: "tup_runtime.go", Src: []byte(rt)}}, nil
+}
+
+// WritePackage writes files to the directory dir, creating it if need be,
+// with a go.mod naming the module path, so that the package builds on its
+// own, unless dir already has one.
+func WritePackage(dir, path string, files []File) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.Name), f.Src, 0o644); err != nil {
+			return err
+		}
+	}
+	mod := filepath.Join(dir, "go.mod")
+	if _, err := os.Stat(mod); err == nil {
+		return nil
+	}
+	return os.WriteFile(mod, []byte(fmt.Sprintf("module %s\n\ngo 1.21\n", path)), 0o644)
+}
+
+// packageName returns the name of the package translating m: main if m
+// has a main function, and otherwise the module's name made an identifier.
+func packageName(m *ir.Module) string {
+	if m.Func("main") != nil {
+		return "main"
+	}
+	var b strings.Builder
+	for i := 0; i < len(m.Name); i++ {
+		if c := m.Name[i]; isIdentChar(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	name := b.String()
+	if !token.IsIdentifier(name) || name == "_" {
+		name = "tup_" + name
+	}
+	return name
+}
+
+type generator struct {
+	module *ir.Module
+	pkg    string
+	// the Go names of the functions of the module, by their names
+	funcNames map[string]string
+	// the functions taken as values, in order, by name
+	values []*funcValue
+	byName map[string]*funcValue
+	// the types described by the values of typeof, by index
+	described []types.Type
+	// the named types and unions defined, and the helper functions, in
+	// order
+	types   []*typedef
+	helpers []*helper
+	// usesStrings is set once the module's file refers to package strings
+	usesStrings bool
+
+	// the sections of the file, in order
+	decls, defs, funcs strings.Builder
+}
+
+func (g *generator) errorf(format string, args ...any) {
+	panic(genBailout{&Error{Msg: fmt.Sprintf(format, args...)}})
+}
+
+func (g *generator) generate() {
+	g.nameFuncs()
+	for _, fn := range g.module.Funcs {
+		if fn.Extern() {
+			if hostFuncs[fn.Name] == "" {
+				g.errorf("host function %s is not provided by the Go runtime", fn.Name)
+			}
+			continue
+		}
+		fn.Update()
+	}
+	// the closures are defined before the functions, so that the text of
+	// a function value finds them all
+	for _, fn := range g.module.Funcs {
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Op == ir.OpFunc {
+					g.funcValue(instr)
+				}
+			}
+		}
+	}
+	for _, fn := range g.module.Funcs {
+		if !fn.Extern() {
+			g.function(fn)
+		}
+	}
+	if len(g.described) > 0 {
+		texts := make([]string, len(g.described))
+		for i, t := range g.described {
+			texts[i] = strconv.Quote((&consteval.TypeValue{Typ: t}).String())
+		}
+		fmt.Fprintf(&g.decls, "\nvar tupTypes = [...]string{%s}\n", strings.Join(texts, ", "))
+	}
+	if main := g.module.Func("main"); main != nil {
+		if main.Extern() || len(main.Params) > 0 {
+			g.errorf("main must be a function without parameters")
+		}
+		fmt.Fprintf(&g.funcs, "\nfunc main() {\n\ttupMain(%s)\n}\n", g.funcName(main.Name))
+	}
+}
+
+// String returns the module's file.
+func (g *generator) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by tup build from module %s. DO NOT EDIT.\n\npackage %s\n", g.module.Name, g.pkg)
+	if g.usesStrings {
+		b.WriteString("\nimport \"strings\"\n")
+	}
+	for _, section := range []*strings.Builder{&g.decls, &g.defs, &g.funcs} {
+		b.WriteString(section.String())
+	}
+	return b.String()
+}
+
+// hostFuncs maps the host functions the runtime provides to their Go
+// names.
+var hostFuncs = map[string]string{
+	"print": "tupPrint",
+}
+
+// runtimeNames holds the exported names of the runtime, which the types
+// and functions of a module must not take.
+var runtimeNames = map[string]bool{
+	"TupNil": true, "TupSymbol": true, "TupType": true, "TupError": true, "TupRuntimeError": true, "TupArray": true, "TupArrayOf": true,
+}
+
+// descriptor returns the index of the type t among those described by the
+// values of typeof.
+func (g *generator) descriptor(t types.Type) int {
+	for i, d := range g.described {
+		if types.Identical(d, t) {
+			return i
+		}
+	}
+	g.described = append(g.described, t)
+	return len(g.described) - 1
+}
+
+// nameFuncs names the functions of the module. Exported functions are
+// named as they are in Tuppence, capitalized, unless that would make an
+// identifier collide with another; the others are unexported.
+func (g *generator) nameFuncs() {
+	taken := map[string]bool{}
+	visited := map[types.Type]bool{}
+	var visit func(t types.Type)
+	visit = func(t types.Type) {
+		if t == nil || visited[t] {
+			return
+		}
+		visited[t] = true
+		switch t := t.(type) {
+		case *types.Named:
+			taken[typeName(t)] = true
+			visit(t.Underlying())
+		case *types.Tuple:
+			for _, f := range t.Fields {
+				visit(f.Type)
+			}
+		case *types.Array:
+			visit(t.Elem)
+		case *types.Union:
+			for _, m := range t.Members {
+				visit(m)
+			}
+		case *types.Function:
+			for _, p := range t.Params {
+				visit(p.Type)
+			}
+			visit(t.Result)
+		}
+	}
+	for _, fn := range g.module.Funcs {
+		visit(fn.Sig)
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				visit(instr.Typ)
+			}
+		}
+	}
+	for _, fn := range g.module.Funcs {
+		name := mangle("f", fn.Name)
+		if fn.Export && isIdent(fn.Name) {
+			if exported := capitalize(fn.Name); !taken[exported] && !runtimeNames[exported] {
+				name = exported
+			}
+		}
+		taken[name] = true
+		g.funcNames[fn.Name] = name
+	}
+}
+
+// funcName returns the Go name of the function named name.
+func (g *generator) funcName(name string) string {
+	if host := hostFuncs[name]; host != "" && g.module.Func(name).Extern() {
+		return host
+	}
+	return g.funcNames[name]
+}
+
+// funcValue is a function of the module taken as a value: of type typ,
+// by OpFunc instructions passing the first captures parameters of fn.
+type funcValue struct {
+	fn       *ir.Func
+	typ      types.Type
+	captures int
+	// whether its closures capture themselves, as the last of captures
+	rec bool
+	// the Go name of the function making a closure of fn, if captures > 0
+	closure string
+}
+
+// funcValue returns the function value of the OpFunc instr, defining the
+// function making its closures first if need be.
+func (g *generator) funcValue(instr *ir.Instr) *funcValue {
+	if v := g.byName[instr.Callee]; v != nil {
+		return v
+	}
+	fn := g.module.Func(instr.Callee)
+	v := &funcValue{fn: fn, typ: instr.Typ, captures: len(instr.Args)}
+	if instr.Rec {
+		v.captures, v.rec = v.captures+1, true
+	}
+	g.values = append(g.values, v)
+	g.byName[fn.Name] = v
+	if v.captures == 0 {
+		return v
+	}
+	v.closure = mangle("fc", fn.Name)
+	var captures, params []string
+	args := make([]string, len(fn.Params))
+	for i, p := range fn.Params {
+		args[i] = paramName(p.Name)
+		decl := args[i] + " " + g.gotype(p.Typ)
+		if i < v.captures {
+			captures = append(captures, decl)
+		} else {
+			params = append(params, decl)
+		}
+	}
+	call := fmt.Sprintf("%s(%s)", g.funcName(fn.Name), strings.Join(args, ", "))
+	if fn.Sig.Result != nil {
+		call = "return " + call
+	}
+	closure := fmt.Sprintf("func(%s)%s {\n\t\t%s\n\t}", strings.Join(params, ", "), g.result(fn.Sig), call)
+	if !v.rec {
+		fmt.Fprintf(&g.defs, "\nfunc %s(%s) %s {\n\treturn %s\n}\n",
+			v.closure, strings.Join(captures, ", "), g.gotype(v.typ), closure)
+		return v
+	}
+	self := captures[len(captures)-1]
+	fmt.Fprintf(&g.defs, "\nfunc %s(%s) %s {\n\tvar %s\n\t%s = %s\n\treturn %s\n}\n",
+		v.closure, strings.Join(captures[:len(captures)-1], ", "), g.gotype(v.typ),
+		self, args[len(captures)-1], closure, args[len(captures)-1])
+	return v
+}
+
+// signature returns the Go signature of fn.
+func (g *generator) signature(fn *ir.Func) string {
+	params := make([]string, len(fn.Params))
+	for i, p := range fn.Params {
+		params[i] = paramName(p.Name) + " " + g.gotype(p.Typ)
+	}
+	var typeParams string
+	if fn.TypeParams != nil {
+		names := make([]string, len(fn.TypeParams))
+		for i, t := range fn.TypeParams {
+			names[i] = typeParamName(t) + " any"
+		}
+		typeParams = "[" + strings.Join(names, ", ") + "]"
+	}
+	return fmt.Sprintf("func %s%s(%s)%s", g.funcName(fn.Name), typeParams, strings.Join(params, ", "), g.result(fn.Sig))
+}
+
+// typeParamName returns the Go name of the type parameter t.
+func typeParamName(t *types.TypeParam) string { return mangle("T", t.Name()) }
+
+// result returns the result of the Go signature of sig, with a leading
+// space, or nothing.
+func (g *generator) result(sig *types.Function) string {
+	if sig.Result == nil {
+		return ""
+	}
+	return " " + g.gotype(sig.Result)
+}
+
+// paramName returns the Go name of the parameter named name.
+func paramName(name string) string { return mangle("a", name) }
+
+// mangle returns a Go identifier for the Tuppence identifier name, which
+// may end with ? or !: prefix_name. Identifiers with other characters than
+// letters, digits and underscores are written prefixx followed by the name
+// with those characters and underscores escaped, so that no two names
+// collide.
+func mangle(prefix, name string) string {
+	if isIdent(name) {
+		return prefix + "_" + name
+	}
+	var b strings.Builder
+	b.WriteString(prefix)
+	b.WriteString("x")
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; isIdentChar(c) && c != '_' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// isIdent reports whether name is made of letters, digits and underscores
+// only.
+func isIdent(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if !isIdentChar(name[i]) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// capitalize returns name with its first letter upper case, which exports
+// it from a Go package.
+func capitalize(name string) string {
+	if name == "" || name[0] < 'a' || name[0] > 'z' {
+		return name
+	}
+	return string(name[0]-'a'+'A') + name[1:]
+}
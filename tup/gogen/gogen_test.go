@@ -0,0 +1,349 @@
+package gogen
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/internal/testutil"
+	"github.com/rowland/tuppence/tup/ir"
+)
+
+// generate writes the package translating input to a new directory, which
+// it returns along with the module's file.
+func generate(t *testing.T, name, input string) (dir, src string) {
+	t.Helper()
+	m, err := testutil.LowerGeneric(t, name+".tup", input)
+	if err != nil {
+		t.Fatalf("lower(%q) = %v", input, err)
+	}
+	return write(t, name, m)
+}
+
+func write(t *testing.T, name string, m *ir.Module) (dir, src string) {
+	t.Helper()
+	files, err := Generate(m)
+	if err != nil {
+		t.Fatalf("Generate() = %v", err)
+	}
+	dir = filepath.Join(t.TempDir(), name)
+	if err := WritePackage(dir, name, files); err != nil {
+		t.Fatalf("WritePackage() = %v", err)
+	}
+	return dir, string(files[0].Src)
+}
+
+// requireGo returns the go command, skipping the test if there is none.
+func requireGo(t *testing.T) string {
+	t.Helper()
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("no go command: %v", err)
+	}
+	return gocmd
+}
+
+// goCmd returns the go command running in dir, outside any workspace the
+// tests run in.
+func goCmd(gocmd, dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command(gocmd, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=")
+	return cmd
+}
+
+// goRun runs the command in dir with go run, returning what it wrote to
+// stdout.
+func goRun(t *testing.T, gocmd, dir string) string {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	cmd := goCmd(gocmd, dir, "run", ".")
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("go run: %v\n%s", err, errOut.String())
+	}
+	return out.String()
+}
+
+const errorDecls = "E1 = error(message: String)\n" +
+	"E2 = error(code: Int)\n" +
+	"classify = fn(n: Int) !Int {\n\tif n < 0 { E1(\"negative\") } else if n == 0 { E2(0) } else { n }\n}\n"
+
+// TestRun runs each program with go run and checks what its main function
+// prints, which is what the interpreter prints.
+func TestRun(t *testing.T) {
+	gocmd := requireGo(t)
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"print", "main = fx() { print(1, \"a\", [1, 2], (x: 1)) }", "1 a [1, 2] (x: 1)\n"},
+		{"print in loop", "main = fx() {\n\tfor i in 1..3 { print(i) }\n}", "1\n2\n3\n"},
+		{"interpolation", "main = fx() {\n\tname = \"World\"\n\tprint(\"Hello, \\(name)!\")\n}", "Hello, World!\n"},
+		{"floats", "f = fx(x: Float) Float { x }\nmain = fx() { print(f(0.1) + f(0.2), f(1.5) * f(2.0), f(1e6), f(1e-5), f(123456.5), f(-0.25)) }",
+			"0.30000000000000004 3.0 1e+06 1e-05 123456.5 -0.25\n"},
+		{"integer types", "f = fx(x: Int8) Int8 { x }\ng = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(f(0) - f(100) - f(28), f(100) / (f(0) - f(3)), f(7) % (f(0) - f(2)), g(4294967296) * g(4294967295)) }",
+			"-128 -33 1 18446744069414584320\n"},
+		{"power and shifts", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(3) ^ f(4), f(1) << f(62), f(-16) >> f(2)) }", "81 4611686018427387904 -4\n"},
+		{"bitwise", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(12) & f(10), f(12) | f(10), ~f(0)) }", "8 14 -1\n"},
+		{"nested strings are quoted", "main = fx() { print([\"a\\tb\", \"\\\"q\\\"\"], (\"x\", 1)) }", "[\"a\\tb\", \"\\\"q\\\"\"] (\"x\", 1)\n"},
+		{"strings", "f = fx(s: String) String { s }\nmain = fx() {\n\ts = f(\"abc\")\n\tprint(s + \"def\", len(s), s[1], s < \"abd\", s == \"abc\")\n}",
+			"abcdef 3 98 true true\n"},
+		{"symbols and nil", "main = fx() { print(:ok, nil, true) }", ":ok nil true\n"},
+		{"recursion", "fact = fn(n: Int) Int { if n <= 1 { 1 } else { n * fact(n - 1) } }\nmain = fx() { print(fact(20)) }", "2432902008176640000\n"},
+		{"generic functions", "apply[a, b]: fn(x: a, f: fn(a) b) b { f(x) }\nid[a]: fn(x: a) a { x }\nshow = fn(n: Int) String { \"<\\(n)>\" }\n" +
+			"main = fx() { print(apply(41, show), id(\"s\"), id([1, 2])) }", "<41> s [1, 2]\n"},
+		{"function value", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\ninc = fn(n: Int) Int { n + 1 }\nmain = fx() { print(apply(41, inc), inc) }", "42 inc\n"},
+		{"typeof", "Circle = type(r: Int)\nSquare = type(s: Int)\nShape = Circle | Square\nf = fx(s: Shape) Shape { s }\n" +
+			"is_circle = fn(s: Shape) Bool { typeof(s) == typeof(Circle) }\n" +
+			"main = fx() { print(is_circle(f(Circle(1))), is_circle(f(Square(2))), typeof(f(Square(2))), (typeof(Circle), 1)) }",
+			"true false typeof(Square) (typeof(Circle), 1)\n"},
+		{"slices", "f = fx(xs: []Int) []Int { xs }\ng = fx(s: String) String { s }\n" +
+			"main = fx() {\n\txs = f([1, 2, 3, 4])\n\tys = xs[1..2]\n\tprint(ys, xs[2..1], g(\"hello\")[1..3], [2]String[\"a\", \"b\"][0..0], ys << 5, xs)\n}",
+			"[2, 3] [] ell [\"a\"] [2, 3, 5] [1, 2, 3, 4]\n"},
+		{"closures", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\nadd = fn(a: Int, b: Int) Int { a + b }\n" +
+			"f = fn(k: Int) Int { apply(3) { apply(it) { |m| m * k + it } } }\n" +
+			"g = fn(k: Int) fn(Int) Int { add(k, *) }\n" +
+			"h = fn(k: Int) Int {\n\tsum = fn(n: Int) Int { if n == 0 { k } else { sum(n - 1) + n } }\n\tsum(4)\n}\n" +
+			"main = fx() {\n\tinc = g(1)\n\tprint(f(2), inc(41), h(10), inc, apply(1) { it + 1 })\n}",
+			"9 42 20 fn { ... } 2\n"},
+		{"closures in a list", "Cons = type(head: fn(Int) Int, tail: List)\n" +
+			"List = Nil | Cons\n" +
+			"add = fn(a: Int, b: Int) Int { a + b }\n" +
+			"empty = fn() List { nil }\n" +
+			"adders = fn(n: Int) List {\n" +
+			"\tfor i, acc = (0, empty()); i < n {\n" +
+			"\t\tnext = (i + 1, Cons(head: add(i, *), tail: acc))\n" +
+			"\t\tnext\n" +
+			"\t}.1\n" +
+			"}\n" +
+			"sum = fn(l: List, x: Int) Int {\n" +
+			"\tfor acc, current = (0, l); current != nil {\n" +
+			"\t\tswitch current {\n" +
+			"\t\t\tCons { |c|\n" +
+			"\t\t\t\t(acc + c.head(x), c.tail)\n" +
+			"\t\t\t}\n" +
+			"\t\t\tNil { (acc, current) }\n" +
+			"\t\t}\n" +
+			"\t}.0\n" +
+			"}\n" +
+			"main = fx() { print(sum(adders(4), 10)) }",
+			"46\n"},
+		{"closure names", "mk = fn(k: Int) fn(Int) Int {\n\taddk = fn(n: Int) Int { n + k }\n\taddk\n}\ninc = fn(n: Int) Int { n + 1 }\n" +
+			"main = fx() {\n\ta = mk(1)\n\tf = inc\n\tprint(a, a(2), a == a, f == inc, a == inc)\n}", "addk 3 true true false\n"},
+		{"labeled tuple", "Point = type(x: Int, y: Int)\nmove = fn(p: Point, dx: Int) Point { p.(x: p.x + dx) }\nmain = fx() { print(move(Point(1, 2), 3)) }", "(x: 4, y: 2)\n"},
+		{"tuple update", "Point = type(x: Int, y: Int)\nmain = fx() {\n\tp = Point(1, 2)\n\tq = p.(y: 5)\n\tprint(p)\n\tprint(q)\n}", "(x: 1, y: 2)\n(x: 1, y: 5)\n"},
+		{"tuple equality", "Point = type(x: Int, y: Int)\nf = fx(p: Point) Point { p }\nmain = fx() { print(f(Point(1, 2)) == Point(1, 2), f(Point(1, 2)) != Point(2, 1)) }", "true true\n"},
+		{"arrays", "f = fx(xs: []Int) []Int { xs }\nmain = fx() {\n\txs = f([1, 2, 3])\n\tys = xs << 4\n\tzs = xs << 5\n\tprint(ys, zs, len(ys), xs[2], xs == [1, 2, 3])\n}",
+			"[1, 2, 3, 4] [1, 2, 3, 5] 4 3 true\n"},
+		{"appends share", "f = fx(xs: []Int) []Int { xs }\nmain = fx() {\n\tys = f([1]) << 2\n\ta = ys << 3\n\tb = ys << 4\n\tprint(a, b, a << 5, ys)\n}",
+			"[1, 2, 3] [1, 2, 4] [1, 2, 3, 5] [1, 2]\n"},
+		{"nested arrays", "f = fx(xs: [][]Int) [][]Int { xs }\nmain = fx() { print(f([[1], [2, 3]])) }", "[[1], [2, 3]]\n"},
+		{"enum", "Color = enum(\n\tred\n\tgreen\n\tblue\n)\nf = fx(c: Color) Color { c }\nmain = fx() { print(f(Color.green), f(Color.blue).int(), f(Color.red).string()) }",
+			"Color.green 2 red\n"},
+		{"checked arithmetic", "f = fx(x: Int8) Int8 { x }\nmain = fx() { print(f(100) ?+ f(27), f(100) ?+ f(100), f(1) ?/ f(0)) }",
+			"127 error(\"integer overflow\") error(\"division by zero\")\n"},
+		{"integer conversions", "f = fx(x: Int) Int { x }\ng = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(Int8(f(-5)), UInt8(f(200)), Int(g(7)), UInt64(f(9)), Int16(Int8(f(-3))), UInt64(~g(0))) }",
+			"-5 200 7 9 -3 18446744073709551615\n"},
+		{"errors", errorDecls + "main = fx() { print(classify(-1), classify(0), classify(3)) }", "(message: \"negative\") (code: 0) 3\n"},
+		{"try", errorDecls + "f = fn(n: Int) !Int {\n\tv = try classify(n)\n\tv + 1\n}\nmain = fx() { print(f(41), f(-1)) }", "42 (message: \"negative\")\n"},
+		{"switch on union", errorDecls + "f = fn(n: Int) String {\n\tv = classify(n)\n\tswitch v {\n\t\tE1 { \"E1\" }\n\t\tE2 { \"E2\" }\n\t\tInt { \"Int\" }\n\t}\n}\nmain = fx() { print(f(-1), f(0), f(1)) }",
+			"E1 E2 Int\n"},
+		{"named union", "IS = Int | String\nf = fx(x: Int, b: Bool) IS { if b { x } else { \"s\" } }\nmain = fx() { print(f(1, true), f(1, false), f(2, true) == f(2, true), f(2, true) == f(2, false)) }",
+			"1 s true false\n"},
+		{"union with nil", "Result = String | Nil\nf = fx(n: Int) Result { switch n { 1 { \"one\" } } }\nmain = fx() { print(f(1), f(2), f(2) == f(3)) }",
+			"one nil true\n"},
+		{"loop", "sum = fn(ns: ...Int) Int { for s = 0; n in ns { s + n } }\nmain = fx() { print(sum(1, 2, 3, 4)) }", "10\n"},
+		{"fib", "fib = fn(n: Int) []Int {\n\tfor (a, b, acc) = (0, 1, Int[]); len(acc) < n {\n\t\t(b, a + b, acc << a)\n\t}.2\n}\nmain = fx() { print(fib(12)) }",
+			"[0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89]\n"},
+		{"generics", "id[a]: fn(x: a) a { x }\npair[a, b]: fn(x: a, y: b) (a, b) { (x, y) }\nNumeric[a] = contract(\n\tmul[a] = fn(a, a) a\n)\nsqr[a]: fn(x: Numeric[a]) a { x * x }\nmain = fx() { print(id(1), id(\"s\"), pair(1, \"x\"), sqr(3), sqr(1.5)) }",
+			"1 s (1, \"x\") 9 2.25\n"},
+		{"generic list", "Cons[a] = type(head: a, tail: List[a])\nList[a] = Nil | Cons[a]\nprepend[a]: fn(list: List[a], head: a) List[a] { Cons(head: head, tail: list) }\nmap[a, b]: fn(list: List[a], f: fn(a) b) List[b] {\n\tswitch list {\n\t\tNil { nil }\n\t\tCons { |c| Cons(head: f(c.head), tail: map(c.tail, f)) }\n\t}\n}\nlength[a]: fn(list: List[a]) Int {\n\tswitch list {\n\t\tNil { 0 }\n\t\tCons { |c| 1 + length(c.tail) }\n\t}\n}\ndouble = fn(n: Int) Int { n * 2 }\nmain = fx() {\n\txs = map(prepend(prepend(nil, 2), 1), double)\n\tprint(xs, length(xs), length(prepend(nil, \"a\")))\n}",
+			"(head: 2, tail: (head: 4, tail: nil)) 2 1\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			dir, _ := generate(t, "test", test.input)
+			if got := goRun(t, gocmd, dir); got != test.want {
+				t.Errorf("program wrote %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// TestTraps builds each program with go build and checks that operations
+// that trap stop it with a runtime error and exit status 2.
+func TestTraps(t *testing.T) {
+	gocmd := requireGo(t)
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"overflow", "f = fx(x: Int8) Int8 { x }\nmain = fx() { print(f(100) + f(100)) }", "runtime error: integer overflow"},
+		{"negation overflow", "f = fx(x: Int) Int { x }\nmain = fx() { print(-(f(-9223372036854775807) - 1)) }", "runtime error: integer overflow"},
+		{"conversion overflow", "f = fx(x: Int) Int { x }\nmain = fx() { print(Int8(f(200))) }", "runtime error: integer overflow"},
+		{"negative to unsigned", "f = fx(x: Int) Int { x }\nmain = fx() { print(UInt(f(-1))) }", "runtime error: integer overflow"},
+		{"unsigned to signed", "g = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(Int(~g(0))) }", "runtime error: integer overflow"},
+		{"division by zero", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(1) / f(0)) }", "runtime error: division by zero"},
+		{"index out of range", "f = fx(xs: []Int) []Int { xs }\nmain = fx() { print(f([1, 2])[2]) }", "runtime error: index 2 out of range [0:2]"},
+		{"slice out of range", "f = fx(xs: []Int) []Int { xs }\nmain = fx() { print(f([1, 2])[1..2]) }", "runtime error: slice [1..2] out of range [0:2]"},
+		{"output before trap", "f = fx(x: Int) Int { x }\nmain = fx() {\n\tprint(1)\n\tprint(f(1) / f(0))\n}", "division by zero"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			dir, _ := generate(t, "test", test.input)
+			exe := filepath.Join(dir, "prog")
+			if out, err := goCmd(gocmd, dir, "build", "-o", exe, ".").CombinedOutput(); err != nil {
+				t.Fatalf("go build: %v\n%s", err, out)
+			}
+			var errOut bytes.Buffer
+			cmd := exec.Command(exe)
+			cmd.Stderr = &errOut
+			err := cmd.Run()
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) || exit.ExitCode() != 2 {
+				t.Fatalf("program exited with %v, want exit status 2", err)
+			}
+			if !strings.Contains(errOut.String(), test.wantErr) {
+				t.Errorf("program wrote %q to stderr, want %q", errOut.String(), test.wantErr)
+			}
+		})
+	}
+}
+
+// TestLibrary builds a Go command calling the exported functions of a
+// generated package, which is named after its module.
+func TestLibrary(t *testing.T) {
+	gocmd := requireGo(t)
+	input := "Point: type(x: Int, y: Int)\n" +
+		"move: fn(p: Point, dx: Int) Point { p.(x: p.x + dx) }\n" +
+		"E1 = error(message: String)\n" +
+		"classify: fn(n: Int) !Int { if n < 0 { E1(\"negative\") } else { n } }\n" +
+		"sum: fn(ns: []Int) Int { for s = 0; n in ns { s + n } }\n"
+	lib, src := generate(t, "geom", input)
+	if !strings.Contains(src, "package geom\n") {
+		t.Errorf("package is not named after the module:\n%s", src)
+	}
+	app := filepath.Join(filepath.Dir(lib), "app")
+	files := map[string]string{
+		"go.mod": "module app\n\ngo 1.21\n\nrequire geom v0.0.0\n\nreplace geom => ../geom\n",
+		"main.go": "package main\n\nimport (\n\t\"fmt\"\n\n\t\"geom\"\n)\n\nfunc main() {\n" +
+			"\tp := geom.Move(geom.Point{X: 1, Y: 2}, 3)\n" +
+			"\tfmt.Println(p.X, p.Y)\n" +
+			"\tif err, ok := geom.Classify(-1).(error); ok {\n\t\tfmt.Println(err)\n\t}\n" +
+			"\tfmt.Println(geom.Sum(geom.TupArrayOf[int64](1, 2, 3)))\n}\n",
+	}
+	if err := os.MkdirAll(app, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(app, name), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "4 2\n(message: \"negative\")\n6\n"
+	if got := goRun(t, gocmd, app); got != want {
+		t.Errorf("program wrote %q, want %q", got, want)
+	}
+}
+
+// TestGenerate checks the Go the types and operations of each program
+// translate to.
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string // substrings of the module's file
+	}{
+		{"labeled tuple", "Mixed = type(a: Int8, b: Int64, c: Int16)\nf = fn(m: Mixed) Int16 { m.c }",
+			[]string{"type Mixed struct {\n\tA int8\n\tB int64\n\tC int16\n}", "= a_m.C\n"}},
+		{"unlabeled tuple", "f = fn(n: Int) (Int, String) { (n, \"s\") }", []string{"struct {\n\t\tF0 int64\n\t\tF1 string\n\t}"}},
+		{"sealed union", "IS = Int | String\nf = fn(x: Int, b: Bool) IS { if b { x } else { \"s\" } }",
+			[]string{"type IS interface {\n\tisIS()\n}", "type IS_0 struct {\n\tV int64\n}", "func (IS_0) isIS() {}", "= IS_1{"}},
+		{"errors", errorDecls, []string{"func (E1) isunion", "func (e E1) Error() string {"}},
+		{"enum", "Color = enum(\n\tred\n\tgreen\n)\nf = fn() Color { Color.green }", []string{"type Color int64", "Color_green Color = 1", "= Color_green\n"}},
+		{"checked", "f = fn(a: Int, b: Int) Int | error { a ?* b }", []string{"if !tupMulOK(a_a, a_b, &v"}},
+		{"phis", "f = fn(n: Int) Int { for s = 0; i in 0..n { s + i } }", []string{"\tvar p", "= p", "goto b"}},
+		{"exported", "inc: fn(n: Int) Int { n + 1 }\nf = fn(n: Int) Int { inc(n) }", []string{"func Inc(a_n int64) int64 {", "func f_f(a_n int64) int64 {"}},
+		{"generic", "id[a]: fn(x: a) a { x }\nf = fn(n: Int) Int { id(n) }", []string{"func Id[T_a any](a_x T_a) T_a {", "= Id[int64](a_n)\n"}},
+		{"mangled names", "ok? = fx(n: Int) Bool { n > 0 }\nf = fx(n: Int) Bool { ok?(n) }", []string{"func fxok_3f(a_n int64) bool {", "fxok_3f(a_n)"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := testutil.LowerGeneric(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			files, err := Generate(m)
+			if err != nil {
+				t.Fatalf("Generate() = %v", err)
+			}
+			src := string(files[0].Src)
+			for _, want := range test.want {
+				if !strings.Contains(src, want) {
+					t.Errorf("file does not contain %q:\n%s", want, src)
+				}
+			}
+			if strings.Contains(src, "func main()") {
+				t.Errorf("package without a main function has a Go main function")
+			}
+		})
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"host function", "module m\n\ndeclare fx @read() String\n\nfx @f() String {\nb0:\n  %0 = call fx String @read()\n  ret %0\n}\n",
+			"host function read is not provided by the Go runtime"},
+		{"main with parameters", "module m\n\nfx @main(%n: Int) {\nb0:\n  ret\n}\n", "main must be a function without parameters"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ir.Parse(test.input)
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			_, err = Generate(m)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Generate() = %v, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestExamples builds each example the IR can lower, runs those with a
+// main function and compares their output with the interpreter's golden
+// output in ../interp/testdata/<name>.out.
+func TestExamples(t *testing.T) {
+	gocmd := requireGo(t)
+	testutil.Examples(t, testutil.LowerGeneric, func(t *testing.T, name string, m *ir.Module) {
+		t.Parallel()
+		dir, _ := write(t, name, m)
+		if m.Func("main") == nil {
+			if out, err := goCmd(gocmd, dir, "build", ".").CombinedOutput(); err != nil {
+				t.Fatalf("go build: %v\n%s", err, out)
+			}
+			return
+		}
+		got := goRun(t, gocmd, dir)
+		want, err := os.ReadFile(filepath.Join("..", "interp", "testdata", name+".out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s wrote:\n%s\nwant:\n%s", name, got, want)
+		}
+	})
+}
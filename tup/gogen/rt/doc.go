@@ -0,0 +1,7 @@
+// Package rt is the runtime of the Go packages generated by package gogen.
+// It is not imported: gogen copies runtime.go into each package it
+// generates, renaming the package, so that a generated package needs
+// nothing beyond the standard library. Keeping the runtime in a package of
+// its own lets the compiler and go vet check it with the rest of the
+// toolchain.
+package rt
@@ -0,0 +1,420 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// TupNil is the type of nil, which has the one value TupNil{}.
+type TupNil struct{}
+
+// TupSymbol is a symbol, held as its name.
+type TupSymbol string
+
+// TupType is a value of typeof, held as the index of the type it describes
+// among those the module describes.
+type TupType int32
+
+// TupError is an error returned by checked arithmetic.
+type TupError struct {
+	Msg string
+}
+
+func (e TupError) Error() string { return e.Msg }
+
+// TupRuntimeError is panicked with when an operation traps, such as an
+// integer overflow or an index out of range.
+type TupRuntimeError string
+
+func (e TupRuntimeError) Error() string { return "runtime error: " + string(e) }
+
+func tupPanic(msg string) { panic(TupRuntimeError(msg)) }
+
+// tupMain runs the main function of a program. A trap stops the program
+// with exit status 2 after writing its runtime error to stderr.
+func tupMain(main func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(TupRuntimeError); ok {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(2)
+			}
+			panic(r)
+		}
+	}()
+	main()
+}
+
+// Dynamic arrays.
+
+// TupArray is a dynamic array of elements of type T. Arrays are values:
+// appending to an array returns a new array and leaves the old one as it
+// was. An append shares the elements of the array appended to when no
+// other array has been appended to it yet, so building an array element by
+// element takes amortized constant time per element. The zero TupArray is
+// empty.
+type TupArray[T any] struct {
+	slab *tupSlab[T]
+	n    int
+}
+
+// tupSlab holds the elements of the arrays sharing it; len(elems) is the
+// length of the longest of them.
+type tupSlab[T any] struct {
+	elems []T
+}
+
+// TupArrayOf returns an array of the given elements.
+func TupArrayOf[T any](elems ...T) TupArray[T] {
+	return TupArray[T]{&tupSlab[T]{append([]T(nil), elems...)}, len(elems)}
+}
+
+// Len returns the number of elements of a.
+func (a TupArray[T]) Len() int64 { return int64(a.n) }
+
+// At returns the element of a at index i, trapping if there is none.
+func (a TupArray[T]) At(i int64) T { return a.slab.elems[tupIndex(i, a.n)] }
+
+// Append returns the array of the elements of a followed by x.
+func (a TupArray[T]) Append(x T) TupArray[T] {
+	if a.slab != nil && len(a.slab.elems) == a.n {
+		a.slab.elems = append(a.slab.elems, x)
+		return TupArray[T]{a.slab, a.n + 1}
+	}
+	elems := make([]T, a.n, max(2*a.n, 4))
+	copy(elems, a.Slice())
+	return TupArray[T]{&tupSlab[T]{append(elems, x)}, a.n + 1}
+}
+
+// Slice returns the elements of a, which must not be modified.
+func (a TupArray[T]) Slice() []T {
+	if a.slab == nil {
+		return nil
+	}
+	return a.slab.elems[:a.n:a.n]
+}
+
+// tupIndex returns i if it is an index of a sequence of n elements, and
+// traps otherwise.
+func tupIndex(i int64, n int) int64 {
+	if i < 0 || i >= int64(n) {
+		tupPanic(fmt.Sprintf("index %d out of range [0:%d]", i, n))
+	}
+	return i
+}
+
+// tupByte returns the byte of s at index i.
+func tupByte(s string, i int64) byte { return s[tupIndex(i, len(s))] }
+
+// tupSlice returns the end of elements lo through hi of a sequence of n
+// elements, for slicing it, and traps if they do not lie within it.
+func tupSlice(lo, hi int64, n int) int64 {
+	if lo < 0 || hi < lo-1 || hi >= int64(n) {
+		tupPanic(fmt.Sprintf("slice [%d..%d] out of range [0:%d]", lo, hi, n))
+	}
+	return hi + 1
+}
+
+// tupSliceOf returns an array of elements lo through hi of elems.
+func tupSliceOf[T any](elems []T, lo, hi int64) TupArray[T] {
+	return TupArrayOf(elems[lo:tupSlice(lo, hi, len(elems))]...)
+}
+
+// tupSubstring returns bytes lo through hi of s.
+func tupSubstring(s string, lo, hi int64) string { return s[lo:tupSlice(lo, hi, len(s))] }
+
+// tupSameFunc reports whether the function values x and y run the same
+// code, as the closures of a function do.
+func tupSameFunc(x, y any) bool {
+	return reflect.ValueOf(x).Pointer() == reflect.ValueOf(y).Pointer()
+}
+
+// tupSameClosure reports whether the function values x and y are the same
+// closure, or the same function. Go holds a function value as a pointer
+// to its code followed by the values it captures, which is made once for
+// each closure and once for a function that captures nothing.
+func tupSameClosure[F any](x, y F) bool {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&x)) == *(*unsafe.Pointer)(unsafe.Pointer(&y))
+}
+
+// tupAs returns the member of type T a union holds, trapping with msg if it
+// holds another.
+func tupAs[T any](v any, msg string) T {
+	m, ok := v.(T)
+	if !ok {
+		tupPanic(msg)
+	}
+	return m
+}
+
+// Integer arithmetic, which traps on overflow and division by zero. The
+// functions ending in OK report overflow instead, for the checked
+// operators.
+
+type tupInteger interface {
+	~int8 | ~int16 | ~int32 | ~int64 | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+type tupFloatType interface {
+	~float32 | ~float64
+}
+
+func tupSigned[T tupInteger]() bool {
+	var zero T
+	return ^zero < 0
+}
+
+func tupBits[T tupInteger]() int {
+	var zero T
+	return 8 * int(unsafe.Sizeof(zero))
+}
+
+// tupMin returns the least value of T, the most negative if T is signed.
+func tupMin[T tupInteger]() T {
+	if !tupSigned[T]() {
+		return 0
+	}
+	return T(1) << (tupBits[T]() - 1)
+}
+
+func tupOverflow() { tupPanic("integer overflow") }
+
+// tupConv converts the integer x to the integer type U, trapping if the
+// value does not fit.
+func tupConv[U, T tupInteger](x T) U {
+	u := U(x)
+	if T(u) != x || (x < 0) != (u < 0) {
+		tupOverflow()
+	}
+	return u
+}
+
+func tupAddOK[T tupInteger](x, y T, z *T) bool {
+	s := x + y
+	if tupSigned[T]() {
+		if y > 0 && s < x || y < 0 && s > x {
+			return false
+		}
+	} else if s < x {
+		return false
+	}
+	*z = s
+	return true
+}
+
+func tupSubOK[T tupInteger](x, y T, z *T) bool {
+	d := x - y
+	if tupSigned[T]() {
+		if y > 0 && d > x || y < 0 && d < x {
+			return false
+		}
+	} else if x < y {
+		return false
+	}
+	*z = d
+	return true
+}
+
+func tupMulOK[T tupInteger](x, y T, z *T) bool {
+	if x == 0 || y == 0 {
+		*z = 0
+		return true
+	}
+	p := x * y
+	if p/y != x || tupSigned[T]() && y == ^T(0) && x == tupMin[T]() {
+		return false
+	}
+	*z = p
+	return true
+}
+
+// tupDivOK reports false for division by zero as well as overflow.
+func tupDivOK[T tupInteger](x, y T, z *T) bool {
+	if y == 0 || tupSigned[T]() && y == ^T(0) && x == tupMin[T]() {
+		return false
+	}
+	*z = x / y
+	return true
+}
+
+func tupAdd[T tupInteger](x, y T) T {
+	var z T
+	if !tupAddOK(x, y, &z) {
+		tupOverflow()
+	}
+	return z
+}
+
+func tupSub[T tupInteger](x, y T) T {
+	var z T
+	if !tupSubOK(x, y, &z) {
+		tupOverflow()
+	}
+	return z
+}
+
+func tupMul[T tupInteger](x, y T) T {
+	var z T
+	if !tupMulOK(x, y, &z) {
+		tupOverflow()
+	}
+	return z
+}
+
+func tupDiv[T tupInteger](x, y T) T {
+	if y == 0 {
+		tupPanic("division by zero")
+	}
+	var z T
+	if !tupDivOK(x, y, &z) {
+		tupOverflow()
+	}
+	return z
+}
+
+func tupMod[T tupInteger](x, y T) T {
+	if y == 0 {
+		tupPanic("division by zero")
+	}
+	return x % y
+}
+
+func tupNeg[T tupInteger](x T) T {
+	var z T
+	if !tupSubOK(0, x, &z) {
+		tupOverflow()
+	}
+	return z
+}
+
+func tupPow[T tupInteger](x, y T) T {
+	if y < 0 {
+		tupPanic("negative exponent")
+	}
+	r := T(1)
+	for y > 0 {
+		if y&1 != 0 {
+			r = tupMul(r, x)
+		}
+		y >>= 1
+		// once |x| is at least 2, a square that overflows would make the
+		// result overflow too
+		if y > 0 {
+			x = tupMul(x, x)
+		}
+	}
+	return r
+}
+
+func tupShl[T tupInteger](x, n T) T {
+	if n < 0 {
+		tupPanic("negative shift count")
+	}
+	if x == 0 {
+		return 0
+	}
+	if n >= T(tupBits[T]()) {
+		tupOverflow()
+	}
+	r := x << n
+	if r>>n != x {
+		tupOverflow()
+	}
+	return r
+}
+
+func tupShr[T tupInteger](x, n T) T {
+	if n < 0 {
+		tupPanic("negative shift count")
+	}
+	return x >> n
+}
+
+// tupCmp returns -1, 0 or 1 as x is less than, equal to or greater than y.
+func tupCmp[T tupInteger | tupFloatType | ~string](x, y T) int64 {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// Floating-point arithmetic, which traps on overflow and division by zero.
+
+func tupFloat[T tupFloatType](x T) T {
+	if math.IsInf(float64(x), 0) || math.IsNaN(float64(x)) {
+		tupPanic("floating-point overflow")
+	}
+	return x
+}
+
+func tupFloatOK[T tupFloatType](x T, z *T) bool {
+	if math.IsInf(float64(x), 0) || math.IsNaN(float64(x)) {
+		return false
+	}
+	*z = x
+	return true
+}
+
+func tupDivFloat[T tupFloatType](x, y T) T {
+	if y == 0 {
+		tupPanic("division by zero")
+	}
+	return tupFloat(x / y)
+}
+
+func tupPowFloat[T tupFloatType](x, y T) T {
+	return tupFloat(T(math.Pow(float64(x), float64(y))))
+}
+
+// Text. The text of a value is written to a builder. Strings are quoted
+// when they are part of a larger value.
+
+func tupFmtInt(b *strings.Builder, v int64) { b.WriteString(strconv.FormatInt(v, 10)) }
+
+func tupFmtUint(b *strings.Builder, v uint64) { b.WriteString(strconv.FormatUint(v, 10)) }
+
+func tupFmtNil(b *strings.Builder, _ TupNil) { b.WriteString("nil") }
+
+func tupFmtBool(b *strings.Builder, v bool) { b.WriteString(strconv.FormatBool(v)) }
+
+// tupFmtFloat writes the shortest text reading back as v, with a decimal
+// point if it would have neither one nor an exponent.
+func tupFmtFloat(b *strings.Builder, v float64) {
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	b.WriteString(s)
+	if !strings.ContainsAny(s, ".eEnN") {
+		b.WriteString(".0")
+	}
+}
+
+func tupFmtString(b *strings.Builder, s string, quote bool) {
+	if quote {
+		s = strconv.Quote(s)
+	}
+	b.WriteString(s)
+}
+
+func tupFmtSymbol(b *strings.Builder, s TupSymbol) {
+	b.WriteString(":")
+	b.WriteString(string(s))
+}
+
+func tupFmtError(b *strings.Builder, e TupError) {
+	b.WriteString("error(")
+	b.WriteString(strconv.Quote(e.Msg))
+	b.WriteString(")")
+}
+
+// Host functions.
+
+func tupPrint(s string) {
+	os.Stdout.WriteString(s + "\n")
+}
@@ -0,0 +1,477 @@
+package gogen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// typedef is a named type or union the package declares.
+type typedef struct {
+	typ  types.Type
+	name string
+	// wrappers holds, for a union, the struct wrapping each member, or ""
+	// for the members that implement the union themselves and for nil.
+	wrappers []string
+}
+
+// gotype returns the Go type holding values of type t, declaring it first
+// if it needs a declaration.
+func (g *generator) gotype(t types.Type) string {
+	if types.Identical(t, types.ErrorType) {
+		return "TupError"
+	}
+	switch u := t.(type) {
+	case *types.Basic:
+		return g.basic(u)
+	case *types.Array:
+		if !u.Fixed() {
+			return "TupArray[" + g.gotype(u.Elem) + "]"
+		}
+		return fmt.Sprintf("[%d]%s", u.Len, g.gotype(u.Elem))
+	case *types.Tuple:
+		names := fieldNames(u, false)
+		fields := make([]string, len(u.Fields))
+		for i, f := range u.Fields {
+			fields[i] = names[i] + " " + g.gotype(f.Type)
+		}
+		if len(fields) == 0 {
+			return "struct{}"
+		}
+		return "struct { " + strings.Join(fields, "; ") + " }"
+	case *types.Function:
+		return g.funcType(u)
+	case *types.TypeParam:
+		return typeParamName(u)
+	}
+	return g.typedef(t).name
+}
+
+func (g *generator) basic(t *types.Basic) string {
+	switch t.Kind() {
+	case types.Nil:
+		return "TupNil"
+	case types.Bool:
+		return "bool"
+	case types.Int8, types.Int16, types.Int32, types.Int64:
+		return fmt.Sprintf("int%d", bits(t))
+	case types.UInt8, types.UInt16, types.UInt32, types.UInt64:
+		return fmt.Sprintf("uint%d", bits(t))
+	case types.Float16, types.Float32:
+		return "float32"
+	case types.Float64:
+		return "float64"
+	case types.String:
+		return "string"
+	case types.Symbol:
+		return "TupSymbol"
+	case types.TypeDescriptor:
+		return "TupType"
+	}
+	g.errorf("%s has no Go representation", t)
+	return ""
+}
+
+// bits returns the size in bits of the integer type t.
+func bits(t types.Type) int {
+	switch t.Underlying().(*types.Basic).Kind() {
+	case types.Int8, types.UInt8:
+		return 8
+	case types.Int16, types.UInt16:
+		return 16
+	case types.Int32, types.UInt32:
+		return 32
+	}
+	return 64
+}
+
+func (g *generator) funcType(t *types.Function) string {
+	params := make([]string, len(t.Params))
+	for i, p := range t.Params {
+		params[i] = g.gotype(p.Type)
+	}
+	return fmt.Sprintf("func(%s)%s", strings.Join(params, ", "), g.result(t))
+}
+
+// typeName returns the Go name of the named type t.
+func typeName(t *types.Named) string {
+	if !isIdent(t.Name()) {
+		return mangle("T", t.Name())
+	}
+	name := capitalize(t.Name())
+	if runtimeNames[name] {
+		name += "_"
+	}
+	return name
+}
+
+// fieldNames returns the names of the fields of the struct holding the
+// tuple t: its labels capitalized, or F0, F1 and so on if it is unlabeled
+// or a label would not make a distinct exported name. The fields of errors
+// are not named Error, which is the name of their method.
+func fieldNames(t *types.Tuple, isError bool) []string {
+	names := make([]string, len(t.Fields))
+	seen := map[string]bool{}
+	for i, f := range t.Fields {
+		name := capitalize(f.Name)
+		if !isIdent(f.Name) || seen[name] || isError && name == "Error" {
+			names = names[:0]
+			break
+		}
+		names[i] = name
+		seen[name] = true
+	}
+	if len(names) < len(t.Fields) {
+		names = names[:len(t.Fields)]
+		for i := range names {
+			names[i] = "F" + strconv.Itoa(i)
+		}
+	}
+	return names
+}
+
+// fields returns the names of the fields of the struct holding values of
+// the tuple type t.
+func fields(t types.Type) []string {
+	named, _ := t.(*types.Named)
+	return fieldNames(t.Underlying().(*types.Tuple), named != nil && named.HasAnnotation("error"))
+}
+
+// implements reports whether the Go type holding values of t may have
+// methods, and so implements the unions t is a member of itself: the
+// named types declared as defined types, and TupError. Named dynamic
+// arrays are aliases of TupArray, and interfaces cannot have methods.
+func implements(t types.Type) bool {
+	if types.Identical(t, types.ErrorType) {
+		return true
+	}
+	if _, ok := t.(*types.Named); !ok {
+		return false
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Array:
+		return u.Fixed()
+	case *types.Union:
+		return false
+	}
+	return true
+}
+
+// typedef returns the declaration of the named type or union t, declaring
+// it first, after the types it is made of, if need be.
+func (g *generator) typedef(t types.Type) *typedef {
+	for _, td := range g.types {
+		if types.Identical(td.typ, t) {
+			return td
+		}
+	}
+	td := &typedef{typ: t, name: "union" + strconv.Itoa(len(g.types))}
+	if named, ok := t.(*types.Named); ok {
+		td.name = typeName(named)
+	}
+	// the type is recorded before its components are declared, so that a
+	// type may refer to itself
+	g.types = append(g.types, td)
+	name := td.name
+
+	var b strings.Builder
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		fmt.Fprintf(&b, "\ntype %s %s\n", name, g.basic(u))
+	case *types.Enum:
+		fmt.Fprintf(&b, "\ntype %s int64\n\nconst (\n", name)
+		for _, m := range u.Members {
+			fmt.Fprintf(&b, "\t%s %s = %d\n", mangle(name, m.Name), name, m.Value)
+		}
+		b.WriteString(")\n")
+	case *types.Function:
+		fmt.Fprintf(&b, "\ntype %s %s\n", name, g.funcType(u))
+	case *types.Array:
+		if u.Fixed() {
+			fmt.Fprintf(&b, "\ntype %s [%d]%s\n", name, u.Len, g.gotype(u.Elem))
+		} else {
+			fmt.Fprintf(&b, "\ntype %s = TupArray[%s]\n", name, g.gotype(u.Elem))
+		}
+	case *types.Tuple:
+		names := fields(t)
+		fmt.Fprintf(&b, "\ntype %s struct {\n", name)
+		for i, f := range u.Fields {
+			fmt.Fprintf(&b, "\t%s %s\n", names[i], g.gotype(f.Type))
+		}
+		b.WriteString("}\n")
+		if named := t.(*types.Named); named.HasAnnotation("error") {
+			g.usesStrings = true
+			fmt.Fprintf(&b, "\nfunc (e %s) Error() string {\n\tvar b strings.Builder\n\t%s\n\treturn b.String()\n}\n",
+				name, g.format(t, "&b", "e", "false"))
+		}
+	case *types.Union:
+		fmt.Fprintf(&b, "\n// %s is the union %s.\ntype %s interface {\n\tis%s()\n}\n", name, u, name, name)
+		// the wrappers are named before the members are declared, which
+		// may refer to the union
+		td.wrappers = make([]string, len(u.Members))
+		for i, m := range u.Members {
+			if !types.Identical(m, types.Typ[types.Nil]) && !implements(m) {
+				td.wrappers[i] = fmt.Sprintf("%s_%d", name, i)
+			}
+		}
+		for i, m := range u.Members {
+			switch {
+			case types.Identical(m, types.Typ[types.Nil]):
+				continue
+			case td.wrappers[i] == "":
+				fmt.Fprintf(&b, "\nfunc (%s) is%s() {}\n", g.gotype(m), name)
+			default:
+				fmt.Fprintf(&b, "\n// %s holds the member %s of %s.\ntype %s struct {\n\tV %s\n}\n\nfunc (%s) is%s() {}\n",
+					td.wrappers[i], m, name, td.wrappers[i], g.gotype(m), td.wrappers[i], name)
+			}
+		}
+	default:
+		g.errorf("%s has no Go representation", t)
+	}
+	g.decls.WriteString(b.String())
+	return td
+}
+
+// member returns the index of the member of type m among the members of
+// the union td declares.
+func member(td *typedef, m types.Type) int {
+	for i, t := range td.typ.Underlying().(*types.Union).Members {
+		if types.Identical(t, m) {
+			return i
+		}
+	}
+	return -1
+}
+
+// helper is a Go function the package defines for values of a type.
+type helper struct {
+	kind string // fmt, eq or tag
+	typ  types.Type
+	name string
+}
+
+// helper returns the name of the helper of the given kind for values of
+// type t, defining it first if need be. The fmt helper writes the text of
+// a value to a builder, quoting strings if asked to; the eq helper reports
+// whether two values are equal; the tag helper returns the index of the
+// member a union holds.
+func (g *generator) helper(kind string, t types.Type) string {
+	for _, h := range g.helpers {
+		if h.kind == kind && types.Identical(h.typ, t) {
+			return h.name
+		}
+	}
+	name := kind + "_" + strconv.Itoa(len(g.helpers))
+	g.helpers = append(g.helpers, &helper{kind: kind, typ: t, name: name})
+	gt := g.gotype(t)
+	var sig, body string
+	switch kind {
+	case "fmt":
+		g.usesStrings = true
+		sig = fmt.Sprintf("func %s(b *strings.Builder, v %s, quote bool)", name, gt)
+		body = g.fmtBody(t)
+	case "eq":
+		sig = fmt.Sprintf("func %s(x, y %s) bool", name, gt)
+		body = g.eqBody(t)
+	case "tag":
+		sig = fmt.Sprintf("func %s(v %s) int64", name, gt)
+		body = g.tagBody(t)
+	}
+	fmt.Fprintf(&g.defs, "\n%s {\n%s}\n", sig, body)
+	return name
+}
+
+// format returns a Go statement writing the text of the Go expression v,
+// of type t, to the builder b, quoting strings if the Go expression quote
+// is true.
+func (g *generator) format(t types.Type, b, v, quote string) string {
+	if types.Identical(t, types.ErrorType) {
+		return fmt.Sprintf("tupFmtError(%s, %s)", b, v)
+	}
+	if basic, ok := t.Underlying().(*types.Basic); ok {
+		switch {
+		case basic.Kind() == types.Nil:
+			return fmt.Sprintf("tupFmtNil(%s, TupNil(%s))", b, v)
+		case basic.Kind() == types.Bool:
+			return fmt.Sprintf("tupFmtBool(%s, bool(%s))", b, v)
+		case basic.Kind() == types.String:
+			return fmt.Sprintf("tupFmtString(%s, string(%s), %s)", b, v, quote)
+		case basic.Kind() == types.Symbol:
+			return fmt.Sprintf("tupFmtSymbol(%s, TupSymbol(%s))", b, v)
+		case basic.Kind() == types.TypeDescriptor:
+			return fmt.Sprintf("tupFmtString(%s, tupTypes[%s], false)", b, v)
+		case types.IsUnsigned(basic):
+			return fmt.Sprintf("tupFmtUint(%s, uint64(%s))", b, v)
+		case types.IsInteger(basic):
+			return fmt.Sprintf("tupFmtInt(%s, int64(%s))", b, v)
+		case types.IsFloat(basic):
+			return fmt.Sprintf("tupFmtFloat(%s, float64(%s))", b, v)
+		}
+	}
+	return fmt.Sprintf("%s(%s, %s, %s)", g.helper("fmt", t), b, v, quote)
+}
+
+func (g *generator) fmtBody(t types.Type) string {
+	var b strings.Builder
+	switch u := t.Underlying().(type) {
+	case *types.Tuple:
+		names := fields(t)
+		b.WriteString("\tb.WriteString(\"(\")\n")
+		for i, f := range u.Fields {
+			prefix := f.Name
+			if prefix != "" {
+				prefix += ": "
+			}
+			if i > 0 {
+				prefix = ", " + prefix
+			}
+			if prefix != "" {
+				fmt.Fprintf(&b, "\tb.WriteString(%s)\n", strconv.Quote(prefix))
+			}
+			fmt.Fprintf(&b, "\t%s\n", g.format(f.Type, "b", "v."+names[i], "true"))
+		}
+		if len(u.Fields) == 1 && u.Fields[0].Name == "" {
+			b.WriteString("\tb.WriteString(\",\")\n")
+		}
+		b.WriteString("\tb.WriteString(\")\")\n")
+	case *types.Array:
+		elems := "v"
+		if !u.Fixed() {
+			elems = "v.Slice()"
+		}
+		b.WriteString("\tb.WriteString(\"[\")\n")
+		fmt.Fprintf(&b, "\tfor i, e := range %s {\n", elems)
+		b.WriteString("\t\tif i > 0 {\n\t\t\tb.WriteString(\", \")\n\t\t}\n")
+		fmt.Fprintf(&b, "\t\t%s\n\t}\n", g.format(u.Elem, "b", "e", "true"))
+		b.WriteString("\tb.WriteString(\"]\")\n")
+	case *types.Union:
+		// the members of unions are written as the values they hold
+		td := g.typedef(t)
+		b.WriteString("\tswitch v := v.(type) {\n")
+		for i, m := range u.Members {
+			switch {
+			case types.Identical(m, types.Typ[types.Nil]):
+				b.WriteString("\tcase nil:\n\t\tb.WriteString(\"nil\")\n")
+			case td.wrappers[i] != "":
+				fmt.Fprintf(&b, "\tcase %s:\n\t\t%s\n", td.wrappers[i], g.format(m, "b", "v.V", "quote"))
+			default:
+				fmt.Fprintf(&b, "\tcase %s:\n\t\t%s\n", g.gotype(m), g.format(m, "b", "v", "quote"))
+			}
+		}
+		b.WriteString("\t}\n")
+	case *types.Enum:
+		name := g.gotype(t)
+		b.WriteString("\tswitch v {\n")
+		for _, m := range u.Members {
+			fmt.Fprintf(&b, "\tcase %s:\n\t\tb.WriteString(%s)\n", mangle(name, m.Name), strconv.Quote(t.String()+"."+m.Name))
+		}
+		b.WriteString("\tdefault:\n\t\ttupFmtInt(b, int64(v))\n\t}\n")
+	case *types.Function:
+		// function values are written as the names of the functions,
+		// known by their code, and closures as fn { ... }; any closure
+		// of a function has its code
+		for _, v := range g.values {
+			text := ir.FuncText(v.fn.Name)
+			if text == "fn { ... }" || !types.Identical(v.typ, t) {
+				continue
+			}
+			code := g.funcName(v.fn.Name)
+			if v.closure != "" {
+				n := v.captures
+				if v.rec {
+					n--
+				}
+				zeros := make([]string, n)
+				for i, p := range v.fn.Params[:n] {
+					zeros[i] = fmt.Sprintf("*new(%s)", g.gotype(p.Typ))
+				}
+				code = fmt.Sprintf("%s(%s)", v.closure, strings.Join(zeros, ", "))
+			}
+			fmt.Fprintf(&b, "\tif tupSameFunc(v, %s) {\n\t\tb.WriteString(%s)\n\t\treturn\n\t}\n", code, strconv.Quote(text))
+		}
+		b.WriteString("\tb.WriteString(\"fn { ... }\")\n")
+	default:
+		g.errorf("cannot convert %s to text", t)
+	}
+	return b.String()
+}
+
+// equal returns a Go expression reporting whether the Go expressions x and
+// y, of type t, are equal.
+func (g *generator) equal(t types.Type, x, y string) string {
+	if types.Identical(t, types.ErrorType) {
+		return fmt.Sprintf("%s == %s", x, y)
+	}
+	switch t.Underlying().(type) {
+	case *types.Basic, *types.Enum:
+		return fmt.Sprintf("%s == %s", x, y)
+	case *types.Function:
+		return fmt.Sprintf("tupSameClosure(%s, %s)", x, y)
+	}
+	return fmt.Sprintf("%s(%s, %s)", g.helper("eq", t), x, y)
+}
+
+func (g *generator) eqBody(t types.Type) string {
+	var b strings.Builder
+	switch u := t.Underlying().(type) {
+	case *types.Tuple:
+		names := fields(t)
+		conds := []string{"true"}
+		if len(u.Fields) > 0 {
+			conds = conds[:0]
+		}
+		for i, f := range u.Fields {
+			conds = append(conds, g.equal(f.Type, "x."+names[i], "y."+names[i]))
+		}
+		fmt.Fprintf(&b, "\treturn %s\n", strings.Join(conds, " &&\n\t\t"))
+	case *types.Array:
+		xs, ys := "x", "y"
+		if !u.Fixed() {
+			xs, ys = "xs", "ys"
+			b.WriteString("\txs, ys := x.Slice(), y.Slice()\n")
+			b.WriteString("\tif len(xs) != len(ys) {\n\t\treturn false\n\t}\n")
+		}
+		fmt.Fprintf(&b, "\tfor i := range %s {\n", xs)
+		fmt.Fprintf(&b, "\t\tif !(%s) {\n\t\t\treturn false\n\t\t}\n\t}\n", g.equal(u.Elem, xs+"[i]", ys+"[i]"))
+		b.WriteString("\treturn true\n")
+	case *types.Union:
+		td := g.typedef(t)
+		b.WriteString("\tswitch x := x.(type) {\n")
+		for i, m := range u.Members {
+			switch {
+			case types.Identical(m, types.Typ[types.Nil]):
+				b.WriteString("\tcase nil:\n\t\treturn y == nil\n")
+			case td.wrappers[i] != "":
+				fmt.Fprintf(&b, "\tcase %s:\n\t\ty, ok := y.(%s)\n\t\treturn ok && %s\n", td.wrappers[i], td.wrappers[i], g.equal(m, "x.V", "y.V"))
+			default:
+				gt := g.gotype(m)
+				fmt.Fprintf(&b, "\tcase %s:\n\t\ty, ok := y.(%s)\n\t\treturn ok && %s\n", gt, gt, g.equal(m, "x", "y"))
+			}
+		}
+		b.WriteString("\t}\n\treturn false\n")
+	default:
+		g.errorf("cannot compare values of %s", t)
+	}
+	return b.String()
+}
+
+func (g *generator) tagBody(t types.Type) string {
+	var b strings.Builder
+	td := g.typedef(t)
+	b.WriteString("\tswitch v.(type) {\n")
+	for i, m := range t.Underlying().(*types.Union).Members {
+		switch {
+		case types.Identical(m, types.Typ[types.Nil]):
+			fmt.Fprintf(&b, "\tcase nil:\n\t\treturn %d\n", i)
+		case td.wrappers[i] != "":
+			fmt.Fprintf(&b, "\tcase %s:\n\t\treturn %d\n", td.wrappers[i], i)
+		default:
+			fmt.Fprintf(&b, "\tcase %s:\n\t\treturn %d\n", g.gotype(m), i)
+		}
+	}
+	b.WriteString("\t}\n\treturn -1\n")
+	return b.String()
+}
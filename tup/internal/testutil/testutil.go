@@ -0,0 +1,112 @@
+// Package testutil holds what the tests of the backends share: lowering
+// programs to the IR, and running a test on each example that lowers.
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/opt"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+)
+
+// A LowerFunc checks input, read from filename, lowers it to the IR and
+// optimizes it.
+type LowerFunc func(t testing.TB, filename, input string) (*ir.Module, error)
+
+// Lower checks input, lowers it to the IR and optimizes it.
+func Lower(t testing.TB, filename, input string) (*ir.Module, error) {
+	t.Helper()
+	return lower(t, filename, input, ir.Lower)
+}
+
+// LowerGeneric checks input, lowers it to the IR, keeping generic the
+// generic functions that need no instances, and optimizes it.
+func LowerGeneric(t testing.TB, filename, input string) (*ir.Module, error) {
+	t.Helper()
+	return lower(t, filename, input, ir.LowerGeneric)
+}
+
+func lower(t testing.TB, filename, input string, lowerModule func(*ast.Module, *check.Info) (*ir.Module, error)) (*ir.Module, error) {
+	t.Helper()
+	module, err := parse.Module(source.NewSource([]byte(input), filename), ast.NewModule(filename))
+	if err != nil {
+		return nil, err
+	}
+	info, err := check.Module(module)
+	if err != nil {
+		return nil, err
+	}
+	m, err := lowerModule(module, info)
+	if err != nil {
+		return nil, err
+	}
+	if err := ir.Verify(m); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	if err := opt.NewManager().Run(m); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	return m, nil
+}
+
+// Unlowered lists the examples Examples expects not to lower, with the
+// reason. Any other example that fails to lower fails the test, as does a
+// listed example that lowers.
+var Unlowered = map[string]string{
+	"annotations":               "annotations of top-level declarations are not yet parsed",
+	"array_literals":            "array literals continued by a leading comma are not yet parsed",
+	"assignments":               "top-level destructuring assignments are not yet parsed",
+	"contract":                  "nilable contract fields are not yet parsed",
+	"enum":                      "top-level statements are not yet parsed",
+	"fixed_size_array_literals": "the example holds invalid array literals",
+	"for":                       "top-level statements are not yet parsed",
+	"functions":                 "union result types of function types are not yet parsed",
+	"if":                        "top-level statements are not yet parsed",
+	"inline_for":                "top-level statements are not yet parsed",
+	"list":                      "the example redeclares empty, which it imports, and uses an undefined x",
+	"multi_line_string_literal": "the sql string is tagged with its own name as processor",
+	"switch":                    "top-level statements are not yet parsed",
+	"try":                       "union result types of function types are not yet parsed",
+	"tuple_literals":            "one-element tuple literals are not yet parsed",
+	"types":                     "string keys in annotations are not yet parsed",
+	"union":                     "union member annotations are not yet parsed",
+}
+
+// Examples lowers each example in ../../examples with lower and runs test
+// on those that lower, as a subtest named after the example. It skips the
+// examples listed in Unlowered.
+func Examples(t *testing.T, lower LowerFunc, test func(t *testing.T, name string, m *ir.Module)) {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("..", "..", "examples", "*.tup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range files {
+		name := strings.TrimSuffix(filepath.Base(filename), ".tup")
+		t.Run(name, func(t *testing.T) {
+			contents, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := lower(t, filename, string(contents))
+			reason, listed := Unlowered[name]
+			if err != nil {
+				if !listed {
+					t.Fatalf("not lowered: %v", err)
+				}
+				t.Skipf("not lowered: %s: %v", reason, err)
+			}
+			if listed {
+				t.Fatalf("lowered, but listed as unlowered (%s): remove it from Unlowered", reason)
+			}
+			test(t, name, m)
+		})
+	}
+}
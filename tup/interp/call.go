@@ -0,0 +1,359 @@
+package interp
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/bind"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+func (in *Interpreter) call(env *env, e *ast.FunctionCall) (consteval.Value, error) {
+	if name := in.info.Builtins[e]; name != "" {
+		return in.builtin(env, e, name)
+	}
+	if partial := in.info.Partials[e]; partial != nil {
+		return in.block(env, partial.Lowered)
+	}
+
+	var callee, recv consteval.Value
+	var recvExpr ast.Expression
+	name := e.Function.String()
+	switch fn := e.Function.(type) {
+	case *ast.MemberAccess:
+		object, ok := fn.Object.(ast.Expression)
+		member, isIdent := fn.Member.(*ast.Identifier)
+		if !ok || !isIdent {
+			return nil, errorf(e, "%s is not supported by the interpreter", e)
+		}
+		v, err := in.eval(env, object)
+		if err != nil {
+			return nil, err
+		}
+		if tuple, ok := v.(*consteval.Tuple); ok && tuple.FieldIndex(member.Name) >= 0 {
+			callee = tuple.Fields[tuple.FieldIndex(member.Name)].Value
+		} else {
+			// uniform function call syntax: recv.f(args) calls f(recv, args)
+			recv, recvExpr, name = v, object, member.Name
+			if callee, err = in.lookup(env, member, member.Name); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		var err error
+		if callee, err = in.eval(env, e.Function); err != nil {
+			return nil, err
+		}
+	}
+	if obj := in.info.Overloads[e]; obj != nil {
+		// one of several overloads
+		callee = in.overload(obj)
+	}
+	fn, ok := callee.(*Func)
+	if !ok {
+		return nil, errorf(e.Function, "cannot call %s: it is not a function", callee)
+	}
+
+	b := in.info.Calls[e]
+	if b == nil {
+		var errs []*bind.Error
+		b, errs = bind.Bind(bind.Params(fn.Sig, fn.params()), bind.NewCall(e, recvExpr, name))
+		if len(errs) > 0 {
+			return nil, errorf(errs[0].Node, "%s", errs[0].Msg)
+		}
+	}
+
+	// the arguments are evaluated in the order written, after the
+	// receiver
+	values := map[*ast.Argument]consteval.Value{}
+	var written []*ast.Argument
+	if args := e.Arguments; args != nil {
+		if args.Args != nil {
+			written = append(written, args.Args.Args...)
+		}
+		if args.LabeledArgs != nil {
+			for _, arg := range args.LabeledArgs.Args {
+				written = append(written, arg.Argument)
+			}
+		}
+	}
+	for _, arg := range written {
+		if _, ok := values[arg]; ok {
+			continue
+		}
+		v, err := in.eval(env, arg.Expr)
+		if err != nil {
+			return nil, err
+		}
+		values[arg] = v
+	}
+
+	args := make([][]consteval.Value, len(b.Args))
+	for i, bound := range b.Args {
+		for _, arg := range bound {
+			v, ok := values[arg]
+			if !ok {
+				// the receiver, a trailing block or a value piped into
+				// the call
+				var err error
+				if arg.Expr == recvExpr {
+					v = recv
+				} else if v, err = in.eval(env, arg.Expr); err != nil {
+					return nil, err
+				}
+			}
+			if !arg.Spread {
+				args[i] = append(args[i], v)
+				continue
+			}
+			array, ok := v.(*consteval.Array)
+			if !ok {
+				return nil, errorf(arg, "cannot spread %s: not an array", v)
+			}
+			args[i] = append(args[i], array.Elems...)
+		}
+	}
+	return in.apply(e, fn, &callArgs{binding: b, values: args})
+}
+
+// callArgs holds the values of the arguments bound to each parameter of
+// the function called.
+type callArgs struct {
+	binding *bind.Binding
+	values  [][]consteval.Value
+}
+
+// callValue calls the function fn with the positional arguments args.
+func (in *Interpreter) callValue(node ast.Node, fn consteval.Value, args ...consteval.Value) (consteval.Value, error) {
+	f, ok := fn.(*Func)
+	if !ok {
+		return nil, errorf(node, "cannot call %s: it is not a function", fn)
+	}
+	params := bind.Params(f.Sig, f.params())
+	b := &bind.Binding{
+		Params:   params,
+		Args:     make([][]*ast.Argument, len(params)),
+		Defaults: make([]ast.Expression, len(params)),
+	}
+	values := make([][]consteval.Value, len(params))
+	for i, param := range params {
+		switch {
+		case i < len(args):
+			values[i] = []consteval.Value{args[i]}
+		case param.Default != nil:
+			b.Defaults[i] = param.Default
+		}
+	}
+	if len(args) > len(params) && f.Block == nil {
+		return nil, errorf(node, "too many arguments in call to %s", f)
+	}
+	if f.Block != nil && len(params) == 0 {
+		// a block whose type is not known takes the arguments as given
+		values = make([][]consteval.Value, len(args))
+		for i, arg := range args {
+			values[i] = []consteval.Value{arg}
+		}
+	}
+	return in.apply(node, f, &callArgs{binding: b, values: values})
+}
+
+// apply calls fn with the arguments args. Parameters receiving no argument
+// take their default values, evaluated in the scope fn was declared in.
+func (in *Interpreter) apply(node ast.Node, fn *Func, args *callArgs) (consteval.Value, error) {
+	if in.depth >= maxDepth {
+		return nil, errorf(node, "call stack exceeded %d nested calls", maxDepth)
+	}
+	in.depth++
+	defer func() { in.depth-- }()
+
+	env := newEnv(fn.env)
+	var v consteval.Value
+	var err error
+	if fn.Block != nil {
+		v, err = in.closure(env, fn, args.values)
+	} else {
+		if err := in.bindParams(node, env, fn, args); err != nil {
+			return nil, err
+		}
+		if fn.Decl.Body == nil {
+			return nil, errorf(node, "%s has no body", fn)
+		}
+		v, err = in.block(env, fn.Decl.Body)
+	}
+	if s, ok := err.(*signal); ok && s.kind == returnSignal {
+		v, err = s.value, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fn.Sig != nil && fn.Sig.Result != nil {
+		if v, err = consteval.Convert(v, fn.Sig.Result); err != nil {
+			return nil, failure(node, err)
+		}
+	}
+	return v, nil
+}
+
+// bindParams binds the parameters of the declared function fn in env.
+func (in *Interpreter) bindParams(node ast.Node, env *env, fn *Func, args *callArgs) error {
+	if args.binding == nil {
+		return nil
+	}
+	// a call through a function value is bound to the parameters of its
+	// type, which may be unnamed; the declaration names them
+	own := bind.Params(fn.Sig, fn.params())
+	for i, param := range args.binding.Params {
+		var typ types.Type
+		if fn.Sig != nil && i < len(fn.Sig.Params) {
+			typ = fn.Sig.Params[i].Type
+		}
+		var v consteval.Value
+		switch {
+		case param.Rest:
+			if typ == nil {
+				typ = types.NewArray(types.Typ[types.Invalid])
+			}
+			elems := args.values[i]
+			if array, ok := typ.Underlying().(*types.Array); ok {
+				for j, elem := range elems {
+					elem, err := consteval.Convert(elem, array.Elem)
+					if err != nil {
+						return failure(node, err)
+					}
+					elems[j] = elem
+				}
+			}
+			v = &consteval.Array{Elems: elems, Typ: typ}
+		case len(args.values[i]) > 0:
+			v = args.values[i][0]
+		case args.binding.Defaults[i] != nil:
+			var err error
+			if v, err = in.eval(fn.env, args.binding.Defaults[i]); err != nil {
+				return err
+			}
+		default:
+			return errorf(node, "missing argument for parameter %d in call to %s", i+1, fn)
+		}
+		var err error
+		if typ != nil {
+			v, err = consteval.Convert(v, typ)
+		} else {
+			v, err = settle(v)
+		}
+		if err != nil {
+			return failure(node, err)
+		}
+		name := param.Name
+		if i < len(own) {
+			name = own[i].Name
+		}
+		if name != "" {
+			env.vars[name] = v
+		}
+	}
+	return nil
+}
+
+// closure evaluates the body of a block called with the values bound to
+// each of its parameters. The block's parameters, or it if it declares
+// none and takes a single argument, receive the arguments.
+func (in *Interpreter) closure(env *env, fn *Func, values [][]consteval.Value) (consteval.Value, error) {
+	var args []consteval.Value
+	for i, vs := range values {
+		v := consteval.Value(consteval.Nil{})
+		switch {
+		case fn.Sig != nil && i == fn.Sig.Rest:
+			v = &consteval.Array{Elems: vs, Typ: fn.Sig.Params[i].Type}
+		case len(vs) > 0:
+			v = vs[0]
+		}
+		args = append(args, v)
+	}
+	block := fn.Block
+	switch {
+	case block.Parameters != nil && block.Parameters.Parameters != nil:
+		var v consteval.Value
+		if len(args) == 1 {
+			v = args[0]
+		} else {
+			tuple := &consteval.Tuple{}
+			for _, arg := range args {
+				tuple.Fields = append(tuple.Fields, consteval.Field{Value: arg})
+			}
+			v = tuple
+		}
+		if err := in.bindLHS(env, block, block.Parameters.Parameters, v); err != nil {
+			return nil, err
+		}
+	case len(args) == 1:
+		env.vars["it"] = args[0]
+	}
+	return in.body(env, block.Body)
+}
+
+// builtin evaluates a call of a builtin function or of a function
+// synthesized for enum types.
+func (in *Interpreter) builtin(env *env, e *ast.FunctionCall, name string) (consteval.Value, error) {
+	var args []consteval.Value
+	if member, ok := e.Function.(*ast.MemberAccess); ok {
+		if object, ok := member.Object.(ast.Expression); ok {
+			v, err := in.eval(env, object)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+	}
+	if e.Arguments != nil && e.Arguments.Args != nil {
+		for _, arg := range e.Arguments.Args.Args {
+			v, err := in.eval(env, arg.Expr)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+	}
+
+	switch name {
+	case "print":
+		if _, err := fmt.Fprintln(in.Stdout, formatArgs(args)); err != nil {
+			return nil, errorf(e, "print: %s", err)
+		}
+		return consteval.Nil{}, nil
+	case "sizeof":
+		if v := in.info.Values[e]; v != nil {
+			return v, nil
+		}
+		return nil, errorf(e, "size of %s is not known", e)
+	}
+	if len(args) != 1 {
+		return nil, errorf(e, "%s takes a single argument", name)
+	}
+	switch name {
+	case "len":
+		switch v := args[0].(type) {
+		case *consteval.Array:
+			return intValue(len(v.Elems)), nil
+		case *consteval.Tuple:
+			return intValue(len(v.Fields)), nil
+		case consteval.String:
+			return intValue(len(v)), nil
+		}
+		return nil, errorf(e, "invalid argument %s for len", args[0])
+	case "int":
+		if v, ok := args[0].(*consteval.Enum); ok {
+			return &consteval.Int{Val: big.NewInt(v.Member.Value), Typ: types.Int}, nil
+		}
+	case "string":
+		if v, ok := args[0].(*consteval.Enum); ok {
+			return consteval.String(v.Member.Name), nil
+		}
+	}
+	return nil, errorf(e, "invalid argument %s for %s", args[0], name)
+}
+
+func intValue(n int) *consteval.Int {
+	return &consteval.Int{Val: big.NewInt(int64(n)), Typ: types.Int}
+}
@@ -0,0 +1,835 @@
+package interp
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+type signalKind int
+
+const (
+	breakSignal signalKind = iota
+	continueSignal
+	returnSignal
+)
+
+// signal unwinds the evaluation of a loop or function body. It is returned
+// as an error by the break, continue, return or try expression raising it
+// and handled by the loop or call it exits.
+type signal struct {
+	kind signalKind
+	node ast.Node
+	// value is the value of the loop or call exited, or for continue the
+	// next value of the loop variables; it is nil for a continue that
+	// leaves them as they are.
+	value consteval.Value
+}
+
+func (s *signal) Error() string {
+	if s.kind == returnSignal {
+		return errorf(s.node, "%s is not in a function", s.node).Error()
+	}
+	return errorf(s.node, "%s is not in a loop", s.node).Error()
+}
+
+func (in *Interpreter) eval(env *env, expr ast.Expression) (consteval.Value, error) {
+	switch e := expr.(type) {
+	// literals
+	case *ast.IntegerLiteral:
+		return consteval.NewInt(e.IntegerValue), nil
+	case *ast.FloatLiteral:
+		return consteval.NewFloat(e.FloatValue), nil
+	case *ast.BooleanLiteral:
+		return consteval.Bool(e.BooleanValue), nil
+	case *ast.StringLiteral:
+		return consteval.String(e.StringValue), nil
+	case *ast.RawStringLiteral:
+		return consteval.String(e.StringValue), nil
+	case *ast.InterpolatedStringLiteral:
+		return in.interpolatedString(env, e)
+	case *ast.MultiLineStringLiteral:
+		if call := in.info.Processors[e]; call != nil {
+			return in.eval(env, call)
+		}
+		return in.interpolatedString(env, e.Contents)
+	case *ast.SymbolLiteral:
+		return consteval.Symbol(strings.TrimPrefix(e.Value, ":")), nil
+	case *ast.RuneLiteral:
+		return &consteval.Int{Val: big.NewInt(int64(e.RuneValue)), Typ: types.Rune}, nil
+	case *ast.TupleLiteral:
+		return in.tupleLiteral(env, e)
+	case *ast.ArrayLiteral:
+		return in.arrayLiteral(env, e)
+
+	// names
+	case *ast.Identifier:
+		return in.lookup(env, e, e.Name)
+	case *ast.FunctionIdentifier:
+		return in.lookup(env, e, e.Name)
+	case *ast.ItExpression:
+		return in.lookup(env, e, "it")
+
+	// operators
+	case *ast.AddSubExpression:
+		return in.binary(env, e, e.Operator.String(), e.Left, e.Right)
+	case *ast.MulDivExpression:
+		return in.binary(env, e, e.Operator.String(), e.Left, e.Right)
+	case *ast.PowExpression:
+		return in.pow(env, e)
+	case *ast.RelationalComparison:
+		if e.Operator == ast.OpMatch {
+			return nil, errorf(e, "pattern matching with %s is not supported by the interpreter", e.Operator)
+		}
+		x, y, err := in.operands(env, e.Left, e.Right)
+		if err != nil {
+			return nil, err
+		}
+		v, err := consteval.Relation(e.Operator.String(), x, y)
+		if err != nil {
+			return nil, failure(e, err)
+		}
+		return v, nil
+	case *ast.TypeComparison:
+		return in.typeComparison(env, e)
+	case *ast.LogicalOrExpression:
+		return in.logical(env, e.Operands, true)
+	case *ast.LogicalAndExpression:
+		return in.logical(env, e.Operands, false)
+	case *ast.UnaryExpression:
+		x, err := in.eval(env, e.Expression)
+		if err != nil {
+			return nil, err
+		}
+		v, err := consteval.Unary(e.Operator.String(), x)
+		if err != nil {
+			return nil, failure(e, err)
+		}
+		return v, nil
+
+	// control flow
+	case *ast.Block:
+		return in.block(env, e)
+	case *ast.IfExpression:
+		return in.ifExpr(env, e)
+	case *ast.SwitchExpression:
+		return in.switchExpr(env, e)
+	case *ast.ForExpression:
+		return in.forExpr(env, e)
+	case *ast.InlineForExpression:
+		inline := in.info.InlineFors[e]
+		if inline == nil {
+			return nil, errorf(e, "inline for loop was not unrolled")
+		}
+		return in.block(env, inline.Lowered)
+	case *ast.ReturnExpression:
+		return nil, in.jump(env, returnSignal, e, e.Expression)
+	case *ast.BreakExpression:
+		return nil, in.jump(env, breakSignal, e, e.Expression)
+	case *ast.ContinueExpression:
+		return nil, in.jump(env, continueSignal, e, e.Expression)
+	case *ast.TryExpression:
+		return in.try(env, e)
+
+	// calls and access
+	case *ast.FunctionCall:
+		return in.call(env, e)
+	case *ast.FunctionBlock:
+		sig, _ := in.info.Types[e].(*types.Function)
+		return &Func{Block: e, Sig: sig, env: env}, nil
+	case *ast.TypeConstructorCall:
+		return in.construct(env, e)
+	case *ast.MemberAccess:
+		return in.memberAccess(env, e)
+	case *ast.IndexedAccess:
+		return in.index(env, e, e.Object, e.Index)
+	case *ast.SafeIndexedAccess:
+		return in.index(env, e, e.Object, e.Index)
+	case *ast.TupleUpdateExpression:
+		return in.tupleUpdate(env, e)
+	case *ast.Range:
+		return in.rangeExpr(env, e)
+	case *ast.TypeofExpression:
+		return in.typeof(env, e)
+	case *ast.ChainedExpression:
+		pipeline := in.info.Pipelines[e]
+		if pipeline == nil {
+			return nil, errorf(e, "pipeline was not lowered")
+		}
+		return in.eval(env, pipeline.Lowered)
+	}
+	return nil, errorf(expr, "%s is not supported by the interpreter", expr)
+}
+
+func (in *Interpreter) operands(env *env, left, right ast.Expression) (consteval.Value, consteval.Value, error) {
+	x, err := in.eval(env, left)
+	if err != nil {
+		return nil, nil, err
+	}
+	y, err := in.eval(env, right)
+	if err != nil {
+		return nil, nil, err
+	}
+	return x, y, nil
+}
+
+// binary evaluates an arithmetic operator. A result that overflows its type
+// or divides by zero is a runtime error, unless the operator is checked, in
+// which case it is an error value.
+func (in *Interpreter) binary(env *env, node ast.Node, op string, left, right ast.Expression) (consteval.Value, error) {
+	x, y, err := in.operands(env, left, right)
+	if err != nil {
+		return nil, err
+	}
+	v, err := consteval.Binary(op, x, y)
+	if err != nil {
+		return nil, failure(node, err)
+	}
+	return v, nil
+}
+
+// pow evaluates a chain of exponentiations, which group to the right.
+func (in *Interpreter) pow(env *env, e *ast.PowExpression) (consteval.Value, error) {
+	values := make([]consteval.Value, len(e.Operands))
+	for i, operand := range e.Operands {
+		v, err := in.eval(env, operand)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	v := values[len(values)-1]
+	for i := len(values) - 2; i >= 0; i-- {
+		var err error
+		if v, err = consteval.Binary("^", values[i], v); err != nil {
+			return nil, failure(e, err)
+		}
+	}
+	return v, nil
+}
+
+func (in *Interpreter) logical(env *env, operands []ast.Expression, or bool) (consteval.Value, error) {
+	for _, operand := range operands {
+		v, err := in.eval(env, operand)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(consteval.Bool)
+		if !ok {
+			return nil, errorf(operand, "non-Bool %s used in logical expression", v)
+		}
+		if bool(b) == or {
+			return b, nil
+		}
+	}
+	return consteval.Bool(!or), nil
+}
+
+// typeComparison evaluates x is T, which tests the type of the value x
+// holds.
+func (in *Interpreter) typeComparison(env *env, e *ast.TypeComparison) (consteval.Value, error) {
+	ref, ok := e.Right.(*ast.TypeReference)
+	if !ok || len(ref.Identifiers) > 0 {
+		return nil, errorf(e, "%s is not supported by the interpreter", e)
+	}
+	obj := in.info.Scope.Lookup(ref.TypeIdentifier.Name)
+	if obj == nil || obj.Kind != check.TypeObject {
+		return nil, errorf(ref, "undefined type %s", ref)
+	}
+	v, err := in.eval(env, e.Left)
+	if err != nil {
+		return nil, err
+	}
+	return consteval.Bool(hasType(v, obj.Type)), nil
+}
+
+// hasType reports whether v is a value of typ, or of a member of typ if it
+// is a union.
+func hasType(v consteval.Value, typ types.Type) bool {
+	if types.Identical(typ, types.ErrorType) {
+		return isError(v)
+	}
+	if types.Identical(types.Default(v.Type()), typ) {
+		return true
+	}
+	// values built by generic functions have the instances of generic
+	// types they name there, such as Cons[a] for Cons[Int]
+	if x, ok := v.Type().(*types.Named); ok && x.Origin() != nil {
+		if y, ok := typ.(*types.Named); ok && x.Origin() == y.Origin() {
+			return true
+		}
+	}
+	if union, ok := typ.Underlying().(*types.Union); ok {
+		for _, member := range union.Members {
+			if hasType(v, member) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (in *Interpreter) interpolatedString(env *env, lit *ast.InterpolatedStringLiteral) (consteval.Value, error) {
+	var builder strings.Builder
+	for _, part := range lit.Parts {
+		switch part := part.(type) {
+		case *ast.StringLiteral:
+			builder.WriteString(part.StringValue)
+		case *ast.Interpolation:
+			v, err := in.eval(env, part.Expression)
+			if err != nil {
+				return nil, err
+			}
+			if obj := in.info.Stringers[part]; obj != nil {
+				// converted by a declared string function, which may be
+				// one of several overloads
+				if v, err = in.callValue(part, in.overload(obj), v); err != nil {
+					return nil, err
+				}
+			}
+			builder.WriteString(Format(v))
+		}
+	}
+	return consteval.String(builder.String()), nil
+}
+
+func (in *Interpreter) tupleLiteral(env *env, lit *ast.TupleLiteral) (consteval.Value, error) {
+	tuple := &consteval.Tuple{}
+	for _, member := range lit.Members {
+		v, err := in.eval(env, member.Value)
+		if err != nil {
+			return nil, err
+		}
+		if member.Spread {
+			spread, ok := v.(*consteval.Tuple)
+			if !ok {
+				return nil, errorf(member.Value, "cannot spread %s: not a tuple", v)
+			}
+			tuple.Fields = append(tuple.Fields, spread.Fields...)
+			continue
+		}
+		if v, err = settle(v); err != nil {
+			return nil, failure(member.Value, err)
+		}
+		name := ""
+		if member.Label != nil {
+			name = member.Label.Name
+		}
+		tuple.Fields = append(tuple.Fields, consteval.Field{Name: name, Value: v})
+	}
+	return tuple, nil
+}
+
+func (in *Interpreter) arrayLiteral(env *env, lit *ast.ArrayLiteral) (consteval.Value, error) {
+	typ := in.info.Types[lit]
+	array, ok := typ.Underlying().(*types.Array)
+	if !ok {
+		return nil, errorf(lit, "type of %s is not known", lit)
+	}
+	var elems []consteval.Value
+	add := func(node ast.Node, v consteval.Value) error {
+		v, err := consteval.Convert(v, array.Elem)
+		if err != nil {
+			return failure(node, err)
+		}
+		elems = append(elems, v)
+		return nil
+	}
+	for _, element := range lit.Elements {
+		v, err := in.eval(env, element)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(element, v); err != nil {
+			return nil, err
+		}
+	}
+	if lit.Initializer != nil && len(lit.Elements) == 0 {
+		// each element of a fixed-size array is initialized from its index
+		init, err := in.eval(env, lit.Initializer)
+		if err != nil {
+			return nil, err
+		}
+		for i := range array.Len {
+			v, err := in.callValue(lit.Initializer, init, &consteval.Int{Val: big.NewInt(i), Typ: types.Int})
+			if err != nil {
+				return nil, err
+			}
+			if err := add(lit.Initializer, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &consteval.Array{Elems: elems, Typ: typ}, nil
+}
+
+// block evaluates a block in a new scope nested in parent.
+func (in *Interpreter) block(parent *env, block *ast.Block) (consteval.Value, error) {
+	if block == nil {
+		return consteval.Nil{}, nil
+	}
+	return in.body(newEnv(parent), block.Body)
+}
+
+// body evaluates the statements of a block body in env, then its final
+// expression, if any, which is its value.
+func (in *Interpreter) body(env *env, body *ast.BlockBody) (consteval.Value, error) {
+	if body == nil {
+		return consteval.Nil{}, nil
+	}
+	return in.statements(env, body.Statements, body.Expression)
+}
+
+func (in *Interpreter) statements(env *env, stmts []ast.Statement, final ast.Expression) (consteval.Value, error) {
+	for _, stmt := range stmts {
+		if err := in.stmt(env, stmt); err != nil {
+			return nil, err
+		}
+	}
+	if final == nil {
+		return consteval.Nil{}, nil
+	}
+	return in.eval(env, final)
+}
+
+func (in *Interpreter) stmt(env *env, stmt ast.Statement) error {
+	switch stmt := stmt.(type) {
+	case *ast.Assignment:
+		return in.assignment(env, stmt)
+	case *ast.CompoundAssignment:
+		x, err := in.lookup(env, stmt.Left, stmt.Left.Name)
+		if err != nil {
+			return err
+		}
+		y, err := in.eval(env, stmt.Right)
+		if err != nil {
+			return err
+		}
+		v, err := consteval.Binary(strings.TrimSuffix(stmt.Operator.String(), "="), x, y)
+		if err != nil {
+			return failure(stmt, err)
+		}
+		if !env.assign(stmt.Left.Name, v) {
+			return errorf(stmt.Left, "cannot assign to %s", stmt.Left.Name)
+		}
+		return nil
+	case *ast.FunctionDeclaration:
+		name := stmt.LHS.Name.Name
+		sig, _ := in.info.Types[stmt].(*types.Function)
+		env.vars[name] = &Func{Decl: stmt, Sig: sig, Name: name, env: env}
+		return nil
+	case *ast.TypeDeclaration, *ast.TypeQualifiedDeclaration, *ast.TypeQualifiedFunctionDeclaration:
+		// types have no run-time effect
+		return nil
+	case ast.Expression:
+		_, err := in.eval(env, stmt)
+		return err
+	}
+	return errorf(stmt, "%s is not supported by the interpreter", stmt)
+}
+
+// assignment binds the names on the left-hand side of an assignment in
+// env, destructuring the value of the right-hand side.
+func (in *Interpreter) assignment(env *env, stmt *ast.Assignment) error {
+	v, err := in.eval(env, stmt.Right)
+	if err != nil {
+		return err
+	}
+	return in.bindLHS(env, stmt, stmt.Left, v)
+}
+
+// bindLHS binds the names of lhs in env to the parts of v.
+func (in *Interpreter) bindLHS(env *env, node ast.Node, lhs ast.AssignmentLHS, v consteval.Value) error {
+	var convErr error
+	err := consteval.Bind(lhs, v, func(ident *ast.Identifier, v consteval.Value) {
+		if v, convErr = settle(v); convErr == nil {
+			env.vars[ident.Name] = v
+		}
+	})
+	if err == nil {
+		err = convErr
+	}
+	if err != nil {
+		return failure(node, err)
+	}
+	return nil
+}
+
+func (in *Interpreter) ifExpr(env *env, e *ast.IfExpression) (consteval.Value, error) {
+	for i, cond := range e.Conditions {
+		expr, ok := cond.(ast.Expression)
+		if !ok {
+			return nil, errorf(cond, "%s is not supported by the interpreter", cond)
+		}
+		v, err := in.eval(env, expr)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(consteval.Bool)
+		if !ok {
+			return nil, errorf(cond, "non-Bool %s used as condition", v)
+		}
+		if b {
+			return in.block(env, e.Blocks[i])
+		}
+	}
+	if e.HasElse {
+		return in.block(env, e.Blocks[len(e.Blocks)-1])
+	}
+	return consteval.Nil{}, nil
+}
+
+// jump evaluates a return, break or continue expression, whose value, if
+// it has one, is carried by the signal it raises.
+func (in *Interpreter) jump(env *env, kind signalKind, node ast.Node, expr ast.Expression) error {
+	s := &signal{kind: kind, node: node}
+	if kind != continueSignal {
+		s.value = consteval.Nil{}
+	}
+	if expr != nil {
+		v, err := in.eval(env, expr)
+		if err != nil {
+			return err
+		}
+		s.value = v
+	}
+	return s
+}
+
+// try evaluates a try expression. An error returns from the enclosing
+// function, breaks out of the enclosing loop with the error as its value,
+// or continues with the next iteration, as the variant of try requires;
+// any other value is the value of the expression.
+func (in *Interpreter) try(env *env, e *ast.TryExpression) (consteval.Value, error) {
+	expr, ok := e.Expression.(ast.Expression)
+	if !ok {
+		return nil, errorf(e, "%s is not supported by the interpreter", e)
+	}
+	if chain, ok := expr.(*ast.ChainedExpression); ok {
+		// the lowered pipeline distributes the try to each stage
+		return in.eval(env, chain)
+	}
+	v, err := in.eval(env, expr)
+	if err != nil || !isError(v) {
+		return v, err
+	}
+	switch e.Variant {
+	case ast.TryBreak:
+		return nil, &signal{kind: breakSignal, node: e, value: v}
+	case ast.TryContinue:
+		return nil, &signal{kind: continueSignal, node: e}
+	}
+	return nil, &signal{kind: returnSignal, node: e, value: v}
+}
+
+// construct evaluates a call of a tuple type, such as P(1, "b"), the
+// conversion of an Int to an enum type, such as Fruit(1), which is nil if
+// no member has the value, or the conversion of an integer to an integer
+// type, such as Int8(n), which fails if the value does not fit.
+func (in *Interpreter) construct(env *env, e *ast.TypeConstructorCall) (consteval.Value, error) {
+	typ := in.info.Types[e]
+	if union, ok := typ.(*types.Union); ok {
+		for _, member := range union.Members {
+			if types.IsEnum(member) {
+				return in.enumConversion(env, e, member)
+			}
+		}
+	}
+	if types.IsInteger(typ) {
+		if e.Arguments == nil || e.Arguments.Args == nil || len(e.Arguments.Args.Args) != 1 {
+			return nil, errorf(e, "conversion to %s requires a single integer argument", typ)
+		}
+		v, err := in.eval(env, e.Arguments.Args.Args[0].Expr)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = consteval.Convert(v, typ); err != nil {
+			return nil, failure(e, err)
+		}
+		return v, nil
+	}
+	tupleType, ok := typ.Underlying().(*types.Tuple)
+	if !ok {
+		return nil, errorf(e, "cannot construct %s", typ)
+	}
+	fields := make([]consteval.Field, len(tupleType.Fields))
+	set := make([]bool, len(fields))
+	assign := func(node ast.Node, i int, expr ast.Expression) error {
+		v, err := in.eval(env, expr)
+		if err != nil {
+			return err
+		}
+		if v, err = consteval.Convert(v, tupleType.Fields[i].Type); err != nil {
+			return failure(node, err)
+		}
+		fields[i], set[i] = consteval.Field{Name: tupleType.Fields[i].Name, Value: v}, true
+		return nil
+	}
+	if args := e.Arguments; args != nil {
+		if args.Args != nil {
+			for i, arg := range args.Args.Args {
+				if arg.Spread || i >= len(fields) {
+					return nil, errorf(arg, "too many arguments in construction of %s", typ)
+				}
+				if err := assign(arg, i, arg.Expr); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if args.LabeledArgs != nil {
+			for _, arg := range args.LabeledArgs.Args {
+				i := tupleType.FieldIndex(arg.Identifier.Name)
+				if i < 0 {
+					return nil, errorf(arg.Identifier, "%s has no field %s", typ, arg.Identifier.Name)
+				}
+				if err := assign(arg, i, arg.Argument.Expr); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for i := range set {
+		if !set[i] {
+			return nil, errorf(e, "missing field %s in construction of %s", tupleType.Fields[i].Name, typ)
+		}
+	}
+	return &consteval.Tuple{Fields: fields, Typ: typ}, nil
+}
+
+func (in *Interpreter) enumConversion(env *env, e *ast.TypeConstructorCall, typ types.Type) (consteval.Value, error) {
+	if e.Arguments == nil || e.Arguments.Args == nil || len(e.Arguments.Args.Args) != 1 {
+		return nil, errorf(e, "conversion to %s requires a single Int argument", typ)
+	}
+	v, err := in.eval(env, e.Arguments.Args.Args[0].Expr)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := v.(*consteval.Int)
+	if !ok {
+		return nil, errorf(e, "cannot convert %s to %s", v, typ)
+	}
+	if x, ok := n.Int64(); ok {
+		if member := typ.Underlying().(*types.Enum).MemberOf(x); member != nil {
+			return &consteval.Enum{Typ: typ, Member: member}, nil
+		}
+	}
+	return consteval.Nil{}, nil
+}
+
+func (in *Interpreter) memberAccess(env *env, e *ast.MemberAccess) (consteval.Value, error) {
+	if _, ok := e.Object.(*ast.TypeIdentifier); ok {
+		// a member of an enum type, such as Fruit.apple
+		typ := in.info.Types[e]
+		if enum, ok := typ.Underlying().(*types.Enum); ok {
+			if member, ok := e.Member.(*ast.Identifier); ok && enum.Member(member.Name) != nil {
+				return &consteval.Enum{Typ: typ, Member: enum.Member(member.Name)}, nil
+			}
+		}
+		return nil, errorf(e, "%s is not supported by the interpreter", e)
+	}
+	object, ok := e.Object.(ast.Expression)
+	if !ok {
+		return nil, errorf(e, "%s is not supported by the interpreter", e)
+	}
+	v, err := in.eval(env, object)
+	if err != nil {
+		return nil, err
+	}
+	tuple, ok := v.(*consteval.Tuple)
+	if !ok {
+		return nil, errorf(e.Member, "%s has no field %s", v, e.Member)
+	}
+	switch member := e.Member.(type) {
+	case *ast.Identifier:
+		if i := tuple.FieldIndex(member.Name); i >= 0 {
+			return tuple.Fields[i].Value, nil
+		}
+	case *ast.IntegerLiteral:
+		if i := member.IntegerValue; i >= 0 && i < int64(len(tuple.Fields)) {
+			return tuple.Fields[i].Value, nil
+		}
+	}
+	return nil, errorf(e.Member, "%s has no field %s", tuple, e.Member)
+}
+
+func (in *Interpreter) index(env *env, node ast.Node, object, index ast.Expression) (consteval.Value, error) {
+	if r, ok := index.(*ast.Range); ok {
+		return in.slice(env, node, object, r)
+	}
+	x, i, err := in.operands(env, object, index)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := i.(*consteval.Int)
+	if !ok {
+		return nil, errorf(index, "invalid index %s", i)
+	}
+	var length int
+	switch x := x.(type) {
+	case *consteval.Array:
+		length = len(x.Elems)
+	case *consteval.Tuple:
+		length = len(x.Fields)
+	case consteval.String:
+		length = len(x)
+	default:
+		return nil, errorf(node, "cannot index %s", x)
+	}
+	if n.Val.Sign() < 0 || !n.Val.IsInt64() || n.Val.Int64() >= int64(length) {
+		return nil, errorf(index, "index %s out of range [0:%d]", n, length)
+	}
+	k := n.Val.Int64()
+	switch x := x.(type) {
+	case *consteval.Array:
+		return x.Elems[k], nil
+	case *consteval.Tuple:
+		return x.Fields[k].Value, nil
+	case consteval.String:
+		return &consteval.Int{Val: big.NewInt(int64(x[k])), Typ: types.Byte}, nil
+	}
+	return nil, errorf(node, "cannot index %s", x)
+}
+
+// slice evaluates the slice object[lo..hi] of an array or string: its
+// elements lo through hi, which must lie within it, or none when hi is
+// lo-1.
+func (in *Interpreter) slice(env *env, node ast.Node, object ast.Expression, index *ast.Range) (consteval.Value, error) {
+	x, err := in.eval(env, object)
+	if err != nil {
+		return nil, err
+	}
+	var length int
+	switch x := x.(type) {
+	case *consteval.Array:
+		length = len(x.Elems)
+	case consteval.String:
+		length = len(x)
+	default:
+		return nil, errorf(node, "cannot slice %s", x)
+	}
+	// a missing bound is that of the whole of x
+	bound := func(b *ast.RangeBound, open int64) (*consteval.Int, error) {
+		if b == nil {
+			return consteval.NewInt(open), nil
+		}
+		v, err := in.eval(env, b.Value)
+		if err != nil {
+			return nil, err
+		}
+		n, ok := v.(*consteval.Int)
+		if !ok {
+			return nil, errorf(b, "invalid slice index %s", v)
+		}
+		return n, nil
+	}
+	lo, err := bound(index.StartBound, 0)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := bound(index.EndBound, int64(length)-1)
+	if err != nil {
+		return nil, err
+	}
+	i, ok1 := lo.Int64()
+	j, ok2 := hi.Int64()
+	if !ok1 || !ok2 || i < 0 || j < i-1 || j >= int64(length) {
+		return nil, errorf(index, "slice [%s..%s] out of range [0:%d]", lo, hi, length)
+	}
+	switch x := x.(type) {
+	case *consteval.Array:
+		return &consteval.Array{Elems: x.Elems[i : j+1 : j+1], Typ: in.info.Types[node]}, nil
+	default:
+		return x.(consteval.String)[i : j+1], nil
+	}
+}
+
+func (in *Interpreter) tupleUpdate(env *env, e *ast.TupleUpdateExpression) (consteval.Value, error) {
+	v, err := in.eval(env, e.Object)
+	if err != nil {
+		return nil, err
+	}
+	tuple, ok := v.(*consteval.Tuple)
+	if !ok {
+		return nil, errorf(e.Object, "cannot update %s: not a tuple", v)
+	}
+	updated := &consteval.Tuple{Fields: append([]consteval.Field(nil), tuple.Fields...), Typ: tuple.Typ}
+	for _, member := range e.Update.Members {
+		if member.Label == nil {
+			return nil, errorf(member, "tuple update requires labeled fields")
+		}
+		i := updated.FieldIndex(member.Label.Name)
+		if i < 0 {
+			return nil, errorf(member.Label, "%s has no field %s", tuple, member.Label.Name)
+		}
+		v, err := in.eval(env, member.Value)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = consteval.Convert(v, updated.Fields[i].Value.Type()); err != nil {
+			return nil, failure(member.Value, err)
+		}
+		updated.Fields[i].Value = v
+	}
+	return updated, nil
+}
+
+// rangeExpr evaluates a range lo..hi to a value of the core Range type. A
+// missing bound of a range of integers is the smallest or largest value
+// of their type.
+func (in *Interpreter) rangeExpr(env *env, e *ast.Range) (consteval.Value, error) {
+	typ := in.info.Types[e]
+	tuple, ok := typ.Underlying().(*types.Tuple)
+	if !ok {
+		return nil, errorf(e, "%s is not a range type", typ)
+	}
+	range_ := &consteval.Tuple{Typ: typ}
+	for i, bound := range []*ast.RangeBound{e.StartBound, e.EndBound} {
+		if bound == nil {
+			min, max, ok := consteval.Bounds(tuple.Fields[i].Type)
+			if !ok {
+				return nil, errorf(e, "open range of %s: only ranges of integers may omit a bound", tuple.Fields[i].Type)
+			}
+			v := max
+			if i == 0 {
+				v = min
+			}
+			range_.Fields = append(range_.Fields, consteval.Field{Name: tuple.Fields[i].Name, Value: v})
+			continue
+		}
+		v, err := in.eval(env, bound.Value)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = consteval.Convert(v, tuple.Fields[i].Type); err != nil {
+			return nil, failure(bound, err)
+		}
+		range_.Fields = append(range_.Fields, consteval.Field{Name: tuple.Fields[i].Name, Value: v})
+	}
+	return range_, nil
+}
+
+// isRange reports whether v is a value of the core Range type.
+func isRange(v *consteval.Tuple) bool {
+	named, ok := v.Typ.(*types.Named)
+	return ok && strings.HasPrefix(named.Name(), "Range[") && len(v.Fields) == 2
+}
+
+// typeof returns the descriptor of the type described by e: the type known
+// at compile time, or else the type of the operand's value.
+func (in *Interpreter) typeof(env *env, e *ast.TypeofExpression) (consteval.Value, error) {
+	if typ := in.info.Typeofs[e]; typ != nil {
+		return &consteval.TypeValue{Typ: typ}, nil
+	}
+	if e.Expression == nil {
+		return nil, errorf(e, "%s is not supported by the interpreter", e)
+	}
+	v, err := in.eval(env, e.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return &consteval.TypeValue{Typ: types.Default(v.Type())}, nil
+}
@@ -0,0 +1,180 @@
+// Package interp runs checked Tuppence modules by walking their syntax
+// trees.
+//
+// The interpreter shares the values and operators of the compile-time
+// evaluator in package consteval, so that a program computes at run time
+// what the checker folds at compile time. It evaluates the forms recorded
+// by the checker rather than re-deriving them: the bindings of call
+// arguments, the decision trees of switch expressions and the lowered
+// forms of pipelines, partial applications, processors and inline for
+// loops.
+package interp
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// maxDepth bounds the depth of nested function calls.
+const maxDepth = 10_000
+
+// Error reports a failure while running a program, such as an unchecked
+// overflow or an index out of range.
+type Error struct {
+	Pos ast.Position
+	Msg string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("runtime error: %s\n--> %s", err.Msg, err.Pos)
+}
+
+func errorf(node ast.Node, format string, args ...any) *Error {
+	return &Error{Pos: ast.PosOf(node), Msg: fmt.Sprintf(format, args...)}
+}
+
+// failure reports err, from an operation on values, at node.
+func failure(node ast.Node, err error) *Error {
+	return &Error{Pos: ast.PosOf(node), Msg: consteval.RuntimeMessage(err)}
+}
+
+// Interpreter runs a checked module.
+type Interpreter struct {
+	// Stdout receives the output of print.
+	Stdout io.Writer
+
+	info    *check.Info
+	globals *env
+	// top-level assignments being evaluated, to report cycles
+	evaluating map[*ast.Assignment]bool
+	depth      int
+}
+
+// New returns an Interpreter for the module checked into info, which must
+// have been checked without errors.
+func New(info *check.Info) *Interpreter {
+	return &Interpreter{
+		Stdout:     os.Stdout,
+		info:       info,
+		globals:    newEnv(nil),
+		evaluating: map[*ast.Assignment]bool{},
+	}
+}
+
+// Run calls the function named name, which takes no arguments, and
+// returns its result.
+func (in *Interpreter) Run(name string) (consteval.Value, error) {
+	obj := in.info.Scope.LookupLocal(name)
+	if obj == nil || obj.Kind != check.FuncObject {
+		return nil, fmt.Errorf("no function %s is declared", name)
+	}
+	if sig, ok := obj.Type.(*types.Function); !ok || len(sig.Params) > 0 {
+		return nil, fmt.Errorf("%s must be a function without parameters, not %s", name, obj.Type)
+	}
+	v, err := in.global(obj.Decl, name)
+	if err != nil {
+		return nil, err
+	}
+	return in.apply(obj.Decl, v.(*Func), &callArgs{})
+}
+
+// Value returns the value of the top-level binding or function named
+// name, evaluating its declaration if it has not been.
+func (in *Interpreter) Value(name string) (consteval.Value, error) {
+	obj := in.info.Scope.LookupLocal(name)
+	if obj == nil || obj.Kind == check.TypeObject {
+		return nil, fmt.Errorf("%s is not declared", name)
+	}
+	return in.global(obj.Decl, name)
+}
+
+// env holds the names bound by the blocks and calls being evaluated.
+type env struct {
+	vars   map[string]consteval.Value
+	parent *env
+}
+
+func newEnv(parent *env) *env {
+	return &env{vars: map[string]consteval.Value{}, parent: parent}
+}
+
+func (e *env) lookup(name string) (consteval.Value, bool) {
+	for ; e != nil; e = e.parent {
+		if v, ok := e.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// assign updates the innermost binding of name.
+func (e *env) assign(name string, v consteval.Value) bool {
+	for ; e != nil; e = e.parent {
+		if _, ok := e.vars[name]; ok {
+			e.vars[name] = v
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the value bound to name in env, or of the top-level
+// declaration of name.
+func (in *Interpreter) lookup(env *env, node ast.Node, name string) (consteval.Value, error) {
+	if v, ok := env.lookup(name); ok {
+		return v, nil
+	}
+	obj := in.info.Scope.LookupLocal(name)
+	if obj == nil {
+		// the predeclared constants, such as nil
+		if obj := in.info.Scope.Lookup(name); obj != nil && obj.Const != nil {
+			return obj.Const, nil
+		}
+	}
+	if obj == nil || obj.Kind == check.TypeObject {
+		return nil, errorf(node, "undefined: %s", name)
+	}
+	return in.global(obj.Decl, name)
+}
+
+// overload returns the function declared by obj, a top-level function
+// that may be one of the overloads of its name.
+func (in *Interpreter) overload(obj *check.Object) *Func {
+	sig, _ := obj.Type.(*types.Function)
+	return &Func{Decl: obj.Decl.(*ast.FunctionDeclaration), Name: obj.Name, Sig: sig, env: in.globals}
+}
+
+// global returns the value of the top-level name declared by decl. Top-level
+// bindings are evaluated when first used, so that declarations may refer to
+// each other in any order.
+func (in *Interpreter) global(decl ast.Node, name string) (consteval.Value, error) {
+	if v, ok := in.globals.vars[name]; ok {
+		return v, nil
+	}
+	switch decl := decl.(type) {
+	case *ast.FunctionDeclaration:
+		fn := &Func{Decl: decl, Name: name, env: in.globals}
+		fn.Sig, _ = in.info.Scope.LookupLocal(name).Type.(*types.Function)
+		in.globals.vars[name] = fn
+		return fn, nil
+	case *ast.Assignment:
+		if in.evaluating[decl] {
+			return nil, errorf(decl, "initialization cycle: %s refers to itself", name)
+		}
+		in.evaluating[decl] = true
+		defer delete(in.evaluating, decl)
+		if err := in.assignment(in.globals, decl); err != nil {
+			return nil, err
+		}
+		if v, ok := in.globals.vars[name]; ok {
+			return v, nil
+		}
+	}
+	return nil, errorf(decl, "%s has no value", name)
+}
@@ -0,0 +1,258 @@
+package interp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+)
+
+const updateRunGoldensEnv = "UPDATE_RUN_GOLDENS"
+
+// newInterpreter checks input and returns an interpreter for it whose
+// output is written to stdout.
+func newInterpreter(t *testing.T, filename, input string, stdout *bytes.Buffer) *Interpreter {
+	t.Helper()
+	module, err := parse.Module(source.NewSource([]byte(input), filename), ast.NewModule(filename))
+	if err != nil {
+		t.Fatalf("parse.Module(%q) = %v", input, err)
+	}
+	info, err := check.Module(module)
+	if err != nil {
+		t.Fatalf("check.Module(%q) = %v", input, err)
+	}
+	in := New(info)
+	in.Stdout = stdout
+	return in
+}
+
+func TestValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{"arithmetic", "x = 1 + 2 * 3", "x", "7", ""},
+		{"order", "x = y * 2\ny = 21", "x", "42", ""},
+		{"call", "double = fn(n: Int) Int { n * 2 }\nx = double(21)", "x", "42", ""},
+		{"method call syntax", "double = fn(n: Int) Int { n * 2 }\nx = 21.double()", "x", "42", ""},
+		{"default", "add = fn(a: Int, b: 1) Int { a + b }\nx = add(41)", "x", "42", ""},
+		{"labeled", "sub = fn(a: Int, b: Int) Int { a - b }\nx = sub(b: 1, a: 43)", "x", "42", ""},
+		{"rest", "sum = fn(ns: ...Int) Int { for s = 0; n in ns { s + n } }\nx = sum(1, 2, 3)", "x", "6", ""},
+		{"recursion", "fact = fn(n: Int) Int { if n <= 1 { 1 } else { n * fact(n - 1) } }\nx = fact(20)", "x", "2432902008176640000", ""},
+		{"trailing block", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\nf = fn(k: Int) Int { apply(40) { |m| m + k } }\nx = f(2)", "x", "42", ""},
+		{"partial application", "add = fn(a: Int, b: Int) Int { a + b }\nadd2 = add(2, *)\nx = add2(40)", "x", "42", ""},
+		{"pipeline", "double = fn(n: Int) Int { n * 2 }\ninc = fn(n: Int) Int { n + 1 }\nx = 20 |> inc() |> double()", "x", "42", ""},
+		{"local function", "f = fn() Int {\n\tsq = fn(n: Int) Int { n * n }\n\tsq(6) + 6\n}\nx = f()", "x", "42", ""},
+		{"tuple", "p = (x: 1, y: 2)\nx = p.y", "x", "2", ""},
+		{"tuple update", "p = (x: 1, y: 2)\nq = p.(y: 3)", "q", "(x: 1, y: 3)", ""},
+		{"array", "xs = [1, 2, 3]\nx = xs[1]", "x", "2", ""},
+		{"array append", "xs = [1, 2] << 3", "xs", "[1, 2, 3]", ""},
+		{"index out of range", "three = fx() Int { 3 }\nxs = [1, 2, 3]\nx = xs[three()]", "x", "", "runtime error: index 3 out of range"},
+		{"safe index", "xs = [1, 2, 3]\nx = xs[2]!", "x", "3", ""},
+		{"slice", "xs = [1, 2, 3]\nx = xs[1..2]", "x", "[2, 3]", ""},
+		{"empty slice", "xs = [1, 2, 3]\nx = xs[3..2]", "x", "[]", ""},
+		{"slice of string", "s = \"hello\"\nx = s[1..3]", "x", `"ell"`, ""},
+		{"slice out of range", "xs = [1, 2, 3]\nx = xs[1..3]", "x", "", "runtime error: slice [1..3] out of range [0:3]"},
+		{"len", "x = len([1, 2, 3]) + len(\"ab\")", "x", "5", ""},
+		{"if", "f = fn(n: Int) String { if n < 0 { \"neg\" } else { \"pos\" } }\nx = f(-1)", "x", `"neg"`, ""},
+		{"interpolation", "n = 42\nx = \"n = \\(n)\"", "x", `"n = 42"`, ""},
+		{"overloaded call", "o = fn(n: Int) String { \"int\" }\no = fn(s: String) String { s + \"!\" }\nx = [o(1), o(\"a\"), \"b\".o()]", "x", `["int", "a!", "b!"]`, ""},
+		{"interpolation of overloaded string", "P = type(x: Int)\nS = type(w: Int)\nstring = fn(p: P) String { \"p\" }\nstring = fn(s: S) String { \"s\" }\nx = \"\\(P(1)) \\(S(2))\"", "x", `"p s"`, ""},
+
+		{"for with condition", "x = for i = 0; i < 10 { i + 1 }", "x", "10", ""},
+		{"for with step", "x = for i = 0; i < 10; i + 2 {}", "x", "10", ""},
+		{"for with break", "x = for i = 0 { if i == 5 { break i * 2 }\ni + 1 }", "x", "10", ""},
+		{"for in array", "x = for sum = 0; n in [1, 2, 3] { sum + n }", "x", "6", ""},
+		{"for in range", "x = for sum = 0; n in 1..4 { sum + n }", "x", "10", ""},
+		{"for in string", "x = for n = 0; r in \"héllo\" { n + 1 }", "x", "5", ""},
+		{"for with continue", "x = for sum = 0; n in 1..10 { if n % 2 == 0 { continue }\nsum + n }", "x", "25", ""},
+		{"for with tuple state", "x = for (a, b) = (0, 1); a < 50 {\n\t(b, a + b)\n}.0", "x", "55", ""},
+
+		{"switch value", "f = fn(n: Int) String { switch n { 1 { \"one\" } 2 { \"two\" } else { \"many\" } } }\nx = f(2)", "x", `"two"`, ""},
+		{"switch range", "f = fn(n: Int) String { switch n { 0..9 { \"single\" } 10..99 { \"double\" } else { \"lots\" } } }\nx = f(42)", "x", `"double"`, ""},
+		{"switch tuple", "Pair = type(Int, Int)\nf = fn(p: Pair) Int { switch p { (0, 0) { 0 } (_, _) { |x, y| x + y } } }\nx = f(Pair(40, 2))", "x", "42", ""},
+		{"switch array", "f = fn(xs: []Int) Int { switch xs { [] { 0 } [_, ...] { |head, ...tail| head + len(tail) } } }\nx = f([40, 1, 1])", "x", "42", ""},
+		{"switch union", "f = fn(v: Int | String) String { switch v { Int { \"int\" } String { it } } }\nx = f(\"str\")", "x", `"str"`, ""},
+		{"typeof named union", "Circle = type(r: Int)\nSquare = type(s: Int)\nShape = Circle | Square\nis_circle = fn(s: Shape) Bool { typeof(s) == typeof(Circle) }\nx = is_circle(Circle(1))", "x", "true", ""},
+		{"switch without match", "n = 2\nx = switch n { 1 { \"one\" } }", "x", "nil", ""},
+
+		{"enum", "Color = enum(\n\tred\n\tgreen\n\tblue\n)\nx = Color.blue.int()", "x", "2", ""},
+		{"enum string", "Color = enum(\n\tred\n\tgreen\n\tblue\n)\nx = Color.green.string()", "x", `"green"`, ""},
+		{"for in enum", "Color = enum(\n\tred\n\tgreen\n\tblue\n)\nx = for n = 0; c in Color { n + c.int() }", "x", "3", ""},
+
+		{"checked overflow", "hundred = fx() Int8 { 100 }\nx = hundred() ?+ hundred()", "x", `error("integer overflow")`, ""},
+		{"unchecked overflow", "hundred = fx() Int8 { 100 }\nx = hundred() + hundred()", "x", "", "runtime error: integer overflow"},
+		{"division by zero", "zero = fx() Int { 0 }\nx = 1 / zero()", "x", "", "runtime error: division by zero"},
+		{"conversion", "n = fx() Int { -5 }\nx = Int8(n())", "x", "-5", ""},
+		{"conversion overflow", "n = fx() Int { 200 }\nx = Int8(n())", "x", "", "runtime error: integer overflow"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			in := newInterpreter(t, "test.tup", test.input, &stdout)
+			v, err := in.Value(test.value)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Value(%q) = %v, %v, want error containing %q", test.value, v, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Value(%q) = %v", test.value, err)
+			}
+			if test.want == "" {
+				return
+			}
+			if got := v.String(); got != test.want {
+				t.Errorf("Value(%q) = %s, want %s", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+// openRanges removes from the ranges of module the bounds written as the
+// identifier open, which the grammar cannot leave out.
+func openRanges(module *ast.Module) {
+	isOpen := func(b *ast.RangeBound) bool { return b.Value.String() == "open" }
+	for _, item := range module.TopLevelItems {
+		ast.Inspect(item, func(n ast.Node) bool {
+			if r, ok := n.(*ast.Range); ok {
+				if isOpen(r.StartBound) {
+					r.StartBound = nil
+				} else if isOpen(r.EndBound) {
+					r.EndBound = nil
+				}
+			}
+			return true
+		})
+	}
+}
+
+func TestOpenRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		value string
+		want  string
+	}{
+		{"slice without end", "xs = [1, 2, 3]\nx = xs[1..open]", "x", "[2, 3]"},
+		{"slice without start", "s = \"hello\"\nx = s[open..1]", "x", `"he"`},
+		{"range without end", "lo = fx() UInt8 { 250 }\nx = for n = 0; i in lo()..open { n + 1 }", "x", "6"},
+		{"range without start", "x = open..3", "x", "(lo: -9223372036854775808, hi: 3)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			module, err := parse.Module(source.NewSource([]byte(test.input), "test.tup"), ast.NewModule("test.tup"))
+			if err != nil {
+				t.Fatalf("parse.Module(%q) = %v", test.input, err)
+			}
+			openRanges(module)
+			info, err := check.Module(module)
+			if err != nil {
+				t.Fatalf("check.Module(%q) = %v", test.input, err)
+			}
+			v, err := New(info).Value(test.value)
+			if err != nil {
+				t.Fatalf("Value(%q) = %v", test.value, err)
+			}
+			if got := v.String(); got != test.want {
+				t.Errorf("Value(%q) = %s, want %s", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+const errorDecls = "E1 = error(message: String)\n" +
+	"E2 = error(code: Int)\n" +
+	"classify = fn(n: Int) !Int {\n\tif n < 0 { E1(\"negative\") } else if n == 0 { E2(0) } else { n }\n}\n"
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{"print", "main = fx() { print(1, \"a\", [1, 2], (x: 1)) }", "1 a [1, 2] (x: 1)\n", ""},
+		{"print in order", "main = fx() {\n\tprint(1)\n\tprint(2)\n}", "1\n2\n", ""},
+		{"print in loop", "main = fx() {\n\tfor i in 1..3 { print(i) }\n}", "1\n2\n3\n", ""},
+		{"print interpolated", "main = fx() {\n\tname = \"World\"\n\tprint(\"Hello, \\(name)!\")\n}", "Hello, World!\n", ""},
+		{"mutable binding", "main = fx() {\n\tn = mut 1\n\tn += 41\n\tprint(n)\n}", "42\n", ""},
+		{"nil", "Maybe = Int | Nil\nfind = fn(n: Int) Maybe { if n > 0 { n } else { nil } }\nmain = fx() { print(find(0)) }", "nil\n", ""},
+		{"function value", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\ninc = fn(n: Int) Int { n + 1 }\nmain = fx() { print(apply(41, inc)) }", "42\n", ""},
+		{"return", "f = fn(n: Int) Int {\n\tif n < 0 { return 0 }\n\tn\n}\nmain = fx() { print(f(-1), f(1)) }", "0 1\n", ""},
+
+		{"try value", errorDecls + "f = fn(n: Int) !Int {\n\tv = try classify(n)\n\tv + 1\n}\nmain = fx() { print(f(41)) }", "42\n", ""},
+		{"try error", errorDecls + "f = fn(n: Int) !Int {\n\tv = try classify(n)\n\tv + 1\n}\nmain = fx() { print(f(-1)) }", "(message: \"negative\")\n", ""},
+		{"try_continue", errorDecls + "f = fn() Int {\n\tfor acc = 0; n in [1, 0, 2] {\n\t\tv = try_continue classify(n)\n\t\tacc + v\n\t}\n}\nmain = fx() { print(f()) }", "3\n", ""},
+		{"try_break", errorDecls + "f = fn() !Int {\n\tfor acc = 0; n in [1, 0, 2] {\n\t\tv = try_break classify(n)\n\t\tacc + v\n\t}\n}\nmain = fx() { print(f()) }", "(code: 0)\n", ""},
+		{"switch on error", errorDecls + "f = fn(n: Int) String {\n\tv = classify(n)\n\tswitch v {\n\t\tE1 { \"E1\" }\n\t\tE2 { \"E2\" }\n\t\tInt { \"Int\" }\n\t}\n}\nmain = fx() { print(f(-1), f(0), f(1)) }", "E1 E2 Int\n", ""},
+
+		{"no main", "x = 1", "", "no function main is declared"},
+		{"main with parameters", "main = fx(n: Int) { print(n) }", "", "main must be a function without parameters"},
+		{"unbounded recursion", "f = fx(n: Int) Int { f(n + 1) }\nmain = fx() { print(f(0)) }", "", "call stack exceeded"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			in := newInterpreter(t, "test.tup", test.input, &stdout)
+			_, err := in.Run("main")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Run(main) = %v, want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run(main) = %v", err)
+			}
+			if got := stdout.String(); got != test.want {
+				t.Errorf("Run(main) wrote %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// TestExampleGoldens runs the main function of each example listed and
+// compares its output with testdata/<name>.out. Set UPDATE_RUN_GOLDENS to
+// rewrite the golden files.
+func TestExampleGoldens(t *testing.T) {
+	update := os.Getenv(updateRunGoldensEnv) != ""
+	for _, name := range []string{"fib"} {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join("..", "..", "examples", name+".tup")
+			contents, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stdout bytes.Buffer
+			in := newInterpreter(t, filename, string(contents), &stdout)
+			if _, err := in.Run("main"); err != nil {
+				t.Fatalf("Run(main) = %v", err)
+			}
+			golden := filepath.Join("testdata", name+".out")
+			if update {
+				if err := os.WriteFile(golden, stdout.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := stdout.String(); got != string(want) {
+				t.Errorf("%s wrote:\n%s\nwant:\n%s", filename, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package interp
+
+import (
+	"math/big"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// forExpr evaluates a for loop.
+//
+// The variables bound by the initializer carry the state of the loop: at
+// each iteration they take the value of the step expression, or of the
+// block's final expression if there is none. A loop that ends when its
+// condition fails or its iterable is exhausted has the value of that
+// state, or nil if it has no initializer; break exits the loop with its
+// own value.
+func (in *Interpreter) forExpr(parent *env, e *ast.ForExpression) (consteval.Value, error) {
+	env := newEnv(parent)
+	var init *ast.Initializer
+	var cond ast.Expression
+	var step *ast.StepExpression
+	var next iterator
+	switch header := e.Header.(type) {
+	case *ast.ForHeader:
+		init, cond, step = header.Initializer, header.Condition, header.StepExpr
+	case *ast.ForInHeader:
+		init, step = header.Initializer, header.StepExpr
+	}
+
+	var state consteval.Value = consteval.Nil{}
+	if init != nil {
+		v, err := in.eval(env, init.Assignment.Right)
+		if err != nil {
+			return nil, err
+		}
+		if state, err = settle(v); err != nil {
+			return nil, failure(init, err)
+		}
+		if err := in.bindLHS(env, init, init.Assignment.Left, state); err != nil {
+			return nil, err
+		}
+	}
+	if header, ok := e.Header.(*ast.ForInHeader); ok {
+		var err error
+		if next, err = in.iterate(env, header.Iterable); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		iteration := newEnv(env)
+		if cond != nil {
+			v, err := in.eval(env, cond)
+			if err != nil {
+				return nil, err
+			}
+			b, ok := v.(consteval.Bool)
+			if !ok {
+				return nil, errorf(cond, "non-Bool %s used as loop condition", v)
+			}
+			if !b {
+				return state, nil
+			}
+		}
+		if next != nil {
+			elem, ok, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return state, nil
+			}
+			header := e.Header.(*ast.ForInHeader)
+			if err := in.bindLHS(iteration, header, header.LoopVar, elem); err != nil {
+				return nil, err
+			}
+		}
+
+		var v consteval.Value
+		var err error
+		if e.Block != nil {
+			v, err = in.statements(newEnv(iteration), e.Block.Statements, e.Block.Expression)
+		}
+		skip := false
+		if s, ok := err.(*signal); ok {
+			switch s.kind {
+			case breakSignal:
+				return s.value, nil
+			case continueSignal:
+				v, err = s.value, nil
+				skip = v == nil
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if init == nil {
+			continue
+		}
+		switch {
+		case step != nil && !skip:
+			if v, err = in.eval(iteration, step.Expression); err != nil {
+				return nil, err
+			}
+		case skip:
+			// continue without a value leaves the variables as they are
+			continue
+		}
+		if state, err = settle(v); err != nil {
+			return nil, failure(e, err)
+		}
+		if err := in.bindLHS(env, e, init.Assignment.Left, state); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// iterator returns the next value of an iterable and whether there is one.
+type iterator func() (consteval.Value, bool, error)
+
+// iterate returns an iterator over the values of the iterable of a for-in
+// loop: the elements of an array, the runes of a string, the integers of a
+// range, the members of an enum type, or the values of several iterables in
+// lockstep. Any other value is iterated by calling a function next(it),
+// which returns nil when there are no more values and otherwise the next
+// value and the rest.
+func (in *Interpreter) iterate(env *env, it *ast.Iterable) (iterator, error) {
+	if ref := it.TypeReference; ref != nil {
+		obj := in.info.Scope.Lookup(ref.TypeIdentifier.Name)
+		if obj == nil || obj.Kind != check.TypeObject || !types.IsEnum(obj.Type) {
+			return nil, errorf(ref, "cannot iterate over type %s", ref)
+		}
+		var members []consteval.Value
+		for _, member := range obj.Type.Underlying().(*types.Enum).Members {
+			members = append(members, &consteval.Enum{Typ: obj.Type, Member: member})
+		}
+		return sliceIterator(members), nil
+	}
+	v, err := in.eval(env, it.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return in.iterator(it.Expression, v)
+}
+
+func (in *Interpreter) iterator(node ast.Node, v consteval.Value) (iterator, error) {
+	switch v := v.(type) {
+	case *consteval.Array:
+		return sliceIterator(v.Elems), nil
+	case consteval.String:
+		var runes []consteval.Value
+		for _, r := range string(v) {
+			runes = append(runes, &consteval.Int{Val: big.NewInt(int64(r)), Typ: types.Rune})
+		}
+		return sliceIterator(runes), nil
+	case *consteval.Tuple:
+		if isRange(v) {
+			lo, hi := v.Fields[0].Value.(*consteval.Int), v.Fields[1].Value.(*consteval.Int)
+			i := new(big.Int).Set(lo.Val)
+			return func() (consteval.Value, bool, error) {
+				if i.Cmp(hi.Val) > 0 {
+					return nil, false, nil
+				}
+				n := &consteval.Int{Val: new(big.Int).Set(i), Typ: lo.Typ}
+				i.Add(i, big.NewInt(1))
+				return n, true, nil
+			}, nil
+		}
+		if v.Typ == nil {
+			// a tuple of iterables produces tuples of their values,
+			// ending with the shortest
+			var its []iterator
+			for _, field := range v.Fields {
+				it, err := in.iterator(node, field.Value)
+				if err != nil {
+					return nil, err
+				}
+				its = append(its, it)
+			}
+			return func() (consteval.Value, bool, error) {
+				tuple := &consteval.Tuple{}
+				for i, it := range its {
+					elem, ok, err := it()
+					if !ok || err != nil {
+						return nil, false, err
+					}
+					tuple.Fields = append(tuple.Fields, consteval.Field{Name: v.Fields[i].Name, Value: elem})
+				}
+				return tuple, true, nil
+			}, nil
+		}
+	}
+
+	next, err := in.lookup(newEnv(nil), node, "next")
+	if err != nil {
+		return nil, errorf(node, "cannot iterate over %s", v)
+	}
+	rest := v
+	return func() (consteval.Value, bool, error) {
+		step, err := in.callValue(node, next, rest)
+		if err != nil {
+			return nil, false, err
+		}
+		tuple, ok := step.(*consteval.Tuple)
+		if !ok || len(tuple.Fields) != 2 {
+			return nil, false, nil
+		}
+		rest = tuple.Fields[1].Value
+		return tuple.Fields[0].Value, true, nil
+	}, nil
+}
+
+func sliceIterator(values []consteval.Value) iterator {
+	i := 0
+	return func() (consteval.Value, bool, error) {
+		if i >= len(values) {
+			return nil, false, nil
+		}
+		i++
+		return values[i-1], true, nil
+	}
+}
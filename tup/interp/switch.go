@@ -0,0 +1,133 @@
+package interp
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/match"
+)
+
+// switchExpr evaluates a switch expression by walking the decision tree
+// the checker compiled for it. A switch no case of which matches has the
+// value nil.
+func (in *Interpreter) switchExpr(parent *env, e *ast.SwitchExpression) (consteval.Value, error) {
+	tree := in.info.Matches[e]
+	if tree == nil {
+		return nil, errorf(e, "%s is not supported by the interpreter", e)
+	}
+	subject, err := in.eval(parent, e.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	node := tree.Root
+	for {
+		switch n := node.(type) {
+		case *match.Fail:
+			return consteval.Nil{}, nil
+		case *match.Switch:
+			v, err := valueAt(e, subject, n.Path)
+			if err != nil {
+				return nil, err
+			}
+			node = n.Default
+			for _, c := range n.Cases {
+				ok, err := test(e, v, c.Test)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					node = c.Node
+					break
+				}
+			}
+			if node == nil {
+				return consteval.Nil{}, nil
+			}
+		case *match.Leaf:
+			block := e.ElseBlock
+			if n.Case != match.Else {
+				block = e.Cases[n.Case].Body
+			}
+			env := newEnv(parent)
+			for _, b := range n.Bindings {
+				v, err := valueAt(e, subject, b.Path)
+				if err != nil {
+					return nil, err
+				}
+				env.vars[b.Ident.Name] = v
+			}
+			if block.Parameters == nil || block.Parameters.Parameters == nil {
+				env.vars["it"] = subject
+			}
+			return in.body(env, block.Body)
+		default:
+			return nil, errorf(e, "unexpected decision tree node %T", node)
+		}
+	}
+}
+
+// valueAt returns the part of subject at path.
+func valueAt(node ast.Node, subject consteval.Value, path *match.Path) (consteval.Value, error) {
+	if path.Kind == match.Root {
+		return subject, nil
+	}
+	parent, err := valueAt(node, subject, path.Parent)
+	if err != nil {
+		return nil, err
+	}
+	switch path.Kind {
+	case match.As:
+		// union values are held as the value of the member type
+		return parent, nil
+	case match.Field:
+		if tuple, ok := parent.(*consteval.Tuple); ok && path.Index < len(tuple.Fields) {
+			return tuple.Fields[path.Index].Value, nil
+		}
+	case match.Elem:
+		if array, ok := parent.(*consteval.Array); ok && path.Index < len(array.Elems) {
+			return array.Elems[path.Index], nil
+		}
+	case match.Rest:
+		switch parent := parent.(type) {
+		case *consteval.Tuple:
+			if path.Index <= len(parent.Fields) {
+				return &consteval.Tuple{Fields: parent.Fields[path.Index:]}, nil
+			}
+		case *consteval.Array:
+			if path.Index <= len(parent.Elems) {
+				return &consteval.Array{Elems: parent.Elems[path.Index:], Typ: path.Type}, nil
+			}
+		}
+	}
+	return nil, errorf(node, "cannot select %s of %s", path, parent)
+}
+
+// test reports whether v passes the test t of a decision tree.
+func test(node ast.Node, v consteval.Value, t *match.Test) (bool, error) {
+	switch t.Kind {
+	case match.Tag:
+		return hasType(v, t.Type), nil
+	case match.Equal:
+		return consteval.Equal(v, t.Value), nil
+	case match.InRange:
+		lo, err := consteval.Compare(t.Lo, v)
+		if err != nil {
+			return false, failure(node, err)
+		}
+		hi, err := consteval.Compare(v, t.Hi)
+		if err != nil {
+			return false, failure(node, err)
+		}
+		return lo <= 0 && hi <= 0, nil
+	case match.Len, match.MinLen:
+		array, ok := v.(*consteval.Array)
+		if !ok {
+			return false, errorf(node, "cannot take the length of %s", v)
+		}
+		if t.Kind == match.Len {
+			return len(array.Elems) == t.N, nil
+		}
+		return len(array.Elems) >= t.N, nil
+	}
+	return false, errorf(node, "unexpected test %s", t)
+}
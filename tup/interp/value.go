@@ -0,0 +1,85 @@
+package interp
+
+import (
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Func is a function value: a declared function or a closure over the
+// environment it was declared in.
+type Func struct {
+	// Decl is the declaration of a declared function, or nil.
+	Decl *ast.FunctionDeclaration
+	// Block is the function block of a closure, or nil.
+	Block *ast.FunctionBlock
+	// Sig is the checked signature of the function. It may be nil for
+	// closures whose type is not known.
+	Sig  *types.Function
+	Name string
+
+	env *env // the environment the function was declared in
+}
+
+func (v *Func) Type() types.Type {
+	if v.Sig == nil {
+		return types.Typ[types.Invalid]
+	}
+	return v.Sig
+}
+
+func (v *Func) String() string {
+	if v.Name == "" {
+		return "fn { ... }"
+	}
+	return v.Name
+}
+
+// params returns the parameters of a declared function, or nil.
+func (v *Func) params() []ast.FunctionTypeParameter {
+	if v.Decl == nil || v.Decl.Type == nil {
+		return nil
+	}
+	return v.Decl.Type.Parameters
+}
+
+// Format returns the text of v as print writes it and as it is
+// interpolated into a string: strings are written without quotes, and other
+// values as they would be written in source.
+func Format(v consteval.Value) string {
+	if s, ok := v.(consteval.String); ok {
+		return string(s)
+	}
+	return v.String()
+}
+
+// formatArgs returns the text of values separated by spaces.
+func formatArgs(values []consteval.Value) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = Format(v)
+	}
+	return strings.Join(parts, " ")
+}
+
+// isError reports whether v is an error: a value of a type declared with
+// error(...), or an error returned by a checked operator.
+func isError(v consteval.Value) bool {
+	if _, ok := v.(*consteval.ErrorValue); ok {
+		return true
+	}
+	return isErrorType(v.Type())
+}
+
+func isErrorType(typ types.Type) bool {
+	named, ok := typ.(*types.Named)
+	return ok && named.HasAnnotation("error")
+}
+
+// settle gives an untyped value its default type, as binding it to a name
+// does.
+func settle(v consteval.Value) (consteval.Value, error) {
+	return consteval.Convert(v, types.Default(v.Type()))
+}
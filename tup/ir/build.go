@@ -0,0 +1,266 @@
+package ir
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// builder emits the instructions of a function, constructing SSA form as
+// it goes with the algorithm of Braun et al., "Simple and Efficient
+// Construction of Static Single Assignment Form": each variable has a
+// definition per block, reads that find none look through the block's
+// predecessors, and blocks whose predecessors are not all known yet get
+// incomplete phis, completed when the block is sealed.
+type builder struct {
+	fn  *Func
+	cur *Block       // block being emitted into, or nil after a terminator
+	pos ast.Position // position of the source being lowered
+
+	preds      map[*Block][]*Block
+	sealed     map[*Block]bool
+	defs       map[*variable]map[*Block]Value
+	incomplete map[*Block]map[*variable]*Instr
+}
+
+// variable is a name, or a hidden piece of state, whose value changes
+// along the control flow of a function.
+type variable struct {
+	name string
+	typ  types.Type
+}
+
+func newBuilder(fn *Func) *builder {
+	b := &builder{
+		fn:         fn,
+		preds:      map[*Block][]*Block{},
+		sealed:     map[*Block]bool{},
+		defs:       map[*variable]map[*Block]Value{},
+		incomplete: map[*Block]map[*variable]*Instr{},
+	}
+	b.cur = fn.NewBlock()
+	b.sealed[b.cur] = true
+	return b
+}
+
+// current returns the block being emitted into, starting an unreachable one
+// if control has left the previous block.
+func (b *builder) current() *Block {
+	if b.cur == nil {
+		b.cur = b.fn.NewBlock()
+		b.sealed[b.cur] = true
+	}
+	return b.cur
+}
+
+// emit appends instr to the current block and returns it.
+func (b *builder) emit(instr *Instr) *Instr {
+	block := b.current()
+	instr.Block = block
+	if instr.Pos.Line == 0 {
+		instr.Pos = b.pos
+	}
+	block.Instrs = append(block.Instrs, instr)
+	return instr
+}
+
+// terminate ends the current block with instr, whose successors gain the
+// block as a predecessor.
+func (b *builder) terminate(instr *Instr) *Instr {
+	b.emit(instr)
+	for _, succ := range instr.Blocks {
+		b.preds[succ] = append(b.preds[succ], instr.Block)
+	}
+	b.cur = nil
+	return instr
+}
+
+func (b *builder) jump(to *Block) {
+	b.terminate(&Instr{Op: OpJump, Blocks: []*Block{to}})
+}
+
+func (b *builder) br(cond Value, then, els *Block) {
+	b.terminate(&Instr{Op: OpBr, Args: []Value{cond}, Blocks: []*Block{then, els}})
+}
+
+// at gives the instructions emitted the position of node, until the
+// function it returns restores the previous position.
+func (b *builder) at(node ast.Node) func() {
+	pos := b.pos
+	if p := ast.PosOf(node); p.Line > 0 {
+		b.pos = p
+	}
+	return func() { b.pos = pos }
+}
+
+// start continues emitting into block, which must be sealed once all of
+// its predecessors are known.
+func (b *builder) start(block *Block) {
+	b.cur = block
+}
+
+func (b *builder) op(op Op, typ types.Type, args ...Value) *Instr {
+	return b.emit(&Instr{Op: op, Typ: typ, Args: args})
+}
+
+func (b *builder) constant(v consteval.Value) *Instr {
+	return b.emit(&Instr{Op: OpConst, Typ: v.Type(), Const: v})
+}
+
+func (b *builder) nilValue() *Instr {
+	return b.constant(consteval.Nil{})
+}
+
+func (b *builder) field(v Value, i int, typ types.Type) *Instr {
+	return b.emit(&Instr{Op: OpField, Typ: typ, Args: []Value{v}, Index: i})
+}
+
+func (b *builder) wrap(v Value, i int, typ types.Type) *Instr {
+	return b.emit(&Instr{Op: OpWrap, Typ: typ, Args: []Value{v}, Index: i})
+}
+
+func (b *builder) payload(v Value, i int, typ types.Type) *Instr {
+	return b.emit(&Instr{Op: OpPayload, Typ: typ, Args: []Value{v}, Index: i})
+}
+
+func (b *builder) trap(msg string) {
+	b.terminate(&Instr{Op: OpTrap, Msg: msg})
+}
+
+// write defines the value of v at the end of the current block.
+func (b *builder) write(v *variable, val Value) {
+	b.writeIn(v, b.current(), val)
+}
+
+func (b *builder) writeIn(v *variable, block *Block, val Value) {
+	if b.defs[v] == nil {
+		b.defs[v] = map[*Block]Value{}
+	}
+	b.defs[v][block] = val
+}
+
+// read returns the value of v in the current block.
+func (b *builder) read(v *variable) Value {
+	return b.readIn(v, b.current())
+}
+
+func (b *builder) readIn(v *variable, block *Block) Value {
+	if val, ok := b.defs[v][block]; ok {
+		return val
+	}
+	var val Value
+	preds := b.preds[block]
+	switch {
+	case !b.sealed[block]:
+		phi := b.phi(block, v.typ)
+		if b.incomplete[block] == nil {
+			b.incomplete[block] = map[*variable]*Instr{}
+		}
+		b.incomplete[block][v] = phi
+		val = phi
+	case len(preds) == 0:
+		// v has no value on any path to block
+		val = b.insert(block, &Instr{Op: OpUndef, Typ: v.typ})
+	case len(preds) == 1:
+		val = b.readIn(v, preds[0])
+	default:
+		phi := b.phi(block, v.typ)
+		b.writeIn(v, block, phi)
+		b.operands(v, phi)
+		val = phi
+	}
+	b.writeIn(v, block, val)
+	return val
+}
+
+// phi inserts an empty phi at the start of block.
+func (b *builder) phi(block *Block, typ types.Type) *Instr {
+	return b.insert(block, &Instr{Op: OpPhi, Typ: typ})
+}
+
+// insert inserts instr after the phis at the start of block.
+func (b *builder) insert(block *Block, instr *Instr) *Instr {
+	instr.Block = block
+	n := len(block.Phis())
+	block.Instrs = append(block.Instrs, nil)
+	copy(block.Instrs[n+1:], block.Instrs[n:])
+	block.Instrs[n] = instr
+	return instr
+}
+
+// operands adds the value of v flowing in from each predecessor of the
+// phi's block to phi.
+func (b *builder) operands(v *variable, phi *Instr) {
+	for _, pred := range b.preds[phi.Block] {
+		phi.Args = append(phi.Args, b.readIn(v, pred))
+		phi.Blocks = append(phi.Blocks, pred)
+	}
+}
+
+// seal records that all predecessors of block are known, completing its
+// phis.
+func (b *builder) seal(block *Block) {
+	for v, phi := range b.incomplete[block] {
+		b.operands(v, phi)
+	}
+	delete(b.incomplete, block)
+	b.sealed[block] = true
+}
+
+// finish removes what the construction leaves behind: unreachable blocks,
+// phis that select a single value or are used only by phis, and constants,
+// functions and undefined values no instruction uses.
+func (b *builder) finish() {
+	fn := b.fn
+	fn.RemoveUnreachable()
+	fn.RemoveTrivialPhis()
+
+	// phis live only if a value other than a phi depends on them
+	live := map[*Instr]bool{}
+	var mark func(v Value)
+	mark = func(v Value) {
+		phi, ok := v.(*Instr)
+		if !ok || phi.Op != OpPhi || live[phi] {
+			return
+		}
+		live[phi] = true
+		for _, arg := range phi.Args {
+			mark(arg)
+		}
+	}
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instrs {
+			if instr.Op != OpPhi {
+				for _, arg := range instr.Args {
+					mark(arg)
+				}
+			}
+		}
+	}
+	fn.RemoveInstrs(func(instr *Instr) bool {
+		return instr.Op == OpPhi && !live[instr]
+	})
+
+	for {
+		used := map[*Instr]bool{}
+		for _, block := range fn.Blocks {
+			for _, instr := range block.Instrs {
+				for _, arg := range instr.Args {
+					if arg, ok := arg.(*Instr); ok {
+						used[arg] = true
+					}
+				}
+			}
+		}
+		if !fn.RemoveInstrs(func(instr *Instr) bool {
+			switch instr.Op {
+			case OpConst, OpFunc, OpUndef:
+				return !used[instr]
+			}
+			return false
+		}) {
+			break
+		}
+	}
+	fn.Update()
+}
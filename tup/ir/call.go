@@ -0,0 +1,258 @@
+package ir
+
+import (
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/bind"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// call lowers a call of a function of the module or of a function value,
+// or a partial application of either.
+func (f *funcLowerer) call(s *scope, e *ast.FunctionCall) Value {
+	if name := f.info.Builtins[e]; name != "" {
+		return f.builtin(s, e, name)
+	}
+	b := f.info.Calls[e]
+	if b == nil {
+		f.errorf(e, "arguments of %s are not bound", e)
+	}
+
+	var callee *Func // the function called directly, or nil
+	var targs []types.Type
+	var fv, recv Value
+	var recvExpr ast.Expression
+	switch fn := e.Function.(type) {
+	case *ast.MemberAccess:
+		object, ok := fn.Object.(ast.Expression)
+		member, isIdent := fn.Member.(*ast.Identifier)
+		if !ok || !isIdent {
+			f.errorf(e, "%s is not supported by the IR", e)
+		}
+		v := f.expr(s, object)
+		if tuple, ok := v.Type().Underlying().(*types.Tuple); ok && tuple.FieldIndex(member.Name) >= 0 {
+			i := tuple.FieldIndex(member.Name)
+			fv = f.field(v, i, tuple.Fields[i].Type)
+		} else {
+			// uniform function call syntax: recv.f(args) calls f(recv, args)
+			recv, recvExpr = v, object
+			callee, targs, fv = f.callee(s, e, member, member.Name)
+		}
+	case *ast.Identifier:
+		callee, targs, fv = f.callee(s, e, fn, fn.Name)
+	case *ast.FunctionIdentifier:
+		callee, targs, fv = f.callee(s, e, fn, fn.Name)
+	default:
+		fv = f.expr(s, e.Function)
+	}
+	var sig *types.Function
+	if callee != nil {
+		sig = instantiate(callee, targs)
+	} else if sig, _ = fv.Type().Underlying().(*types.Function); sig == nil {
+		f.errorf(e.Function, "cannot call %s: it is not a function", fv.Type())
+	}
+	if len(b.Params) != len(sig.Params) {
+		f.errorf(e, "arguments of %s do not match %s", e, sig)
+	}
+
+	// the arguments are evaluated in the order written, after the
+	// receiver
+	values := map[*ast.Argument]Value{}
+	if args := e.Arguments; args != nil {
+		if args.Args != nil {
+			for _, arg := range args.Args.Args {
+				values[arg] = f.expr(s, arg.Expr)
+			}
+		}
+		if args.LabeledArgs != nil {
+			for _, arg := range args.LabeledArgs.Args {
+				values[arg.Argument] = f.expr(s, arg.Argument.Expr)
+			}
+		}
+	}
+	value := func(arg *ast.Argument) Value {
+		if v, ok := values[arg]; ok {
+			return v
+		}
+		if arg.Expr == recvExpr {
+			return recv
+		}
+		// a value piped into the call
+		return f.expr(s, arg.Expr)
+	}
+
+	if p := f.info.Partials[e]; p != nil {
+		return f.partial(e, p, b, sig, callee, targs, fv, value)
+	}
+	var operands []Value
+	if callee == nil {
+		operands = append(operands, fv)
+	}
+	operands = append(operands, f.operands(e, b, sig, value, nil)...)
+
+	instr := &Instr{Op: OpCall, Typ: sig.Result, Args: operands, Fx: sig.HasSideEffects}
+	if callee != nil {
+		instr.Callee, instr.TypeArgs = callee.Name, targs
+	}
+	f.emit(instr)
+	if instr.Typ == nil {
+		return f.nilValue()
+	}
+	return instr
+}
+
+// operands returns the arguments of a call of a function of type sig,
+// whose arguments are bound by b and have the values value returns. The
+// parameters of a partial application that receive no argument take the
+// values open returns instead.
+func (f *funcLowerer) operands(e *ast.FunctionCall, b *bind.Binding, sig *types.Function,
+	value func(*ast.Argument) Value, open func(int) Value) []Value {
+	var operands []Value
+	for i, param := range b.Params {
+		typ := sig.Params[i].Type
+		bound := b.Args[i]
+		var v Value
+		switch {
+		case open != nil && len(bound) == 0:
+			v = open(i)
+		case param.Rest && len(bound) == 1 && bound[0].Spread:
+			v = f.coerce(bound[0], value(bound[0]), typ)
+		case param.Rest:
+			array, ok := typ.Underlying().(*types.Array)
+			if !ok {
+				f.errorf(e, "rest parameter of %s is not an array", sig)
+			}
+			elems := make([]Value, len(bound))
+			for j, arg := range bound {
+				if arg.Spread {
+					f.errorf(arg, "spreading an array among other arguments is not supported by the IR")
+				}
+				elems[j] = f.coerce(arg, value(arg), array.Elem)
+			}
+			v = f.op(OpArray, typ, elems...)
+		case len(bound) == 1:
+			if bound[0].Spread {
+				f.errorf(bound[0], "cannot spread an array into a parameter that is not a rest parameter")
+			}
+			v = f.coerce(bound[0], value(bound[0]), typ)
+		case b.Defaults[i] != nil:
+			// defaults are evaluated in the scope of the callee, which
+			// sees only top-level declarations
+			v = f.coerce(b.Defaults[i], f.expr(newScope(nil), b.Defaults[i]), typ)
+		default:
+			f.errorf(e, "missing argument for parameter %d in call to %s", i+1, e.Function)
+		}
+		operands = append(operands, v)
+	}
+	return operands
+}
+
+// callee returns the function named name called directly by call, or the
+// function value of the variable name. A call of an overloaded function
+// calls the overload the checker resolved it to, and a call of a generic
+// function its instance for the type arguments of the call, or the
+// function kept generic and the type arguments.
+func (f *funcLowerer) callee(s *scope, call *ast.FunctionCall, node ast.Node, name string) (*Func, []types.Type, Value) {
+	if v := s.lookup(name); v != nil {
+		return nil, nil, f.read(v)
+	}
+	obj := f.info.Scope.LookupLocal(name)
+	if obj == nil {
+		f.errorf(node, "undefined: %s", name)
+	}
+	if overload := f.info.Overloads[call]; overload != nil {
+		obj = overload
+	}
+	if inst := f.info.Instances[call]; inst != nil {
+		fn, targs := f.instance(call, obj, inst)
+		return fn, targs, nil
+	}
+	return f.function(node, obj), nil, nil
+}
+
+// instantiate returns the signature of fn called with the type arguments
+// targs, if it is kept generic.
+func instantiate(fn *Func, targs []types.Type) *types.Function {
+	if targs == nil {
+		return fn.Sig
+	}
+	args := map[string]types.Type{}
+	for i, param := range fn.TypeParams {
+		args[param.Name()] = targs[i]
+	}
+	return Canonical(types.Subst(fn.Sig, args)).(*types.Function)
+}
+
+// printSig is the signature of the host function print, to which the text
+// of the arguments of the builtin print is passed.
+var printSig = types.NewFunction([]*types.Field{types.NewField("", types.Typ[types.String])}, nil, true)
+
+// builtin lowers a call of a builtin function or of a function synthesized
+// for enum types. Calls whose values are known at compile time have been
+// lowered to their values.
+func (f *funcLowerer) builtin(s *scope, e *ast.FunctionCall, name string) Value {
+	var args []Value
+	if member, ok := e.Function.(*ast.MemberAccess); ok {
+		if object, ok := member.Object.(ast.Expression); ok {
+			args = append(args, f.expr(s, object))
+		}
+	}
+	if e.Arguments != nil && e.Arguments.Args != nil {
+		for _, arg := range e.Arguments.Args.Args {
+			args = append(args, f.expr(s, arg.Expr))
+		}
+	}
+
+	if name == "print" {
+		var parts []Value
+		for i, arg := range args {
+			if i > 0 {
+				parts = append(parts, f.constant(consteval.String(" ")))
+			}
+			parts = append(parts, f.text(arg))
+		}
+		print := f.extern("print", printSig)
+		f.emit(&Instr{Op: OpCall, Args: []Value{f.concat(parts)}, Callee: print.Name, Fx: true})
+		return f.nilValue()
+	}
+	if len(args) != 1 {
+		f.errorf(e, "%s takes a single argument", name)
+	}
+	arg := args[0]
+	switch name {
+	case "len":
+		if tuple, ok := arg.Type().Underlying().(*types.Tuple); ok {
+			return f.intConst(int64(len(tuple.Fields)))
+		}
+		return f.op(OpLen, types.Int, arg)
+	case "int":
+		if types.IsEnum(arg.Type()) {
+			return f.op(OpOrd, types.Int, arg)
+		}
+	case "string":
+		if types.IsEnum(arg.Type()) {
+			return f.memberName(e, arg)
+		}
+	}
+	f.errorf(e, "%s of %s is not supported by the IR", name, arg.Type())
+	return nil
+}
+
+// memberName returns the name of the member of an enum type v holds.
+func (f *funcLowerer) memberName(node ast.Node, v Value) Value {
+	enum := v.Type().Underlying().(*types.Enum)
+	result := &variable{typ: types.Typ[types.String]}
+	done := f.fn.NewBlock()
+	ord := f.op(OpOrd, types.Int, v)
+	for i, member := range enum.Members {
+		if i < len(enum.Members)-1 {
+			then, els := f.branch(f.op(OpEq, boolType, ord, f.intConst(member.Value)))
+			f.start(then)
+			f.join(node, result, f.constant(consteval.String(member.Name)), done)
+			f.start(els)
+			continue
+		}
+		f.join(node, result, f.constant(consteval.String(member.Name)), done)
+	}
+	return f.joined(result, done)
+}
@@ -0,0 +1,244 @@
+package ir
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/bind"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Closures are lifted out of the functions they are created in: the body
+// of a function block, of a local function or of a partial application
+// becomes a function of the module of its own, named after the function it
+// is created in and numbered, as f$1, or after the local function. Its
+// parameters are the values the closure captures, followed by its own. A
+// func instruction builds the closure from the values captured, which a
+// call through the closure passes ahead of its arguments.
+//
+// Variables are captured by value, when the closure is created. The
+// closure of a local function that refers to itself and captures values
+// captures itself too, after them, so that it and the values it holds
+// form a cycle; one that captures nothing refers to its function.
+
+// lift returns a lowerer for the body of a closure of type sig created in
+// the function being lowered, which is lowered into a new function of the
+// module. The function of a local function is named after it, as f$sq,
+// numbered from 2 if the name is taken, as f$sq.2.
+func (f *funcLowerer) lift(sig *types.Function, local string) *funcLowerer {
+	var name string
+	if local == "" {
+		f.lifted[f.fn.Name]++
+		name = fmt.Sprintf("%s$%d", f.fn.Name, f.lifted[f.fn.Name])
+	} else {
+		name = f.fn.Name + "$" + local
+		for n := 2; f.funcs[name] != nil; n++ {
+			name = fmt.Sprintf("%s$%s.%d", f.fn.Name, local, n)
+		}
+	}
+	fn := &Func{Name: name, Sig: sig}
+	f.funcs[fn.Name] = fn
+	f.module.Funcs = append(f.module.Funcs, fn)
+	return &funcLowerer{lowerer: f.lowerer, builder: newBuilder(fn), decl: f.decl, targs: f.targs, edges: f.edges, local: local}
+}
+
+// captureScope returns the outermost scope of the body of the closure c,
+// created where s is in scope. The names c does not bind are looked up in
+// s, and the variables found captured.
+func (c *funcLowerer) captureScope(f *funcLowerer, s *scope) *scope {
+	root := newScope(nil)
+	root.capture = func(name string) *variable {
+		outer := s.lookup(name)
+		if outer == nil {
+			return nil
+		}
+		param := &Param{Name: name, Typ: outer.typ}
+		v := &variable{name: name, typ: outer.typ}
+		root.vars[name] = v
+		c.writeIn(v, c.fn.Blocks[0], param)
+		c.captures = append(c.captures, func() Value { return f.read(outer) })
+		c.captured = append(c.captured, param)
+		return v
+	}
+	return root
+}
+
+// close finishes the closure c of type sig, whose own parameters are
+// params, and returns the closure, built from the values it captures.
+func (f *funcLowerer) close(c *funcLowerer, params []*Param, sig *types.Function) Value {
+	fn := c.fn
+	c.finish()
+	// a local function still referring to its function captures itself
+	// if it captures anything else
+	captured := c.captured
+	rec := len(captured) > 0 && c.self != nil && uses(fn, c.self)
+	if rec {
+		self := &Param{Name: c.local, Typ: sig}
+		fn.Replace(c.self, self)
+		captured = append(captured[:len(captured):len(captured)], self)
+	}
+	fn.Params = append(append([]*Param{}, captured...), params...)
+	var fields []*types.Field
+	for _, param := range captured {
+		fields = append(fields, types.NewField("", param.Typ))
+	}
+	fn.Sig = types.NewFunction(append(fields, sig.Params...), sig.Result, sig.HasSideEffects)
+	if sig.Rest >= 0 {
+		fn.Sig.Rest = sig.Rest + len(fields)
+	}
+	fn.Update()
+
+	captures := make([]Value, len(c.captures))
+	for i, capture := range c.captures {
+		captures[i] = capture()
+	}
+	return f.emit(&Instr{Op: OpFunc, Typ: sig, Callee: fn.Name, Args: captures, Rec: rec})
+}
+
+// uses reports whether an instruction of fn has v as an operand.
+func uses(fn *Func, v Value) bool {
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if slices.Contains(instr.Args, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// funcBlock lowers a function block to a closure. Its parameters, or it if
+// it declares none and takes a single argument, receive the arguments.
+func (f *funcLowerer) funcBlock(s *scope, block *ast.FunctionBlock) Value {
+	sig, ok := f.typeOf(block).(*types.Function)
+	if !ok {
+		f.errorf(block, "type of %s is not a function", block)
+	}
+	c := f.lift(sig, "")
+	root := c.captureScope(f, s)
+	params := make([]*Param, len(sig.Params))
+	for i, p := range sig.Params {
+		params[i] = &Param{Name: fmt.Sprintf("_%d", i), Typ: p.Type}
+	}
+	switch {
+	case block.Parameters != nil && block.Parameters.Parameters != nil:
+		lhs := block.Parameters.Parameters
+		if ordinal, ok := lhs.(*ast.OrdinalAssignmentLHS); ok &&
+			ordinal.RestOperator == nil && len(ordinal.Identifiers) == len(params) {
+			// the parameters are named by the block
+			for i, ident := range ordinal.Identifiers {
+				if ident != nil && ident.Name != "_" {
+					params[i].Name = ident.Name
+					c.define(root, ident.Name, params[i])
+				}
+			}
+			break
+		}
+		var v Value
+		if len(params) == 1 {
+			v = params[0]
+		} else {
+			args := make([]Value, len(params))
+			for i, param := range params {
+				args[i] = param
+			}
+			v = c.op(OpTuple, types.NewTuple(sig.Params...), args...)
+		}
+		c.destructure(block, lhs, v, func(ident *ast.Identifier, v Value) {
+			c.define(root, ident.Name, v)
+		})
+	case len(params) == 1:
+		params[0].Name = "it"
+		c.define(root, "it", params[0])
+	}
+	v := c.body(root, block.Body)
+	c.ret(block.Body, v)
+	return f.close(c, params, sig)
+}
+
+// localFunc lowers the declaration of a local function, binding its name
+// in s to its closure.
+func (f *funcLowerer) localFunc(s *scope, decl *ast.FunctionDeclaration) {
+	name := decl.LHS.Name.Name
+	sig, ok := f.info.Types[decl].(*types.Function)
+	if !ok {
+		f.errorf(decl, "type of %s is not known", name)
+	}
+	if sig.TypeParams != nil {
+		f.errorf(decl, "generic local function %s is not supported by the IR", name)
+	}
+	if decl.Body == nil {
+		f.errorf(decl, "%s has no body", name)
+	}
+	sig = Canonical(f.subst(sig)).(*types.Function)
+	c := f.lift(sig, name)
+	root := c.captureScope(f, s)
+	c.self = c.emit(&Instr{Op: OpFunc, Typ: sig, Callee: c.fn.Name})
+	c.define(root, name, c.self)
+	params := c.params(decl, sig, root)
+	v := c.block(root, decl.Body)
+	c.ret(decl.Body, v)
+	f.define(s, name, f.close(c, params, sig))
+}
+
+// partial lowers the partial application e of the function of type sig,
+// called directly if callee is set and through the function value fv
+// otherwise, whose arguments are bound by b and have the values value
+// returns. The closure captures the function value and the arguments
+// supplied, and takes the parameters left.
+func (f *funcLowerer) partial(e *ast.FunctionCall, p *check.Partial, b *bind.Binding, sig *types.Function,
+	callee *Func, targs []types.Type, fv Value, value func(*ast.Argument) Value) Value {
+	typ := Canonical(f.subst(p.Type)).(*types.Function)
+	c := f.lift(typ, "")
+	capture := func(v Value) Value {
+		param := &Param{Name: fmt.Sprintf("_%d", len(c.captured)), Typ: v.Type()}
+		c.captures = append(c.captures, func() Value { return v })
+		c.captured = append(c.captured, param)
+		return param
+	}
+	var operands []Value
+	if callee == nil {
+		operands = append(operands, capture(fv))
+	}
+	var params []*Param
+	open := func(i int) Value {
+		param := &Param{Typ: sig.Params[i].Type}
+		params = append(params, param)
+		return param
+	}
+	operands = append(operands, c.operands(e, b, sig, func(arg *ast.Argument) Value {
+		return capture(value(arg))
+	}, open)...)
+	for i, param := range params {
+		param.Name = fmt.Sprintf("_%d", len(c.captured)+i)
+	}
+
+	instr := &Instr{Op: OpCall, Typ: sig.Result, Args: operands, Fx: sig.HasSideEffects}
+	if callee != nil {
+		instr.Callee, instr.TypeArgs = callee.Name, targs
+	}
+	c.emit(instr)
+	c.ret(e, instr)
+	return f.close(c, params, typ)
+}
+
+// FuncText returns the text of a value of the function named name, as
+// print writes it: the name of the function declared, without the suffix
+// telling overloads and local functions of the same name apart, or
+// fn { ... } for the closure of a function block or partial application,
+// as the interpreter writes them.
+func FuncText(name string) string {
+	if i := strings.LastIndexByte(name, '$'); i >= 0 {
+		name = name[i+1:]
+		if name == "" || name[0] >= '0' && name[0] <= '9' {
+			return "fn { ... }"
+		}
+	}
+	if i := strings.LastIndexByte(name, '.'); i >= 0 && strings.Trim(name[i+1:], "0123456789") == "" {
+		name = name[:i]
+	}
+	return name
+}
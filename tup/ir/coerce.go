@@ -0,0 +1,188 @@
+package ir
+
+import (
+	"math/big"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// coerce converts v to the type want, as binding a value to a variable or
+// parameter of that type does: constants are converted, values are
+// wrapped into unions that have their type as a member and unwrapped from
+// unions whose members convert to the type wanted, and unions are
+// rewrapped into unions with the members they hold. Unwrapping traps if
+// the union holds a member that does not convert.
+func (f *funcLowerer) coerce(node ast.Node, v Value, want types.Type) Value {
+	have := v.Type()
+	if types.Identical(have, want) {
+		return v
+	}
+	if instr, ok := v.(*Instr); ok {
+		switch instr.Op {
+		case OpConst:
+			if c, err := consteval.Convert(instr.Const, want); err != nil {
+				f.errorf(node, "%s", err)
+			} else if types.Identical(c.Type(), want) {
+				return f.constant(c)
+			}
+		case OpUndef:
+			return f.emit(&Instr{Op: OpUndef, Typ: want})
+		}
+	}
+
+	wantUnion, _ := want.Underlying().(*types.Union)
+	haveUnion, _ := have.Underlying().(*types.Union)
+	switch {
+	case wantUnion != nil && haveUnion == nil:
+		if i := MemberIndex(wantUnion, have); i >= 0 {
+			return f.wrap(v, i, want)
+		}
+		if instr, ok := v.(*Instr); ok && instr.Op == OpConst {
+			// an untyped constant takes the type of the first member
+			// that represents it
+			for i, member := range wantUnion.Members {
+				if c, err := consteval.Convert(instr.Const, member); err == nil && types.Identical(c.Type(), member) {
+					return f.wrap(f.constant(c), i, want)
+				}
+			}
+		}
+	case wantUnion != nil && haveUnion != nil:
+		if i := MemberIndex(wantUnion, have); i >= 0 {
+			return f.wrap(v, i, want)
+		}
+		return f.rewrap(node, v, haveUnion, want, wantUnion)
+	case haveUnion != nil:
+		// the members that convert, such as the tuples of the arms of a
+		// switch whose fields are wrapped differently
+		var members []int
+		for i, member := range haveUnion.Members {
+			if convertible(member, want) {
+				members = append(members, i)
+			}
+		}
+		if i := MemberIndex(haveUnion, want); i >= 0 && len(members) == 1 {
+			return f.payload(v, i, want)
+		}
+		if len(members) > 0 {
+			return f.unwrap(v, haveUnion, want, members, func(i int, payload Value) Value {
+				return f.coerce(node, payload, want)
+			})
+		}
+	}
+
+	haveTuple, _ := have.Underlying().(*types.Tuple)
+	wantTuple, _ := want.Underlying().(*types.Tuple)
+	if haveTuple != nil && wantTuple != nil && len(haveTuple.Fields) == len(wantTuple.Fields) {
+		fields := make([]Value, len(wantTuple.Fields))
+		for i, field := range wantTuple.Fields {
+			fields[i] = f.coerce(node, f.field(v, i, haveTuple.Fields[i].Type), field.Type)
+		}
+		return f.op(OpTuple, want, fields...)
+	}
+	f.errorf(node, "cannot convert %s to %s", have, want)
+	return nil
+}
+
+// rewrap converts the union v to the union type want, branching on the
+// member v holds. Members of v that want lacks trap, unless they convert
+// to want themselves.
+func (f *funcLowerer) rewrap(node ast.Node, v Value, have *types.Union, want types.Type, wantUnion *types.Union) Value {
+	var members []int
+	to := map[int]int{}
+	for i, member := range have.Members {
+		j := MemberIndex(wantUnion, member)
+		if j >= 0 || convertible(member, want) {
+			members = append(members, i)
+			to[i] = j
+		}
+	}
+	if len(members) == 0 {
+		f.errorf(node, "cannot convert %s to %s", v.Type(), want)
+	}
+	return f.unwrap(v, have, want, members, func(i int, payload Value) Value {
+		if to[i] < 0 {
+			return f.coerce(node, payload, want)
+		}
+		return f.wrap(payload, to[i], want)
+	})
+}
+
+// unwrap converts the union v to the type want, branching on the member v
+// holds: convert converts the payloads of the given members, and the
+// others trap.
+func (f *funcLowerer) unwrap(v Value, have *types.Union, want types.Type, members []int, convert func(i int, payload Value) Value) Value {
+	missing := len(members) < len(have.Members)
+	result := &variable{typ: want}
+	tag := f.op(OpTag, types.Int, v)
+	done := f.fn.NewBlock()
+	for k, i := range members {
+		if k == len(members)-1 && !missing {
+			// the last member needs no test
+			f.write(result, convert(i, f.payload(v, i, have.Members[i])))
+			f.jump(done)
+			break
+		}
+		match, next := f.fn.NewBlock(), f.fn.NewBlock()
+		f.br(f.op(OpEq, types.Typ[types.Bool], tag, f.intConst(int64(i))), match, next)
+		f.seal(match)
+		f.seal(next)
+		f.start(match)
+		f.write(result, convert(i, f.payload(v, i, have.Members[i])))
+		f.jump(done)
+		f.start(next)
+	}
+	if missing {
+		f.trap("cannot convert " + v.Type().String() + " to " + want.String())
+	}
+	f.seal(done)
+	f.start(done)
+	return f.read(result)
+}
+
+// convertible reports whether coerce converts every value of type have to
+// want without trapping.
+func convertible(have, want types.Type) bool {
+	return convertibleTypes(have, want, map[[2]types.Type]bool{})
+}
+
+// convertibleTypes implements convertible; seen holds the pairs of types
+// being compared, which recursive types come back to.
+func convertibleTypes(have, want types.Type, seen map[[2]types.Type]bool) bool {
+	if types.Identical(have, want) {
+		return true
+	}
+	wantUnion, _ := want.Underlying().(*types.Union)
+	if wantUnion != nil && MemberIndex(wantUnion, have) >= 0 {
+		return true
+	}
+	pair := [2]types.Type{have, want}
+	if seen[pair] {
+		return true
+	}
+	seen[pair] = true
+	if haveUnion, ok := have.Underlying().(*types.Union); ok {
+		for _, member := range haveUnion.Members {
+			if !convertibleTypes(member, want, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	haveTuple, _ := have.Underlying().(*types.Tuple)
+	wantTuple, _ := want.Underlying().(*types.Tuple)
+	if haveTuple == nil || wantTuple == nil || len(haveTuple.Fields) != len(wantTuple.Fields) {
+		return false
+	}
+	for i, field := range wantTuple.Fields {
+		if !convertibleTypes(haveTuple.Fields[i].Type, field.Type, seen) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *builder) intConst(n int64) *Instr {
+	return b.constant(&consteval.Int{Val: big.NewInt(n), Typ: types.Int})
+}
@@ -0,0 +1,548 @@
+package ir
+
+import (
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/match"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// unreachable returns a value for an expression that does not complete,
+// such as a return expression, for the code that follows it, which control
+// never reaches.
+func (f *funcLowerer) unreachable() Value {
+	return f.emit(&Instr{Op: OpUndef, Typ: types.Typ[types.Invalid]})
+}
+
+// resultVar returns a variable to join the values of the branches of an
+// expression of type typ in, or nil if the expression has no value.
+func resultVar(typ types.Type) *variable {
+	if types.IsInvalid(typ) {
+		return nil
+	}
+	return &variable{typ: typ}
+}
+
+// join ends a branch of an expression with the value v, which flows to
+// the block done in the variable result.
+func (f *funcLowerer) join(node ast.Node, result *variable, v Value, done *Block) {
+	if result != nil {
+		f.write(result, f.coerce(node, v, result.typ))
+	}
+	f.jump(done)
+}
+
+// joined continues with the block done, which the branches of an
+// expression join, and returns the value of the expression.
+func (f *funcLowerer) joined(result *variable, done *Block) Value {
+	f.seal(done)
+	f.start(done)
+	if result == nil {
+		return f.nilValue()
+	}
+	return f.read(result)
+}
+
+// branch ends the current block with a branch on cond and returns the
+// blocks taken when it is true and false.
+func (f *funcLowerer) branch(cond Value) (then, els *Block) {
+	then, els = f.fn.NewBlock(), f.fn.NewBlock()
+	f.br(cond, then, els)
+	f.seal(then)
+	f.seal(els)
+	return then, els
+}
+
+func (f *funcLowerer) ifExpr(s *scope, e *ast.IfExpression) Value {
+	result := resultVar(f.typeOf(e))
+	done := f.fn.NewBlock()
+	for i, cond := range e.Conditions {
+		expr, ok := cond.(ast.Expression)
+		if !ok {
+			f.errorf(cond, "%s is not supported by the IR", cond)
+		}
+		then, els := f.branch(f.coerce(cond, f.expr(s, expr), boolType))
+		f.start(then)
+		f.join(e.Blocks[i], result, f.block(s, e.Blocks[i]), done)
+		f.start(els)
+	}
+	if e.HasElse {
+		last := e.Blocks[len(e.Blocks)-1]
+		f.join(last, result, f.block(s, last), done)
+	} else {
+		f.join(e, result, f.nilValue(), done)
+	}
+	return f.joined(result, done)
+}
+
+// returnExpr lowers a return expression, which returns its value, or nil,
+// from the function.
+func (f *funcLowerer) returnExpr(s *scope, e *ast.ReturnExpression) Value {
+	var v Value
+	if e.Expression != nil {
+		v = f.expr(s, e.Expression)
+	} else {
+		v = f.nilValue()
+	}
+	f.ret(e, v)
+	return f.unreachable()
+}
+
+// loop holds the state of a for loop being lowered.
+type loop struct {
+	// result holds the value of the loop, set when its condition fails or
+	// its iterable is exhausted and by break
+	result *variable
+	// latch ends each iteration, advancing the iterable; exit follows the
+	// loop
+	latch, exit *Block
+
+	init *ast.Initializer
+	step *ast.StepExpression
+	// state holds the value of the initializer and of each iteration;
+	// vars holds the variables bound to its parts by the initializer
+	state *variable
+	vars  map[string]*variable
+	// iteration is the scope of the loop variables, in which the step
+	// expression is evaluated
+	iteration *scope
+}
+
+// forExpr lowers a for loop to blocks for its entry, a header testing its
+// condition or iterable, its body, a latch advancing its iterable and its
+// exit. The variables bound by the initializer carry the state of the loop
+// from one iteration to the next, through phis in the header.
+func (f *funcLowerer) forExpr(parent *scope, e *ast.ForExpression) Value {
+	s := newScope(parent)
+	l := &loop{
+		result: &variable{typ: f.typeOf(e)},
+		latch:  f.fn.NewBlock(),
+		exit:   f.fn.NewBlock(),
+		vars:   map[string]*variable{},
+	}
+	var cond ast.Expression
+	switch header := e.Header.(type) {
+	case *ast.ForHeader:
+		l.init, cond, l.step = header.Initializer, header.Condition, header.StepExpr
+	case *ast.ForInHeader:
+		l.init, l.step = header.Initializer, header.StepExpr
+	}
+
+	if l.init != nil {
+		v := f.expr(s, l.init.Assignment.Right)
+		l.state = &variable{typ: v.Type()}
+		f.write(l.state, v)
+		f.destructure(l.init, l.init.Assignment.Left, v, func(ident *ast.Identifier, part Value) {
+			if part == v && types.Identical(part.Type(), l.state.typ) {
+				// a name bound to the whole state is the state, so
+				// that the loop carries it through a single phi
+				s.vars[ident.Name] = l.state
+				l.vars[ident.Name] = l.state
+				return
+			}
+			l.vars[ident.Name] = f.define(s, ident.Name, part)
+		})
+	}
+	var it *iterator
+	if header, ok := e.Header.(*ast.ForInHeader); ok {
+		it = f.iterate(s, header.Iterable)
+	}
+
+	header, body := f.fn.NewBlock(), f.fn.NewBlock()
+	f.jump(header)
+	f.start(header)
+	var test Value
+	switch {
+	case cond != nil:
+		test = f.coerce(cond, f.expr(s, cond), boolType)
+	case it != nil:
+		test = it.cond()
+	}
+	if test != nil {
+		var state Value
+		if l.state != nil {
+			state = f.read(l.state)
+		} else {
+			state = f.nilValue()
+		}
+		f.write(l.result, f.coerce(e, state, l.result.typ))
+		f.br(test, body, l.exit)
+	} else {
+		f.jump(body)
+	}
+	f.seal(body)
+	f.start(body)
+
+	l.iteration = newScope(s)
+	if it != nil {
+		header := e.Header.(*ast.ForInHeader)
+		f.destructure(header, header.LoopVar, it.elem(), func(ident *ast.Identifier, v Value) {
+			f.define(l.iteration, ident.Name, v)
+		})
+	}
+	f.loops = append(f.loops, l)
+	var v Value
+	if e.Block != nil {
+		v = f.statements(newScope(l.iteration), e.Block.Statements, e.Block.Expression)
+	} else {
+		v = f.nilValue()
+	}
+	f.next(e, l, v)
+	f.loops = f.loops[:len(f.loops)-1]
+
+	f.seal(l.latch)
+	f.start(l.latch)
+	if it != nil {
+		it.advance()
+	}
+	f.jump(header)
+	f.seal(header)
+	f.seal(l.exit)
+	f.start(l.exit)
+	return f.read(l.result)
+}
+
+// next ends an iteration of the loop l whose value is v, which with the
+// step expression, if there is one, gives the next state of the loop.
+func (f *funcLowerer) next(node ast.Node, l *loop, v Value) {
+	if l.init != nil {
+		if l.step != nil {
+			v = f.expr(l.iteration, l.step.Expression)
+		}
+		state := f.coerce(node, v, l.state.typ)
+		f.write(l.state, state)
+		f.destructure(node, l.init.Assignment.Left, state, func(ident *ast.Identifier, v Value) {
+			variable := l.vars[ident.Name]
+			f.write(variable, f.coerce(node, v, variable.typ))
+		})
+	}
+	f.jump(l.latch)
+}
+
+// innermost returns the innermost loop enclosing node.
+func (f *funcLowerer) innermost(node ast.Node) *loop {
+	if len(f.loops) == 0 {
+		f.errorf(node, "%s is not in a loop", node)
+	}
+	return f.loops[len(f.loops)-1]
+}
+
+// breakExpr lowers a break expression, which exits the innermost loop with
+// its value, or nil.
+func (f *funcLowerer) breakExpr(s *scope, e *ast.BreakExpression) Value {
+	l := f.innermost(e)
+	var v Value
+	if e.Expression != nil {
+		v = f.expr(s, e.Expression)
+	} else {
+		v = f.nilValue()
+	}
+	f.join(e, l.result, v, l.exit)
+	return f.unreachable()
+}
+
+// continueExpr lowers a continue expression, which ends the iteration of
+// the innermost loop with its value, or leaves the state of the loop as it
+// is if it has none.
+func (f *funcLowerer) continueExpr(s *scope, e *ast.ContinueExpression) Value {
+	l := f.innermost(e)
+	if e.Expression == nil {
+		f.jump(l.latch)
+	} else {
+		f.next(e, l, f.expr(s, e.Expression))
+	}
+	return f.unreachable()
+}
+
+// iterator steps through the values of the iterable of a for-in loop.
+type iterator struct {
+	cond    func() Value // whether there is a value, tested in the header
+	elem    func() Value // the value, at the start of the body
+	advance func()       // moves on to the next value, in the latch
+}
+
+// iterate returns an iterator over the iterable of a for-in loop: the
+// elements of an array, the integers of a range, the members of an enum
+// type, or the values of several iterables in lockstep.
+func (f *funcLowerer) iterate(s *scope, it *ast.Iterable) *iterator {
+	if ref := it.TypeReference; ref != nil {
+		obj := f.info.Scope.Lookup(ref.TypeIdentifier.Name)
+		if obj == nil || obj.Kind != check.TypeObject || !types.IsEnum(obj.Type) {
+			f.errorf(ref, "cannot iterate over type %s", ref)
+		}
+		enum := obj.Type.Underlying().(*types.Enum)
+		members := make([]Value, len(enum.Members))
+		for i, member := range enum.Members {
+			members[i] = f.constant(&consteval.Enum{Typ: obj.Type, Member: member})
+		}
+		array := f.op(OpArray, types.NewFixedArray(obj.Type, int64(len(members))), members...)
+		return f.iterator(ref, array)
+	}
+	return f.iterator(it.Expression, f.expr(s, it.Expression))
+}
+
+func (f *funcLowerer) iterator(node ast.Node, v Value) *iterator {
+	switch typ := v.Type().Underlying().(type) {
+	case *types.Array:
+		i := &variable{typ: types.Int}
+		f.write(i, f.intConst(0))
+		n := f.op(OpLen, types.Int, v)
+		return &iterator{
+			cond: func() Value { return f.op(OpLt, boolType, f.read(i), n) },
+			elem: func() Value { return f.op(OpIndex, typ.Elem, v, f.read(i)) },
+			advance: func() {
+				f.write(i, f.op(OpAdd, types.Int, f.read(i), f.intConst(1)))
+			},
+		}
+	case *types.Tuple:
+		if isRange(v.Type()) {
+			elem := typ.Fields[0].Type
+			i := &variable{typ: elem}
+			f.write(i, f.field(v, 0, elem))
+			hi := f.field(v, 1, typ.Fields[1].Type)
+			one, err := consteval.Convert(consteval.NewInt(1), elem)
+			if err != nil {
+				f.errorf(node, "%s", err)
+			}
+			return &iterator{
+				cond: func() Value { return f.op(OpLe, boolType, f.read(i), hi) },
+				elem: func() Value { return f.read(i) },
+				advance: func() {
+					f.write(i, f.op(OpAdd, elem, f.read(i), f.constant(one)))
+				},
+			}
+		}
+		if _, ok := v.Type().(*types.Named); !ok {
+			// a tuple of iterables produces tuples of their values,
+			// ending with the shortest
+			its := make([]*iterator, len(typ.Fields))
+			for i, field := range typ.Fields {
+				its[i] = f.iterator(node, f.field(v, i, field.Type))
+			}
+			return &iterator{
+				cond: func() Value {
+					var test Value
+					for _, it := range its {
+						if c := it.cond(); test == nil {
+							test = c
+						} else {
+							test = f.op(OpAnd, boolType, test, c)
+						}
+					}
+					return test
+				},
+				elem: func() Value {
+					elems := make([]Value, len(its))
+					fields := make([]*types.Field, len(its))
+					for i, it := range its {
+						elems[i] = it.elem()
+						fields[i] = types.NewField(typ.Fields[i].Name, elems[i].Type())
+					}
+					return f.op(OpTuple, types.NewTuple(fields...), elems...)
+				},
+				advance: func() {
+					for _, it := range its {
+						it.advance()
+					}
+				},
+			}
+		}
+	}
+	f.errorf(node, "iteration over %s is not supported by the IR", v.Type())
+	return nil
+}
+
+// isRange reports whether typ is an instance of the core Range type.
+func isRange(typ types.Type) bool {
+	named, ok := typ.(*types.Named)
+	return ok && strings.HasPrefix(named.Name(), "Range[")
+}
+
+// switchExpr lowers a switch expression by walking the decision tree the
+// checker compiled for it. A switch no case of which matches has the value
+// nil, or traps if its type has no nil.
+func (f *funcLowerer) switchExpr(s *scope, e *ast.SwitchExpression) Value {
+	tree := f.info.Matches[e]
+	if tree == nil {
+		f.errorf(e, "%s is not supported by the IR", e)
+	}
+	sw := &switchLowerer{f: f, s: s, e: e, subject: f.expr(s, e.Expression)}
+	sw.result = resultVar(f.typeOf(e))
+	sw.done = f.fn.NewBlock()
+	sw.node(tree.Root)
+	return f.joined(sw.result, sw.done)
+}
+
+type switchLowerer struct {
+	f       *funcLowerer
+	s       *scope
+	e       *ast.SwitchExpression
+	subject Value
+	result  *variable
+	done    *Block
+}
+
+// node lowers the decision tree node n in the current block.
+func (sw *switchLowerer) node(n match.Node) {
+	f := sw.f
+	switch n := n.(type) {
+	case *match.Switch:
+		if len(n.Cases) == 0 {
+			sw.node(n.Default)
+			return
+		}
+		v := sw.valueAt(n.Path)
+		for i, c := range n.Cases {
+			if i == len(n.Cases)-1 && n.Default == nil {
+				// the cases cover every value, so the last needs no test
+				sw.node(c.Node)
+				return
+			}
+			then, els := f.branch(sw.test(v, c.Test))
+			f.start(then)
+			sw.node(c.Node)
+			f.start(els)
+		}
+		sw.node(n.Default)
+	case *match.Leaf:
+		block := sw.e.ElseBlock
+		subject := sw.subject
+		if n.Case != match.Else {
+			block = sw.e.Cases[n.Case].Body
+		} else {
+			// the else block receives the members no case selects
+			subject = f.coerce(block, subject, Canonical(f.subst(match.ElseType(sw.e, f.info))))
+		}
+		s := newScope(sw.s)
+		for _, b := range n.Bindings {
+			v := subject
+			if b.Path.Kind != match.Root {
+				v = sw.valueAt(b.Path)
+			}
+			f.define(s, b.Ident.Name, v)
+		}
+		if block.Parameters == nil || block.Parameters.Parameters == nil {
+			f.define(s, "it", subject)
+		}
+		f.join(block, sw.result, f.body(s, block.Body), sw.done)
+	case nil, *match.Fail:
+		if sw.result != nil && !hasType(types.Typ[types.Nil], sw.result.typ) {
+			f.trap("no case of the switch matches")
+			return
+		}
+		f.join(sw.e, sw.result, f.nilValue(), sw.done)
+	default:
+		f.errorf(sw.e, "unexpected decision tree node %T", n)
+	}
+}
+
+// valueAt returns the part of the subject at path.
+func (sw *switchLowerer) valueAt(path *match.Path) Value {
+	f := sw.f
+	if path.Kind == match.Root {
+		return sw.subject
+	}
+	parent := sw.valueAt(path.Parent)
+	switch path.Kind {
+	case match.As:
+		if union, ok := parent.Type().Underlying().(*types.Union); ok {
+			if i := MemberIndex(union, Canonical(f.subst(path.Type))); i >= 0 {
+				return f.payload(parent, i, union.Members[i])
+			}
+		}
+		// the value is narrowed to several members, or already narrowed
+		return parent
+	case match.Field:
+		if tuple, ok := parent.Type().Underlying().(*types.Tuple); ok && path.Index < len(tuple.Fields) {
+			return f.field(parent, path.Index, tuple.Fields[path.Index].Type)
+		}
+	case match.Elem:
+		if array, ok := parent.Type().Underlying().(*types.Array); ok {
+			return f.op(OpIndex, array.Elem, parent, f.intConst(int64(path.Index)))
+		}
+	case match.Rest:
+		if tuple, ok := parent.Type().Underlying().(*types.Tuple); ok && path.Index <= len(tuple.Fields) {
+			rest := types.NewTuple(tuple.Fields[path.Index:]...)
+			fields := make([]Value, len(rest.Fields))
+			for i, field := range rest.Fields {
+				fields[i] = f.field(parent, path.Index+i, field.Type)
+			}
+			return f.op(OpTuple, rest, fields...)
+		}
+		if array, ok := parent.Type().Underlying().(*types.Array); ok {
+			// the subject has passed a test of its length
+			last := f.op(OpSub, types.Int, f.op(OpLen, types.Int, parent), f.intConst(1))
+			return f.op(OpSlice, types.NewArray(array.Elem), parent, f.intConst(int64(path.Index)), last)
+		}
+	}
+	f.errorf(sw.e, "cannot select %s of %s", path, parent.Type())
+	return nil
+}
+
+// test returns whether v passes the test t of a decision tree.
+func (sw *switchLowerer) test(v Value, t *match.Test) Value {
+	f := sw.f
+	ordered := func(op Op, x, y Value) Value {
+		if types.IsEnum(x.Type()) {
+			x, y = f.op(OpOrd, types.Int, x), f.op(OpOrd, types.Int, y)
+		}
+		return f.op(op, boolType, x, y)
+	}
+	constant := func(c consteval.Value) Value {
+		return f.coerce(sw.e, f.constValue(sw.e, c), v.Type())
+	}
+	switch t.Kind {
+	case match.Tag:
+		return f.hasType(v, f.subst(t.Type))
+	case match.Equal:
+		return f.op(OpEq, boolType, v, constant(t.Value))
+	case match.InRange:
+		lo := ordered(OpLe, constant(t.Lo), v)
+		hi := ordered(OpLe, v, constant(t.Hi))
+		return f.op(OpAnd, boolType, lo, hi)
+	case match.Len, match.MinLen:
+		n := f.op(OpLen, types.Int, v)
+		op := OpEq
+		if t.Kind == match.MinLen {
+			op = OpGe
+		}
+		return f.op(op, boolType, n, f.intConst(int64(t.N)))
+	}
+	f.errorf(sw.e, "unexpected test %s", t)
+	return nil
+}
+
+// try lowers a try expression. An error returns from the function, breaks
+// out of the innermost loop with the error as its value, or continues with
+// its next iteration, as the variant of try requires; any other value is
+// the value of the expression.
+func (f *funcLowerer) try(s *scope, e *ast.TryExpression) Value {
+	expr, ok := e.Expression.(ast.Expression)
+	if !ok {
+		f.errorf(e, "%s is not supported by the IR", e)
+	}
+	if chain, ok := expr.(*ast.ChainedExpression); ok {
+		// the lowered pipeline distributes the try to each stage
+		return f.expr(s, chain)
+	}
+	v := f.expr(s, expr)
+	if _, ok := v.Type().Underlying().(*types.Union); !ok {
+		return v
+	}
+	failed, passed := f.branch(f.hasType(v, types.ErrorType))
+	f.start(failed)
+	switch e.Variant {
+	case ast.TryBreak:
+		l := f.innermost(e)
+		f.join(e, l.result, v, l.exit)
+	case ast.TryContinue:
+		f.jump(f.innermost(e).latch)
+	default:
+		f.ret(e, v)
+	}
+	f.start(passed)
+	return f.coerce(e, v, f.typeOf(e))
+}
@@ -0,0 +1,153 @@
+package ir
+
+// DomTree holds the dominators of the blocks of a function: a block a
+// dominates a block b if every path from the entry to b passes through a.
+type DomTree struct {
+	idom []*Block // immediate dominator of each block, by index; nil for the entry and unreachable blocks
+	pre  []int    // preorder number of each block in the tree
+	post []int    // postorder number of each block in the tree
+}
+
+// Dominators computes the dominator tree of fn, whose blocks and edges
+// must be up to date, using the algorithm of Cooper, Harvey and Kennedy,
+// "A Simple, Fast Dominance Algorithm".
+func Dominators(fn *Func) *DomTree {
+	n := len(fn.Blocks)
+	t := &DomTree{idom: make([]*Block, n), pre: make([]int, n), post: make([]int, n)}
+	if n == 0 {
+		return t
+	}
+
+	order := ReversePostorder(fn)
+	rpo := make([]int, n) // position of each block in order, or -1
+	for i := range rpo {
+		rpo[i] = -1
+	}
+	for i, b := range order {
+		rpo[b.Index] = i
+	}
+
+	entry := fn.Blocks[0]
+	doms := make([]*Block, n)
+	doms[entry.Index] = entry
+	intersect := func(a, b *Block) *Block {
+		for a != b {
+			for rpo[a.Index] > rpo[b.Index] {
+				a = doms[a.Index]
+			}
+			for rpo[b.Index] > rpo[a.Index] {
+				b = doms[b.Index]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order[1:] {
+			var idom *Block
+			for _, p := range b.Preds {
+				if doms[p.Index] == nil {
+					continue
+				}
+				if idom == nil {
+					idom = p
+				} else {
+					idom = intersect(p, idom)
+				}
+			}
+			if doms[b.Index] != idom {
+				doms[b.Index] = idom
+				changed = true
+			}
+		}
+	}
+	for _, b := range order[1:] {
+		t.idom[b.Index] = doms[b.Index]
+	}
+
+	// number the tree so that dominance is a comparison of intervals
+	children := make([][]*Block, n)
+	for _, b := range order[1:] {
+		idom := t.idom[b.Index]
+		children[idom.Index] = append(children[idom.Index], b)
+	}
+	clock := 0
+	var walk func(b *Block)
+	walk = func(b *Block) {
+		clock++
+		t.pre[b.Index] = clock
+		for _, c := range children[b.Index] {
+			walk(c)
+		}
+		clock++
+		t.post[b.Index] = clock
+	}
+	walk(entry)
+	return t
+}
+
+// Idom returns the immediate dominator of b, or nil if b is the entry or
+// is unreachable.
+func (t *DomTree) Idom(b *Block) *Block {
+	return t.idom[b.Index]
+}
+
+// Reachable reports whether b is reachable from the entry.
+func (t *DomTree) Reachable(b *Block) bool {
+	return t.pre[b.Index] != 0
+}
+
+// Dominates reports whether a dominates b. Every block dominates itself.
+func (t *DomTree) Dominates(a, b *Block) bool {
+	if !t.Reachable(a) || !t.Reachable(b) {
+		return false
+	}
+	return t.pre[a.Index] <= t.pre[b.Index] && t.post[b.Index] <= t.post[a.Index]
+}
+
+// ReversePostorder returns the blocks of fn reachable from the entry in
+// reverse postorder, in which each block comes before its successors
+// except along back edges.
+func ReversePostorder(fn *Func) []*Block {
+	if len(fn.Blocks) == 0 {
+		return nil
+	}
+	seen := make([]bool, len(fn.Blocks))
+	var post []*Block
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		seen[b.Index] = true
+		for _, s := range b.Succs {
+			if !seen[s.Index] {
+				visit(s)
+			}
+		}
+		post = append(post, b)
+	}
+	visit(fn.Blocks[0])
+	for i, j := 0, len(post)-1; i < j; i, j = i+1, j-1 {
+		post[i], post[j] = post[j], post[i]
+	}
+	return post
+}
+
+// InLoops returns the blocks of fn that are part of loops: those from
+// which a path leads back to them.
+func InLoops(fn *Func) map[*Block]bool {
+	in := map[*Block]bool{}
+	for _, b := range fn.Blocks {
+		seen := map[*Block]bool{}
+		work := append([]*Block{}, b.Succs...)
+		for len(work) > 0 && !in[b] {
+			s := work[len(work)-1]
+			work = work[:len(work)-1]
+			if s == b {
+				in[b] = true
+			} else if !seen[s] {
+				seen[s] = true
+				work = append(work, s.Succs...)
+			}
+		}
+	}
+	return in
+}
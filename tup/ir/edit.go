@@ -0,0 +1,97 @@
+package ir
+
+import "slices"
+
+// Replace replaces the instruction old, which it removes, by v in the
+// operands of the instructions of fn.
+func (fn *Func) Replace(old *Instr, v Value) {
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for i, arg := range instr.Args {
+				if arg == old {
+					instr.Args[i] = v
+				}
+			}
+		}
+	}
+	fn.RemoveInstrs(func(instr *Instr) bool { return instr == old })
+}
+
+// RemoveInstrs removes the instructions of fn for which remove returns
+// true, and reports whether it removed any.
+func (fn *Func) RemoveInstrs(remove func(*Instr) bool) bool {
+	removed := false
+	for _, b := range fn.Blocks {
+		instrs := b.Instrs[:0]
+		for _, instr := range b.Instrs {
+			if remove(instr) {
+				removed = true
+				continue
+			}
+			instrs = append(instrs, instr)
+		}
+		b.Instrs = instrs
+	}
+	return removed
+}
+
+// RemoveUnreachable removes the blocks of fn that cannot be reached from
+// the entry, and the operands of phis flowing in from blocks that no
+// longer branch to theirs. It updates fn.
+func (fn *Func) RemoveUnreachable() {
+	reachable := map[*Block]bool{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		reachable[b] = true
+		if term := b.Terminator(); term != nil {
+			for _, succ := range term.Blocks {
+				if !reachable[succ] {
+					visit(succ)
+				}
+			}
+		}
+	}
+	visit(fn.Blocks[0])
+	fn.Blocks = slices.DeleteFunc(fn.Blocks, func(b *Block) bool { return !reachable[b] })
+	fn.Update()
+	for _, b := range fn.Blocks {
+		for _, phi := range b.Phis() {
+			args, from := phi.Args[:0], phi.Blocks[:0]
+			for i, pred := range phi.Blocks {
+				if slices.Contains(b.Preds, pred) {
+					args, from = append(args, phi.Args[i]), append(from, pred)
+				}
+			}
+			phi.Args, phi.Blocks = args, from
+		}
+	}
+}
+
+// RemoveTrivialPhis replaces each phi whose operands are all one value, or
+// the phi itself, by that value, until none is left.
+func (fn *Func) RemoveTrivialPhis() {
+	for changed := true; changed; {
+		changed = false
+		for _, b := range fn.Blocks {
+			// Replace removes phis from the block, so iterate over a copy
+			for _, phi := range slices.Clone(b.Phis()) {
+				var same Value
+				trivial := true
+				for _, arg := range phi.Args {
+					if arg == same || arg == phi {
+						continue
+					}
+					if same != nil {
+						trivial = false
+						break
+					}
+					same = arg
+				}
+				if trivial && same != nil {
+					fn.Replace(phi, same)
+					changed = true
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,899 @@
+package ir
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+var boolType = types.Typ[types.Bool]
+
+// expr lowers expr, returning its value. Expressions whose values the
+// checker knows are lowered to those values.
+func (f *funcLowerer) expr(s *scope, expr ast.Expression) Value {
+	defer f.at(expr)()
+	if v := f.info.Values[expr]; v != nil && f.foldable(expr, v) {
+		if c := f.constValue(expr, v); c != nil {
+			return c
+		}
+	}
+	switch e := expr.(type) {
+	// literals
+	case *ast.IntegerLiteral:
+		return f.literal(e, consteval.NewInt(e.IntegerValue))
+	case *ast.FloatLiteral:
+		return f.literal(e, consteval.NewFloat(e.FloatValue))
+	case *ast.BooleanLiteral:
+		return f.constant(consteval.Bool(e.BooleanValue))
+	case *ast.StringLiteral:
+		return f.constant(consteval.String(e.StringValue))
+	case *ast.RawStringLiteral:
+		return f.constant(consteval.String(e.StringValue))
+	case *ast.SymbolLiteral:
+		return f.constant(consteval.Symbol(strings.TrimPrefix(e.Value, ":")))
+	case *ast.RuneLiteral:
+		return f.constant(&consteval.Int{Val: big.NewInt(int64(e.RuneValue)), Typ: types.Rune})
+	case *ast.InterpolatedStringLiteral:
+		return f.interpolatedString(s, e)
+	case *ast.MultiLineStringLiteral:
+		if call := f.info.Processors[e]; call != nil {
+			return f.expr(s, call)
+		}
+		return f.interpolatedString(s, e.Contents)
+	case *ast.TupleLiteral:
+		return f.tupleLiteral(s, e)
+	case *ast.ArrayLiteral:
+		return f.arrayLiteral(s, e)
+
+	// names
+	case *ast.Identifier:
+		return f.ident(s, e, e.Name)
+	case *ast.FunctionIdentifier:
+		return f.ident(s, e, e.Name)
+	case *ast.ItExpression:
+		return f.ident(s, e, "it")
+
+	// operators
+	case *ast.AddSubExpression:
+		return f.binary(s, e, e.Operator.String(), e.Left, e.Right)
+	case *ast.MulDivExpression:
+		return f.binary(s, e, e.Operator.String(), e.Left, e.Right)
+	case *ast.PowExpression:
+		typ := f.typeOf(e)
+		v := f.coerce(e, f.expr(s, e.Operands[len(e.Operands)-1]), typ)
+		for i := len(e.Operands) - 2; i >= 0; i-- {
+			v = f.op(OpPow, typ, f.coerce(e, f.expr(s, e.Operands[i]), typ), v)
+		}
+		return v
+	case *ast.RelationalComparison:
+		return f.comparison(s, e)
+	case *ast.TypeComparison:
+		return f.typeComparison(s, e)
+	case *ast.TypeofExpression:
+		if typ := f.info.Typeofs[e]; typ != nil {
+			return f.descriptor(typ)
+		}
+		if e.Expression == nil {
+			f.errorf(e, "type of %s is not known", e)
+		}
+		return f.typeofValue(e, f.expr(s, e.Expression))
+	case *ast.LogicalOrExpression:
+		return f.logical(s, e.Operands, true)
+	case *ast.LogicalAndExpression:
+		return f.logical(s, e.Operands, false)
+	case *ast.UnaryExpression:
+		return f.unary(s, e)
+
+	// control flow
+	case *ast.Block:
+		return f.block(s, e)
+	case *ast.IfExpression:
+		return f.ifExpr(s, e)
+	case *ast.SwitchExpression:
+		return f.switchExpr(s, e)
+	case *ast.ForExpression:
+		return f.forExpr(s, e)
+	case *ast.InlineForExpression:
+		inline := f.info.InlineFors[e]
+		if inline == nil {
+			f.errorf(e, "inline for loop was not unrolled")
+			return nil
+		}
+		return f.block(s, inline.Lowered)
+	case *ast.ReturnExpression:
+		return f.returnExpr(s, e)
+	case *ast.BreakExpression:
+		return f.breakExpr(s, e)
+	case *ast.ContinueExpression:
+		return f.continueExpr(s, e)
+	case *ast.TryExpression:
+		return f.try(s, e)
+
+	// calls and access
+	case *ast.FunctionCall:
+		return f.call(s, e)
+	case *ast.TypeConstructorCall:
+		return f.construct(s, e)
+	case *ast.MemberAccess:
+		return f.memberAccess(s, e)
+	case *ast.IndexedAccess:
+		return f.index(s, e, e.Object, e.Index)
+	case *ast.SafeIndexedAccess:
+		return f.index(s, e, e.Object, e.Index)
+	case *ast.TupleUpdateExpression:
+		return f.tupleUpdate(s, e)
+	case *ast.Range:
+		return f.rangeExpr(s, e)
+	case *ast.ChainedExpression:
+		pipeline := f.info.Pipelines[e]
+		if pipeline == nil {
+			f.errorf(e, "pipeline was not lowered")
+		}
+		return f.expr(s, pipeline.Lowered)
+	case *ast.FunctionBlock:
+		return f.funcBlock(s, e)
+	}
+	f.errorf(expr, "%s is not supported by the IR", expr)
+	return nil
+}
+
+// foldable reports whether expr may be lowered to its compile-time value
+// v. Where expr holds a union and v only the member it folded to, such as
+// the nil returned by a function whose result may be nil, v is not
+// foldable: expr is lowered instead, so that the union is built.
+func (f *funcLowerer) foldable(expr ast.Expression, v consteval.Value) bool {
+	typ := f.info.Types[expr]
+	if typ == nil {
+		return true
+	}
+	if typ = f.subst(typ); !holdsUnion(typ, map[types.Type]bool{}) {
+		return true
+	}
+	return v.Type() != nil && types.Identical(Canonical(v.Type()), Canonical(typ))
+}
+
+// holdsUnion reports whether values of t are or contain unions.
+func holdsUnion(t types.Type, seen map[types.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch u := t.Underlying().(type) {
+	case *types.Union:
+		return true
+	case *types.Tuple:
+		for _, field := range u.Fields {
+			if holdsUnion(field.Type, seen) {
+				return true
+			}
+		}
+	case *types.Array:
+		return holdsUnion(u.Elem, seen)
+	}
+	return false
+}
+
+// literal lowers a numeric literal to a constant of its type.
+func (f *funcLowerer) literal(e ast.Expression, v consteval.Value) Value {
+	c, err := consteval.Convert(v, f.typeOf(e))
+	if err != nil {
+		f.errorf(e, "%s", err)
+	}
+	return f.constant(c)
+}
+
+// constValue lowers the compile-time value v of node to instructions
+// building it, or returns nil if the IR has no form for it.
+func (f *funcLowerer) constValue(node ast.Node, v consteval.Value) Value {
+	switch v := v.(type) {
+	case *consteval.Int, *consteval.Float:
+		c, err := consteval.Convert(v, types.Default(v.Type()))
+		if err != nil {
+			f.errorf(node, "%s", err)
+		}
+		return f.constant(c)
+	case consteval.Bool, consteval.String, consteval.Symbol, consteval.Nil, *consteval.Enum, *consteval.ErrorValue:
+		return f.constant(v)
+	case *consteval.TypeValue:
+		return f.descriptor(v.Typ)
+	case *consteval.Tuple:
+		fields := make([]Value, len(v.Fields))
+		for i, field := range v.Fields {
+			if fields[i] = f.constValue(node, field.Value); fields[i] == nil {
+				return nil
+			}
+		}
+		typ := v.Typ
+		if typ == nil {
+			tfields := make([]*types.Field, len(fields))
+			for i, field := range v.Fields {
+				tfields[i] = types.NewField(field.Name, fields[i].Type())
+			}
+			typ = types.NewTuple(tfields...)
+		}
+		typ = Canonical(typ)
+		tuple := typ.Underlying().(*types.Tuple)
+		for i := range fields {
+			fields[i] = f.coerce(node, fields[i], tuple.Fields[i].Type)
+		}
+		return f.op(OpTuple, typ, fields...)
+	case *consteval.Array:
+		typ := Canonical(v.Typ)
+		array, ok := typ.Underlying().(*types.Array)
+		if !ok {
+			return nil
+		}
+		elems := make([]Value, len(v.Elems))
+		for i, elem := range v.Elems {
+			if elems[i] = f.constValue(node, elem); elems[i] == nil {
+				return nil
+			}
+			elems[i] = f.coerce(node, elems[i], array.Elem)
+		}
+		return f.op(OpArray, typ, elems...)
+	case *consteval.Func:
+		if fn := f.declared[v.Decl]; fn != nil {
+			return f.emit(&Instr{Op: OpFunc, Typ: fn.Sig, Callee: fn.Name})
+		}
+	}
+	return nil
+}
+
+// ident lowers a reference to name: a variable of the function, a
+// top-level function or a constant.
+func (f *funcLowerer) ident(s *scope, node ast.Node, name string) Value {
+	if v := s.lookup(name); v != nil {
+		return f.read(v)
+	}
+	obj := f.info.Scope.Lookup(name)
+	if obj != nil {
+		switch obj.Kind {
+		case check.FuncObject:
+			fn := f.function(node, obj)
+			return f.emit(&Instr{Op: OpFunc, Typ: fn.Sig, Callee: fn.Name})
+		case check.VarObject:
+			if obj.Const != nil {
+				if v := f.constValue(node, obj.Const); v != nil {
+					return v
+				}
+			}
+		}
+	}
+	f.errorf(node, "%s has no value the IR can represent", name)
+	return nil
+}
+
+// function returns the lowered top-level function declared by obj.
+func (f *funcLowerer) function(node ast.Node, obj *check.Object) *Func {
+	fn := f.declared[obj.Decl]
+	if fn == nil {
+		if sig, ok := obj.Type.(*types.Function); ok && sig.TypeParams != nil {
+			f.errorf(node, "generic function %s must be called to be instantiated", obj.Name)
+		}
+		f.errorf(node, "%s is not a function of the module", obj.Name)
+	}
+	return fn
+}
+
+// binary lowers an arithmetic or bitwise operator. A checked operator
+// yields the union of its type and error.
+func (f *funcLowerer) binary(s *scope, node ast.Node, op string, left, right ast.Expression) Value {
+	typ := f.typeOf(node)
+	x := f.expr(s, left)
+	if _, ok := x.Type().Underlying().(*types.Array); ok && op == "<<" {
+		array := x.Type().Underlying().(*types.Array)
+		return f.op(OpAppend, x.Type(), x, f.coerce(right, f.expr(s, right), array.Elem))
+	}
+	if types.IsEnum(x.Type()) && op == "-" {
+		y := f.expr(s, right)
+		return f.op(OpSub, types.Int, f.op(OpOrd, types.Int, x), f.op(OpOrd, types.Int, y))
+	}
+	if op, ok := strings.CutPrefix(op, "?"); ok {
+		if union, ok := typ.Underlying().(*types.Union); ok {
+			// the checker types a checked operation by its operands
+			typ = union.Members[0]
+			for _, member := range union.Members {
+				if !isErrorType(member) {
+					typ = member
+				}
+			}
+		}
+		x = f.coerce(left, x, typ)
+		y := f.coerce(right, f.expr(s, right), typ)
+		return f.checked(checkedOps[op], typ, x, y)
+	}
+	x = f.coerce(left, x, typ)
+	y := f.coerce(right, f.expr(s, right), typ)
+	irOp, ok := binaryOps[op]
+	if !ok {
+		f.errorf(node, "operator %s is not supported by the IR", op)
+	}
+	return f.op(irOp, typ, x, y)
+}
+
+var binaryOps = map[string]Op{
+	"+":  OpAdd,
+	"-":  OpSub,
+	"*":  OpMul,
+	"/":  OpDiv,
+	"%":  OpMod,
+	"^":  OpPow,
+	"&":  OpAnd,
+	"|":  OpOr,
+	"<<": OpShl,
+	">>": OpShr,
+}
+
+var checkedOps = map[string]Op{
+	"+": OpCheckedAdd,
+	"-": OpCheckedSub,
+	"*": OpCheckedMul,
+	"/": OpCheckedDiv,
+	"%": OpCheckedMod,
+}
+
+// checked emits the checked operation op on x and y of type typ, whose
+// value is the result wrapped in typ | error, or the error describing the
+// failure.
+func (f *funcLowerer) checked(op Op, typ types.Type, x, y Value) Value {
+	union := Canonical(types.NewUnion(typ, types.ErrorType))
+	members := union.Underlying().(*types.Union)
+	result := &variable{typ: union}
+	ok, failed, done := f.fn.NewBlock(), f.fn.NewBlock(), f.fn.NewBlock()
+	r := f.terminate(&Instr{Op: op, Typ: typ, Args: []Value{x, y}, Blocks: []*Block{ok, failed}})
+	f.seal(ok)
+	f.seal(failed)
+
+	f.start(ok)
+	f.write(result, f.wrap(r, MemberIndex(members, typ), union))
+	f.jump(done)
+
+	fail := func(msg string) {
+		err := f.constant(&consteval.ErrorValue{Msg: msg})
+		f.write(result, f.wrap(err, MemberIndex(members, types.ErrorType), union))
+		f.jump(done)
+	}
+	f.start(failed)
+	if op == OpCheckedDiv || op == OpCheckedMod {
+		zero, err := consteval.Convert(consteval.NewInt(0), typ)
+		if err != nil {
+			panic(err)
+		}
+		byZero, overflow := f.fn.NewBlock(), f.fn.NewBlock()
+		f.br(f.op(OpEq, boolType, y, f.constant(zero)), byZero, overflow)
+		f.seal(byZero)
+		f.seal(overflow)
+		f.start(byZero)
+		fail("division by zero")
+		f.start(overflow)
+	}
+	fail("integer overflow")
+
+	f.seal(done)
+	f.start(done)
+	return f.read(result)
+}
+
+var comparisonOps = map[ast.RelOp]Op{
+	ast.OpEq:      OpEq,
+	ast.OpNeq:     OpNe,
+	ast.OpLt:      OpLt,
+	ast.OpLte:     OpLe,
+	ast.OpGt:      OpGt,
+	ast.OpGte:     OpGe,
+	ast.OpCompare: OpCmp,
+}
+
+// comparison lowers a comparison. Both operands take the type of the
+// operand that is not an untyped constant; enum members are compared by
+// their values.
+func (f *funcLowerer) comparison(s *scope, e *ast.RelationalComparison) Value {
+	op, ok := comparisonOps[e.Operator]
+	if !ok {
+		f.errorf(e, "operator %s is not supported by the IR", e.Operator)
+	}
+	typ := f.info.Types[e.Left]
+	if typ == nil || types.IsUntyped(typ) {
+		typ = f.info.Types[e.Right]
+	}
+	if typ == nil {
+		f.errorf(e, "type of %s is not known", e)
+	}
+	typ = Canonical(f.subst(typ))
+	x := f.coerce(e.Left, f.expr(s, e.Left), typ)
+	y := f.coerce(e.Right, f.expr(s, e.Right), typ)
+	if types.IsEnum(typ) && op != OpEq && op != OpNe {
+		x, y = f.op(OpOrd, types.Int, x), f.op(OpOrd, types.Int, y)
+	}
+	result := types.Type(boolType)
+	if op == OpCmp {
+		result = types.Int
+	}
+	return f.op(op, result, x, y)
+}
+
+// typeComparison lowers x is T.
+func (f *funcLowerer) typeComparison(s *scope, e *ast.TypeComparison) Value {
+	ref, ok := e.Right.(*ast.TypeReference)
+	if !ok || len(ref.Identifiers) > 0 {
+		f.errorf(e, "%s is not supported by the IR", e)
+	}
+	obj := f.info.Scope.Lookup(ref.TypeIdentifier.Name)
+	if obj == nil || obj.Kind != check.TypeObject {
+		f.errorf(ref, "undefined type %s", ref)
+	}
+	return f.hasType(f.expr(s, e.Left), obj.Type)
+}
+
+// descriptor returns the descriptor of typ, the value of typeof(typ).
+func (f *funcLowerer) descriptor(typ types.Type) Value {
+	return f.constant(&consteval.TypeValue{Typ: Canonical(f.subst(typ))})
+}
+
+// typeofValue returns the descriptor of the type of v, which for a union
+// is that of the member it holds.
+func (f *funcLowerer) typeofValue(node ast.Node, v Value) Value {
+	union, ok := v.Type().Underlying().(*types.Union)
+	if !ok {
+		return f.descriptor(types.Default(v.Type()))
+	}
+	result := &variable{typ: types.Typ[types.TypeDescriptor]}
+	done := f.fn.NewBlock()
+	tag := f.op(OpTag, types.Int, v)
+	for i, member := range union.Members {
+		last := i == len(union.Members)-1
+		var els *Block
+		if !last {
+			var then *Block
+			then, els = f.branch(f.op(OpEq, boolType, tag, f.intConst(int64(i))))
+			f.start(then)
+		}
+		d := f.descriptor(member)
+		if _, ok := member.Underlying().(*types.Union); ok {
+			d = f.typeofValue(node, f.payload(v, i, member))
+		}
+		f.join(node, result, d, done)
+		if !last {
+			f.start(els)
+		}
+	}
+	return f.joined(result, done)
+}
+
+// hasType returns whether v holds a value of type typ, or of a member of
+// typ if it is a union.
+func (f *funcLowerer) hasType(v Value, typ types.Type) Value {
+	union, ok := v.Type().Underlying().(*types.Union)
+	if !ok {
+		return f.constant(consteval.Bool(hasType(v.Type(), typ)))
+	}
+	var members []int
+	for i, member := range union.Members {
+		if hasType(member, typ) {
+			members = append(members, i)
+		}
+	}
+	switch len(members) {
+	case 0:
+		return f.constant(consteval.Bool(false))
+	case len(union.Members):
+		return f.constant(consteval.Bool(true))
+	}
+	tag := f.op(OpTag, types.Int, v)
+	var result Value
+	for _, i := range members {
+		eq := f.op(OpEq, boolType, tag, f.intConst(int64(i)))
+		if result == nil {
+			result = eq
+		} else {
+			result = f.op(OpOr, boolType, result, eq)
+		}
+	}
+	return result
+}
+
+// hasType reports whether values of type t are values of typ: t is typ or
+// a member of the union typ, t is an instance of the generic type typ, or
+// t is an error type and typ is error.
+func hasType(t, typ types.Type) bool {
+	if types.Identical(t, typ) {
+		return true
+	}
+	if n, ok := t.(*types.Named); ok && n.Origin() != nil && n.Origin() == typ {
+		return true
+	}
+	if types.Identical(typ, types.ErrorType) {
+		return isErrorType(t)
+	}
+	if union, ok := typ.Underlying().(*types.Union); ok {
+		for _, member := range union.Members {
+			if hasType(t, member) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *funcLowerer) unary(s *scope, e *ast.UnaryExpression) Value {
+	typ := f.typeOf(e)
+	x := f.coerce(e.Expression, f.expr(s, e.Expression), typ)
+	switch e.Operator {
+	case ast.OpPosSign:
+		return x
+	case ast.OpNegSign:
+		return f.op(OpNeg, typ, x)
+	}
+	return f.op(OpNot, typ, x)
+}
+
+// logical lowers a chain of and or or operators, which evaluates its
+// operands until one decides the result.
+func (f *funcLowerer) logical(s *scope, operands []ast.Expression, or bool) Value {
+	result := &variable{typ: boolType}
+	done := f.fn.NewBlock()
+	for i, operand := range operands {
+		v := f.coerce(operand, f.expr(s, operand), boolType)
+		f.write(result, v)
+		if i == len(operands)-1 {
+			f.jump(done)
+			break
+		}
+		next := f.fn.NewBlock()
+		if or {
+			f.br(v, done, next)
+		} else {
+			f.br(v, next, done)
+		}
+		f.seal(next)
+		f.start(next)
+	}
+	f.seal(done)
+	f.start(done)
+	return f.read(result)
+}
+
+// concat returns the concatenation of the strings parts.
+func (f *funcLowerer) concat(parts []Value) Value {
+	if len(parts) == 0 {
+		return f.constant(consteval.String(""))
+	}
+	v := parts[0]
+	for _, part := range parts[1:] {
+		v = f.op(OpAdd, types.Typ[types.String], v, part)
+	}
+	return v
+}
+
+// text returns the text of v, as print writes it.
+func (f *funcLowerer) text(v Value) Value {
+	if types.IsString(v.Type()) {
+		return v
+	}
+	return f.op(OpStr, types.Typ[types.String], v)
+}
+
+func (f *funcLowerer) interpolatedString(s *scope, lit *ast.InterpolatedStringLiteral) Value {
+	var parts []Value
+	for _, part := range lit.Parts {
+		switch part := part.(type) {
+		case *ast.StringLiteral:
+			parts = append(parts, f.constant(consteval.String(part.StringValue)))
+		case *ast.Interpolation:
+			v := f.expr(s, part.Expression)
+			if obj := f.info.Stringers[part]; obj != nil {
+				// converted by a declared string function
+				fn := f.function(part, obj)
+				if len(fn.Sig.Params) != 1 || fn.Sig.Result == nil {
+					f.errorf(part, "%s cannot convert %s to a string", obj.Name, v.Type())
+				}
+				v = f.emit(&Instr{
+					Op:     OpCall,
+					Typ:    fn.Sig.Result,
+					Args:   []Value{f.coerce(part, v, fn.Sig.Params[0].Type)},
+					Callee: fn.Name,
+					Fx:     fn.Sig.HasSideEffects,
+				})
+			}
+			parts = append(parts, f.text(v))
+		}
+	}
+	return f.concat(parts)
+}
+
+func (f *funcLowerer) tupleLiteral(s *scope, lit *ast.TupleLiteral) Value {
+	var fields []Value
+	var names []string
+	for _, member := range lit.Members {
+		v := f.expr(s, member.Value)
+		if member.Spread {
+			tuple, ok := v.Type().Underlying().(*types.Tuple)
+			if !ok {
+				f.errorf(member.Value, "cannot spread %s: not a tuple", v.Type())
+			}
+			for i, field := range tuple.Fields {
+				fields = append(fields, f.field(v, i, field.Type))
+				names = append(names, field.Name)
+			}
+			continue
+		}
+		name := ""
+		if member.Label != nil {
+			name = member.Label.Name
+		}
+		fields = append(fields, v)
+		names = append(names, name)
+	}
+
+	typ := f.typeOf(lit)
+	tuple, ok := typ.Underlying().(*types.Tuple)
+	if !ok || len(tuple.Fields) != len(fields) {
+		tfields := make([]*types.Field, len(fields))
+		for i, field := range fields {
+			tfields[i] = types.NewField(names[i], field.Type())
+		}
+		tuple = types.NewTuple(tfields...)
+		typ = tuple
+	}
+	for i, field := range tuple.Fields {
+		fields[i] = f.coerce(lit, fields[i], field.Type)
+	}
+	return f.op(OpTuple, typ, fields...)
+}
+
+func (f *funcLowerer) arrayLiteral(s *scope, lit *ast.ArrayLiteral) Value {
+	typ := f.typeOf(lit)
+	array, ok := typ.Underlying().(*types.Array)
+	if !ok {
+		f.errorf(lit, "type of %s is not known", lit)
+	}
+	elems := make([]Value, len(lit.Elements))
+	for i, element := range lit.Elements {
+		elems[i] = f.coerce(element, f.expr(s, element), array.Elem)
+	}
+	if lit.Initializer != nil && len(lit.Elements) == 0 {
+		// each element of a fixed-size array is initialized from its index
+		init := f.funcBlock(s, lit.Initializer)
+		sig := init.Type().Underlying().(*types.Function)
+		for i := range array.Len {
+			v := f.emit(&Instr{Op: OpCall, Typ: sig.Result, Args: []Value{init, f.intConst(i)}, Fx: sig.HasSideEffects})
+			elems = append(elems, f.coerce(lit.Initializer, v, array.Elem))
+		}
+	}
+	return f.op(OpArray, typ, elems...)
+}
+
+// construct lowers a call of a tuple type, such as P(1, "b"), the
+// conversion of an integer to an enum type, such as Fruit(n), or the
+// conversion of an integer to an integer type, such as Int8(n).
+func (f *funcLowerer) construct(s *scope, e *ast.TypeConstructorCall) Value {
+	typ := f.typeOf(e)
+	if union, ok := typ.Underlying().(*types.Union); ok {
+		for _, member := range union.Members {
+			if types.IsEnum(member) {
+				return f.enumConversion(s, e, member, typ)
+			}
+		}
+	}
+	if types.IsInteger(typ) {
+		return f.conversion(s, e, typ)
+	}
+	tuple, ok := typ.Underlying().(*types.Tuple)
+	if !ok {
+		f.errorf(e, "construction of %s is not supported by the IR", typ)
+	}
+	fields := make([]Value, len(tuple.Fields))
+	assign := func(node ast.Node, i int, expr ast.Expression) {
+		fields[i] = f.coerce(node, f.expr(s, expr), tuple.Fields[i].Type)
+	}
+	if args := e.Arguments; args != nil {
+		if args.Args != nil {
+			for i, arg := range args.Args.Args {
+				if arg.Spread || i >= len(fields) {
+					f.errorf(arg, "too many arguments in construction of %s", typ)
+				}
+				assign(arg, i, arg.Expr)
+			}
+		}
+		if args.LabeledArgs != nil {
+			for _, arg := range args.LabeledArgs.Args {
+				i := tuple.FieldIndex(arg.Identifier.Name)
+				if i < 0 {
+					f.errorf(arg.Identifier, "%s has no field %s", typ, arg.Identifier.Name)
+				}
+				assign(arg, i, arg.Argument.Expr)
+			}
+		}
+	}
+	for i, field := range fields {
+		if field == nil {
+			f.errorf(e, "missing field %s in construction of %s", tuple.Fields[i].Name, typ)
+		}
+	}
+	return f.op(OpTuple, typ, fields...)
+}
+
+// enumConversion lowers the conversion of an integer to the enum type
+// enum, whose value, of type typ, is the member with the integer's value,
+// or nil if there is none.
+func (f *funcLowerer) enumConversion(s *scope, e *ast.TypeConstructorCall, enum, typ types.Type) Value {
+	if e.Arguments == nil || e.Arguments.Args == nil || len(e.Arguments.Args.Args) != 1 {
+		f.errorf(e, "conversion to %s requires a single Int argument", enum)
+	}
+	arg := e.Arguments.Args.Args[0].Expr
+	n := f.expr(s, arg)
+	if !types.IsInteger(n.Type()) {
+		n = f.coerce(arg, n, types.Int)
+	}
+	result := &variable{typ: typ}
+	done := f.fn.NewBlock()
+	for _, member := range enum.Underlying().(*types.Enum).Members {
+		value, err := consteval.Convert(consteval.NewInt(member.Value), n.Type())
+		if err != nil {
+			// no value of the integer's type is the member's
+			continue
+		}
+		then, els := f.branch(f.op(OpEq, boolType, n, f.constant(value)))
+		f.start(then)
+		f.join(e, result, f.constant(&consteval.Enum{Typ: enum, Member: member}), done)
+		f.start(els)
+	}
+	f.join(e, result, f.nilValue(), done)
+	return f.joined(result, done)
+}
+
+// conversion lowers the conversion of an integer to the integer type
+// typ, which traps if the value does not fit. Constants are converted
+// here.
+func (f *funcLowerer) conversion(s *scope, e *ast.TypeConstructorCall, typ types.Type) Value {
+	if e.Arguments == nil || e.Arguments.Args == nil || len(e.Arguments.Args.Args) != 1 {
+		f.errorf(e, "conversion to %s requires a single integer argument", typ)
+	}
+	arg := e.Arguments.Args.Args[0].Expr
+	v := f.expr(s, arg)
+	if instr, ok := v.(*Instr); ok && instr.Op == OpConst || types.Identical(v.Type(), typ) {
+		return f.coerce(arg, v, typ)
+	}
+	return f.op(OpConv, typ, v)
+}
+
+func (f *funcLowerer) memberAccess(s *scope, e *ast.MemberAccess) Value {
+	if _, ok := e.Object.(*ast.TypeIdentifier); ok {
+		// a member of an enum type, such as Fruit.apple
+		typ := f.typeOf(e)
+		if enum, ok := typ.Underlying().(*types.Enum); ok {
+			if member, ok := e.Member.(*ast.Identifier); ok && enum.Member(member.Name) != nil {
+				return f.constant(&consteval.Enum{Typ: typ, Member: enum.Member(member.Name)})
+			}
+		}
+		f.errorf(e, "%s is not supported by the IR", e)
+	}
+	object, ok := e.Object.(ast.Expression)
+	if !ok {
+		f.errorf(e, "%s is not supported by the IR", e)
+	}
+	v := f.expr(s, object)
+	tuple, ok := v.Type().Underlying().(*types.Tuple)
+	if !ok {
+		f.errorf(e.Member, "%s has no field %s", v.Type(), e.Member)
+	}
+	i := -1
+	switch member := e.Member.(type) {
+	case *ast.Identifier:
+		i = tuple.FieldIndex(member.Name)
+	case *ast.IntegerLiteral:
+		if member.IntegerValue < int64(len(tuple.Fields)) {
+			i = int(member.IntegerValue)
+		}
+	}
+	if i < 0 {
+		f.errorf(e.Member, "%s has no field %s", v.Type(), e.Member)
+	}
+	return f.field(v, i, tuple.Fields[i].Type)
+}
+
+func (f *funcLowerer) index(s *scope, node ast.Node, object, index ast.Expression) Value {
+	if r, ok := index.(*ast.Range); ok {
+		return f.slice(s, node, object, r)
+	}
+	x := f.expr(s, object)
+	if tuple, ok := x.Type().Underlying().(*types.Tuple); ok {
+		// tuples are indexed by constants
+		if n, ok := f.info.Values[index].(*consteval.Int); ok {
+			if i, ok := n.Int64(); ok && i >= 0 && i < int64(len(tuple.Fields)) {
+				return f.field(x, int(i), tuple.Fields[i].Type)
+			}
+		}
+		f.errorf(index, "invalid index %s of %s", index, x.Type())
+	}
+	i := f.expr(s, index)
+	if !types.IsInteger(i.Type()) {
+		i = f.coerce(index, i, types.Int)
+	}
+	var elem types.Type = types.Byte
+	if array, ok := x.Type().Underlying().(*types.Array); ok {
+		elem = array.Elem
+	} else if !types.IsString(x.Type()) {
+		f.errorf(node, "cannot index %s", x.Type())
+	}
+	return f.op(OpIndex, elem, x, i)
+}
+
+// slice lowers the slice object[lo..hi] of an array or string.
+func (f *funcLowerer) slice(s *scope, node ast.Node, object ast.Expression, index *ast.Range) Value {
+	x := f.expr(s, object)
+	// a missing bound is that of the whole of x
+	var lo, hi Value = f.intConst(0), nil
+	if index.StartBound != nil {
+		lo = f.sliceBound(s, index.StartBound)
+	}
+	if index.EndBound != nil {
+		hi = f.sliceBound(s, index.EndBound)
+	} else {
+		hi = f.op(OpSub, types.Int, f.op(OpLen, types.Int, x), f.intConst(1))
+	}
+	return f.op(OpSlice, f.typeOf(node), x, lo, hi)
+}
+
+// sliceBound returns the value of the bound b of a slice, an integer.
+func (f *funcLowerer) sliceBound(s *scope, b *ast.RangeBound) Value {
+	v := f.expr(s, b.Value)
+	if !types.IsInteger(v.Type()) {
+		v = f.coerce(b, v, types.Int)
+	}
+	return v
+}
+
+func (f *funcLowerer) tupleUpdate(s *scope, e *ast.TupleUpdateExpression) Value {
+	v := f.expr(s, e.Object)
+	tuple, ok := v.Type().Underlying().(*types.Tuple)
+	if !ok {
+		f.errorf(e.Object, "cannot update %s: not a tuple", v.Type())
+	}
+	// the fields not updated are shared with the tuple updated
+	for _, member := range e.Update.Members {
+		if member.Label == nil {
+			f.errorf(member, "tuple update requires labeled fields")
+		}
+		i := tuple.FieldIndex(member.Label.Name)
+		if i < 0 {
+			f.errorf(member.Label, "%s has no field %s", v.Type(), member.Label.Name)
+		}
+		x := f.coerce(member.Value, f.expr(s, member.Value), tuple.Fields[i].Type)
+		v = f.emit(&Instr{Op: OpUpdate, Typ: v.Type(), Args: []Value{v, x}, Index: i})
+	}
+	return v
+}
+
+// rangeExpr lowers a range lo..hi to a value of the core Range type. A
+// missing bound of a range of integers is the smallest or largest value
+// of their type.
+func (f *funcLowerer) rangeExpr(s *scope, e *ast.Range) Value {
+	typ := f.typeOf(e)
+	tuple, ok := typ.Underlying().(*types.Tuple)
+	if !ok {
+		f.errorf(e, "%s is not a range type", typ)
+	}
+	var bounds []Value
+	for i, bound := range []*ast.RangeBound{e.StartBound, e.EndBound} {
+		elem := tuple.Fields[i].Type
+		if bound != nil {
+			bounds = append(bounds, f.coerce(bound, f.expr(s, bound.Value), elem))
+			continue
+		}
+		min, max, ok := consteval.Bounds(elem)
+		if !ok {
+			f.errorf(e, "open range of %s: only ranges of integers may omit a bound", elem)
+		}
+		if i == 0 {
+			bounds = append(bounds, f.constant(min))
+		} else {
+			bounds = append(bounds, f.constant(max))
+		}
+	}
+	return f.op(OpTuple, typ, bounds...)
+}
@@ -0,0 +1,86 @@
+package ir
+
+import (
+	"math/big"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// operators holds the operators of package consteval that the arithmetic
+// and comparison operations perform.
+var operators = map[Op]string{
+	OpAdd: "+",
+	OpSub: "-",
+	OpMul: "*",
+	OpDiv: "/",
+	OpMod: "%",
+	OpPow: "^",
+	OpAnd: "&",
+	OpOr:  "|",
+	OpShl: "<<",
+	OpShr: ">>",
+	OpEq:  "==",
+	OpNe:  "!=",
+	OpLt:  "<",
+	OpLe:  "<=",
+	OpGt:  ">",
+	OpGe:  ">=",
+}
+
+// Fold returns the value of the operation op with result type typ on the
+// constants args. It returns an error if the operation traps, or for a
+// checked operation, overflows or divides by zero, and nil if op is not an
+// operation on constants it folds.
+func Fold(op Op, typ types.Type, args ...consteval.Value) (consteval.Value, error) {
+	op = op.Unchecked()
+	switch {
+	case op.IsBinary():
+		x, y := args[0], args[1]
+		if types.IsBool(typ) {
+			// the logical operations, which consteval leaves to the
+			// evaluation of && and ||
+			a, b := bool(x.(consteval.Bool)), bool(y.(consteval.Bool))
+			switch op {
+			case OpAnd:
+				return consteval.Bool(a && b), nil
+			case OpOr:
+				return consteval.Bool(a || b), nil
+			case OpXor:
+				return consteval.Bool(a != b), nil
+			}
+			return nil, nil
+		}
+		operator, ok := operators[op]
+		if !ok {
+			return nil, nil
+		}
+		return consteval.Binary(operator, x, y)
+	case op == OpNeg:
+		return consteval.Unary("-", args[0])
+	case op == OpNot:
+		if types.IsBool(typ) {
+			return consteval.Unary("!", args[0])
+		}
+		return consteval.Unary("~", args[0])
+	case op == OpCmp:
+		cmp, err := consteval.Compare(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &consteval.Int{Val: big.NewInt(int64(cmp)), Typ: types.Int}, nil
+	case op.IsComparison():
+		return consteval.Relation(operators[op], args[0], args[1])
+	case op == OpOrd:
+		if enum, ok := args[0].(*consteval.Enum); ok {
+			return &consteval.Int{Val: big.NewInt(enum.Member.Value), Typ: types.Int}, nil
+		}
+	case op == OpConv:
+		return consteval.Convert(args[0], typ)
+	case op == OpLen:
+		if s, ok := args[0].(consteval.String); ok {
+			return &consteval.Int{Val: big.NewInt(int64(len(s))), Typ: types.Int}, nil
+		}
+	}
+	return nil, nil
+}
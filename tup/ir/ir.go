@@ -0,0 +1,394 @@
+// Package ir defines a typed intermediate representation of Tuppence
+// programs in static single assignment form, the lowering of checked
+// modules into it, a textual format for it and a verifier.
+//
+// A function is a list of basic blocks, the first of which is its entry.
+// Each block is a list of instructions ending with a single terminator,
+// which transfers control to the block's successors or leaves the
+// function. Every value is defined once, by a parameter or an instruction,
+// and the definition of a value dominates its uses; phi instructions at
+// the start of a block select among the values flowing in from its
+// predecessors, which is how the state of for loops is carried from one
+// iteration to the next.
+//
+// Values have the types of package types. Tuples and arrays are built and
+// taken apart by aggregate instructions; unions are built by wrap and
+// taken apart by tag, which yields the index of the member held, and
+// payload. Arithmetic traps on overflow and division by zero, except for
+// the checked operators, which branch to an overflow block instead. Calls
+// carry the effect of the function called: fn functions have no side
+// effects, so a call of one may be removed, repeated or moved. Closures
+// are functions of the module that take the values they capture ahead of
+// their parameters, and are built by func from those values.
+//
+// Generic functions are lowered as an instance for each list of type
+// arguments they are called with, except that LowerGeneric keeps those
+// that need no instances generic, for targets with type parameters of
+// their own.
+package ir
+
+import (
+	"strconv"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Module is a lowered module.
+type Module struct {
+	Name string
+	// Funcs holds the functions of the module, and the declarations of the
+	// host functions they call, which have no blocks.
+	Funcs []*Func
+}
+
+// Func returns the function named name, or nil.
+func (m *Module) Func(name string) *Func {
+	for _, fn := range m.Funcs {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+// Func is a function of a module.
+type Func struct {
+	Name   string
+	Sig    *types.Function
+	Params []*Param
+	// Blocks holds the blocks of the function, the entry first. It is nil
+	// for the declaration of a host function.
+	Blocks []*Block
+	// Export is set for the functions exported by the module.
+	Export bool
+	// TypeParams holds the type parameters of a generic function, which
+	// only LowerGeneric keeps; the types of its values may mention them.
+	TypeParams []*types.TypeParam
+}
+
+// Extern reports whether fn is the declaration of a host function.
+func (fn *Func) Extern() bool { return fn.Blocks == nil }
+
+// NewBlock appends a new empty block to fn.
+func (fn *Func) NewBlock() *Block {
+	b := &Block{Index: len(fn.Blocks), Func: fn}
+	fn.Blocks = append(fn.Blocks, b)
+	return b
+}
+
+// Update numbers the blocks of fn in order and the values they define in
+// order of definition, and recomputes the predecessors and successors of
+// each block from the terminators. Passes that add or remove blocks or
+// change terminators call it before relying on either.
+func (fn *Func) Update() {
+	id := 0
+	for i, b := range fn.Blocks {
+		b.Index = i
+		b.Func = fn
+		b.Preds, b.Succs = nil, nil
+		for _, instr := range b.Instrs {
+			instr.Block = b
+			if instr.Typ != nil {
+				instr.ID = id
+				id++
+			}
+		}
+	}
+	for _, b := range fn.Blocks {
+		if term := b.Terminator(); term != nil {
+			for _, succ := range term.Blocks {
+				b.Succs = append(b.Succs, succ)
+				succ.Preds = append(succ.Preds, b)
+			}
+		}
+	}
+}
+
+// Param is a parameter of a function.
+type Param struct {
+	Name string
+	Typ  types.Type
+}
+
+func (p *Param) Type() types.Type { return p.Typ }
+func (p *Param) String() string   { return "%" + p.Name }
+
+// Block is a basic block.
+type Block struct {
+	Index  int
+	Instrs []*Instr
+	// Preds and Succs hold the blocks control flows in from and out to, as
+	// computed by Func.Update.
+	Preds, Succs []*Block
+	Func         *Func
+}
+
+func (b *Block) String() string { return "b" + strconv.Itoa(b.Index) }
+
+// Terminator returns the last instruction of b if it is a terminator, or
+// nil.
+func (b *Block) Terminator() *Instr {
+	if len(b.Instrs) == 0 {
+		return nil
+	}
+	if last := b.Instrs[len(b.Instrs)-1]; last.Op.IsTerminator() {
+		return last
+	}
+	return nil
+}
+
+// Phis returns the phi instructions at the start of b.
+func (b *Block) Phis() []*Instr {
+	n := 0
+	for n < len(b.Instrs) && b.Instrs[n].Op == OpPhi {
+		n++
+	}
+	return b.Instrs[:n]
+}
+
+// Value is the value of a parameter or instruction.
+type Value interface {
+	Type() types.Type
+	// String returns the name of the value as it is written as an operand.
+	String() string
+}
+
+// Instr is an instruction.
+type Instr struct {
+	Op Op
+	// ID numbers the value defined by the instruction within its function.
+	ID int
+	// Typ is the type of the value the instruction defines, or nil if it
+	// defines none.
+	Typ  types.Type
+	Args []Value
+	// Blocks holds the successors of a terminator, or the predecessors from
+	// which the arguments of a phi flow in, one per argument.
+	Blocks []*Block
+	// Index is the field of field and update, and the member of wrap and
+	// payload.
+	Index int
+	// Const is the value of const.
+	Const consteval.Value
+	// Callee is the function named by func and by direct calls. The
+	// function of a closure takes the values it captures, the Args of
+	// func, ahead of the parameters of the closure.
+	Callee string
+	// TypeArgs holds the type arguments of direct calls of generic
+	// functions, one for each of their type parameters.
+	TypeArgs []types.Type
+	// Rec is set for the closures of recursive local functions, which
+	// capture themselves after their Args.
+	Rec bool
+	// Fx is set for calls of functions with side effects.
+	Fx bool
+	// Msg is the message of trap.
+	Msg string
+	// Stack is set for tuples and updates that do not outlive the call of
+	// their function, which may be kept in its frame rather than
+	// allocated.
+	Stack bool
+	// Pos is the position of the source the instruction was lowered from,
+	// if it is known.
+	Pos   ast.Position
+	Block *Block
+}
+
+func (instr *Instr) Type() types.Type { return instr.Typ }
+func (instr *Instr) String() string   { return "%" + strconv.Itoa(instr.ID) }
+
+// Op identifies the operation of an instruction.
+type Op int
+
+const (
+	OpInvalid Op = iota
+
+	// values
+	OpConst // the constant Const
+	OpFunc  // the function Callee, a closure over Args if it has any
+	OpUndef // an unspecified value, where a variable has none
+
+	// arithmetic on Args[0] and Args[1], which have the type of the result;
+	// integer overflow and division by zero trap. add concatenates strings;
+	// and, or and xor apply to Bool as well as integers.
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpPow
+	OpAnd
+	OpOr
+	OpXor
+	OpShl
+	OpShr
+	OpNeg // -Args[0]
+	OpNot // !Args[0] for Bool, ~Args[0] for integers
+
+	// comparisons of Args[0] and Args[1], which have the same type; eq and
+	// ne compare values of any type, the others numbers and strings. cmp
+	// yields -1, 0 or 1.
+	OpEq
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+	OpCmp
+
+	// conversions
+	OpStr  // the text of Args[0], as print writes it
+	OpOrd  // the value of the enum member Args[0]
+	OpConv // the integer Args[0] as a value of the integer type of the result; traps if it does not fit
+
+	// aggregates
+	OpTuple  // a tuple of Args
+	OpField  // field Index of the tuple Args[0]
+	OpUpdate // the tuple Args[0] with field Index replaced by Args[1]
+	OpArray  // an array of Args
+	OpIndex  // element Args[1] of the array, or byte of the string, Args[0]; traps when out of range
+	OpLen    // the length of the array or string Args[0]
+	OpAppend // the array Args[0] with Args[1] appended
+	OpSlice  // elements Args[1] through Args[2] of the array or string Args[0], as a dynamic array or string; traps when out of range
+
+	// unions
+	OpWrap    // a union holding Args[0] as member Index
+	OpTag     // the index of the member held by the union Args[0]
+	OpPayload // the value of member Index held by the union Args[0]; traps if it holds another
+
+	// OpCall calls the function Callee, or the function value Args[0] if
+	// Callee is empty, with the remaining Args.
+	OpCall
+	// OpPhi selects Args[i] when control flows in from Blocks[i].
+	OpPhi
+
+	// terminators
+	OpJump // jump to Blocks[0]
+	OpBr   // branch to Blocks[0] if Args[0] is true, else to Blocks[1]
+	OpRet  // return Args[0], or nothing
+	OpTrap // stop the program with the message Msg
+
+	// checked arithmetic: terminators that define the result of the
+	// operation on Args[0] and Args[1] and continue with Blocks[0], or with
+	// Blocks[1] if the result overflows or divides by zero. The result may
+	// only be used in blocks dominated by Blocks[0], whose only predecessor
+	// is the instruction's block.
+	OpCheckedAdd
+	OpCheckedSub
+	OpCheckedMul
+	OpCheckedDiv
+	OpCheckedMod
+)
+
+var opNames = [...]string{
+	OpInvalid:    "invalid",
+	OpConst:      "const",
+	OpFunc:       "func",
+	OpUndef:      "undef",
+	OpAdd:        "add",
+	OpSub:        "sub",
+	OpMul:        "mul",
+	OpDiv:        "div",
+	OpMod:        "mod",
+	OpPow:        "pow",
+	OpAnd:        "and",
+	OpOr:         "or",
+	OpXor:        "xor",
+	OpShl:        "shl",
+	OpShr:        "shr",
+	OpNeg:        "neg",
+	OpNot:        "not",
+	OpEq:         "eq",
+	OpNe:         "ne",
+	OpLt:         "lt",
+	OpLe:         "le",
+	OpGt:         "gt",
+	OpGe:         "ge",
+	OpCmp:        "cmp",
+	OpStr:        "str",
+	OpOrd:        "ord",
+	OpConv:       "conv",
+	OpTuple:      "tuple",
+	OpField:      "field",
+	OpUpdate:     "update",
+	OpArray:      "array",
+	OpIndex:      "index",
+	OpLen:        "len",
+	OpAppend:     "append",
+	OpSlice:      "slice",
+	OpWrap:       "wrap",
+	OpTag:        "tag",
+	OpPayload:    "payload",
+	OpCall:       "call",
+	OpPhi:        "phi",
+	OpJump:       "jump",
+	OpBr:         "br",
+	OpRet:        "ret",
+	OpTrap:       "trap",
+	OpCheckedAdd: "add.checked",
+	OpCheckedSub: "sub.checked",
+	OpCheckedMul: "mul.checked",
+	OpCheckedDiv: "div.checked",
+	OpCheckedMod: "mod.checked",
+}
+
+func (op Op) String() string {
+	if op >= 0 && int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return "op" + strconv.Itoa(int(op))
+}
+
+// IsTerminator reports whether op ends a block.
+func (op Op) IsTerminator() bool {
+	return op >= OpJump
+}
+
+// IsChecked reports whether op is a checked arithmetic operation.
+func (op Op) IsChecked() bool {
+	return op >= OpCheckedAdd
+}
+
+// IsBinary reports whether op combines two operands of the result's type.
+func (op Op) IsBinary() bool {
+	return op >= OpAdd && op <= OpShr
+}
+
+// IsComparison reports whether op compares two operands.
+func (op Op) IsComparison() bool {
+	return op >= OpEq && op <= OpCmp
+}
+
+// Unchecked returns the arithmetic operation a checked operation performs.
+func (op Op) Unchecked() Op {
+	switch op {
+	case OpCheckedAdd:
+		return OpAdd
+	case OpCheckedSub:
+		return OpSub
+	case OpCheckedMul:
+		return OpMul
+	case OpCheckedDiv:
+		return OpDiv
+	case OpCheckedMod:
+		return OpMod
+	}
+	return op
+}
+
+// HasSideEffects reports whether instr has an effect other than defining
+// its value, so that it may not be removed when the value is unused:
+// calls of fx functions, and operations that may trap.
+func (instr *Instr) HasSideEffects() bool {
+	switch instr.Op {
+	case OpCall:
+		return instr.Fx
+	case OpAdd, OpSub, OpMul, OpPow, OpNeg, OpShl:
+		return types.IsInteger(instr.Typ)
+	case OpDiv, OpMod, OpIndex, OpSlice, OpPayload, OpConv:
+		return true
+	}
+	return instr.Op.IsTerminator()
+}
@@ -0,0 +1,471 @@
+package ir
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+)
+
+const updateIRGoldensEnv = "UPDATE_IR_GOLDENS"
+
+// lower checks input and lowers it to the IR.
+func lower(t *testing.T, input string) (*Module, error) {
+	t.Helper()
+	return lowerFile(t, "test.tup", input)
+}
+
+func lowerFile(t *testing.T, filename, input string) (*Module, error) {
+	t.Helper()
+	return Lower(checkFile(t, filename, input))
+}
+
+// lowerGeneric checks input and lowers it to the IR, keeping generic the
+// generic functions that need no instances.
+func lowerGeneric(t *testing.T, input string) (*Module, error) {
+	t.Helper()
+	return LowerGeneric(checkFile(t, "test.tup", input))
+}
+
+func checkFile(t *testing.T, filename, input string) (*ast.Module, *check.Info) {
+	t.Helper()
+	module, err := parse.Module(source.NewSource([]byte(input), filename), ast.NewModule(filename))
+	if err != nil {
+		t.Fatalf("parse.Module(%q) = %v", input, err)
+	}
+	info, err := check.Module(module)
+	if err != nil {
+		t.Fatalf("check.Module(%q) = %v", input, err)
+	}
+	return module, info
+}
+
+const errorDecls = "E1 = error(message: String)\n" +
+	"E2 = error(code: Int)\n" +
+	"classify = fn(n: Int) !Int {\n\tif n < 0 { E1(\"negative\") } else if n == 0 { E2(0) } else { n }\n}\n"
+
+// listDecls declares a generic list and functions over it.
+const listDecls = "Cons[a] = type(head: a, tail: List[a])\n" +
+	"List[a] = Nil | Cons[a]\n" +
+	"prepend[a]: fn(list: List[a], head: a) List[a] { Cons(head: head, tail: list) }\n" +
+	"length[a]: fn(list: List[a]) Int {\n\tswitch list {\n\t\tNil { 0 }\n\t\tCons { |c| 1 + length(c.tail) }\n\t}\n}\n" +
+	"map[a, b]: fn(list: List[a], f: fn(a) b) List[b] {\n\tswitch list {\n\t\tNil { nil }\n\t\tCons { |c| Cons(head: f(c.head), tail: map(c.tail, f)) }\n\t}\n}\n" +
+	"double: fn(x: Int) Int { x * 2 }\n"
+
+// TestLower lowers each input, verifies the module and checks that its
+// text parses back to a module with the same text.
+func TestLower(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string // substrings of the text of the module
+	}{
+		{"arithmetic", "f = fn(a: Int, b: Int) Int { a + b * 2 - a / b % 3 }", []string{"mul Int %b", "div Int %a, %b"}},
+		{"constant", "k = 40 + 2\nf = fn() Int { k }", []string{"const Int 42"}},
+		{"call", "double = fn(n: Int) Int { n * 2 }\nf = fn() Int { double(21) }", []string{"call fn Int @double"}},
+		{"method call syntax", "double = fn(n: Int) Int { n * 2 }\nf = fn(n: Int) Int { n.double() }", []string{"call fn Int @double(%n)"}},
+		{"default", "add = fn(a: Int, b: 1) Int { a + b }\nf = fn() Int { add(41) }", []string{"@add(%0, %1)"}},
+		{"labeled", "sub = fn(a: Int, b: Int) Int { a - b }\nf = fn() Int { sub(b: 1, a: 43) }", nil},
+		{"rest", "sum = fn(ns: ...Int) Int { for s = 0; n in ns { s + n } }\nf = fn() Int { sum(1, 2, 3) }", []string{"array []Int", "phi Int"}},
+		{"recursion", "fact = fn(n: Int) Int { if n <= 1 { 1 } else { n * fact(n - 1) } }", []string{"call fn Int @fact"}},
+		{"function value", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\ninc = fn(n: Int) Int { n + 1 }\ng = fn() Int { apply(1, inc) }", []string{"call fn Int %f(%v)", "func fn(n: Int) Int @inc"}},
+		{"pipeline", "double = fn(n: Int) Int { n * 2 }\ninc = fn(n: Int) Int { n + 1 }\nf = fn(n: Int) Int { n |> inc() |> double() }", nil},
+		{"tuple", "Point = type(x: Int, y: Int)\nf = fn(p: Point) Int { p.y }", []string{"field Int %p, 1"}},
+		{"tuple update", "Point = type(x: Int, y: Int)\nf = fn(p: Point) Point { p.(y: 3) }", []string{"update Point %p, %0, 1"}},
+		{"array", "f = fn(xs: []Int, i: Int) Int { xs[i] }", []string{"index Int %xs, %i"}},
+		{"array append", "f = fn(xs: []Int) []Int { xs << 3 }", []string{"append []Int %xs"}},
+		{"array initializer", "f = fn(k: Int) [2]Int { [2]Int{ |i| i * k } }", []string{"func fn(Int) Int @f$1(%k)", "call fn Int %0(%", "array [2]Int %"}},
+		{"slice", "f = fn(xs: [3]Int, i: Int) []Int { xs[i..2] }", []string{"slice []Int %xs, %i, %"}},
+		{"slice of string", "f = fn(s: String) String { s[1..2] }", []string{"slice String %s, %"}},
+		{"len", "f = fn(xs: []Int, s: String) Int { len(xs) + len(s) }", []string{"len Int %xs", "len Int %s"}},
+		{"if", "f = fn(n: Int) String { if n < 0 { \"neg\" } else { \"pos\" } }", []string{"phi String"}},
+		{"interpolation", "f = fn(n: Int) String { \"n = \\(n)\" }", []string{"str String %n"}},
+		{"logical", "f = fn(a: Bool, b: Bool) Bool { a && b || !a }", []string{"not Bool %a", "phi Bool"}},
+
+		{"for with condition", "f = fn() Int { for i = 0; i < 10 { i + 1 } }", []string{"phi Int", "lt Bool"}},
+		{"for with step", "f = fn() Int { for i = 0; i < 10; i + 2 {} }", nil},
+		{"for with break", "f = fn() Int { for i = 0 { if i == 5 { break i * 2 }\ni + 1 } }", nil},
+		{"for in range", "f = fn() Int { for sum = 0; n in 1..4 { sum + n } }", nil},
+		{"for with continue", "f = fn() Int { for sum = 0; n in 1..10 { if n % 2 == 0 { continue }\nsum + n } }", nil},
+		{"for with tuple state", "f = fn() Int { for (a, b) = (0, 1); a < 50 {\n\t(b, a + b)\n}.0 }", nil},
+		{"mutable binding", "f = fx() Int {\n\tn = mut 1\n\tn += 41\n\tn\n}", []string{"add Int"}},
+		{"return", "f = fn(n: Int) Int {\n\tif n < 0 { return 0 }\n\tn\n}", nil},
+
+		{"switch value", "f = fn(n: Int) String { switch n { 1 { \"one\" } 2 { \"two\" } else { \"many\" } } }", []string{"eq Bool %n"}},
+		{"switch range", "f = fn(n: Int) String { switch n { 0..9 { \"single\" } 10..99 { \"double\" } else { \"lots\" } } }", []string{"le Bool"}},
+		{"switch tuple", "Pair = type(Int, Int)\nf = fn(p: Pair) Int { switch p { (0, 0) { 0 } (_, _) { |x, y| x + y } } }", []string{"type Pair = (Int, Int)"}},
+		{"switch array rest", "f = fn(xs: []Int) Int { switch xs { [] { 0 } [_, ...] { |head, ...tail| head + len(tail) } } }", []string{"sub Int", "slice []Int %xs, %"}},
+		{"switch union", "f = fn(v: Int | String) String { switch v { Int { \"int\" } String { it } } }", []string{"tag Int %v", "payload String %v, 1"}},
+		{"switch arms of different tuples", "Cons = type(head: Int, tail: List)\nList = Nil | Cons\nf = fn(l: List, e: List) List {\n\tfor current, acc = (l, e); current != nil {\n\t\tswitch current {\n\t\t\tCons { |c|\n\t\t\t\tnext = (c.tail, Cons(head: c.head, tail: acc))\n\t\t\t\tnext\n\t\t\t}\n\t\t\tNil { (current, acc) }\n\t\t}\n\t}.1\n}",
+			[]string{"payload (List, Cons) %", "wrap List %"}},
+		{"union as member", "Cons = type(head: Int, tail: List)\nList = Nil | Cons\nCL = Cons | List\nf = fn(l: List) CL { l }", []string{"wrap CL %l, 1"}},
+		{"union with the wanted type as member", "Cons = type(head: Int, tail: List)\nList = Nil | Cons\nCL = Cons | List\nf = fn(l: CL) List { l }", []string{"tag Int %l", "payload Cons %l, 0", "payload List %l, 1"}},
+		{"folded union", "Maybe = Int | Nil\nnone = fn() Maybe { nil }\nf = fn() (Maybe, Int) {\n\tp = (none(), 1)\n\tp\n}", []string{"call fn Maybe @none()", "tuple (Maybe, Int) %0, %1"}},
+		{"switch else", "f = fn(v: Int | String | Nil) Int {\n\tswitch v {\n\t\tNil { 0 }\n\t\tString { 1 }\n\t\telse { |n| n + 1 }\n\t}\n}", []string{"payload Int %v, 0"}},
+		{"switch else of several members", "Value = Int | String\nf = fn(v: Int | String | Nil) Value {\n\tswitch v {\n\t\tNil { 0 }\n\t\telse { it }\n\t}\n}", []string{"wrap Value"}},
+		{"switch without match", "Result = String | Nil\nf = fn(n: Int) Result { switch n { 1 { \"one\" } } }", []string{"wrap"}},
+
+		{"enum", "Color = enum(\n\tred\n\tgreen\n\tblue\n)\nf = fn(c: Color) Int { c.int() }", []string{"ord Int %c"}},
+		{"enum string", "Color = enum(\n\tred\n\tgreen\n\tblue\n)\nf = fn(c: Color) String { c.string() }", []string{"const String \"green\""}},
+		{"inline for", "ABC = type(a: Int, b: String)\nf = fn(abc: ABC) String {\n\tinline for acc = \"\"; name, value in abc {\n\t\tswitch name {\n\t\t\t:a { acc + \"a=\\(value) \" }\n\t\t\t:b { acc + \"b=\\(value)\" }\n\t\t}\n\t}\n}",
+			[]string{"field Int %abc, 0", "field String %abc, 1"}},
+		{"for in enum", "Color = enum(\n\tred\n\tgreen\n\tblue\n)\nf = fn() Int { for n = 0; c in Color { n + c.int() } }", nil},
+		{"conversion", "f = fn(n: Int) Int8 { Int8(n) + Int8(1) }", []string{"conv Int8 %n", "const Int8 1"}},
+		{"enum conversion", "Fruit = enum(\n\tapple = 1\n\tcherry = 300\n)\nMaybe = Fruit | Nil\nf = fn(n: UInt8) Maybe { Fruit(n) }",
+			[]string{"eq Bool %n, %", "const Fruit Fruit.apple", "wrap Maybe", "phi Maybe"}},
+		{"typeof", "Circle = type(r: Int)\nSquare = type(s: Int)\nShape = Circle | Square\nf = fn(s: Shape) Bool { typeof(s) == typeof(Circle) }",
+			[]string{"tag Int %s", "const Type typeof(Square)", "phi Type", "eq Bool %"}},
+		{"typeof of a type", "f = fn() Type { typeof(Int) }", []string{"const Type typeof(Int)"}},
+
+		{"checked add", "f = fn(a: Int8, b: Int8) Int8 | error { a ?+ b }", []string{"add.checked Int8 %a, %b, b1, b2", "const error error(\"integer overflow\")"}},
+		{"checked div", "f = fn(a: Int, b: Int) !Int { a ?/ b }", []string{"div.checked Int %a, %b", "error(\"division by zero\")"}},
+
+		{"print", "main = fx() { print(1, \"a\", [1, 2], (x: 1)) }", []string{"declare fx @print(String)", "call fx @print"}},
+		{"try", errorDecls + "f = fn(n: Int) !Int {\n\tv = try classify(n)\n\tv + 1\n}", []string{"call fn E1 | E2 | Int @classify(%n)"}},
+		{"try_continue", errorDecls + "f = fn() Int {\n\tfor acc = 0; n in [1, 0, 2] {\n\t\tv = try_continue classify(n)\n\t\tacc + v\n\t}\n}", nil},
+		{"try_break", errorDecls + "f = fn() !Int {\n\tfor acc = 0; n in [1, 0, 2] {\n\t\tv = try_break classify(n)\n\t\tacc + v\n\t}\n}", nil},
+		{"switch on error", errorDecls + "f = fn(n: Int) String {\n\tv = classify(n)\n\tswitch v {\n\t\tE1 { \"E1\" }\n\t\tE2 { \"E2\" }\n\t\tInt { \"Int\" }\n\t}\n}", nil},
+
+		{"generic instances", "id[a]: fn(x: a) a { x }\nf = fn() Int { id(1) + id(2) }\ng = fn() String { id(\"s\") }",
+			[]string{"fn @id[Int](%x: Int) Int", "fn @id[String](%x: String) String", "call fn Int @id[Int]"}},
+		{"generic list", listDecls + "f = fn() Int {\n\txs = map(prepend(prepend(nil, 2), 1), double)\n\tlength(xs) + length(prepend(nil, \"a\"))\n}",
+			[]string{"fn @map[Int, Int](%list: List[Int], %f: fn(Int) Int) List[Int]", "call fn List[Int] @map[Int, Int]", "fn @length[String]"}},
+		{"contract", "Numeric[a] = contract(\n\tmul[a] = fn(a, a) a\n)\nsqr[a]: fn(x: Numeric[a]) a { x * x }\nf = fn() Float { sqr(1.5) }",
+			[]string{"fn @sqr[Float](%x: Float) Float", "mul Float %x, %x"}},
+
+		{"trailing block", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\nf = fn(k: Int) Int { apply(40) { |m| m + k } }",
+			[]string{"func fn(Int) Int @f$1(%k)", "fn @f$1(%k: Int, %m: Int) Int", "add Int %m, %k"}},
+		{"closure over closures", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\nf = fn(k: Int) Int { apply(3) { apply(it) { |m| m + k + it } } }",
+			[]string{"func fn(Int) Int @f$1(%k)", "func fn(Int) Int @f$1$1(%k, %it)", "fn @f$1$1(%k: Int, %it: Int, %m: Int) Int"}},
+		{"partial application", "add = fn(a: Int, b: Int) Int { a + b }\nf = fn() fn(Int) Int { add(2, *) }",
+			[]string{"func fn(b: Int) Int @f$1(%0)", "fn @f$1(%_0: Int, %_1: Int) Int", "call fn Int @add(%_0, %_1)"}},
+		{"partial application of a function value", "f = fn(g: fn(Int, String) Int) fn(String) Int { g(1, *) }",
+			[]string{"@f$1(%g, %0)", "call fn Int %_0(%_1, %_2)"}},
+		{"local function", "f = fn(k: Int) Int {\n\tsum = fn(n: Int) Int { if n == 0 { k } else { sum(n - 1) + n } }\n\tsum(6)\n}",
+			[]string{"func.rec fn(n: Int) Int @f$sum(%k)", "fn @f$sum(%k: Int, %sum: fn(n: Int) Int, %n: Int) Int", "call fn Int %sum(%"}},
+		{"local function capturing nothing", "f = fn() Int {\n\tfact = fn(n: Int) Int { if n == 0 { 1 } else { n * fact(n - 1) } }\n\tfact(5)\n}",
+			[]string{"%0 = func fn(n: Int) Int @f$fact\n", "fn @f$fact(%n: Int) Int", "func fn(n: Int) Int @f$fact\n"}},
+		{"overloads", "f = fn(n: Int) Int { n + 1 }\nf = fn(s: String) String { s }\ng = fn() String { \"\\(f(1)) \\(f(\"a\")) \\(\"b\".f())\" }",
+			[]string{"fn @f(%n: Int) Int", "fn @f.2(%s: String) String", "call fn Int @f(", "call fn String @f.2(%", "call fn String @f.2(%"}},
+		{"overloaded string", "P = type(x: Int)\nS = type(w: Int)\nstring = fn(p: P) String { \"p\" }\nstring = fn(s: S) String { \"s\" }\nf = fn(p: P, s: S) String { \"\\(p) \\(s)\" }",
+			[]string{"call fn String @string(%p)", "call fn String @string.2(%s)"}},
+		{"local functions of the same name", "f = fn(k: Int) Int {\n\ta = {\n\t\tg = fn() Int { k }\n\t\tg()\n\t}\n\tb = {\n\t\tg = fn() Int { k * 2 }\n\t\tg()\n\t}\n\ta + b\n}",
+			[]string{"fn @f$g(%k: Int) Int", "fn @f$g.2(%k: Int) Int"}},
+		{"closure in a generic instance", listDecls + "f = fn(k: Int) List[Int] { map(prepend(nil, 1)) { it + k } }\n" +
+			"over[a]: fn(x: a, f: fn(a) a) a { f(x) }\ntwice[a]: fn(x: a, g: fn(a) a) a { over(x) { g(g(it)) } }\ng = fn() Int { twice(1, double) }",
+			[]string{"func fn(Int) Int @f$1(%k)", "fn @twice[Int]$1(%g: fn(Int) Int, %it: Int) Int"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := lower(t, test.input)
+			if err != nil {
+				t.Fatalf("Lower() = %v", err)
+			}
+			text := m.String()
+			if err := Verify(m); err != nil {
+				t.Fatalf("Verify() = %v\n%s", err, text)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("text does not contain %q:\n%s", want, text)
+				}
+			}
+			parsed, err := Parse(text)
+			if err != nil {
+				t.Fatalf("Parse() = %v\n%s", err, text)
+			}
+			if err := Verify(parsed); err != nil {
+				t.Errorf("Verify(Parse()) = %v", err)
+			}
+			if got := parsed.String(); got != text {
+				t.Errorf("Parse() printed:\n%s\nwant:\n%s", got, text)
+			}
+		})
+	}
+}
+
+// openRanges removes from the ranges of module the bounds written as the
+// identifier open, which the grammar cannot leave out.
+func openRanges(module *ast.Module) {
+	isOpen := func(b *ast.RangeBound) bool { return b.Value.String() == "open" }
+	for _, item := range module.TopLevelItems {
+		ast.Inspect(item, func(n ast.Node) bool {
+			if r, ok := n.(*ast.Range); ok {
+				if isOpen(r.StartBound) {
+					r.StartBound = nil
+				} else if isOpen(r.EndBound) {
+					r.EndBound = nil
+				}
+			}
+			return true
+		})
+	}
+}
+
+func TestLowerOpenRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"slice without end", "f = fn(xs: []Int) []Int { xs[1..open] }", []string{"len Int %xs", "slice []Int %xs"}},
+		{"slice without start", "f = fn(s: String) String { s[open..1] }", []string{"const Int 0", "slice String %s"}},
+		{"range without start", "f = fn(n: UInt8) Int { for c = 0; i in open..n { c + 1 } }", []string{"const UInt8 0"}},
+		{"range without end", "f = fn(n: Int8) Int { for c = 0; i in n..open { c + 1 } }", []string{"const Int8 127"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			module, err := parse.Module(source.NewSource([]byte(test.input), "test.tup"), ast.NewModule("test.tup"))
+			if err != nil {
+				t.Fatalf("parse.Module(%q) = %v", test.input, err)
+			}
+			openRanges(module)
+			info, err := check.Module(module)
+			if err != nil {
+				t.Fatalf("check.Module(%q) = %v", test.input, err)
+			}
+			m, err := Lower(module, info)
+			if err != nil {
+				t.Fatalf("Lower() = %v", err)
+			}
+			text := m.String()
+			if err := Verify(m); err != nil {
+				t.Fatalf("Verify() = %v\n%s", err, text)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("text does not contain %q:\n%s", want, text)
+				}
+			}
+		})
+	}
+}
+
+func TestLowerGeneric(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		want     []string
+		unwanted []string
+	}{
+		{"passed on", "id[a]: fn(x: a) a { x }\nf = fn() Int { id(1) + id(2) }\ng = fn() String { id(\"s\") }",
+			[]string{"fn[a] @id(%x: a) a {", "call fn Int @id[Int](", "call fn String @id[String]("}, []string{"fn @id[Int]"}},
+		{"exported", "id[a]: fn(x: a) a { x }", []string{"export fn[a] @id(%x: a) a {"}, nil},
+		{"function values", "apply[a, b]: fn(x: a, f: fn(a) b) b { f(x) }\ndouble = fn(n: Int) Int { n * 2 }\nf = fn() Int { apply(21, double) }",
+			[]string{"fn[a, b] @apply(%x: a, %f: fn(a) b) b {", "call fn b %f(%x)", "call fn Int @apply[Int, Int]("}, nil},
+		{"recursion", "count[a]: fn(x: a, n: Int) Int {\n\tif n == 0 { 0 } else { count(x, n - 1) + 1 }\n}\nf = fn() Int { count(\"s\", 3) }",
+			[]string{"fn[a] @count(%x: a, %n: Int) Int {", "call fn Int @count[a](%x, ", "call fn Int @count[String]("}, nil},
+		{"generic callee", "over[a]: fn(x: a, f: fn(a) a) a { f(x) }\ntwice[a]: fn(x: a, g: fn(a) a) a { over(over(x, g), g) }\n" +
+			"double = fn(n: Int) Int { n * 2 }\nf = fn() Int { twice(1, double) }",
+			[]string{"fn[a] @over(", "fn[a] @twice(", "call fn a @over[a](%x, %g)", "call fn Int @twice[Int]("}, nil},
+		{"arithmetic", "Numeric[a] = contract(\n\tmul[a] = fn(a, a) a\n)\nsqr[a]: fn(x: Numeric[a]) a { x * x }\nf = fn() Float { sqr(1.5) }",
+			[]string{"fn @sqr[Float](%x: Float) Float", "call fn Float @sqr[Float]("}, []string{"fn[a]"}},
+		{"generic types", listDecls + "f = fn() Int { length(prepend(nil, 1)) }",
+			[]string{"fn @length[Int](", "fn @prepend[Int]("}, []string{"fn[a]"}},
+		{"closure", "over[a]: fn(x: a, f: fn(a) a) a { f(x) }\ntwice[a]: fn(x: a, g: fn(a) a) a { over(x) { g(g(it)) } }\n" +
+			"double = fn(n: Int) Int { n * 2 }\nf = fn() Int { twice(1, double) }",
+			[]string{"fn[a] @over(", "fn @twice[Int](", "fn @twice[Int]$1(", "call fn Int @over[Int]("}, []string{"fn[a] @twice", "@twice$1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := lowerGeneric(t, test.input)
+			if err != nil {
+				t.Fatalf("LowerGeneric() = %v", err)
+			}
+			text := m.String()
+			if err := Verify(m); err != nil {
+				t.Fatalf("Verify() = %v\n%s", err, text)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("text does not contain %q:\n%s", want, text)
+				}
+			}
+			for _, unwanted := range test.unwanted {
+				if strings.Contains(text, unwanted) {
+					t.Errorf("text contains %q:\n%s", unwanted, text)
+				}
+			}
+			parsed, err := Parse(text)
+			if err != nil {
+				t.Fatalf("Parse() = %v\n%s", err, text)
+			}
+			if err := Verify(parsed); err != nil {
+				t.Errorf("Verify(Parse()) = %v", err)
+			}
+			if got := parsed.String(); got != text {
+				t.Errorf("Parse() printed:\n%s\nwant:\n%s", got, text)
+			}
+		})
+	}
+}
+
+func TestLowerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"generic value", "id[a]: fn(x: a) a { x }\nf = fn() Int {\n\th = id\n\th(1)\n}", "generic function id must be called to be instantiated"},
+		{"polymorphic recursion", "nest[a]: fn(x: a, n: Int) Int {\n\tif n == 0 { 0 } else { nest([x], n - 1) + 1 }\n}\nf = fn() Int { nest(1, 3) }",
+			"generic function nest instantiates itself without bound: nest[a] calls nest[[]a]"},
+		{"mutual polymorphic recursion", "ping[a]: fn(x: a, n: Int) Int {\n\tif n == 0 { 0 } else { pong((x, x), n - 1) }\n}\npong[b]: fn(y: b, n: Int) Int {\n\tif n == 0 { 1 } else { ping(y, n - 1) }\n}\nf = fn() Int { ping(1, 3) }",
+			"generic function ping instantiates itself without bound: ping[a] calls pong[(a, a)]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := lower(t, test.input)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Lower() = %v, %v, want error containing %q", m, err, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestPositions checks that instructions carry the positions of the
+// source they were lowered from.
+func TestPositions(t *testing.T) {
+	m, err := lower(t, "f = fn(a: Int, b: Int) Int {\n\tc = a * b\n\tc + 1\n}")
+	if err != nil {
+		t.Fatalf("Lower() = %v", err)
+	}
+	lines := map[Op]int{}
+	for _, b := range m.Func("f").Blocks {
+		for _, instr := range b.Instrs {
+			lines[instr.Op] = instr.Pos.Line
+		}
+	}
+	for op, want := range map[Op]int{OpMul: 2, OpAdd: 3} {
+		if got := lines[op]; got != want {
+			t.Errorf("%s is on line %d, want %d", op, got, want)
+		}
+	}
+}
+
+// TestVerify parses modules that are malformed in one respect each and
+// checks that Verify reports it.
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"well formed", "module m\n\nfn @f(%a: Int) Int {\nb0:\n  %0 = add Int %a, %a\n  ret %0\n}\n", ""},
+		{"closure", "module m\n\nfn @g(%k: String, %a: Int) Int {\nb0:\n  ret %a\n}\n\nfn @f(%k: Int) fn(Int) Int {\nb0:\n  %0 = func fn(Int) Int @g(%k)\n  ret %0\n}\n", "@g has type fn(k: String, a: Int) Int, want fn(Int, Int) Int"},
+		{"closure capturing itself", "module m\n\nfn @g(%a: Int) Int {\nb0:\n  ret %a\n}\n\nfn @f() fn(Int) Int {\nb0:\n  %0 = func.rec fn(Int) Int @g\n  ret %0\n}\n", "only closures capture themselves"},
+		{"operand type", "module m\n\nfn @f(%a: Int, %b: Float64) Int {\nb0:\n  %0 = add Int %a, %b\n  ret %0\n}\n", "operand %b has type Float64, want the result type Int"},
+		{"comparison", "module m\n\nfn @f(%a: Int, %b: Int) Int {\nb0:\n  %0 = lt Int %a, %b\n  ret %0\n}\n", "has type Int, want Bool"},
+		{"return type", "module m\n\nfn @f(%a: Int) String {\nb0:\n  ret %a\n}\n", "returns Int, want String"},
+		{"missing terminator", "module m\n\nfn @f(%a: Int) Int {\nb0:\n  %0 = add Int %a, %a\n}\n", "terminator"},
+		{"dominance", "module m\n\nfn @f(%c: Bool) Int {\nb0:\n  br %c, b1, b2\nb1:\n  %0 = const Int 1\n  jump b2\nb2:\n  ret %0\n}\n", "operand %0 does not dominate its use"},
+		{"phi predecessors", "module m\n\nfn @f(%c: Bool) Int {\nb0:\n  %0 = const Int 1\n  br %c, b1, b2\nb1:\n  jump b2\nb2:\n  %1 = phi Int b1: %0\n  ret %1\n}\n", "no operand for predecessor b0"},
+		{"wrap", "module m\n\nfn @f(%a: Int) Int | String {\nb0:\n  %0 = wrap Int | String %a, 1\n  ret %0\n}\n", "operand has type Int, want String"},
+		{"update", "module m\n\nfn @f(%p: (Int, String)) (Int, String) {\nb0:\n  %0 = update (Int, String) %p, %p, 1\n  ret %0\n}\n", "field 1 has type (Int, String), want String"},
+		{"call arity", "module m\n\nfn @g(%a: Int) Int {\nb0:\n  ret %a\n}\n\nfn @f() Int {\nb0:\n  %0 = call fn Int @g()\n  ret %0\n}\n", "0 arguments, want 1"},
+		{"call effect", "module m\n\nfx @g() Int {\nb0:\n  %0 = const Int 1\n  ret %0\n}\n\nfn @f() Int {\nb0:\n  %0 = call fn Int @g()\n  ret %0\n}\n", "fx"},
+		{"slice", "module m\n\nfn @f(%a: [3]Int, %i: Int) [3]Int {\nb0:\n  %0 = slice [3]Int %a, %i, %i\n  ret %0\n}\n", "result has type [3]Int, want a dynamic array of Int"},
+		{"conversion", "module m\n\nfn @f(%a: Int) String {\nb0:\n  %0 = conv String %a\n  ret %0\n}\n", "conv is not defined on String"},
+		{"checked overflow block", "module m\n\nfn @f(%a: Int) Int {\nb0:\n  %0 = add.checked Int %a, %a, b1, b1\nb1:\n  ret %a\n}\n", "must have the checked operation as its only predecessor"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := Parse(test.input)
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			err = Verify(m)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Verify() = %v, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"no module", "fn @f() {\nb0:\n  ret\n}\n", "line 1:"},
+		{"unknown operation", "module m\n\nfn @f() {\nb0:\n  frob\n}\n", "line 5:"},
+		{"undefined value", "module m\n\nfn @f() Int {\nb0:\n  ret %9\n}\n", "line 5:"},
+		{"unknown type", "module m\n\nfn @f(%a: Widget) {\nb0:\n  ret\n}\n", "line 3:"},
+		{"unterminated function", "module m\n\nfn @f() {\nb0:\n  ret\n", "missing } at end of function f"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := Parse(test.input)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Parse() = %v, %v, want error containing %q", m, err, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestExampleGoldens lowers each example listed and compares the text of
+// the module with testdata/<name>.ir. Set UPDATE_IR_GOLDENS to rewrite the
+// golden files.
+func TestExampleGoldens(t *testing.T) {
+	update := os.Getenv(updateIRGoldensEnv) != ""
+	for _, name := range []string{"fib"} {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join("..", "..", "examples", name+".tup")
+			contents, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := lowerFile(t, filename, string(contents))
+			if err != nil {
+				t.Fatalf("Lower() = %v", err)
+			}
+			if err := Verify(m); err != nil {
+				t.Fatalf("Verify() = %v", err)
+			}
+			golden := filepath.Join("testdata", name+".ir")
+			if update {
+				if err := os.WriteFile(golden, []byte(m.String()), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.String(); got != string(want) {
+				t.Errorf("%s lowered to:\n%s\nwant:\n%s", filename, got, want)
+			}
+		})
+	}
+}
+
+func TestFuncText(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"f", "f"},
+		{"map[Int, String]", "map[Int, String]"},
+		{"f$1", "fn { ... }"},
+		{"f$sq", "sq"},
+		{"f$sq.2", "sq"},
+		{"f.2", "f"},
+		{"f.2$1", "fn { ... }"},
+		{"f$sq$1", "fn { ... }"},
+		{"map[Int, String]$1$g", "g"},
+	}
+	for _, test := range tests {
+		if got := FuncText(test.name); got != test.want {
+			t.Errorf("FuncText(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package ir
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Error reports a construct that cannot be lowered, such as a generic
+// value, which the IR does not represent.
+type Error struct {
+	Pos ast.Position
+	Msg string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("error: %s\n--> %s", err.Msg, err.Pos)
+}
+
+// Lower lowers the module checked into info, which must have been checked
+// without errors. Each top-level function, and each instance of a generic
+// function called, becomes a function of the module; the later overloads of
+// a name are named after it followed by .2, .3 and so on. Each closure also
+// becomes a function, lifted out of the function it is created in;
+// top-level bindings, which must be constants, are folded into the
+// functions that use them, and the host functions called are declared.
+func Lower(module *ast.Module, info *check.Info) (*Module, error) {
+	return lowerModule(module, info, false)
+}
+
+// LowerGeneric lowers as Lower does, but keeps generic the generic
+// functions that need no instances: those whose values of the types of
+// their type parameters are only passed on, as the arguments and results
+// of calls and the operands of phis, and whose other values have types
+// not mentioning them. Direct calls of these carry their type arguments.
+func LowerGeneric(module *ast.Module, info *check.Info) (*Module, error) {
+	return lowerModule(module, info, true)
+}
+
+func lowerModule(module *ast.Module, info *check.Info, generic bool) (m *Module, err error) {
+	l := &lowerer{
+		info:     info,
+		generic:  generic,
+		kept:     map[*ast.FunctionDeclaration]*Func{},
+		module:   &Module{Name: strings.TrimSuffix(filepath.Base(module.Name), ".tup")},
+		funcs:    map[string]*Func{},
+		declared: map[ast.Node]*Func{},
+		externs:  map[string]*Func{},
+		lowered:  map[string]bool{},
+		lifted:   map[string]int{},
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(lowerBailout)
+			if !ok {
+				panic(r)
+			}
+			m, err = nil, b.err
+		}
+	}()
+	l.lower(module)
+	return l.module, nil
+}
+
+// lowerBailout is panicked with to abandon lowering after an error.
+type lowerBailout struct{ err *Error }
+
+type lowerer struct {
+	info   *check.Info
+	module *Module
+	// functions of the module and the host functions declared, by name
+	funcs   map[string]*Func
+	externs map[string]*Func
+	// the functions declared at top level, by their declarations
+	declared map[ast.Node]*Func
+
+	// instances of generic functions not yet lowered
+	instances []*instance
+	// generic is set to keep generic the generic functions that need no
+	// instances, which kept holds by their declarations, or nil for those
+	// that do
+	generic bool
+	kept    map[*ast.FunctionDeclaration]*Func
+	// generic functions an instance of which has been lowered, whose
+	// edges between type parameters are known
+	lowered  map[string]bool
+	edgeList []instEdge
+	// number of closures lifted out of each function
+	lifted map[string]int
+}
+
+func (l *lowerer) errorf(node ast.Node, format string, args ...any) {
+	panic(lowerBailout{&Error{Pos: ast.PosOf(node), Msg: fmt.Sprintf(format, args...)}})
+}
+
+func (l *lowerer) lower(module *ast.Module) {
+	// every function is declared before any body is lowered, so that
+	// functions may call each other in any order
+	var decls, exported []*ast.FunctionDeclaration
+	for _, item := range module.TopLevelItems {
+		export := false
+		switch e := item.(type) {
+		case *ast.ExportFunctionDeclaration:
+			item, export = e.Function, true
+		case *ast.ExportAssignment:
+			item = &e.Assignment
+		}
+		switch item := item.(type) {
+		case *ast.FunctionDeclaration:
+			obj, name := l.declName(item)
+			sig, ok := obj.Type.(*types.Function)
+			if !ok {
+				l.errorf(item, "type of %s is not known", name)
+			}
+			if sig.TypeParams != nil {
+				// generic functions are lowered only once instantiated,
+				// unless they are exported and may be kept generic
+				if export && l.generic {
+					exported = append(exported, item)
+				}
+				continue
+			}
+			fn := &Func{Name: name, Sig: Canonical(sig).(*types.Function), Export: export}
+			l.funcs[name] = fn
+			l.declared[item] = fn
+			l.module.Funcs = append(l.module.Funcs, fn)
+			decls = append(decls, item)
+		case *ast.Assignment:
+			if l.info.Values[item.Right] == nil {
+				l.errorf(item, "top-level binding %s is not a constant", item.Left)
+			}
+		}
+	}
+	for _, decl := range exported {
+		obj, name := l.declName(decl)
+		if fn := l.keep(decl, name, obj.Type.(*types.Function)); fn != nil {
+			fn.Export = true
+		}
+	}
+	for _, decl := range decls {
+		l.function(l.declared[decl], decl, nil)
+	}
+	for len(l.instances) > 0 {
+		inst := l.instances[0]
+		l.instances = l.instances[1:]
+		l.function(inst.fn, inst.decl, inst.targs)
+	}
+}
+
+// declName returns the object declaring the top-level function decl and
+// the name of its function in the module: its own, or for the later
+// overloads of a name the name followed by .2, .3 and so on.
+func (l *lowerer) declName(decl *ast.FunctionDeclaration) (*check.Object, string) {
+	name := decl.LHS.Name.Name
+	if obj := l.info.Scope.LookupLocal(name); obj != nil {
+		for i, obj := range append([]*check.Object{obj}, obj.Overloads...) {
+			if obj.Decl != decl {
+				continue
+			}
+			if i > 0 {
+				name = fmt.Sprintf("%s.%d", name, i+1)
+			}
+			return obj, name
+		}
+	}
+	l.errorf(decl, "%s is not declared", name)
+	return nil, ""
+}
+
+// extern returns the declaration of the host function name, adding it to
+// the module on first use.
+func (l *lowerer) extern(name string, sig *types.Function) *Func {
+	if fn := l.externs[name]; fn != nil {
+		return fn
+	}
+	fn := &Func{Name: name, Sig: sig}
+	l.externs[name] = fn
+	l.module.Funcs = append(l.module.Funcs, fn)
+	return fn
+}
+
+// scope maps the names bound by the blocks of a function to variables.
+type scope struct {
+	vars   map[string]*variable
+	parent *scope
+	// capture, set for the outermost scope of a closure, looks up the
+	// names the closure does not bind in the scopes it is created in
+	capture func(name string) *variable
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: map[string]*variable{}, parent: parent}
+}
+
+func (s *scope) lookup(name string) *variable {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+		if s.capture != nil {
+			return s.capture(name)
+		}
+	}
+	return nil
+}
+
+// funcLowerer lowers the body of a function.
+type funcLowerer struct {
+	*lowerer
+	*builder
+	decl  *ast.FunctionDeclaration
+	loops []*loop // enclosing for loops, innermost last
+
+	// types of the type parameters of the instance of a generic function
+	// being lowered, by name
+	targs map[string]types.Type
+	// edges is set while lowering the first instance of a generic
+	// function, whose calls add the edges between type parameters
+	edges bool
+
+	// the values a closure captures, read where it is created, and the
+	// parameters that receive them
+	captures []func() Value
+	captured []*Param
+	// self is the function of a local function, named local, which its
+	// body refers to by its name
+	self  *Instr
+	local string
+}
+
+// function lowers the function declared by decl, or its instance for the
+// types of its type parameters targs, into fn.
+func (l *lowerer) function(fn *Func, decl *ast.FunctionDeclaration, targs map[string]types.Type) {
+	f := &funcLowerer{lowerer: l, builder: newBuilder(fn), decl: decl, targs: targs}
+	if _, name := l.declName(decl); targs != nil && !l.lowered[name] {
+		l.lowered[name] = true
+		f.edges = true
+	}
+	if decl.Body == nil {
+		l.errorf(decl, "%s has no body", fn.Name)
+	}
+	s := newScope(nil)
+	fn.Params = f.params(decl, fn.Sig, s)
+	v := f.block(s, decl.Body)
+	f.ret(decl.Body, v)
+	f.finish()
+}
+
+// params returns the parameters of the function of type sig declared by
+// decl, binding those it names in s.
+func (f *funcLowerer) params(decl *ast.FunctionDeclaration, sig *types.Function, s *scope) []*Param {
+	var params []ast.FunctionTypeParameter
+	if decl.Type != nil {
+		params = decl.Type.Parameters
+	}
+	var result []*Param
+	for i, p := range sig.Params {
+		name := ""
+		if i < len(params) {
+			switch p := params[i].(type) {
+			case *ast.LabeledParameter:
+				name = p.Identifier.Name
+			case *ast.LabeledRestParameter:
+				name = p.Identifier.Name
+			}
+		}
+		param := &Param{Name: name, Typ: p.Type}
+		if name == "" || name == "_" {
+			param.Name = fmt.Sprintf("_%d", i)
+		} else {
+			v := &variable{name: name, typ: p.Type}
+			s.vars[name] = v
+			f.write(v, param)
+		}
+		result = append(result, param)
+	}
+	return result
+}
+
+// ret returns v from the function being lowered.
+func (f *funcLowerer) ret(node ast.Node, v Value) {
+	result := f.fn.Sig.Result
+	if result == nil {
+		f.terminate(&Instr{Op: OpRet})
+		return
+	}
+	f.terminate(&Instr{Op: OpRet, Args: []Value{f.coerce(node, v, result)}})
+}
+
+// typeOf returns the type the IR gives to the values of expr.
+func (f *funcLowerer) typeOf(expr ast.Node) types.Type {
+	typ := f.info.Types[expr]
+	if typ == nil {
+		f.errorf(expr, "type of %s is not known", expr)
+	}
+	return Canonical(f.subst(typ))
+}
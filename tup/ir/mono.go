@@ -0,0 +1,286 @@
+package ir
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Generic functions are monomorphized: each instance reached from a call,
+// such as map[Int, String], is lowered as a function of its own, a clone of
+// the body of the generic function with the types of its type parameters
+// substituted. Instances are named and shared by the canonical text of
+// their type arguments.
+//
+// A generic function that calls itself, directly or through others, with
+// type arguments built from its own type parameters, such as f[a] calling
+// f[[]a], would have instances without bound. Such polymorphic recursion is
+// found from the type parameters each call passes on: an edge runs from a
+// type parameter of the caller to each type parameter of the callee whose
+// type argument mentions it, and is expansive if the type argument is more
+// than the type parameter itself. Instantiation is unbounded exactly when
+// an expansive edge lies on a cycle.
+
+// instance is an instance of a generic function, lowered once the
+// functions before it have been.
+type instance struct {
+	fn   *Func
+	decl *ast.FunctionDeclaration
+	// targs maps the names of the type parameters of the function to
+	// their types
+	targs map[string]types.Type
+}
+
+// typeParam is a type parameter of a generic function of the module.
+type typeParam struct {
+	fn, param string
+}
+
+// instEdge runs from a type parameter of a generic function to a type
+// parameter of a generic function it calls, whose type argument mentions
+// the first.
+type instEdge struct {
+	from, to  typeParam
+	expansive bool
+	call      *ast.FunctionCall
+	// the caller and the instance it calls, as written in the caller,
+	// such as f[a] and f[[]a]
+	caller, callee string
+}
+
+// subst returns t with the type parameters of the generic function being
+// lowered replaced by their types.
+func (f *funcLowerer) subst(t types.Type) types.Type {
+	return types.Subst(t, f.targs)
+}
+
+// instance returns the instance of the generic function declared by obj
+// that call calls, adding it to the module on first use. If the function
+// is kept generic, it is returned instead, with the type arguments of the
+// call.
+func (f *funcLowerer) instance(call *ast.FunctionCall, obj *check.Object, inst *check.Instance) (*Func, []types.Type) {
+	sig, ok := obj.Type.(*types.Function)
+	decl, isDecl := obj.Decl.(*ast.FunctionDeclaration)
+	if !ok || !isDecl || len(sig.TypeParams) != len(inst.TypeArgs) {
+		f.errorf(call, "instance of %s is not known", obj.Name)
+	}
+	args := make([]types.Type, len(inst.TypeArgs))
+	targs := map[string]types.Type{}
+	unknown := -1 // a type argument mentioning type parameters
+	for i, param := range sig.TypeParams {
+		args[i] = Canonical(f.subst(inst.TypeArgs[i]))
+		targs[param.Name()] = args[i]
+		if types.IsGeneric(args[i]) {
+			// the type parameters of a function being kept generic
+			if unknown < 0 {
+				unknown = i
+			}
+			continue
+		}
+		// constraints on the type parameters of the caller are checked
+		// once their types are known
+		if err := f.info.Satisfies(args[i], param); err != nil {
+			f.errorf(call, "%v in call to %s", err, obj.Name)
+		}
+	}
+	_, base := f.declName(decl)
+	if f.generic {
+		if fn := f.keep(decl, base, sig); fn != nil {
+			return fn, args
+		}
+	}
+	if unknown >= 0 {
+		f.errorf(call, "type %s of %s in call to %s is not known", args[unknown], sig.TypeParams[unknown], obj.Name)
+	}
+	if f.edges {
+		f.instEdges(call, base, sig, inst.TypeArgs)
+	}
+
+	name := types.InstanceName(base, args)
+	if fn := f.funcs[name]; fn != nil {
+		return fn, nil
+	}
+	f.unbounded()
+	fn := &Func{Name: name, Sig: Canonical(types.Subst(sig, targs)).(*types.Function)}
+	f.funcs[name] = fn
+	f.module.Funcs = append(f.module.Funcs, fn)
+	f.instances = append(f.instances, &instance{fn: fn, decl: decl, targs: targs})
+	return fn, nil
+}
+
+// keep returns the generic function named name declared by decl, of type
+// sig, kept generic, or nil if it needs instances. The function is lowered
+// once, with the types of its values mentioning its type parameters; if
+// that fails, or some of those values are not only passed on, what the
+// lowering added to the module is taken back.
+func (l *lowerer) keep(decl *ast.FunctionDeclaration, name string, sig *types.Function) *Func {
+	if fn, ok := l.kept[decl]; ok {
+		return fn
+	}
+	fn := &Func{Name: name, Sig: Canonical(sig).(*types.Function), TypeParams: sig.TypeParams}
+	// calls of the function within itself call it kept generic
+	l.kept[decl] = fn
+	l.funcs[name] = fn
+	funcs, instances, lifted := len(l.module.Funcs), len(l.instances), maps.Clone(l.lifted)
+	l.module.Funcs = append(l.module.Funcs, fn)
+	if l.tryFunction(fn, decl) && passesOn(fn) && !slices.ContainsFunc(l.module.Funcs[funcs+1:], mentionsTypeParams) {
+		return fn
+	}
+	for _, added := range l.module.Funcs[funcs:] {
+		delete(l.funcs, added.Name)
+		delete(l.externs, added.Name)
+		for d, kept := range l.kept {
+			if kept == added {
+				delete(l.kept, d)
+			}
+		}
+	}
+	l.module.Funcs = l.module.Funcs[:funcs]
+	l.instances = l.instances[:instances]
+	l.lifted = lifted
+	l.kept[decl] = nil
+	return nil
+}
+
+// tryFunction lowers the generic function declared by decl into fn,
+// reporting whether it could be.
+func (l *lowerer) tryFunction(fn *Func, decl *ast.FunctionDeclaration) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isBailout := r.(lowerBailout); !isBailout {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	l.function(fn, decl, nil)
+	return true
+}
+
+// passesOn reports whether fn only passes on the values whose types
+// mention its type parameters, as the arguments and results of calls and
+// the operands of phis, and whether those values have the types of the
+// type parameters or of functions of them, which need no instances.
+func passesOn(fn *Func) bool {
+	for _, param := range fn.Params {
+		if !opaque(param.Typ) {
+			return false
+		}
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			generic := instr.Typ != nil && types.IsGeneric(instr.Typ)
+			for _, arg := range instr.Args {
+				generic = generic || types.IsGeneric(arg.Type())
+			}
+			if !generic {
+				continue
+			}
+			switch instr.Op {
+			case OpCall, OpPhi, OpRet:
+			default:
+				return false
+			}
+			if instr.Typ != nil && !opaque(instr.Typ) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// opaque reports whether t is a type parameter or a function of types t
+// for which opaque holds, or does not mention type parameters.
+func opaque(t types.Type) bool {
+	switch t := t.(type) {
+	case *types.TypeParam:
+		return true
+	case *types.Function:
+		for _, p := range t.Params {
+			if !opaque(p.Type) {
+				return false
+			}
+		}
+		return t.Result == nil || opaque(t.Result)
+	}
+	return !types.IsGeneric(t)
+}
+
+// mentionsTypeParams reports whether the types of fn or of its values
+// mention type parameters other than those of fn kept generic.
+func mentionsTypeParams(fn *Func) bool {
+	if fn.TypeParams != nil {
+		return false
+	}
+	if types.IsGeneric(fn.Sig) {
+		return true
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Typ != nil && types.IsGeneric(instr.Typ) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// instEdges adds the edges from the type parameters of the generic
+// function being lowered to those of the generic function name of type
+// sig, called by call with the type arguments args.
+func (f *funcLowerer) instEdges(call *ast.FunctionCall, name string, sig *types.Function, args []types.Type) {
+	_, caller := f.declName(f.decl)
+	callerSig := f.info.Types[f.decl].(*types.Function)
+	params := make([]types.Type, len(callerSig.TypeParams))
+	for i, param := range callerSig.TypeParams {
+		params[i] = param
+	}
+	for i, arg := range args {
+		for _, p := range types.TypeParamsOf(arg) {
+			_, same := arg.(*types.TypeParam)
+			f.edgeList = append(f.edgeList, instEdge{
+				from:      typeParam{caller, p.Name()},
+				to:        typeParam{name, sig.TypeParams[i].Name()},
+				expansive: !same,
+				call:      call,
+				caller:    types.InstanceName(caller, params),
+				callee:    types.InstanceName(name, args),
+			})
+		}
+	}
+}
+
+// unbounded reports polymorphic recursion, which an expansive edge on a
+// cycle shows, before an instance is added.
+func (f *funcLowerer) unbounded() {
+	for _, e := range f.edgeList {
+		if e.expansive && f.reaches(e.to, e.from) {
+			f.errorf(e.call, "generic function %s instantiates itself without bound: %s calls %s",
+				e.from.fn, e.caller, e.callee)
+		}
+	}
+}
+
+// reaches reports whether the type parameter to is reached by following
+// the edges from the type parameter from.
+func (l *lowerer) reaches(from, to typeParam) bool {
+	seen := map[typeParam]bool{from: true}
+	work := []typeParam{from}
+	for len(work) > 0 {
+		p := work[len(work)-1]
+		work = work[:len(work)-1]
+		if p == to {
+			return true
+		}
+		for _, e := range l.edgeList {
+			if e.from == p && !seen[e.to] {
+				seen[e.to] = true
+				work = append(work, e.to)
+			}
+		}
+	}
+	return false
+}
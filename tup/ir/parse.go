@@ -0,0 +1,736 @@
+package ir
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// ParseError reports a malformed line of the text of a module.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (err *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", err.Line, err.Msg)
+}
+
+// Parse parses the text of a module, as written by Fprint.
+func Parse(text string) (*Module, error) {
+	p := &parser{named: map[string]*types.Named{"error": types.ErrorType}}
+	for i, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			p.lines = append(p.lines, sourceLine{i + 1, line})
+		}
+	}
+	m, err := p.module()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type sourceLine struct {
+	n    int
+	text string
+}
+
+type parser struct {
+	lines []sourceLine
+	line  int // index of the line being parsed
+
+	// tokens of the line being parsed
+	toks []string
+	pos  int
+
+	named map[string]*types.Named
+	funcs map[string]*Func
+	// type parameters of the function being parsed, by name
+	typeParams map[string]*types.TypeParam
+}
+
+// bailout is panicked with to abandon parsing after an error.
+type bailout struct{ err *ParseError }
+
+func (p *parser) errorf(format string, args ...any) {
+	n := 0
+	if p.line < len(p.lines) {
+		n = p.lines[p.line].n
+	}
+	panic(bailout{&ParseError{Line: n, Msg: fmt.Sprintf(format, args...)}})
+}
+
+func (p *parser) module() (m *Module, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(bailout)
+			if !ok {
+				panic(r)
+			}
+			err = b.err
+		}
+	}()
+
+	m = &Module{}
+	p.funcs = map[string]*Func{}
+	if len(p.lines) == 0 {
+		p.errorf("missing module line")
+	}
+	p.start(0)
+	p.expect("module")
+	m.Name = p.next()
+	p.end()
+
+	// named types may refer to each other in any order, so they are
+	// declared before any is resolved
+	var decls []int
+	for i := 1; i < len(p.lines); i++ {
+		p.start(i)
+		if p.peek() != "type" {
+			break
+		}
+		p.next()
+		name := p.next()
+		if p.named[name] != nil {
+			p.errorf("type %s redeclared", name)
+		}
+		var annotations []string
+		for p.peek() == "@" {
+			p.next()
+			annotations = append(annotations, p.next())
+		}
+		p.named[name] = types.NewNamed(name, nil, annotations...)
+		decls = append(decls, i)
+	}
+	for _, i := range decls {
+		p.start(i)
+		p.next()
+		named := p.named[p.next()]
+		for p.peek() == "@" {
+			p.next()
+			p.next()
+		}
+		p.expect("=")
+		if p.peek() == "enum" {
+			named.SetUnderlying(p.enum())
+		} else {
+			named.SetUnderlying(p.typ())
+		}
+		p.end()
+	}
+
+	// functions may call each other in any order, so their signatures are
+	// read before their bodies
+	var bodies []int
+	for i := 1 + len(decls); i < len(p.lines); i++ {
+		p.start(i)
+		fn := p.header()
+		if p.funcs[fn.Name] != nil {
+			p.errorf("function %s redeclared", fn.Name)
+		}
+		p.funcs[fn.Name] = fn
+		m.Funcs = append(m.Funcs, fn)
+		if fn.Extern() {
+			continue
+		}
+		bodies = append(bodies, i)
+		for i++; i < len(p.lines) && p.lines[i].text != "}"; i++ {
+		}
+		if i == len(p.lines) {
+			p.errorf("missing } at end of function %s", fn.Name)
+		}
+	}
+	for _, i := range bodies {
+		p.body(i)
+	}
+	return m, nil
+}
+
+// start begins parsing line i.
+func (p *parser) start(i int) {
+	p.line = i
+	p.toks = tokenize(p, p.lines[i].text)
+	p.pos = 0
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	tok := p.peek()
+	if tok == "" {
+		p.errorf("unexpected end of line")
+	}
+	p.pos++
+	return tok
+}
+
+func (p *parser) expect(tok string) {
+	if got := p.peek(); got != tok {
+		if got == "" {
+			got = "end of line"
+		}
+		p.errorf("expected %s, got %s", tok, got)
+	}
+	p.pos++
+}
+
+func (p *parser) accept(tok string) bool {
+	if p.peek() == tok {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) end() {
+	if p.pos < len(p.toks) {
+		p.errorf("unexpected %s", p.toks[p.pos])
+	}
+}
+
+// tokenize splits a line into punctuation, quoted strings and words, which
+// run to the next space or punctuation. A word immediately followed by a
+// bracket, as in the name of the named type Range[Int], takes in the
+// bracketed text and what follows it, as in the name of the closure
+// map[Int, Int]$1.
+func tokenize(p *parser, line string) []string {
+	var toks []string
+	const punct = "(),[]{}:=|%@"
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(line) {
+				p.errorf("unterminated string")
+			}
+			toks = append(toks, line[i:j+1])
+			i = j + 1
+		case strings.IndexByte(punct, c) >= 0:
+			toks = append(toks, line[i:i+1])
+			i++
+		default:
+			j := i
+			for {
+				for j < len(line) && line[j] != ' ' && line[j] != '\t' && line[j] != '"' && strings.IndexByte(punct, line[j]) < 0 {
+					j++
+				}
+				if j >= len(line) || line[j] != '[' {
+					break
+				}
+				depth := 0
+				for ; j < len(line); j++ {
+					if line[j] == '[' {
+						depth++
+					} else if line[j] == ']' {
+						if depth--; depth == 0 {
+							j++
+							break
+						}
+					}
+				}
+			}
+			toks = append(toks, line[i:j])
+			i = j
+		}
+	}
+	return toks
+}
+
+// header parses the first line of a function or the declaration of a host
+// function.
+func (p *parser) header() *Func {
+	fn := &Func{}
+	extern := p.accept("declare")
+	if !extern {
+		fn.Export = p.accept("export")
+	}
+	fx := false
+	effect := p.next()
+	p.typeParams = nil
+	if i := strings.IndexByte(effect, '['); i >= 0 && !extern && strings.HasSuffix(effect, "]") {
+		p.typeParams = map[string]*types.TypeParam{}
+		for _, name := range strings.Split(effect[i+1:len(effect)-1], ",") {
+			param := types.NewTypeParam(strings.TrimSpace(name))
+			p.typeParams[param.Name()] = param
+			fn.TypeParams = append(fn.TypeParams, param)
+		}
+		effect = effect[:i]
+	}
+	switch effect {
+	case "fn":
+	case "fx":
+		fx = true
+	default:
+		p.errorf("expected fn, fx or declare, got %s", effect)
+	}
+	p.expect("@")
+	fn.Name = p.next()
+	p.expect("(")
+	var params []*types.Field
+	rest := -1
+	for !p.accept(")") {
+		if len(params) > 0 {
+			p.expect(",")
+		}
+		name := ""
+		if !extern {
+			p.expect("%")
+			name = p.next()
+			p.expect(":")
+		}
+		typ, isRest := p.paramType()
+		if isRest {
+			rest = len(params)
+		}
+		params = append(params, types.NewField(name, typ))
+		if !extern {
+			fn.Params = append(fn.Params, &Param{Name: name, Typ: typ})
+		}
+	}
+	var result types.Type
+	if p.peek() != "{" && p.peek() != "" {
+		result = p.typ()
+	}
+	fn.Sig = types.NewFunction(params, result, fx)
+	fn.Sig.Rest = rest
+	if !extern {
+		p.expect("{")
+		fn.Blocks = []*Block{}
+	}
+	p.end()
+	return fn
+}
+
+// paramType parses the type of a parameter, reporting whether it is a rest
+// parameter, written ...T, whose type is []T.
+func (p *parser) paramType() (types.Type, bool) {
+	if tok := p.peek(); strings.HasPrefix(tok, "...") {
+		if tok == "..." {
+			p.next()
+		} else {
+			p.toks[p.pos] = tok[3:]
+		}
+		return types.NewArray(p.typ()), true
+	}
+	return p.typ(), false
+}
+
+// typ parses a type, as written by its String method.
+func (p *parser) typ() types.Type {
+	t := p.nonUnion()
+	if p.peek() != "|" {
+		return t
+	}
+	members := []types.Type{t}
+	for p.accept("|") {
+		members = append(members, p.nonUnion())
+	}
+	return &types.Union{Members: members}
+}
+
+func (p *parser) nonUnion() types.Type {
+	switch tok := p.next(); tok {
+	case "(":
+		tuple := types.NewTuple()
+		for !p.accept(")") {
+			if len(tuple.Fields) > 0 {
+				p.expect(",")
+			}
+			name := ""
+			if p.pos+1 < len(p.toks) && p.toks[p.pos+1] == ":" {
+				name = p.next()
+				p.next()
+			}
+			tuple.Fields = append(tuple.Fields, types.NewField(name, p.typ()))
+		}
+		return tuple
+	case "[":
+		if p.accept("]") {
+			return types.NewArray(p.nonUnion())
+		}
+		n, err := strconv.ParseInt(p.next(), 10, 64)
+		if err != nil {
+			p.errorf("invalid array length")
+		}
+		p.expect("]")
+		return types.NewFixedArray(p.nonUnion(), n)
+	case "fn", "fx":
+		if p.peek() != "(" {
+			break
+		}
+		p.next()
+		var params []*types.Field
+		rest := -1
+		for !p.accept(")") {
+			if len(params) > 0 {
+				p.expect(",")
+			}
+			name := ""
+			if p.pos+1 < len(p.toks) && p.toks[p.pos+1] == ":" {
+				name = p.next()
+				p.next()
+			}
+			typ, isRest := p.paramType()
+			if isRest {
+				rest = len(params)
+			}
+			params = append(params, types.NewField(name, typ))
+		}
+		var result types.Type
+		if startsType(p.peek()) {
+			result = p.typ()
+		}
+		sig := types.NewFunction(params, result, tok == "fx")
+		sig.Rest = rest
+		return sig
+	default:
+		if t := basicType(tok); t != nil {
+			return t
+		}
+		if named := p.named[tok]; named != nil {
+			return named
+		}
+		if param := p.typeParams[tok]; param != nil {
+			return param
+		}
+		p.errorf("unknown type %s", tok)
+	}
+	p.errorf("unexpected %s in type", p.peek())
+	return nil
+}
+
+// startsType reports whether tok may begin a type, as opposed to the
+// tokens that may follow one.
+func startsType(tok string) bool {
+	switch tok {
+	case "", ",", ")", "]", "|", "{", "=", "%", "@", ":":
+		return false
+	}
+	return true
+}
+
+func basicType(name string) types.Type {
+	for _, b := range types.Aliases {
+		if b.Name() == name {
+			return b
+		}
+	}
+	for _, b := range types.Typ {
+		if b.Name() == name && b.Kind() != types.Invalid && !types.IsUntyped(b) {
+			return b
+		}
+	}
+	return nil
+}
+
+// enum parses the underlying type of an enum type.
+func (p *parser) enum() types.Type {
+	p.expect("enum")
+	p.expect("(")
+	enum := types.NewEnum()
+	for !p.accept(")") {
+		if len(enum.Members) > 0 {
+			p.expect(",")
+		}
+		name := p.next()
+		p.expect("=")
+		v, err := strconv.ParseInt(p.next(), 10, 64)
+		if err != nil {
+			p.errorf("invalid value of enum member %s", name)
+		}
+		enum.Members = append(enum.Members, &types.EnumMember{Name: name, Value: v})
+	}
+	return enum
+}
+
+// body parses the blocks of the function whose header is at line i.
+func (p *parser) body(i int) {
+	p.start(i)
+	fn := p.header()
+	fn = p.funcs[fn.Name]
+
+	// blocks may be used before they are labeled, and values before they
+	// are defined, as phis use them
+	blocks := map[string]*Block{}
+	block := func(name string) *Block {
+		b := blocks[name]
+		if b == nil {
+			b = &Block{Func: fn}
+			blocks[name] = b
+		}
+		return b
+	}
+	values := map[string]Value{}
+	for _, param := range fn.Params {
+		if values[param.Name] != nil {
+			p.errorf("parameter %%%s redeclared", param.Name)
+		}
+		values[param.Name] = param
+	}
+	type forward struct {
+		instr *Instr
+		arg   int
+		name  string
+		line  int
+	}
+	var forwards []forward
+	value := func(instr *Instr, arg int) {
+		p.expect("%")
+		name := p.next()
+		if v := values[name]; v != nil {
+			instr.Args[arg] = v
+		} else {
+			forwards = append(forwards, forward{instr, arg, name, p.line})
+		}
+	}
+
+	var cur *Block
+	labeled := map[*Block]bool{}
+	for i++; p.lines[i].text != "}"; i++ {
+		p.start(i)
+		if tok := p.peek(); len(p.toks) == 2 && p.toks[1] == ":" {
+			cur = block(tok)
+			if labeled[cur] {
+				p.errorf("block %s redeclared", tok)
+			}
+			labeled[cur] = true
+			fn.Blocks = append(fn.Blocks, cur)
+			continue
+		}
+		if cur == nil {
+			p.errorf("instruction outside a block")
+		}
+		instr := &Instr{Block: cur}
+		name := ""
+		if p.peek() == "%" {
+			p.next()
+			name = p.next()
+			if values[name] != nil {
+				p.errorf("%%%s redefined", name)
+			}
+			p.expect("=")
+		}
+		p.instr(instr, name != "", block, value)
+		if name != "" {
+			values[name] = instr
+		}
+		p.end()
+		cur.Instrs = append(cur.Instrs, instr)
+	}
+	for _, f := range forwards {
+		v := values[f.name]
+		if v == nil {
+			p.line = f.line
+			p.errorf("undefined value %%%s", f.name)
+		}
+		f.instr.Args[f.arg] = v
+	}
+	for name, b := range blocks {
+		if !labeled[b] {
+			p.errorf("undefined block %s in %s", name, fn.Name)
+		}
+	}
+	fn.Update()
+}
+
+// instr parses the operation of an instruction and its operands, after
+// the value it defines, if any.
+func (p *parser) instr(instr *Instr, defines bool, block func(string) *Block, value func(*Instr, int)) {
+	opName := p.next()
+	if name, ok := strings.CutSuffix(opName, ".stack"); ok && (name == OpTuple.String() || name == OpUpdate.String()) {
+		opName, instr.Stack = name, true
+	}
+	if name, ok := strings.CutSuffix(opName, ".rec"); ok && name == OpFunc.String() {
+		opName, instr.Rec = name, true
+	}
+	op := OpInvalid
+	for o, name := range opNames {
+		if name == opName {
+			op = Op(o)
+		}
+	}
+	if op == OpInvalid {
+		p.errorf("unknown operation %s", opName)
+	}
+	instr.Op = op
+	hasType := defines && op != OpCall
+
+	if op == OpCall {
+		switch effect := p.next(); effect {
+		case "fn":
+		case "fx":
+			instr.Fx = true
+		default:
+			p.errorf("expected fn or fx, got %s", effect)
+		}
+		if defines {
+			instr.Typ = p.typ()
+		}
+		if p.accept("@") {
+			p.callee(instr, p.next())
+		} else {
+			instr.Args = append(instr.Args, nil)
+			value(instr, 0)
+		}
+		p.expect("(")
+		for n := 0; !p.accept(")"); n++ {
+			if n > 0 {
+				p.expect(",")
+			}
+			instr.Args = append(instr.Args, nil)
+			value(instr, len(instr.Args)-1)
+		}
+		return
+	}
+	if hasType {
+		instr.Typ = p.typ()
+	} else if defines {
+		p.errorf("%s defines no value", op)
+	}
+
+	switch op {
+	case OpConst:
+		instr.Const = p.constant(instr.Typ)
+		return
+	case OpFunc:
+		p.expect("@")
+		instr.Callee = p.next()
+		if p.accept("(") {
+			for n := 0; !p.accept(")"); n++ {
+				if n > 0 {
+					p.expect(",")
+				}
+				instr.Args = append(instr.Args, nil)
+				value(instr, len(instr.Args)-1)
+			}
+		}
+		return
+	case OpTrap:
+		msg, err := strconv.Unquote(p.next())
+		if err != nil {
+			p.errorf("invalid trap message")
+		}
+		instr.Msg = msg
+		return
+	}
+	for n := 0; p.peek() != ""; n++ {
+		if n > 0 {
+			p.expect(",")
+		}
+		switch tok := p.peek(); {
+		case op == OpPhi:
+			instr.Blocks = append(instr.Blocks, block(p.next()))
+			p.expect(":")
+			instr.Args = append(instr.Args, nil)
+			value(instr, len(instr.Args)-1)
+		case tok == "%":
+			instr.Args = append(instr.Args, nil)
+			value(instr, len(instr.Args)-1)
+		case isBlockName(tok):
+			instr.Blocks = append(instr.Blocks, block(p.next()))
+		default:
+			index, err := strconv.Atoi(p.next())
+			if err != nil {
+				p.errorf("unexpected %s", tok)
+			}
+			instr.Index = index
+		}
+	}
+}
+
+// callee sets the function instr calls directly to the one named name, or
+// for a call of a generic function kept generic, written as an instance
+// is, to that function and the type arguments in brackets.
+func (p *parser) callee(instr *Instr, name string) {
+	instr.Callee = name
+	i := strings.IndexByte(name, '[')
+	if p.funcs[name] != nil || i < 0 || p.funcs[name[:i]] == nil || p.funcs[name[:i]].TypeParams == nil {
+		return
+	}
+	instr.Callee = name[:i]
+	toks, pos := p.toks, p.pos
+	p.toks, p.pos = tokenize(p, name[i+1:len(name)-1]), 0
+	for len(instr.TypeArgs) == 0 || p.accept(",") {
+		instr.TypeArgs = append(instr.TypeArgs, p.typ())
+	}
+	p.end()
+	p.toks, p.pos = toks, pos
+}
+
+func isBlockName(tok string) bool {
+	if len(tok) < 2 || tok[0] != 'b' {
+		return false
+	}
+	_, err := strconv.Atoi(tok[1:])
+	return err == nil
+}
+
+// constant parses a constant of type typ.
+func (p *parser) constant(typ types.Type) consteval.Value {
+	tok := p.next()
+	switch {
+	case typ == types.ErrorType:
+		if tok == "error" && p.accept("(") {
+			msg, err := strconv.Unquote(p.next())
+			p.expect(")")
+			if err == nil {
+				return &consteval.ErrorValue{Msg: msg}
+			}
+		}
+	case types.IsEnum(typ):
+		name := tok[strings.LastIndexByte(tok, '.')+1:]
+		if member := typ.Underlying().(*types.Enum).Member(name); member != nil && tok == typ.String()+"."+name {
+			return &consteval.Enum{Typ: typ, Member: member}
+		}
+	case types.IsInteger(typ):
+		if n, ok := new(big.Int).SetString(tok, 10); ok {
+			return &consteval.Int{Val: n, Typ: typ}
+		}
+	case types.IsFloat(typ):
+		if f, err := strconv.ParseFloat(tok, 64); err == nil {
+			return &consteval.Float{Val: f, Typ: typ}
+		}
+	case types.IsBool(typ):
+		if tok == "true" || tok == "false" {
+			return consteval.Bool(tok == "true")
+		}
+	case types.IsString(typ):
+		if s, err := strconv.Unquote(tok); err == nil {
+			return consteval.String(s)
+		}
+	case types.Identical(typ, types.Typ[types.Symbol]):
+		if tok == ":" {
+			return consteval.Symbol(p.next())
+		}
+	case types.Identical(typ, types.Typ[types.Nil]):
+		if tok == "nil" {
+			return consteval.Nil{}
+		}
+	case types.Identical(typ, types.Typ[types.TypeDescriptor]):
+		if tok == "typeof" && p.accept("(") {
+			described := p.typ()
+			p.expect(")")
+			return &consteval.TypeValue{Typ: described}
+		}
+	}
+	p.errorf("invalid constant %s of type %s", tok, typ)
+	return nil
+}
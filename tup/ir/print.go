@@ -0,0 +1,287 @@
+package ir
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// The text of a module lists the named types it uses, then its functions:
+//
+//	module fib
+//
+//	type Point = (x: Int, y: Int)
+//
+//	declare fx @print(String)
+//
+//	fn @inc(%n: Int) Int {
+//	b0:
+//	  %0 = const Int 1
+//	  %1 = add Int %n, %0
+//	  ret %1
+//	}
+//
+// Each instruction defining a value is written as %id = op Type operands,
+// with the operands separated by commas, and tuples kept in the frame as
+// tuple.stack or update.stack, and closures capturing themselves as
+// func.rec. Blocks, the indices of fields and members and the messages of
+// traps are written among the operands; phi operands are written as block:
+// value. Calls are written as call fn|fx Type @f(args), or with a function
+// value %f in place of @f, and without the type if they define no value. A
+// generic function kept generic is written fn[a, b] @f(...), and its calls
+// @f[Type, Type](args).
+
+// String returns the text of m.
+func (m *Module) String() string {
+	var builder strings.Builder
+	Fprint(&builder, m)
+	return builder.String()
+}
+
+// Fprint writes the text of m to w. The values of each function are
+// numbered as by Func.Update.
+func Fprint(w io.Writer, m *Module) error {
+	p := &printer{w: w}
+	p.printf("module %s\n", m.Name)
+	named := NamedTypes(m)
+	if len(named) > 0 {
+		p.printf("\n")
+	}
+	for _, n := range named {
+		p.printf("type %s", n.Name())
+		for _, a := range n.Annotations() {
+			p.printf(" @%s", a)
+		}
+		p.printf(" = %s\n", n.Underlying())
+	}
+	for _, fn := range m.Funcs {
+		p.printf("\n")
+		p.fn(fn)
+	}
+	return p.err
+}
+
+// String returns the text of fn.
+func (fn *Func) String() string {
+	var builder strings.Builder
+	p := &printer{w: &builder}
+	p.fn(fn)
+	return builder.String()
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+func (p *printer) fn(fn *Func) {
+	effect := "fn"
+	if fn.Sig.HasSideEffects {
+		effect = "fx"
+	}
+	if fn.Extern() {
+		p.printf("declare %s @%s(", effect, fn.Name)
+		for i, param := range fn.Sig.Params {
+			if i > 0 {
+				p.printf(", ")
+			}
+			p.printf("%s", paramType(fn.Sig, i, param.Type))
+		}
+		p.printf(")%s\n", result(fn.Sig))
+		return
+	}
+
+	fn.Update()
+	if fn.Export {
+		p.printf("export ")
+	}
+	if fn.TypeParams != nil {
+		effect += typeList(fn.TypeParams)
+	}
+	p.printf("%s @%s(", effect, fn.Name)
+	for i, param := range fn.Params {
+		if i > 0 {
+			p.printf(", ")
+		}
+		p.printf("%s: %s", param, paramType(fn.Sig, i, param.Typ))
+	}
+	p.printf(")%s {\n", result(fn.Sig))
+	for _, b := range fn.Blocks {
+		p.printf("%s:\n", b)
+		for _, instr := range b.Instrs {
+			p.printf("  %s\n", instr.Format())
+		}
+	}
+	p.printf("}\n")
+}
+
+// paramType returns the type of the i'th parameter of sig as it is
+// written in a signature: the element type for a rest parameter.
+func paramType(sig *types.Function, i int, typ types.Type) string {
+	if array, ok := typ.(*types.Array); ok && i == sig.Rest {
+		return "..." + array.Elem.String()
+	}
+	return typ.String()
+}
+
+// typeList returns the text of typs between brackets, or nothing if there
+// are none.
+func typeList[T types.Type](typs []T) string {
+	if len(typs) == 0 {
+		return ""
+	}
+	texts := make([]string, len(typs))
+	for i, t := range typs {
+		texts[i] = t.String()
+	}
+	return "[" + strings.Join(texts, ", ") + "]"
+}
+
+func result(sig *types.Function) string {
+	if sig.Result == nil {
+		return ""
+	}
+	return " " + sig.Result.String()
+}
+
+// Format returns the text of instr.
+func (instr *Instr) Format() string {
+	var builder strings.Builder
+	if instr.Typ != nil {
+		fmt.Fprintf(&builder, "%s = ", instr)
+	}
+	builder.WriteString(instr.Op.String())
+	if instr.Stack {
+		builder.WriteString(".stack")
+	}
+	if instr.Rec {
+		builder.WriteString(".rec")
+	}
+
+	if instr.Op == OpCall {
+		if instr.Fx {
+			builder.WriteString(" fx")
+		} else {
+			builder.WriteString(" fn")
+		}
+		if instr.Typ != nil {
+			fmt.Fprintf(&builder, " %s", instr.Typ)
+		}
+		args := instr.Args
+		if instr.Callee != "" {
+			fmt.Fprintf(&builder, " @%s%s(", instr.Callee, typeList(instr.TypeArgs))
+		} else {
+			fmt.Fprintf(&builder, " %s(", args[0])
+			args = args[1:]
+		}
+		for i, arg := range args {
+			if i > 0 {
+				builder.WriteString(", ")
+			}
+			builder.WriteString(arg.String())
+		}
+		builder.WriteString(")")
+		return builder.String()
+	}
+
+	if instr.Typ != nil {
+		fmt.Fprintf(&builder, " %s", instr.Typ)
+	}
+	var operands []string
+	switch instr.Op {
+	case OpConst:
+		operands = append(operands, instr.Const.String())
+	case OpFunc:
+		callee := "@" + instr.Callee
+		if len(instr.Args) > 0 {
+			args := make([]string, len(instr.Args))
+			for i, arg := range instr.Args {
+				args[i] = arg.String()
+			}
+			callee += "(" + strings.Join(args, ", ") + ")"
+		}
+		operands = append(operands, callee)
+	case OpPhi:
+		for i, arg := range instr.Args {
+			operands = append(operands, fmt.Sprintf("%s: %s", instr.Blocks[i], arg))
+		}
+	case OpTrap:
+		operands = append(operands, fmt.Sprintf("%q", instr.Msg))
+	default:
+		for _, arg := range instr.Args {
+			operands = append(operands, arg.String())
+		}
+		switch instr.Op {
+		case OpField, OpUpdate, OpWrap, OpPayload:
+			operands = append(operands, fmt.Sprint(instr.Index))
+		}
+		for _, b := range instr.Blocks {
+			operands = append(operands, b.String())
+		}
+	}
+	if len(operands) > 0 {
+		builder.WriteString(" ")
+		builder.WriteString(strings.Join(operands, ", "))
+	}
+	return builder.String()
+}
+
+// NamedTypes returns the named types used by m, other than the
+// predeclared error type, in the order they are first used.
+func NamedTypes(m *Module) []*types.Named {
+	var named []*types.Named
+	seen := map[types.Type]bool{}
+	var visit func(t types.Type)
+	visit = func(t types.Type) {
+		if t == nil || seen[t] {
+			return
+		}
+		seen[t] = true
+		switch t := t.(type) {
+		case *types.Named:
+			if t != types.ErrorType {
+				named = append(named, t)
+			}
+			visit(t.Underlying())
+		case *types.Tuple:
+			for _, f := range t.Fields {
+				visit(f.Type)
+			}
+		case *types.Array:
+			visit(t.Elem)
+		case *types.Union:
+			for _, member := range t.Members {
+				visit(member)
+			}
+		case *types.Function:
+			for _, param := range t.Params {
+				visit(param.Type)
+			}
+			visit(t.Result)
+		}
+	}
+	for _, fn := range m.Funcs {
+		visit(fn.Sig)
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				visit(instr.Typ)
+				if instr.Const != nil {
+					visit(instr.Const.Type())
+				}
+				if d, ok := instr.Const.(*consteval.TypeValue); ok {
+					visit(d.Typ)
+				}
+			}
+		}
+	}
+	return named
+}
@@ -0,0 +1,147 @@
+package ir
+
+import (
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// block lowers a block in a new scope nested in parent.
+func (f *funcLowerer) block(parent *scope, block *ast.Block) Value {
+	if block == nil {
+		return f.nilValue()
+	}
+	return f.body(newScope(parent), block.Body)
+}
+
+// body lowers the statements of a block body in s, then its final
+// expression, if any, which is its value.
+func (f *funcLowerer) body(s *scope, body *ast.BlockBody) Value {
+	if body == nil {
+		return f.nilValue()
+	}
+	return f.statements(s, body.Statements, body.Expression)
+}
+
+func (f *funcLowerer) statements(s *scope, stmts []ast.Statement, final ast.Expression) Value {
+	for _, stmt := range stmts {
+		f.stmt(s, stmt)
+	}
+	if final == nil {
+		return f.nilValue()
+	}
+	return f.expr(s, final)
+}
+
+func (f *funcLowerer) stmt(s *scope, stmt ast.Statement) {
+	defer f.at(stmt)()
+	switch stmt := stmt.(type) {
+	case *ast.Assignment:
+		v := f.expr(s, stmt.Right)
+		f.destructure(stmt, stmt.Left, v, func(ident *ast.Identifier, v Value) {
+			f.define(s, ident.Name, v)
+		})
+	case *ast.CompoundAssignment:
+		v := s.lookup(stmt.Left.Name)
+		if v == nil {
+			f.errorf(stmt.Left, "cannot assign to %s", stmt.Left.Name)
+		}
+		op := strings.TrimSuffix(stmt.Operator.String(), "=")
+		x := f.read(v)
+		var result Value
+		if array, ok := v.typ.Underlying().(*types.Array); ok && op == "<<" {
+			result = f.op(OpAppend, v.typ, x, f.coerce(stmt.Right, f.expr(s, stmt.Right), array.Elem))
+		} else {
+			y := f.coerce(stmt.Right, f.expr(s, stmt.Right), v.typ)
+			irOp, ok := binaryOps[op]
+			if !ok {
+				f.errorf(stmt, "operator %s is not supported by the IR", stmt.Operator)
+			}
+			result = f.op(irOp, v.typ, x, y)
+		}
+		f.write(v, result)
+	case *ast.FunctionDeclaration:
+		f.localFunc(s, stmt)
+	case *ast.TypeDeclaration, *ast.TypeQualifiedDeclaration, *ast.TypeQualifiedFunctionDeclaration:
+		// types have no run-time effect
+	case ast.Expression:
+		f.expr(s, stmt)
+	default:
+		f.errorf(stmt, "%s is not supported by the IR", stmt)
+	}
+}
+
+// define binds name in s to a new variable holding v.
+func (f *funcLowerer) define(s *scope, name string, v Value) *variable {
+	variable := &variable{name: name, typ: v.Type()}
+	s.vars[name] = variable
+	f.write(variable, v)
+	return variable
+}
+
+// destructure calls bind with each identifier of lhs and the part of v it
+// receives, as consteval.Bind does for values.
+func (f *funcLowerer) destructure(node ast.Node, lhs ast.AssignmentLHS, v Value, bind func(*ast.Identifier, Value)) {
+	bindOne := func(ident *ast.Identifier, v Value) {
+		if ident != nil && ident.Name != "_" {
+			bind(ident, v)
+		}
+	}
+
+	switch lhs := lhs.(type) {
+	case *ast.OrdinalAssignmentLHS:
+		if len(lhs.Identifiers) == 1 && lhs.RestOperator == nil {
+			bindOne(lhs.Identifiers[0], v)
+			return
+		}
+		tuple, ok := v.Type().Underlying().(*types.Tuple)
+		n := len(lhs.Identifiers)
+		if !ok || len(tuple.Fields) < n || lhs.RestOperator == nil && len(tuple.Fields) != n {
+			f.errorf(node, "cannot destructure %s into %d variables", v.Type(), n)
+		}
+		for i, ident := range lhs.Identifiers {
+			if ident != nil && ident.Name != "_" {
+				bind(ident, f.field(v, i, tuple.Fields[i].Type))
+			}
+		}
+		if lhs.RestOperator != nil && lhs.RestOperator.Identifier != nil {
+			rest := types.NewTuple(tuple.Fields[n:]...)
+			fields := make([]Value, len(rest.Fields))
+			for i, field := range rest.Fields {
+				fields[i] = f.field(v, n+i, field.Type)
+			}
+			bind(lhs.RestOperator.Identifier, f.op(OpTuple, rest, fields...))
+		}
+	case *ast.LabeledAssignmentLHS:
+		tuple, ok := v.Type().Underlying().(*types.Tuple)
+		if !ok {
+			f.errorf(node, "cannot destructure %s: not a tuple", v.Type())
+		}
+		labeled := false
+		for _, field := range tuple.Fields {
+			labeled = labeled || field.Name != ""
+		}
+		for i, rename := range lhs.Renames {
+			rename, ok := rename.(*ast.RenameIdentifier)
+			if !ok {
+				continue
+			}
+			name := rename.Identifier.Name
+			if rename.Original != nil {
+				name = rename.Original.Name
+			}
+			index := tuple.FieldIndex(name)
+			if !labeled && index < 0 {
+				// unlabeled tuples are destructured by position
+				index = i
+			}
+			if index < 0 || index >= len(tuple.Fields) {
+				f.errorf(node, "%s has no field %s", v.Type(), name)
+			}
+			bindOne(rename.Identifier, f.field(v, index, tuple.Fields[index].Type))
+		}
+	default:
+		f.errorf(node, "cannot destructure %s", v.Type())
+	}
+}
@@ -0,0 +1,86 @@
+package ir
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Canonical returns the type the IR gives to values of type t. Untyped
+// types take their default types, and the members of unions not declared
+// by a named type are sorted by their text, so that identical union types
+// number their members alike and a member index means the same member in
+// both. Named types are returned unchanged.
+func Canonical(t types.Type) types.Type {
+	switch t := t.(type) {
+	case *types.Basic:
+		return types.Default(t)
+	case *types.Tuple:
+		fields := make([]*types.Field, len(t.Fields))
+		changed := false
+		for i, f := range t.Fields {
+			fields[i] = f
+			if typ := Canonical(f.Type); typ != f.Type {
+				fields[i] = types.NewField(f.Name, typ)
+				changed = true
+			}
+		}
+		if !changed {
+			return t
+		}
+		return types.NewTuple(fields...)
+	case *types.Array:
+		if elem := Canonical(t.Elem); elem != t.Elem {
+			return &types.Array{Elem: elem, Len: t.Len}
+		}
+		return t
+	case *types.Union:
+		members := make([]types.Type, len(t.Members))
+		for i, m := range t.Members {
+			members[i] = Canonical(m)
+		}
+		u, ok := types.NewUnion(members...).(*types.Union)
+		if !ok {
+			return types.NewUnion(members...)
+		}
+		slices.SortStableFunc(u.Members, func(x, y types.Type) int {
+			return strings.Compare(x.String(), y.String())
+		})
+		if slices.Equal(u.Members, t.Members) {
+			return t
+		}
+		return u
+	case *types.Function:
+		params := make([]*types.Field, len(t.Params))
+		for i, p := range t.Params {
+			params[i] = types.NewField(p.Name, Canonical(p.Type))
+		}
+		var result types.Type
+		if t.Result != nil {
+			result = Canonical(t.Result)
+		}
+		sig := types.NewFunction(params, result, t.HasSideEffects)
+		sig.Rest = t.Rest
+		return sig
+	}
+	return t
+}
+
+// MemberIndex returns the index of the member of the union u identical to
+// t, or -1.
+func MemberIndex(u *types.Union, t types.Type) int {
+	for i, m := range u.Members {
+		if types.Identical(m, t) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isErrorType reports whether values of type t are errors: the
+// predeclared error type and the types declared with error(...).
+func isErrorType(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.HasAnnotation("error")
+}
@@ -0,0 +1,560 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Verify checks that m is well formed: that each block ends with a single
+// terminator and begins with its phis, which have one operand for each
+// predecessor; that each operand is defined in its function by a
+// definition dominating the use; and that the types of the operands and
+// results agree with each operation and with the signatures of the
+// functions called. It returns the problems found, joined, or nil.
+func Verify(m *Module) error {
+	v := &verifier{m: m, funcs: map[string]*Func{}}
+	for _, fn := range m.Funcs {
+		if v.funcs[fn.Name] != nil {
+			v.errs = append(v.errs, fmt.Errorf("function @%s redeclared", fn.Name))
+		}
+		v.funcs[fn.Name] = fn
+	}
+	for _, fn := range m.Funcs {
+		if !fn.Extern() {
+			v.function(fn)
+		}
+	}
+	return errors.Join(v.errs...)
+}
+
+type verifier struct {
+	m     *Module
+	funcs map[string]*Func
+	errs  []error
+
+	fn  *Func
+	dom *DomTree
+	// position of each instruction of fn in its block
+	pos map[*Instr]int
+}
+
+func (v *verifier) errorf(instr *Instr, format string, args ...any) {
+	where := "@" + v.fn.Name
+	if instr != nil && instr.Block != nil {
+		where += ": " + instr.Block.String() + ": " + instr.Format()
+	}
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", where, fmt.Sprintf(format, args...)))
+}
+
+func (v *verifier) function(fn *Func) {
+	v.fn = fn
+	fn.Update()
+	if len(fn.Blocks) == 0 {
+		v.errorf(nil, "function has no blocks")
+		return
+	}
+	if len(fn.Params) != len(fn.Sig.Params) {
+		v.errorf(nil, "%d parameters, signature has %d", len(fn.Params), len(fn.Sig.Params))
+	}
+	for i, param := range fn.Params {
+		if i < len(fn.Sig.Params) && !types.Identical(param.Typ, fn.Sig.Params[i].Type) {
+			v.errorf(nil, "parameter %s has type %s, signature has %s", param, param.Typ, fn.Sig.Params[i].Type)
+		}
+	}
+	if len(fn.Blocks[0].Preds) > 0 {
+		v.errorf(nil, "entry block %s has predecessors", fn.Blocks[0])
+	}
+
+	v.pos = map[*Instr]int{}
+	for _, b := range fn.Blocks {
+		if len(b.Instrs) == 0 {
+			v.errorf(nil, "block %s is empty", b)
+			continue
+		}
+		phis := true
+		for i, instr := range b.Instrs {
+			v.pos[instr] = i
+			if instr.Op == OpPhi && !phis {
+				v.errorf(instr, "phi after other instructions")
+			}
+			phis = phis && instr.Op == OpPhi
+			last := i == len(b.Instrs)-1
+			if instr.Op.IsTerminator() && !last {
+				v.errorf(instr, "terminator before the end of the block")
+			}
+			if !instr.Op.IsTerminator() && last {
+				v.errorf(instr, "block %s does not end with a terminator", b)
+			}
+		}
+	}
+
+	v.dom = Dominators(fn)
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			v.operands(instr)
+			v.types(instr)
+		}
+	}
+}
+
+// operands checks that the operands of instr are defined in the function
+// by definitions dominating their use.
+func (v *verifier) operands(instr *Instr) {
+	for i, arg := range instr.Args {
+		switch arg := arg.(type) {
+		case nil:
+			v.errorf(instr, "missing operand %d", i)
+		case *Param:
+			found := false
+			for _, param := range v.fn.Params {
+				found = found || param == arg
+			}
+			if !found {
+				v.errorf(instr, "operand %s is a parameter of another function", arg)
+			}
+		case *Instr:
+			if _, ok := v.pos[arg]; !ok || arg.Block.Func != v.fn {
+				v.errorf(instr, "operand %s is not defined in the function", arg)
+				continue
+			}
+			if arg.Typ == nil {
+				v.errorf(instr, "operand %s defines no value", arg)
+				continue
+			}
+			use, at := instr.Block, v.pos[instr]
+			if instr.Op == OpPhi {
+				if i >= len(instr.Blocks) {
+					continue
+				}
+				// the operand is used at the end of the predecessor
+				use = instr.Blocks[i]
+				at = len(use.Instrs)
+			}
+			if !v.dom.Reachable(use) {
+				continue
+			}
+			if !v.dominates(arg, use, at) {
+				v.errorf(instr, "operand %s does not dominate its use", arg)
+			}
+		}
+	}
+}
+
+// dominates reports whether the definition def dominates a use at position
+// at of block b.
+func (v *verifier) dominates(def *Instr, b *Block, at int) bool {
+	if def.Op.IsChecked() {
+		// the result is defined on entry to the block that continues
+		// without overflow
+		return len(def.Blocks) > 0 && v.dom.Dominates(def.Blocks[0], b)
+	}
+	if def.Block == b {
+		return v.pos[def] < at
+	}
+	return v.dom.Dominates(def.Block, b)
+}
+
+// types checks the operands, result and successors of instr against its
+// operation.
+func (v *verifier) types(instr *Instr) {
+	for _, arg := range instr.Args {
+		if arg == nil || arg.Type() == nil {
+			return
+		}
+	}
+	nargs := func(n int) bool {
+		if len(instr.Args) != n {
+			v.errorf(instr, "%d operands, want %d", len(instr.Args), n)
+			return false
+		}
+		return true
+	}
+	nblocks := func(n int) {
+		if len(instr.Blocks) != n {
+			v.errorf(instr, "%d successors, want %d", len(instr.Blocks), n)
+		}
+	}
+	typed := func() bool {
+		if instr.Typ == nil {
+			v.errorf(instr, "%s must define a value", instr.Op)
+			return false
+		}
+		return true
+	}
+	same := func(t types.Type, what string) {
+		for _, arg := range instr.Args {
+			if !types.Identical(arg.Type(), t) {
+				v.errorf(instr, "operand %s has type %s, want %s %s", arg, arg.Type(), what, t)
+			}
+		}
+	}
+	if instr.Op.IsTerminator() && !instr.Op.IsChecked() && instr.Typ != nil {
+		v.errorf(instr, "%s defines no value", instr.Op)
+	}
+	if instr.Op != OpPhi && !instr.Op.IsTerminator() && len(instr.Blocks) > 0 {
+		v.errorf(instr, "%s has no successors", instr.Op)
+	}
+	if instr.Stack && instr.Op != OpTuple && instr.Op != OpUpdate {
+		v.errorf(instr, "only tuples are kept in the frame")
+	}
+	if instr.Rec && (instr.Op != OpFunc || len(instr.Args) == 0) {
+		v.errorf(instr, "only closures capture themselves")
+	}
+
+	switch op := instr.Op; {
+	case op == OpConst:
+		if typed() && nargs(0) {
+			if instr.Const == nil || !types.Identical(instr.Const.Type(), instr.Typ) {
+				v.errorf(instr, "constant of type %s", instr.Typ)
+			}
+		}
+	case op == OpFunc:
+		if typed() {
+			v.closure(instr)
+		}
+	case op == OpUndef:
+		if typed() {
+			nargs(0)
+		}
+	case op.IsBinary(), op == OpNeg, op == OpNot, op.IsChecked():
+		n := 2
+		if op == OpNeg || op == OpNot {
+			n = 1
+		}
+		if !typed() || !nargs(n) {
+			return
+		}
+		same(instr.Typ, "the result type")
+		ok := types.IsNumeric(instr.Typ)
+		switch op.Unchecked() {
+		case OpAdd:
+			ok = ok || types.IsString(instr.Typ)
+		case OpAnd, OpOr, OpXor, OpNot:
+			ok = types.IsInteger(instr.Typ) || types.IsBool(instr.Typ)
+		case OpShl, OpShr:
+			ok = types.IsInteger(instr.Typ)
+		}
+		if op.IsChecked() {
+			ok = types.IsNumeric(instr.Typ)
+		}
+		if !ok {
+			v.errorf(instr, "%s is not defined on %s", op, instr.Typ)
+		}
+		if op.IsChecked() {
+			nblocks(2)
+			if len(instr.Blocks) == 2 {
+				if ok := instr.Blocks[0]; ok == instr.Blocks[1] || len(ok.Preds) != 1 {
+					v.errorf(instr, "block %s must have the checked operation as its only predecessor", ok)
+				}
+			}
+		}
+	case op.IsComparison():
+		if !typed() || !nargs(2) {
+			return
+		}
+		same(instr.Args[0].Type(), "the type of")
+		want := types.Type(types.Typ[types.Bool])
+		if op == OpCmp {
+			want = types.Int
+		}
+		v.result(instr, want)
+	case op == OpStr:
+		if typed() && nargs(1) {
+			v.result(instr, types.Typ[types.String])
+		}
+	case op == OpOrd:
+		if typed() && nargs(1) {
+			if !types.IsEnum(instr.Args[0].Type()) {
+				v.errorf(instr, "operand is not an enum")
+			}
+			v.result(instr, types.Int)
+		}
+	case op == OpConv:
+		if typed() && nargs(1) {
+			if !types.IsInteger(instr.Args[0].Type()) {
+				v.errorf(instr, "operand is not an integer")
+			}
+			if !types.IsInteger(instr.Typ) {
+				v.errorf(instr, "%s is not defined on %s", op, instr.Typ)
+			}
+		}
+	case op == OpTuple:
+		if !typed() {
+			return
+		}
+		tuple, ok := instr.Typ.Underlying().(*types.Tuple)
+		if !ok {
+			v.errorf(instr, "type is not a tuple")
+			return
+		}
+		if nargs(len(tuple.Fields)) {
+			for i, arg := range instr.Args {
+				if !types.Identical(arg.Type(), tuple.Fields[i].Type) {
+					v.errorf(instr, "field %d has type %s, want %s", i, arg.Type(), tuple.Fields[i].Type)
+				}
+			}
+		}
+	case op == OpField:
+		if !typed() || !nargs(1) {
+			return
+		}
+		tuple, ok := instr.Args[0].Type().Underlying().(*types.Tuple)
+		if !ok || instr.Index < 0 || instr.Index >= len(tuple.Fields) {
+			v.errorf(instr, "%s has no field %d", instr.Args[0].Type(), instr.Index)
+			return
+		}
+		v.result(instr, tuple.Fields[instr.Index].Type)
+	case op == OpUpdate:
+		if !typed() || !nargs(2) {
+			return
+		}
+		tuple, ok := instr.Args[0].Type().Underlying().(*types.Tuple)
+		if !ok || instr.Index < 0 || instr.Index >= len(tuple.Fields) {
+			v.errorf(instr, "%s has no field %d", instr.Args[0].Type(), instr.Index)
+			return
+		}
+		if !types.Identical(instr.Args[1].Type(), tuple.Fields[instr.Index].Type) {
+			v.errorf(instr, "field %d has type %s, want %s", instr.Index, instr.Args[1].Type(), tuple.Fields[instr.Index].Type)
+		}
+		v.result(instr, instr.Args[0].Type())
+	case op == OpArray:
+		if !typed() {
+			return
+		}
+		array, ok := instr.Typ.Underlying().(*types.Array)
+		if !ok {
+			v.errorf(instr, "type is not an array")
+			return
+		}
+		if array.Fixed() && int64(len(instr.Args)) != array.Len {
+			v.errorf(instr, "%d elements, want %d", len(instr.Args), array.Len)
+		}
+		same(array.Elem, "the element type")
+	case op == OpIndex:
+		if !typed() || !nargs(2) {
+			return
+		}
+		if !types.IsInteger(instr.Args[1].Type()) {
+			v.errorf(instr, "index %s is not an integer", instr.Args[1])
+		}
+		switch x := instr.Args[0].Type().Underlying().(type) {
+		case *types.Array:
+			v.result(instr, x.Elem)
+		default:
+			if !types.IsString(x) {
+				v.errorf(instr, "cannot index %s", x)
+				return
+			}
+			v.result(instr, types.Byte)
+		}
+	case op == OpLen:
+		if !typed() || !nargs(1) {
+			return
+		}
+		if _, ok := instr.Args[0].Type().Underlying().(*types.Array); !ok && !types.IsString(instr.Args[0].Type()) {
+			v.errorf(instr, "cannot take the length of %s", instr.Args[0].Type())
+		}
+		v.result(instr, types.Int)
+	case op == OpAppend:
+		if !typed() || !nargs(2) {
+			return
+		}
+		array, ok := instr.Typ.Underlying().(*types.Array)
+		if !ok || array.Fixed() {
+			v.errorf(instr, "type is not a dynamic array")
+			return
+		}
+		if !types.Identical(instr.Args[0].Type(), instr.Typ) || !types.Identical(instr.Args[1].Type(), array.Elem) {
+			v.errorf(instr, "cannot append %s to %s", instr.Args[1].Type(), instr.Args[0].Type())
+		}
+	case op == OpSlice:
+		if !typed() || !nargs(3) {
+			return
+		}
+		if !types.IsInteger(instr.Args[1].Type()) || !types.IsInteger(instr.Args[2].Type()) {
+			v.errorf(instr, "bounds %s and %s are not integers", instr.Args[1], instr.Args[2])
+		}
+		switch x := instr.Args[0].Type().Underlying().(type) {
+		case *types.Array:
+			if array, ok := instr.Typ.Underlying().(*types.Array); !ok || array.Fixed() || !types.Identical(array.Elem, x.Elem) {
+				v.errorf(instr, "result has type %s, want a dynamic array of %s", instr.Typ, x.Elem)
+			}
+		default:
+			if !types.IsString(x) {
+				v.errorf(instr, "cannot slice %s", x)
+				return
+			}
+			if !types.IsString(instr.Typ) {
+				v.errorf(instr, "result has type %s, want a string", instr.Typ)
+			}
+		}
+	case op == OpWrap:
+		if !typed() || !nargs(1) {
+			return
+		}
+		member := v.member(instr, instr.Typ)
+		if member != nil && !types.Identical(instr.Args[0].Type(), member) {
+			v.errorf(instr, "operand has type %s, want %s", instr.Args[0].Type(), member)
+		}
+	case op == OpTag:
+		if typed() && nargs(1) {
+			if _, ok := instr.Args[0].Type().Underlying().(*types.Union); !ok {
+				v.errorf(instr, "operand is not a union")
+			}
+			v.result(instr, types.Int)
+		}
+	case op == OpPayload:
+		if typed() && nargs(1) {
+			if member := v.member(instr, instr.Args[0].Type()); member != nil {
+				v.result(instr, member)
+			}
+		}
+	case op == OpCall:
+		v.call(instr)
+	case op == OpPhi:
+		if !typed() {
+			return
+		}
+		if len(instr.Args) != len(instr.Blocks) {
+			v.errorf(instr, "%d operands for %d blocks", len(instr.Args), len(instr.Blocks))
+			return
+		}
+		same(instr.Typ, "the result type")
+		seen := map[*Block]bool{}
+		for _, b := range instr.Blocks {
+			if seen[b] {
+				v.errorf(instr, "block %s repeated", b)
+			}
+			seen[b] = true
+		}
+		for _, pred := range instr.Block.Preds {
+			if !seen[pred] {
+				v.errorf(instr, "no operand for predecessor %s", pred)
+			}
+			delete(seen, pred)
+		}
+		for b := range seen {
+			v.errorf(instr, "%s is not a predecessor", b)
+		}
+	case op == OpJump:
+		nargs(0)
+		nblocks(1)
+	case op == OpBr:
+		nblocks(2)
+		if nargs(1) && !types.IsBool(instr.Args[0].Type()) {
+			v.errorf(instr, "condition %s is not a Bool", instr.Args[0])
+		}
+	case op == OpRet:
+		nblocks(0)
+		if v.fn.Sig.Result == nil {
+			nargs(0)
+		} else if nargs(1) && !types.Identical(instr.Args[0].Type(), v.fn.Sig.Result) {
+			v.errorf(instr, "returns %s, want %s", instr.Args[0].Type(), v.fn.Sig.Result)
+		}
+	case op == OpTrap:
+		nargs(0)
+		nblocks(0)
+	default:
+		v.errorf(instr, "invalid operation")
+	}
+}
+
+func (v *verifier) result(instr *Instr, want types.Type) {
+	if !types.Identical(instr.Typ, want) {
+		v.errorf(instr, "result has type %s, want %s", instr.Typ, want)
+	}
+}
+
+// member returns member Index of the union type typ, reporting an error if
+// there is none.
+func (v *verifier) member(instr *Instr, typ types.Type) types.Type {
+	union, ok := typ.Underlying().(*types.Union)
+	if !ok {
+		v.errorf(instr, "%s is not a union", typ)
+		return nil
+	}
+	if instr.Index < 0 || instr.Index >= len(union.Members) {
+		v.errorf(instr, "%s has no member %d", typ, instr.Index)
+		return nil
+	}
+	return union.Members[instr.Index]
+}
+
+// closure checks that the function a func instruction names takes the
+// values it captures ahead of the parameters of its type.
+func (v *verifier) closure(instr *Instr) {
+	fn := v.funcs[instr.Callee]
+	if fn == nil {
+		v.errorf(instr, "undefined function @%s", instr.Callee)
+		return
+	}
+	sig, ok := instr.Typ.(*types.Function)
+	if !ok {
+		v.errorf(instr, "func has type %s, want a function", instr.Typ)
+		return
+	}
+	fields := make([]*types.Field, len(instr.Args))
+	for i, arg := range instr.Args {
+		fields[i] = types.NewField("", arg.Type())
+	}
+	if instr.Rec {
+		fields = append(fields, types.NewField("", sig))
+	}
+	want := types.NewFunction(append(fields, sig.Params...), sig.Result, sig.HasSideEffects)
+	if sig.Rest >= 0 {
+		want.Rest = sig.Rest + len(fields)
+	}
+	if !types.Identical(fn.Sig, want) {
+		v.errorf(instr, "@%s has type %s, want %s", instr.Callee, fn.Sig, want)
+	}
+}
+
+func (v *verifier) call(instr *Instr) {
+	args := instr.Args
+	var sig *types.Function
+	if instr.Callee != "" {
+		fn := v.funcs[instr.Callee]
+		if fn == nil {
+			v.errorf(instr, "undefined function @%s", instr.Callee)
+			return
+		}
+		if len(instr.TypeArgs) != len(fn.TypeParams) {
+			v.errorf(instr, "%d type arguments, want %d", len(instr.TypeArgs), len(fn.TypeParams))
+			return
+		}
+		sig = instantiate(fn, instr.TypeArgs)
+	} else {
+		if len(args) == 0 {
+			v.errorf(instr, "missing function operand")
+			return
+		}
+		var ok bool
+		if sig, ok = args[0].Type().Underlying().(*types.Function); !ok {
+			v.errorf(instr, "cannot call %s of type %s", args[0], args[0].Type())
+			return
+		}
+		args = args[1:]
+	}
+	if instr.Fx != sig.HasSideEffects {
+		v.errorf(instr, "effect does not match %s", sig)
+	}
+	if len(args) != len(sig.Params) {
+		v.errorf(instr, "%d arguments, want %d", len(args), len(sig.Params))
+		return
+	}
+	for i, arg := range args {
+		if !types.Identical(arg.Type(), sig.Params[i].Type) {
+			v.errorf(instr, "argument %s has type %s, want %s", arg, arg.Type(), sig.Params[i].Type)
+		}
+	}
+	switch {
+	case sig.Result == nil && instr.Typ != nil:
+		v.errorf(instr, "%s returns no value", sig)
+	case sig.Result != nil && instr.Typ == nil:
+		v.errorf(instr, "result of %s must be defined", sig)
+	case sig.Result != nil && !types.Identical(instr.Typ, sig.Result):
+		v.errorf(instr, "result has type %s, want %s", instr.Typ, sig.Result)
+	}
+}
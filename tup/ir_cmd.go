@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/opt"
+	"github.com/spf13/pflag"
+)
+
+// irCommand checks a module, lowers it to the IR and prints the IR,
+// optimized if -O is given. -d=pass,... writes the IR before and after
+// each pass named, and implies -O:
+//
+//	tup ir [-O] [-d=sccp,dce] file.tup
+func irCommand(args []string) error {
+	flags := pflag.NewFlagSet("ir", pflag.ContinueOnError)
+	optimize := flags.BoolP("optimize", "O", false, "Run the optimization passes")
+	dump := flags.StringSliceP("dump", "d", nil, "Write the IR before and after the named passes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: tup ir [-O] [-d=pass,...] file.tup")
+	}
+	module, info, err := loadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	m, err := ir.Lower(module, info)
+	if err != nil {
+		return err
+	}
+	if err := ir.Verify(m); err != nil {
+		return err
+	}
+	if *optimize || len(*dump) > 0 {
+		pm := opt.NewManager()
+		pm.Out, pm.Verify = os.Stdout, true
+		for _, name := range *dump {
+			pm.Dump[name] = true
+		}
+		if err := pm.Run(m); err != nil {
+			return err
+		}
+	}
+	fmt.Print(m)
+	return nil
+}
@@ -0,0 +1,259 @@
+// Package layout computes the memory layout of Tuppence types: their size,
+// alignment and the offsets of tuple fields, for a given target.
+//
+// Tuple fields are ordered by size in descending order. Fields of the same
+// size are ordered by label in labeled tuples and by position otherwise.
+// Named tuple types annotated @cstruct keep their declaration order and are
+// padded as a C compiler for the target would.
+//
+// The values of recursive types, named types that contain themselves other
+// than through a reference, are boxed wherever other values hold them: the
+// tuple fields, union members and array elements of those types are laid
+// out as references to the values.
+package layout
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Target describes the machine a layout is computed for.
+type Target struct {
+	// WordSize is the size in bytes of pointers and of the values of
+	// reference types.
+	WordSize int64
+}
+
+// Predefined targets.
+var (
+	Target32 = &Target{WordSize: 4}
+	Target64 = &Target{WordSize: 8}
+)
+
+// tagSize is the size of the type tag that discriminates union values.
+const tagSize = 4
+
+// Field is the placement of a tuple field.
+type Field struct {
+	Name   string // empty for unlabeled fields
+	Index  int    // position of the field in the tuple type
+	Offset int64
+	Size   int64
+	Type   types.Type
+}
+
+// Layout is the memory layout of a type.
+type Layout struct {
+	Type  types.Type
+	Size  int64
+	Align int64
+	// Fields holds the fields of a tuple in memory order.
+	Fields []Field
+	// Payload is the offset of the value of a union, which follows its
+	// type tag.
+	Payload int64
+	// CStruct is set when the fields are in declaration order.
+	CStruct bool
+}
+
+// Field returns the placement of the i'th field of the tuple.
+func (l *Layout) Field(i int) *Field {
+	for j := range l.Fields {
+		if l.Fields[j].Index == i {
+			return &l.Fields[j]
+		}
+	}
+	return nil
+}
+
+// String returns a description of the layout with a line for each field.
+func (l *Layout) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s: size %d, align %d", l.Type, l.Size, l.Align)
+	if l.CStruct {
+		builder.WriteString(", @cstruct")
+	}
+	if _, ok := l.Type.Underlying().(*types.Union); ok {
+		fmt.Fprintf(&builder, ", payload at %d", l.Payload)
+	}
+	for _, field := range l.Fields {
+		name := field.Name
+		if name == "" {
+			name = fmt.Sprint(field.Index)
+		}
+		fmt.Fprintf(&builder, "\n  %4d  %s: %s (%d)", field.Offset, name, field.Type, field.Size)
+	}
+	return builder.String()
+}
+
+// Of returns the layout of typ on the target. Type parameters have no
+// layout.
+func (t *Target) Of(typ types.Type) (*Layout, error) {
+	typ = types.Default(typ)
+	switch u := typ.Underlying().(type) {
+	case *types.Basic:
+		return t.basic(typ, u)
+	case *types.Tuple:
+		named, _ := typ.(*types.Named)
+		return t.tuple(typ, u, named != nil && named.HasAnnotation("cstruct"))
+	case *types.Array:
+		if !u.Fixed() {
+			return t.word(typ), nil
+		}
+		elem, err := t.component(u.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return &Layout{Type: typ, Size: elem.Size * u.Len, Align: elem.Align}, nil
+	case *types.Union:
+		return t.union(typ, u)
+	case *types.Function:
+		return t.word(typ), nil
+	case *types.Enum:
+		// enum values are held as their Int values
+		return t.basic(typ, types.Typ[types.Int64])
+	}
+	return nil, fmt.Errorf("%s has no layout", typ)
+}
+
+// Sizeof returns the size of typ on the target.
+func (t *Target) Sizeof(typ types.Type) (int64, error) {
+	l, err := t.Of(typ)
+	if err != nil {
+		return 0, err
+	}
+	return l.Size, nil
+}
+
+// component returns the layout of a tuple field, union member or array
+// element of type typ: a reference if typ is recursive.
+func (t *Target) component(typ types.Type) (*Layout, error) {
+	if Recursive(typ) {
+		return t.word(typ), nil
+	}
+	return t.Of(typ)
+}
+
+// Recursive reports whether typ is a named type that contains itself other
+// than through a reference, whose values are boxed wherever other values
+// hold them.
+func Recursive(typ types.Type) bool {
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return false
+	}
+	seen := map[types.Type]bool{}
+	var contains func(t types.Type) bool
+	contains = func(t types.Type) bool {
+		if t == named {
+			return true
+		}
+		if seen[t] {
+			return false
+		}
+		seen[t] = true
+		switch u := t.Underlying().(type) {
+		case *types.Tuple:
+			for _, f := range u.Fields {
+				if contains(f.Type) {
+					return true
+				}
+			}
+		case *types.Union:
+			for _, m := range u.Members {
+				if contains(m) {
+					return true
+				}
+			}
+		case *types.Array:
+			return u.Fixed() && contains(u.Elem)
+		}
+		return false
+	}
+	return contains(named.Underlying())
+}
+
+func (t *Target) word(typ types.Type) *Layout {
+	return &Layout{Type: typ, Size: t.WordSize, Align: t.WordSize}
+}
+
+func (t *Target) basic(typ types.Type, b *types.Basic) (*Layout, error) {
+	var size int64
+	switch b.Kind() {
+	case types.Nil:
+		return &Layout{Type: typ, Size: 0, Align: 1}, nil
+	case types.Bool, types.Int8, types.UInt8:
+		size = 1
+	case types.Int16, types.UInt16, types.Float16:
+		size = 2
+	case types.Int32, types.UInt32, types.Float32, types.TypeDescriptor:
+		size = 4
+	case types.Int64, types.UInt64, types.Float64:
+		size = 8
+	case types.String, types.Symbol:
+		return t.word(typ), nil
+	default:
+		return nil, fmt.Errorf("%s has no layout", typ)
+	}
+	// 8-byte values are only 4-byte aligned on 32-bit targets
+	return &Layout{Type: typ, Size: size, Align: min(size, max(t.WordSize, 4))}, nil
+}
+
+func (t *Target) tuple(typ types.Type, tuple *types.Tuple, cstruct bool) (*Layout, error) {
+	l := &Layout{Type: typ, Align: 1, CStruct: cstruct}
+	var aligns []int64
+	for i, f := range tuple.Fields {
+		fl, err := t.component(f.Type)
+		if err != nil {
+			return nil, err
+		}
+		l.Fields = append(l.Fields, Field{Name: f.Name, Index: i, Size: fl.Size, Type: f.Type})
+		aligns = append(aligns, fl.Align)
+	}
+	if !cstruct {
+		labeled := tuple.Labeled()
+		sort.SliceStable(l.Fields, func(i, j int) bool {
+			x, y := l.Fields[i], l.Fields[j]
+			if x.Size != y.Size {
+				return x.Size > y.Size
+			}
+			if labeled {
+				return x.Name < y.Name
+			}
+			return x.Index < y.Index
+		})
+	}
+	for i := range l.Fields {
+		align := aligns[l.Fields[i].Index]
+		l.Size = alignTo(l.Size, align)
+		l.Fields[i].Offset = l.Size
+		l.Size += l.Fields[i].Size
+		l.Align = max(l.Align, align)
+	}
+	l.Size = alignTo(l.Size, l.Align)
+	return l, nil
+}
+
+// union lays out a union as a type tag followed by space for the largest
+// member.
+func (t *Target) union(typ types.Type, u *types.Union) (*Layout, error) {
+	var size, align int64 = 0, 1
+	for _, m := range u.Members {
+		ml, err := t.component(m)
+		if err != nil {
+			return nil, err
+		}
+		size = max(size, ml.Size)
+		align = max(align, ml.Align)
+	}
+	payload := alignTo(tagSize, align)
+	align = max(align, tagSize)
+	return &Layout{Type: typ, Size: alignTo(payload+size, align), Align: align, Payload: payload}, nil
+}
+
+func alignTo(n, align int64) int64 {
+	return (n + align - 1) / align * align
+}
@@ -0,0 +1,114 @@
+package layout
+
+import (
+	"testing"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+func TestOf(t *testing.T) {
+	str := types.Typ[types.String]
+	i32 := types.Typ[types.Int32]
+	abc := types.NewTuple(types.NewField("a", types.Byte), types.NewField("b", types.Int), types.NewField("c", i32))
+	list := types.NewNamed("List", nil)
+	list.SetUnderlying(types.NewUnion(types.Typ[types.Nil], types.NewTuple(types.NewField("head", types.Int), types.NewField("tail", list))))
+	tree := types.NewNamed("Tree", nil)
+	tree.SetUnderlying(types.NewTuple(types.NewField("value", types.Int), types.NewField("children", types.NewArray(tree))))
+
+	tests := []struct {
+		name    string
+		target  *Target
+		typ     types.Type
+		want    string
+		wantErr string
+	}{
+		{"int", Target64, types.Int, "Int: size 8, align 8", ""},
+		{"bool", Target64, types.Typ[types.Bool], "Bool: size 1, align 1", ""},
+		{"string", Target64, str, "String: size 8, align 8", ""},
+		{"nil", Target64, types.Typ[types.Nil], "Nil: size 0, align 1", ""},
+		{"untyped", Target64, types.Typ[types.UntypedFloat], "Float: size 8, align 8", ""},
+		{"descending size", Target64, abc,
+			"(a: Byte, b: Int, c: Int32): size 16, align 8\n" +
+				"     0  b: Int (8)\n" +
+				"     8  c: Int32 (4)\n" +
+				"    12  a: Byte (1)", ""},
+		{"labels break ties", Target64, types.NewTuple(types.NewField("z", types.Int), types.NewField("a", types.Int)),
+			"(z: Int, a: Int): size 16, align 8\n" +
+				"     0  a: Int (8)\n" +
+				"     8  z: Int (8)", ""},
+		{"ordinals break ties", Target64, types.NewTuple(types.NewField("", i32), types.NewField("", i32), types.NewField("", types.Int)),
+			"(Int32, Int32, Int): size 16, align 8\n" +
+				"     0  2: Int (8)\n" +
+				"     8  0: Int32 (4)\n" +
+				"    12  1: Int32 (4)", ""},
+		{"cstruct", Target64, types.NewNamed("ABC", abc, "cstruct"),
+			"ABC: size 24, align 8, @cstruct\n" +
+				"     0  a: Byte (1)\n" +
+				"     8  b: Int (8)\n" +
+				"    16  c: Int32 (4)", ""},
+		{"named tuple", Target64, types.NewNamed("ABC", abc), "ABC: size 16, align 8\n" +
+			"     0  b: Int (8)\n" +
+			"     8  c: Int32 (4)\n" +
+			"    12  a: Byte (1)", ""},
+		{"fixed array", Target64, types.NewFixedArray(types.Typ[types.Int16], 4), "[4]Int16: size 8, align 2", ""},
+		{"dynamic array", Target64, types.NewArray(types.Int), "[]Int: size 8, align 8", ""},
+		{"union", Target64, types.NewUnion(types.Int, types.Typ[types.Bool]), "Int | Bool: size 16, align 8, payload at 8", ""},
+		{"small union", Target64, types.NewUnion(i32, types.Typ[types.Nil]), "Int32 | Nil: size 8, align 4, payload at 4", ""},
+		{"enum", Target32, types.NewNamed("Fruit", types.NewEnum(&types.EnumMember{Name: "apple"})), "Fruit: size 8, align 4", ""},
+		{"function", Target32, types.NewFunction(nil, nil, false), "fn(): size 4, align 4", ""},
+		{"32-bit", Target32, types.NewTuple(types.NewField("a", types.Byte), types.NewField("b", types.Int)),
+			"(a: Byte, b: Int): size 12, align 4\n" +
+				"     0  b: Int (8)\n" +
+				"     8  a: Byte (1)", ""},
+		{"recursive through reference", Target64, tree, "Tree: size 16, align 8\n" +
+			"     0  children: []Tree (8)\n" +
+			"     8  value: Int (8)", ""},
+		{"recursive", Target64, list, "List: size 24, align 8, payload at 8", ""},
+		{"recursive member", Target64, list.Underlying().(*types.Union).Members[1], "(head: Int, tail: List): size 16, align 8\n" +
+			"     0  head: Int (8)\n" +
+			"     8  tail: List (8)", ""},
+		{"type parameter", Target64, types.NewTypeParam("a"), "", "a has no layout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := tt.target.Of(tt.typ)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Of(%s) = %v, want error %q", tt.typ, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Of(%s) = %v", tt.typ, err)
+			}
+			if got := l.String(); got != tt.want {
+				t.Errorf("Of(%s) =\n%s\nwant\n%s", tt.typ, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	list := types.NewNamed("List", nil)
+	cons := types.NewNamed("Cons", types.NewTuple(types.NewField("head", types.Int), types.NewField("tail", list)))
+	list.SetUnderlying(types.NewUnion(types.Typ[types.Nil], cons))
+	tree := types.NewNamed("Tree", nil)
+	tree.SetUnderlying(types.NewTuple(types.NewField("value", types.Int), types.NewField("children", types.NewArray(tree))))
+	pair := types.NewNamed("Pair", types.NewTuple(types.NewField("a", list), types.NewField("b", list)))
+	tests := []struct {
+		typ  types.Type
+		want bool
+	}{
+		{list, true},
+		{cons, true},
+		{tree, false}, // through a reference
+		{pair, false}, // holds a recursive type, but not itself
+		{cons.Underlying(), false},
+		{types.Int, false},
+	}
+	for _, tt := range tests {
+		if got := Recursive(tt.typ); got != tt.want {
+			t.Errorf("Recursive(%s) = %t, want %t", tt.typ, got, tt.want)
+		}
+	}
+}
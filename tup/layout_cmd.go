@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/layout"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+	"github.com/spf13/pflag"
+)
+
+// layoutCommand prints the memory layout of the named types and values
+// declared by a module, or of all its types if none are named:
+//
+//	tup layout [--word-size 4|8] file.tup [name...]
+func layoutCommand(args []string) error {
+	flags := pflag.NewFlagSet("layout", pflag.ContinueOnError)
+	wordSize := flags.Int64("word-size", 8, "Target word size in bytes (4 or 8)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("usage: tup layout [--word-size 4|8] file.tup [name...]")
+	}
+	if *wordSize != 4 && *wordSize != 8 {
+		return fmt.Errorf("invalid word size %d: must be 4 or 8", *wordSize)
+	}
+	target := &layout.Target{WordSize: *wordSize}
+
+	info, err := checkFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	names := flags.Args()[1:]
+	if len(names) == 0 {
+		for _, name := range info.Scope.Names() {
+			if info.Scope.LookupLocal(name).Kind == check.TypeObject {
+				names = append(names, name)
+			}
+		}
+	}
+	for i, name := range names {
+		obj := info.Scope.LookupLocal(name)
+		if obj == nil {
+			return fmt.Errorf("%s is not declared in %s", name, flags.Arg(0))
+		}
+		l, err := target.Of(obj.Type)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		if obj.Kind != check.TypeObject {
+			fmt.Printf("%s ", name)
+		}
+		fmt.Println(l)
+	}
+	return nil
+}
+
+// checkFile parses and checks the module in filename, printing any
+// warnings to standard error.
+func checkFile(filename string) (*check.Info, error) {
+	_, info, err := loadFile(filename)
+	return info, err
+}
+
+// loadFile is checkFile, also returning the module parsed.
+func loadFile(filename string) (*ast.Module, *check.Info, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	module, err := parse.Module(source.NewSource(contents, filename), ast.NewModule(filename))
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := check.Module(module)
+	if info != nil {
+		for _, warning := range info.Warnings {
+			fmt.Fprintln(os.Stderr, warning)
+		}
+	}
+	return module, info, err
+}
@@ -11,7 +11,27 @@ import (
 	"github.com/spf13/pflag"
 )
 
+// commands maps the names of subcommands to their implementations.
+var commands = map[string]func(args []string) error{
+	"build":  buildCommand,
+	"disasm": disasmCommand,
+	"ir":     irCommand,
+	"layout": layoutCommand,
+	"match":  matchCommand,
+	"repl":   replCommand,
+	"run":    runCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	var input string
 	var output string
 	pflag.StringVarP(&input, "input", "i", "", "Input file")
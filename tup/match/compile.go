@@ -0,0 +1,698 @@
+package match
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Info provides the types and values recorded by the checker. The type of
+// each pattern is the type of the values it matches, which is a member of
+// the type being matched when that is a union.
+type Info interface {
+	TypeOf(node ast.Node) types.Type
+	ValueOf(expr ast.Expression) consteval.Value
+}
+
+// pattern is a pattern of a case, resolved against the type of the part of
+// the subject it matches.
+type pattern interface {
+	pattern()
+}
+
+type (
+	// wildPattern matches any value.
+	wildPattern struct{}
+	// tagPattern matches a union holding a value of type typ that matches
+	// inner.
+	tagPattern struct {
+		typ   types.Type
+		inner pattern
+	}
+	// tuplePattern matches a tuple whose fields match elems, one per field.
+	tuplePattern struct {
+		elems []pattern
+	}
+	// arrayPattern matches an array whose first elements match elems and
+	// which has no more elements unless rest is set.
+	arrayPattern struct {
+		elems []pattern
+		rest  bool
+	}
+	// valuePattern matches a value equal to value.
+	valuePattern struct {
+		value consteval.Value
+	}
+	// rangePattern matches a value within lo..hi, inclusive.
+	rangePattern struct {
+		lo, hi consteval.Value
+	}
+	// orPattern matches a value matching any of alts.
+	orPattern struct {
+		alts []pattern
+	}
+)
+
+func (wildPattern) pattern()   {}
+func (*tagPattern) pattern()   {}
+func (*tuplePattern) pattern() {}
+func (*arrayPattern) pattern() {}
+func (*valuePattern) pattern() {}
+func (*rangePattern) pattern() {}
+func (*orPattern) pattern()    {}
+
+var wild pattern = wildPattern{}
+
+// row is a row of the pattern matrix: the patterns a case requires of the
+// parts of the subject in the columns.
+type row struct {
+	pats  []pattern
+	index int   // the case, or Else
+	root  *Path // the subject, narrowed by the case's outermost tag
+	block *ast.FunctionBlock
+}
+
+func (r *row) with(pats []pattern) *row {
+	return &row{pats: pats, index: r.index, root: r.root, block: r.block}
+}
+
+type compiler struct {
+	info Info
+	err  error
+}
+
+// Compile compiles the cases of e into a decision tree. Cases are matched
+// in order, so a case is selected only if no earlier case matches.
+func Compile(e *ast.SwitchExpression, info Info) (*Tree, error) {
+	c := &compiler{info: info}
+	subject := info.TypeOf(e.Expression)
+	if subject == nil {
+		return nil, fmt.Errorf("type of switch subject %s is unknown", e.Expression)
+	}
+	root := &Path{Kind: Root, Type: subject}
+	var rows []*row
+	for i, sc := range e.Cases {
+		p := c.pattern(sc.Condition, subject)
+		alts := []pattern{p}
+		if or, ok := p.(*orPattern); ok {
+			alts = or.alts
+		}
+		for _, alt := range alts {
+			r := &row{pats: []pattern{alt}, index: i, root: root, block: sc.Body}
+			if tag, ok := alt.(*tagPattern); ok {
+				r.root = root.As(tag.typ)
+			}
+			rows = append(rows, r)
+		}
+	}
+	if e.ElseBlock != nil {
+		rows = append(rows, &row{pats: []pattern{wild}, index: Else, root: root, block: e.ElseBlock})
+	}
+	tree := &Tree{Switch: e, Subject: root}
+	tree.Root = c.compile([]*Path{root}, rows)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return tree, nil
+}
+
+func (c *compiler) errorf(node ast.Node, format string, args ...any) pattern {
+	if c.err == nil {
+		c.err = fmt.Errorf("%s: %s", ast.PosOf(node), fmt.Sprintf(format, args...))
+	}
+	return wild
+}
+
+// pattern resolves the pattern p matching values of type typ.
+func (c *compiler) pattern(p ast.Node, typ types.Type) pattern {
+	if list, ok := p.(*ast.ListMatch); ok {
+		or := &orPattern{}
+		for _, elem := range list.Elements {
+			alt := c.pattern(elem, typ)
+			if inner, ok := alt.(*orPattern); ok {
+				or.alts = append(or.alts, inner.alts...)
+			} else {
+				or.alts = append(or.alts, alt)
+			}
+		}
+		return or
+	}
+	if _, ok := p.(*ast.WildcardPattern); ok {
+		return wild
+	}
+	narrowed := c.info.TypeOf(p)
+	if narrowed == nil {
+		return c.errorf(p, "type of pattern %s is unknown", p)
+	}
+	if _, ok := typ.Underlying().(*types.Union); ok && !types.Identical(narrowed, typ) {
+		if members, ok := narrowed.(*types.Union); ok {
+			// the pattern matches several members, as error does
+			or := &orPattern{}
+			for _, m := range members.Members {
+				or.alts = append(or.alts, &tagPattern{typ: m, inner: wild})
+			}
+			return or
+		}
+		return &tagPattern{typ: narrowed, inner: c.inner(p, narrowed)}
+	}
+	return c.inner(p, typ)
+}
+
+// inner resolves the pattern p matching values of type typ, once the tag
+// of any union holding them has been tested.
+func (c *compiler) inner(p ast.Node, typ types.Type) pattern {
+	switch p := p.(type) {
+	case *ast.WildcardPattern, *ast.TypeReference, *ast.InferredErrorType:
+		return wild
+	case *ast.TypedPattern:
+		return c.pattern(p.Pattern, typ)
+	case *ast.Constant:
+		expr := ConstantExpr(p)
+		if expr == nil {
+			return c.errorf(p, "invalid constant %s", p)
+		}
+		return &valuePattern{value: c.value(expr)}
+	case *ast.Range:
+		return &rangePattern{lo: c.value(p.StartBound.Value), hi: c.value(p.EndBound.Value)}
+	case *ast.TuplePattern:
+		tuple, ok := typ.Underlying().(*types.Tuple)
+		if !ok || len(tuple.Fields) != len(p.Elements) {
+			return c.errorf(p, "cannot match %s against %s", p, typ)
+		}
+		elems := make([]pattern, len(p.Elements))
+		for i, elem := range p.Elements {
+			elems[i] = c.pattern(elem, tuple.Fields[i].Type)
+		}
+		return &tuplePattern{elems: elems}
+	case *ast.LabeledPattern:
+		tuple, ok := typ.Underlying().(*types.Tuple)
+		if !ok {
+			return c.errorf(p, "cannot match %s against %s", p, typ)
+		}
+		elems := make([]pattern, len(tuple.Fields))
+		for i := range elems {
+			elems[i] = wild
+		}
+		for _, m := range p.Members {
+			i := tuple.FieldIndex(m.Label.Name)
+			if i < 0 {
+				return c.errorf(m, "%s has no field %s", typ, m.Label.Name)
+			}
+			elems[i] = c.pattern(m.Pattern, tuple.Fields[i].Type)
+		}
+		return &tuplePattern{elems: elems}
+	case *ast.ArrayPattern:
+		array, ok := typ.Underlying().(*types.Array)
+		if !ok {
+			return c.errorf(p, "cannot match %s against %s", p, typ)
+		}
+		elems := make([]pattern, len(p.Elements))
+		for i, elem := range p.Elements {
+			elems[i] = c.pattern(elem, array.Elem)
+		}
+		return &arrayPattern{elems: elems, rest: p.HasRest}
+	}
+	return c.errorf(p, "unsupported pattern %s", p)
+}
+
+// ConstantExpr returns the expression whose value a constant pattern
+// matches, or nil if it has none. Identifiers naming constants of the
+// module are the only scoped identifiers supported.
+func ConstantExpr(p *ast.Constant) ast.Expression {
+	switch v := p.Value.(type) {
+	case *ast.ScopedIdentifier:
+		if len(v.Identifiers) == 1 {
+			return v.Identifiers[0]
+		}
+	case ast.Expression:
+		return v
+	}
+	return nil
+}
+
+func (c *compiler) value(expr ast.Expression) consteval.Value {
+	v := c.info.ValueOf(expr)
+	if v == nil {
+		c.errorf(expr, "%s is not a compile-time constant", expr)
+		return consteval.Nil{}
+	}
+	return v
+}
+
+// compile returns the decision tree matching the parts of the subject at
+// paths against the rows, in order.
+func (c *compiler) compile(paths []*Path, rows []*row) Node {
+	rows = expand(rows)
+	if len(rows) == 0 {
+		return &Fail{}
+	}
+	j := -1
+	for i, p := range rows[0].pats {
+		if p != wild {
+			j = i
+			break
+		}
+	}
+	if j < 0 {
+		return &Leaf{Case: rows[0].index, Bindings: c.bindings(rows[0])}
+	}
+	switch rows[0].pats[j].(type) {
+	case *tuplePattern:
+		return c.destructure(paths, rows, j)
+	case *tagPattern:
+		return c.tagSwitch(paths, rows, j)
+	case *arrayPattern:
+		return c.lenSwitch(paths, rows, j)
+	case *valuePattern, *rangePattern:
+		return c.valueSwitch(paths, rows, j)
+	}
+	c.errorf(rows[0].block, "unsupported pattern")
+	return &Fail{}
+}
+
+// expand replaces each row holding alternatives with a row for each.
+func expand(rows []*row) []*row {
+	var expanded []*row
+	for _, r := range rows {
+		j := slices.IndexFunc(r.pats, func(p pattern) bool {
+			_, ok := p.(*orPattern)
+			return ok
+		})
+		if j < 0 {
+			expanded = append(expanded, r)
+			continue
+		}
+		var alts []*row
+		for _, alt := range r.pats[j].(*orPattern).alts {
+			alts = append(alts, r.with(replace(r.pats, j, alt)))
+		}
+		expanded = append(expanded, expand(alts)...)
+	}
+	return expanded
+}
+
+// replace returns s with its j'th element replaced by with.
+func replace[T any](s []T, j int, with ...T) []T {
+	result := make([]T, 0, len(s)-1+len(with))
+	result = append(result, s[:j]...)
+	result = append(result, with...)
+	return append(result, s[j+1:]...)
+}
+
+func wilds(n int) []pattern {
+	pats := make([]pattern, n)
+	for i := range pats {
+		pats[i] = wild
+	}
+	return pats
+}
+
+// destructure replaces column j, holding tuples, with a column for each
+// field. No test is needed.
+func (c *compiler) destructure(paths []*Path, rows []*row, j int) Node {
+	tuple, ok := paths[j].Type.Underlying().(*types.Tuple)
+	if !ok {
+		c.errorf(rows[0].block, "cannot destructure %s", paths[j].Type)
+		return &Fail{}
+	}
+	fields := make([]*Path, len(tuple.Fields))
+	for i, f := range tuple.Fields {
+		fields[i] = paths[j].Field(i, f.Type)
+	}
+	var next []*row
+	for _, r := range rows {
+		switch p := r.pats[j].(type) {
+		case *tuplePattern:
+			next = append(next, r.with(replace(r.pats, j, p.elems...)))
+		case wildPattern:
+			next = append(next, r.with(replace(r.pats, j, wilds(len(fields))...)))
+		default:
+			c.errorf(r.block, "cannot match a tuple and a %T in the same position", p)
+		}
+	}
+	return c.compile(replace(paths, j, fields...), next)
+}
+
+// tagSwitch tests the tag of the union in column j, in the order in which
+// the cases mention its members.
+func (c *compiler) tagSwitch(paths []*Path, rows []*row, j int) Node {
+	var tags []types.Type
+	for _, r := range rows {
+		if p, ok := r.pats[j].(*tagPattern); ok && !containsType(tags, p.typ) {
+			tags = append(tags, p.typ)
+		}
+	}
+	s := &Switch{Path: paths[j]}
+	for _, tag := range tags {
+		var next []*row
+		for _, r := range rows {
+			switch p := r.pats[j].(type) {
+			case *tagPattern:
+				if types.Identical(p.typ, tag) {
+					next = append(next, r.with(replace(r.pats, j, p.inner)))
+				}
+			case wildPattern:
+				next = append(next, r)
+			}
+		}
+		s.Cases = append(s.Cases, &Case{
+			Test: &Test{Kind: Tag, Type: tag},
+			Node: c.compile(replace(paths, j, paths[j].As(tag)), next),
+		})
+	}
+	if union, ok := paths[j].Type.Underlying().(*types.Union); !ok || len(tags) < len(union.Members) {
+		s.Default = c.compile(paths, wildRows(rows, j))
+	}
+	return s
+}
+
+func containsType(typs []types.Type, typ types.Type) bool {
+	for _, t := range typs {
+		if types.Identical(t, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildRows returns the rows matching any value in column j.
+func wildRows(rows []*row, j int) []*row {
+	var next []*row
+	for _, r := range rows {
+		if r.pats[j] == wild {
+			next = append(next, r)
+		}
+	}
+	return next
+}
+
+// valueSwitch tests the value in column j against the constants and ranges
+// of the cases, in order. A row whose range overlaps a test that succeeds
+// without containing it is tested again below.
+func (c *compiler) valueSwitch(paths []*Path, rows []*row, j int) Node {
+	var tests []*Test
+	for _, r := range rows {
+		var t *Test
+		switch p := r.pats[j].(type) {
+		case *valuePattern:
+			t = &Test{Kind: Equal, Value: p.value}
+		case *rangePattern:
+			t = &Test{Kind: InRange, Lo: p.lo, Hi: p.hi}
+		default:
+			continue
+		}
+		if !failed(tests, r.pats[j]) {
+			tests = append(tests, t)
+		}
+	}
+	s := &Switch{Path: paths[j]}
+	for k, t := range tests {
+		var next []*row
+		for _, r := range rows {
+			if failed(tests[:k], r.pats[j]) {
+				continue
+			}
+			switch p := r.pats[j].(type) {
+			case wildPattern:
+				next = append(next, r)
+			case *valuePattern:
+				if t.Kind == Equal && consteval.Equal(p.value, t.Value) {
+					next = append(next, r.with(replace(r.pats, j, wild)))
+				} else if t.Kind == InRange && within(p.value, t.Lo, t.Hi) {
+					next = append(next, r)
+				}
+			case *rangePattern:
+				switch {
+				case t.Kind == Equal && within(t.Value, p.lo, p.hi):
+					next = append(next, r.with(replace(r.pats, j, wild)))
+				case t.Kind == InRange && sameTest(t, &Test{Kind: InRange, Lo: p.lo, Hi: p.hi}):
+					next = append(next, r.with(replace(r.pats, j, wild)))
+				case t.Kind == InRange && overlap(p.lo, p.hi, t.Lo, t.Hi):
+					next = append(next, r)
+				}
+			}
+		}
+		s.Cases = append(s.Cases, &Case{Test: t, Node: c.compile(paths, next)})
+	}
+	if !coversAll(paths[j].Type, tests) {
+		s.Default = c.compile(paths, wildRows(rows, j))
+	}
+	return s
+}
+
+// failed reports whether p can no longer match once the tests have
+// failed: its value or range is that of a test or within a tested range.
+func failed(tests []*Test, p pattern) bool {
+	for _, t := range tests {
+		switch p := p.(type) {
+		case *valuePattern:
+			if t.Kind == Equal && consteval.Equal(p.value, t.Value) ||
+				t.Kind == InRange && contains(t.Lo, t.Hi, p.value, p.value) {
+				return true
+			}
+		case *rangePattern:
+			if t.Kind == InRange && contains(t.Lo, t.Hi, p.lo, p.hi) ||
+				t.Kind == Equal && consteval.Equal(p.lo, t.Value) && consteval.Equal(p.hi, t.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contains reports whether the range lo..hi is known to contain the range
+// from..to.
+func contains(lo, hi, from, to consteval.Value) bool {
+	cmpLo, err1 := consteval.Compare(lo, from)
+	cmpHi, err2 := consteval.Compare(to, hi)
+	return err1 == nil && err2 == nil && cmpLo <= 0 && cmpHi <= 0
+}
+
+func sameTest(x, y *Test) bool {
+	if x.Kind != y.Kind {
+		return false
+	}
+	switch x.Kind {
+	case Equal:
+		return consteval.Equal(x.Value, y.Value)
+	case InRange:
+		return consteval.Equal(x.Lo, y.Lo) && consteval.Equal(x.Hi, y.Hi)
+	case Len, MinLen:
+		return x.N == y.N
+	}
+	return types.Identical(x.Type, y.Type)
+}
+
+func containsTest(tests []*Test, t *Test) bool {
+	for _, u := range tests {
+		if sameTest(t, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether v is within lo..hi. Values that cannot be
+// compared are assumed to be, so that their rows are tested again.
+func within(v, lo, hi consteval.Value) bool {
+	cmpLo, err1 := consteval.Compare(v, lo)
+	cmpHi, err2 := consteval.Compare(v, hi)
+	return err1 != nil || err2 != nil || cmpLo >= 0 && cmpHi <= 0
+}
+
+// overlap reports whether the ranges lo1..hi1 and lo2..hi2 may overlap.
+func overlap(lo1, hi1, lo2, hi2 consteval.Value) bool {
+	return within(lo1, lo2, hi2) || within(lo2, lo1, hi1)
+}
+
+// coversAll reports whether the tests cover every value of type typ, as
+// both Bool values or every member of an enum.
+func coversAll(typ types.Type, tests []*Test) bool {
+	var values []consteval.Value
+	switch t := typ.Underlying().(type) {
+	case *types.Basic:
+		if !types.IsBool(t) {
+			return false
+		}
+		values = []consteval.Value{consteval.Bool(false), consteval.Bool(true)}
+	case *types.Enum:
+		for _, m := range t.Members {
+			values = append(values, &consteval.Enum{Typ: typ, Member: m})
+		}
+	default:
+		return false
+	}
+	for _, v := range values {
+		if !containsTest(tests, &Test{Kind: Equal, Value: v}) {
+			return false
+		}
+	}
+	return true
+}
+
+// lenSwitch tests the length of the array in column j and replaces the
+// column with a column for each element the cases for that length match.
+// There is a test for each exact length required by a case and for each
+// length from the shortest to the longest prefix of the cases with a rest,
+// and a final test for arrays at least as long as the longest prefix.
+func (c *compiler) lenSwitch(paths []*Path, rows []*row, j int) Node {
+	array, ok := paths[j].Type.Underlying().(*types.Array)
+	if !ok {
+		c.errorf(rows[0].block, "cannot match an array against %s", paths[j].Type)
+		return &Fail{}
+	}
+	if array.Len >= 0 {
+		// the length of fixed arrays is known
+		return c.lenCase(paths, rows, j, array, int(array.Len), false)
+	}
+	exact := map[int]bool{}
+	minRest, maxRest := -1, -1
+	for _, r := range rows {
+		p, ok := r.pats[j].(*arrayPattern)
+		switch {
+		case !ok:
+		case p.rest:
+			if minRest < 0 || len(p.elems) < minRest {
+				minRest = len(p.elems)
+			}
+			maxRest = max(maxRest, len(p.elems))
+		default:
+			exact[len(p.elems)] = true
+		}
+	}
+	if minRest >= 0 {
+		for n := minRest; n < maxRest; n++ {
+			exact[n] = true
+		}
+	}
+	longest := maxRest
+	for n := range exact {
+		longest = max(longest, n)
+	}
+	s := &Switch{Path: paths[j]}
+	for n := 0; n <= longest; n++ {
+		if exact[n] {
+			s.Cases = append(s.Cases, &Case{
+				Test: &Test{Kind: Len, N: n},
+				Node: c.lenCase(paths, rows, j, array, n, false),
+			})
+		}
+	}
+	if maxRest >= 0 {
+		s.Cases = append(s.Cases, &Case{
+			Test: &Test{Kind: MinLen, N: maxRest},
+			Node: c.lenCase(paths, rows, j, array, maxRest, true),
+		})
+	}
+	// the tests cover every length if there is a case with a rest and a
+	// test for each shorter length
+	covered := maxRest >= 0
+	for n := 0; n < maxRest; n++ {
+		covered = covered && exact[n]
+	}
+	if !covered {
+		s.Default = c.compile(paths, wildRows(rows, j))
+	}
+	return s
+}
+
+// lenCase compiles the rows for arrays with n elements, or at least n if
+// atLeast is set, in column j.
+func (c *compiler) lenCase(paths []*Path, rows []*row, j int, array *types.Array, n int, atLeast bool) Node {
+	elems := make([]*Path, n)
+	for i := range elems {
+		elems[i] = paths[j].Elem(i, array.Elem)
+	}
+	var next []*row
+	for _, r := range rows {
+		switch p := r.pats[j].(type) {
+		case wildPattern:
+			next = append(next, r.with(replace(r.pats, j, wilds(n)...)))
+		case *arrayPattern:
+			if p.rest && len(p.elems) <= n || !p.rest && !atLeast && len(p.elems) == n {
+				pats := append(append([]pattern{}, p.elems...), wilds(n-len(p.elems))...)
+				next = append(next, r.with(replace(r.pats, j, pats...)))
+			}
+		}
+	}
+	return c.compile(replace(paths, j, elems...), next)
+}
+
+// bindings binds the parameters of the block of r to the parts of the
+// subject they destructure.
+func (c *compiler) bindings(r *row) []*Binding {
+	if r.block == nil || r.block.Parameters == nil || r.block.Parameters.Parameters == nil {
+		return nil
+	}
+	root := r.root
+	var bindings []*Binding
+	bind := func(ident *ast.Identifier, path *Path) {
+		if ident != nil && ident.Name != "_" {
+			bindings = append(bindings, &Binding{Ident: ident, Path: path})
+		}
+	}
+	switch lhs := r.block.Parameters.Parameters.(type) {
+	case *ast.OrdinalAssignmentLHS:
+		if len(lhs.Identifiers) == 1 && lhs.RestOperator == nil {
+			bind(lhs.Identifiers[0], root)
+			break
+		}
+		for i, ident := range lhs.Identifiers {
+			bind(ident, part(root, i))
+		}
+		if lhs.RestOperator != nil && lhs.RestOperator.Identifier != nil {
+			bind(lhs.RestOperator.Identifier, rest(root, len(lhs.Identifiers)))
+		}
+	case *ast.LabeledAssignmentLHS:
+		tuple, _ := root.Type.Underlying().(*types.Tuple)
+		for i, rename := range lhs.Renames {
+			rename, ok := rename.(*ast.RenameIdentifier)
+			if !ok || tuple == nil {
+				continue
+			}
+			name := rename.Identifier.Name
+			if rename.Original != nil {
+				name = rename.Original.Name
+			}
+			index := tuple.FieldIndex(name)
+			if !tuple.Labeled() && index < 0 {
+				index = i
+			}
+			if index >= 0 && index < len(tuple.Fields) {
+				bind(rename.Identifier, root.Field(index, tuple.Fields[index].Type))
+			}
+		}
+	}
+	return bindings
+}
+
+// part returns the path of the i'th field or element of the tuple or array
+// at p.
+func part(p *Path, i int) *Path {
+	switch t := p.Type.Underlying().(type) {
+	case *types.Array:
+		return p.Elem(i, t.Elem)
+	case *types.Tuple:
+		if i < len(t.Fields) {
+			return p.Field(i, t.Fields[i].Type)
+		}
+	}
+	return p.Field(i, types.Typ[types.Invalid])
+}
+
+// rest returns the path of the fields or elements from i on of the tuple or
+// array at p.
+func rest(p *Path, i int) *Path {
+	switch t := p.Type.Underlying().(type) {
+	case *types.Array:
+		return p.Rest(i, types.NewArray(t.Elem))
+	case *types.Tuple:
+		if i <= len(t.Fields) {
+			return p.Rest(i, types.NewTuple(t.Fields[i:]...))
+		}
+	}
+	return p.Rest(i, types.Typ[types.Invalid])
+}
@@ -0,0 +1,281 @@
+// Package match compiles the cases of a switch expression into a decision
+// tree, which finds the first case matching the switch subject by testing
+// its parts, without repeating a test whose outcome is already known.
+//
+// The tree tests the tags of union values, the values of scalars against
+// constants and ranges, and the lengths of arrays; tuples are destructured
+// without a test. Each leaf selects a case and binds the parameters of its
+// block to parts of the subject. Interpreters and code generators walk the
+// tree rather than the patterns.
+package match
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// StepKind identifies how a path selects a part of its parent.
+type StepKind int
+
+const (
+	Root  StepKind = iota // the subject of the switch
+	Field                 // field Index of a tuple
+	Elem                  // element Index of an array
+	Rest                  // the fields or elements of a tuple or array from Index on
+	As                    // the value of a union holding a value of type Type
+)
+
+// Path identifies a part of the subject of a switch.
+type Path struct {
+	Parent *Path
+	Kind   StepKind
+	Index  int
+	Type   types.Type // type of the part
+}
+
+// Field returns the path of the i'th field of the tuple at p.
+func (p *Path) Field(i int, typ types.Type) *Path {
+	return &Path{Parent: p, Kind: Field, Index: i, Type: typ}
+}
+
+// Elem returns the path of the i'th element of the array at p.
+func (p *Path) Elem(i int, typ types.Type) *Path {
+	return &Path{Parent: p, Kind: Elem, Index: i, Type: typ}
+}
+
+// Rest returns the path of the fields or elements from i on of the tuple
+// or array at p.
+func (p *Path) Rest(i int, typ types.Type) *Path {
+	return &Path{Parent: p, Kind: Rest, Index: i, Type: typ}
+}
+
+// As returns the path of the value of the union at p, once it is known to
+// hold a value of type typ.
+func (p *Path) As(typ types.Type) *Path {
+	return &Path{Parent: p, Kind: As, Type: typ}
+}
+
+func (p *Path) String() string {
+	switch p.Kind {
+	case Field:
+		return fmt.Sprintf("%s.%d", p.Parent, p.Index)
+	case Elem:
+		return fmt.Sprintf("%s[%d]", p.Parent, p.Index)
+	case Rest:
+		return fmt.Sprintf("%s[%d:]", p.Parent, p.Index)
+	case As:
+		return fmt.Sprintf("%s.(%s)", p.Parent, p.Type)
+	}
+	return "$"
+}
+
+// TestKind identifies the kind of a test.
+type TestKind int
+
+const (
+	Tag     TestKind = iota // the union holds a value of type Type
+	Equal                   // the value equals Value
+	InRange                 // the value is within Lo..Hi, inclusive
+	Len                     // the array has exactly N elements
+	MinLen                  // the array has at least N elements
+)
+
+// Test is a test of the part of the subject at a path.
+type Test struct {
+	Kind   TestKind
+	Type   types.Type
+	Value  consteval.Value // for Equal
+	Lo, Hi consteval.Value // for InRange
+	N      int             // for Len and MinLen
+}
+
+func (t *Test) String() string {
+	switch t.Kind {
+	case Equal:
+		return "== " + t.Value.String()
+	case InRange:
+		return "in " + t.Lo.String() + ".." + t.Hi.String()
+	case Len:
+		return fmt.Sprintf("len == %d", t.N)
+	case MinLen:
+		return fmt.Sprintf("len >= %d", t.N)
+	}
+	return "is " + t.Type.String()
+}
+
+// ElseType returns the type of the subjects of the switch e that reach its
+// else block: the members of a union subject that no case selects by its
+// type alone, or the type of the subject if every member may reach it.
+func ElseType(e *ast.SwitchExpression, info Info) types.Type {
+	subject := info.TypeOf(e.Expression)
+	if subject == nil {
+		return nil
+	}
+	union, ok := subject.Underlying().(*types.Union)
+	if !ok {
+		return subject
+	}
+	covered := make([]bool, len(union.Members))
+	var cover func(p ast.Node)
+	cover = func(p ast.Node) {
+		switch p := p.(type) {
+		case *ast.ListMatch:
+			for _, elem := range p.Elements {
+				cover(elem)
+			}
+		case *ast.TypeReference:
+			for i, m := range union.Members {
+				if typ := info.TypeOf(p); typ != nil && types.Identical(m, typ) {
+					covered[i] = true
+				}
+			}
+		}
+	}
+	for _, sc := range e.Cases {
+		cover(sc.Condition)
+	}
+	var rest []types.Type
+	for i, m := range union.Members {
+		if !covered[i] {
+			rest = append(rest, m)
+		}
+	}
+	if len(rest) == 0 || len(rest) == len(union.Members) {
+		return subject
+	}
+	return types.NewUnion(rest...)
+}
+
+// Node is a node of a decision tree: a *Switch, *Leaf or *Fail.
+type Node interface {
+	node()
+}
+
+// Switch tests the part of the subject at Path. The cases are tried in
+// order, continuing with the node of the first whose test succeeds, or
+// with Default if none does. Default is nil if the cases cover every
+// value of the part.
+type Switch struct {
+	Path    *Path
+	Cases   []*Case
+	Default Node
+}
+
+// Case is a test of a switch and the node to continue with if it succeeds.
+type Case struct {
+	Test *Test
+	Node Node
+}
+
+// Leaf selects a case of the switch expression, or its else block if Case
+// is Else, and binds the parameters of its block.
+type Leaf struct {
+	Case     int
+	Bindings []*Binding
+}
+
+// Else is the Case of the leaves selecting the else block.
+const Else = -1
+
+// Binding binds a block parameter to a part of the subject.
+type Binding struct {
+	Ident *ast.Identifier
+	Path  *Path
+}
+
+// Fail is reached when no case matches the subject.
+type Fail struct{}
+
+func (*Switch) node() {}
+func (*Leaf) node()   {}
+func (*Fail) node()   {}
+
+// Tree is the decision tree of a switch expression.
+type Tree struct {
+	Switch *ast.SwitchExpression
+	// Subject is the root path, whose type is that of the subject.
+	Subject *Path
+	Root    Node
+}
+
+// Exhaustive reports whether every value of the subject is matched by a
+// case or the else block.
+func (t *Tree) Exhaustive() bool {
+	exhaustive := true
+	t.walk(t.Root, func(n Node) {
+		if _, ok := n.(*Fail); ok {
+			exhaustive = false
+		}
+	})
+	return exhaustive
+}
+
+// Reachable reports whether the i'th case, or the else block if i is Else,
+// is selected by any leaf.
+func (t *Tree) Reachable(i int) bool {
+	reachable := false
+	t.walk(t.Root, func(n Node) {
+		if leaf, ok := n.(*Leaf); ok && leaf.Case == i {
+			reachable = true
+		}
+	})
+	return reachable
+}
+
+func (t *Tree) walk(n Node, f func(Node)) {
+	f(n)
+	if s, ok := n.(*Switch); ok {
+		for _, c := range s.Cases {
+			t.walk(c.Node, f)
+		}
+		if s.Default != nil {
+			t.walk(s.Default, f)
+		}
+	}
+}
+
+// String returns an indented dump of the tree, for debugging.
+func (t *Tree) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "switch %s: %s\n", t.Switch.Expression, t.Subject.Type)
+	t.dump(&builder, t.Root, 1)
+	return builder.String()
+}
+
+func (t *Tree) dump(builder *strings.Builder, n Node, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch n := n.(type) {
+	case *Switch:
+		fmt.Fprintf(builder, "%stest %s: %s\n", indent, n.Path, n.Path.Type)
+		for _, c := range n.Cases {
+			fmt.Fprintf(builder, "%s  %s:\n", indent, c.Test)
+			t.dump(builder, c.Node, depth+2)
+		}
+		if n.Default != nil {
+			fmt.Fprintf(builder, "%s  else:\n", indent)
+			t.dump(builder, n.Default, depth+2)
+		}
+	case *Leaf:
+		builder.WriteString(indent)
+		if n.Case == Else {
+			builder.WriteString("match else")
+		} else {
+			fmt.Fprintf(builder, "match %d: %s", n.Case, t.Switch.Cases[n.Case].Condition)
+		}
+		for i, b := range n.Bindings {
+			if i == 0 {
+				builder.WriteString(" with ")
+			} else {
+				builder.WriteString(", ")
+			}
+			fmt.Fprintf(builder, "%s = %s", b.Ident.Name, b.Path)
+		}
+		builder.WriteString("\n")
+	case *Fail:
+		fmt.Fprintf(builder, "%sno match\n", indent)
+	}
+}
@@ -0,0 +1,46 @@
+package match
+
+import (
+	"testing"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+func TestPath(t *testing.T) {
+	point := types.NewNamed("Point", types.NewTuple(types.NewField("x", types.Int), types.NewField("y", types.Int)))
+	root := &Path{Kind: Root, Type: types.NewUnion(point, types.Typ[types.Nil])}
+	tests := []struct {
+		path *Path
+		want string
+	}{
+		{root, "$"},
+		{root.As(point), "$.(Point)"},
+		{root.As(point).Field(1, types.Int), "$.(Point).1"},
+		{root.Elem(2, types.Int), "$[2]"},
+		{root.Rest(1, types.NewArray(types.Int)), "$[1:]"},
+	}
+	for _, tt := range tests {
+		if got := tt.path.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestTestString(t *testing.T) {
+	tests := []struct {
+		test *Test
+		want string
+	}{
+		{&Test{Kind: Tag, Type: types.Int}, "is Int"},
+		{&Test{Kind: Equal, Value: consteval.String("a")}, `== "a"`},
+		{&Test{Kind: InRange, Lo: consteval.NewInt(1), Hi: consteval.NewInt(9)}, "in 1..9"},
+		{&Test{Kind: Len, N: 2}, "len == 2"},
+		{&Test{Kind: MinLen, N: 1}, "len >= 1"},
+	}
+	for _, tt := range tests {
+		if got := tt.test.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
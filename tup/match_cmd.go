@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/match"
+	"github.com/spf13/pflag"
+)
+
+// matchCommand prints the decision trees compiled from the switch
+// expressions of a module, in source order:
+//
+//	tup match file.tup
+func matchCommand(args []string) error {
+	flags := pflag.NewFlagSet("match", pflag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: tup match file.tup")
+	}
+	info, err := checkFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	trees := make([]*match.Tree, 0, len(info.Matches))
+	for _, tree := range info.Matches {
+		trees = append(trees, tree)
+	}
+	sort.Slice(trees, func(i, j int) bool {
+		return ast.PosOf(trees[i].Switch).Offset < ast.PosOf(trees[j].Switch).Offset
+	})
+	for i, tree := range trees {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s:\n%s", ast.PosOf(tree.Switch), tree)
+	}
+	return nil
+}
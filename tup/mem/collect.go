@@ -0,0 +1,161 @@
+package mem
+
+import "github.com/rowland/tuppence/tup/types"
+
+// color is the state of an object in cycle collection.
+type color uint8
+
+const (
+	black  color = iota // in use, or free
+	gray                // possibly part of a garbage cycle
+	white               // part of a garbage cycle
+	purple              // a possible root of a cycle
+)
+
+// cyclicTypes reports for each type whether its objects may be part of a
+// cycle: whether they may hold functions, directly or through the objects
+// they refer to.
+func cyclicTypes(descs []*types.Descriptor) []bool {
+	cyclic := make([]bool, len(descs))
+	holds := func(tag types.Tag) bool {
+		return tag.Index() < len(cyclic) && cyclic[tag.Index()]
+	}
+	// recursive types refer to each other, so iterate until nothing
+	// changes
+	for changed := true; changed; {
+		changed = false
+		for i, d := range descs {
+			if cyclic[i] {
+				continue
+			}
+			switch d.Kind {
+			case types.FunctionDescriptor:
+				cyclic[i] = true
+			case types.TupleDescriptor:
+				for _, field := range d.Fields {
+					cyclic[i] = cyclic[i] || holds(field.Type)
+				}
+			case types.ArrayDescriptor:
+				cyclic[i] = holds(d.Elem)
+			case types.UnionDescriptor:
+				for _, member := range d.Members {
+					cyclic[i] = cyclic[i] || holds(member)
+				}
+			case types.NamedDescriptor:
+				cyclic[i] = holds(d.Underlying)
+			}
+			changed = changed || cyclic[i]
+		}
+	}
+	return cyclic
+}
+
+// possibleRoot records that obj, which is still referenced after one of
+// its references was released, may be the root of a garbage cycle.
+func (h *Heap) possibleRoot(obj *Object) {
+	if i := obj.Type.Index(); i >= len(h.cyclic) || !h.cyclic[i] || obj.color == purple {
+		return
+	}
+	obj.color = purple
+	if !obj.buffered {
+		obj.buffered = true
+		h.roots = append(h.roots, obj)
+	}
+}
+
+// Collect frees the garbage cycles reachable from the possible roots of
+// cycles, and returns the number of objects it freed. A cycle is garbage
+// if removing the references among its objects leaves none; Collect finds
+// them by trial deletion, as described by Bacon and Rajan in "Concurrent
+// Cycle Collection in Reference Counted Systems".
+func (h *Heap) Collect() int {
+	if len(h.roots) == 0 {
+		return 0
+	}
+	h.stats.Collections++
+	roots := make([]*Object, 0, len(h.roots))
+	for _, obj := range h.roots {
+		if obj.color == purple && obj.refs > 0 {
+			h.markGray(obj)
+			roots = append(roots, obj)
+			continue
+		}
+		obj.buffered = false
+		if obj.color == black && obj.refs == 0 {
+			h.freeObject(obj)
+		}
+	}
+	h.roots = h.roots[:0]
+	for _, obj := range roots {
+		h.scan(obj)
+	}
+	freed := 0
+	for _, obj := range roots {
+		obj.buffered = false
+		freed += h.collectWhite(obj)
+	}
+	h.stats.Collected += freed
+	return freed
+}
+
+// children calls f with the counted objects obj refers to.
+func children(obj *Object, f func(*Object)) {
+	for _, e := range obj.Elems {
+		if child := counted(e); child != nil {
+			f(child)
+		}
+	}
+}
+
+// markGray removes the references among the objects reachable from obj.
+func (h *Heap) markGray(obj *Object) {
+	if obj.color == gray {
+		return
+	}
+	obj.color = gray
+	children(obj, func(child *Object) {
+		child.refs--
+		h.markGray(child)
+	})
+}
+
+// scan marks white the objects reachable from obj that are referenced
+// only from among them, and restores the references of the others.
+func (h *Heap) scan(obj *Object) {
+	if obj.color != gray {
+		return
+	}
+	if obj.refs > 0 {
+		h.scanBlack(obj)
+		return
+	}
+	obj.color = white
+	children(obj, h.scan)
+}
+
+// scanBlack restores the references among the objects reachable from obj,
+// which is referenced from outside them.
+func (h *Heap) scanBlack(obj *Object) {
+	obj.color = black
+	children(obj, func(child *Object) {
+		child.refs++
+		if child.color != black {
+			h.scanBlack(child)
+		}
+	})
+}
+
+// collectWhite frees the white objects reachable from obj, and returns
+// their number.
+func (h *Heap) collectWhite(obj *Object) int {
+	if obj.color != white || obj.buffered {
+		return 0
+	}
+	obj.color = black
+	freed := 1
+	children(obj, func(child *Object) {
+		freed += h.collectWhite(child)
+	})
+	h.freeObject(obj)
+	return freed
+}
@@ -0,0 +1,302 @@
+// Package mem manages the memory of running Tuppence programs: the tuples,
+// arrays and unions they build, which are allocated as Objects from a Heap.
+//
+// Objects are reference counted. Since values are immutable, an object
+// holds references only to objects built before it, and no cycle can form
+// but through a closure holding itself. Objects whose types may hold
+// closures are watched for cycles when a reference to them is released,
+// and a cycle collector frees the cycles no longer referenced from outside
+// them, by trial deletion.
+//
+// Counting references lets the heap reuse the storage of values no longer
+// referenced, and lets updates and appends reuse the storage of the value
+// they update in place when it is referenced only by their operand, which
+// they consume. Otherwise an update copies the tuple it updates, sharing
+// the values its fields refer to.
+package mem
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// Value is a value of a running program. Its representation depends on
+// its type:
+//
+//   - integers, enums and Bools are held in N, signed integers sign
+//     extended and the others zero extended; floats are held in N as the
+//     bits of a float64, rounded to float32 for Float32 and Float16; nil
+//     is the zero Value;
+//   - strings, symbols and errors are held in R as a Go string, errors as
+//     their messages;
+//   - tuples and fixed-size arrays are held in R as an *Object whose Elems
+//     are their fields or elements;
+//   - dynamic arrays are held as their length in N and an *Object in R
+//     whose Elems begin with their elements, shared with the arrays
+//     appended to them;
+//   - unions are held as the index of the member held in N and an *Object
+//     in R whose one element is the value held;
+//   - functions are held in R as the function of the program running,
+//     and closures as an *Object whose Elems are the values they
+//     capture.
+//
+// A Value holding an *Object allocated from a Heap holds a reference to
+// it, which is counted.
+type Value struct {
+	N uint64
+	R any
+}
+
+// Int returns v as a signed integer.
+func (v Value) Int() int64 { return int64(v.N) }
+
+// Float returns v as a float.
+func (v Value) Float() float64 { return math.Float64frombits(v.N) }
+
+// Bool returns v as a Bool.
+func (v Value) Bool() bool { return v.N != 0 }
+
+// Str returns v as a string, symbol or error.
+func (v Value) Str() string {
+	s, _ := v.R.(string)
+	return s
+}
+
+// Object returns the object v refers to, or nil.
+func (v Value) Object() *Object {
+	obj, _ := v.R.(*Object)
+	return obj
+}
+
+// Object is a value held by reference. Objects built other than by a Heap
+// are not counted, and live as long as anything refers to them.
+type Object struct {
+	// Type is the tag of the type of the value, with the reference bit of
+	// the type-tag encoding set for dynamic arrays.
+	Type  types.Tag
+	Elems []Value
+
+	refs     int32
+	counted  bool
+	color    color
+	buffered bool // held by the heap's possible roots of cycles
+}
+
+// Refs returns the number of references to obj, or 0 if they are not
+// counted.
+func (obj *Object) Refs() int { return int(obj.refs) }
+
+// counted returns the object v refers to if its references are counted.
+func counted(v Value) *Object {
+	obj, ok := v.R.(*Object)
+	if !ok || !obj.counted {
+		return nil
+	}
+	return obj
+}
+
+const (
+	// minCap is the capacity of the storage of a new dynamic array, which
+	// leaves room for appending to it.
+	minCap = 4
+	// maxFree bounds the capacity of the storage the heap keeps for
+	// reuse, and maxFreeList the number of objects of each capacity kept.
+	maxFree     = 16
+	maxFreeList = 1024
+	// collectAt is the number of possible roots of cycles at which an
+	// allocation collects cycles.
+	collectAt = 10_000
+)
+
+// Heap allocates the objects of a program.
+type Heap struct {
+	// cyclic is indexed by type: objects of the type may be part of a
+	// cycle
+	cyclic []bool
+	// free holds freed objects for reuse, by the capacity of their
+	// storage
+	free  [maxFree][]*Object
+	roots []*Object // possible roots of cycles
+	work  []*Object
+	stats Stats
+}
+
+// Stats holds the allocation statistics of a heap.
+type Stats struct {
+	Allocs  int // objects allocated
+	Reused  int // allocations that reused the storage of a freed object
+	Frees   int // objects freed
+	Live    int // objects allocated and not freed
+	MaxLive int // the most objects live at once
+
+	// InPlace counts the updates and appends that reused the storage of
+	// the value updated, Shared the appends that shared the storage of the
+	// array appended to with the arrays appended to before, and Copies the
+	// updates and appends that copied it.
+	InPlace int
+	Shared  int
+	Copies  int
+
+	Collections int // cycle collections
+	Collected   int // objects freed by cycle collections
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("allocs %d (reused %d), frees %d, live %d (max %d), in place %d, shared %d, copies %d, collections %d (freed %d)",
+		s.Allocs, s.Reused, s.Frees, s.Live, s.MaxLive, s.InPlace, s.Shared, s.Copies, s.Collections, s.Collected)
+}
+
+// NewHeap returns a heap for the objects of the types descs describes,
+// indexed by their tags.
+func NewHeap(descs []*types.Descriptor) *Heap {
+	return &Heap{cyclic: cyclicTypes(descs)}
+}
+
+// Stats returns the allocation statistics of h.
+func (h *Heap) Stats() Stats { return h.stats }
+
+// New returns a new object of the type identified by tag, with n elements
+// and a reference. The objects of dynamic arrays, whose tags have the
+// reference bit set, have room to append to.
+func (h *Heap) New(tag types.Tag, n int) *Object {
+	if len(h.roots) >= collectAt {
+		h.Collect()
+	}
+	h.stats.Allocs++
+	h.stats.Live++
+	h.stats.MaxLive = max(h.stats.MaxLive, h.stats.Live)
+	var obj *Object
+	if n < maxFree && len(h.free[n]) > 0 {
+		list := h.free[n]
+		obj = list[len(list)-1]
+		h.free[n] = list[:len(list)-1]
+		obj.Elems = obj.Elems[:n]
+		h.stats.Reused++
+	} else {
+		size := n
+		if tag.IsRef() {
+			size = max(n, minCap)
+		}
+		obj = &Object{Elems: make([]Value, n, size)}
+	}
+	obj.Type, obj.refs, obj.counted, obj.color = tag, 1, true, black
+	return obj
+}
+
+// Retain adds a reference to the object v refers to.
+func (h *Heap) Retain(v Value) {
+	if obj := counted(v); obj != nil {
+		obj.refs++
+	}
+}
+
+// Release drops a reference to the object v refers to, freeing it, and
+// releasing the references it holds, once none is left.
+func (h *Heap) Release(v Value) {
+	if obj := counted(v); obj != nil {
+		h.release(obj)
+	}
+}
+
+func (h *Heap) release(obj *Object) {
+	obj.refs--
+	if obj.refs > 0 {
+		h.possibleRoot(obj)
+		return
+	}
+	// the objects freed are released without recursion, as lists may be
+	// long
+	work := append(h.work[:0], obj)
+	for len(work) > 0 {
+		obj := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, e := range obj.Elems {
+			if child := counted(e); child != nil {
+				child.refs--
+				if child.refs == 0 {
+					work = append(work, child)
+				} else {
+					h.possibleRoot(child)
+				}
+			}
+		}
+		obj.color = black
+		if !obj.buffered {
+			h.freeObject(obj)
+		}
+	}
+	h.work = work
+}
+
+// freeObject frees obj, keeping its storage for reuse.
+func (h *Heap) freeObject(obj *Object) {
+	h.stats.Frees++
+	h.stats.Live--
+	clear(obj.Elems)
+	obj.refs = 0
+	if c := cap(obj.Elems); c < maxFree && len(h.free[c]) < maxFreeList {
+		obj.Elems = obj.Elems[:0]
+		h.free[c] = append(h.free[c], obj)
+	}
+}
+
+// Update returns the tuple v with its field i replaced by x. It consumes
+// the references v and x hold, and updates v in place if no other
+// reference to it is left; otherwise the copy shares the values the other
+// fields refer to.
+func (h *Heap) Update(v Value, i int, x Value) Value {
+	obj := v.R.(*Object)
+	if obj.counted && obj.refs == 1 {
+		h.Release(obj.Elems[i])
+		obj.Elems[i] = x
+		h.stats.InPlace++
+		return v
+	}
+	cp := h.New(obj.Type, len(obj.Elems))
+	copy(cp.Elems, obj.Elems)
+	for j, e := range cp.Elems {
+		if j != i {
+			h.Retain(e)
+		}
+	}
+	cp.Elems[i] = x
+	h.Release(v)
+	h.stats.Copies++
+	return Value{N: v.N, R: cp}
+}
+
+// Append returns the dynamic array a, of the type identified by tag, with
+// x appended. It consumes the references a and x hold. The storage of a
+// is extended in place if no other reference to it is left, or if the
+// arrays sharing it end before a does; otherwise a is copied.
+func (h *Heap) Append(tag types.Tag, a, x Value) Value {
+	obj, _ := a.R.(*Object)
+	n := int(a.N)
+	switch {
+	case obj == nil:
+		obj = h.New(tag, 0)
+	case obj.counted && obj.refs == 1:
+		// the elements past the end of a are no longer reachable
+		for _, e := range obj.Elems[n:] {
+			h.Release(e)
+		}
+		clear(obj.Elems[n:])
+		obj.Elems = obj.Elems[:n]
+		h.stats.InPlace++
+	case len(obj.Elems) == n:
+		h.stats.Shared++
+	default:
+		cp := h.New(tag, n)
+		copy(cp.Elems, obj.Elems[:n])
+		for _, e := range cp.Elems {
+			h.Retain(e)
+		}
+		h.Release(a)
+		obj = cp
+		h.stats.Copies++
+	}
+	obj.Elems = append(obj.Elems, x)
+	return Value{N: uint64(n + 1), R: obj}
+}
@@ -0,0 +1,242 @@
+package mem
+
+import (
+	"testing"
+
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// testTypes are the types of the objects the tests allocate.
+type testTypes struct {
+	descs                  []*types.Descriptor
+	pair, array, fn, boxed types.Tag
+}
+
+func newTestTypes() *testTypes {
+	table := types.NewTable()
+	fn := types.NewFunction(nil, types.Int, false)
+	tt := &testTypes{
+		pair:  table.Add(types.NewTuple(types.NewField("", types.Int), types.NewField("", types.Int))).Tag,
+		array: table.Add(types.NewArray(types.Int)).Tag,
+		fn:    table.Add(fn).Tag,
+		boxed: table.Add(types.NewTuple(types.NewField("f", fn))).Tag,
+	}
+	for i := range table.Len() {
+		tt.descs = append(tt.descs, table.Descriptor(types.MakeTag(i, false)))
+	}
+	return tt
+}
+
+func ints(xs ...int64) []Value {
+	values := make([]Value, len(xs))
+	for i, x := range xs {
+		values[i] = Value{N: uint64(x)}
+	}
+	return values
+}
+
+func (tt *testTypes) newPair(h *Heap, x, y int64) Value {
+	obj := h.New(tt.pair, 2)
+	copy(obj.Elems, ints(x, y))
+	return Value{R: obj}
+}
+
+func TestRelease(t *testing.T) {
+	tt := newTestTypes()
+	h := NewHeap(tt.descs)
+	inner := tt.newPair(h, 1, 2)
+	outer := h.New(tt.pair, 2)
+	outer.Elems[0] = inner
+	h.Retain(inner)
+	outer.Elems[1] = inner
+	v := Value{R: outer}
+
+	h.Retain(v)
+	h.Release(v)
+	if got := outer.Refs(); got != 1 {
+		t.Fatalf("Refs() = %d after Retain and Release, want 1", got)
+	}
+	h.Release(v)
+	if s := h.Stats(); s.Allocs != 2 || s.Frees != 2 || s.Live != 0 || s.MaxLive != 2 {
+		t.Errorf("Stats() = %v, want 2 objects allocated and freed", s)
+	}
+
+	// the storage of the objects freed is reused
+	h.New(tt.pair, 2)
+	if s := h.Stats(); s.Reused != 1 || s.Live != 1 {
+		t.Errorf("Stats() = %v, want 1 reused and live", s)
+	}
+
+	// objects not allocated from the heap are not counted
+	h.Release(Value{R: &Object{Type: tt.pair, Elems: ints(1, 2)}})
+	if s := h.Stats(); s.Frees != 2 {
+		t.Errorf("Stats() = %v after releasing an uncounted object, want 2 frees", s)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tt := newTestTypes()
+	h := NewHeap(tt.descs)
+	v := tt.newPair(h, 1, 2)
+	if u := h.Update(v, 1, Value{N: 3}); u.R != v.R || u.Object().Elems[1].N != 3 {
+		t.Errorf("Update of an unshared tuple = %v, want it updated in place", u.Object().Elems)
+	}
+
+	h.Retain(v)
+	u := h.Update(v, 0, Value{N: 4})
+	if u.R == v.R {
+		t.Fatalf("Update of a shared tuple updated it in place")
+	}
+	if got, want := v.Object().Elems, ints(1, 3); got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("tuple updated = %v, want %v", got, want)
+	}
+	if got, want := u.Object().Elems, ints(4, 3); got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Update() = %v, want %v", got, want)
+	}
+	if s := h.Stats(); s.InPlace != 1 || s.Copies != 1 {
+		t.Errorf("Stats() = %v, want 1 in place and 1 copy", s)
+	}
+	h.Release(u)
+	h.Release(v)
+	if s := h.Stats(); s.Live != 0 {
+		t.Errorf("Stats() = %v, want nothing live", s)
+	}
+}
+
+func TestUpdateShares(t *testing.T) {
+	tt := newTestTypes()
+	h := NewHeap(tt.descs)
+	inner := tt.newPair(h, 1, 2)
+	outer := h.New(tt.pair, 2)
+	outer.Elems[0] = inner
+	v := Value{R: outer}
+	h.Retain(v)
+	u := h.Update(v, 1, Value{N: 5})
+	if u.Object().Elems[0].R != inner.R || inner.Object().Refs() != 2 {
+		t.Errorf("Update() does not share the field it does not replace")
+	}
+	h.Release(v)
+	h.Release(u)
+	if s := h.Stats(); s.Live != 0 {
+		t.Errorf("Stats() = %v, want nothing live", s)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	tt := newTestTypes()
+	h := NewHeap(tt.descs)
+	var a Value
+	for i := range 10 {
+		a = h.Append(tt.array, a, Value{N: uint64(i)})
+	}
+	if s := h.Stats(); s.Allocs != 1 || s.InPlace != 9 {
+		t.Errorf("Stats() = %v building an array, want 1 alloc and 9 appends in place", s)
+	}
+
+	// b shares the storage of a, which ends where it does
+	h.Retain(a)
+	b := h.Append(tt.array, a, Value{N: 10})
+	if b.R != a.R || h.Stats().Shared != 1 {
+		t.Errorf("Append() to the end of shared storage = %v, want it shared", h.Stats())
+	}
+	// appending to a again copies it, leaving b as it was
+	h.Retain(a)
+	c := h.Append(tt.array, a, Value{N: 20})
+	if c.R == a.R || h.Stats().Copies != 1 {
+		t.Errorf("Append() within shared storage = %v, want a copy", h.Stats())
+	}
+	if got := b.Object().Elems[10].N; got != 10 {
+		t.Errorf("b[10] = %d after appending to a, want 10", got)
+	}
+	if got := c.Object().Elems[10].N; got != 20 || c.N != 11 {
+		t.Errorf("c[10] = %d, len %d, want 20, 11", got, c.N)
+	}
+
+	// once a and b are released, the storage is extended in place
+	h.Release(b)
+	d := h.Append(tt.array, a, Value{N: 30})
+	if d.R != a.R || d.Object().Elems[10].N != 30 {
+		t.Errorf("Append() to unshared storage = %v, want it extended in place", d.Object().Elems)
+	}
+	h.Release(c)
+	h.Release(d)
+	if s := h.Stats(); s.Live != 0 {
+		t.Errorf("Stats() = %v, want nothing live", s)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	tt := newTestTypes()
+	h := NewHeap(tt.descs)
+
+	// a closure holding the tuple holding it
+	closure := h.New(tt.fn, 1)
+	boxed := h.New(tt.boxed, 1)
+	boxed.Elems[0] = Value{R: closure}
+	closure.Elems[0] = Value{R: boxed}
+	h.Retain(closure.Elems[0])
+	h.Release(Value{R: boxed})
+	if s := h.Stats(); s.Live != 2 {
+		t.Fatalf("Stats() = %v, want the cycle live", s)
+	}
+
+	// a tuple referenced from outside is left alone
+	kept := tt.newPair(h, 1, 2)
+	h.Retain(kept)
+	h.Release(kept)
+
+	if got := h.Collect(); got != 2 {
+		t.Errorf("Collect() = %d, want 2", got)
+	}
+	if s := h.Stats(); s.Live != 1 || s.Collections != 1 || s.Collected != 2 {
+		t.Errorf("Stats() = %v, want the cycle collected", s)
+	}
+	if got := h.Collect(); got != 0 {
+		t.Errorf("Collect() = %d with nothing to collect, want 0", got)
+	}
+}
+
+func TestCollectReferenced(t *testing.T) {
+	tt := newTestTypes()
+	h := NewHeap(tt.descs)
+	closure := h.New(tt.fn, 1)
+	boxed := h.New(tt.boxed, 1)
+	boxed.Elems[0] = Value{R: closure}
+	closure.Elems[0] = Value{R: boxed}
+	h.Retain(closure.Elems[0])
+	// the closure is still referenced from outside the cycle
+	h.Retain(boxed.Elems[0])
+	h.Release(Value{R: boxed})
+
+	if got := h.Collect(); got != 0 {
+		t.Errorf("Collect() = %d, want 0", got)
+	}
+	if closure.Refs() != 2 || boxed.Refs() != 1 {
+		t.Errorf("Refs() = %d, %d after Collect, want 2, 1", closure.Refs(), boxed.Refs())
+	}
+	h.Release(Value{R: closure})
+	if got := h.Collect(); got != 2 {
+		t.Errorf("Collect() = %d once the cycle is unreferenced, want 2", got)
+	}
+	if s := h.Stats(); s.Live != 0 {
+		t.Errorf("Stats() = %v, want nothing live", s)
+	}
+}
+
+func TestCyclicTypes(t *testing.T) {
+	tt := newTestTypes()
+	cyclic := cyclicTypes(tt.descs)
+	for _, test := range []struct {
+		tag  types.Tag
+		want bool
+	}{
+		{tt.pair, false},
+		{tt.array, false},
+		{tt.fn, true},
+		{tt.boxed, true},
+	} {
+		if got := cyclic[test.tag.Index()]; got != test.want {
+			t.Errorf("cyclic[%s] = %v, want %v", tt.descs[test.tag.Index()].Type, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package opt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ir"
+)
+
+// CSE eliminates common subexpressions in the functions of m: an
+// instruction computing the same operation on the same operands as one
+// that dominates it is replaced by that one. Calls of fn functions are
+// operations like any other, since they have no side effects; calls of fx
+// functions and terminators are left alone. Operations that may trap are
+// shared too, since the dominating one has not trapped. Taking apart an
+// aggregate built by the function, as the field of a tuple or the payload
+// of a union, is replaced by the part it was built from, or that an update
+// replaced. Phis of a block selecting the same values are merged once the
+// values they select have been shared.
+func CSE(m *ir.Module) {
+	funcs(m, cse)
+}
+
+func cse(fn *ir.Func) {
+	fn.Update()
+	dom := ir.Dominators(fn)
+	children := map[*ir.Block][]*ir.Block{}
+	for _, b := range fn.Blocks {
+		if idom := dom.Idom(b); idom != nil {
+			children[idom] = append(children[idom], b)
+		}
+	}
+
+	// available maps the keys of the instructions of the blocks
+	// dominating the one visited to the instructions
+	available := map[string]*ir.Instr{}
+	var visit func(b *ir.Block)
+	visit = func(b *ir.Block) {
+		var added []string
+		// Replace removes instructions from b, so iterate over a copy
+		for _, instr := range slices.Clone(b.Instrs) {
+			if part := forward(instr); part != nil {
+				fn.Replace(instr, part)
+				continue
+			}
+			k, ok := key(instr)
+			if !ok {
+				continue
+			}
+			if prev := available[k]; prev != nil {
+				fn.Replace(instr, prev)
+				continue
+			}
+			available[k] = instr
+			added = append(added, k)
+		}
+		for _, child := range children[b] {
+			visit(child)
+		}
+		for _, k := range added {
+			delete(available, k)
+		}
+	}
+	visit(fn.Blocks[0])
+	mergePhis(fn)
+	fn.Update()
+}
+
+// mergePhis replaces each phi of fn selecting the same values from the same
+// predecessors as an earlier phi of its block by that one. Merging phis may
+// make others select the same values, as those of a loop whose state parts
+// are all equal, so it is repeated until nothing changes.
+func mergePhis(fn *ir.Func) {
+	for changed := true; changed; {
+		changed = false
+		for _, b := range fn.Blocks {
+			phis := map[string]*ir.Instr{}
+			for _, instr := range slices.Clone(b.Instrs) {
+				if instr.Op != ir.OpPhi {
+					continue
+				}
+				k := phiKey(instr)
+				if prev := phis[k]; prev != nil {
+					fn.Replace(instr, prev)
+					changed = true
+					continue
+				}
+				phis[k] = instr
+			}
+		}
+	}
+}
+
+// phiKey returns a key identifying the values phi selects.
+func phiKey(phi *ir.Instr) string {
+	var k strings.Builder
+	fmt.Fprintf(&k, "%s", phi.Typ)
+	for i, arg := range phi.Args {
+		fmt.Fprintf(&k, " %p: %p", phi.Blocks[i], arg)
+	}
+	return k.String()
+}
+
+// key returns a key identifying the operation instr computes, and whether
+// it may be shared.
+func key(instr *ir.Instr) (string, bool) {
+	switch {
+	case instr.Typ == nil, instr.Op == ir.OpPhi, instr.Op.IsTerminator():
+		return "", false
+	case instr.Op == ir.OpCall && instr.Fx:
+		return "", false
+	}
+	var k strings.Builder
+	fmt.Fprintf(&k, "%s %s %d @%s", instr.Op, instr.Typ, instr.Index, instr.Callee)
+	if instr.Const != nil {
+		fmt.Fprintf(&k, " %s", instr.Const)
+	}
+	for _, arg := range instr.Args {
+		fmt.Fprintf(&k, " %p", arg)
+	}
+	return k.String(), true
+}
+
+// forward returns the value instr yields by taking apart an aggregate the
+// function builds, or nil. A field not replaced by an update is taken from
+// the tuple updated instead.
+func forward(instr *ir.Instr) ir.Value {
+	if instr.Op != ir.OpField && instr.Op != ir.OpPayload {
+		return nil
+	}
+	agg, ok := instr.Args[0].(*ir.Instr)
+	for ok && instr.Op == ir.OpField && agg.Op == ir.OpUpdate && agg.Index != instr.Index {
+		instr.Args[0] = agg.Args[0]
+		agg, ok = instr.Args[0].(*ir.Instr)
+	}
+	switch {
+	case !ok:
+	case instr.Op == ir.OpField && agg.Op == ir.OpTuple:
+		return agg.Args[instr.Index]
+	case instr.Op == ir.OpField && agg.Op == ir.OpUpdate:
+		return agg.Args[1]
+	case instr.Op == ir.OpPayload && agg.Op == ir.OpWrap && agg.Index == instr.Index:
+		return agg.Args[0]
+	}
+	return nil
+}
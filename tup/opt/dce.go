@@ -0,0 +1,162 @@
+package opt
+
+import (
+	"slices"
+
+	"github.com/rowland/tuppence/tup/ir"
+)
+
+// DCE removes the instructions of the functions of m whose values are not
+// used by an instruction with side effects, directly or through other
+// values, and simplifies their control flow: branches to a single block
+// become jumps, blocks holding only a jump are bypassed, blocks are merged
+// into their only predecessor when it jumps to them, and phis left
+// selecting a single value are replaced by it.
+func DCE(m *ir.Module) {
+	funcs(m, dce)
+}
+
+func dce(fn *ir.Func) {
+	fn.RemoveUnreachable()
+	for changed := true; changed; {
+		changed = removeDead(fn)
+		changed = simplifyCFG(fn) || changed
+		fn.RemoveUnreachable()
+		fn.RemoveTrivialPhis()
+	}
+	fn.Update()
+}
+
+// removeDead removes the instructions of fn no side effect depends on,
+// reporting whether it removed any.
+func removeDead(fn *ir.Func) bool {
+	live := map[*ir.Instr]bool{}
+	var work []*ir.Instr
+	mark := func(instr *ir.Instr) {
+		if !live[instr] {
+			live[instr] = true
+			work = append(work, instr)
+		}
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.HasSideEffects() {
+				mark(instr)
+			}
+		}
+	}
+	for len(work) > 0 {
+		instr := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, arg := range instr.Args {
+			if arg, ok := arg.(*ir.Instr); ok {
+				mark(arg)
+			}
+		}
+	}
+	return fn.RemoveInstrs(func(instr *ir.Instr) bool { return !live[instr] })
+}
+
+// simplifyCFG applies one round of simplifications to the blocks of fn,
+// reporting whether it changed any. fn must be up to date.
+func simplifyCFG(fn *ir.Func) bool {
+	changed := false
+	for _, b := range fn.Blocks {
+		if term := b.Terminator(); term.Op == ir.OpBr && term.Blocks[0] == term.Blocks[1] {
+			b.Instrs[len(b.Instrs)-1] = &ir.Instr{Op: ir.OpJump, Blocks: term.Blocks[:1], Block: b}
+			changed = true
+		}
+	}
+	if changed {
+		fn.Update()
+	}
+
+	for _, b := range fn.Blocks[1:] {
+		if len(b.Instrs) != 1 || b.Instrs[0].Op != ir.OpJump || len(b.Preds) == 0 {
+			continue
+		}
+		if bypass(b) {
+			fn.Update()
+			changed = true
+		}
+	}
+
+	for i := 1; i < len(fn.Blocks); i++ {
+		b := fn.Blocks[i]
+		if len(b.Preds) != 1 {
+			continue
+		}
+		pred := b.Preds[0]
+		if pred == b || pred.Terminator().Op != ir.OpJump {
+			continue
+		}
+		// b's phis each select the value flowing in from pred
+		for _, phi := range slices.Clone(b.Phis()) {
+			fn.Replace(phi, phi.Args[0])
+		}
+		for _, succ := range b.Succs {
+			for _, phi := range succ.Phis() {
+				for j, from := range phi.Blocks {
+					if from == b {
+						phi.Blocks[j] = pred
+					}
+				}
+			}
+		}
+		pred.Instrs = append(pred.Instrs[:len(pred.Instrs)-1], b.Instrs...)
+		b.Instrs = nil
+		fn.Blocks = slices.Delete(fn.Blocks, i, i+1)
+		fn.Update()
+		changed = true
+		i--
+	}
+	return changed
+}
+
+// bypass redirects the predecessors of b, which holds only a jump, to the
+// block b jumps to, reporting whether it could. The predecessors that
+// cannot be redirected are left in place: checked operations, whose
+// continuation must have them as the only predecessor, and blocks that
+// already branch to a target with phis, which could not tell the two
+// edges apart.
+func bypass(b *ir.Block) bool {
+	target := b.Instrs[0].Blocks[0]
+	if target == b {
+		return false
+	}
+	bypassed := false
+	for _, pred := range slices.Clone(b.Preds) {
+		term := pred.Terminator()
+		if term.Op.IsChecked() || slices.Contains(target.Preds, pred) && len(target.Phis()) > 0 {
+			continue
+		}
+		for i, succ := range term.Blocks {
+			if succ == b {
+				term.Blocks[i] = target
+			}
+		}
+		for _, phi := range target.Phis() {
+			for i, from := range phi.Blocks {
+				if from == b {
+					phi.Args = append(phi.Args, phi.Args[i])
+					phi.Blocks = append(phi.Blocks, pred)
+				}
+			}
+		}
+		b.Preds = slices.DeleteFunc(b.Preds, func(p *ir.Block) bool { return p == pred })
+		bypassed = true
+	}
+	if bypassed && len(b.Preds) == 0 {
+		// b is now unreachable, and its phi operands go with it
+		for _, phi := range target.Phis() {
+			for i := 0; i < len(phi.Blocks); i++ {
+				if phi.Blocks[i] == b {
+					phi.Args = slices.Delete(phi.Args, i, i+1)
+					phi.Blocks = slices.Delete(phi.Blocks, i, i+1)
+					i--
+				}
+			}
+		}
+	}
+	return bypassed
+}
@@ -0,0 +1,65 @@
+package opt
+
+import "github.com/rowland/tuppence/tup/ir"
+
+// Escape marks the tuples of the functions of m that do not outlive the
+// call of their function to be kept in its frame, as built by tuple or by
+// update. A tuple escapes if it is returned, passed to a function or
+// stored in another aggregate, or if a phi it flows into escapes; taking
+// its fields, updating it, comparing it and converting it to text do not
+// make it escape. Since tuples are immutable, a tuple kept in the frame
+// may be copied where a phi selects it.
+func Escape(m *ir.Module) {
+	funcs(m, escape)
+}
+
+func escape(fn *ir.Func) {
+	users := map[*ir.Instr][]*ir.Instr{}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, arg := range instr.Args {
+				if arg, ok := arg.(*ir.Instr); ok {
+					users[arg] = append(users[arg], instr)
+				}
+			}
+		}
+	}
+
+	// escapes reports whether v escapes, assuming that the phis being
+	// visited do not
+	visiting := map[*ir.Instr]bool{}
+	var escapes func(v *ir.Instr) bool
+	escapes = func(v *ir.Instr) bool {
+		for _, user := range users[v] {
+			switch user.Op {
+			case ir.OpField, ir.OpEq, ir.OpNe, ir.OpStr:
+			case ir.OpUpdate:
+				// an update copies the tuple it updates, but stores the
+				// field it replaces
+				if user.Args[1] == v {
+					return true
+				}
+			case ir.OpPhi:
+				if visiting[user] {
+					continue
+				}
+				visiting[user] = true
+				escaped := escapes(user)
+				delete(visiting, user)
+				if escaped {
+					return true
+				}
+			default:
+				return true
+			}
+		}
+		return false
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Op == ir.OpTuple || instr.Op == ir.OpUpdate {
+				instr.Stack = !escapes(instr)
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package opt
+
+import (
+	"slices"
+
+	"github.com/rowland/tuppence/tup/ir"
+)
+
+// inlineBudget is the largest number of instructions, constants aside, of
+// a function whose calls are inlined.
+const inlineBudget = 30
+
+// Inline replaces the calls of small fn functions in the functions of m by
+// copies of their bodies. Because fn functions have no side effects, the
+// copy behaves as the call did wherever it is placed. Calls of function
+// values known to be a function of the module, or a closure of one, are
+// first made direct calls, which pass a closure's captured values, and the
+// closure itself if it captures itself, ahead of the arguments. Calls of a
+// function within itself, and calls in the copies just made, are not
+// inlined, so that recursion ends.
+func Inline(m *ir.Module) {
+	funcs(m, func(fn *ir.Func) {
+		inline(m, fn)
+	})
+}
+
+func inline(m *ir.Module, fn *ir.Func) {
+	var calls []*ir.Instr
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Op != ir.OpCall {
+				continue
+			}
+			if instr.Callee == "" {
+				if f, ok := instr.Args[0].(*ir.Instr); ok && f.Op == ir.OpFunc {
+					instr.Callee = f.Callee
+					args := slices.Clone(f.Args)
+					if f.Rec {
+						args = append(args, f)
+					}
+					instr.Args = append(args, instr.Args[1:]...)
+				}
+			}
+			if inlinable(fn, callee(m, instr)) {
+				calls = append(calls, instr)
+			}
+		}
+	}
+	for _, call := range calls {
+		inlineCall(fn, call, callee(m, call))
+		fn.Update()
+	}
+}
+
+// callee returns the function call calls directly, or nil.
+func callee(m *ir.Module, call *ir.Instr) *ir.Func {
+	if call.Callee == "" {
+		return nil
+	}
+	return m.Func(call.Callee)
+}
+
+// inlinable reports whether calls of callee in fn may be inlined.
+func inlinable(fn, callee *ir.Func) bool {
+	if callee == nil || callee == fn || callee.Extern() || callee.Sig.HasSideEffects || callee.TypeParams != nil {
+		return false
+	}
+	size := 0
+	for _, b := range callee.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Op != ir.OpConst {
+				size++
+			}
+		}
+	}
+	return size <= inlineBudget
+}
+
+// inlineCall replaces call, a call of callee in fn, by a copy of the
+// blocks of callee. The block of the call is split after it: the part
+// before jumps to the copy of the entry, and the copies of the returns
+// jump to the part after, where a phi selects the value returned.
+func inlineCall(fn *ir.Func, call *ir.Instr, callee *ir.Func) {
+	b := call.Block
+	at := slices.Index(b.Instrs, call)
+	rest := &ir.Block{Func: fn, Instrs: slices.Clone(b.Instrs[at+1:])}
+	b.Instrs = b.Instrs[:at]
+	for _, succ := range rest.Instrs[len(rest.Instrs)-1].Blocks {
+		for _, phi := range succ.Phis() {
+			for i, from := range phi.Blocks {
+				if from == b {
+					phi.Blocks[i] = rest
+				}
+			}
+		}
+	}
+
+	values := map[ir.Value]ir.Value{}
+	for i, param := range callee.Params {
+		values[param] = call.Args[i]
+	}
+	blocks := map[*ir.Block]*ir.Block{}
+	var copies []*ir.Block
+	for _, cb := range callee.Blocks {
+		clone := &ir.Block{Func: fn}
+		blocks[cb] = clone
+		copies = append(copies, clone)
+	}
+	var returns []*ir.Instr
+	for _, cb := range callee.Blocks {
+		clone := blocks[cb]
+		for _, instr := range cb.Instrs {
+			c := *instr
+			c.Block = clone
+			c.Args = slices.Clone(instr.Args)
+			c.Blocks = make([]*ir.Block, len(instr.Blocks))
+			for i, succ := range instr.Blocks {
+				c.Blocks[i] = blocks[succ]
+			}
+			if c.Op == ir.OpRet {
+				returns = append(returns, &c)
+				c.Op, c.Blocks = ir.OpJump, []*ir.Block{rest}
+			}
+			values[instr] = &c
+			clone.Instrs = append(clone.Instrs, &c)
+		}
+	}
+	for _, clone := range copies {
+		for _, instr := range clone.Instrs {
+			for i, arg := range instr.Args {
+				instr.Args[i] = values[arg]
+			}
+		}
+	}
+
+	// the value returned, selected among the returns
+	var result ir.Value
+	if call.Typ != nil {
+		switch len(returns) {
+		case 0:
+			// the callee never returns
+			undef := &ir.Instr{Op: ir.OpUndef, Typ: call.Typ, Block: rest}
+			rest.Instrs = slices.Insert(rest.Instrs, 0, undef)
+			result = undef
+		case 1:
+			result = returns[0].Args[0]
+		default:
+			phi := &ir.Instr{Op: ir.OpPhi, Typ: call.Typ, Block: rest}
+			for _, ret := range returns {
+				phi.Args = append(phi.Args, ret.Args[0])
+				phi.Blocks = append(phi.Blocks, ret.Block)
+			}
+			rest.Instrs = slices.Insert(rest.Instrs, 0, phi)
+			result = phi
+		}
+	}
+	for _, ret := range returns {
+		ret.Args = nil
+	}
+
+	b.Instrs = append(b.Instrs, &ir.Instr{Op: ir.OpJump, Blocks: []*ir.Block{copies[0]}, Block: b})
+	i := slices.Index(fn.Blocks, b)
+	fn.Blocks = slices.Insert(fn.Blocks, i+1, append(copies, rest)...)
+	if result != nil {
+		fn.Replace(call, result)
+	}
+}
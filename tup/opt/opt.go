@@ -0,0 +1,109 @@
+// Package opt implements optimization passes over the IR of package ir and
+// a pass manager that runs them in sequence.
+//
+// Every pass preserves the meaning of the module, relying on the effects
+// the IR records: a call of an fn function has no side effects, so it may
+// be inlined, shared with an identical call or removed, while calls of fx
+// functions, traps and operations that may trap stay where they are.
+package opt
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rowland/tuppence/tup/ir"
+)
+
+// Pass is an optimization pass.
+type Pass struct {
+	// Name identifies the pass in dump flags such as -d=sccp.
+	Name string
+	Doc  string
+	Run  func(m *ir.Module)
+}
+
+// Passes holds the passes, in the order the pass manager runs them by
+// default.
+var Passes = []*Pass{
+	{"inline", "inline calls of small fn functions", Inline},
+	{"sccp", "sparse conditional constant propagation", SCCP},
+	{"cse", "common subexpression elimination", CSE},
+	{"dce", "dead code elimination", DCE},
+	{"escape", "keep tuples that do not escape in the frame", Escape},
+}
+
+// Lookup returns the pass named name, or nil.
+func Lookup(name string) *Pass {
+	for _, pass := range Passes {
+		if pass.Name == name {
+			return pass
+		}
+	}
+	return nil
+}
+
+// Manager runs a sequence of passes over a module.
+type Manager struct {
+	Passes []*Pass
+	// Dump holds the names of the passes around which the module is
+	// written to Out, before and after the pass runs.
+	Dump map[string]bool
+	Out  io.Writer
+	// Verify is set to verify the module after each pass.
+	Verify bool
+}
+
+// NewManager returns a manager running the default passes.
+func NewManager() *Manager {
+	return &Manager{Passes: Passes, Dump: map[string]bool{}}
+}
+
+// Run runs the passes of pm over m. It returns an error if a dump flag
+// names no pass, or if the module fails to verify after a pass.
+func (pm *Manager) Run(m *ir.Module) error {
+	for name := range pm.Dump {
+		if Lookup(name) == nil {
+			return fmt.Errorf("unknown pass %s", name)
+		}
+	}
+	for _, pass := range pm.Passes {
+		dump := pm.Dump[pass.Name] && pm.Out != nil
+		if dump {
+			fmt.Fprintf(pm.Out, "-- before %s --\n%s\n", pass.Name, m)
+		}
+		pass.Run(m)
+		if dump {
+			fmt.Fprintf(pm.Out, "-- after %s --\n%s\n", pass.Name, m)
+		}
+		if pm.Verify {
+			if err := ir.Verify(m); err != nil {
+				return fmt.Errorf("after %s: %w", pass.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// funcs calls f with each function of m that has a body.
+func funcs(m *ir.Module, f func(fn *ir.Func)) {
+	for _, fn := range m.Funcs {
+		if !fn.Extern() {
+			f(fn)
+		}
+	}
+}
+
+// uses returns the number of uses of each instruction of fn as an operand.
+func uses(fn *ir.Func) map[*ir.Instr]int {
+	n := map[*ir.Instr]int{}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, arg := range instr.Args {
+				if arg, ok := arg.(*ir.Instr); ok {
+					n[arg]++
+				}
+			}
+		}
+	}
+	return n
+}
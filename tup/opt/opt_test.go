@@ -0,0 +1,127 @@
+package opt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+)
+
+const updateOptGoldensEnv = "UPDATE_OPT_GOLDENS"
+
+// lower checks the module in filename and lowers it to the IR.
+func lower(t *testing.T, filename string) *ir.Module {
+	t.Helper()
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, err := parse.Module(source.NewSource(contents, filename), ast.NewModule(filename))
+	if err != nil {
+		t.Fatalf("parse.Module() = %v", err)
+	}
+	info, err := check.Module(module)
+	if err != nil {
+		t.Fatalf("check.Module() = %v", err)
+	}
+	m, err := ir.Lower(module, info)
+	if err != nil {
+		t.Fatalf("ir.Lower() = %v", err)
+	}
+	return m
+}
+
+// TestPassGoldens runs each pass over the modules in testdata/<pass> and
+// compares the module before and after the pass, as -d=<pass> writes it,
+// with <name>.golden. Set UPDATE_OPT_GOLDENS to rewrite the golden files.
+func TestPassGoldens(t *testing.T) {
+	update := os.Getenv(updateOptGoldensEnv) != ""
+	for _, pass := range Passes {
+		inputs, err := filepath.Glob(filepath.Join("testdata", pass.Name, "*.tup"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(inputs) == 0 {
+			t.Errorf("no tests for pass %s", pass.Name)
+		}
+		for _, input := range inputs {
+			name := strings.TrimSuffix(filepath.Base(input), ".tup")
+			t.Run(pass.Name+"/"+name, func(t *testing.T) {
+				m := lower(t, input)
+				var out bytes.Buffer
+				pm := &Manager{Passes: []*Pass{pass}, Dump: map[string]bool{pass.Name: true}, Out: &out, Verify: true}
+				if err := pm.Run(m); err != nil {
+					t.Fatalf("Run() = %v\n%s", err, out.String())
+				}
+				if _, err := ir.Parse(m.String()); err != nil {
+					t.Fatalf("ir.Parse() = %v", err)
+				}
+				golden := strings.TrimSuffix(input, ".tup") + ".golden"
+				if update {
+					if err := os.WriteFile(golden, out.Bytes(), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := out.String(); got != string(want) {
+					t.Errorf("%s:\n%s\nwant:\n%s", pass.Name, got, want)
+				}
+			})
+		}
+	}
+}
+
+// TestPipeline runs the default passes over the modules listed and
+// compares the result with testdata/pipeline/<name>.golden.
+func TestPipeline(t *testing.T) {
+	update := os.Getenv(updateOptGoldensEnv) != ""
+	for _, input := range []string{
+		filepath.Join("..", "..", "examples", "fib.tup"),
+		filepath.Join("testdata", "inline", "small.tup"),
+		filepath.Join("testdata", "sccp", "checked.tup"),
+	} {
+		name := strings.TrimSuffix(filepath.Base(input), ".tup")
+		t.Run(name, func(t *testing.T) {
+			m := lower(t, input)
+			pm := NewManager()
+			pm.Verify = true
+			if err := pm.Run(m); err != nil {
+				t.Fatalf("Run() = %v", err)
+			}
+			golden := filepath.Join("testdata", "pipeline", name+".golden")
+			if update {
+				if err := os.WriteFile(golden, []byte(m.String()), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.String(); got != string(want) {
+				t.Errorf("%s optimized to:\n%s\nwant:\n%s", input, got, want)
+			}
+		})
+	}
+}
+
+func TestManagerErrors(t *testing.T) {
+	m := lower(t, filepath.Join("testdata", "dce", "unused.tup"))
+	pm := NewManager()
+	pm.Dump["bogus"] = true
+	if err := pm.Run(m); err == nil || err.Error() != "unknown pass bogus" {
+		t.Errorf("Run() = %v, want unknown pass bogus", err)
+	}
+}
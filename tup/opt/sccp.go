@@ -0,0 +1,304 @@
+package opt
+
+import (
+	"math/big"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+// SCCP propagates constants through the functions of m with the sparse
+// conditional constant propagation of Wegman and Zadeck, "Constant
+// Propagation with Conditional Branches": values are assumed undefined
+// until shown to be a constant or to vary, and blocks unreachable until a
+// branch that may be taken leads to them, so that constants flowing around
+// loops and through branches on constants are found. Values found constant
+// are replaced by constants, branches on constants by jumps and checked
+// operations that cannot overflow by their unchecked results, and the
+// blocks never reached are removed.
+func SCCP(m *ir.Module) {
+	funcs(m, sccp)
+}
+
+// lattice is the state of a value: undefined (the zero value), the
+// constant c, or varying.
+type lattice struct {
+	c       consteval.Value
+	varying bool
+}
+
+type propagator struct {
+	fn    *ir.Func
+	users map[ir.Value][]*ir.Instr
+	state map[ir.Value]lattice
+	// edges taken and blocks reached
+	edges   map[[2]*ir.Block]bool
+	reached map[*ir.Block]bool
+
+	blocks []*ir.Block
+	instrs []*ir.Instr
+}
+
+func sccp(fn *ir.Func) {
+	fn.Update()
+	p := &propagator{
+		fn:      fn,
+		users:   map[ir.Value][]*ir.Instr{},
+		state:   map[ir.Value]lattice{},
+		edges:   map[[2]*ir.Block]bool{},
+		reached: map[*ir.Block]bool{},
+	}
+	for _, param := range fn.Params {
+		p.state[param] = lattice{varying: true}
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, arg := range instr.Args {
+				p.users[arg] = append(p.users[arg], instr)
+			}
+			// field and payload take their states from the operands of
+			// the aggregates they take apart
+			if instr.Op == ir.OpField || instr.Op == ir.OpPayload {
+				if agg, ok := instr.Args[0].(*ir.Instr); ok && (agg.Op == ir.OpTuple || agg.Op == ir.OpWrap || agg.Op == ir.OpUpdate) {
+					for _, part := range agg.Args {
+						p.users[part] = append(p.users[part], instr)
+					}
+				}
+			}
+		}
+	}
+
+	p.reach(fn.Blocks[0])
+	for len(p.blocks) > 0 || len(p.instrs) > 0 {
+		if n := len(p.blocks); n > 0 {
+			b := p.blocks[n-1]
+			p.blocks = p.blocks[:n-1]
+			for _, instr := range b.Instrs {
+				p.visit(instr)
+			}
+			continue
+		}
+		n := len(p.instrs)
+		instr := p.instrs[n-1]
+		p.instrs = p.instrs[:n-1]
+		if p.reached[instr.Block] {
+			p.visit(instr)
+		}
+	}
+	p.rewrite()
+}
+
+// reach marks b reached, queueing its instructions the first time.
+func (p *propagator) reach(b *ir.Block) {
+	if !p.reached[b] {
+		p.reached[b] = true
+		p.blocks = append(p.blocks, b)
+	}
+}
+
+// take marks the edge from b to succ taken. Taking it again revisits the
+// phis of succ, which have an operand flowing along it.
+func (p *propagator) take(b, succ *ir.Block) {
+	edge := [2]*ir.Block{b, succ}
+	if p.edges[edge] {
+		return
+	}
+	p.edges[edge] = true
+	if p.reached[succ] {
+		for _, phi := range succ.Phis() {
+			p.visit(phi)
+		}
+	}
+	p.reach(succ)
+}
+
+// set lowers the state of instr to l, queueing its users if it changed.
+// States only ever lower, from undefined to constant to varying.
+func (p *propagator) set(instr *ir.Instr, l lattice) {
+	old := p.state[instr]
+	if old.varying || old.c != nil && !l.varying {
+		return
+	}
+	if !l.varying && l.c == nil {
+		return
+	}
+	p.state[instr] = l
+	p.instrs = append(p.instrs, p.users[instr]...)
+}
+
+// meet combines the states of two values flowing into a phi.
+func meet(x, y lattice) lattice {
+	switch {
+	case x.varying || y.varying:
+		return lattice{varying: true}
+	case x.c == nil:
+		return y
+	case y.c == nil:
+		return x
+	case consteval.Equal(x.c, y.c):
+		return x
+	}
+	return lattice{varying: true}
+}
+
+func (p *propagator) visit(instr *ir.Instr) {
+	b := instr.Block
+	switch op := instr.Op; {
+	case op == ir.OpConst:
+		p.set(instr, lattice{c: instr.Const})
+	case op == ir.OpPhi:
+		var l lattice
+		for i, arg := range instr.Args {
+			if p.edges[[2]*ir.Block{instr.Blocks[i], b}] {
+				l = meet(l, p.state[arg])
+			}
+		}
+		p.set(instr, l)
+	case op == ir.OpJump:
+		p.take(b, instr.Blocks[0])
+	case op == ir.OpBr:
+		cond := p.state[instr.Args[0]]
+		switch {
+		case cond.varying:
+			p.take(b, instr.Blocks[0])
+			p.take(b, instr.Blocks[1])
+		case cond.c != nil:
+			if cond.c.(consteval.Bool) {
+				p.take(b, instr.Blocks[0])
+			} else {
+				p.take(b, instr.Blocks[1])
+			}
+		}
+	case op == ir.OpRet, op == ir.OpTrap:
+	case op.IsChecked():
+		args, l := p.operands(instr)
+		switch {
+		case l.varying:
+			p.set(instr, l)
+			p.take(b, instr.Blocks[0])
+			p.take(b, instr.Blocks[1])
+		case args != nil:
+			if c, err := ir.Fold(op, instr.Typ, args...); err != nil {
+				p.take(b, instr.Blocks[1])
+			} else if c != nil {
+				p.set(instr, lattice{c: c})
+				p.take(b, instr.Blocks[0])
+			} else {
+				p.set(instr, lattice{varying: true})
+				p.take(b, instr.Blocks[0])
+				p.take(b, instr.Blocks[1])
+			}
+		}
+	case op == ir.OpTag:
+		if wrap, ok := instr.Args[0].(*ir.Instr); ok && wrap.Op == ir.OpWrap {
+			// the member a union holds is known where it is built
+			p.set(instr, lattice{c: &consteval.Int{Val: big.NewInt(int64(wrap.Index)), Typ: types.Int}})
+		} else {
+			p.set(instr, lattice{varying: true})
+		}
+	case op == ir.OpField || op == ir.OpPayload:
+		// the parts of aggregates built in the function are known as
+		// their operands are
+		if agg, ok := instr.Args[0].(*ir.Instr); ok && (agg.Op == ir.OpTuple || agg.Op == ir.OpWrap && agg.Index == instr.Index) {
+			part := agg.Args[0]
+			if op == ir.OpField {
+				part = agg.Args[instr.Index]
+			}
+			p.set(instr, p.state[part])
+		} else if ok && op == ir.OpField && agg.Op == ir.OpUpdate && agg.Index == instr.Index {
+			p.set(instr, p.state[agg.Args[1]])
+		} else {
+			p.set(instr, lattice{varying: true})
+		}
+	case instr.Typ == nil || op == ir.OpCall || op == ir.OpUndef:
+		p.set(instr, lattice{varying: true})
+	default:
+		args, l := p.operands(instr)
+		if args != nil {
+			// operations that trap or are not folded vary: they are
+			// left for the program to perform
+			if c, err := ir.Fold(op, instr.Typ, args...); err == nil && c != nil {
+				l = lattice{c: c}
+			} else {
+				l = lattice{varying: true}
+			}
+		}
+		p.set(instr, l)
+	}
+}
+
+// operands returns the constant operands of instr, or nil and the state
+// of instr if one is undefined or varies.
+func (p *propagator) operands(instr *ir.Instr) ([]consteval.Value, lattice) {
+	args := make([]consteval.Value, len(instr.Args))
+	for i, arg := range instr.Args {
+		l := p.state[arg]
+		if l.varying {
+			return nil, l
+		}
+		if l.c == nil {
+			return nil, lattice{}
+		}
+		args[i] = l.c
+	}
+	return args, lattice{}
+}
+
+// rewrite replaces the values found constant by constants at the start of
+// the entry, and the branches found to go one way by jumps, then removes
+// the blocks not reached.
+func (p *propagator) rewrite() {
+	fn := p.fn
+	var consts []*ir.Instr
+	replaced := map[*ir.Instr]bool{}
+	for _, b := range fn.Blocks {
+		if !p.reached[b] {
+			continue
+		}
+		for _, instr := range b.Instrs {
+			l := p.state[instr]
+			if instr.Op == ir.OpConst || l.c == nil || !types.Identical(l.c.Type(), instr.Typ) {
+				continue
+			}
+			c := &ir.Instr{Op: ir.OpConst, Typ: instr.Typ, Const: l.c}
+			consts = append(consts, c)
+			replaced[instr] = true
+			for _, user := range p.users[instr] {
+				for i, arg := range user.Args {
+					if arg == instr {
+						user.Args[i] = c
+					}
+				}
+			}
+		}
+	}
+	for _, b := range fn.Blocks {
+		if !p.reached[b] {
+			continue
+		}
+		term := b.Terminator()
+		var taken []*ir.Block
+		for _, succ := range term.Blocks {
+			if p.edges[[2]*ir.Block{b, succ}] {
+				taken = append(taken, succ)
+			}
+		}
+		if len(term.Blocks) < 2 || len(taken) != 1 {
+			continue
+		}
+		if term.Op.IsChecked() && taken[0] == term.Blocks[0] && !replaced[term] {
+			// the result is constant, but of another type, and still used
+			continue
+		}
+		// a branch known to go one way, or a checked operation known to
+		// overflow or not, whose result is now unused
+		b.Instrs[len(b.Instrs)-1] = &ir.Instr{Op: ir.OpJump, Blocks: taken, Block: b}
+	}
+	fn.RemoveInstrs(func(instr *ir.Instr) bool { return replaced[instr] })
+	entry := fn.Blocks[0]
+	entry.Instrs = append(consts, entry.Instrs...)
+	fn.RemoveUnreachable()
+	fn.RemoveTrivialPhis()
+	fn.Update()
+}
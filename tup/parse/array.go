@@ -50,6 +50,7 @@ func ArrayLiteral(tokens []tok.Token) (arr *ast.ArrayLiteral, remainder []tok.To
 		return nil, remainder2, typeErr
 	}
 
+	start := remainder
 	var found bool
 	if remainder, found = OpenBracket(remainder); !found {
 		return nil, tokens, ErrNoMatch
@@ -60,12 +61,14 @@ func ArrayLiteral(tokens []tok.Token) (arr *ast.ArrayLiteral, remainder []tok.To
 		return nil, remainder, err
 	}
 
-	remainder = skipTrivia(remainder)
-	if remainder, found = CloseBracket(remainder); !found {
+	end := skipTrivia(remainder)
+	if remainder, found = CloseBracket(end); !found {
 		return nil, remainder, errorExpectingTokenType(tok.TokCloseBracket, remainder)
 	}
 
-	return ast.NewArrayLiteral(nil, arrayMembers, nil), remainder, nil
+	arr = ast.NewArrayLiteral(nil, arrayMembers, nil)
+	arr.Source, arr.StartOffset, arr.Length = start[0].File, start[0].Offset, end[0].Offset+end[0].Length-start[0].Offset
+	return arr, remainder, nil
 }
 
 // array_members = expression { "," expression } [ "," ] .
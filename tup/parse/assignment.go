@@ -138,7 +138,10 @@ func labeledAssignmentLHS(tokens []tok.Token) (lhs *ast.LabeledAssignmentLHS, re
 	}
 
 	rename, remainder, err := Rename(remainder)
-	if rename == nil || err != nil {
+	if err == ErrNoMatch {
+		// not a rename, so possibly a tuple literal
+		return nil, tokens, ErrNoMatch
+	} else if rename == nil || err != nil {
 		return nil, nil, err
 	}
 	renames = append(renames, rename)
@@ -151,6 +154,10 @@ func labeledAssignmentLHS(tokens []tok.Token) (lhs *ast.LabeledAssignmentLHS, re
 	}
 
 	if remainder, found = CloseParen(remainder); !found {
+		if _, found = Comma(remainder); found {
+			// e.g. (a, 1) or (a: 1, ...rest), so possibly a tuple literal
+			return nil, tokens, ErrNoMatch
+		}
 		return nil, remainder, errorExpectingTokenType(tok.TokCloseParen, remainder)
 	}
 
@@ -176,6 +183,10 @@ func Rename(tokens []tok.Token) (ren ast.Rename, remainder []tok.Token, err erro
 		return typeId, remainder, nil
 	}
 
+	if len(errors) == 0 || allNoMatch(errors) {
+		return nil, tokens, ErrNoMatch
+	}
+
 	return nil, nil, errorExpectingOneOf("rename_identifier or rename_type", tokens, errors)
 }
 
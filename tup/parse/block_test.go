@@ -55,6 +55,51 @@ func TestBlock(t *testing.T) {
 				),
 			),
 		},
+		{
+			name:  "block with tuple literal expression",
+			input: "{ (1, 2) }",
+			want: ast.NewBlock(
+				ast.NewBlockBody(
+					[]ast.Statement{},
+					ast.NewTupleLiteral(false, []*ast.TupleMember{
+						ast.NewTupleMember(nil, ast.NewDecimalLiteral("1", 1, nil, 0, 1)),
+						ast.NewTupleMember(nil, ast.NewDecimalLiteral("2", 2, nil, 0, 1)),
+					}),
+				),
+			),
+		},
+		{
+			name:  "block with spread tuple literal expression",
+			input: "{ (...acc, d: 1) }",
+			want: ast.NewBlock(
+				ast.NewBlockBody(
+					[]ast.Statement{},
+					ast.NewTupleLiteral(true, []*ast.TupleMember{
+						ast.NewSpreadTupleMember(ast.NewIdentifier("acc", nil, 0, 3)),
+						ast.NewTupleMember(ast.NewIdentifier("d", nil, 0, 1), ast.NewDecimalLiteral("1", 1, nil, 0, 1)),
+					}),
+				),
+			),
+		},
+		{
+			name:  "block with labeled assignment",
+			input: "{ (a, b) = t; a }",
+			want: ast.NewBlock(
+				ast.NewBlockBody(
+					[]ast.Statement{
+						ast.NewAssignment(
+							ast.NewLabeledAssignmentLHS([]ast.Rename{
+								ast.NewRenameIdentifier(ast.NewIdentifier("a", nil, 0, 1), nil),
+								ast.NewRenameIdentifier(ast.NewIdentifier("b", nil, 0, 1), nil),
+							}),
+							false,
+							ast.NewIdentifier("t", nil, 0, 1),
+						),
+					},
+					ast.NewIdentifier("a", nil, 0, 1),
+				),
+			),
+		},
 		{
 			name:  "block with multiple assignments",
 			input: "{ x = 1; y = 2; y + 1 }",
@@ -54,6 +54,7 @@ func TryExpression(tokens []tok.Token) (expr *ast.TryExpression, remainder []tok
 	default:
 		return nil, tokens, ErrNoMatch
 	}
+	remainder = remainder[1:]
 
 	var expression ast.Expression
 	if expression, remainder, err = Expression(remainder); err != nil {
@@ -400,6 +400,18 @@ func TestExpression(t *testing.T) {
 				ast.NewIdentifier("key", nil, 0, 3),
 			),
 		},
+		{
+			name:  "safe indexed access with computed index",
+			input: "values[i + 1]!",
+			want: ast.NewSafeIndexedAccess(
+				ast.NewIdentifier("values", nil, 0, 6),
+				ast.NewAddSubExpression(
+					ast.NewIdentifier("i", nil, 0, 1),
+					ast.OpAdd,
+					ast.NewDecimalLiteral("1", 1, nil, 0, 1),
+				),
+			),
+		},
 		{
 			name:  "block expression",
 			input: "{ x = 1; x + 1 }",
@@ -746,10 +758,21 @@ func TestExpression(t *testing.T) {
 		{
 			name:  "meta expression",
 			input: `$(file: "config.json")`,
-			want: ast.NewMetaExpression(map[string]ast.Node{
-				"file": ast.NewArgument(ast.NewStringLiteral(`"config.json"`, "config.json", nil, 0, 13), false),
+			want: ast.NewMetaExpression([]*ast.LabeledArgument{
+				ast.NewLabeledArgument(
+					ast.NewIdentifier("file", nil, 0, 4),
+					ast.NewArgument(ast.NewStringLiteral(`"config.json"`, "config.json", nil, 0, 13), false),
+				),
 			}),
 		},
+		{
+			name:  "try expression",
+			input: `try foo |> bar()`,
+			want: ast.NewTryExpression(ast.TryStandard, ast.NewChainedExpression(
+				ast.NewIdentifier("foo", nil, 0, 3),
+				[]*ast.FunctionCall{ast.NewFunctionCall(ast.NewFunctionIdentifier("bar", nil, 0, 3), nil, ast.NewFunctionArguments(nil, nil, false), nil)},
+			)),
+		},
 		{
 			name:    "malformed member access tail",
 			input:   "user.",
@@ -951,6 +974,16 @@ func TestExpression(t *testing.T) {
 					t.Errorf("Expression(%q) = %v, want %v", tt.input, got, want)
 					return
 				}
+			case *ast.TryExpression:
+				got, ok := expression.(*ast.TryExpression)
+				if !ok {
+					t.Errorf("Expression(%q) = %T, want %T", tt.input, expression, tt.want)
+					return
+				}
+				if got.String() != want.String() {
+					t.Errorf("Expression(%q) = %v, want %v", tt.input, got, want)
+					return
+				}
 			case *ast.TypeofExpression:
 				got, ok := expression.(*ast.TypeofExpression)
 				if !ok {
@@ -997,18 +1030,13 @@ func TestExpression(t *testing.T) {
 					t.Errorf("Expression(%q) = %T, want %T", tt.input, expression, tt.want)
 					return
 				}
-				if len(got.KeyValues) != len(want.KeyValues) {
-					t.Errorf("Expression(%q) key count = %d, want %d", tt.input, len(got.KeyValues), len(want.KeyValues))
+				if len(got.Args) != len(want.Args) {
+					t.Errorf("Expression(%q) key count = %d, want %d", tt.input, len(got.Args), len(want.Args))
 					return
 				}
-				for key, wantValue := range want.KeyValues {
-					gotValue, ok := got.KeyValues[key]
-					if !ok {
-						t.Errorf("Expression(%q) missing key %q", tt.input, key)
-						return
-					}
-					if gotValue.String() != wantValue.String() {
-						t.Errorf("Expression(%q) key %q = %v, want %v", tt.input, key, gotValue, wantValue)
+				for i, wantArg := range want.Args {
+					if gotArg := got.Args[i]; gotArg.String() != wantArg.String() {
+						t.Errorf("Expression(%q) argument %d = %v, want %v", tt.input, i, gotArg, wantArg)
 						return
 					}
 				}
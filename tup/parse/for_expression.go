@@ -43,9 +43,17 @@ func Initializer(tokens []tok.Token) (*ast.Initializer, []tok.Token, error) {
 	return ast.NewInitializer(assignment), remainder, nil
 }
 
-// iterable = expression .
+// iterable = type_reference | expression .
 
 func Iterable(tokens []tok.Token) (*ast.Iterable, []tok.Token, error) {
+	// A type followed by the loop body iterates over the members of an
+	// enum. Otherwise Fruit { ... } would be read as an array literal.
+	if typeRef, remainder, err := TypeReference(tokens); err == nil {
+		if peek(skipComments(remainder)).Type == tok.TokOpenBrace {
+			return ast.NewIterableType(typeRef), remainder, nil
+		}
+	}
+
 	expression, remainder, err := Expression(tokens)
 	if err != nil {
 		return nil, remainder, err
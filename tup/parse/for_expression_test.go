@@ -105,6 +105,23 @@ func TestIterable(t *testing.T) {
 			input: "items",
 			want:  ast.NewIterable(ast.NewIdentifier("items", nil, 0, 5)),
 		},
+		{
+			name:  "enum type",
+			input: "Fruit { }",
+			want: ast.NewIterableType(
+				ast.NewTypeReference(nil, ast.NewTypeIdentifier("Fruit", nil, 0, 5), nil, 0, 5),
+			),
+		},
+		{
+			name:  "type member access",
+			input: "Fruit.values",
+			want: ast.NewIterable(
+				ast.NewMemberAccess(
+					ast.NewTypeIdentifier("Fruit", nil, 0, 5),
+					ast.NewIdentifier("values", nil, 0, 6),
+				),
+			),
+		},
 	}
 
 	for _, test := range tests {
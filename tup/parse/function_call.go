@@ -153,7 +153,9 @@ func FunctionParameterTypes(tokens []tok.Token) (expr *ast.FunctionParameterType
 	}
 
 	if remainder, found = CloseBracket(remainder); !found {
-		return nil, remainder, errorExpectingTokenType(tok.TokCloseBracket, remainder)
+		// an index such as values[i + 1] begins like a list of
+		// parameter types
+		return nil, tokens, ErrNoMatch
 	}
 
 	return ast.NewFunctionParameterTypes(parameters), remainder, nil
@@ -16,11 +16,13 @@ func Interpolation(tokens []tok.Token) (*ast.Interpolation, []tok.Token, error)
 	}
 
 	value := t.Value()
+	start := t.Offset
 	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
 		value = value[1 : len(value)-1]
+		start++
 	}
 
-	expression, err := parseInterpolationExpression(value[2 : len(value)-1])
+	expression, err := parseInterpolationExpression(value[2:len(value)-1], t.File, offsetFrom(start+2))
 	if err != nil {
 		return nil, remainder, err
 	}
@@ -51,7 +53,7 @@ func InterpolatedStringLiteral(tokens []tok.Token) (*ast.InterpolatedStringLiter
 	}
 
 	content := value[1 : len(value)-1]
-	parts, err := parseInterpolatedStringParts(t.File, t.Offset+1, content)
+	parts, err := parseInterpolatedStringParts(t.File, offsetFrom(t.Offset+1), content)
 	if err != nil {
 		return nil, remainder, err
 	}
@@ -67,7 +69,17 @@ func InterpolatedStringLiteral(tokens []tok.Token) (*ast.InterpolatedStringLiter
 	}, remainder[1:], nil
 }
 
-func parseInterpolatedStringParts(src *source.Source, startOffset int32, content string) ([]ast.InterpolatedStringPart, error) {
+// offsetFrom returns the offset function of contents that begin at start
+// in their source file and are written there as is.
+func offsetFrom(start int32) func(int) int32 {
+	return func(i int) int32 { return start + int32(i) }
+}
+
+// parseInterpolatedStringParts splits the contents of an interpolated string
+// into literal segments and interpolations. The offset function gives the
+// offset in src of each byte of content, and of its end, so that the parts
+// and the expressions they interpolate are positioned within src.
+func parseInterpolatedStringParts(src *source.Source, offset func(int) int32, content string) ([]ast.InterpolatedStringPart, error) {
 	parts := []ast.InterpolatedStringPart{}
 	segmentStart := 0
 
@@ -83,14 +95,14 @@ func parseInterpolatedStringParts(src *source.Source, startOffset int32, content
 				content[segmentStart:i],
 				content[segmentStart:i],
 				src,
-				startOffset+int32(segmentStart),
-				int32(i-segmentStart),
+				offset(segmentStart),
+				offset(i)-offset(segmentStart),
 			))
 		}
 
 		// Find the matching closing ')' for this interpolation by tokenizing the
 		// interpolation body and reusing the tokenizer's parenthesis handling.
-		end, expression, err := parseInterpolationAt(content, i+2)
+		end, expression, err := parseInterpolationAt(content, i+2, src, offset)
 		if err != nil {
 			return nil, err
 		}
@@ -99,8 +111,8 @@ func parseInterpolatedStringParts(src *source.Source, startOffset int32, content
 			BaseNode: ast.BaseNode{
 				Type:        ast.NodeInterpolation,
 				Source:      src,
-				StartOffset: startOffset + int32(i),
-				Length:      int32(end - i + 1),
+				StartOffset: offset(i),
+				Length:      offset(end+1) - offset(i),
 			},
 			Expression: expression,
 		})
@@ -114,20 +126,19 @@ func parseInterpolatedStringParts(src *source.Source, startOffset int32, content
 			content[segmentStart:],
 			content[segmentStart:],
 			src,
-			startOffset+int32(segmentStart),
-			int32(len(content)-segmentStart),
+			offset(segmentStart),
+			offset(len(content))-offset(segmentStart),
 		))
 	}
 
 	return parts, nil
 }
 
-func parseInterpolationAt(content string, exprStart int) (end int, expression ast.Expression, err error) {
+func parseInterpolationAt(content string, exprStart int, src *source.Source, offset func(int) int32) (end int, expression ast.Expression, err error) {
 	// Tokenize from just after the opening "\(" and scan until the tokenizer
 	// reports the ')' that closes this interpolation, accounting for nested
 	// parentheses in the embedded expression.
-	src := source.NewSource([]byte(content[exprStart:]), "interpolation.tup")
-	tokens, err := tok.Tokenize(src.Contents, src.Filename)
+	tokens, err := tok.Tokenize([]byte(content[exprStart:]), "interpolation.tup")
 	if err != nil {
 		return 0, nil, err
 	}
@@ -158,7 +169,9 @@ func parseInterpolationAt(content string, exprStart int) (end int, expression as
 
 	// Re-tokenize just the expression body so Expression(...) sees the same
 	// input it would see in ordinary source code, without the closing ')'.
-	expression, err = parseInterpolationExpression(content[exprStart : exprStart+closeOffset])
+	expression, err = parseInterpolationExpression(content[exprStart:exprStart+closeOffset], src, func(i int) int32 {
+		return offset(exprStart + i)
+	})
 	if err != nil {
 		return 0, nil, err
 	}
@@ -166,12 +179,24 @@ func parseInterpolationAt(content string, exprStart int) (end int, expression as
 	return exprStart + closeOffset, expression, nil
 }
 
-func parseInterpolationExpression(exprText string) (ast.Expression, error) {
-	src := source.NewSource([]byte(exprText), "interpolation.tup")
-	tokens, err := tok.Tokenize(src.Contents, src.Filename)
+// parseInterpolationExpression parses the expression of an interpolation.
+// Its text is tokenized on its own, then its tokens are positioned within
+// src by the offset function, so that nodes and errors point into the
+// literal.
+func parseInterpolationExpression(exprText string, src *source.Source, offset func(int) int32) (ast.Expression, error) {
+	tokens, err := tok.Tokenize([]byte(exprText), "interpolation.tup")
 	if err != nil {
 		return nil, err
 	}
+	if src != nil {
+		for i := range tokens {
+			tokens[i].File = src
+			tokens[i].Offset = offset(int(tokens[i].Offset))
+			if tokens[i].ErrorOffset != 0 {
+				tokens[i].ErrorOffset = offset(int(tokens[i].ErrorOffset))
+			}
+		}
+	}
 
 	expression, remainder, err := Expression(tokens)
 	if err != nil {
@@ -131,7 +131,8 @@ func emptyTuple(tokens []tok.Token) (tupleMembers []*ast.TupleMember, remainder
 	return nil, remainder, ErrNoMatch
 }
 
-// labeled_tuple_members = "(" labeled_tuple_member { "," labeled_tuple_member } [ "," ] ")" .
+// labeled_tuple_members = "(" labeled_tuple_element { "," labeled_tuple_element } [ "," ] ")" .
+// labeled_tuple_element = labeled_tuple_member | spread_argument .
 
 func labeledTupleMembers(tokens []tok.Token) (tupleMembers []*ast.TupleMember, remainder []tok.Token, err error) {
 	// fmt.Println("labeledTupleMembers", tok.Types(tokens))
@@ -141,10 +142,17 @@ func labeledTupleMembers(tokens []tok.Token) (tupleMembers []*ast.TupleMember, r
 		return nil, tokens, ErrNoMatch
 	}
 
+	members := remainder
+	labeled := 0
 	for {
 		var member *ast.TupleMember
 		if member, remainder, err = labeledTupleMember(remainder); err == nil {
 			tupleMembers = append(tupleMembers, member)
+			labeled++
+		} else if err != ErrNoMatch {
+			return nil, remainder, err
+		} else if member, remainder, err = spreadTupleMember(remainder); err == nil {
+			tupleMembers = append(tupleMembers, member)
 		} else if err != ErrNoMatch {
 			return nil, remainder, err
 		}
@@ -154,16 +162,37 @@ func labeledTupleMembers(tokens []tok.Token) (tupleMembers []*ast.TupleMember, r
 		}
 	}
 
+	// A tuple made only of spreads is parsed by tupleMembers.
+	if labeled == 0 {
+		return nil, skipTrivia(members), ErrNoMatch
+	}
+
 	remainder = skipTrivia(remainder)
-	if remainder, found = CloseParen(remainder); !found && len(tupleMembers) > 0 {
+	if remainder, found = CloseParen(remainder); !found {
 		return nil, remainder, errorExpectingTokenType(tok.TokCloseParen, remainder)
 	}
 
-	if len(tupleMembers) > 0 {
-		return tupleMembers, remainder, nil
+	return tupleMembers, remainder, nil
+}
+
+// spread_argument = spread_op expression .
+
+func spreadTupleMember(tokens []tok.Token) (tupleMember *ast.TupleMember, remainder []tok.Token, err error) {
+	// fmt.Println("spreadTupleMember", tok.Types(tokens))
+
+	var found bool
+	if remainder, found = SpreadOp(tokens); !found {
+		return nil, tokens, ErrNoMatch
 	}
 
-	return nil, remainder, ErrNoMatch
+	var expression ast.Expression
+	if expression, remainder, err = Expression(remainder); err == nil {
+		return ast.NewSpreadTupleMember(expression), remainder, nil
+	} else if err != ErrNoMatch {
+		return nil, remainder, err
+	}
+
+	return nil, remainder, errorExpecting("expression", remainder)
 }
 
 // labeled_tuple_member = identifier ":" tuple_member .
@@ -191,7 +220,7 @@ func labeledTupleMember(tokens []tok.Token) (labeledTupleMember *ast.TupleMember
 	return nil, tokens, ErrNoMatch
 }
 
-// tuple_members = "(" tuple_member "," { tuple_member "," } [ tuple_member ] ")" .
+// tuple_members = "(" tuple_element "," { tuple_element "," } [ tuple_element ] ")" .
 
 func tupleMembers(tokens []tok.Token) (tupleMembers []*ast.TupleMember, remainder []tok.Token, err error) {
 	// fmt.Println("tupleMembers", tok.Types(tokens))
@@ -203,7 +232,7 @@ func tupleMembers(tokens []tok.Token) (tupleMembers []*ast.TupleMember, remainde
 
 	for {
 		var member *ast.TupleMember
-		if member, remainder, err = tupleMember(remainder); err == nil {
+		if member, remainder, err = tupleElement(remainder); err == nil {
 			tupleMembers = append(tupleMembers, member)
 		}
 
@@ -227,6 +256,18 @@ func tupleMembers(tokens []tok.Token) (tupleMembers []*ast.TupleMember, remainde
 	return nil, remainder, ErrNoMatch
 }
 
+// tuple_element = tuple_member | spread_argument .
+
+func tupleElement(tokens []tok.Token) (member *ast.TupleMember, remainder []tok.Token, err error) {
+	// fmt.Println("tupleElement", tok.Types(tokens))
+
+	if member, remainder, err = spreadTupleMember(tokens); err != ErrNoMatch {
+		return member, remainder, err
+	}
+
+	return tupleMember(tokens)
+}
+
 // tuple_member = expression .
 
 func tupleMember(tokens []tok.Token) (tupleMember *ast.TupleMember, remainder []tok.Token, err error) {
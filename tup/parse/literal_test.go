@@ -44,6 +44,9 @@ func TestLiteral(t *testing.T) {
 		{"labeled tuple literal with trailing comma", "(a: 1,\nb: 2,\nc: 3,\n)", ast.NodeTupleLiteral, false},
 		{"labeled tuple literal with missing colon", "(a 1, b: 2, c: 3)", ast.NodeTupleLiteral, true},
 		{"labeled tuple literal with missing value", "(a: 1, b: 2, c)", ast.NodeTupleLiteral, true},
+		{"labeled tuple literal with spread", "(...acc, d: 1)", ast.NodeTupleLiteral, false},
+		{"tuple literal with spread", "(...acc, 1)", ast.NodeTupleLiteral, false},
+		{"tuple literal with spread missing expression", "(..., 1)", ast.NodeTupleLiteral, true},
 
 		// array
 		{"array literal", "[1, 2, 3]", ast.NodeArrayLiteral, false},
@@ -93,3 +96,55 @@ func TestLiteral(t *testing.T) {
 		})
 	}
 }
+
+func TestTupleLiteral(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *ast.TupleLiteral
+		wantErr bool
+	}{
+		{
+			name:  "labeled members",
+			input: "(a: 1, b: 2)",
+			want: ast.NewTupleLiteral(true, []*ast.TupleMember{
+				ast.NewTupleMember(ast.NewIdentifier("a", nil, 0, 0), ast.NewDecimalLiteral("1", 1, nil, 0, 0)),
+				ast.NewTupleMember(ast.NewIdentifier("b", nil, 0, 0), ast.NewDecimalLiteral("2", 2, nil, 0, 0)),
+			}),
+		},
+		{
+			name:  "leading spread",
+			input: "(...acc, d: value + 1)",
+			want: ast.NewTupleLiteral(true, []*ast.TupleMember{
+				ast.NewSpreadTupleMember(ast.NewIdentifier("acc", nil, 0, 0)),
+				ast.NewTupleMember(ast.NewIdentifier("d", nil, 0, 0), ast.NewAddSubExpression(
+					ast.NewIdentifier("value", nil, 0, 0), ast.OpAdd, ast.NewDecimalLiteral("1", 1, nil, 0, 0),
+				)),
+			}),
+		},
+		{
+			name:  "trailing spread",
+			input: "(a: 1, ...rest)",
+			want: ast.NewTupleLiteral(true, []*ast.TupleMember{
+				ast.NewTupleMember(ast.NewIdentifier("a", nil, 0, 0), ast.NewDecimalLiteral("1", 1, nil, 0, 0)),
+				ast.NewSpreadTupleMember(ast.NewIdentifier("rest", nil, 0, 0)),
+			}),
+		},
+		{
+			name:  "unlabeled spread",
+			input: "(...xs, 3)",
+			want: ast.NewTupleLiteral(false, []*ast.TupleMember{
+				ast.NewSpreadTupleMember(ast.NewIdentifier("xs", nil, 0, 0)),
+				ast.NewTupleMember(nil, ast.NewDecimalLiteral("3", 3, nil, 0, 0)),
+			}),
+		},
+		{
+			name:    "spread missing expression",
+			input:   "(a: 1, ...)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		RunParseTest(t, tt.name, tt.input, tt.want, tt.wantErr, "TupleLiteral", TupleLiteral, StringerCheck[*ast.TupleLiteral])
+	}
+}
@@ -32,10 +32,5 @@ func MetaExpression(tokens []tok.Token) (expr *ast.MetaExpression, remainder []t
 		return nil, remainder, errorExpectingTokenType(tok.TokCloseParen, remainder)
 	}
 
-	keyValues := make(map[string]ast.Node, len(labeledArgs.Args))
-	for _, arg := range labeledArgs.Args {
-		keyValues[arg.Identifier.Name] = arg.Argument
-	}
-
-	return ast.NewMetaExpression(keyValues), remainder, nil
+	return ast.NewMetaExpression(labeledArgs.Args), remainder, nil
 }
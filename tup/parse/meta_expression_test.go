@@ -11,23 +11,28 @@ func TestMetaExpression(t *testing.T) {
 	tests := []struct {
 		name    string
 		input   string
-		want    map[string]string
+		want    []string
 		wantErr bool
 	}{
 		{
 			name:  "single key",
 			input: `$(file: "config.json")`,
-			want: map[string]string{
-				"file": `"config.json"`,
-			},
+			want:  []string{`file: "config.json"`},
 		},
 		{
 			name:  "multiple keys with trailing comma",
 			input: `$(hash: "hello", algorithm: "sha256",)`,
-			want: map[string]string{
-				"hash":      `"hello"`,
-				"algorithm": `"sha256"`,
-			},
+			want:  []string{`hash: "hello"`, `algorithm: "sha256"`},
+		},
+		{
+			name:  "keys in source order",
+			input: `$(algorithm: "sha256", hash: "hello")`,
+			want:  []string{`algorithm: "sha256"`, `hash: "hello"`},
+		},
+		{
+			name:  "duplicate keys",
+			input: `$(env: "HOME", env: "USER")`,
+			want:  []string{`env: "HOME"`, `env: "USER"`},
 		},
 		{
 			name:    "missing labeled arguments",
@@ -54,16 +59,12 @@ func TestMetaExpression(t *testing.T) {
 			if err != nil {
 				t.Fatalf("MetaExpression(%q): got error %v, want nil", test.input, err)
 			}
-			if len(got.KeyValues) != len(test.want) {
-				t.Fatalf("MetaExpression(%q): got %d keys, want %d", test.input, len(got.KeyValues), len(test.want))
+			if len(got.Args) != len(test.want) {
+				t.Fatalf("MetaExpression(%q): got %d keys, want %d", test.input, len(got.Args), len(test.want))
 			}
-			for key, wantValue := range test.want {
-				value, ok := got.KeyValues[key]
-				if !ok {
-					t.Fatalf("MetaExpression(%q): missing key %q", test.input, key)
-				}
-				if value.String() != wantValue {
-					t.Fatalf("MetaExpression(%q): key %q = %q, want %q", test.input, key, value.String(), wantValue)
+			for i, wantArg := range test.want {
+				if got.Args[i].String() != wantArg {
+					t.Fatalf("MetaExpression(%q): argument %d = %q, want %q", test.input, i, got.Args[i].String(), wantArg)
 				}
 			}
 		})
@@ -25,13 +25,18 @@ func MultiLineStringLiteral(tokens []tok.Token) (*ast.MultiLineStringLiteral, []
 
 	var processor *ast.FunctionCallContext
 	if header != "" {
-		processor, err = parseFunctionCallContextText(header)
+		processor, err = parseFunctionCallContextText(header, t.File, t.Offset+3)
 		if err != nil {
 			return nil, remainder, err
 		}
 	}
 
-	contents, err := parseMultiLineStringContents(body)
+	// the body follows the line ending of the opening fence line
+	bodyStart := 3 + len(header)
+	if value[bodyStart] == '\r' {
+		bodyStart++
+	}
+	contents, err := parseMultiLineStringContents(body, t.File, t.Offset+int32(bodyStart+1))
 	if err != nil {
 		return nil, remainder, err
 	}
@@ -85,12 +90,25 @@ func splitMultiLineStringToken(value string) (header string, body string, err er
 	return header, body, nil
 }
 
-func parseFunctionCallContextText(text string) (*ast.FunctionCallContext, error) {
+// parseFunctionCallContextText parses the processor header text, which
+// begins at offset in file. The header is tokenized on its own, then its
+// tokens are positioned within file so that nodes and errors point into
+// the literal.
+func parseFunctionCallContextText(text string, file *source.Source, offset int32) (*ast.FunctionCallContext, error) {
 	src := source.NewSource([]byte(text), "function_call_context.tup")
 	tokens, err := tok.Tokenize(src.Contents, src.Filename)
 	if err != nil {
 		return nil, err
 	}
+	if file != nil {
+		for i := range tokens {
+			tokens[i].File = file
+			tokens[i].Offset += offset
+			if tokens[i].ErrorOffset != 0 {
+				tokens[i].ErrorOffset += offset
+			}
+		}
+	}
 
 	context, remainder, err := FunctionCallContext(tokens)
 	if err != nil {
@@ -105,27 +123,43 @@ func parseFunctionCallContextText(text string) (*ast.FunctionCallContext, error)
 	return context, nil
 }
 
-func parseMultiLineStringContents(body string) (*ast.InterpolatedStringLiteral, error) {
+// parseMultiLineStringContents dedents the body of a multi-line string
+// literal, which begins at offset in file, and parses its interpolations.
+// The parts are positioned at the text they were dedented from.
+func parseMultiLineStringContents(body string, file *source.Source, offset int32) (*ast.InterpolatedStringLiteral, error) {
 	lines := splitLinesPreserveEndings(body)
 	indent := firstNonEmptyLineIndent(lines)
 	var builder strings.Builder
+	// the offset in file of each byte of the dedented body, and of its end
+	offsets := make([]int32, 0, len(body)+1)
 
 	// Dedent each physical line while preserving its original line ending.
 	// The rebuilt body is then parsed with the same interpolation machinery as
 	// ordinary interpolated strings, so newlines remain ordinary string content.
+	lineStart := offset
 	for _, line := range lines {
-		builder.WriteString(dedentSegment(line, indent))
+		dedented := dedentSegment(line, indent)
+		builder.WriteString(dedented)
+		removed := int32(len(line) - len(dedented))
+		for i := range dedented {
+			offsets = append(offsets, lineStart+removed+int32(i))
+		}
+		lineStart += int32(len(line))
 	}
+	offsets = append(offsets, lineStart)
 
 	content := builder.String()
-	parts, err := parseInterpolatedStringParts(nil, 0, content)
+	parts, err := parseInterpolatedStringParts(file, func(i int) int32 { return offsets[i] }, content)
 	if err != nil {
 		return nil, err
 	}
 
 	return &ast.InterpolatedStringLiteral{
 		BaseNode: ast.BaseNode{
-			Type: ast.NodeInterpolatedStringLiteral,
+			Type:        ast.NodeInterpolatedStringLiteral,
+			Source:      file,
+			StartOffset: offset,
+			Length:      int32(len(body)),
 		},
 		Parts: parts,
 	}, nil
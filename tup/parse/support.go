@@ -27,3 +27,12 @@ func skipComments(tokens []tok.Token) []tok.Token {
 func skipTrivia(tokens []tok.Token) []tok.Token {
 	return skip(tokens, tok.TokComment, tok.TokEOL)
 }
+
+func allNoMatch(errors []error) bool {
+	for _, err := range errors {
+		if err != ErrNoMatch {
+			return false
+		}
+	}
+	return true
+}
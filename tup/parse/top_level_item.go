@@ -11,6 +11,7 @@ import (
 // 	                | function_type_declaration
 // 	                | function_declaration
 // 	                | assignment
+// 	                | meta_expression
 // 	                | export_declaration
 // 	                ) .
 
@@ -69,6 +70,13 @@ func TopLevelItem(tokens []tok.Token) (item ast.TopLevelItem, remainder []tok.To
 		return nil, remainder, err
 	}
 
+	var me *ast.MetaExpression
+	if me, remainder, err = MetaExpression(tokens); err == nil {
+		return me, remainder, nil
+	} else if err != ErrNoMatch {
+		return nil, remainder, err
+	}
+
 	var ed ast.TopLevelItem
 	if ed, remainder, err = ExportDeclaration(tokens); err == nil {
 		if ed != nil {
@@ -33,5 +33,11 @@ func tupleUpdateTail(object ast.Expression, tokens []tok.Token) (expr *ast.Tuple
 		return nil, remainder, err
 	}
 
+	for _, member := range updateMembers {
+		if member.Spread {
+			return nil, remainder, errorExpecting("field name", tokens)
+		}
+	}
+
 	return ast.NewTupleUpdateExpression(object, ast.NewTupleLiteral(true, updateMembers)), remainder, nil
 }
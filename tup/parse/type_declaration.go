@@ -1164,7 +1164,8 @@ func LabeledTupleTypeMembers(tokens []tok.Token) ([]ast.TupleTypeMemberNode, []t
 //                                 | literal ) .
 //
 // The parser currently implements the subset that is already in use elsewhere:
-// nilable types, tuple types, local type references, and literals.
+// nilable types, tuple types, generic types, local type references, and
+// literals.
 
 func TupleTypeMember(tokens []tok.Token) (*ast.TupleTypeMember, []tok.Token, error) {
 	annotations, remainder, err := Annotations(tokens)
@@ -1228,6 +1229,12 @@ func tupleTypeMemberType(tokens []tok.Token) (ast.FunctionTypeParameterType, []t
 		return nil, remainder, err
 	}
 
+	if genericType, remainder, err := GenericType(tokens); err == nil {
+		return genericType, remainder, nil
+	} else if err != ErrNoMatch {
+		return nil, remainder, err
+	}
+
 	if localTypeReference, remainder, err := LocalTypeReference(tokens); err == nil {
 		memberType, ok := any(localTypeReference).(ast.FunctionTypeParameterType)
 		if !ok {
@@ -1772,6 +1772,27 @@ func TestTupleType(t *testing.T) {
 				),
 			}),
 		},
+		{
+			name:  "generic tuple type member",
+			input: "(head: a, tail: List[a])",
+			want: ast.NewTupleType([]ast.TupleTypeMemberNode{
+				ast.NewLabeledTupleTypeMember(
+					nil,
+					ast.NewIdentifier("head", nil, 0, 4),
+					ast.NewIdentifier("a", nil, 0, 1),
+				),
+				ast.NewLabeledTupleTypeMember(
+					nil,
+					ast.NewIdentifier("tail", nil, 0, 4),
+					ast.NewGenericType(
+						ast.NewTypeReference(nil, ast.NewTypeIdentifier("List", nil, 0, 4), nil, 0, 4),
+						ast.NewTypeArgumentList([]*ast.TypeArgument{
+							ast.NewTypeArgument(ast.NewIdentifier("a", nil, 0, 1)),
+						}),
+					),
+				),
+			}),
+		},
 		{
 			name:    "mixed labeled and ordinal members are rejected",
 			input:   "(name: String, Int)",
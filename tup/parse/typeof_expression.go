@@ -5,7 +5,7 @@ import (
 	"github.com/rowland/tuppence/tup/tok"
 )
 
-// typeof_expression = "typeof" "(" expression ")" .
+// typeof_expression = "typeof" "(" ( expression | type ) ")" .
 
 func TypeofExpression(tokens []tok.Token) (expr *ast.TypeofExpression, remainder []tok.Token, err error) {
 	remainder = skipTrivia(tokens)
@@ -20,14 +20,23 @@ func TypeofExpression(tokens []tok.Token) (expr *ast.TypeofExpression, remainder
 		return nil, remainder, errorExpectingTokenType(tok.TokOpenParen, remainder)
 	}
 
-	var expression ast.Expression
-	if expression, remainder, err = Expression(remainder); err != nil {
-		return nil, remainder, err
+	expression, remainder2, exprErr := Expression(remainder)
+	if exprErr == nil {
+		if remainder2, found = CloseParen(remainder2); found {
+			return ast.NewTypeofExpression(expression), remainder2, nil
+		}
+		exprErr = errorExpectingTokenType(tok.TokCloseParen, remainder2)
 	}
 
-	if remainder, found = CloseParen(remainder); !found {
-		return nil, remainder, errorExpectingTokenType(tok.TokCloseParen, remainder)
+	// types such as Int and []Int are not expressions
+	if typ, remainder3, err := Type(remainder); err == nil {
+		if remainder3, found = CloseParen(remainder3); found {
+			return ast.NewTypeofType(typ), remainder3, nil
+		}
 	}
 
-	return ast.NewTypeofExpression(expression), remainder, nil
+	if exprErr == ErrNoMatch {
+		return nil, remainder, errorExpecting("expression or type", remainder)
+	}
+	return nil, remainder2, exprErr
 }
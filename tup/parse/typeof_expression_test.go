@@ -31,6 +31,41 @@ func TestTypeofExpression(t *testing.T) {
 				),
 			),
 		},
+		{
+			name:  "type",
+			input: "typeof(Int)",
+			want: ast.NewTypeofType(
+				ast.NewTypeReference(nil, ast.NewTypeIdentifier("Int", nil, 0, 3), nil, 0, 3),
+			),
+		},
+		{
+			name:  "array type",
+			input: "typeof([]Int)",
+			want: ast.NewTypeofType(
+				ast.NewDynamicArrayType(
+					ast.NewTypeReference(nil, ast.NewTypeIdentifier("Int", nil, 0, 3), nil, 0, 3),
+				),
+			),
+		},
+		{
+			name:  "type constructor call",
+			input: "typeof(Point(1, 2))",
+			want: ast.NewTypeofExpression(
+				ast.NewTypeConstructorCall(
+					ast.NewTypeReference(nil, ast.NewTypeIdentifier("Point", nil, 0, 5), nil, 0, 5),
+					nil,
+					ast.NewFunctionArguments(
+						ast.NewArguments([]*ast.Argument{
+							ast.NewArgument(ast.NewDecimalLiteral("1", 1, nil, 0, 0), false),
+							ast.NewArgument(ast.NewDecimalLiteral("2", 2, nil, 0, 0), false),
+						}),
+						nil,
+						false,
+					),
+					nil,
+				),
+			),
+		},
 		{
 			name:    "missing expression",
 			input:   "typeof()",
@@ -0,0 +1,130 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+	"github.com/rowland/tuppence/tup/tok"
+)
+
+const help = `:type expr     print the type of expr without evaluating it
+:ast expr      print the syntax tree of expr or of a top-level item
+:tokens text   print the tokens of text
+:load file     add the definitions in file
+:help          print this message
+:quit          leave the REPL
+`
+
+// command runs a meta command and reports whether it is :quit.
+func (r *REPL) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	var err error
+	switch name {
+	case ":quit", ":q":
+		return true
+	case ":help":
+		fmt.Fprint(r.Stdout, help)
+	case ":type":
+		err = r.typeOf(arg)
+	case ":ast":
+		err = r.ast(arg)
+	case ":tokens":
+		err = r.tokens(arg)
+	case ":load":
+		err = r.load(arg)
+	default:
+		err = fmt.Errorf("unknown command %s; :help lists the commands", name)
+	}
+	if err != nil {
+		r.report(err)
+	}
+	return false
+}
+
+// typeOf writes the type of an expression checked against the definitions.
+func (r *REPL) typeOf(text string) error {
+	src := source.NewSource([]byte(text), "<type>")
+	tokens, err := tok.Tokenize(src.Contents, src.Filename)
+	if err != nil {
+		return err
+	}
+	expr, _ := expression(tokens)
+	if expr == nil {
+		return fmt.Errorf("usage: :type expr")
+	}
+	name := "repl#type"
+	_, info, err := r.check(src, []ast.TopLevelItem{binding(src, name, expr)})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(r.Stdout, "%s : %s\n", expr, info.Scope.LookupLocal(name).Type)
+	return nil
+}
+
+// ast writes the syntax tree of an expression or of top-level items.
+func (r *REPL) ast(text string) error {
+	src := source.NewSource([]byte(text), "<ast>")
+	tokens, err := tok.Tokenize(src.Contents, src.Filename)
+	if err != nil {
+		return err
+	}
+	if expr, _ := expression(tokens); expr != nil {
+		dump(r.Stdout, expr, 0)
+		return nil
+	}
+	module, err := parse.Module(src, ast.NewModule(r.module.Name))
+	if err != nil {
+		return err
+	}
+	for _, item := range module.TopLevelItems {
+		dump(r.Stdout, item, 0)
+	}
+	return nil
+}
+
+// dump writes node and its descendants, one per line, indented by depth.
+func dump(w io.Writer, node ast.Node, depth int) {
+	text := strings.Join(strings.Fields(node.String()), " ")
+	fmt.Fprintf(w, "%s%s %s\n", strings.Repeat("  ", depth), node.NodeType(), text)
+	for _, child := range ast.Children(node) {
+		dump(w, child, depth+1)
+	}
+}
+
+// tokens writes the tokens of text, one per line.
+func (r *REPL) tokens(text string) error {
+	tokens, err := tok.Tokenize([]byte(text), "<tokens>")
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if token.Type == tok.TokEOF {
+			break
+		}
+		fmt.Fprintf(r.Stdout, "%s %s\n", token.Type, token.Value())
+	}
+	return nil
+}
+
+// load adds the definitions in a file, without evaluating them.
+func (r *REPL) load(filename string) error {
+	if filename == "" {
+		return fmt.Errorf("usage: :load file.tup")
+	}
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	src := source.NewSource(contents, filename)
+	module, err := parse.Module(src, ast.NewModule(r.module.Name))
+	if err != nil {
+		return err
+	}
+	return r.define(src, module.TopLevelItems, false)
+}
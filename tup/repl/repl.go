@@ -0,0 +1,290 @@
+// Package repl implements an interactive read-eval-print loop over the
+// parser, checker and interpreter.
+//
+// Each entry is a top-level item, such as an assignment or a function or
+// type declaration, or an expression. Definitions accumulate in a module
+// that later entries are checked against; an entry that declares a name
+// already defined replaces the earlier definition. Expressions are checked
+// and evaluated against the definitions without being added to them.
+// Lines beginning with a colon are meta commands; see :help.
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/ast"
+	"github.com/rowland/tuppence/tup/check"
+	"github.com/rowland/tuppence/tup/interp"
+	"github.com/rowland/tuppence/tup/parse"
+	"github.com/rowland/tuppence/tup/source"
+	"github.com/rowland/tuppence/tup/tok"
+	"github.com/rowland/tuppence/tup/types"
+)
+
+const (
+	prompt             = "> "
+	continuationPrompt = "... "
+)
+
+// errIncomplete is returned by Eval for input that ends before the entry it
+// begins.
+var errIncomplete = errors.New("incomplete entry")
+
+// REPL holds the state of a read-eval-print loop.
+type REPL struct {
+	// Stdout receives prompts, results, messages and the output of print.
+	Stdout io.Writer
+
+	module  *ast.Module // the definitions entered so far
+	entries int         // the number of entries read, to name their sources
+}
+
+// New returns a REPL without definitions that writes to os.Stdout.
+func New() *REPL {
+	return &REPL{Stdout: os.Stdout, module: ast.NewModule("repl")}
+}
+
+// Run reads entries from input line by line until it is exhausted or a
+// :quit command is read. Lines are added to an entry until it is complete:
+// while braces, brackets, parentheses or a multi-line string are open, or
+// the parser expects more tokens at the end of the input, the entry
+// continues on the next line. Errors in an entry are reported and do not
+// end the loop.
+func (r *REPL) Run(input io.Reader) error {
+	scanner := bufio.NewScanner(input)
+	var entry strings.Builder
+	fmt.Fprint(r.Stdout, prompt)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if entry.Len() == 0 {
+			trimmed := strings.TrimSpace(line)
+			if strings.HasPrefix(trimmed, ":") {
+				if r.command(trimmed) {
+					return nil
+				}
+				fmt.Fprint(r.Stdout, prompt)
+				continue
+			}
+			if trimmed == "" {
+				fmt.Fprint(r.Stdout, prompt)
+				continue
+			}
+		}
+		entry.WriteString(line)
+		entry.WriteString("\n")
+		if err := r.Eval(entry.String()); errors.Is(err, errIncomplete) {
+			fmt.Fprint(r.Stdout, continuationPrompt)
+			continue
+		} else if err != nil {
+			r.report(err)
+		}
+		entry.Reset()
+		fmt.Fprint(r.Stdout, prompt)
+	}
+	fmt.Fprintln(r.Stdout)
+	if entry.Len() > 0 {
+		r.report(fmt.Errorf("unexpected end of input in entry:\n%s", entry.String()))
+	}
+	return scanner.Err()
+}
+
+// Eval checks and evaluates an entry, writing its results to Stdout. It
+// returns errIncomplete if the entry ends early, and any error found
+// parsing, checking or evaluating it otherwise.
+func (r *REPL) Eval(text string) error {
+	src := source.NewSource([]byte(text), fmt.Sprintf("<repl %d>", r.entries+1))
+	tokens, err := tok.Tokenize(src.Contents, src.Filename)
+	if err != nil {
+		return err
+	}
+	expr, exprErr := expression(tokens)
+	if expr != nil {
+		r.entries++
+		return r.evalExpr(src, expr)
+	}
+	module, err := parse.Module(src, ast.NewModule(r.module.Name))
+	if err != nil {
+		if incomplete(tokens, err) || incomplete(tokens, exprErr) {
+			return errIncomplete
+		}
+		r.entries++
+		if exprErr != nil && atStart(tokens, err) {
+			// the entry is not a top-level item: report why it is not an
+			// expression either
+			return exprErr
+		}
+		return err
+	}
+	r.entries++
+	return r.define(src, module.TopLevelItems, true)
+}
+
+// evalExpr checks and evaluates expr, which is not a top-level item, by
+// binding it to a name that cannot be written in source.
+func (r *REPL) evalExpr(src *source.Source, expr ast.Expression) error {
+	name := fmt.Sprintf("repl#%d", r.entries)
+	_, info, err := r.check(src, []ast.TopLevelItem{binding(src, name, expr)})
+	if err != nil {
+		return err
+	}
+	in := interp.New(info)
+	in.Stdout = r.Stdout
+	v, err := in.Value(name)
+	if err != nil {
+		return err
+	}
+	if typ := info.Scope.LookupLocal(name).Type; !types.Identical(typ, types.Typ[types.Nil]) {
+		fmt.Fprintf(r.Stdout, "%s : %s\n", v, typ)
+	}
+	return nil
+}
+
+// define adds items to the definitions, replacing the earlier definitions
+// of the names they declare, and writes the names with their types. The
+// values of bindings are written too if eval is set.
+func (r *REPL) define(src *source.Source, items []ast.TopLevelItem, eval bool) error {
+	module, info, err := r.check(src, items)
+	if err != nil {
+		return err
+	}
+	r.module = module
+
+	in := interp.New(info)
+	in.Stdout = r.Stdout
+	for _, item := range items {
+		for _, name := range check.Declares(item) {
+			obj := info.Scope.LookupLocal(name)
+			switch {
+			case obj.Kind == check.TypeObject:
+				fmt.Fprintf(r.Stdout, "%s = %s\n", name, obj.Type.Underlying())
+			case obj.Kind == check.VarObject && eval:
+				v, err := in.Value(name)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(r.Stdout, "%s = %s : %s\n", name, v, obj.Type)
+			default:
+				fmt.Fprintf(r.Stdout, "%s : %s\n", name, obj.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// check checks items, read from src, together with the definitions they do
+// not replace. It writes the warnings found in items and returns the
+// module checked.
+func (r *REPL) check(src *source.Source, items []ast.TopLevelItem) (*ast.Module, *check.Info, error) {
+	replaced := map[string]bool{}
+	for _, item := range items {
+		for _, name := range check.Declares(item) {
+			replaced[name] = true
+		}
+	}
+	module := ast.NewModule(r.module.Name)
+	module.Sources = append(r.module.Sources[:len(r.module.Sources):len(r.module.Sources)], src)
+	for _, item := range r.module.TopLevelItems {
+		if !declaresAny(item, replaced) {
+			module.AddTopLevelItem(item)
+		}
+	}
+	for _, item := range items {
+		module.AddTopLevelItem(item)
+	}
+
+	info, err := check.Module(module)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, warning := range info.Warnings {
+		if warning.Pos.Filename == src.Filename {
+			fmt.Fprintln(r.Stdout, warning)
+		}
+	}
+	return module, info, nil
+}
+
+func declaresAny(item ast.TopLevelItem, names map[string]bool) bool {
+	for _, name := range check.Declares(item) {
+		if names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// binding returns the assignment of expr to name.
+func binding(src *source.Source, name string, expr ast.Expression) *ast.Assignment {
+	ident := ast.NewIdentifier(name, src, 0, 0)
+	return ast.NewAssignment(ast.NewOrdinalAssignmentLHS([]*ast.Identifier{ident}, nil), false, expr)
+}
+
+// expression returns the expression tokens consist of, or nil and the
+// error parsing them if they are not a single expression.
+func expression(tokens []tok.Token) (ast.Expression, error) {
+	expr, remainder, err := parse.Expression(tokens)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range remainder {
+		switch token.Type {
+		case tok.TokEOL, tok.TokComment, tok.TokEOF:
+		default:
+			return nil, nil
+		}
+	}
+	return expr, nil
+}
+
+// atStart reports whether err, from parsing tokens, is reported at their
+// first token.
+func atStart(tokens []tok.Token, err error) bool {
+	var perr *parse.Error
+	if !errors.As(err, &perr) {
+		return false
+	}
+	for _, token := range tokens {
+		if token.Type != tok.TokEOL && token.Type != tok.TokComment {
+			line, column := token.Position()
+			return perr.Line == line && perr.Column == column
+		}
+	}
+	return false
+}
+
+// incomplete reports whether err, from parsing tokens, shows that the
+// input ends before the entry it begins: it has unclosed braces, brackets
+// or parentheses or an unterminated multi-line string, or the parser
+// expected more tokens at its end.
+func incomplete(tokens []tok.Token, err error) bool {
+	var perr *parse.Error
+	if !errors.As(err, &perr) {
+		return false
+	}
+	if perr.Got == "" {
+		return true
+	}
+	depth := 0
+	for _, token := range tokens {
+		switch token.Type {
+		case tok.TokOpenBrace, tok.TokOpenBracket, tok.TokOpenParen:
+			depth++
+		case tok.TokCloseBrace, tok.TokCloseBracket, tok.TokCloseParen:
+			depth--
+		case tok.TokMultiStrLit:
+			if token.Invalid {
+				return true
+			}
+		}
+	}
+	return depth > 0
+}
+
+func (r *REPL) report(err error) {
+	fmt.Fprintln(r.Stdout, err)
+}
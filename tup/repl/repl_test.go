@@ -0,0 +1,79 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// run feeds input to a new REPL and returns what it wrote.
+func run(t *testing.T, input string) string {
+	t.Helper()
+	var stdout bytes.Buffer
+	r := New()
+	r.Stdout = &stdout
+	if err := r.Run(strings.NewReader(input)); err != nil {
+		t.Fatalf("Run(%q) = %v", input, err)
+	}
+	return stdout.String()
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"expression", "1 + 2\n", "> 3 : Int\n> \n"},
+		{"nil result", "print(\"hi\")\n", "> hi\n> \n"},
+		{"definition", "x = 1 + 2\nx * 2\n", "> x = 3 : Int\n> 6 : Int\n> \n"},
+		{"destructuring", "a, b = (1, \"b\")\n", "> a = 1 : Int\nb = \"b\" : String\n> \n"},
+		{"function", "f = fn(n: Int) Int { n * 2 }\nf(21)\n", "> f : fn(n: Int) Int\n> 42 : Int\n> \n"},
+		{"type", "P = type(a: Int)\nP(1).a\n", "> P = (a: Int)\n> 1 : Int\n> \n"},
+		{"redefinition", "x = 1\nf = fn() Int { x }\nx = 2\nf()\n", "> x = 1 : Int\n> f : fn() Int\n> x = 2 : Int\n> 2 : Int\n> \n"},
+		{"open brace", "f = fn(n: Int) Int {\n\tn + 1\n}\nf(1)\n", "> ... ... f : fn(n: Int) Int\n> 2 : Int\n> \n"},
+		{"open parenthesis", "(1,\n2)\n", "> ... (1, 2) : (Int, Int)\n> \n"},
+		{"trailing operator", "1 +\n2\n", "> ... 3 : Int\n> \n"},
+		{"multi-line string", "s = ```\n\tab\n\t```\n", "> ... ... s = \"ab\\n\" : String\n> \n"},
+		{"blank lines", "\n\n1\n", "> > > 1 : Int\n> \n"},
+		{"unfinished at end", "f = fn() Int {\n", "> ... \nunexpected end of input in entry:\nf = fn() Int {\n\n"},
+		{"parse error", "1 + )\n2\n", "> error: expecting \"expression\", got \")\"\n--> <repl 1>:1:5\n> 2 : Int\n> \n"},
+		{"check error", "x = y\nx = 1\n", "> error: undefined: y\n--> <repl 1>:1:5\n> x = 1 : Int\n> \n"},
+		{"runtime error", "zero = fx() Int { 0 }\n1 / zero()\n", "> zero : fx() Int\n> runtime error: division by zero\n--> <repl 2>:1:1\n> \n"},
+		{"quit", ":quit\n1\n", "> "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := run(t, test.input); got != test.want {
+				t.Errorf("Run(%q) wrote:\n%s\nwant:\n%s", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"type", "f = fn(n: Int) Int { n }\n:type f(1) < 2\n", "f(1) < 2 : Bool\n"},
+		{"type of undefined", ":type y\n", "error: undefined: y\n--> <type>:1:1\n"},
+		{"ast", ":ast 1 + f(2)\n", "AddSubExpression 1 + f(2)\n  IntegerLiteral 1\n  FunctionCall f(2)\n    FunctionIdentifier f\n    FunctionArguments (2)\n      Arguments 2\n        Argument 2\n          IntegerLiteral 2\n"},
+		{"ast of item", ":ast x = 1\n", "Assignment x = 1\n  OrdinalAssignmentLHS x\n    Identifier x\n  IntegerLiteral 1\n"},
+		{"tokens", ":tokens x = 1\n", "identifier x\n= =\ndecimal_literal 1\n"},
+		{"load", ":load ../../examples/fib.tup\nfib_sequence(5)\n", "fib_sequence : fn(n: Int) []Int\nmain : fx()\n> [0, 1, 1, 2, 3] : []Int\n"},
+		{"load missing file", ":load missing.tup\n", "open missing.tup: no such file or directory\n"},
+		{"unknown", ":bogus\n", "unknown command :bogus; :help lists the commands\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := run(t, test.input+":quit\n")
+			// the output of the last entry ends with the prompt for :quit
+			got = strings.TrimSuffix(got, "> ")
+			if !strings.HasSuffix(got, test.want) {
+				t.Errorf("Run(%q) wrote:\n%s\nwant it to end with:\n%s", test.input, got, test.want)
+			}
+		})
+	}
+}
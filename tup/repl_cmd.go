@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rowland/tuppence/tup/repl"
+	"github.com/spf13/pflag"
+)
+
+// replCommand reads, checks and evaluates entries from standard input:
+//
+//	tup repl
+func replCommand(args []string) error {
+	flags := pflag.NewFlagSet("repl", pflag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: tup repl")
+	}
+	return repl.New().Run(os.Stdin)
+}
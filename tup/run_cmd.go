@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rowland/tuppence/tup/bytecode"
+	"github.com/rowland/tuppence/tup/interp"
+	"github.com/spf13/pflag"
+)
+
+// runCommand checks a module and runs its main function with the
+// interpreter, or runs a program built for the bytecode target with the VM.
+// --memstats writes the allocation statistics of the VM to stderr once the
+// program stops:
+//
+//	tup run [--memstats] file.tup|file.tupc
+func runCommand(args []string) error {
+	flags := pflag.NewFlagSet("run", pflag.ContinueOnError)
+	memstats := flags.Bool("memstats", false, "Write the allocation statistics of the VM to stderr")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: tup run [--memstats] file.tup|file.tupc")
+	}
+	if strings.HasSuffix(flags.Arg(0), ".tupc") {
+		p, err := loadProgram(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		vm, err := bytecode.New(p)
+		if err != nil {
+			return err
+		}
+		err = vm.Run()
+		if *memstats {
+			fmt.Fprintf(os.Stderr, "memstats: %v\n", vm.Stats())
+		}
+		return err
+	}
+	if *memstats {
+		return fmt.Errorf("--memstats applies to bytecode programs only")
+	}
+	info, err := checkFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = interp.New(info).Run("main")
+	return err
+}
@@ -344,6 +344,12 @@ outer:
 					if invalid {
 						break outer
 					}
+					if t.index >= len(t.source) {
+						// no body or closing sequence follows the header
+						invalid = true
+						errorIndex = t.index
+						break outer
+					}
 					st = stateMultiStrBody
 				} else {
 					// Regular raw string literal
@@ -805,6 +805,7 @@ func TestMultiLineStringLiteral(t *testing.T) {
 
 		// Invalid cases
 		{"unclosed_string", "```\nUnclosed string", TokMultiStrLit, true},
+		{"unclosed_after_header", "```\n", TokMultiStrLit, true},
 		{"missing_newline_after_open", "```Some text\n```", TokMultiStrLit, true},
 		{"missing_newline_before_close", "```\nSome text```", TokMultiStrLit, true},
 		{"invalid_escape", "```\nInvalid escape: \\z\n```", TokMultiStrLit, true},
@@ -0,0 +1,31 @@
+package types
+
+import "strconv"
+
+// Array represents a dynamic ([]T) or fixed-size ([n]T) array type.
+type Array struct {
+	Elem Type
+	Len  int64 // -1 for dynamic arrays
+}
+
+// NewArray returns a new dynamic array type with the given element type.
+func NewArray(elem Type) *Array {
+	return &Array{Elem: elem, Len: -1}
+}
+
+// NewFixedArray returns a new fixed-size array type.
+func NewFixedArray(elem Type, length int64) *Array {
+	return &Array{Elem: elem, Len: length}
+}
+
+// Fixed reports whether the array has a size known at compile time.
+func (a *Array) Fixed() bool { return a.Len >= 0 }
+
+func (a *Array) Underlying() Type { return a }
+
+func (a *Array) String() string {
+	if a.Len < 0 {
+		return "[]" + a.Elem.String()
+	}
+	return "[" + strconv.FormatInt(a.Len, 10) + "]" + a.Elem.String()
+}
@@ -0,0 +1,32 @@
+package types
+
+import "strings"
+
+// Contract represents the requirements of a contract, such as
+// Numeric[a] = contract(add[a] = fn(a, a) a), on the types that satisfy
+// it: the functions they must provide, whose types mention the type
+// parameter of the contract, and the fields they must have.
+type Contract struct {
+	Funcs  []*Field
+	Fields []*Field
+}
+
+// NewContract returns a new contract requiring funcs and fields.
+func NewContract(funcs, fields []*Field) *Contract {
+	return &Contract{Funcs: funcs, Fields: fields}
+}
+
+func (c *Contract) Underlying() Type { return c }
+
+func (c *Contract) String() string {
+	var builder strings.Builder
+	builder.WriteString("contract(")
+	for i, f := range append(c.Funcs[:len(c.Funcs):len(c.Funcs)], c.Fields...) {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(f.String())
+	}
+	builder.WriteString(")")
+	return builder.String()
+}
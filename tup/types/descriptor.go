@@ -0,0 +1,191 @@
+package types
+
+// Tag identifies a type at run time. It is the index of the type's
+// descriptor in a Table shifted left by one bit, with the low-order bit set
+// when values of the type are references.
+type Tag uint32
+
+// MakeTag returns the tag for the descriptor at index.
+func MakeTag(index int, ref bool) Tag {
+	tag := Tag(index) << 1
+	if ref {
+		tag |= 1
+	}
+	return tag
+}
+
+// Index returns the index of the descriptor identified by t.
+func (t Tag) Index() int { return int(t >> 1) }
+
+// IsRef reports whether values of the type identified by t are references.
+func (t Tag) IsRef() bool { return t&1 != 0 }
+
+// DescriptorKind describes the kind of type a Descriptor describes.
+type DescriptorKind int
+
+const (
+	BasicDescriptor DescriptorKind = iota
+	TupleDescriptor
+	ArrayDescriptor
+	UnionDescriptor
+	FunctionDescriptor
+	NamedDescriptor
+	TypeParamDescriptor
+	EnumDescriptor
+)
+
+// FieldDescriptor describes a field of a tuple or a function parameter.
+// Label is empty for unlabeled fields.
+type FieldDescriptor struct {
+	Label string
+	Type  Tag
+}
+
+// Descriptor is the run-time description of a type, as returned by typeof.
+// Only the members relevant to its kind are set.
+type Descriptor struct {
+	Tag  Tag
+	Kind DescriptorKind
+	Type Type
+	// Name is the declared name of named and basic types.
+	Name string
+
+	// Fields holds the fields of a tuple, the parameters of a function or
+	// the members of an enum.
+	Fields []FieldDescriptor
+	// Values holds the values of the members of an enum.
+	Values []int64
+	// Members holds the members of a union.
+	Members []Tag
+	// Elem is the element type of an array.
+	Elem Tag
+	// Len is the length of a fixed-size array, or -1 if it is dynamic.
+	Len int64
+	// Result is the result type of a function if HasResult is set.
+	Result    Tag
+	HasResult bool
+	// Underlying is the type a named type was declared with.
+	Underlying Tag
+}
+
+// Labels returns the labels of the fields of d, which are empty for
+// unlabeled fields.
+func (d *Descriptor) Labels() []string {
+	labels := make([]string, len(d.Fields))
+	for i, field := range d.Fields {
+		labels[i] = field.Label
+	}
+	return labels
+}
+
+// Table holds the descriptors of the types used by a program. Anonymous
+// types with identical structures share a single descriptor; named types
+// each have their own.
+type Table struct {
+	descs []*Descriptor
+}
+
+// NewTable returns an empty table.
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Len returns the number of descriptors in the table.
+func (t *Table) Len() int { return len(t.descs) }
+
+// Descriptor returns the descriptor identified by tag, or nil if there is
+// none.
+func (t *Table) Descriptor(tag Tag) *Descriptor {
+	if tag.Index() >= len(t.descs) {
+		return nil
+	}
+	return t.descs[tag.Index()]
+}
+
+// Lookup returns the descriptor of typ, or nil if it has not been added.
+func (t *Table) Lookup(typ Type) *Descriptor {
+	for _, d := range t.descs {
+		if Identical(d.Type, typ) {
+			return d
+		}
+	}
+	return nil
+}
+
+// Add returns the descriptor of typ, adding it and the descriptors of the
+// types it is built from if they are not already in the table.
+func (t *Table) Add(typ Type) *Descriptor {
+	if d := t.Lookup(typ); d != nil {
+		return d
+	}
+	d := &Descriptor{Tag: MakeTag(len(t.descs), isRef(typ)), Type: typ, Len: -1}
+	// The descriptor is added before those of its components so that
+	// recursive named types refer back to it.
+	t.descs = append(t.descs, d)
+	switch typ := typ.(type) {
+	case *Basic:
+		d.Kind = BasicDescriptor
+		// aliases share the descriptor of the type they alias
+		d.Name = Typ[typ.kind].name
+	case *Named:
+		d.Kind = NamedDescriptor
+		d.Name = typ.name
+		underlying := typ.underlying
+		if underlying == nil {
+			underlying = Typ[Invalid]
+		}
+		d.Underlying = t.Add(underlying).Tag
+	case *TypeParam:
+		d.Kind = TypeParamDescriptor
+		d.Name = typ.name
+	case *Tuple:
+		d.Kind = TupleDescriptor
+		d.Fields = t.fields(typ.Fields)
+	case *Array:
+		d.Kind = ArrayDescriptor
+		d.Elem = t.Add(typ.Elem).Tag
+		d.Len = typ.Len
+	case *Union:
+		d.Kind = UnionDescriptor
+		for _, m := range typ.Members {
+			d.Members = append(d.Members, t.Add(m).Tag)
+		}
+	case *Enum:
+		d.Kind = EnumDescriptor
+		tag := t.Add(Int).Tag
+		for _, m := range typ.Members {
+			d.Fields = append(d.Fields, FieldDescriptor{Label: m.Name, Type: tag})
+			d.Values = append(d.Values, m.Value)
+		}
+	case *Function:
+		d.Kind = FunctionDescriptor
+		d.Fields = t.fields(typ.Params)
+		if typ.Result != nil {
+			d.Result = t.Add(typ.Result).Tag
+			d.HasResult = true
+		}
+	}
+	return d
+}
+
+func (t *Table) fields(fields []*Field) []FieldDescriptor {
+	descs := make([]FieldDescriptor, len(fields))
+	for i, field := range fields {
+		descs[i] = FieldDescriptor{Label: field.Name, Type: t.Add(field.Type).Tag}
+	}
+	return descs
+}
+
+// isRef reports whether values of typ are held by reference: strings,
+// dynamic arrays and functions.
+func isRef(typ Type) bool {
+	switch t := typ.Underlying().(type) {
+	case *Basic:
+		return t.kind == String
+	case *Array:
+		return !t.Fixed()
+	case *Function:
+		return true
+	}
+	return false
+}
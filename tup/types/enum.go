@@ -0,0 +1,60 @@
+package types
+
+import (
+	"strconv"
+	"strings"
+)
+
+// EnumMember is a named constant of an enum type.
+type EnumMember struct {
+	Name  string
+	Value int64
+}
+
+// Enum represents an enum type: a closed set of named integer constants.
+// Enum types are only identical to themselves.
+type Enum struct {
+	Members []*EnumMember
+}
+
+// NewEnum returns a new enum type with the given members.
+func NewEnum(members ...*EnumMember) *Enum {
+	return &Enum{Members: members}
+}
+
+// Member returns the member with the given name, or nil.
+func (e *Enum) Member(name string) *EnumMember {
+	for _, m := range e.Members {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// MemberOf returns the member with the given value, or nil.
+func (e *Enum) MemberOf(value int64) *EnumMember {
+	for _, m := range e.Members {
+		if m.Value == value {
+			return m
+		}
+	}
+	return nil
+}
+
+func (e *Enum) Underlying() Type { return e }
+
+func (e *Enum) String() string {
+	var builder strings.Builder
+	builder.WriteString("enum(")
+	for i, m := range e.Members {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(m.Name)
+		builder.WriteString(" = ")
+		builder.WriteString(strconv.FormatInt(m.Value, 10))
+	}
+	builder.WriteString(")")
+	return builder.String()
+}
@@ -0,0 +1,81 @@
+package types
+
+import "strings"
+
+// Function represents the type of an fn or fx function.
+type Function struct {
+	Params         []*Field
+	Result         Type // nil if the function returns nothing
+	HasSideEffects bool // true for fx, false for fn
+	// Rest is the index of the rest parameter, whose type is an array of
+	// the values it collects, or -1 if there is none.
+	Rest int
+	// TypeParams holds the type parameters of a generic function
+	// declaration, which are given types when it is instantiated.
+	TypeParams []*TypeParam
+}
+
+// NewFunction returns a new function type without a rest parameter.
+func NewFunction(params []*Field, result Type, hasSideEffects bool) *Function {
+	return &Function{Params: params, Result: result, HasSideEffects: hasSideEffects, Rest: -1}
+}
+
+func (f *Function) Underlying() Type { return f }
+
+func (f *Function) String() string {
+	var builder strings.Builder
+	if f.HasSideEffects {
+		builder.WriteString("fx(")
+	} else {
+		builder.WriteString("fn(")
+	}
+	for i, param := range f.Params {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		if array, ok := param.Type.(*Array); ok && i == f.Rest {
+			if param.Name != "" {
+				builder.WriteString(param.Name)
+				builder.WriteString(": ")
+			}
+			builder.WriteString("...")
+			builder.WriteString(array.Elem.String())
+			continue
+		}
+		builder.WriteString(param.String())
+	}
+	builder.WriteString(")")
+	if f.Result != nil {
+		builder.WriteString(" ")
+		builder.WriteString(f.Result.String())
+	}
+	return builder.String()
+}
+
+// TypeParam represents a type parameter of a generic declaration,
+// such as the a in Range[a]. Type parameters are identified by their
+// names.
+type TypeParam struct {
+	name       string
+	constraint Type
+}
+
+// NewTypeParam returns a new type parameter with the given name.
+func NewTypeParam(name string) *TypeParam {
+	return &TypeParam{name: name}
+}
+
+// NewConstrainedTypeParam returns a new type parameter with the given name,
+// whose types must satisfy constraint, a contract such as Numeric[a].
+func NewConstrainedTypeParam(name string, constraint Type) *TypeParam {
+	return &TypeParam{name: name, constraint: constraint}
+}
+
+// Name returns the name of the type parameter.
+func (t *TypeParam) Name() string { return t.name }
+
+// Constraint returns the contract the types of t must satisfy, or nil.
+func (t *TypeParam) Constraint() Type { return t.constraint }
+
+func (t *TypeParam) Underlying() Type { return t }
+func (t *TypeParam) String() string   { return t.name }
@@ -0,0 +1,317 @@
+package types
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Instantiate returns the instance of the generic type n for the type
+// arguments args, such as Cons[Int] for Cons. Instantiating n twice with
+// identical arguments yields the same type. The underlying type of the
+// instance is that of n with its type parameters replaced by args, once
+// the underlying type of n is known.
+func Instantiate(n *Named, args []Type) *Named {
+	for i, arg := range args {
+		args[i] = Default(arg)
+	}
+	name := InstanceName(n.name, args)
+	if inst, ok := n.instances[name]; ok {
+		return inst
+	}
+	inst := &Named{name: name, annotations: n.annotations, origin: n, typeArgs: args}
+	if n.instances == nil {
+		n.instances = map[string]*Named{}
+	}
+	// the instance is recorded before its underlying type is made, which
+	// may refer back to it
+	n.instances[name] = inst
+	if n.underlying != nil {
+		inst.underlying = Subst(n.underlying, inst.bindings())
+	}
+	return inst
+}
+
+// InstanceName returns the name of the instance of the generic type or
+// function name for the type arguments args, such as map[Int, String].
+// Instances with identical type arguments have the same name.
+func InstanceName(name string, args []Type) string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = typeKey(arg)
+	}
+	return name + "[" + strings.Join(keys, ", ") + "]"
+}
+
+// typeKey returns the text of t, with the members of unions sorted and
+// basic types spelled alike so that identical types have the same key.
+func typeKey(t Type) string {
+	switch t := t.(type) {
+	case *Basic:
+		// the kinds with common aliases are spelled as the aliases
+		switch k := basicKind(Default(t)); k {
+		case Int64:
+			return Int.name
+		case UInt64:
+			return UInt.name
+		case Float64:
+			return Float.name
+		default:
+			return Typ[k].name
+		}
+	case *Tuple:
+		fields := make([]string, len(t.Fields))
+		for i, f := range t.Fields {
+			fields[i] = typeKey(f.Type)
+			if f.Name != "" {
+				fields[i] = f.Name + ": " + fields[i]
+			}
+		}
+		return "(" + strings.Join(fields, ", ") + ")"
+	case *Array:
+		if t.Fixed() {
+			return "[" + strconv.FormatInt(t.Len, 10) + "]" + typeKey(t.Elem)
+		}
+		return "[]" + typeKey(t.Elem)
+	case *Union:
+		members := make([]string, len(t.Members))
+		for i, m := range t.Members {
+			members[i] = typeKey(m)
+		}
+		slices.Sort(members)
+		return strings.Join(members, " | ")
+	case *Function:
+		sig := *t
+		sig.Params = make([]*Field, len(t.Params))
+		for i, p := range t.Params {
+			sig.Params[i] = NewField(p.Name, keyType{typeKey(p.Type)})
+		}
+		if t.Result != nil {
+			sig.Result = keyType{typeKey(t.Result)}
+		}
+		return sig.String()
+	}
+	return Default(t).String()
+}
+
+// keyType stands in for a type written as its key.
+type keyType struct{ key string }
+
+func (t keyType) Underlying() Type { return t }
+func (t keyType) String() string   { return t.key }
+
+// Subst returns t with the type parameters named in args replaced by the
+// types they map to. Instances of generic types whose type arguments
+// change are instantiated anew. Parts of t that do not change are shared.
+func Subst(t Type, args map[string]Type) Type {
+	if len(args) == 0 {
+		return t
+	}
+	switch t := t.(type) {
+	case *TypeParam:
+		if arg, ok := args[t.name]; ok {
+			return arg
+		}
+	case *Named:
+		if t.origin == nil {
+			return t
+		}
+		targs, changed := substTypes(t.typeArgs, args)
+		if changed {
+			return Instantiate(t.origin, targs)
+		}
+	case *Tuple:
+		if fields, changed := substFields(t.Fields, args); changed {
+			return NewTuple(fields...)
+		}
+	case *Array:
+		if elem := Subst(t.Elem, args); elem != t.Elem {
+			return &Array{Elem: elem, Len: t.Len}
+		}
+	case *Union:
+		if members, changed := substTypes(t.Members, args); changed {
+			return NewUnion(members...)
+		}
+	case *Function:
+		params, changed := substFields(t.Params, args)
+		result := t.Result
+		if result != nil {
+			result = Subst(result, args)
+		}
+		if changed || result != t.Result {
+			return &Function{Params: params, Result: result, HasSideEffects: t.HasSideEffects, Rest: t.Rest}
+		}
+	case *Contract:
+		funcs, changedFuncs := substFields(t.Funcs, args)
+		fields, changedFields := substFields(t.Fields, args)
+		if changedFuncs || changedFields {
+			return NewContract(funcs, fields)
+		}
+	}
+	return t
+}
+
+func substTypes(typs []Type, args map[string]Type) ([]Type, bool) {
+	result := make([]Type, len(typs))
+	changed := false
+	for i, t := range typs {
+		result[i] = Subst(t, args)
+		changed = changed || result[i] != t
+	}
+	return result, changed
+}
+
+func substFields(fields []*Field, args map[string]Type) ([]*Field, bool) {
+	result := make([]*Field, len(fields))
+	changed := false
+	for i, f := range fields {
+		result[i] = f
+		if typ := Subst(f.Type, args); typ != f.Type {
+			result[i] = NewField(f.Name, typ)
+			changed = true
+		}
+	}
+	return result, changed
+}
+
+// Infer infers the types of the type parameters param mentions from arg,
+// the type of a value passed where a value of type param is expected,
+// adding them to args. A value passed as a generic union is matched with
+// the member of the same generic type, or with its one type parameter.
+// Infer reports false if a type parameter would have two types.
+func Infer(param, arg Type, args map[string]Type) bool {
+	if IsInvalid(arg) {
+		return true
+	}
+	switch p := param.(type) {
+	case *TypeParam:
+		prev, ok := args[p.name]
+		switch {
+		case !ok:
+			args[p.name] = Default(arg)
+			return true
+		case IsUntyped(arg):
+			// an untyped constant fits any numeric type it may be
+			// converted to
+			return IsFloat(prev) || IsInteger(prev) && IsInteger(arg)
+		}
+		return Identical(prev, Default(arg))
+	case *Named:
+		if p.origin == nil {
+			return true
+		}
+		if a, ok := arg.(*Named); ok && a.origin == p.origin {
+			for i, targ := range p.typeArgs {
+				if i < len(a.typeArgs) && !Infer(targ, a.typeArgs[i], args) {
+					return false
+				}
+			}
+			return true
+		}
+		if u, ok := p.Underlying().(*Union); ok {
+			return inferMember(u, arg, args)
+		}
+	case *Array:
+		if a, ok := arg.Underlying().(*Array); ok {
+			return Infer(p.Elem, a.Elem, args)
+		}
+	case *Tuple:
+		if a, ok := arg.Underlying().(*Tuple); ok && len(a.Fields) == len(p.Fields) {
+			for i, f := range p.Fields {
+				if !Infer(f.Type, a.Fields[i].Type, args) {
+					return false
+				}
+			}
+		}
+	case *Union:
+		if a, ok := arg.(*Union); ok {
+			for _, m := range a.Members {
+				if !inferMember(p, m, args) {
+					return false
+				}
+			}
+			return true
+		}
+		return inferMember(p, arg, args)
+	case *Function:
+		a, ok := arg.Underlying().(*Function)
+		if !ok || len(a.Params) != len(p.Params) {
+			return true
+		}
+		for i, param := range p.Params {
+			if !Infer(param.Type, a.Params[i].Type, args) {
+				return false
+			}
+		}
+		if p.Result != nil && a.Result != nil {
+			return Infer(p.Result, a.Result, args)
+		}
+	}
+	return true
+}
+
+// inferMember infers the types of the type parameters of the union u from
+// arg, the type of a value passed as one of its members.
+func inferMember(u *Union, arg Type, args map[string]Type) bool {
+	var params []Type
+	for _, m := range u.Members {
+		if Identical(m, arg) {
+			return true
+		}
+		if n, ok := m.(*Named); ok && n.origin != nil {
+			if a, ok := arg.(*Named); ok && a.origin == n.origin {
+				return Infer(m, arg, args)
+			}
+		}
+		if IsTypeParam(m) {
+			params = append(params, m)
+		}
+	}
+	if len(params) == 1 {
+		return Infer(params[0], arg, args)
+	}
+	return true
+}
+
+// TypeParamsOf returns the type parameters t mentions, in the order they
+// first appear. Of the mentions of a type parameter, one with a constraint
+// is returned.
+func TypeParamsOf(t Type) []*TypeParam {
+	var params []*TypeParam
+	seen := map[string]int{}
+	var visit func(t Type)
+	visit = func(t Type) {
+		switch t := t.(type) {
+		case *TypeParam:
+			if i, ok := seen[t.name]; !ok {
+				seen[t.name] = len(params)
+				params = append(params, t)
+			} else if params[i].constraint == nil {
+				params[i] = t
+			}
+		case *Named:
+			for _, arg := range t.typeArgs {
+				visit(arg)
+			}
+		case *Tuple:
+			for _, f := range t.Fields {
+				visit(f.Type)
+			}
+		case *Array:
+			visit(t.Elem)
+		case *Union:
+			for _, m := range t.Members {
+				visit(m)
+			}
+		case *Function:
+			for _, p := range t.Params {
+				visit(p.Type)
+			}
+			if t.Result != nil {
+				visit(t.Result)
+			}
+		}
+	}
+	visit(t)
+	return params
+}
@@ -0,0 +1,88 @@
+package types
+
+import "slices"
+
+// Named represents a type declared with a name, such as
+// ABC = type(a: Int, b: String). A generic declaration, such as
+// Cons[a] = type(head: a, tail: List[a]), declares a named type with type
+// parameters, whose instances, such as Cons[Int], are named types too.
+type Named struct {
+	name        string
+	underlying  Type
+	annotations []string
+
+	typeParams []*TypeParam
+	// origin is the generic type n is an instance of, for the type
+	// arguments typeArgs, or nil
+	origin   *Named
+	typeArgs []Type
+	// instances holds the instances of a generic type by InstanceName,
+	// so that instantiating it twice alike yields the same type
+	instances map[string]*Named
+}
+
+// NewNamed returns a new named type. The underlying type may be nil and
+// set later with SetUnderlying, which allows recursive declarations.
+func NewNamed(name string, underlying Type, annotations ...string) *Named {
+	return &Named{name: name, underlying: underlying, annotations: annotations}
+}
+
+// Name returns the declared name of the type.
+func (n *Named) Name() string { return n.name }
+
+// SetUnderlying sets the underlying type of n, and of the instances of n
+// made before it was known.
+func (n *Named) SetUnderlying(underlying Type) {
+	n.underlying = underlying
+	for _, inst := range n.instances {
+		if inst.underlying == nil {
+			inst.underlying = Subst(underlying, inst.bindings())
+		}
+	}
+}
+
+// TypeParams returns the type parameters of a generic type, or nil.
+func (n *Named) TypeParams() []*TypeParam { return n.typeParams }
+
+// SetTypeParams declares the type parameters of a generic type.
+func (n *Named) SetTypeParams(params []*TypeParam) { n.typeParams = params }
+
+// Origin returns the generic type n is an instance of, or nil.
+func (n *Named) Origin() *Named { return n.origin }
+
+// TypeArgs returns the type arguments of an instance of a generic type.
+func (n *Named) TypeArgs() []Type { return n.typeArgs }
+
+// bindings maps the names of the type parameters of the origin of an
+// instance to its type arguments.
+func (n *Named) bindings() map[string]Type {
+	m := map[string]Type{}
+	for i, param := range n.origin.typeParams {
+		if i < len(n.typeArgs) {
+			m[param.name] = n.typeArgs[i]
+		}
+	}
+	return m
+}
+
+// Annotations returns the simple annotations applied to the declaration,
+// without the leading "@".
+func (n *Named) Annotations() []string { return n.annotations }
+
+// HasAnnotation reports whether the declaration was annotated with @name.
+func (n *Named) HasAnnotation(name string) bool {
+	return slices.Contains(n.annotations, name)
+}
+
+func (n *Named) Underlying() Type {
+	if n.underlying == nil {
+		return Typ[Invalid]
+	}
+	return n.underlying.Underlying()
+}
+
+func (n *Named) String() string { return n.name }
+
+// ErrorType is the predeclared error type. Declarations of the form
+// X = error(...) satisfy it.
+var ErrorType = NewNamed("error", NewTuple(), "error", "false")
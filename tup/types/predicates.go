@@ -0,0 +1,188 @@
+package types
+
+// Identical reports whether x and y are identical types.
+func Identical(x, y Type) bool {
+	if x == y {
+		return true
+	}
+	switch x := x.(type) {
+	case *Basic:
+		if y, ok := y.(*Basic); ok {
+			return x.kind == y.kind
+		}
+	case *Tuple:
+		if y, ok := y.(*Tuple); ok {
+			if len(x.Fields) != len(y.Fields) {
+				return false
+			}
+			for i, f := range x.Fields {
+				g := y.Fields[i]
+				if f.Name != g.Name || !Identical(f.Type, g.Type) {
+					return false
+				}
+			}
+			return true
+		}
+	case *Array:
+		if y, ok := y.(*Array); ok {
+			return x.Len == y.Len && Identical(x.Elem, y.Elem)
+		}
+	case *Union:
+		if y, ok := y.(*Union); ok {
+			if len(x.Members) != len(y.Members) {
+				return false
+			}
+			for _, m := range x.Members {
+				if !y.Contains(m) {
+					return false
+				}
+			}
+			return true
+		}
+	case *Function:
+		if y, ok := y.(*Function); ok {
+			if x.HasSideEffects != y.HasSideEffects || x.Rest != y.Rest || len(x.Params) != len(y.Params) {
+				return false
+			}
+			for i, p := range x.Params {
+				if !Identical(p.Type, y.Params[i].Type) {
+					return false
+				}
+			}
+			if x.Result == nil || y.Result == nil {
+				return x.Result == nil && y.Result == nil
+			}
+			return Identical(x.Result, y.Result)
+		}
+	case *TypeParam:
+		if y, ok := y.(*TypeParam); ok {
+			return x.name == y.name
+		}
+	}
+	// Named types are only identical to themselves.
+	return false
+}
+
+func basicKind(t Type) BasicKind {
+	if t == nil {
+		return Invalid
+	}
+	if b, ok := t.Underlying().(*Basic); ok {
+		return b.kind
+	}
+	return Invalid
+}
+
+// IsInteger reports whether t is an integer type, including untyped integers.
+func IsInteger(t Type) bool {
+	k := basicKind(t)
+	return k >= Int8 && k <= UInt64 || k == UntypedInt
+}
+
+// IsUnsigned reports whether t is an unsigned integer type.
+func IsUnsigned(t Type) bool {
+	k := basicKind(t)
+	return k >= UInt8 && k <= UInt64
+}
+
+// IsFloat reports whether t is a floating-point type, including untyped floats.
+func IsFloat(t Type) bool {
+	k := basicKind(t)
+	return k >= Float16 && k <= Float64 || k == UntypedFloat
+}
+
+// IsNumeric reports whether t is an integer or floating-point type.
+func IsNumeric(t Type) bool {
+	return IsInteger(t) || IsFloat(t)
+}
+
+// IsUntyped reports whether t is the type of an untyped literal.
+func IsUntyped(t Type) bool {
+	k := basicKind(t)
+	return k == UntypedInt || k == UntypedFloat
+}
+
+// IsString reports whether t is the String type.
+func IsString(t Type) bool {
+	return basicKind(t) == String
+}
+
+// IsBool reports whether t is the Bool type.
+func IsBool(t Type) bool {
+	return basicKind(t) == Bool
+}
+
+// IsEnum reports whether t is an enum type.
+func IsEnum(t Type) bool {
+	_, ok := t.Underlying().(*Enum)
+	return ok
+}
+
+// IsTypeParam reports whether t is a type parameter.
+func IsTypeParam(t Type) bool {
+	_, ok := t.(*TypeParam)
+	return ok
+}
+
+// IsGeneric reports whether t is or mentions a type parameter, so that
+// values of t have a type that is known only once t is instantiated.
+func IsGeneric(t Type) bool {
+	switch t := t.(type) {
+	case *TypeParam:
+		return true
+	case *Named:
+		// a generic type is instantiated with the types of its values
+		if t.origin == nil {
+			return t.typeParams != nil
+		}
+		for _, arg := range t.typeArgs {
+			if IsGeneric(arg) {
+				return true
+			}
+		}
+	case *Array:
+		return IsGeneric(t.Elem)
+	case *Tuple:
+		for _, f := range t.Fields {
+			if IsGeneric(f.Type) {
+				return true
+			}
+		}
+	case *Union:
+		for _, m := range t.Members {
+			if IsGeneric(m) {
+				return true
+			}
+		}
+	case *Function:
+		for _, p := range t.Params {
+			if IsGeneric(p.Type) {
+				return true
+			}
+		}
+		return t.Result != nil && IsGeneric(t.Result)
+	}
+	return false
+}
+
+// IsInvalid reports whether t is nil or the invalid type.
+func IsInvalid(t Type) bool {
+	if t == nil {
+		return true
+	}
+	b, ok := t.(*Basic)
+	return ok && b.kind == Invalid
+}
+
+// Default returns the default type for the type of an untyped literal:
+// Int for untyped integers and Float for untyped floats. Other types are
+// returned unchanged.
+func Default(t Type) Type {
+	switch basicKind(t) {
+	case UntypedInt:
+		return Int
+	case UntypedFloat:
+		return Float
+	}
+	return t
+}
@@ -0,0 +1,71 @@
+package types
+
+import "strings"
+
+// Field is a member of a tuple type or a function parameter.
+// Name is empty for unlabeled members.
+type Field struct {
+	Name string
+	Type Type
+}
+
+// NewField returns a new field with the given name and type.
+func NewField(name string, typ Type) *Field {
+	return &Field{Name: name, Type: typ}
+}
+
+func (f *Field) String() string {
+	if f.Name == "" {
+		return f.Type.String()
+	}
+	return f.Name + ": " + f.Type.String()
+}
+
+// Tuple represents a labeled or unlabeled tuple type.
+type Tuple struct {
+	Fields []*Field
+}
+
+// NewTuple returns a new tuple type with the given fields.
+func NewTuple(fields ...*Field) *Tuple {
+	return &Tuple{Fields: fields}
+}
+
+// Labeled reports whether every field of the tuple has a label.
+// The empty tuple is unlabeled.
+func (t *Tuple) Labeled() bool {
+	if len(t.Fields) == 0 {
+		return false
+	}
+	for _, field := range t.Fields {
+		if field.Name == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// FieldIndex returns the index of the field with the given label, or -1.
+func (t *Tuple) FieldIndex(name string) int {
+	for i, field := range t.Fields {
+		if field.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *Tuple) Underlying() Type { return t }
+
+func (t *Tuple) String() string {
+	var builder strings.Builder
+	builder.WriteString("(")
+	for i, field := range t.Fields {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(field.String())
+	}
+	builder.WriteString(")")
+	return builder.String()
+}
@@ -0,0 +1,94 @@
+// Package types declares the data types that represent Tuppence types and
+// the predicates used by the checker to compare them.
+package types
+
+// Type is the interface implemented by all Tuppence types.
+type Type interface {
+	// Underlying returns the underlying type of a type. Named types return
+	// the type they were declared with; all other types return themselves.
+	Underlying() Type
+	// String returns a textual representation of the type as it would be
+	// written in source.
+	String() string
+}
+
+// BasicKind describes the kind of a basic type.
+type BasicKind int
+
+const (
+	Invalid BasicKind = iota // type is invalid
+
+	// predeclared types
+	Nil
+	Bool
+	Int8
+	Int16
+	Int32
+	Int64
+	UInt8
+	UInt16
+	UInt32
+	UInt64
+	Float16
+	Float32
+	Float64
+	String
+	Symbol
+	TypeDescriptor // the type of typeof expressions
+
+	// types for untyped values
+	UntypedInt
+	UntypedFloat
+)
+
+// Basic represents a predeclared type. Aliases such as Int, UInt, Byte,
+// Rune and Float share the kind of the type they alias but keep their own
+// name for display.
+type Basic struct {
+	kind BasicKind
+	name string
+}
+
+// Kind returns the kind of basic type b.
+func (b *Basic) Kind() BasicKind { return b.kind }
+
+// Name returns the name of basic type b.
+func (b *Basic) Name() string { return b.name }
+
+func (b *Basic) Underlying() Type { return b }
+func (b *Basic) String() string   { return b.name }
+
+// Typ contains the predeclared types indexed by their kind.
+var Typ = [...]*Basic{
+	Invalid:        {Invalid, "invalid type"},
+	Nil:            {Nil, "Nil"},
+	Bool:           {Bool, "Bool"},
+	Int8:           {Int8, "Int8"},
+	Int16:          {Int16, "Int16"},
+	Int32:          {Int32, "Int32"},
+	Int64:          {Int64, "Int64"},
+	UInt8:          {UInt8, "UInt8"},
+	UInt16:         {UInt16, "UInt16"},
+	UInt32:         {UInt32, "UInt32"},
+	UInt64:         {UInt64, "UInt64"},
+	Float16:        {Float16, "Float16"},
+	Float32:        {Float32, "Float32"},
+	Float64:        {Float64, "Float64"},
+	String:         {String, "String"},
+	Symbol:         {Symbol, "Symbol"},
+	TypeDescriptor: {TypeDescriptor, "Type"},
+	UntypedInt:     {UntypedInt, "untyped Int"},
+	UntypedFloat:   {UntypedFloat, "untyped Float"},
+}
+
+// Aliases for predeclared types, as declared in lib/core-*.tup.
+var (
+	Int   = &Basic{Int64, "Int"}
+	UInt  = &Basic{UInt64, "UInt"}
+	Float = &Basic{Float64, "Float"}
+	Byte  = &Basic{UInt8, "Byte"}
+	Rune  = &Basic{Int32, "Rune"}
+)
+
+// Aliases lists the predeclared aliases.
+var Aliases = [...]*Basic{Int, UInt, Float, Byte, Rune}
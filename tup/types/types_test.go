@@ -0,0 +1,307 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  Type
+		want string
+	}{
+		{"basic", Typ[String], "String"},
+		{"alias", Int, "Int"},
+		{"empty tuple", NewTuple(), "()"},
+		{"unlabeled tuple", NewTuple(NewField("", Int), NewField("", Typ[String])), "(Int, String)"},
+		{"labeled tuple", NewTuple(NewField("a", Int), NewField("b", Float)), "(a: Int, b: Float)"},
+		{"dynamic array", NewArray(Byte), "[]Byte"},
+		{"fixed array", NewFixedArray(Int, 4), "[4]Int"},
+		{"union", NewUnion(Int, Typ[String]), "Int | String"},
+		{"named", NewNamed("ABC", NewTuple()), "ABC"},
+		{"fn", NewFunction([]*Field{NewField("n", Int)}, Int, false), "fn(n: Int) Int"},
+		{"fx", NewFunction(nil, nil, true), "fx()"},
+		{"rest", &Function{Params: []*Field{NewField("args", NewArray(Int)), NewField("f", NewFunction([]*Field{NewField("", Int)}, Int, false))}, Rest: 0}, "fn(args: ...Int, f: fn(Int) Int)"},
+		{"enum", NewEnum(&EnumMember{"a", 0}, &EnumMember{"b", 5}), "enum(a = 0, b = 5)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.typ.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentical(t *testing.T) {
+	named := NewNamed("ABC", NewTuple(NewField("a", Int)))
+	tests := []struct {
+		name string
+		x, y Type
+		want bool
+	}{
+		{"alias", Int, Typ[Int64], true},
+		{"different basics", Int, Typ[Int32], false},
+		{"tuples", NewTuple(NewField("a", Int)), NewTuple(NewField("a", Int)), true},
+		{"tuple labels", NewTuple(NewField("a", Int)), NewTuple(NewField("b", Int)), false},
+		{"named and underlying", named, named.Underlying(), false},
+		{"named", named, named, true},
+		{"arrays", NewArray(Int), NewArray(Int), true},
+		{"array lengths", NewFixedArray(Int, 3), NewArray(Int), false},
+		{"unions in any order", NewUnion(Int, Typ[String]), NewUnion(Typ[String], Int), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Identical(tt.x, tt.y); got != tt.want {
+				t.Errorf("Identical(%s, %s) = %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewUnion(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []Type
+		want    string
+	}{
+		{"single member", []Type{Int}, "Int"},
+		{"duplicates", []Type{Int, Typ[String], Int}, "Int | String"},
+		{"nested", []Type{Int, NewUnion(Typ[String], Typ[Nil])}, "Int | String | Nil"},
+		{"invalid members", []Type{Typ[Invalid], Int}, "Int"},
+		{"no members", nil, "invalid type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewUnion(tt.members...).String(); got != tt.want {
+				t.Errorf("NewUnion() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTag(t *testing.T) {
+	tests := []struct {
+		index int
+		ref   bool
+		want  Tag
+	}{
+		{0, false, 0},
+		{0, true, 1},
+		{3, false, 6},
+		{3, true, 7},
+	}
+	for _, tt := range tests {
+		tag := MakeTag(tt.index, tt.ref)
+		if tag != tt.want || tag.Index() != tt.index || tag.IsRef() != tt.ref {
+			t.Errorf("MakeTag(%d, %v) = %d (index %d, ref %v), want %d",
+				tt.index, tt.ref, tag, tag.Index(), tag.IsRef(), tt.want)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := NewTable()
+	point := NewNamed("Point", NewTuple(NewField("x", Int), NewField("y", Int)))
+	other := NewNamed("Other", NewTuple(NewField("x", Int), NewField("y", Int)))
+
+	t.Run("anonymous types are merged", func(t *testing.T) {
+		x := table.Add(NewTuple(NewField("a", Int), NewField("b", Typ[String])))
+		y := table.Add(NewTuple(NewField("a", Typ[Int64]), NewField("b", Typ[String])))
+		if x != y {
+			t.Errorf("identical tuples have tags %d and %d", x.Tag, y.Tag)
+		}
+		if got := table.Add(NewUnion(Typ[String], Int)); got != table.Add(NewUnion(Int, Typ[String])) {
+			t.Errorf("identical unions have different descriptors")
+		}
+	})
+
+	t.Run("named types are distinct", func(t *testing.T) {
+		p, o := table.Add(point), table.Add(other)
+		if p == o {
+			t.Fatalf("Point and Other share tag %d", p.Tag)
+		}
+		if p.Kind != NamedDescriptor || p.Name != "Point" {
+			t.Errorf("Point descriptor = %v %q", p.Kind, p.Name)
+		}
+		if p.Underlying != o.Underlying {
+			t.Errorf("identical underlying types have tags %d and %d", p.Underlying, o.Underlying)
+		}
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		d := table.Descriptor(table.Add(point).Underlying)
+		if d.Kind != TupleDescriptor {
+			t.Fatalf("kind = %v, want TupleDescriptor", d.Kind)
+		}
+		if got := d.Labels(); len(got) != 2 || got[0] != "x" || got[1] != "y" {
+			t.Errorf("Labels() = %q, want [x y]", got)
+		}
+		if got := table.Descriptor(d.Fields[0].Type).Name; got != "Int64" {
+			t.Errorf("field type = %s, want Int64", got)
+		}
+	})
+
+	t.Run("union members", func(t *testing.T) {
+		d := table.Add(NewUnion(Int, Typ[String], Typ[Nil]))
+		var names []string
+		for _, m := range d.Members {
+			names = append(names, table.Descriptor(m).Name)
+		}
+		if len(names) != 3 || names[0] != "Int64" || names[1] != "String" || names[2] != "Nil" {
+			t.Errorf("members = %q, want [Int64 String Nil]", names)
+		}
+	})
+
+	t.Run("arrays", func(t *testing.T) {
+		dynamic, fixed := table.Add(NewArray(Byte)), table.Add(NewFixedArray(Byte, 4))
+		if !dynamic.Tag.IsRef() || fixed.Tag.IsRef() {
+			t.Errorf("IsRef() = %v, %v, want true, false", dynamic.Tag.IsRef(), fixed.Tag.IsRef())
+		}
+		if dynamic.Elem != fixed.Elem || table.Descriptor(dynamic.Elem).Name != "UInt8" {
+			t.Errorf("element tags = %d, %d", dynamic.Elem, fixed.Elem)
+		}
+		if dynamic.Len != -1 || fixed.Len != 4 {
+			t.Errorf("Len = %d, %d, want -1, 4", dynamic.Len, fixed.Len)
+		}
+	})
+
+	t.Run("recursive types", func(t *testing.T) {
+		list := NewNamed("List", nil)
+		list.SetUnderlying(NewUnion(Typ[Nil], NewTuple(NewField("head", Int), NewField("tail", list))))
+		d := table.Add(list)
+		cons := table.Descriptor(table.Descriptor(d.Underlying).Members[1])
+		if got := cons.Fields[1].Type; got != d.Tag {
+			t.Errorf("tail tag = %d, want %d", got, d.Tag)
+		}
+	})
+}
+
+// newList returns the generic types List[a] = Nil | Cons[a] and
+// Cons[a] = type(head: a, tail: List[a]).
+func newList() (list, cons *Named) {
+	a := NewTypeParam("a")
+	list, cons = NewNamed("List", nil), NewNamed("Cons", nil)
+	list.SetTypeParams([]*TypeParam{a})
+	cons.SetTypeParams([]*TypeParam{a})
+	list.SetUnderlying(NewUnion(Typ[Nil], Instantiate(cons, []Type{a})))
+	cons.SetUnderlying(NewTuple(NewField("head", a), NewField("tail", Instantiate(list, []Type{a}))))
+	return list, cons
+}
+
+func TestInstantiate(t *testing.T) {
+	list, cons := newList()
+	ints := Instantiate(list, []Type{Int})
+	if ints != Instantiate(list, []Type{Typ[Int64]}) {
+		t.Errorf("instantiating List twice with Int yields distinct types")
+	}
+	if got, want := ints.String(), "List[Int]"; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+	if ints.Origin() != list || IsGeneric(ints) || !IsGeneric(list) {
+		t.Errorf("Origin() = %v, IsGeneric() = %v", ints.Origin(), IsGeneric(ints))
+	}
+	u, ok := ints.Underlying().(*Union)
+	if !ok || len(u.Members) != 2 {
+		t.Fatalf("underlying type of List[Int] = %s", ints.Underlying())
+	}
+	c, ok := u.Members[1].(*Named)
+	if !ok || c.Origin() != cons || c.String() != "Cons[Int]" {
+		t.Fatalf("member of List[Int] = %s, want Cons[Int]", u.Members[1])
+	}
+	if got, want := c.Underlying().String(), "(head: Int, tail: List[Int])"; got != want {
+		t.Errorf("underlying type of Cons[Int] = %s, want %s", got, want)
+	}
+	if tail := c.Underlying().(*Tuple).Fields[1].Type; tail != ints {
+		t.Errorf("tail of Cons[Int] is not List[Int]")
+	}
+}
+
+func TestSubst(t *testing.T) {
+	a, b := NewTypeParam("a"), NewTypeParam("b")
+	list, _ := newList()
+	args := map[string]Type{"a": Int, "b": Typ[String]}
+	tests := []struct {
+		name string
+		typ  Type
+		want string
+	}{
+		{"type param", a, "Int"},
+		{"unbound", NewTypeParam("c"), "c"},
+		{"array", NewArray(a), "[]Int"},
+		{"tuple", NewTuple(NewField("x", a), NewField("y", b)), "(x: Int, y: String)"},
+		{"union", NewUnion(a, Typ[Nil]), "Int | Nil"},
+		{"fn", NewFunction([]*Field{NewField("x", a)}, b, false), "fn(x: Int) String"},
+		{"instance", Instantiate(list, []Type{NewArray(a)}), "List[[]Int]"},
+		{"concrete", Typ[Bool], "Bool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Subst(tt.typ, args).String(); got != tt.want {
+				t.Errorf("Subst(%s) = %s, want %s", tt.typ, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfer(t *testing.T) {
+	a, b := NewTypeParam("a"), NewTypeParam("b")
+	list, cons := newList()
+	tests := []struct {
+		name       string
+		params     []Type
+		args       []Type
+		want       string
+		wantFailed bool
+	}{
+		{"type params", []Type{a, b}, []Type{Int, Typ[String]}, "a: Int, b: String", false},
+		{"untyped", []Type{a}, []Type{Typ[UntypedInt]}, "a: Int", false},
+		{"untyped after typed", []Type{a, a}, []Type{Float, Typ[UntypedInt]}, "a: Float", false},
+		{"conflict", []Type{a, a}, []Type{Int, Typ[String]}, "a: Int", true},
+		{"array", []Type{NewArray(a)}, []Type{NewArray(Typ[Bool])}, "a: Bool", false},
+		{"fn", []Type{NewFunction([]*Field{NewField("", a)}, b, false)},
+			[]Type{NewFunction([]*Field{NewField("x", Int)}, Typ[String], false)}, "a: Int, b: String", false},
+		{"instance", []Type{Instantiate(list, []Type{a})}, []Type{Instantiate(list, []Type{Int})}, "a: Int", false},
+		{"union member", []Type{Instantiate(list, []Type{a})}, []Type{Instantiate(cons, []Type{Float})}, "a: Float", false},
+		{"union", []Type{NewUnion(a, Typ[Nil])}, []Type{Typ[String]}, "a: String", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bound := map[string]Type{}
+			ok := true
+			for i, param := range tt.params {
+				ok = ok && Infer(param, tt.args[i], bound)
+			}
+			var got []string
+			for _, name := range []string{"a", "b"} {
+				if typ, found := bound[name]; found {
+					got = append(got, name+": "+typ.String())
+				}
+			}
+			if strings.Join(got, ", ") != tt.want || ok == tt.wantFailed {
+				t.Errorf("Infer() = %q, %v, want %q, %v", got, ok, tt.want, !tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	point := NewNamed("Point", NewTuple(NewField("x", Int)))
+	tests := []struct {
+		args []Type
+		want string
+	}{
+		{[]Type{Int, Typ[String]}, "map[Int, String]"},
+		{[]Type{Typ[UntypedFloat]}, "map[Float]"},
+		{[]Type{NewUnion(Typ[String], Int)}, "map[Int | String]"},
+		{[]Type{NewUnion(Int, Typ[String])}, "map[Int | String]"},
+		{[]Type{NewTuple(NewField("", NewArray(point)), NewField("n", Typ[Bool]))}, "map[([]Point, n: Bool)]"},
+		{[]Type{NewFunction([]*Field{NewField("x", Int)}, NewUnion(Typ[Nil], Int), false)}, "map[fn(x: Int) Int | Nil]"},
+	}
+	for _, tt := range tests {
+		if got := InstanceName("map", tt.args); got != tt.want {
+			t.Errorf("InstanceName(map, %v) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package types
+
+import "strings"
+
+// Union represents a union of two or more distinct types.
+type Union struct {
+	Members []Type
+}
+
+// NewUnion returns the union of the given types. Nested unions are
+// flattened and identical members removed. Invalid members, such as the
+// type of a branch that never produces a value, contribute nothing. If
+// only one distinct member remains, it is returned instead of a union.
+func NewUnion(members ...Type) Type {
+	var flat []Type
+	var add func(t Type)
+	add = func(t Type) {
+		if u, ok := t.(*Union); ok {
+			for _, m := range u.Members {
+				add(m)
+			}
+			return
+		}
+		for _, m := range flat {
+			if Identical(m, t) {
+				return
+			}
+		}
+		flat = append(flat, t)
+	}
+	for _, m := range members {
+		if !IsInvalid(m) {
+			add(m)
+		}
+	}
+	switch len(flat) {
+	case 0:
+		return Typ[Invalid]
+	case 1:
+		return flat[0]
+	}
+	return &Union{Members: flat}
+}
+
+// Contains reports whether t is identical to one of the members of u.
+func (u *Union) Contains(t Type) bool {
+	for _, m := range u.Members {
+		if Identical(m, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Union) Underlying() Type { return u }
+
+func (u *Union) String() string {
+	var builder strings.Builder
+	for i, member := range u.Members {
+		if i > 0 {
+			builder.WriteString(" | ")
+		}
+		builder.WriteString(member.String())
+	}
+	return builder.String()
+}
@@ -0,0 +1,397 @@
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Error is an error decoding, parsing or validating a module.
+type Error struct {
+	Msg string
+}
+
+func (e *Error) Error() string { return "wasm: " + e.Msg }
+
+func errorf(format string, args ...any) error {
+	return &Error{Msg: fmt.Sprintf(format, args...)}
+}
+
+// Decode reads a module in the binary format. Custom sections are skipped.
+// It does not validate the module.
+func Decode(b []byte) (m *Module, err error) {
+	d := &decoder{buf: b}
+	defer func() {
+		if r := recover(); r != nil {
+			bail, ok := r.(decodeBailout)
+			if !ok {
+				panic(r)
+			}
+			m, err = nil, bail.err
+		}
+	}()
+	if !bytes.Equal(d.take(4), magic) {
+		d.fail(0, "not a WebAssembly module")
+	}
+	if !bytes.Equal(d.take(4), version) {
+		d.fail(4, "unsupported version")
+	}
+	m = &Module{}
+	var funcTypes []uint32
+	last := 0
+	for d.pos < len(d.buf) {
+		start := d.pos
+		id := int(d.byte())
+		size := int(d.u32())
+		end := d.pos + size
+		if end > len(d.buf) {
+			d.fail(start, "section %d runs past the end of the module", id)
+		}
+		if id == secCustom {
+			d.pos = end
+			continue
+		}
+		if id == secStart || id > secDataCnt {
+			d.fail(start, "unsupported section %d", id)
+		}
+		if sectionOrder[id] <= last {
+			d.fail(start, "section %d out of order", id)
+		}
+		last = sectionOrder[id]
+		switch id {
+		case secType:
+			for n := d.u32(); n > 0; n-- {
+				if at := d.pos; d.byte() != funcTypeForm {
+					d.fail(at, "malformed function type")
+				}
+				params := d.valTypes()
+				results := d.valTypes()
+				m.Types = append(m.Types, FuncType{Params: params, Results: results})
+			}
+		case secImport:
+			for n := d.u32(); n > 0; n-- {
+				imp := &Import{Module: d.name(), Name: d.name()}
+				if at := d.pos; d.byte() != byte(ExternFunc) {
+					d.fail(at, "unsupported import of %s.%s: only functions may be imported", imp.Module, imp.Name)
+				}
+				imp.Type = d.u32()
+				m.Imports = append(m.Imports, imp)
+			}
+		case secFunction:
+			for n := d.u32(); n > 0; n-- {
+				funcTypes = append(funcTypes, d.u32())
+			}
+		case secTable:
+			if at, n := d.pos, d.u32(); n != 1 {
+				d.fail(at, "unsupported number of tables %d", n)
+			}
+			if at := d.pos; ValType(d.byte()) != FuncRef {
+				d.fail(at, "unsupported table element type")
+			}
+			min, _, hasMax := d.limits()
+			if hasMax {
+				d.fail(d.pos, "unsupported table maximum")
+			}
+			m.Table = &Table{Min: min}
+		case secMemory:
+			if at, n := d.pos, d.u32(); n != 1 {
+				d.fail(at, "unsupported number of memories %d", n)
+			}
+			min, max, hasMax := d.limits()
+			m.Memory = &Memory{Min: min, Max: max, HasMax: hasMax}
+		case secGlobal:
+			for n := d.u32(); n > 0; n-- {
+				g := &Global{Type: d.valType()}
+				switch at := d.pos; d.byte() {
+				case 0:
+				case 1:
+					g.Mutable = true
+				default:
+					d.fail(at, "malformed mutability")
+				}
+				g.Init = d.constExpr()
+				m.Globals = append(m.Globals, g)
+			}
+		case secExport:
+			for n := d.u32(); n > 0; n-- {
+				exp := &Export{Name: d.name()}
+				at := d.pos
+				exp.Kind = ExternKind(d.byte())
+				if exp.Kind > ExternGlobal {
+					d.fail(at, "malformed export kind")
+				}
+				exp.Index = d.u32()
+				m.Exports = append(m.Exports, exp)
+			}
+		case secElement:
+			for n := d.u32(); n > 0; n-- {
+				if at, flags := d.pos, d.u32(); flags != 0 {
+					d.fail(at, "unsupported element segment kind %d", flags)
+				}
+				el := &Elem{Offset: d.offset()}
+				for k := d.u32(); k > 0; k-- {
+					el.Funcs = append(el.Funcs, d.u32())
+				}
+				m.Elems = append(m.Elems, el)
+			}
+		case secCode:
+			if at, n := d.pos, d.u32(); int(n) != len(funcTypes) {
+				d.fail(at, "%d function bodies for %d functions", n, len(funcTypes))
+			}
+			for _, typ := range funcTypes {
+				m.Funcs = append(m.Funcs, d.funcBody(typ))
+			}
+		case secData:
+			for n := d.u32(); n > 0; n-- {
+				if at, flags := d.pos, d.u32(); flags != 0 {
+					d.fail(at, "unsupported data segment kind %d", flags)
+				}
+				data := &Data{Offset: d.offset()}
+				data.Bytes = append([]byte(nil), d.take(int(d.u32()))...)
+				m.Data = append(m.Data, data)
+			}
+		case secDataCnt:
+			d.u32()
+		}
+		if d.pos != end {
+			d.fail(start, "section %d has size %d, but its contents have size %d", id, size, d.pos-(end-size))
+		}
+	}
+	if len(funcTypes) > 0 && m.Funcs == nil {
+		d.fail(d.pos, "%d functions have no bodies", len(funcTypes))
+	}
+	return m, nil
+}
+
+// sectionOrder ranks the sections in the order they must appear, which
+// places the data count section before the code section.
+var sectionOrder = [...]int{
+	secType: 1, secImport: 2, secFunction: 3, secTable: 4, secMemory: 5,
+	secGlobal: 6, secExport: 7, secStart: 8, secElement: 9, secDataCnt: 10,
+	secCode: 11, secData: 12,
+}
+
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+type decodeBailout struct {
+	err error
+}
+
+func (d *decoder) fail(at int, format string, args ...any) {
+	panic(decodeBailout{errorf("offset 0x%x: %s", at, fmt.Sprintf(format, args...))})
+}
+
+func (d *decoder) take(n int) []byte {
+	if n < 0 || d.pos+n > len(d.buf) {
+		d.fail(d.pos, "unexpected end")
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) byte() byte { return d.take(1)[0] }
+
+// u32 reads an unsigned LEB128 number of at most 32 bits.
+func (d *decoder) u32() uint32 {
+	at := d.pos
+	var v uint64
+	for shift := 0; ; shift += 7 {
+		b := d.byte()
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift >= 28 {
+			d.fail(at, "integer too long")
+		}
+	}
+	if v > math.MaxUint32 {
+		d.fail(at, "integer too large")
+	}
+	return uint32(v)
+}
+
+// s64 reads a signed LEB128 number of at most bits bits.
+func (d *decoder) s64(bits int) int64 {
+	at := d.pos
+	var v int64
+	shift := 0
+	for {
+		b := d.byte()
+		v |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			break
+		}
+		if shift >= bits {
+			d.fail(at, "integer too long")
+		}
+	}
+	if bits == 32 && (v < math.MinInt32 || v > math.MaxInt32) {
+		d.fail(at, "integer too large")
+	}
+	return v
+}
+
+func (d *decoder) name() string {
+	at := d.pos
+	b := d.take(int(d.u32()))
+	if !utf8.Valid(b) {
+		d.fail(at, "malformed UTF-8 name")
+	}
+	return string(b)
+}
+
+func (d *decoder) valType() ValType {
+	at := d.pos
+	switch t := ValType(d.byte()); t {
+	case I32, I64, F32, F64:
+		return t
+	}
+	d.fail(at, "malformed value type")
+	return 0
+}
+
+func (d *decoder) valTypes() []ValType {
+	var ts []ValType
+	for n := d.u32(); n > 0; n-- {
+		ts = append(ts, d.valType())
+	}
+	return ts
+}
+
+func (d *decoder) limits() (min, max uint32, hasMax bool) {
+	switch at := d.pos; d.byte() {
+	case 0:
+		return d.u32(), 0, false
+	case 1:
+		return d.u32(), d.u32(), true
+	default:
+		d.fail(at, "malformed limits")
+	}
+	return 0, 0, false
+}
+
+// constExpr reads a constant expression of a single instruction.
+func (d *decoder) constExpr() Instr {
+	at := d.pos
+	instr := d.instr()
+	switch instr.Op {
+	case OpI32Const, OpI64Const, OpF32Const, OpF64Const, OpGlobalGet:
+	default:
+		d.fail(at, "unsupported constant expression")
+	}
+	if at := d.pos; Op(d.byte()) != OpEnd {
+		d.fail(at, "unsupported constant expression")
+	}
+	return instr
+}
+
+// offset reads the offset of a segment, which must be a constant.
+func (d *decoder) offset() uint32 {
+	at := d.pos
+	instr := d.constExpr()
+	if instr.Op != OpI32Const {
+		d.fail(at, "unsupported segment offset")
+	}
+	return uint32(instr.Imm)
+}
+
+func (d *decoder) funcBody(typ uint32) *Func {
+	size := int(d.u32())
+	end := d.pos + size
+	f := &Func{Type: typ}
+	total := 0
+	for n := d.u32(); n > 0; n-- {
+		at := d.pos
+		count := int(d.u32())
+		t := d.valType()
+		if total += count; total > 50000 {
+			d.fail(at, "too many locals")
+		}
+		for ; count > 0; count-- {
+			f.Locals = append(f.Locals, t)
+		}
+	}
+	depth := 0
+	for {
+		at := d.pos
+		if at >= end {
+			d.fail(at, "function body runs past its size")
+		}
+		instr := d.instr()
+		switch instr.Op {
+		case OpBlock, OpLoop, OpIf:
+			depth++
+		case OpEnd:
+			if depth == 0 {
+				if d.pos != end {
+					d.fail(d.pos, "function body ends before its size")
+				}
+				return f
+			}
+			depth--
+		}
+		f.Body = append(f.Body, instr)
+	}
+}
+
+func (d *decoder) instr() Instr {
+	at := d.pos
+	op := Op(d.byte())
+	if op == prefixFC {
+		op = Op(prefixFC)<<8 | Op(d.u32()&0xff)
+	}
+	info := opInfos[op]
+	if info == nil {
+		d.fail(at, "unknown opcode 0x%x", uint16(op))
+	}
+	instr := Instr{Op: op}
+	switch info.imm {
+	case immBlock:
+		bt := d.pos
+		switch b := d.byte(); b {
+		case blockEmpty:
+		case byte(I32), byte(I64), byte(F32), byte(F64):
+			instr.Imm = int64(b)
+		default:
+			d.fail(bt, "unsupported block type")
+		}
+	case immLabel, immFunc, immLocal, immGlobal:
+		instr.Imm = int64(d.u32())
+	case immCallIndirect:
+		instr.Imm = int64(d.u32())
+		if d.byte() != 0 {
+			d.fail(at, "unsupported table index")
+		}
+	case immMem:
+		instr.Align = d.u32()
+		instr.Imm = int64(d.u32())
+	case immMemIdx:
+		if d.byte() != 0 {
+			d.fail(at, "unsupported memory index")
+		}
+	case immMemCopy:
+		if d.byte() != 0 || d.byte() != 0 {
+			d.fail(at, "unsupported memory index")
+		}
+	case immI32:
+		instr.Imm = d.s64(32)
+	case immI64:
+		instr.Imm = d.s64(64)
+	case immF32:
+		instr.F = float64(math.Float32frombits(binary.LittleEndian.Uint32(d.take(4))))
+	case immF64:
+		instr.F = math.Float64frombits(binary.LittleEndian.Uint64(d.take(8)))
+	}
+	return instr
+}
@@ -0,0 +1,265 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// magic and version begin every module.
+var (
+	magic   = []byte{0x00, 'a', 's', 'm'}
+	version = []byte{0x01, 0x00, 0x00, 0x00}
+)
+
+// The section identifiers.
+const (
+	secCustom   = 0
+	secType     = 1
+	secImport   = 2
+	secFunction = 3
+	secTable    = 4
+	secMemory   = 5
+	secGlobal   = 6
+	secExport   = 7
+	secStart    = 8
+	secElement  = 9
+	secCode     = 10
+	secData     = 11
+	secDataCnt  = 12
+)
+
+const (
+	funcTypeForm = 0x60
+	blockEmpty   = 0x40
+	prefixFC     = 0xfc
+)
+
+// Encode returns the binary format of m. It does not validate m.
+func Encode(m *Module) []byte {
+	var e encoder
+	e.bytes(magic)
+	e.bytes(version)
+	if len(m.Types) > 0 {
+		e.section(secType, func(e *encoder) {
+			e.u32(uint32(len(m.Types)))
+			for _, t := range m.Types {
+				e.byte(funcTypeForm)
+				e.valTypes(t.Params)
+				e.valTypes(t.Results)
+			}
+		})
+	}
+	if len(m.Imports) > 0 {
+		e.section(secImport, func(e *encoder) {
+			e.u32(uint32(len(m.Imports)))
+			for _, imp := range m.Imports {
+				e.name(imp.Module)
+				e.name(imp.Name)
+				e.byte(byte(ExternFunc))
+				e.u32(imp.Type)
+			}
+		})
+	}
+	if len(m.Funcs) > 0 {
+		e.section(secFunction, func(e *encoder) {
+			e.u32(uint32(len(m.Funcs)))
+			for _, f := range m.Funcs {
+				e.u32(f.Type)
+			}
+		})
+	}
+	if m.Table != nil {
+		e.section(secTable, func(e *encoder) {
+			e.u32(1)
+			e.byte(byte(FuncRef))
+			e.byte(0)
+			e.u32(m.Table.Min)
+		})
+	}
+	if m.Memory != nil {
+		e.section(secMemory, func(e *encoder) {
+			e.u32(1)
+			if m.Memory.HasMax {
+				e.byte(1)
+				e.u32(m.Memory.Min)
+				e.u32(m.Memory.Max)
+			} else {
+				e.byte(0)
+				e.u32(m.Memory.Min)
+			}
+		})
+	}
+	if len(m.Globals) > 0 {
+		e.section(secGlobal, func(e *encoder) {
+			e.u32(uint32(len(m.Globals)))
+			for _, g := range m.Globals {
+				e.byte(byte(g.Type))
+				if g.Mutable {
+					e.byte(1)
+				} else {
+					e.byte(0)
+				}
+				e.instr(g.Init)
+				e.byte(byte(OpEnd))
+			}
+		})
+	}
+	if len(m.Exports) > 0 {
+		e.section(secExport, func(e *encoder) {
+			e.u32(uint32(len(m.Exports)))
+			for _, exp := range m.Exports {
+				e.name(exp.Name)
+				e.byte(byte(exp.Kind))
+				e.u32(exp.Index)
+			}
+		})
+	}
+	if len(m.Elems) > 0 {
+		e.section(secElement, func(e *encoder) {
+			e.u32(uint32(len(m.Elems)))
+			for _, el := range m.Elems {
+				e.u32(0)
+				e.offset(el.Offset)
+				e.u32(uint32(len(el.Funcs)))
+				for _, f := range el.Funcs {
+					e.u32(f)
+				}
+			}
+		})
+	}
+	if len(m.Funcs) > 0 {
+		e.section(secCode, func(e *encoder) {
+			e.u32(uint32(len(m.Funcs)))
+			for _, f := range m.Funcs {
+				var body encoder
+				body.locals(f.Locals)
+				for _, instr := range f.Body {
+					body.instr(instr)
+				}
+				body.byte(byte(OpEnd))
+				e.u32(uint32(len(body.buf)))
+				e.bytes(body.buf)
+			}
+		})
+	}
+	if len(m.Data) > 0 {
+		e.section(secData, func(e *encoder) {
+			e.u32(uint32(len(m.Data)))
+			for _, d := range m.Data {
+				e.u32(0)
+				e.offset(d.Offset)
+				e.u32(uint32(len(d.Bytes)))
+				e.bytes(d.Bytes)
+			}
+		})
+	}
+	return e.buf
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) byte(b byte)    { e.buf = append(e.buf, b) }
+func (e *encoder) bytes(b []byte) { e.buf = append(e.buf, b...) }
+func (e *encoder) u32(v uint32)   { e.buf = binary.AppendUvarint(e.buf, uint64(v)) }
+
+func (e *encoder) name(s string) {
+	e.u32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// offset appends the constant expression of the offset of a segment.
+func (e *encoder) offset(off uint32) {
+	e.instr(I(OpI32Const, int64(int32(off))))
+	e.byte(byte(OpEnd))
+}
+
+func (e *encoder) valTypes(ts []ValType) {
+	e.u32(uint32(len(ts)))
+	for _, t := range ts {
+		e.byte(byte(t))
+	}
+}
+
+// s64 appends v in the signed LEB128 encoding.
+func (e *encoder) s64(v int64) {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && b&0x40 == 0 || v == -1 && b&0x40 != 0 {
+			e.byte(b)
+			return
+		}
+		e.byte(b | 0x80)
+	}
+}
+
+// section appends the section id with the contents body writes.
+func (e *encoder) section(id byte, body func(*encoder)) {
+	var s encoder
+	body(&s)
+	e.byte(id)
+	e.u32(uint32(len(s.buf)))
+	e.bytes(s.buf)
+}
+
+// locals appends the locals of a function, as runs of one type.
+func (e *encoder) locals(locals []ValType) {
+	var runs encoder
+	n := 0
+	for i := 0; i < len(locals); {
+		j := i
+		for j < len(locals) && locals[j] == locals[i] {
+			j++
+		}
+		runs.u32(uint32(j - i))
+		runs.byte(byte(locals[i]))
+		n++
+		i = j
+	}
+	e.u32(uint32(n))
+	e.bytes(runs.buf)
+}
+
+func (e *encoder) instr(instr Instr) {
+	if instr.Op > 0xff {
+		e.byte(prefixFC)
+		e.u32(uint32(instr.Op & 0xff))
+	} else {
+		e.byte(byte(instr.Op))
+	}
+	info := opInfos[instr.Op]
+	if info == nil {
+		return
+	}
+	switch info.imm {
+	case immBlock:
+		if instr.Imm == 0 {
+			e.byte(blockEmpty)
+		} else {
+			e.byte(byte(instr.Imm))
+		}
+	case immLabel, immFunc, immLocal, immGlobal:
+		e.u32(uint32(instr.Imm))
+	case immCallIndirect:
+		e.u32(uint32(instr.Imm))
+		e.byte(0)
+	case immMem:
+		e.u32(instr.Align)
+		e.u32(uint32(instr.Imm))
+	case immMemIdx:
+		e.byte(0)
+	case immMemCopy:
+		e.byte(0)
+		e.byte(0)
+	case immI32:
+		e.s64(int64(int32(instr.Imm)))
+	case immI64:
+		e.s64(instr.Imm)
+	case immF32:
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, math.Float32bits(float32(instr.F)))
+	case immF64:
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(instr.F))
+	}
+}
@@ -0,0 +1,406 @@
+package wasm
+
+// Op is an opcode. The opcodes that follow the 0xfc prefix are 0xfc00 plus
+// their index.
+type Op uint16
+
+// The control, parametric, variable and memory instructions.
+const (
+	OpUnreachable  Op = 0x00
+	OpNop          Op = 0x01
+	OpBlock        Op = 0x02
+	OpLoop         Op = 0x03
+	OpIf           Op = 0x04
+	OpElse         Op = 0x05
+	OpEnd          Op = 0x0b
+	OpBr           Op = 0x0c
+	OpBrIf         Op = 0x0d
+	OpReturn       Op = 0x0f
+	OpCall         Op = 0x10
+	OpCallIndirect Op = 0x11
+	OpDrop         Op = 0x1a
+	OpSelect       Op = 0x1b
+	OpLocalGet     Op = 0x20
+	OpLocalSet     Op = 0x21
+	OpLocalTee     Op = 0x22
+	OpGlobalGet    Op = 0x23
+	OpGlobalSet    Op = 0x24
+	OpMemorySize   Op = 0x3f
+	OpMemoryGrow   Op = 0x40
+	OpMemoryCopy   Op = 0xfc0a
+	OpMemoryFill   Op = 0xfc0b
+)
+
+// The loads and stores.
+const (
+	OpI32Load    Op = 0x28
+	OpI64Load    Op = 0x29
+	OpF32Load    Op = 0x2a
+	OpF64Load    Op = 0x2b
+	OpI32Load8S  Op = 0x2c
+	OpI32Load8U  Op = 0x2d
+	OpI32Load16S Op = 0x2e
+	OpI32Load16U Op = 0x2f
+	OpI64Load8S  Op = 0x30
+	OpI64Load8U  Op = 0x31
+	OpI64Load16S Op = 0x32
+	OpI64Load16U Op = 0x33
+	OpI64Load32S Op = 0x34
+	OpI64Load32U Op = 0x35
+	OpI32Store   Op = 0x36
+	OpI64Store   Op = 0x37
+	OpF32Store   Op = 0x38
+	OpF64Store   Op = 0x39
+	OpI32Store8  Op = 0x3a
+	OpI32Store16 Op = 0x3b
+	OpI64Store8  Op = 0x3c
+	OpI64Store16 Op = 0x3d
+	OpI64Store32 Op = 0x3e
+)
+
+// The numeric instructions.
+const (
+	OpI32Const Op = 0x41
+	OpI64Const Op = 0x42
+	OpF32Const Op = 0x43
+	OpF64Const Op = 0x44
+
+	OpI32Eqz Op = 0x45
+	OpI32Eq  Op = 0x46
+	OpI32Ne  Op = 0x47
+	OpI32LtS Op = 0x48
+	OpI32LtU Op = 0x49
+	OpI32GtS Op = 0x4a
+	OpI32GtU Op = 0x4b
+	OpI32LeS Op = 0x4c
+	OpI32LeU Op = 0x4d
+	OpI32GeS Op = 0x4e
+	OpI32GeU Op = 0x4f
+
+	OpI64Eqz Op = 0x50
+	OpI64Eq  Op = 0x51
+	OpI64Ne  Op = 0x52
+	OpI64LtS Op = 0x53
+	OpI64LtU Op = 0x54
+	OpI64GtS Op = 0x55
+	OpI64GtU Op = 0x56
+	OpI64LeS Op = 0x57
+	OpI64LeU Op = 0x58
+	OpI64GeS Op = 0x59
+	OpI64GeU Op = 0x5a
+
+	OpF32Eq Op = 0x5b
+	OpF32Ne Op = 0x5c
+	OpF32Lt Op = 0x5d
+	OpF32Gt Op = 0x5e
+	OpF32Le Op = 0x5f
+	OpF32Ge Op = 0x60
+
+	OpF64Eq Op = 0x61
+	OpF64Ne Op = 0x62
+	OpF64Lt Op = 0x63
+	OpF64Gt Op = 0x64
+	OpF64Le Op = 0x65
+	OpF64Ge Op = 0x66
+
+	OpI32Clz    Op = 0x67
+	OpI32Ctz    Op = 0x68
+	OpI32Popcnt Op = 0x69
+	OpI32Add    Op = 0x6a
+	OpI32Sub    Op = 0x6b
+	OpI32Mul    Op = 0x6c
+	OpI32DivS   Op = 0x6d
+	OpI32DivU   Op = 0x6e
+	OpI32RemS   Op = 0x6f
+	OpI32RemU   Op = 0x70
+	OpI32And    Op = 0x71
+	OpI32Or     Op = 0x72
+	OpI32Xor    Op = 0x73
+	OpI32Shl    Op = 0x74
+	OpI32ShrS   Op = 0x75
+	OpI32ShrU   Op = 0x76
+	OpI32Rotl   Op = 0x77
+	OpI32Rotr   Op = 0x78
+
+	OpI64Clz    Op = 0x79
+	OpI64Ctz    Op = 0x7a
+	OpI64Popcnt Op = 0x7b
+	OpI64Add    Op = 0x7c
+	OpI64Sub    Op = 0x7d
+	OpI64Mul    Op = 0x7e
+	OpI64DivS   Op = 0x7f
+	OpI64DivU   Op = 0x80
+	OpI64RemS   Op = 0x81
+	OpI64RemU   Op = 0x82
+	OpI64And    Op = 0x83
+	OpI64Or     Op = 0x84
+	OpI64Xor    Op = 0x85
+	OpI64Shl    Op = 0x86
+	OpI64ShrS   Op = 0x87
+	OpI64ShrU   Op = 0x88
+	OpI64Rotl   Op = 0x89
+	OpI64Rotr   Op = 0x8a
+
+	OpF32Abs      Op = 0x8b
+	OpF32Neg      Op = 0x8c
+	OpF32Ceil     Op = 0x8d
+	OpF32Floor    Op = 0x8e
+	OpF32Trunc    Op = 0x8f
+	OpF32Nearest  Op = 0x90
+	OpF32Sqrt     Op = 0x91
+	OpF32Add      Op = 0x92
+	OpF32Sub      Op = 0x93
+	OpF32Mul      Op = 0x94
+	OpF32Div      Op = 0x95
+	OpF32Min      Op = 0x96
+	OpF32Max      Op = 0x97
+	OpF32Copysign Op = 0x98
+
+	OpF64Abs      Op = 0x99
+	OpF64Neg      Op = 0x9a
+	OpF64Ceil     Op = 0x9b
+	OpF64Floor    Op = 0x9c
+	OpF64Trunc    Op = 0x9d
+	OpF64Nearest  Op = 0x9e
+	OpF64Sqrt     Op = 0x9f
+	OpF64Add      Op = 0xa0
+	OpF64Sub      Op = 0xa1
+	OpF64Mul      Op = 0xa2
+	OpF64Div      Op = 0xa3
+	OpF64Min      Op = 0xa4
+	OpF64Max      Op = 0xa5
+	OpF64Copysign Op = 0xa6
+
+	OpI32WrapI64        Op = 0xa7
+	OpI32TruncF32S      Op = 0xa8
+	OpI32TruncF32U      Op = 0xa9
+	OpI32TruncF64S      Op = 0xaa
+	OpI32TruncF64U      Op = 0xab
+	OpI64ExtendI32S     Op = 0xac
+	OpI64ExtendI32U     Op = 0xad
+	OpI64TruncF32S      Op = 0xae
+	OpI64TruncF32U      Op = 0xaf
+	OpI64TruncF64S      Op = 0xb0
+	OpI64TruncF64U      Op = 0xb1
+	OpF32ConvertI32S    Op = 0xb2
+	OpF32ConvertI32U    Op = 0xb3
+	OpF32ConvertI64S    Op = 0xb4
+	OpF32ConvertI64U    Op = 0xb5
+	OpF32DemoteF64      Op = 0xb6
+	OpF64ConvertI32S    Op = 0xb7
+	OpF64ConvertI32U    Op = 0xb8
+	OpF64ConvertI64S    Op = 0xb9
+	OpF64ConvertI64U    Op = 0xba
+	OpF64PromoteF32     Op = 0xbb
+	OpI32ReinterpretF32 Op = 0xbc
+	OpI64ReinterpretF64 Op = 0xbd
+	OpF32ReinterpretI32 Op = 0xbe
+	OpF64ReinterpretI64 Op = 0xbf
+
+	OpI32Extend8S  Op = 0xc0
+	OpI32Extend16S Op = 0xc1
+	OpI64Extend8S  Op = 0xc2
+	OpI64Extend16S Op = 0xc3
+	OpI64Extend32S Op = 0xc4
+)
+
+// immKind is the kind of immediate an instruction takes.
+type immKind int
+
+const (
+	immNone         immKind = iota
+	immBlock                // a block type
+	immLabel                // a label depth
+	immFunc                 // a function index
+	immCallIndirect         // a type index and table 0
+	immLocal                // a local index
+	immGlobal               // a global index
+	immMem                  // an alignment and an offset
+	immMemIdx               // memory 0
+	immMemCopy              // memory 0 twice
+	immI32
+	immI64
+	immF32
+	immF64
+)
+
+// opInfo describes an opcode. The instructions whose typing is fixed give
+// their operand and result types; the others are typed by the validator.
+type opInfo struct {
+	name    string
+	imm     immKind
+	in, out []ValType
+	size    uint32 // of the memory a load or store accesses
+}
+
+// natural returns the natural alignment of a memory access, as a power of
+// two.
+func (info *opInfo) natural() uint32 {
+	var a uint32
+	for 1<<a < info.size {
+		a++
+	}
+	return a
+}
+
+var (
+	opInfos = map[Op]*opInfo{}
+	opNames = map[string]Op{}
+)
+
+func def(op Op, name string, imm immKind, in, out []ValType) *opInfo {
+	info := &opInfo{name: name, imm: imm, in: in, out: out}
+	opInfos[op] = info
+	opNames[name] = op
+	return info
+}
+
+func types(ts ...ValType) []ValType { return ts }
+
+func init() {
+	def(OpUnreachable, "unreachable", immNone, nil, nil)
+	def(OpNop, "nop", immNone, nil, nil)
+	def(OpBlock, "block", immBlock, nil, nil)
+	def(OpLoop, "loop", immBlock, nil, nil)
+	def(OpIf, "if", immBlock, nil, nil)
+	def(OpElse, "else", immNone, nil, nil)
+	def(OpEnd, "end", immNone, nil, nil)
+	def(OpBr, "br", immLabel, nil, nil)
+	def(OpBrIf, "br_if", immLabel, nil, nil)
+	def(OpReturn, "return", immNone, nil, nil)
+	def(OpCall, "call", immFunc, nil, nil)
+	def(OpCallIndirect, "call_indirect", immCallIndirect, nil, nil)
+	def(OpDrop, "drop", immNone, nil, nil)
+	def(OpSelect, "select", immNone, nil, nil)
+	def(OpLocalGet, "local.get", immLocal, nil, nil)
+	def(OpLocalSet, "local.set", immLocal, nil, nil)
+	def(OpLocalTee, "local.tee", immLocal, nil, nil)
+	def(OpGlobalGet, "global.get", immGlobal, nil, nil)
+	def(OpGlobalSet, "global.set", immGlobal, nil, nil)
+	def(OpMemorySize, "memory.size", immMemIdx, nil, types(I32))
+	def(OpMemoryGrow, "memory.grow", immMemIdx, types(I32), types(I32))
+	def(OpMemoryCopy, "memory.copy", immMemCopy, types(I32, I32, I32), nil)
+	def(OpMemoryFill, "memory.fill", immMemIdx, types(I32, I32, I32), nil)
+
+	for _, m := range []struct {
+		op   Op
+		name string
+		t    ValType
+		size uint32
+	}{
+		{OpI32Load, "i32.load", I32, 4},
+		{OpI64Load, "i64.load", I64, 8},
+		{OpF32Load, "f32.load", F32, 4},
+		{OpF64Load, "f64.load", F64, 8},
+		{OpI32Load8S, "i32.load8_s", I32, 1},
+		{OpI32Load8U, "i32.load8_u", I32, 1},
+		{OpI32Load16S, "i32.load16_s", I32, 2},
+		{OpI32Load16U, "i32.load16_u", I32, 2},
+		{OpI64Load8S, "i64.load8_s", I64, 1},
+		{OpI64Load8U, "i64.load8_u", I64, 1},
+		{OpI64Load16S, "i64.load16_s", I64, 2},
+		{OpI64Load16U, "i64.load16_u", I64, 2},
+		{OpI64Load32S, "i64.load32_s", I64, 4},
+		{OpI64Load32U, "i64.load32_u", I64, 4},
+	} {
+		def(m.op, m.name, immMem, types(I32), types(m.t)).size = m.size
+	}
+	for _, m := range []struct {
+		op   Op
+		name string
+		t    ValType
+		size uint32
+	}{
+		{OpI32Store, "i32.store", I32, 4},
+		{OpI64Store, "i64.store", I64, 8},
+		{OpF32Store, "f32.store", F32, 4},
+		{OpF64Store, "f64.store", F64, 8},
+		{OpI32Store8, "i32.store8", I32, 1},
+		{OpI32Store16, "i32.store16", I32, 2},
+		{OpI64Store8, "i64.store8", I64, 1},
+		{OpI64Store16, "i64.store16", I64, 2},
+		{OpI64Store32, "i64.store32", I64, 4},
+	} {
+		def(m.op, m.name, immMem, types(I32, m.t), nil).size = m.size
+	}
+
+	def(OpI32Const, "i32.const", immI32, nil, types(I32))
+	def(OpI64Const, "i64.const", immI64, nil, types(I64))
+	def(OpF32Const, "f32.const", immF32, nil, types(F32))
+	def(OpF64Const, "f64.const", immF64, nil, types(F64))
+
+	intCompare := []string{"eq", "ne", "lt_s", "lt_u", "gt_s", "gt_u", "le_s", "le_u", "ge_s", "ge_u"}
+	floatCompare := []string{"eq", "ne", "lt", "gt", "le", "ge"}
+	intUnary := []string{"clz", "ctz", "popcnt"}
+	intBinary := []string{"add", "sub", "mul", "div_s", "div_u", "rem_s", "rem_u", "and", "or", "xor", "shl", "shr_s", "shr_u", "rotl", "rotr"}
+	floatUnary := []string{"abs", "neg", "ceil", "floor", "trunc", "nearest", "sqrt"}
+	floatBinary := []string{"add", "sub", "mul", "div", "min", "max", "copysign"}
+	family := func(op Op, t ValType, names []string, in, out []ValType) Op {
+		for _, name := range names {
+			def(op, t.String()+"."+name, immNone, in, out)
+			op++
+		}
+		return op
+	}
+	def(OpI32Eqz, "i32.eqz", immNone, types(I32), types(I32))
+	family(OpI32Eq, I32, intCompare, types(I32, I32), types(I32))
+	def(OpI64Eqz, "i64.eqz", immNone, types(I64), types(I32))
+	family(OpI64Eq, I64, intCompare, types(I64, I64), types(I32))
+	family(OpF32Eq, F32, floatCompare, types(F32, F32), types(I32))
+	family(OpF64Eq, F64, floatCompare, types(F64, F64), types(I32))
+	for _, t := range []ValType{I32, I64} {
+		op := OpI32Clz
+		if t == I64 {
+			op = OpI64Clz
+		}
+		op = family(op, t, intUnary, types(t), types(t))
+		family(op, t, intBinary, types(t, t), types(t))
+	}
+	for _, t := range []ValType{F32, F64} {
+		op := OpF32Abs
+		if t == F64 {
+			op = OpF64Abs
+		}
+		op = family(op, t, floatUnary, types(t), types(t))
+		family(op, t, floatBinary, types(t, t), types(t))
+	}
+
+	for _, c := range []struct {
+		op      Op
+		name    string
+		in, out ValType
+	}{
+		{OpI32WrapI64, "i32.wrap_i64", I64, I32},
+		{OpI32TruncF32S, "i32.trunc_f32_s", F32, I32},
+		{OpI32TruncF32U, "i32.trunc_f32_u", F32, I32},
+		{OpI32TruncF64S, "i32.trunc_f64_s", F64, I32},
+		{OpI32TruncF64U, "i32.trunc_f64_u", F64, I32},
+		{OpI64ExtendI32S, "i64.extend_i32_s", I32, I64},
+		{OpI64ExtendI32U, "i64.extend_i32_u", I32, I64},
+		{OpI64TruncF32S, "i64.trunc_f32_s", F32, I64},
+		{OpI64TruncF32U, "i64.trunc_f32_u", F32, I64},
+		{OpI64TruncF64S, "i64.trunc_f64_s", F64, I64},
+		{OpI64TruncF64U, "i64.trunc_f64_u", F64, I64},
+		{OpF32ConvertI32S, "f32.convert_i32_s", I32, F32},
+		{OpF32ConvertI32U, "f32.convert_i32_u", I32, F32},
+		{OpF32ConvertI64S, "f32.convert_i64_s", I64, F32},
+		{OpF32ConvertI64U, "f32.convert_i64_u", I64, F32},
+		{OpF32DemoteF64, "f32.demote_f64", F64, F32},
+		{OpF64ConvertI32S, "f64.convert_i32_s", I32, F64},
+		{OpF64ConvertI32U, "f64.convert_i32_u", I32, F64},
+		{OpF64ConvertI64S, "f64.convert_i64_s", I64, F64},
+		{OpF64ConvertI64U, "f64.convert_i64_u", I64, F64},
+		{OpF64PromoteF32, "f64.promote_f32", F32, F64},
+		{OpI32ReinterpretF32, "i32.reinterpret_f32", F32, I32},
+		{OpI64ReinterpretF64, "i64.reinterpret_f64", F64, I64},
+		{OpF32ReinterpretI32, "f32.reinterpret_i32", I32, F32},
+		{OpF64ReinterpretI64, "f64.reinterpret_i64", I64, F64},
+		{OpI32Extend8S, "i32.extend8_s", I32, I32},
+		{OpI32Extend16S, "i32.extend16_s", I32, I32},
+		{OpI64Extend8S, "i64.extend8_s", I64, I64},
+		{OpI64Extend16S, "i64.extend16_s", I64, I64},
+		{OpI64Extend32S, "i64.extend32_s", I64, I64},
+	} {
+		def(c.op, c.name, immNone, types(c.in), types(c.out))
+	}
+}
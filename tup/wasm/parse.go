@@ -0,0 +1,780 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseText reads a module in the text format that Text prints: the
+// definitions of a module, with function bodies written flat, labels and
+// definitions referred to by ID or index, and ;; and (; ;) comments. A
+// function may name its type with (type), give its parameters and results,
+// or both. Folded instructions and abbreviations other than inline exports
+// of functions are not supported.
+func ParseText(src string) (m *Module, err error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{
+		toks:      toks,
+		m:         &Module{},
+		typeIDs:   map[string]uint32{},
+		funcIDs:   map[string]uint32{},
+		globalIDs: map[string]uint32{},
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			bail, ok := r.(parseBailout)
+			if !ok {
+				panic(r)
+			}
+			m, err = nil, bail.err
+		}
+	}()
+	p.module()
+	return p.m, nil
+}
+
+type tokKind int
+
+const (
+	tokLParen tokKind = iota
+	tokRParen
+	tokAtom
+	tokString
+	tokEOF
+)
+
+type token struct {
+	kind tokKind
+	text string // of atoms, or the value of strings
+	line int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokLParen:
+		return "("
+	case tokRParen:
+		return ")"
+	case tokString:
+		return strconv.Quote(t.text)
+	case tokEOF:
+		return "end of input"
+	}
+	return t.text
+}
+
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], ";;"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "(;"):
+			depth := 0
+			start := line
+			for {
+				if i >= len(src) {
+					return nil, errorf("line %d: unterminated block comment", start)
+				}
+				switch {
+				case strings.HasPrefix(src[i:], "(;"):
+					depth++
+					i += 2
+				case strings.HasPrefix(src[i:], ";)"):
+					depth--
+					i += 2
+				default:
+					if src[i] == '\n' {
+						line++
+					}
+					i++
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		case c == '(':
+			toks = append(toks, token{kind: tokLParen, line: line})
+			i++
+		case c == ')':
+			toks = append(toks, token{kind: tokRParen, line: line})
+			i++
+		case c == '"':
+			s, n, err := unquote(src[i:])
+			if err != nil {
+				return nil, errorf("line %d: %s", line, err)
+			}
+			toks = append(toks, token{kind: tokString, text: s, line: line})
+			i += n
+		default:
+			j := i
+			for j < len(src) && !strings.ContainsRune(" \t\r\n()\";", rune(src[j])) {
+				j++
+			}
+			if j == i {
+				return nil, errorf("line %d: unexpected %q", line, c)
+			}
+			toks = append(toks, token{kind: tokAtom, text: src[i:j], line: line})
+			i = j
+		}
+	}
+	return append(toks, token{kind: tokEOF, line: line}), nil
+}
+
+// unquote reads the string at the start of s, returning its value and its
+// length in s.
+func unquote(s string) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(s); {
+		switch c := s[i]; c {
+		case '"':
+			return sb.String(), i + 1, nil
+		case '\n':
+			return "", 0, fmt.Errorf("unterminated string")
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			switch e := s[i+1]; e {
+			case 't':
+				sb.WriteByte('\t')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case '"', '\'', '\\':
+				sb.WriteByte(e)
+			case 'u':
+				end := strings.IndexByte(s[i:], '}')
+				if !strings.HasPrefix(s[i+2:], "{") || end < 0 {
+					return "", 0, fmt.Errorf("malformed escape")
+				}
+				r, err := strconv.ParseUint(s[i+3:i+end], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", 0, fmt.Errorf("malformed escape")
+				}
+				sb.WriteRune(rune(r))
+				i += end + 1
+				continue
+			default:
+				if i+2 >= len(s) {
+					return "", 0, fmt.Errorf("malformed escape")
+				}
+				b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+				if err != nil {
+					return "", 0, fmt.Errorf("malformed escape")
+				}
+				sb.WriteByte(byte(b))
+				i += 3
+				continue
+			}
+			i += 2
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+type parser struct {
+	toks []token
+	pos  int
+	m    *Module
+
+	typeIDs, funcIDs, globalIDs map[string]uint32
+	localIDs                    map[string]uint32
+	labels                      []string // innermost last
+}
+
+type parseBailout struct {
+	err error
+}
+
+func (p *parser) fail(format string, args ...any) {
+	panic(parseBailout{errorf("line %d: %s", p.peek().line, fmt.Sprintf(format, args...))})
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokKind, what string) token {
+	if p.peek().kind != kind {
+		p.fail("expected %s, found %s", what, p.peek())
+	}
+	return p.next()
+}
+
+func (p *parser) lparen() { p.expect(tokLParen, "(") }
+func (p *parser) rparen() { p.expect(tokRParen, ")") }
+
+// keyword consumes the atom kw.
+func (p *parser) keyword(kw string) {
+	if t := p.peek(); t.kind != tokAtom || t.text != kw {
+		p.fail("expected %s, found %s", kw, t)
+	}
+	p.next()
+}
+
+// isField reports whether the next tokens open a field with the keyword kw.
+func (p *parser) isField(kw string) bool {
+	return p.peek().kind == tokLParen && p.toks[p.pos+1].kind == tokAtom && p.toks[p.pos+1].text == kw
+}
+
+// id consumes and returns an ID, without its $, if one is next.
+func (p *parser) id() string {
+	if t := p.peek(); t.kind == tokAtom && strings.HasPrefix(t.text, "$") {
+		p.next()
+		return t.text[1:]
+	}
+	return ""
+}
+
+// skip skips the rest of the field whose ( has been consumed.
+func (p *parser) skip() {
+	for depth := 1; depth > 0; {
+		switch p.next().kind {
+		case tokLParen:
+			depth++
+		case tokRParen:
+			depth--
+		case tokEOF:
+			p.fail("unexpected end of input")
+		}
+	}
+}
+
+type field struct {
+	kw  string
+	pos int // of the token after the keyword
+	id  string
+}
+
+func (p *parser) module() {
+	p.lparen()
+	p.keyword("module")
+	p.id()
+	// Find the fields, and number the types, functions and globals, so that
+	// they can be referred to before they are defined.
+	var fields []field
+	for p.peek().kind == tokLParen {
+		p.next()
+		kw := p.expect(tokAtom, "a field").text
+		f := field{kw: kw, pos: p.pos, id: p.id()}
+		if kw == "import" {
+			p.expect(tokString, "a module name")
+			p.expect(tokString, "a name")
+			p.lparen()
+			p.keyword("func")
+			f.id = p.id()
+		}
+		fields = append(fields, f)
+		p.pos = f.pos
+		p.skip()
+	}
+	p.rparen()
+	p.expect(tokEOF, "end of input")
+	numbered := func(kw string, ids map[string]uint32) {
+		n := uint32(0)
+		for _, f := range fields {
+			if f.kw == kw {
+				if f.id != "" {
+					if _, ok := ids[f.id]; ok {
+						p.pos = f.pos
+						p.fail("duplicate %s $%s", kw, f.id)
+					}
+					ids[f.id] = n
+				}
+				n++
+			}
+		}
+	}
+	numbered("type", p.typeIDs)
+	numbered("global", p.globalIDs)
+	// Imports are numbered before the functions the module defines.
+	var imports, funcs []field
+	for _, f := range fields {
+		switch f.kw {
+		case "import":
+			imports = append(imports, f)
+		case "func":
+			funcs = append(funcs, f)
+		}
+	}
+	for i, f := range append(imports, funcs...) {
+		if f.id == "" {
+			continue
+		}
+		if _, ok := p.funcIDs[f.id]; ok {
+			p.pos = f.pos
+			p.fail("duplicate func $%s", f.id)
+		}
+		p.funcIDs[f.id] = uint32(i)
+	}
+	// Types come first, so that the types functions use inline are numbered
+	// after them.
+	for _, f := range fields {
+		if f.kw == "type" {
+			p.pos = f.pos
+			p.typeField()
+		}
+	}
+	for _, f := range fields {
+		if f.kw == "import" {
+			p.pos = f.pos
+			p.importField()
+		}
+	}
+	for _, f := range fields {
+		p.pos = f.pos
+		p.id()
+		switch f.kw {
+		case "type", "import":
+		case "func":
+			p.funcField(f.id)
+		case "table":
+			if p.m.Table != nil {
+				p.fail("multiple tables")
+			}
+			p.m.Table = &Table{Min: p.u32()}
+			p.keyword("funcref")
+			p.rparen()
+		case "memory":
+			if p.m.Memory != nil {
+				p.fail("multiple memories")
+			}
+			p.m.Memory = &Memory{Min: p.u32()}
+			if p.peek().kind == tokAtom {
+				p.m.Memory.Max = p.u32()
+				p.m.Memory.HasMax = true
+			}
+			p.rparen()
+		case "global":
+			g := &Global{ID: f.id}
+			if p.isField("mut") {
+				p.lparen()
+				p.keyword("mut")
+				g.Type = p.valType()
+				g.Mutable = true
+				p.rparen()
+			} else {
+				g.Type = p.valType()
+			}
+			p.lparen()
+			g.Init = p.instr()
+			p.rparen()
+			p.rparen()
+			p.m.Globals = append(p.m.Globals, g)
+		case "export":
+			exp := &Export{Name: p.expect(tokString, "a name").text}
+			p.lparen()
+			exp.Kind, exp.Index = p.externRef()
+			p.rparen()
+			p.rparen()
+			p.m.Exports = append(p.m.Exports, exp)
+		case "elem":
+			el := &Elem{Offset: p.offset()}
+			if t := p.peek(); t.kind == tokAtom && t.text == "func" {
+				p.next()
+			}
+			for p.peek().kind == tokAtom {
+				el.Funcs = append(el.Funcs, p.ref(p.funcIDs, "func"))
+			}
+			p.rparen()
+			p.m.Elems = append(p.m.Elems, el)
+		case "data":
+			d := &Data{Offset: p.offset()}
+			for p.peek().kind == tokString {
+				d.Bytes = append(d.Bytes, p.next().text...)
+			}
+			p.rparen()
+			p.m.Data = append(p.m.Data, d)
+		default:
+			p.pos = f.pos - 1
+			p.fail("unsupported field %s", f.kw)
+		}
+	}
+}
+
+func (p *parser) typeField() {
+	p.id()
+	p.lparen()
+	p.keyword("func")
+	t, _ := p.signature(false)
+	p.rparen()
+	p.rparen()
+	p.m.Types = append(p.m.Types, t)
+}
+
+func (p *parser) importField() {
+	imp := &Import{
+		Module: p.expect(tokString, "a module name").text,
+		Name:   p.expect(tokString, "a name").text,
+	}
+	p.lparen()
+	p.keyword("func")
+	imp.ID = p.id()
+	imp.Type, _ = p.typeUse()
+	p.rparen()
+	p.rparen()
+	p.m.Imports = append(p.m.Imports, imp)
+}
+
+// signature reads the parameters and results of a function type, with the
+// IDs of the parameters if named is set.
+func (p *parser) signature(named bool) (FuncType, []string) {
+	var t FuncType
+	var ids []string
+	for p.isField("param") {
+		p.lparen()
+		p.keyword("param")
+		if id := p.id(); id != "" {
+			if !named {
+				p.fail("unexpected parameter ID $%s", id)
+			}
+			ids = append(ids, id)
+			t.Params = append(t.Params, p.valType())
+		} else {
+			for p.peek().kind == tokAtom {
+				ids = append(ids, "")
+				t.Params = append(t.Params, p.valType())
+			}
+		}
+		p.rparen()
+	}
+	for p.isField("result") {
+		p.lparen()
+		p.keyword("result")
+		for p.peek().kind == tokAtom {
+			t.Results = append(t.Results, p.valType())
+		}
+		p.rparen()
+	}
+	return t, ids
+}
+
+// typeUse reads the type of a function: a (type) reference, its parameters
+// and results, or both, which must agree.
+func (p *parser) typeUse() (uint32, []string) {
+	index := uint32(math.MaxUint32)
+	if p.isField("type") {
+		p.lparen()
+		p.keyword("type")
+		index = p.ref(p.typeIDs, "type")
+		if int(index) >= len(p.m.Types) {
+			p.fail("unknown type %d", index)
+		}
+		p.rparen()
+	}
+	explicit := p.isField("param") || p.isField("result")
+	t, ids := p.signature(true)
+	if index == math.MaxUint32 {
+		return p.m.TypeIndex(t), ids
+	}
+	if explicit && !p.m.Types[index].equal(t) {
+		p.fail("signature does not match type %d", index)
+	}
+	return index, ids
+}
+
+func (p *parser) funcField(id string) {
+	f := &Func{ID: id}
+	index := uint32(len(p.m.Imports) + len(p.m.Funcs))
+	for p.isField("export") {
+		p.lparen()
+		p.keyword("export")
+		p.m.Exports = append(p.m.Exports, &Export{Name: p.expect(tokString, "a name").text, Kind: ExternFunc, Index: index})
+		p.rparen()
+	}
+	var ids []string
+	f.Type, ids = p.typeUse()
+	params := len(p.m.Types[f.Type].Params)
+	for len(ids) < params {
+		ids = append(ids, "")
+	}
+	for p.isField("local") {
+		p.lparen()
+		p.keyword("local")
+		if id := p.id(); id != "" {
+			ids = append(ids, id)
+			f.Locals = append(f.Locals, p.valType())
+		} else {
+			for p.peek().kind == tokAtom {
+				ids = append(ids, "")
+				f.Locals = append(f.Locals, p.valType())
+			}
+		}
+		p.rparen()
+	}
+	p.localIDs = map[string]uint32{}
+	for i, id := range ids {
+		if id == "" {
+			continue
+		}
+		if _, ok := p.localIDs[id]; ok {
+			p.fail("duplicate local $%s", id)
+		}
+		p.localIDs[id] = uint32(i)
+		f.LocalIDs = ids
+	}
+	p.labels = nil
+	for p.peek().kind != tokRParen {
+		f.Body = append(f.Body, p.instr())
+	}
+	if len(p.labels) > 0 {
+		p.fail("missing end")
+	}
+	p.rparen()
+	p.m.Funcs = append(p.m.Funcs, f)
+}
+
+// offset reads the constant offset of a segment, which may be written in an
+// (offset) field.
+func (p *parser) offset() uint32 {
+	p.lparen()
+	wrapped := p.peek().kind == tokAtom && p.peek().text == "offset"
+	if wrapped {
+		p.next()
+		p.lparen()
+	}
+	p.keyword("i32.const")
+	off := p.integer(32)
+	p.rparen()
+	if wrapped {
+		p.rparen()
+	}
+	return uint32(off)
+}
+
+func (p *parser) externRef() (ExternKind, uint32) {
+	kw := p.expect(tokAtom, "an export kind").text
+	switch kw {
+	case "func":
+		return ExternFunc, p.ref(p.funcIDs, "func")
+	case "global":
+		return ExternGlobal, p.ref(p.globalIDs, "global")
+	case "memory":
+		return ExternMemory, p.ref(nil, "memory")
+	case "table":
+		return ExternTable, p.ref(nil, "table")
+	}
+	p.fail("unknown export kind %s", kw)
+	return 0, 0
+}
+
+// ref reads a reference to a definition, by ID or index.
+func (p *parser) ref(ids map[string]uint32, what string) uint32 {
+	t := p.expect(tokAtom, "a "+what)
+	if strings.HasPrefix(t.text, "$") {
+		index, ok := ids[t.text[1:]]
+		if !ok {
+			p.pos--
+			p.fail("unknown %s %s", what, t.text)
+		}
+		return index
+	}
+	p.pos--
+	return p.u32()
+}
+
+func (p *parser) valType() ValType {
+	t := p.expect(tokAtom, "a value type")
+	for _, v := range []ValType{I32, I64, F32, F64} {
+		if t.text == v.String() {
+			return v
+		}
+	}
+	p.pos--
+	p.fail("unknown value type %s", t.text)
+	return 0
+}
+
+func (p *parser) u32() uint32 {
+	return uint32(p.unsigned(math.MaxUint32))
+}
+
+// unsigned reads an unsigned integer no greater than max.
+func (p *parser) unsigned(max uint64) uint64 {
+	t := p.expect(tokAtom, "a number")
+	v, err := strconv.ParseUint(strings.ReplaceAll(t.text, "_", ""), 0, 64)
+	if err != nil || v > max || strings.HasPrefix(t.text, "0") && len(t.text) > 1 && !strings.HasPrefix(t.text, "0x") {
+		p.pos--
+		p.fail("malformed number %s", t.text)
+	}
+	return v
+}
+
+// integer reads an integer constant of the given size, which may be written
+// signed or unsigned.
+func (p *parser) integer(bits int) int64 {
+	t := p.expect(tokAtom, "an integer")
+	text := strings.ReplaceAll(t.text, "_", "")
+	neg := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "-"), "+")
+	v, err := strconv.ParseUint(text, 0, bits)
+	if err == nil && strings.HasPrefix(text, "0") && len(text) > 1 && !strings.HasPrefix(text, "0x") {
+		err = strconv.ErrSyntax
+	}
+	if err == nil && neg && v > 1<<(bits-1) {
+		err = strconv.ErrRange
+	}
+	if err != nil {
+		p.pos--
+		p.fail("malformed i%d constant %s", bits, t.text)
+	}
+	if neg {
+		v = -v
+	}
+	if bits == 32 {
+		return int64(int32(uint32(v)))
+	}
+	return int64(v)
+}
+
+func (p *parser) float(bits int) float64 {
+	t := p.expect(tokAtom, "a float")
+	text := strings.ReplaceAll(t.text, "_", "")
+	sign := 1.0
+	switch {
+	case strings.HasPrefix(text, "-"):
+		sign = -1
+		text = text[1:]
+	case strings.HasPrefix(text, "+"):
+		text = text[1:]
+	}
+	switch {
+	case text == "inf":
+		return math.Inf(int(sign))
+	case text == "nan" || strings.HasPrefix(text, "nan:"):
+		return math.Copysign(math.NaN(), sign)
+	}
+	if strings.HasPrefix(text, "0x") && !strings.ContainsAny(text, "pP") {
+		text += "p0"
+	}
+	v, err := strconv.ParseFloat(text, bits)
+	if err != nil || strings.ContainsAny(text, "iInN") {
+		p.pos--
+		p.fail("malformed f%d constant %s", bits, t.text)
+	}
+	return sign * v
+}
+
+// label reads a reference to a label, by ID or depth.
+func (p *parser) label() int64 {
+	t := p.expect(tokAtom, "a label")
+	if strings.HasPrefix(t.text, "$") {
+		for i := len(p.labels) - 1; i >= 0; i-- {
+			if p.labels[i] == t.text[1:] {
+				return int64(len(p.labels) - 1 - i)
+			}
+		}
+		p.pos--
+		p.fail("unknown label %s", t.text)
+	}
+	p.pos--
+	return int64(p.u32())
+}
+
+func (p *parser) instr() Instr {
+	t := p.peek()
+	if t.kind == tokLParen {
+		p.fail("folded instructions are not supported")
+	}
+	name := p.expect(tokAtom, "an instruction").text
+	op, ok := opNames[name]
+	if !ok {
+		p.pos--
+		p.fail("unknown instruction %s", name)
+	}
+	info := opInfos[op]
+	instr := Instr{Op: op}
+	switch op {
+	case OpElse, OpEnd:
+		if len(p.labels) == 0 {
+			p.pos--
+			p.fail("%s outside a block", name)
+		}
+		if id := p.id(); id != "" && id != p.labels[len(p.labels)-1] {
+			p.fail("mismatched label $%s", id)
+		}
+		if op == OpEnd {
+			p.labels = p.labels[:len(p.labels)-1]
+		}
+		return instr
+	}
+	switch info.imm {
+	case immBlock:
+		p.labels = append(p.labels, p.id())
+		if p.isField("result") {
+			p.lparen()
+			p.keyword("result")
+			instr.Imm = int64(p.valType())
+			p.rparen()
+		}
+	case immLabel:
+		instr.Imm = p.label()
+	case immFunc:
+		instr.Imm = int64(p.ref(p.funcIDs, "func"))
+	case immCallIndirect:
+		index, _ := p.typeUse()
+		instr.Imm = int64(index)
+	case immLocal:
+		instr.Imm = int64(p.ref(p.localIDs, "local"))
+	case immGlobal:
+		instr.Imm = int64(p.ref(p.globalIDs, "global"))
+	case immMem:
+		instr.Align = info.natural()
+		if t := p.peek(); t.kind == tokAtom && strings.HasPrefix(t.text, "offset=") {
+			p.next()
+			v, err := strconv.ParseUint(strings.TrimPrefix(t.text, "offset="), 0, 32)
+			if err != nil {
+				p.pos--
+				p.fail("malformed offset %s", t.text)
+			}
+			instr.Imm = int64(v)
+		}
+		if t := p.peek(); t.kind == tokAtom && strings.HasPrefix(t.text, "align=") {
+			p.next()
+			v, err := strconv.ParseUint(strings.TrimPrefix(t.text, "align="), 0, 32)
+			if err != nil || v == 0 || v&(v-1) != 0 {
+				p.pos--
+				p.fail("malformed alignment %s", t.text)
+			}
+			instr.Align = 0
+			for 1<<instr.Align < v {
+				instr.Align++
+			}
+		}
+	case immI32:
+		instr.Imm = p.integer(32)
+	case immI64:
+		instr.Imm = p.integer(64)
+	case immF32:
+		instr.F = p.float(32)
+	case immF64:
+		instr.F = p.float(64)
+	}
+	return instr
+}
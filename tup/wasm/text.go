@@ -0,0 +1,276 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Text returns m in the text format. Functions, globals, parameters and
+// locals are named by their IDs where they have distinct ones; labels are
+// referred to by depth.
+func Text(m *Module) string {
+	p := &printer{m: m}
+	p.funcIDs = p.ids(len(m.Imports)+len(m.Funcs), func(i int) string {
+		if i < len(m.Imports) {
+			return m.Imports[i].ID
+		}
+		return m.Funcs[i-len(m.Imports)].ID
+	})
+	p.globalIDs = p.ids(len(m.Globals), func(i int) string { return m.Globals[i].ID })
+	p.module()
+	return p.sb.String()
+}
+
+type printer struct {
+	m         *Module
+	sb        strings.Builder
+	funcIDs   []string
+	globalIDs []string
+}
+
+// ids returns the IDs of n definitions for printing, leaving out those that
+// are empty, not valid IDs or not distinct.
+func (p *printer) ids(n int, id func(int) string) []string {
+	ids := make([]string, n)
+	count := map[string]int{}
+	for i := range ids {
+		if s := id(i); isID(s) {
+			ids[i] = s
+			count[s]++
+		}
+	}
+	for i, s := range ids {
+		if count[s] > 1 {
+			ids[i] = ""
+		}
+	}
+	return ids
+}
+
+func (p *printer) printf(format string, args ...any) {
+	fmt.Fprintf(&p.sb, format, args...)
+}
+
+// ref returns the reference to a definition: its ID or its index.
+func ref(ids []string, index int64) string {
+	if index >= 0 && index < int64(len(ids)) && ids[index] != "" {
+		return "$" + ids[index]
+	}
+	return strconv.FormatInt(index, 10)
+}
+
+func (p *printer) module() {
+	m := p.m
+	p.printf("(module\n")
+	for i, t := range m.Types {
+		p.printf("  (type (;%d;) (func%s))\n", i, signature(t, nil))
+	}
+	for i, imp := range m.Imports {
+		p.printf("  (import %s %s (func%s (type %d)))\n", quote([]byte(imp.Module)), quote([]byte(imp.Name)), p.def(p.funcIDs, i), imp.Type)
+	}
+	for i, f := range m.Funcs {
+		p.function(len(m.Imports)+i, f)
+	}
+	if m.Table != nil {
+		p.printf("  (table (;0;) %d funcref)\n", m.Table.Min)
+	}
+	if m.Memory != nil {
+		if m.Memory.HasMax {
+			p.printf("  (memory (;0;) %d %d)\n", m.Memory.Min, m.Memory.Max)
+		} else {
+			p.printf("  (memory (;0;) %d)\n", m.Memory.Min)
+		}
+	}
+	for i, g := range m.Globals {
+		typ := g.Type.String()
+		if g.Mutable {
+			typ = "(mut " + typ + ")"
+		}
+		p.printf("  (global%s %s (%s))\n", p.def(p.globalIDs, i), typ, p.instr(g.Init, nil))
+	}
+	for _, exp := range m.Exports {
+		index := strconv.Itoa(int(exp.Index))
+		switch exp.Kind {
+		case ExternFunc:
+			index = ref(p.funcIDs, int64(exp.Index))
+		case ExternGlobal:
+			index = ref(p.globalIDs, int64(exp.Index))
+		}
+		p.printf("  (export %s (%s %s))\n", quote([]byte(exp.Name)), exp.Kind, index)
+	}
+	for _, el := range m.Elems {
+		p.printf("  (elem (i32.const %d) func", int32(el.Offset))
+		for _, f := range el.Funcs {
+			p.printf(" %s", ref(p.funcIDs, int64(f)))
+		}
+		p.printf(")\n")
+	}
+	for _, d := range m.Data {
+		p.printf("  (data (i32.const %d) %s)\n", int32(d.Offset), quote(d.Bytes))
+	}
+	p.printf(")\n")
+}
+
+// def returns the ID, or the index in a comment, that begins a definition.
+func (p *printer) def(ids []string, index int) string {
+	if ids[index] != "" {
+		return " $" + ids[index]
+	}
+	return fmt.Sprintf(" (;%d;)", index)
+}
+
+// signature returns the parameters and results of a function type, naming
+// the parameters with the IDs of locals, if it has them.
+func signature(t FuncType, localIDs []string) string {
+	var sb strings.Builder
+	named := false
+	for i := range t.Params {
+		if i < len(localIDs) && localIDs[i] != "" {
+			named = true
+		}
+	}
+	if named {
+		for i, param := range t.Params {
+			if i < len(localIDs) && localIDs[i] != "" {
+				fmt.Fprintf(&sb, " (param $%s %s)", localIDs[i], param)
+			} else {
+				fmt.Fprintf(&sb, " (param %s)", param)
+			}
+		}
+	} else if len(t.Params) > 0 {
+		sb.WriteString(" (param")
+		for _, param := range t.Params {
+			sb.WriteString(" " + param.String())
+		}
+		sb.WriteString(")")
+	}
+	if len(t.Results) > 0 {
+		sb.WriteString(" (result")
+		for _, result := range t.Results {
+			sb.WriteString(" " + result.String())
+		}
+		sb.WriteString(")")
+	}
+	return sb.String()
+}
+
+func (p *printer) function(index int, f *Func) {
+	var params []ValType
+	if int(f.Type) < len(p.m.Types) {
+		params = p.m.Types[f.Type].Params
+	}
+	localIDs := p.ids(len(f.LocalIDs), func(i int) string { return f.LocalIDs[i] })
+	p.printf("  (func%s (type %d)", p.def(p.funcIDs, index), f.Type)
+	if int(f.Type) < len(p.m.Types) {
+		p.printf("%s", signature(p.m.Types[f.Type], localIDs))
+	}
+	p.printf("\n")
+	if len(f.Locals) > 0 {
+		id := func(i int) string {
+			if k := len(params) + i; k < len(localIDs) {
+				return localIDs[k]
+			}
+			return ""
+		}
+		named := false
+		for i := range f.Locals {
+			named = named || id(i) != ""
+		}
+		var decls []string
+		for i, t := range f.Locals {
+			switch {
+			case id(i) != "":
+				decls = append(decls, fmt.Sprintf("(local $%s %s)", id(i), t))
+			case named:
+				decls = append(decls, fmt.Sprintf("(local %s)", t))
+			case i == 0:
+				decls = append(decls, "(local "+t.String())
+			default:
+				decls = append(decls, t.String())
+			}
+		}
+		if !named {
+			decls[len(decls)-1] += ")"
+		}
+		p.printf("    %s\n", strings.Join(decls, " "))
+	}
+	depth := 2
+	for _, instr := range f.Body {
+		if instr.Op == OpEnd || instr.Op == OpElse {
+			depth--
+		}
+		p.printf("%s%s\n", strings.Repeat("  ", depth), p.instr(instr, localIDs))
+		switch instr.Op {
+		case OpBlock, OpLoop, OpIf, OpElse:
+			depth++
+		}
+	}
+	p.printf("  )\n")
+}
+
+func (p *printer) instr(instr Instr, localIDs []string) string {
+	info := opInfos[instr.Op]
+	if info == nil {
+		return instr.String()
+	}
+	switch info.imm {
+	case immFunc:
+		return info.name + " " + ref(p.funcIDs, instr.Imm)
+	case immLocal:
+		return info.name + " " + ref(localIDs, instr.Imm)
+	case immGlobal:
+		return info.name + " " + ref(p.globalIDs, instr.Imm)
+	}
+	return instr.String()
+}
+
+// formatFloat formats a float constant of the given size.
+func formatFloat(f float64, bits int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
+
+// quote returns b as a string of the text format.
+func quote(b []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range b {
+		if c >= 0x20 && c < 0x7f && c != '"' && c != '\\' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "\\%02x", c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// isID reports whether s may follow $ as an ID.
+func isID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isIDChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIDChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-./:<=>?@\\^_`|~", c) >= 0
+}
@@ -0,0 +1,362 @@
+package wasm
+
+import "fmt"
+
+// Validate reports whether m is valid: whether its indices are in range, its
+// segments fit the table and memory, its exports are distinct and its
+// functions are well typed, following the validation algorithm of the
+// specification.
+func Validate(m *Module) error {
+	nfuncs := uint32(len(m.Imports) + len(m.Funcs))
+	for i, imp := range m.Imports {
+		if int(imp.Type) >= len(m.Types) {
+			return errorf("import %d (%s.%s): unknown type %d", i, imp.Module, imp.Name, imp.Type)
+		}
+	}
+	for i, f := range m.Funcs {
+		if int(f.Type) >= len(m.Types) {
+			return errorf("func %d: unknown type %d", len(m.Imports)+i, f.Type)
+		}
+	}
+	if m.Memory != nil {
+		if m.Memory.Min > 65536 || m.Memory.HasMax && (m.Memory.Max > 65536 || m.Memory.Max < m.Memory.Min) {
+			return errorf("memory: invalid limits")
+		}
+	}
+	for i, g := range m.Globals {
+		var t ValType
+		switch g.Init.Op {
+		case OpI32Const:
+			t = I32
+		case OpI64Const:
+			t = I64
+		case OpF32Const:
+			t = F32
+		case OpF64Const:
+			t = F64
+		default:
+			return errorf("global %d: initializer is not a constant", i)
+		}
+		if t != g.Type {
+			return errorf("global %d: initializer has type %s, want %s", i, t, g.Type)
+		}
+	}
+	names := map[string]bool{}
+	for _, exp := range m.Exports {
+		if names[exp.Name] {
+			return errorf("duplicate export %q", exp.Name)
+		}
+		names[exp.Name] = true
+		var ok bool
+		switch exp.Kind {
+		case ExternFunc:
+			ok = exp.Index < nfuncs
+		case ExternTable:
+			ok = m.Table != nil && exp.Index == 0
+		case ExternMemory:
+			ok = m.Memory != nil && exp.Index == 0
+		case ExternGlobal:
+			ok = int(exp.Index) < len(m.Globals)
+		}
+		if !ok {
+			return errorf("export %q: unknown %s %d", exp.Name, exp.Kind, exp.Index)
+		}
+	}
+	for i, el := range m.Elems {
+		if m.Table == nil {
+			return errorf("elem %d: no table", i)
+		}
+		if uint64(el.Offset)+uint64(len(el.Funcs)) > uint64(m.Table.Min) {
+			return errorf("elem %d: out of the bounds of the table", i)
+		}
+		for _, f := range el.Funcs {
+			if f >= nfuncs {
+				return errorf("elem %d: unknown func %d", i, f)
+			}
+		}
+	}
+	for i, d := range m.Data {
+		if m.Memory == nil {
+			return errorf("data %d: no memory", i)
+		}
+		if uint64(d.Offset)+uint64(len(d.Bytes)) > uint64(m.Memory.Min)*PageSize {
+			return errorf("data %d: out of the bounds of the memory", i)
+		}
+	}
+	for i, f := range m.Funcs {
+		v := &validator{m: m, f: f}
+		if err := v.function(); err != nil {
+			index := len(m.Imports) + i
+			if f.ID != "" {
+				return errorf("func %d ($%s): %s", index, f.ID, err)
+			}
+			return errorf("func %d: %s", index, err)
+		}
+	}
+	return nil
+}
+
+// unknown is the type of the operands of unreachable code, which matches
+// any type.
+const unknown ValType = 0
+
+type ctrlFrame struct {
+	op          Op
+	results     []ValType
+	height      int
+	unreachable bool
+}
+
+// labelTypes returns the types a branch to the frame passes.
+func (c *ctrlFrame) labelTypes() []ValType {
+	if c.op == OpLoop {
+		return nil
+	}
+	return c.results
+}
+
+type validator struct {
+	m      *Module
+	f      *Func
+	locals []ValType
+	vals   []ValType
+	ctrls  []ctrlFrame
+}
+
+type validateError struct {
+	msg string
+}
+
+func (v *validator) fail(format string, args ...any) {
+	panic(validateError{fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) push(t ValType) { v.vals = append(v.vals, t) }
+
+func (v *validator) pushAll(ts []ValType) {
+	for _, t := range ts {
+		v.push(t)
+	}
+}
+
+func (v *validator) pop() ValType {
+	c := &v.ctrls[len(v.ctrls)-1]
+	if len(v.vals) == c.height {
+		if c.unreachable {
+			return unknown
+		}
+		v.fail("operand stack underflow")
+	}
+	t := v.vals[len(v.vals)-1]
+	v.vals = v.vals[:len(v.vals)-1]
+	return t
+}
+
+func (v *validator) popExpect(want ValType) ValType {
+	t := v.pop()
+	if t != want && t != unknown && want != unknown {
+		v.fail("operand has type %s, want %s", t, want)
+	}
+	if t == unknown {
+		return want
+	}
+	return t
+}
+
+func (v *validator) popAll(ts []ValType) {
+	for i := len(ts) - 1; i >= 0; i-- {
+		v.popExpect(ts[i])
+	}
+}
+
+func (v *validator) pushCtrl(op Op, results []ValType) {
+	v.ctrls = append(v.ctrls, ctrlFrame{op: op, results: results, height: len(v.vals)})
+}
+
+func (v *validator) popCtrl() ctrlFrame {
+	if len(v.ctrls) == 0 {
+		v.fail("end without a block")
+	}
+	c := v.ctrls[len(v.ctrls)-1]
+	v.popAll(c.results)
+	if len(v.vals) != c.height {
+		v.fail("%d values left on the operand stack", len(v.vals)-c.height)
+	}
+	v.ctrls = v.ctrls[:len(v.ctrls)-1]
+	return c
+}
+
+func (v *validator) setUnreachable() {
+	c := &v.ctrls[len(v.ctrls)-1]
+	v.vals = v.vals[:c.height]
+	c.unreachable = true
+}
+
+func (v *validator) label(depth int64) *ctrlFrame {
+	if depth < 0 || depth >= int64(len(v.ctrls)) {
+		v.fail("unknown label %d", depth)
+	}
+	return &v.ctrls[len(v.ctrls)-1-int(depth)]
+}
+
+func (v *validator) funcType(index int64) *FuncType {
+	if index < 0 || index > int64(^uint32(0)) {
+		v.fail("unknown func %d", index)
+	}
+	t := v.m.FuncType(uint32(index))
+	if t == nil {
+		v.fail("unknown func %d", index)
+	}
+	return t
+}
+
+func (v *validator) function() (err error) {
+	t := v.m.Types[v.f.Type]
+	v.locals = append(append([]ValType(nil), t.Params...), v.f.Locals...)
+	n := 0
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(validateError)
+			if !ok {
+				panic(r)
+			}
+			if n < len(v.f.Body) {
+				err = fmt.Errorf("instruction %d (%s): %s", n, v.f.Body[n], e.msg)
+			} else {
+				err = fmt.Errorf("end: %s", e.msg)
+			}
+		}
+	}()
+	v.pushCtrl(OpBlock, t.Results)
+	for n = range v.f.Body {
+		if len(v.ctrls) == 0 {
+			v.fail("instruction after the end of the function")
+		}
+		v.instr(v.f.Body[n])
+	}
+	n = len(v.f.Body)
+	if len(v.ctrls) != 1 {
+		v.fail("%d blocks not ended", len(v.ctrls)-1)
+	}
+	v.popCtrl()
+	return nil
+}
+
+func (v *validator) instr(instr Instr) {
+	info := opInfos[instr.Op]
+	if info == nil {
+		v.fail("unknown opcode")
+	}
+	switch instr.Op {
+	case OpUnreachable:
+		v.setUnreachable()
+	case OpNop:
+	case OpBlock, OpLoop, OpIf:
+		var results []ValType
+		switch t := ValType(instr.Imm); t {
+		case unknown:
+		case I32, I64, F32, F64:
+			results = []ValType{t}
+		default:
+			v.fail("invalid block type")
+		}
+		if instr.Op == OpIf {
+			v.popExpect(I32)
+		}
+		v.pushCtrl(instr.Op, results)
+	case OpElse:
+		if v.ctrls[len(v.ctrls)-1].op != OpIf || len(v.ctrls) == 1 {
+			v.fail("else without if")
+		}
+		c := v.popCtrl()
+		v.pushCtrl(OpElse, c.results)
+	case OpEnd:
+		if len(v.ctrls) == 1 {
+			v.fail("end without a block")
+		}
+		c := v.popCtrl()
+		if c.op == OpIf && len(c.results) > 0 {
+			v.fail("if without else has results")
+		}
+		v.pushAll(c.results)
+	case OpBr:
+		v.popAll(v.label(instr.Imm).labelTypes())
+		v.setUnreachable()
+	case OpBrIf:
+		v.popExpect(I32)
+		types := v.label(instr.Imm).labelTypes()
+		v.popAll(types)
+		v.pushAll(types)
+	case OpReturn:
+		v.popAll(v.ctrls[0].results)
+		v.setUnreachable()
+	case OpCall:
+		t := v.funcType(instr.Imm)
+		v.popAll(t.Params)
+		v.pushAll(t.Results)
+	case OpCallIndirect:
+		if v.m.Table == nil {
+			v.fail("no table")
+		}
+		if instr.Imm < 0 || instr.Imm >= int64(len(v.m.Types)) {
+			v.fail("unknown type %d", instr.Imm)
+		}
+		t := v.m.Types[instr.Imm]
+		v.popExpect(I32)
+		v.popAll(t.Params)
+		v.pushAll(t.Results)
+	case OpDrop:
+		v.pop()
+	case OpSelect:
+		v.popExpect(I32)
+		t1 := v.pop()
+		t2 := v.pop()
+		if t1 != t2 && t1 != unknown && t2 != unknown {
+			v.fail("select of %s and %s", t2, t1)
+		}
+		if t1 == unknown {
+			t1 = t2
+		}
+		v.push(t1)
+	case OpLocalGet, OpLocalSet, OpLocalTee:
+		if instr.Imm < 0 || instr.Imm >= int64(len(v.locals)) {
+			v.fail("unknown local %d", instr.Imm)
+		}
+		t := v.locals[instr.Imm]
+		if instr.Op != OpLocalGet {
+			v.popExpect(t)
+		}
+		if instr.Op != OpLocalSet {
+			v.push(t)
+		}
+	case OpGlobalGet, OpGlobalSet:
+		if instr.Imm < 0 || instr.Imm >= int64(len(v.m.Globals)) {
+			v.fail("unknown global %d", instr.Imm)
+		}
+		g := v.m.Globals[instr.Imm]
+		if instr.Op == OpGlobalGet {
+			v.push(g.Type)
+		} else {
+			if !g.Mutable {
+				v.fail("global %d is immutable", instr.Imm)
+			}
+			v.popExpect(g.Type)
+		}
+	default:
+		if info.imm == immMem || info.imm == immMemIdx || info.imm == immMemCopy {
+			if v.m.Memory == nil {
+				v.fail("no memory")
+			}
+		}
+		if info.imm == immMem {
+			if instr.Align > info.natural() {
+				v.fail("alignment 2**%d exceeds the natural alignment", instr.Align)
+			}
+			if instr.Imm < 0 || instr.Imm > int64(^uint32(0)) {
+				v.fail("offset out of range")
+			}
+		}
+		v.popAll(info.in)
+		v.pushAll(info.out)
+	}
+}
@@ -0,0 +1,242 @@
+// Package wasm represents WebAssembly modules, and encodes, decodes,
+// validates, prints and parses them.
+//
+// It covers the part of WebAssembly the wasmgen backend uses: function
+// imports, one table of function references, one memory, globals, exports,
+// active element and data segments, and the instructions of the 1.0
+// specification with the sign extension, multi-value and bulk memory
+// additions, less br_table. The text format is that of the specification,
+// written flat, with an instruction to a line: Text prints a module and
+// ParseText reads one, and Encode and Decode do the same for the binary
+// format. Validate checks a module as an engine would before running it,
+// including the types of the instructions of each function.
+package wasm
+
+import "fmt"
+
+// ValType is the type of a value.
+type ValType byte
+
+const (
+	I32     ValType = 0x7f
+	I64     ValType = 0x7e
+	F32     ValType = 0x7d
+	F64     ValType = 0x7c
+	FuncRef ValType = 0x70
+)
+
+func (t ValType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	case FuncRef:
+		return "funcref"
+	}
+	return fmt.Sprintf("valtype(0x%02x)", byte(t))
+}
+
+// FuncType is the type of a function.
+type FuncType struct {
+	Params, Results []ValType
+}
+
+func (t FuncType) equal(u FuncType) bool {
+	return equalTypes(t.Params, u.Params) && equalTypes(t.Results, u.Results)
+}
+
+func equalTypes(x, y []ValType) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Module is a WebAssembly module. Functions are numbered imports first,
+// then the functions the module defines.
+type Module struct {
+	Types   []FuncType
+	Imports []*Import
+	Funcs   []*Func
+	Table   *Table
+	Memory  *Memory
+	Globals []*Global
+	Exports []*Export
+	Elems   []*Elem
+	Data    []*Data
+}
+
+// TypeIndex returns the index of the type t, adding it to the types of m if
+// it is not among them.
+func (m *Module) TypeIndex(t FuncType) uint32 {
+	for i, u := range m.Types {
+		if u.equal(t) {
+			return uint32(i)
+		}
+	}
+	m.Types = append(m.Types, t)
+	return uint32(len(m.Types) - 1)
+}
+
+// FuncType returns the type of the function with the given index, or nil if
+// there is none.
+func (m *Module) FuncType(index uint32) *FuncType {
+	var typ uint32
+	switch {
+	case int(index) < len(m.Imports):
+		typ = m.Imports[index].Type
+	case int(index) < len(m.Imports)+len(m.Funcs):
+		typ = m.Funcs[int(index)-len(m.Imports)].Type
+	default:
+		return nil
+	}
+	if int(typ) >= len(m.Types) {
+		return nil
+	}
+	return &m.Types[typ]
+}
+
+// Import is an imported function.
+type Import struct {
+	Module, Name string
+	Type         uint32
+	// ID names the function in the text format; it may be empty.
+	ID string
+}
+
+// Func is a function the module defines.
+type Func struct {
+	Type   uint32
+	Locals []ValType
+	// Body holds the instructions of the function, without the end that
+	// closes it.
+	Body []Instr
+	// ID names the function in the text format, and LocalIDs its parameters
+	// and locals, in order; either may be empty.
+	ID       string
+	LocalIDs []string
+}
+
+// Table is a table of function references.
+type Table struct {
+	Min uint32
+}
+
+// Memory is a linear memory, whose size is counted in pages of 64KiB.
+type Memory struct {
+	Min    uint32
+	Max    uint32
+	HasMax bool
+}
+
+// PageSize is the size of a page of memory.
+const PageSize = 65536
+
+// Global is a global variable, initialized by a constant instruction.
+type Global struct {
+	Type    ValType
+	Mutable bool
+	Init    Instr
+	ID      string
+}
+
+// ExternKind is the kind of definition an export exports.
+type ExternKind byte
+
+const (
+	ExternFunc   ExternKind = 0
+	ExternTable  ExternKind = 1
+	ExternMemory ExternKind = 2
+	ExternGlobal ExternKind = 3
+)
+
+func (k ExternKind) String() string {
+	switch k {
+	case ExternFunc:
+		return "func"
+	case ExternTable:
+		return "table"
+	case ExternMemory:
+		return "memory"
+	case ExternGlobal:
+		return "global"
+	}
+	return fmt.Sprintf("externkind(%d)", byte(k))
+}
+
+// Export exports a definition under a name.
+type Export struct {
+	Name  string
+	Kind  ExternKind
+	Index uint32
+}
+
+// Elem places functions in the table from an offset.
+type Elem struct {
+	Offset uint32
+	Funcs  []uint32
+}
+
+// Data places bytes in memory from an offset.
+type Data struct {
+	Offset uint32
+	Bytes  []byte
+}
+
+// Instr is an instruction.
+type Instr struct {
+	Op Op
+	// Imm is the immediate of the instructions that take one: the index of
+	// a function, type, local or global, the depth of a label, the result
+	// type of a block, or 0 for none, the value of an integer constant, or
+	// the offset of a memory access. The value of a float constant is held
+	// by F.
+	Imm   int64
+	F     float64
+	Align uint32 // of memory accesses, as a power of two
+}
+
+// I returns the instruction op with the immediate imm.
+func I(op Op, imm int64) Instr { return Instr{Op: op, Imm: imm} }
+
+func (instr Instr) String() string {
+	info := opInfos[instr.Op]
+	if info == nil {
+		return fmt.Sprintf("op(0x%x)", uint16(instr.Op))
+	}
+	switch info.imm {
+	case immNone, immMemIdx, immMemCopy:
+		return info.name
+	case immBlock:
+		if instr.Imm != 0 {
+			return fmt.Sprintf("%s (result %s)", info.name, ValType(instr.Imm))
+		}
+		return info.name
+	case immCallIndirect:
+		return fmt.Sprintf("%s (type %d)", info.name, instr.Imm)
+	case immF32:
+		return info.name + " " + formatFloat(instr.F, 32)
+	case immF64:
+		return info.name + " " + formatFloat(instr.F, 64)
+	case immMem:
+		s := info.name
+		if instr.Imm != 0 {
+			s += fmt.Sprintf(" offset=%d", instr.Imm)
+		}
+		if instr.Align != info.natural() {
+			s += fmt.Sprintf(" align=%d", 1<<instr.Align)
+		}
+		return s
+	}
+	return fmt.Sprintf("%s %d", info.name, instr.Imm)
+}
@@ -0,0 +1,264 @@
+package wasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const sample = `(module
+  (type $binary (func (param i32 i32) (result i32)))
+  (import "env" "print" (func $print (param i32)))
+  (func $add (type $binary) (param $x i32) (param $y i32) (result i32)
+    local.get $x
+    local.get $y
+    i32.add
+  )
+  (func $count (export "count") (param $n i64) (result i64)
+    (local $sum i64) (local $f f64)
+    block $done
+      loop $next
+        local.get $n
+        i64.eqz
+        br_if $done
+        local.get $sum
+        local.get $n
+        i64.add
+        local.set $sum
+        local.get $n
+        i64.const -1
+        i64.add
+        local.set $n
+        br $next
+      end
+    end
+    f64.const 1.5e+300
+    local.set $f
+    local.get $sum
+  )
+  (func $choose (param i32) (result f32) ;; a comment
+    local.get 0
+    if (result f32)
+      f32.const -inf
+    else
+      f32.const 0x1p-3
+    end
+  )
+  (func $memory (result i32)
+    i32.const 8
+    i32.const 0x11223344
+    i32.store offset=4 align=2
+    i32.const 16
+    i32.const 8
+    i32.const 8
+    memory.copy
+    i32.const 16
+    i32.const 0
+    i32.const 4
+    memory.fill
+    i32.const 8
+    i64.load8_s offset=4
+    i32.wrap_i64
+    i32.extend8_s
+    i32.const 2
+    i32.const 3
+    i32.const 0
+    call_indirect (type $binary)
+    i32.add
+    global.get $heap
+    i32.add
+    memory.size
+    i32.add
+  )
+  (func $trap
+    i32.const 1
+    call $print
+    unreachable
+  )
+  (table 2 funcref)
+  (memory 1 2)
+  (global $heap (mut i32) (i32.const 1024))
+  (global $pi f64 (f64.const 3.14159))
+  (export "memory" (memory 0))
+  (export "heap" (global $heap))
+  (elem (i32.const 0) func $add $add)
+  (data (i32.const 8) "hi\00\ff\"")
+)
+`
+
+func TestRoundTrip(t *testing.T) {
+	m, err := ParseText(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(m); err != nil {
+		t.Fatal(err)
+	}
+	bin := Encode(m)
+	d, err := Decode(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(d); err != nil {
+		t.Fatal(err)
+	}
+	if got := Encode(d); !bytes.Equal(got, bin) {
+		t.Errorf("decoded module encodes differently:\n%x\n%x", got, bin)
+	}
+	for name, mod := range map[string]*Module{"parsed": m, "decoded": d} {
+		text := Text(mod)
+		again, err := ParseText(text)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", name, err, text)
+		}
+		if got := Encode(again); !bytes.Equal(got, bin) {
+			t.Errorf("%s: printed module encodes differently:\n%s", name, text)
+		}
+	}
+	text := Text(m)
+	for _, want := range []string{
+		`(import "env" "print" (func $print (type 1)))`,
+		`(func $add (type 0) (param $x i32) (param $y i32) (result i32)`,
+		`(local $sum i64) (local $f f64)`,
+		"      loop\n        local.get $n",
+		"br_if 1",
+		"f32.const 0.125",
+		"i32.store offset=4 align=2",
+		"i64.load8_s offset=4\n",
+		"call_indirect (type 0)",
+		"(memory (;0;) 1 2)",
+		`(export "count" (func $count))`,
+		`(data (i32.const 8) "hi\00\ff\22")`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text lacks %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	m := &Module{
+		Types:   []FuncType{{Results: []ValType{I32}}},
+		Funcs:   []*Func{{Body: []Instr{I(OpI32Const, -129)}}},
+		Exports: []*Export{{Name: "f", Kind: ExternFunc}},
+	}
+	want := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7f,
+		0x03, 0x02, 0x01, 0x00,
+		0x07, 0x05, 0x01, 0x01, 'f', 0x00, 0x00,
+		0x0a, 0x07, 0x01, 0x05, 0x00, 0x41, 0xff, 0x7e, 0x0b,
+	}
+	if got := Encode(m); !bytes.Equal(got, want) {
+		t.Errorf("Encode =\n%x, want\n%x", got, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	header := "\x00asm\x01\x00\x00\x00"
+	tests := []struct {
+		name, bin, err string
+	}{
+		{"magic", "\x00wat\x01\x00\x00\x00", "not a WebAssembly module"},
+		{"version", "\x00asm\x02\x00\x00\x00", "unsupported version"},
+		{"truncated", header + "\x01\x05\x01\x60", "runs past the end"},
+		{"order", header + "\x03\x01\x00\x01\x01\x00", "section 1 out of order"},
+		{"start", header + "\x08\x01\x00", "unsupported section 8"},
+		{"opcode", header + "\x01\x04\x01\x60\x00\x00\x03\x02\x01\x00\x0a\x05\x01\x03\x00\xff\x0b", "unknown opcode 0xff"},
+		{"bodies", header + "\x01\x04\x01\x60\x00\x00\x03\x02\x01\x00", "functions have no bodies"},
+		{"size", header + "\x01\x05\x01\x60\x00\x00\x00", "section 1 has size 5"},
+		{"leb", header + "\x05\x07\x01\x00\xff\xff\xff\xff\x7f", "integer too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode([]byte(tt.bin))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Decode error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	tests := []struct {
+		name, body, err string
+	}{
+		{"underflow", "i32.add", "instruction 0 (i32.add): operand stack underflow"},
+		{"type", "i64.const 1\ni32.eqz", "operand has type i64, want i32"},
+		{"leftover", "i32.const 1\ni32.const 2", "end: 1 values left on the operand stack"},
+		{"result", "", "operand stack underflow"},
+		{"label", "i32.const 0\nbr 2", "unknown label 2"},
+		{"local", "local.get 3", "unknown local 3"},
+		{"call", "call 9", "unknown func 9"},
+		{"immutable", "i32.const 0\nglobal.set 0\ni32.const 0", "global 0 is immutable"},
+		{"if", "i32.const 1\nif (result i32)\ni32.const 2\nend", "if without else has results"},
+		{"align", "i32.const 0\ni32.load align=8", "exceeds the natural alignment"},
+		{"select", "i32.const 1\ni64.const 2\ni32.const 0\nselect", "select of i32 and i64"},
+		{"unreachable", "unreachable\ni64.add\ni32.wrap_i64", ""},
+		{"frame", "i32.const 0\nloop (result i32)\nbr_if 0\ni32.const 2\nend", "instruction 2 (br_if 0): operand stack underflow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "(module (memory 1) (global i32 (i32.const 0)) (func (param i32) (result i32)\n" + tt.body + "\n))"
+			m, err := ParseText(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Validate(m)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Validate error = %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Validate error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateModule(t *testing.T) {
+	tests := []struct {
+		name, src, err string
+	}{
+		{"export", `(module (func $f) (export "f" (func $f)) (export "f" (func $f)))`, `duplicate export "f"`},
+		{"elem", `(module (func $f) (table 1 funcref) (elem (i32.const 1) func $f))`, "out of the bounds of the table"},
+		{"data", `(module (memory 1) (data (i32.const 65535) "ab"))`, "out of the bounds of the memory"},
+		{"memory", `(module (data (i32.const 0) "a"))`, "data 0: no memory"},
+		{"global", `(module (global i64 (i32.const 0)))`, "initializer has type i32, want i64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ParseText(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Validate(m); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Validate error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name, src, err string
+	}{
+		{"folded", "(module (func (i32.const 1) drop))", "line 1: folded instructions are not supported"},
+		{"op", "(module (func\n  i32.frob))", "line 2: unknown instruction i32.frob"},
+		{"label", "(module (func br $out))", "unknown label $out"},
+		{"func", "(module (func call $g))", "unknown func $g"},
+		{"duplicate", "(module (func $f) (func $f))", "duplicate func $f"},
+		{"const", "(module (func i32.const 4294967296 drop))", "malformed i32 constant"},
+		{"end", "(module (func block))", "missing end"},
+		{"string", "(module (data (i32.const 0) \"a))", "unterminated string"},
+		{"comment", "(module (; open", "unterminated block comment"},
+		{"type", "(module (type (func)) (func (type 0) (param i32)))", "does not match type 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseText(tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseText error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,718 @@
+package wasmgen
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/types"
+	"github.com/rowland/tuppence/tup/wasm"
+)
+
+// code is the body of a function being generated, with its locals.
+type code struct {
+	*generator
+	fn *wasm.Func
+}
+
+func (c *code) emit(op wasm.Op, imm int64) {
+	c.fn.Body = append(c.fn.Body, wasm.I(op, imm))
+}
+
+// call calls the runtime function named name.
+func (c *code) call(name string) {
+	index, ok := c.rt[name]
+	if !ok {
+		c.errorf("the runtime has no function %s", name)
+	}
+	c.emit(wasm.OpCall, int64(index))
+}
+
+// local adds a local of type t named id, returning its index.
+func (c *code) local(id string, t wasm.ValType) uint32 {
+	c.fn.Locals = append(c.fn.Locals, t)
+	c.fn.LocalIDs = append(c.fn.LocalIDs, id)
+	return uint32(len(c.fn.LocalIDs) - 1)
+}
+
+// temp adds a local of type t for a value used within an instruction.
+func (c *code) temp(t wasm.ValType) uint32 {
+	return c.local("t"+strconv.Itoa(len(c.fn.LocalIDs)), t)
+}
+
+// funcGen generates the function translating a function of the module.
+// Each value is held in a local, v<id>, and each phi also in a copy,
+// p<id>, assigned on the edges into its block. The local of an object
+// holds a reference to it from its first assignment until it is assigned
+// again or the function returns; the copies of phis and the parameters,
+// which the caller holds, hold none. A function returns its result with a
+// reference of its own.
+type funcGen struct {
+	code
+	ir     *ir.Func
+	values map[*ir.Instr]uint32
+	copies map[*ir.Instr]uint32
+	// the values whose locals hold references, and the blocks in loops,
+	// whose locals may be assigned again
+	counted []*ir.Instr
+	loops   map[*ir.Block]bool
+
+	// the arrangement of the blocks, by stackify
+	rpo      map[*ir.Block]int
+	children map[*ir.Block][]*ir.Block
+	loop     map[*ir.Block]bool
+	merge    map[*ir.Block]bool
+	frames   []frame
+}
+
+func (g *generator) function(fn *ir.Func) {
+	wf := g.out.Funcs[int(g.funcs[fn.Name])-len(g.out.Imports)]
+	for _, p := range fn.Params {
+		wf.LocalIDs = append(wf.LocalIDs, p.Name)
+	}
+	f := &funcGen{code: code{generator: g, fn: wf}, ir: fn, values: map[*ir.Instr]uint32{}, copies: map[*ir.Instr]uint32{}, loops: ir.InLoops(fn)}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Typ == nil {
+				continue
+			}
+			if counted(instr.Typ) {
+				// the local holds no reference until it is assigned, as
+				// locals start out 0
+				f.counted = append(f.counted, instr)
+			}
+			t := g.valType(instr.Typ)
+			f.values[instr] = f.local("v"+strconv.Itoa(instr.ID), t)
+			if instr.Op == ir.OpPhi {
+				f.copies[instr] = f.local("p"+strconv.Itoa(instr.ID), t)
+			}
+		}
+	}
+	f.stackify()
+}
+
+// value returns a function pushing v.
+func (f *funcGen) value(v ir.Value) func() {
+	switch v := v.(type) {
+	case *ir.Param:
+		for i, p := range f.ir.Params {
+			if p == v {
+				return f.get(uint32(i))
+			}
+		}
+	case *ir.Instr:
+		if index, ok := f.values[v]; ok {
+			return f.get(index)
+		}
+	}
+	f.errorf("unexpected value %s", v)
+	return nil
+}
+
+// set pops the value of instr into its local.
+func (f *funcGen) set(instr *ir.Instr) {
+	f.emit(wasm.OpLocalSet, int64(f.values[instr]))
+}
+
+// fresh reports whether the value instr defines comes with a reference of
+// its own: all do but those loaded from the blocks holding them by their
+// addresses, as the values held in place are copied out.
+func fresh(instr *ir.Instr) bool {
+	switch instr.Op {
+	case ir.OpField, ir.OpIndex, ir.OpPayload:
+		return inline(instr.Typ)
+	}
+	return true
+}
+
+func (f *funcGen) instr(instr *ir.Instr) {
+	arg := func(i int) func() { return f.value(instr.Args[i]) }
+	self := func() { f.emit(wasm.OpLocalGet, int64(f.values[instr])) }
+	owns := instr.Typ != nil && counted(instr.Typ)
+	if owns && f.loops[instr.Block] {
+		self()
+		f.call("tup_release")
+	}
+
+	switch op := instr.Op; {
+	case op == ir.OpConst:
+		f.constant(instr)
+	case op == ir.OpFunc:
+		v := f.table[instr.Callee]
+		if v == nil {
+			f.errorf("undefined function %s", instr.Callee)
+		}
+		if v.captures == 0 {
+			f.emit(wasm.OpI32Const, int64(v.addr))
+			break
+		}
+		// the block of a closure holds the values it captures
+		t := v.capturesType()
+		l := f.layout(t)
+		f.object(capturesOffset+l.Size, f.kind("closure", t), cyclic(t))
+		f.set(instr)
+		self()
+		f.emit(wasm.OpI32Const, int64(v.index))
+		f.memory(wasm.OpI32Store, 0, 4)
+		for i, p := range v.fn.Params[:v.captures] {
+			if i == len(instr.Args) {
+				// the closure captures itself
+				f.store(p.Typ, capturesOffset+l.Field(i).Offset, self, self)
+				continue
+			}
+			f.store(p.Typ, capturesOffset+l.Field(i).Offset, self, arg(i))
+		}
+		return
+	case op == ir.OpUndef:
+		f.zero(f.valType(instr.Typ))
+	case op.IsBinary():
+		f.arith(instr)
+	case op == ir.OpNeg:
+		f.neg(instr.Typ, arg(0))
+	case op == ir.OpNot:
+		arg(0)()
+		switch t := instr.Typ; {
+		case types.IsBool(t):
+			f.emit(wasm.OpI32Eqz, 0)
+		case f.valType(t) == wasm.I64:
+			f.emit(wasm.OpI64Const, -1)
+			f.emit(wasm.OpI64Xor, 0)
+		default:
+			// unsigned values keep their high bits clear
+			mask := int64(-1)
+			if types.IsUnsigned(t) {
+				_, max := bounds(t)
+				mask = int64(int32(max))
+			}
+			f.emit(wasm.OpI32Const, mask)
+			f.emit(wasm.OpI32Xor, 0)
+		}
+	case op.IsComparison():
+		f.compare(instr)
+	case op == ir.OpStr:
+		b := f.temp(wasm.I32)
+		f.call("tup_builder_new")
+		f.emit(wasm.OpLocalSet, int64(b))
+		f.format(instr.Args[0].Type(), f.get(b), arg(0), f.i32(0))
+		f.emit(wasm.OpLocalGet, int64(b))
+		f.call("tup_builder_string")
+	case op == ir.OpOrd:
+		arg(0)()
+	case op == ir.OpConv:
+		f.conv(instr.Typ, instr.Args[0].Type(), arg(0))
+
+	case op == ir.OpTuple:
+		tuple := instr.Typ.Underlying().(*types.Tuple)
+		l := f.layout(instr.Typ)
+		f.alloc(instr.Typ)
+		f.set(instr)
+		for i, field := range tuple.Fields {
+			f.store(field.Type, l.Field(i).Offset, self, arg(i))
+		}
+		return
+	case op == ir.OpUpdate:
+		// the copy refers to the objects the tuple does, but for the
+		// field replaced
+		field := instr.Typ.Underlying().(*types.Tuple).Fields[instr.Index].Type
+		l := f.layout(instr.Typ)
+		f.alloc(instr.Typ)
+		f.set(instr)
+		self()
+		arg(0)()
+		f.emit(wasm.OpI32Const, l.Size)
+		f.emit(wasm.OpMemoryCopy, 0)
+		f.visitInPlace(instr.Typ, self, f.i32(opRetain))
+		f.visit(field, self, l.Field(instr.Index).Offset, f.i32(opRelease))
+		f.store(field, l.Field(instr.Index).Offset, self, arg(1))
+		return
+	case op == ir.OpField:
+		field := instr.Args[0].Type().Underlying().(*types.Tuple).Fields[instr.Index]
+		arg(0)()
+		f.load(field.Type, f.layout(instr.Args[0].Type()).Field(instr.Index).Offset)
+		f.copyInline(field.Type)
+	case op == ir.OpArray:
+		array := instr.Typ.Underlying().(*types.Array)
+		size := f.size(array.Elem)
+		if array.Fixed() {
+			f.alloc(instr.Typ)
+			f.set(instr)
+			for i := range instr.Args {
+				f.store(array.Elem, int64(i)*size, self, arg(i))
+			}
+			return
+		}
+		f.emit(wasm.OpI32Const, int64(len(instr.Args)))
+		f.slab(array.Elem)
+		f.call("tup_array_new")
+		f.set(instr)
+		slab := func() {
+			self()
+			f.memory(wasm.OpI32Load, 4, 4)
+		}
+		for i := range instr.Args {
+			f.store(array.Elem, 8+int64(i)*size, slab, arg(i))
+		}
+		return
+	case op == ir.OpIndex:
+		index := func() { f.int64(instr.Args[1].Type(), arg(1)) }
+		switch t := instr.Args[0].Type().Underlying().(type) {
+		case *types.Array:
+			size := f.size(t.Elem)
+			arg(0)()
+			index()
+			if t.Fixed() {
+				f.emit(wasm.OpI32Const, t.Len)
+				f.call("tup_index")
+				f.emit(wasm.OpI32Const, size)
+				f.emit(wasm.OpI32Mul, 0)
+				f.emit(wasm.OpI32Add, 0)
+			} else {
+				f.emit(wasm.OpI32Const, size)
+				f.call("tup_array_elem")
+			}
+			f.load(t.Elem, 0)
+			f.copyInline(t.Elem)
+		default:
+			arg(0)()
+			index()
+			f.call("tup_string_byte")
+		}
+	case op == ir.OpLen:
+		if t, ok := instr.Args[0].Type().Underlying().(*types.Array); ok && t.Fixed() {
+			f.emit(wasm.OpI64Const, t.Len)
+		} else {
+			arg(0)()
+			f.memory(wasm.OpI32Load, 0, 4)
+			f.emit(wasm.OpI64ExtendI32U, 0)
+		}
+	case op == ir.OpAppend:
+		elem := instr.Typ.Underlying().(*types.Array).Elem
+		size := f.size(elem)
+		arg(0)()
+		f.slab(elem)
+		f.call("tup_array_append")
+		f.set(instr)
+		f.store(elem, 0, func() {
+			self()
+			f.emit(wasm.OpI32Const, size)
+			f.call("tup_array_last")
+		}, arg(1))
+		return
+	case op == ir.OpSlice:
+		arg(0)()
+		f.int64(instr.Args[1].Type(), arg(1))
+		f.int64(instr.Args[2].Type(), arg(2))
+		switch t := instr.Args[0].Type().Underlying().(type) {
+		case *types.Array:
+			if t.Fixed() {
+				f.emit(wasm.OpI32Const, t.Len)
+				f.slab(t.Elem)
+				f.call("tup_elems_slice")
+			} else {
+				f.slab(t.Elem)
+				f.call("tup_array_slice")
+			}
+		default:
+			f.call("tup_string_slice")
+		}
+
+	case op == ir.OpWrap:
+		member := instr.Typ.Underlying().(*types.Union).Members[instr.Index]
+		l := f.layout(instr.Typ)
+		f.alloc(instr.Typ)
+		f.set(instr)
+		self()
+		f.emit(wasm.OpI32Const, int64(instr.Index))
+		f.memory(wasm.OpI32Store, 0, 4)
+		f.store(member, l.Payload, self, arg(0))
+		return
+	case op == ir.OpTag:
+		arg(0)()
+		f.memory(wasm.OpI32Load, 0, 4)
+		f.emit(wasm.OpI64ExtendI32U, 0)
+	case op == ir.OpPayload:
+		union := instr.Args[0].Type()
+		member := union.Underlying().(*types.Union).Members[instr.Index]
+		f.tagIs(arg(0), instr.Index)
+		f.emit(wasm.OpI32Eqz, 0)
+		f.emit(wasm.OpIf, 0)
+		f.panic(union.String() + " does not hold " + member.String())
+		f.emit(wasm.OpEnd, 0)
+		arg(0)()
+		f.load(member, f.layout(union).Payload)
+		f.copyInline(member)
+
+	case op == ir.OpCall:
+		f.callInstr(instr)
+		return
+	default:
+		f.errorf("%s is not supported by the WebAssembly backend", op)
+	}
+	f.set(instr)
+	if owns && !fresh(instr) {
+		self()
+		f.call("tup_retain")
+	}
+}
+
+// copyInline replaces the address on the stack of a value of type t held
+// in place in a block, if t is held in place, with that of a copy of it.
+func (f *funcGen) copyInline(t types.Type) {
+	if inline(t) {
+		f.copy(t)
+	}
+}
+
+// panic stops the program with the message msg.
+func (c *code) panic(msg string) {
+	c.emit(wasm.OpI32Const, int64(c.literal(msg)))
+	c.call("tup_panic")
+	c.emit(wasm.OpUnreachable, 0)
+}
+
+// zero pushes the zero value of type t.
+func (c *code) zero(t wasm.ValType) {
+	switch t {
+	case wasm.I64:
+		c.emit(wasm.OpI64Const, 0)
+	case wasm.F32:
+		c.fn.Body = append(c.fn.Body, wasm.Instr{Op: wasm.OpF32Const})
+	case wasm.F64:
+		c.fn.Body = append(c.fn.Body, wasm.Instr{Op: wasm.OpF64Const})
+	default:
+		c.emit(wasm.OpI32Const, 0)
+	}
+}
+
+// arith pushes the result of the arithmetic instr, which traps on overflow
+// and division by zero.
+func (f *funcGen) arith(instr *ir.Instr) {
+	t := instr.Typ
+	x, y := f.value(instr.Args[0]), f.value(instr.Args[1])
+	vt := f.valType(t)
+	switch op := instr.Op; {
+	case (op == ir.OpAnd || op == ir.OpOr || op == ir.OpXor) && (types.IsBool(t) || types.IsInteger(t)):
+		x()
+		y()
+		f.emit(bitwise[vt][op], 0)
+	case op == ir.OpAdd && types.IsString(t):
+		x()
+		y()
+		f.call("tup_string_concat")
+	case types.IsFloat(t):
+		f.floatArith(op, vt, x, y)
+	case types.IsInteger(t):
+		f.intArith(op, t, x, y)
+	default:
+		f.errorf("%s of %s is not supported by the WebAssembly backend", instr.Op, t)
+	}
+}
+
+var bitwise = map[wasm.ValType]map[ir.Op]wasm.Op{
+	wasm.I32: {ir.OpAnd: wasm.OpI32And, ir.OpOr: wasm.OpI32Or, ir.OpXor: wasm.OpI32Xor},
+	wasm.I64: {ir.OpAnd: wasm.OpI64And, ir.OpOr: wasm.OpI64Or, ir.OpXor: wasm.OpI64Xor},
+}
+
+var floatOps = map[wasm.ValType]map[ir.Op]wasm.Op{
+	wasm.F32: {ir.OpAdd: wasm.OpF32Add, ir.OpSub: wasm.OpF32Sub, ir.OpMul: wasm.OpF32Mul, ir.OpDiv: wasm.OpF32Div},
+	wasm.F64: {ir.OpAdd: wasm.OpF64Add, ir.OpSub: wasm.OpF64Sub, ir.OpMul: wasm.OpF64Mul, ir.OpDiv: wasm.OpF64Div},
+}
+
+// floatArith pushes the result of the float arithmetic op on the values x
+// and y push, of type vt.
+func (f *funcGen) floatArith(op ir.Op, vt wasm.ValType, x, y func()) {
+	suffix := ""
+	if vt == wasm.F32 {
+		suffix = "32"
+	}
+	switch op {
+	case ir.OpAdd, ir.OpSub, ir.OpMul:
+		x()
+		y()
+		f.emit(floatOps[vt][op], 0)
+		f.call("tup_float" + suffix)
+	case ir.OpDiv:
+		x()
+		y()
+		f.call("tup_div_float" + suffix)
+	case ir.OpPow:
+		// Float32 is raised to a power as Float64
+		for _, v := range []func(){x, y} {
+			v()
+			if vt == wasm.F32 {
+				f.emit(wasm.OpF64PromoteF32, 0)
+			}
+		}
+		f.call("tup_pow_float")
+		if vt == wasm.F32 {
+			f.emit(wasm.OpF32DemoteF64, 0)
+			f.call("tup_float32")
+		}
+	default:
+		f.errorf("%s of Float is not supported by the WebAssembly backend", op)
+	}
+}
+
+// intArith pushes the result of the integer arithmetic op on the values x
+// and y push, of type t. Integers narrower than 64 bits are computed as
+// Int64 and checked against the bounds of t.
+func (f *funcGen) intArith(op ir.Op, t types.Type, x, y func()) {
+	name := "tup_" + op.String()
+	if types.IsUnsigned(t) && intBits(t) == 64 {
+		x()
+		y()
+		f.call(name + "_u")
+		return
+	}
+	f.int64(t, x)
+	f.int64(t, y)
+	if op != ir.OpMod && op != ir.OpShr {
+		f.bounds(t)
+	}
+	f.call(name)
+	if f.valType(t) == wasm.I32 {
+		f.emit(wasm.OpI32WrapI64, 0)
+	}
+}
+
+// bounds pushes the least and greatest values of the integer type t.
+func (f *funcGen) bounds(t types.Type) {
+	min, max := bounds(t)
+	f.emit(wasm.OpI64Const, min)
+	f.emit(wasm.OpI64Const, max)
+}
+
+// neg pushes the negation of the value x pushes, of type t.
+func (f *funcGen) neg(t types.Type, x func()) {
+	switch vt := f.valType(t); {
+	case vt == wasm.F32:
+		x()
+		f.emit(wasm.OpF32Neg, 0)
+	case vt == wasm.F64:
+		x()
+		f.emit(wasm.OpF64Neg, 0)
+	default:
+		f.intArith(ir.OpSub, t, func() { f.zero(vt) }, x)
+	}
+}
+
+// conv pushes the integer x pushes, of type from, as a value of the
+// integer type to, trapping if it does not fit.
+func (f *funcGen) conv(to, from types.Type, x func()) {
+	f.int64(from, x)
+	min, max := bounds(to)
+	switch {
+	case types.IsUnsigned(from) && intBits(from) == 64:
+		if !types.IsUnsigned(to) || intBits(to) < 64 {
+			f.emit(wasm.OpI64Const, max)
+			f.call("tup_conv_u")
+		}
+	case types.IsUnsigned(to) && intBits(to) == 64:
+		// every value of from that is not negative fits
+		f.emit(wasm.OpI64Const, 0)
+		f.emit(wasm.OpI64Const, math.MaxInt64)
+		f.call("tup_conv")
+	default:
+		f.emit(wasm.OpI64Const, min)
+		f.emit(wasm.OpI64Const, max)
+		f.call("tup_conv")
+	}
+	if f.valType(to) == wasm.I32 {
+		f.emit(wasm.OpI32WrapI64, 0)
+	}
+}
+
+// compare pushes the result of the comparison instr.
+func (f *funcGen) compare(instr *ir.Instr) {
+	t := instr.Args[0].Type()
+	x, y := f.value(instr.Args[0]), f.value(instr.Args[1])
+	switch instr.Op {
+	case ir.OpEq:
+		f.equal(t, x, y)
+		return
+	case ir.OpNe:
+		f.equal(t, x, y)
+		f.emit(wasm.OpI32Eqz, 0)
+		return
+	}
+	x()
+	y()
+	vt := f.valType(t)
+	if types.IsString(t) {
+		f.call("tup_string_cmp")
+		if instr.Op == ir.OpCmp {
+			return
+		}
+		f.emit(wasm.OpI64Const, 0)
+		vt = wasm.I64
+	}
+	if instr.Op != ir.OpCmp {
+		f.emit(relation(vt, types.IsUnsigned(t), instr.Op), 0)
+		return
+	}
+	// (x > y) - (x < y)
+	gt := f.temp(wasm.I32)
+	f.emit(relation(vt, types.IsUnsigned(t), ir.OpGt), 0)
+	f.emit(wasm.OpLocalSet, int64(gt))
+	f.emit(wasm.OpLocalGet, int64(gt))
+	x()
+	y()
+	f.emit(relation(vt, types.IsUnsigned(t), ir.OpLt), 0)
+	f.emit(wasm.OpI32Sub, 0)
+	f.emit(wasm.OpI64ExtendI32S, 0)
+}
+
+// relation returns the instruction comparing values of type vt.
+func relation(vt wasm.ValType, unsigned bool, op ir.Op) wasm.Op {
+	ops := map[wasm.ValType][4]wasm.Op{
+		wasm.I32: {wasm.OpI32LtS, wasm.OpI32LeS, wasm.OpI32GtS, wasm.OpI32GeS},
+		wasm.I64: {wasm.OpI64LtS, wasm.OpI64LeS, wasm.OpI64GtS, wasm.OpI64GeS},
+		wasm.F32: {wasm.OpF32Lt, wasm.OpF32Le, wasm.OpF32Gt, wasm.OpF32Ge},
+		wasm.F64: {wasm.OpF64Lt, wasm.OpF64Le, wasm.OpF64Gt, wasm.OpF64Ge},
+	}
+	if unsigned {
+		ops[wasm.I32] = [4]wasm.Op{wasm.OpI32LtU, wasm.OpI32LeU, wasm.OpI32GtU, wasm.OpI32GeU}
+		ops[wasm.I64] = [4]wasm.Op{wasm.OpI64LtU, wasm.OpI64LeU, wasm.OpI64GtU, wasm.OpI64GeU}
+	}
+	return ops[vt][op-ir.OpLt]
+}
+
+// checked computes the result of the checked arithmetic instr into its
+// local and pushes whether it is valid.
+func (f *funcGen) checked(instr *ir.Instr) {
+	t := instr.Typ
+	op := instr.Op.Unchecked()
+	x, y := f.value(instr.Args[0]), f.value(instr.Args[1])
+	vt := f.valType(t)
+	if types.IsFloat(t) {
+		if op == ir.OpMod {
+			f.errorf("%s of %s is not supported by the WebAssembly backend", instr.Op, t)
+		}
+		x()
+		y()
+		f.emit(floatOps[vt][op], 0)
+		f.set(instr)
+		f.emit(wasm.OpLocalGet, int64(f.values[instr]))
+		if vt == wasm.F32 {
+			f.emit(wasm.OpF64PromoteF32, 0)
+		}
+		f.call("tup_finite")
+		if op == ir.OpDiv {
+			y()
+			f.zero(vt)
+			f.emit(map[wasm.ValType]wasm.Op{wasm.F32: wasm.OpF32Ne, wasm.F64: wasm.OpF64Ne}[vt], 0)
+			f.emit(wasm.OpI32And, 0)
+		}
+		return
+	}
+	if !types.IsInteger(t) {
+		f.errorf("%s of %s is not supported by the WebAssembly backend", instr.Op, t)
+	}
+	name := "tup_" + op.String()
+	if types.IsUnsigned(t) && intBits(t) == 64 {
+		x()
+		y()
+		f.call(name + "_u_ok")
+	} else {
+		f.int64(t, x)
+		f.int64(t, y)
+		if op != ir.OpMod {
+			f.bounds(t)
+		}
+		f.call(name + "_ok")
+	}
+	ok := f.temp(wasm.I32)
+	f.emit(wasm.OpLocalSet, int64(ok))
+	if vt == wasm.I32 {
+		f.emit(wasm.OpI32WrapI64, 0)
+	}
+	f.set(instr)
+	f.emit(wasm.OpLocalGet, int64(ok))
+}
+
+func (f *funcGen) callInstr(instr *ir.Instr) {
+	args := instr.Args
+	var sig *types.Function
+	if instr.Callee == "" {
+		// the trampoline of a function value takes its block first
+		sig = args[0].Type().Underlying().(*types.Function)
+		for _, arg := range args {
+			f.value(arg)()
+		}
+		f.value(args[0])()
+		f.memory(wasm.OpI32Load, 0, 4)
+		f.emit(wasm.OpCallIndirect, int64(f.closureType(sig)))
+	} else {
+		fn := f.module.Func(instr.Callee)
+		index, ok := f.funcs[instr.Callee]
+		if fn == nil || !ok {
+			f.errorf("undefined function %s", instr.Callee)
+		}
+		sig = fn.Sig
+		for _, arg := range args {
+			f.value(arg)()
+		}
+		f.emit(wasm.OpCall, int64(index))
+	}
+	switch {
+	case instr.Typ != nil:
+		f.set(instr)
+	case sig.Result != nil:
+		f.emit(wasm.OpDrop, 0)
+	}
+}
+
+// constant pushes the value of the const instr.
+func (f *funcGen) constant(instr *ir.Instr) {
+	vt := f.valType(instr.Typ)
+	switch c := instr.Const.(type) {
+	case *consteval.Int:
+		switch vt {
+		case wasm.F32, wasm.F64:
+			v, _ := new(big.Float).SetInt(c.Val).Float64()
+			f.float(vt, v)
+		case wasm.I64:
+			v := c.Val.Int64()
+			if !c.Val.IsInt64() {
+				v = int64(c.Val.Uint64())
+			}
+			f.emit(wasm.OpI64Const, v)
+		default:
+			// UInt32 values above the greatest Int32 keep their bits
+			f.emit(wasm.OpI32Const, int64(int32(c.Val.Int64())))
+		}
+	case *consteval.Float:
+		f.float(vt, c.Val)
+	case consteval.Bool:
+		v := int64(0)
+		if c {
+			v = 1
+		}
+		f.emit(wasm.OpI32Const, v)
+	case consteval.String:
+		f.emit(wasm.OpI32Const, int64(f.literal(string(c))))
+	case consteval.Symbol:
+		f.emit(wasm.OpI32Const, int64(f.literal(string(c))))
+	case consteval.Nil:
+		f.emit(wasm.OpI32Const, 0)
+	case *consteval.Enum:
+		f.emit(wasm.OpI64Const, c.Member.Value)
+	case *consteval.ErrorValue:
+		f.emit(wasm.OpI32Const, int64(f.literal(c.Msg)))
+	case *consteval.TypeValue:
+		f.emit(wasm.OpI32Const, int64(f.descriptor(c.Typ)))
+	default:
+		f.errorf("constant %s has no WebAssembly form", instr.Const)
+	}
+}
+
+// float pushes the float v as a value of type vt.
+func (c *code) float(vt wasm.ValType, v float64) {
+	if vt == wasm.F32 {
+		c.fn.Body = append(c.fn.Body, wasm.Instr{Op: wasm.OpF32Const, F: float64(float32(v))})
+	} else {
+		c.fn.Body = append(c.fn.Body, wasm.Instr{Op: wasm.OpF64Const, F: v})
+	}
+}
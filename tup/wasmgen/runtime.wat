@@ -0,0 +1,2619 @@
+;; The runtime of the modules generated by the Tuppence WebAssembly backend,
+;; which is linked into each of them, less the functions they do not call.
+;; It provides allocation, strings, dynamic arrays, the arithmetic that
+;; traps, which stops the program with a runtime error, and the text of
+;; values as print writes it.
+;;
+;; Memory is allocated from $tup_heap upwards, growing the memory as need
+;; be. Strings, arrays, tuples, unions and the blocks of closures are
+;; objects, each behind a header of 16 bytes: the number of references to
+;; it, an i32, negative for constants, which are not counted; the kind of
+;; the function visiting the objects it refers to, an i32; the size of its
+;; block, header included, an i32; then its color in cycle collection
+;; (0 black, 1 gray, 2 white, 3 purple), whether it is among the possible
+;; roots of cycles and whether it may be part of a cycle, a byte each. An
+;; object is freed once no reference to it is left, releasing those it
+;; holds, and its block kept for reuse; the cycles only closures can form
+;; are freed by trial deletion, as the C backend's runtime does.
+;;
+;; A String is the address of its length, an i32, and its bytes. A dynamic
+;; array is the address of its length and of the slab holding its
+;; elements, which holds the number of elements used and its capacity,
+;; both i32, then from offset 8 the elements. Appending to the array that
+;; ends where the slab's elements do fills the slab in place, so that the
+;; arrays built by appending to each other in turn share it; appending to
+;; any other array copies it. The slab holds the references of all its
+;; elements. Integer arithmetic is done in 64 bits and checked against the
+;; bounds of the type, given as $min and $max.
+;;
+;; The globals named $lit_* hold the addresses of strings the generator
+;; places in the module's data, and $tup_heap the end of the data.
+(module
+  ;; $tup_format_float writes the text of a float to memory at the address
+  ;; given, 32 bytes at most, and returns its length.
+  (import "tup" "format_float" (func $tup_format_float (param f64 i32) (result i32)))
+  (import "tup" "pow" (func $tup_host_pow (param f64 f64) (result f64)))
+
+  (global $tup_heap (mut i32) (i32.const 0))
+  ;; $tup_classes holds the address of the heads of the free lists of the
+  ;; blocks of less than 512 bytes, by size, and $tup_large the head of the
+  ;; list of larger ones.
+  (global $tup_classes (mut i32) (i32.const 0))
+  (global $tup_large (mut i32) (i32.const 0))
+  ;; the blocks holding the possible roots of cycles and the objects being
+  ;; released, and their numbers
+  (global $tup_roots (mut i32) (i32.const 0))
+  (global $tup_roots_len (mut i32) (i32.const 0))
+  (global $tup_dead (mut i32) (i32.const 0))
+  (global $tup_dead_len (mut i32) (i32.const 0))
+  (global $tup_releasing (mut i32) (i32.const 0))
+  (global $tup_allocs (mut i64) (i64.const 0))
+  (global $tup_reused (mut i64) (i64.const 0))
+  (global $tup_frees (mut i64) (i64.const 0))
+  (global $tup_live (mut i64) (i64.const 0))
+  (global $tup_max_live (mut i64) (i64.const 0))
+  (global $tup_collections (mut i64) (i64.const 0))
+  (global $tup_collected (mut i64) (i64.const 0))
+  ;; $tup_panic_msg holds the message of the runtime error that stopped
+  ;; the program, for the host to report.
+  (global $tup_panic_msg (mut i32) (i32.const 0))
+  (global $lit_overflow i32 (i32.const 0))
+  (global $lit_division i32 (i32.const 0))
+  (global $lit_exponent i32 (i32.const 0))
+  (global $lit_shift i32 (i32.const 0))
+  (global $lit_float i32 (i32.const 0))
+  (global $lit_memory i32 (i32.const 0))
+  (global $lit_index i32 (i32.const 0))
+  (global $lit_range i32 (i32.const 0))
+  (global $lit_slice i32 (i32.const 0))
+  (global $lit_dots i32 (i32.const 0))
+  (global $lit_sliced i32 (i32.const 0))
+  (global $lit_true i32 (i32.const 0))
+  (global $lit_false i32 (i32.const 0))
+  (global $lit_nil i32 (i32.const 0))
+  (global $lit_error i32 (i32.const 0))
+  (global $lit_allocs i32 (i32.const 0))
+  (global $lit_reused i32 (i32.const 0))
+  (global $lit_frees i32 (i32.const 0))
+  (global $lit_live i32 (i32.const 0))
+  (global $lit_max i32 (i32.const 0))
+  (global $lit_collections i32 (i32.const 0))
+  (global $lit_freed i32 (i32.const 0))
+
+  ;; Traps.
+
+  (func $tup_panic (param $msg i32)
+    local.get $msg
+    global.set $tup_panic_msg
+    unreachable
+  )
+
+  (func $tup_overflow
+    global.get $lit_overflow
+    call $tup_panic
+  )
+
+  ;; The heap. $tup_bump takes size bytes from the end of the heap, which
+  ;; are zeroed as memory never used before is.
+
+  (func $tup_bump (param $size i32) (result i32)
+    (local $p i32) (local $end i32)
+    global.get $tup_heap
+    i32.const 7
+    i32.add
+    i32.const -8
+    i32.and
+    local.tee $p
+    local.get $size
+    i32.add
+    local.tee $end
+    local.get $p
+    i32.lt_u
+    if
+      global.get $lit_memory
+      call $tup_panic
+    end
+    block $fits
+      local.get $end
+      memory.size
+      i32.const 16
+      i32.shl
+      i32.le_u
+      br_if $fits
+      local.get $end
+      memory.size
+      i32.const 16
+      i32.shl
+      i32.sub
+      i32.const 65535
+      i32.add
+      i32.const 16
+      i32.shr_u
+      memory.grow
+      i32.const -1
+      i32.ne
+      br_if $fits
+      global.get $lit_memory
+      call $tup_panic
+    end
+    local.get $end
+    global.set $tup_heap
+    local.get $p
+  )
+
+  ;; $tup_block returns a zeroed block of at least size bytes, a multiple
+  ;; of 16, with its size at offset 8. A block of less than 512 bytes is
+  ;; taken from the free list of its size if it is not empty, and a larger
+  ;; one from the first on the list of large blocks that is big enough.
+  (func $tup_block (param $size i32) (result i32)
+    (local $head i32) (local $h i32) (local $prev i32)
+    local.get $size
+    i32.const 15
+    i32.add
+    i32.const -16
+    i32.and
+    local.set $size
+    global.get $tup_classes
+    i32.eqz
+    if
+      i32.const 128
+      call $tup_bump
+      global.set $tup_classes
+    end
+    block $reuse
+      block $bump
+        local.get $size
+        i32.const 512
+        i32.lt_u
+        if
+          global.get $tup_classes
+          local.get $size
+          i32.const 2
+          i32.shr_u
+          i32.add
+          local.tee $head
+          i32.load
+          local.tee $h
+          i32.eqz
+          br_if $bump
+          local.get $head
+          local.get $h
+          i32.load
+          i32.store
+          br $reuse
+        end
+        global.get $tup_large
+        local.set $h
+        loop $next
+          local.get $h
+          i32.eqz
+          br_if $bump
+          local.get $h
+          i32.load offset=8
+          local.get $size
+          i32.ge_u
+          if
+            local.get $prev
+            if
+              local.get $prev
+              local.get $h
+              i32.load
+              i32.store
+            else
+              local.get $h
+              i32.load
+              global.set $tup_large
+            end
+            local.get $h
+            i32.load offset=8
+            local.set $size
+            br $reuse
+          end
+          local.get $h
+          local.set $prev
+          local.get $h
+          i32.load
+          local.set $h
+          br $next
+        end
+      end
+      local.get $size
+      call $tup_bump
+      local.tee $h
+      local.get $size
+      i32.store offset=8
+      local.get $h
+      return
+    end
+    local.get $h
+    i32.const 0
+    local.get $size
+    memory.fill
+    local.get $h
+    local.get $size
+    i32.store offset=8
+    global.get $tup_reused
+    i64.const 1
+    i64.add
+    global.set $tup_reused
+    local.get $h
+  )
+
+  ;; $tup_free_block puts the block h on the free list of its size, linked
+  ;; through its first word.
+  (func $tup_free_block (param $h i32)
+    (local $head i32)
+    local.get $h
+    i32.load offset=8
+    i32.const 512
+    i32.lt_u
+    if
+      local.get $h
+      global.get $tup_classes
+      local.get $h
+      i32.load offset=8
+      i32.const 2
+      i32.shr_u
+      i32.add
+      local.tee $head
+      i32.load
+      i32.store
+      local.get $head
+      local.get $h
+      i32.store
+      return
+    end
+    local.get $h
+    global.get $tup_large
+    i32.store
+    local.get $h
+    global.set $tup_large
+  )
+
+  ;; $tup_push pushes h onto the stack held from offset 16 of the block s,
+  ;; of len objects, returning the block, grown if need be.
+  (func $tup_push (param $s i32) (param $len i32) (param $h i32) (result i32)
+    (local $grown i32)
+    local.get $s
+    i32.eqz
+    if (result i32)
+      i32.const 1
+    else
+      local.get $len
+      i32.const 2
+      i32.shl
+      i32.const 16
+      i32.add
+      local.get $s
+      i32.load offset=8
+      i32.ge_u
+    end
+    if
+      local.get $len
+      i32.const 3
+      i32.shl
+      i32.const 272
+      i32.add
+      call $tup_block
+      local.set $grown
+      local.get $s
+      if
+        local.get $grown
+        i32.const 16
+        i32.add
+        local.get $s
+        i32.const 16
+        i32.add
+        local.get $len
+        i32.const 2
+        i32.shl
+        memory.copy
+        local.get $s
+        call $tup_free_block
+      end
+      local.get $grown
+      local.set $s
+    end
+    local.get $s
+    local.get $len
+    i32.const 2
+    i32.shl
+    i32.add
+    local.get $h
+    i32.store offset=16
+    local.get $s
+  )
+
+  ;; $tup_new returns a new object of size bytes with a reference, whose
+  ;; references the visit function of the given kind visits, and which may
+  ;; be part of a cycle if cyclic.
+  (func $tup_new (param $size i32) (param $kind i32) (param $cyclic i32) (result i32)
+    (local $h i32)
+    global.get $tup_roots_len
+    i32.const 10000
+    i32.ge_u
+    if
+      call $tup_collect
+    end
+    local.get $size
+    i32.const 16
+    i32.add
+    call $tup_block
+    local.tee $h
+    i32.const 1
+    i32.store
+    local.get $h
+    local.get $kind
+    i32.store offset=4
+    local.get $h
+    local.get $cyclic
+    i32.store8 offset=14
+    global.get $tup_allocs
+    i64.const 1
+    i64.add
+    global.set $tup_allocs
+    global.get $tup_live
+    i64.const 1
+    i64.add
+    global.set $tup_live
+    global.get $tup_live
+    global.get $tup_max_live
+    i64.gt_s
+    if
+      global.get $tup_live
+      global.set $tup_max_live
+    end
+    local.get $h
+    i32.const 16
+    i32.add
+  )
+
+  ;; $tup_alloc returns a new object of size bytes that refers to no other,
+  ;; such as a string.
+  (func $tup_alloc (param $size i32) (result i32)
+    local.get $size
+    i32.const 0
+    i32.const 0
+    call $tup_new
+  )
+
+  (func $tup_free (param $h i32)
+    global.get $tup_frees
+    i64.const 1
+    i64.add
+    global.set $tup_frees
+    global.get $tup_live
+    i64.const 1
+    i64.sub
+    global.set $tup_live
+    local.get $h
+    call $tup_free_block
+  )
+
+  ;; $tup_counted returns the header of obj, or 0 if obj is 0 or static.
+  (func $tup_counted (param $obj i32) (result i32)
+    (local $h i32)
+    local.get $obj
+    i32.eqz
+    if
+      i32.const 0
+      return
+    end
+    local.get $obj
+    i32.const 16
+    i32.sub
+    local.tee $h
+    i32.load
+    i32.const 0
+    i32.lt_s
+    if
+      i32.const 0
+      return
+    end
+    local.get $h
+  )
+
+  (func $tup_retain (param $obj i32)
+    (local $h i32)
+    local.get $obj
+    call $tup_counted
+    local.tee $h
+    if
+      local.get $h
+      local.get $h
+      i32.load
+      i32.const 1
+      i32.add
+      i32.store
+    end
+  )
+
+  ;; $tup_possible_root records that the object of h, which is still
+  ;; referenced after one of its references was released, may be the root
+  ;; of a garbage cycle.
+  (func $tup_possible_root (param $h i32)
+    local.get $h
+    i32.load8_u offset=14
+    i32.eqz
+    local.get $h
+    i32.load8_u offset=12
+    i32.const 3
+    i32.eq
+    i32.or
+    if
+      return
+    end
+    local.get $h
+    i32.const 3
+    i32.store8 offset=12
+    local.get $h
+    i32.load8_u offset=13
+    if
+      return
+    end
+    local.get $h
+    i32.const 1
+    i32.store8 offset=13
+    global.get $tup_roots
+    global.get $tup_roots_len
+    local.get $h
+    call $tup_push
+    global.set $tup_roots
+    global.get $tup_roots_len
+    i32.const 1
+    i32.add
+    global.set $tup_roots_len
+  )
+
+  ;; $tup_release drops a reference to obj, freeing it once none is left.
+  ;; The objects freed are released without recursion, as chains of
+  ;; closures may be long.
+  (func $tup_release (param $obj i32)
+    (local $h i32) (local $refs i32)
+    local.get $obj
+    call $tup_counted
+    local.tee $h
+    i32.eqz
+    if
+      return
+    end
+    local.get $h
+    local.get $h
+    i32.load
+    i32.const 1
+    i32.sub
+    local.tee $refs
+    i32.store
+    local.get $refs
+    i32.const 0
+    i32.gt_s
+    if
+      local.get $h
+      call $tup_possible_root
+      return
+    end
+    global.get $tup_dead
+    global.get $tup_dead_len
+    local.get $h
+    call $tup_push
+    global.set $tup_dead
+    global.get $tup_dead_len
+    i32.const 1
+    i32.add
+    global.set $tup_dead_len
+    global.get $tup_releasing
+    if
+      return
+    end
+    i32.const 1
+    global.set $tup_releasing
+    block $done
+      loop $next
+        global.get $tup_dead_len
+        i32.eqz
+        br_if $done
+        global.get $tup_dead_len
+        i32.const 1
+        i32.sub
+        global.set $tup_dead_len
+        global.get $tup_dead
+        global.get $tup_dead_len
+        i32.const 2
+        i32.shl
+        i32.add
+        i32.load offset=16
+        local.tee $h
+        i32.const 16
+        i32.add
+        i32.const 1
+        call $tup_visit_object
+        local.get $h
+        i32.const 0
+        i32.store8 offset=12
+        local.get $h
+        i32.load8_u offset=13
+        i32.eqz
+        if
+          local.get $h
+          call $tup_free
+        end
+        br $next
+      end
+    end
+    i32.const 0
+    global.set $tup_releasing
+  )
+
+  ;; $tup_visit applies the operation op to the object obj, which another
+  ;; refers to: 0 retains it and 1 releases it, and 2 to 5 take the steps
+  ;; of cycle collection described by $tup_mark_gray, $tup_scan_black,
+  ;; $tup_scan and $tup_collect_white to it.
+  (func $tup_visit (param $obj i32) (param $op i32)
+    (local $h i32)
+    local.get $op
+    i32.const 1
+    i32.eq
+    if
+      local.get $obj
+      call $tup_release
+      return
+    end
+    local.get $obj
+    call $tup_counted
+    local.tee $h
+    i32.eqz
+    if
+      return
+    end
+    local.get $op
+    i32.eqz
+    if
+      local.get $h
+      local.get $h
+      i32.load
+      i32.const 1
+      i32.add
+      i32.store
+      return
+    end
+    local.get $op
+    i32.const 2
+    i32.eq
+    if
+      local.get $h
+      local.get $h
+      i32.load
+      i32.const 1
+      i32.sub
+      i32.store
+      local.get $h
+      call $tup_mark_gray
+      return
+    end
+    local.get $op
+    i32.const 3
+    i32.eq
+    if
+      local.get $h
+      local.get $h
+      i32.load
+      i32.const 1
+      i32.add
+      i32.store
+      local.get $h
+      i32.load8_u offset=12
+      if
+        local.get $h
+        call $tup_scan_black
+      end
+      return
+    end
+    local.get $op
+    i32.const 4
+    i32.eq
+    if
+      local.get $h
+      call $tup_scan
+      return
+    end
+    local.get $h
+    call $tup_collect_white
+  )
+
+  ;; $tup_visit_object calls $tup_visit with op and each object obj refers
+  ;; to, by the kind of its visit function: 0 if it refers to none, -1 for
+  ;; a dynamic array, which refers to its slab, or the number of one the
+  ;; generator defines.
+  (func $tup_visit_object (param $obj i32) (param $op i32)
+    (local $kind i32)
+    local.get $obj
+    i32.const 16
+    i32.sub
+    i32.load offset=4
+    local.tee $kind
+    i32.eqz
+    if
+      return
+    end
+    local.get $kind
+    i32.const -1
+    i32.eq
+    if
+      local.get $obj
+      i32.load offset=4
+      local.get $op
+      call $tup_visit
+      return
+    end
+    local.get $obj
+    local.get $op
+    local.get $kind
+    call $tup_visit_kind
+  )
+
+  ;; $tup_visit_kind calls the visit function of the given kind with obj
+  ;; and op. The generator supplies its body.
+  (func $tup_visit_kind (param $obj i32) (param $op i32) (param $kind i32)
+  )
+
+  ;; $tup_mark_gray removes the references among the objects reachable
+  ;; from the object of h.
+  (func $tup_mark_gray (param $h i32)
+    local.get $h
+    i32.load8_u offset=12
+    i32.const 1
+    i32.eq
+    if
+      return
+    end
+    local.get $h
+    i32.const 1
+    i32.store8 offset=12
+    local.get $h
+    i32.const 16
+    i32.add
+    i32.const 2
+    call $tup_visit_object
+  )
+
+  ;; $tup_scan_black restores the references among the objects reachable
+  ;; from the object of h, which is referenced from outside them.
+  (func $tup_scan_black (param $h i32)
+    local.get $h
+    i32.const 0
+    i32.store8 offset=12
+    local.get $h
+    i32.const 16
+    i32.add
+    i32.const 3
+    call $tup_visit_object
+  )
+
+  ;; $tup_scan marks white the objects reachable from the object of h that
+  ;; are referenced only from among them, and restores the references of
+  ;; the others.
+  (func $tup_scan (param $h i32)
+    local.get $h
+    i32.load8_u offset=12
+    i32.const 1
+    i32.ne
+    if
+      return
+    end
+    local.get $h
+    i32.load
+    i32.const 0
+    i32.gt_s
+    if
+      local.get $h
+      call $tup_scan_black
+      return
+    end
+    local.get $h
+    i32.const 2
+    i32.store8 offset=12
+    local.get $h
+    i32.const 16
+    i32.add
+    i32.const 4
+    call $tup_visit_object
+  )
+
+  ;; $tup_collect_white frees the white objects reachable from the object
+  ;; of h.
+  (func $tup_collect_white (param $h i32)
+    local.get $h
+    i32.load8_u offset=12
+    i32.const 2
+    i32.ne
+    local.get $h
+    i32.load8_u offset=13
+    i32.or
+    if
+      return
+    end
+    local.get $h
+    i32.const 0
+    i32.store8 offset=12
+    local.get $h
+    i32.const 16
+    i32.add
+    i32.const 5
+    call $tup_visit_object
+    global.get $tup_collected
+    i64.const 1
+    i64.add
+    global.set $tup_collected
+    local.get $h
+    call $tup_free
+  )
+
+  ;; $tup_root returns the i-th possible root of cycles.
+  (func $tup_root (param $i i32) (result i32)
+    global.get $tup_roots
+    local.get $i
+    i32.const 2
+    i32.shl
+    i32.add
+    i32.load offset=16
+  )
+
+  ;; $tup_collect frees the garbage cycles reachable from the possible roots
+  ;; of cycles: those that removing the references among their objects
+  ;; leaves unreferenced.
+  (func $tup_collect
+    (local $i i32) (local $n i32) (local $h i32)
+    global.get $tup_roots_len
+    i32.eqz
+    if
+      return
+    end
+    global.get $tup_collections
+    i64.const 1
+    i64.add
+    global.set $tup_collections
+    block $done
+      loop $next
+        local.get $i
+        global.get $tup_roots_len
+        i32.ge_u
+        br_if $done
+        local.get $i
+        call $tup_root
+        local.set $h
+        local.get $i
+        i32.const 1
+        i32.add
+        local.set $i
+        local.get $h
+        i32.load8_u offset=12
+        i32.const 3
+        i32.eq
+        local.get $h
+        i32.load
+        i32.const 0
+        i32.gt_s
+        i32.and
+        if
+          local.get $h
+          call $tup_mark_gray
+          global.get $tup_roots
+          local.get $n
+          i32.const 2
+          i32.shl
+          i32.add
+          local.get $h
+          i32.store offset=16
+          local.get $n
+          i32.const 1
+          i32.add
+          local.set $n
+          br $next
+        end
+        local.get $h
+        i32.const 0
+        i32.store8 offset=13
+        local.get $h
+        i32.load8_u offset=12
+        local.get $h
+        i32.load
+        i32.or
+        i32.eqz
+        if
+          local.get $h
+          call $tup_free
+        end
+        br $next
+      end
+    end
+    i32.const 0
+    local.set $i
+    block $done
+      loop $next
+        local.get $i
+        local.get $n
+        i32.ge_u
+        br_if $done
+        local.get $i
+        call $tup_root
+        call $tup_scan
+        local.get $i
+        i32.const 1
+        i32.add
+        local.set $i
+        br $next
+      end
+    end
+    i32.const 0
+    local.set $i
+    block $done
+      loop $next
+        local.get $i
+        local.get $n
+        i32.ge_u
+        br_if $done
+        local.get $i
+        call $tup_root
+        local.tee $h
+        i32.const 0
+        i32.store8 offset=13
+        local.get $h
+        call $tup_collect_white
+        local.get $i
+        i32.const 1
+        i32.add
+        local.set $i
+        br $next
+      end
+    end
+    i32.const 0
+    global.set $tup_roots_len
+  )
+
+  ;; $tup_exit collects the cycles left when the program ends, and returns
+  ;; the statistics of the heap as a string for the host to write.
+  (func $tup_exit (result i32)
+    (local $allocs i64) (local $reused i64) (local $frees i64) (local $live i64)
+    (local $max i64) (local $collections i64) (local $collected i64) (local $b i32)
+    call $tup_collect
+    global.get $tup_allocs
+    local.set $allocs
+    global.get $tup_reused
+    local.set $reused
+    global.get $tup_frees
+    local.set $frees
+    global.get $tup_live
+    local.set $live
+    global.get $tup_max_live
+    local.set $max
+    global.get $tup_collections
+    local.set $collections
+    global.get $tup_collected
+    local.set $collected
+    call $tup_builder_new
+    local.tee $b
+    global.get $lit_allocs
+    call $tup_puts
+    local.get $b
+    local.get $allocs
+    call $tup_fmt_int
+    local.get $b
+    global.get $lit_reused
+    call $tup_puts
+    local.get $b
+    local.get $reused
+    call $tup_fmt_int
+    local.get $b
+    global.get $lit_frees
+    call $tup_puts
+    local.get $b
+    local.get $frees
+    call $tup_fmt_int
+    local.get $b
+    global.get $lit_live
+    call $tup_puts
+    local.get $b
+    local.get $live
+    call $tup_fmt_int
+    local.get $b
+    global.get $lit_max
+    call $tup_puts
+    local.get $b
+    local.get $max
+    call $tup_fmt_int
+    local.get $b
+    global.get $lit_collections
+    call $tup_puts
+    local.get $b
+    local.get $collections
+    call $tup_fmt_int
+    local.get $b
+    global.get $lit_freed
+    call $tup_puts
+    local.get $b
+    local.get $collected
+    call $tup_fmt_int
+    local.get $b
+    i32.const 41
+    call $tup_write_byte
+    local.get $b
+    call $tup_builder_string
+  )
+
+  ;; Strings.
+
+  (func $tup_string_new (param $len i32) (result i32)
+    (local $s i32)
+    local.get $len
+    i32.const 4
+    i32.add
+    call $tup_alloc
+    local.tee $s
+    local.get $len
+    i32.store
+    local.get $s
+  )
+
+  (func $tup_string_concat (param $x i32) (param $y i32) (result i32)
+    (local $xn i32) (local $yn i32) (local $s i32)
+    local.get $x
+    i32.load
+    local.tee $xn
+    i32.eqz
+    if
+      local.get $y
+      call $tup_retain
+      local.get $y
+      return
+    end
+    local.get $y
+    i32.load
+    local.tee $yn
+    i32.eqz
+    if
+      local.get $x
+      call $tup_retain
+      local.get $x
+      return
+    end
+    local.get $xn
+    local.get $yn
+    i32.add
+    call $tup_string_new
+    local.tee $s
+    i32.const 4
+    i32.add
+    local.get $x
+    i32.const 4
+    i32.add
+    local.get $xn
+    memory.copy
+    local.get $s
+    i32.const 4
+    i32.add
+    local.get $xn
+    i32.add
+    local.get $y
+    i32.const 4
+    i32.add
+    local.get $yn
+    memory.copy
+    local.get $s
+  )
+
+  ;; $tup_memcmp compares n bytes at a and b, returning -1, 0 or 1.
+  (func $tup_memcmp (param $a i32) (param $b i32) (param $n i32) (result i32)
+    (local $i i32) (local $x i32) (local $y i32)
+    block $done
+      loop $next
+        local.get $i
+        local.get $n
+        i32.ge_u
+        br_if $done
+        local.get $a
+        local.get $i
+        i32.add
+        i32.load8_u
+        local.tee $x
+        local.get $b
+        local.get $i
+        i32.add
+        i32.load8_u
+        local.tee $y
+        i32.ne
+        if
+          i32.const -1
+          i32.const 1
+          local.get $x
+          local.get $y
+          i32.lt_u
+          select
+          return
+        end
+        local.get $i
+        i32.const 1
+        i32.add
+        local.set $i
+        br $next
+      end
+    end
+    i32.const 0
+  )
+
+  (func $tup_string_eq (param $x i32) (param $y i32) (result i32)
+    local.get $x
+    i32.load
+    local.get $y
+    i32.load
+    i32.ne
+    if
+      i32.const 0
+      return
+    end
+    local.get $x
+    i32.const 4
+    i32.add
+    local.get $y
+    i32.const 4
+    i32.add
+    local.get $x
+    i32.load
+    call $tup_memcmp
+    i32.eqz
+  )
+
+  (func $tup_string_cmp (param $x i32) (param $y i32) (result i64)
+    (local $xn i32) (local $yn i32) (local $c i32)
+    local.get $x
+    i32.load
+    local.set $xn
+    local.get $y
+    i32.load
+    local.set $yn
+    local.get $x
+    i32.const 4
+    i32.add
+    local.get $y
+    i32.const 4
+    i32.add
+    local.get $xn
+    local.get $yn
+    local.get $xn
+    local.get $yn
+    i32.lt_u
+    select
+    call $tup_memcmp
+    local.tee $c
+    if
+      local.get $c
+      i64.extend_i32_s
+      return
+    end
+    local.get $xn
+    local.get $yn
+    i32.gt_u
+    local.get $xn
+    local.get $yn
+    i32.lt_u
+    i32.sub
+    i64.extend_i32_s
+  )
+
+  ;; $tup_index returns the index i of a string or array of length len,
+  ;; trapping if it is out of range.
+  (func $tup_index (param $i i64) (param $len i32) (result i32)
+    (local $b i32)
+    local.get $i
+    local.get $len
+    i64.extend_i32_u
+    i64.ge_u
+    if
+      call $tup_builder_new
+      local.tee $b
+      global.get $lit_index
+      call $tup_puts
+      local.get $b
+      local.get $i
+      call $tup_fmt_int
+      local.get $b
+      global.get $lit_range
+      call $tup_puts
+      local.get $b
+      local.get $len
+      i64.extend_i32_u
+      call $tup_fmt_int
+      local.get $b
+      i32.const 93
+      call $tup_write_byte
+      local.get $b
+      call $tup_builder_string
+      call $tup_panic
+    end
+    local.get $i
+    i32.wrap_i64
+  )
+
+  ;; $tup_slice returns the number of elements lo through hi of a string
+  ;; or array of length len, trapping if they do not lie within it.
+  (func $tup_slice (param $lo i64) (param $hi i64) (param $len i32) (result i32)
+    (local $b i32)
+    local.get $lo
+    i64.const 0
+    i64.lt_s
+    local.get $hi
+    local.get $lo
+    i64.const 1
+    i64.sub
+    i64.lt_s
+    i32.or
+    local.get $hi
+    local.get $len
+    i64.extend_i32_u
+    i64.ge_s
+    i32.or
+    if
+      call $tup_builder_new
+      local.tee $b
+      global.get $lit_slice
+      call $tup_puts
+      local.get $b
+      local.get $lo
+      call $tup_fmt_int
+      local.get $b
+      global.get $lit_dots
+      call $tup_puts
+      local.get $b
+      local.get $hi
+      call $tup_fmt_int
+      local.get $b
+      global.get $lit_sliced
+      call $tup_puts
+      local.get $b
+      local.get $len
+      i64.extend_i32_u
+      call $tup_fmt_int
+      local.get $b
+      i32.const 93
+      call $tup_write_byte
+      local.get $b
+      call $tup_builder_string
+      call $tup_panic
+    end
+    local.get $hi
+    local.get $lo
+    i64.sub
+    i32.wrap_i64
+    i32.const 1
+    i32.add
+  )
+
+  (func $tup_string_slice (param $s i32) (param $lo i64) (param $hi i64) (result i32)
+    (local $n i32) (local $r i32)
+    local.get $lo
+    local.get $hi
+    local.get $s
+    i32.load
+    call $tup_slice
+    local.tee $n
+    call $tup_string_new
+    local.tee $r
+    i32.const 4
+    i32.add
+    local.get $s
+    i32.const 4
+    i32.add
+    local.get $lo
+    i32.wrap_i64
+    i32.add
+    local.get $n
+    memory.copy
+    local.get $r
+  )
+
+  (func $tup_string_byte (param $s i32) (param $i i64) (result i32)
+    local.get $s
+    i32.const 4
+    i32.add
+    local.get $i
+    local.get $s
+    i32.load
+    call $tup_index
+    i32.add
+    i32.load8_u
+  )
+
+  ;; Dynamic arrays. The elements of an array are size bytes each, and
+  ;; its slab is visited by the visit function of the given kind. The
+  ;; array and its slab may be part of a cycle if cyclic.
+
+  (func $tup_array_new (param $len i32) (param $size i32) (param $kind i32) (param $cyclic i32) (result i32)
+    (local $a i32) (local $slab i32)
+    i32.const 8
+    i32.const -1
+    local.get $cyclic
+    call $tup_new
+    local.set $a
+    local.get $len
+    if
+      local.get $len
+      local.get $size
+      i32.mul
+      i32.const 8
+      i32.add
+      local.get $kind
+      local.get $cyclic
+      call $tup_new
+      local.tee $slab
+      local.get $len
+      i32.store
+      local.get $slab
+      local.get $len
+      i32.store offset=4
+      local.get $a
+      local.get $slab
+      i32.store offset=4
+    end
+    local.get $a
+    local.get $len
+    i32.store
+    local.get $a
+  )
+
+  ;; $tup_array_elem returns the address of element i of a, trapping if
+  ;; it is out of range.
+  (func $tup_array_elem (param $a i32) (param $i i64) (param $size i32) (result i32)
+    local.get $a
+    i32.load offset=4
+    i32.const 8
+    i32.add
+    local.get $i
+    local.get $a
+    i32.load
+    call $tup_index
+    local.get $size
+    i32.mul
+    i32.add
+  )
+
+  ;; $tup_array_append returns a with room for one more element, the last,
+  ;; which the caller stores, adding the reference of the slab to it.
+  (func $tup_array_append (param $a i32) (param $size i32) (param $kind i32) (param $cyclic i32) (result i32)
+    (local $len i32) (local $slab i32) (local $cap i32) (local $grown i32) (local $r i32)
+    ;; the array is allocated first, while the slab holds the references
+    ;; of all its elements, in case the allocation collects cycles
+    i32.const 8
+    i32.const -1
+    local.get $cyclic
+    call $tup_new
+    local.set $r
+    local.get $a
+    i32.load
+    local.set $len
+    local.get $a
+    i32.load offset=4
+    local.set $slab
+    block $shared
+      local.get $slab
+      if
+        local.get $slab
+        i32.load
+        local.get $len
+        i32.eq
+        local.get $slab
+        i32.load
+        local.get $slab
+        i32.load offset=4
+        i32.ne
+        i32.and
+        if
+          local.get $slab
+          call $tup_retain
+          br $shared
+        end
+      end
+      i32.const 8
+      local.get $len
+      i32.const 1
+      i32.shl
+      local.get $len
+      i32.const 4
+      i32.lt_u
+      select
+      local.tee $cap
+      local.get $size
+      i32.mul
+      i32.const 8
+      i32.add
+      local.get $kind
+      local.get $cyclic
+      call $tup_new
+      local.tee $grown
+      local.get $len
+      i32.store
+      local.get $grown
+      local.get $cap
+      i32.store offset=4
+      local.get $len
+      if
+        local.get $grown
+        i32.const 8
+        i32.add
+        local.get $slab
+        i32.const 8
+        i32.add
+        local.get $len
+        local.get $size
+        i32.mul
+        memory.copy
+        local.get $grown
+        i32.const 0
+        call $tup_visit_object
+      end
+      local.get $grown
+      local.set $slab
+    end
+    local.get $slab
+    local.get $slab
+    i32.load
+    i32.const 1
+    i32.add
+    i32.store
+    local.get $r
+    local.get $slab
+    i32.load
+    i32.store
+    local.get $r
+    local.get $slab
+    i32.store offset=4
+    local.get $r
+  )
+
+  ;; $tup_array_last returns the address of the last element of a.
+  (func $tup_array_last (param $a i32) (param $size i32) (result i32)
+    local.get $a
+    i32.load offset=4
+    i32.const 8
+    i32.add
+    local.get $a
+    i32.load
+    i32.const 1
+    i32.sub
+    local.get $size
+    i32.mul
+    i32.add
+  )
+
+  ;; $tup_elems_slice returns an array of elements lo through hi of the
+  ;; len elements at elems.
+  (func $tup_elems_slice (param $elems i32) (param $lo i64) (param $hi i64) (param $len i32) (param $size i32) (param $kind i32) (param $cyclic i32) (result i32)
+    (local $n i32) (local $a i32)
+    local.get $lo
+    local.get $hi
+    local.get $len
+    call $tup_slice
+    local.tee $n
+    local.get $size
+    local.get $kind
+    local.get $cyclic
+    call $tup_array_new
+    local.set $a
+    local.get $n
+    if
+      local.get $a
+      i32.load offset=4
+      i32.const 8
+      i32.add
+      local.get $elems
+      local.get $lo
+      i32.wrap_i64
+      local.get $size
+      i32.mul
+      i32.add
+      local.get $n
+      local.get $size
+      i32.mul
+      memory.copy
+      local.get $a
+      i32.load offset=4
+      i32.const 0
+      call $tup_visit_object
+    end
+    local.get $a
+  )
+
+  (func $tup_array_slice (param $a i32) (param $lo i64) (param $hi i64) (param $size i32) (param $kind i32) (param $cyclic i32) (result i32)
+    local.get $a
+    i32.load offset=4
+    i32.const 8
+    i32.add
+    local.get $lo
+    local.get $hi
+    local.get $a
+    i32.load
+    local.get $size
+    local.get $kind
+    local.get $cyclic
+    call $tup_elems_slice
+  )
+
+  ;; Signed integers. The functions named _ok return the result and
+  ;; whether it is valid; the others trap unless it is.
+
+  (func $tup_bound (param $r i64) (param $ok i32) (param $min i64) (param $max i64) (result i64 i32)
+    local.get $r
+    local.get $ok
+    local.get $r
+    local.get $min
+    i64.ge_s
+    i32.and
+    local.get $r
+    local.get $max
+    i64.le_s
+    i32.and
+  )
+
+  (func $tup_check (param $r i64) (param $ok i32) (result i64)
+    local.get $ok
+    i32.eqz
+    if
+      call $tup_overflow
+    end
+    local.get $r
+  )
+
+  ;; tup_conv returns x, trapping unless it lies between min and max;
+  ;; tup_conv_u returns the unsigned x, trapping if it exceeds max.
+
+  (func $tup_conv (param $x i64) (param $min i64) (param $max i64) (result i64)
+    local.get $x
+    local.get $min
+    i64.lt_s
+    local.get $x
+    local.get $max
+    i64.gt_s
+    i32.or
+    if
+      call $tup_overflow
+    end
+    local.get $x
+  )
+
+  (func $tup_conv_u (param $x i64) (param $max i64) (result i64)
+    local.get $x
+    local.get $max
+    i64.gt_u
+    if
+      call $tup_overflow
+    end
+    local.get $x
+  )
+
+  (func $tup_add_ok (param $x i64) (param $y i64) (param $min i64) (param $max i64) (result i64 i32)
+    (local $r i64)
+    local.get $x
+    local.get $y
+    i64.add
+    local.tee $r
+    local.get $x
+    local.get $r
+    i64.xor
+    local.get $y
+    local.get $r
+    i64.xor
+    i64.and
+    i64.const 0
+    i64.ge_s
+    local.get $min
+    local.get $max
+    call $tup_bound
+  )
+
+  (func $tup_sub_ok (param $x i64) (param $y i64) (param $min i64) (param $max i64) (result i64 i32)
+    (local $r i64)
+    local.get $x
+    local.get $y
+    i64.sub
+    local.tee $r
+    local.get $x
+    local.get $y
+    i64.xor
+    local.get $x
+    local.get $r
+    i64.xor
+    i64.and
+    i64.const 0
+    i64.ge_s
+    local.get $min
+    local.get $max
+    call $tup_bound
+  )
+
+  (func $tup_mul_ok (param $x i64) (param $y i64) (param $min i64) (param $max i64) (result i64 i32)
+    (local $r i64) (local $ok i32)
+    local.get $x
+    i64.const -1
+    i64.eq
+    if (result i32)
+      i64.const 0
+      local.get $y
+      i64.sub
+      local.set $r
+      local.get $y
+      i64.const -9223372036854775808
+      i64.ne
+    else
+      local.get $x
+      local.get $y
+      i64.mul
+      local.set $r
+      local.get $x
+      i64.eqz
+      if (result i32)
+        i32.const 1
+      else
+        local.get $r
+        local.get $x
+        i64.div_s
+        local.get $y
+        i64.eq
+      end
+    end
+    local.set $ok
+    local.get $r
+    local.get $ok
+    local.get $min
+    local.get $max
+    call $tup_bound
+  )
+
+  (func $tup_div_ok (param $x i64) (param $y i64) (param $min i64) (param $max i64) (result i64 i32)
+    (local $r i64) (local $ok i32)
+    local.get $y
+    i64.eqz
+    if
+      i64.const 0
+      i32.const 0
+      return
+    end
+    local.get $y
+    i64.const -1
+    i64.eq
+    if (result i32)
+      i64.const 0
+      local.get $x
+      i64.sub
+      local.set $r
+      local.get $x
+      i64.const -9223372036854775808
+      i64.ne
+    else
+      local.get $x
+      local.get $y
+      i64.div_s
+      local.set $r
+      i32.const 1
+    end
+    local.set $ok
+    local.get $r
+    local.get $ok
+    local.get $min
+    local.get $max
+    call $tup_bound
+  )
+
+  (func $tup_mod_ok (param $x i64) (param $y i64) (result i64 i32)
+    local.get $y
+    i64.eqz
+    if
+      i64.const 0
+      i32.const 0
+      return
+    end
+    local.get $x
+    local.get $y
+    i64.rem_s
+    i32.const 1
+  )
+
+  (func $tup_add (param $x i64) (param $y i64) (param $min i64) (param $max i64) (result i64)
+    local.get $x
+    local.get $y
+    local.get $min
+    local.get $max
+    call $tup_add_ok
+    call $tup_check
+  )
+
+  (func $tup_sub (param $x i64) (param $y i64) (param $min i64) (param $max i64) (result i64)
+    local.get $x
+    local.get $y
+    local.get $min
+    local.get $max
+    call $tup_sub_ok
+    call $tup_check
+  )
+
+  (func $tup_mul (param $x i64) (param $y i64) (param $min i64) (param $max i64) (result i64)
+    local.get $x
+    local.get $y
+    local.get $min
+    local.get $max
+    call $tup_mul_ok
+    call $tup_check
+  )
+
+  (func $tup_div (param $x i64) (param $y i64) (param $min i64) (param $max i64) (result i64)
+    local.get $y
+    i64.eqz
+    if
+      global.get $lit_division
+      call $tup_panic
+    end
+    local.get $x
+    local.get $y
+    local.get $min
+    local.get $max
+    call $tup_div_ok
+    call $tup_check
+  )
+
+  (func $tup_mod (param $x i64) (param $y i64) (result i64)
+    local.get $y
+    i64.eqz
+    if
+      global.get $lit_division
+      call $tup_panic
+    end
+    local.get $x
+    local.get $y
+    i64.rem_s
+  )
+
+  (func $tup_pow (param $x i64) (param $y i64) (param $min i64) (param $max i64) (result i64)
+    (local $r i64)
+    local.get $y
+    i64.const 0
+    i64.lt_s
+    if
+      global.get $lit_exponent
+      call $tup_panic
+    end
+    i64.const 1
+    local.set $r
+    block $done
+      loop $next
+        local.get $y
+        i64.eqz
+        br_if $done
+        local.get $y
+        i64.const 1
+        i64.and
+        i32.wrap_i64
+        if
+          local.get $r
+          local.get $x
+          i64.const -9223372036854775808
+          i64.const 9223372036854775807
+          call $tup_mul
+          local.set $r
+        end
+        local.get $y
+        i64.const 1
+        i64.shr_u
+        local.tee $y
+        i64.eqz
+        br_if $done
+        ;; once |x| is at least 2, a square that overflows would make the
+        ;; result overflow too
+        local.get $x
+        local.get $x
+        i64.const -9223372036854775808
+        i64.const 9223372036854775807
+        call $tup_mul
+        local.set $x
+        br $next
+      end
+    end
+    local.get $r
+    i32.const 1
+    local.get $min
+    local.get $max
+    call $tup_bound
+    call $tup_check
+  )
+
+  (func $tup_shl (param $x i64) (param $n i64) (param $min i64) (param $max i64) (result i64)
+    (local $r i64)
+    local.get $n
+    i64.const 0
+    i64.lt_s
+    if
+      global.get $lit_shift
+      call $tup_panic
+    end
+    local.get $x
+    i64.eqz
+    if
+      i64.const 0
+      return
+    end
+    local.get $n
+    i64.const 64
+    i64.ge_s
+    if
+      call $tup_overflow
+    end
+    local.get $x
+    local.get $n
+    i64.shl
+    local.tee $r
+    local.get $r
+    local.get $n
+    i64.shr_s
+    local.get $x
+    i64.eq
+    local.get $min
+    local.get $max
+    call $tup_bound
+    call $tup_check
+  )
+
+  (func $tup_shr (param $x i64) (param $n i64) (result i64)
+    local.get $n
+    i64.const 0
+    i64.lt_s
+    if
+      global.get $lit_shift
+      call $tup_panic
+    end
+    local.get $n
+    i64.const 64
+    i64.ge_s
+    if
+      i64.const -1
+      i64.const 0
+      local.get $x
+      i64.const 0
+      i64.lt_s
+      select
+      return
+    end
+    local.get $x
+    local.get $n
+    i64.shr_s
+  )
+
+  ;; UInt64.
+
+  (func $tup_add_u_ok (param $x i64) (param $y i64) (result i64 i32)
+    (local $r i64)
+    local.get $x
+    local.get $y
+    i64.add
+    local.tee $r
+    local.get $r
+    local.get $x
+    i64.ge_u
+  )
+
+  (func $tup_sub_u_ok (param $x i64) (param $y i64) (result i64 i32)
+    local.get $x
+    local.get $y
+    i64.sub
+    local.get $x
+    local.get $y
+    i64.ge_u
+  )
+
+  (func $tup_mul_u_ok (param $x i64) (param $y i64) (result i64 i32)
+    (local $r i64)
+    local.get $x
+    local.get $y
+    i64.mul
+    local.set $r
+    local.get $r
+    local.get $x
+    i64.eqz
+    if (result i32)
+      i32.const 1
+    else
+      local.get $r
+      local.get $x
+      i64.div_u
+      local.get $y
+      i64.eq
+    end
+  )
+
+  (func $tup_div_u_ok (param $x i64) (param $y i64) (result i64 i32)
+    local.get $y
+    i64.eqz
+    if
+      i64.const 0
+      i32.const 0
+      return
+    end
+    local.get $x
+    local.get $y
+    i64.div_u
+    i32.const 1
+  )
+
+  (func $tup_mod_u_ok (param $x i64) (param $y i64) (result i64 i32)
+    local.get $y
+    i64.eqz
+    if
+      i64.const 0
+      i32.const 0
+      return
+    end
+    local.get $x
+    local.get $y
+    i64.rem_u
+    i32.const 1
+  )
+
+  (func $tup_add_u (param $x i64) (param $y i64) (result i64)
+    local.get $x
+    local.get $y
+    call $tup_add_u_ok
+    call $tup_check
+  )
+
+  (func $tup_sub_u (param $x i64) (param $y i64) (result i64)
+    local.get $x
+    local.get $y
+    call $tup_sub_u_ok
+    call $tup_check
+  )
+
+  (func $tup_mul_u (param $x i64) (param $y i64) (result i64)
+    local.get $x
+    local.get $y
+    call $tup_mul_u_ok
+    call $tup_check
+  )
+
+  (func $tup_div_u (param $x i64) (param $y i64) (result i64)
+    local.get $y
+    i64.eqz
+    if
+      global.get $lit_division
+      call $tup_panic
+    end
+    local.get $x
+    local.get $y
+    i64.div_u
+  )
+
+  (func $tup_mod_u (param $x i64) (param $y i64) (result i64)
+    local.get $y
+    i64.eqz
+    if
+      global.get $lit_division
+      call $tup_panic
+    end
+    local.get $x
+    local.get $y
+    i64.rem_u
+  )
+
+  (func $tup_pow_u (param $x i64) (param $y i64) (result i64)
+    (local $r i64)
+    i64.const 1
+    local.set $r
+    block $done
+      loop $next
+        local.get $y
+        i64.eqz
+        br_if $done
+        local.get $y
+        i64.const 1
+        i64.and
+        i32.wrap_i64
+        if
+          local.get $r
+          local.get $x
+          call $tup_mul_u
+          local.set $r
+        end
+        local.get $y
+        i64.const 1
+        i64.shr_u
+        local.tee $y
+        i64.eqz
+        br_if $done
+        local.get $x
+        local.get $x
+        call $tup_mul_u
+        local.set $x
+        br $next
+      end
+    end
+    local.get $r
+  )
+
+  (func $tup_shl_u (param $x i64) (param $n i64) (result i64)
+    (local $r i64)
+    local.get $x
+    i64.eqz
+    if
+      i64.const 0
+      return
+    end
+    local.get $n
+    i64.const 64
+    i64.ge_u
+    if
+      call $tup_overflow
+    end
+    local.get $x
+    local.get $n
+    i64.shl
+    local.tee $r
+    local.get $r
+    local.get $n
+    i64.shr_u
+    local.get $x
+    i64.eq
+    call $tup_check
+  )
+
+  (func $tup_shr_u (param $x i64) (param $n i64) (result i64)
+    local.get $n
+    i64.const 64
+    i64.ge_u
+    if
+      i64.const 0
+      return
+    end
+    local.get $x
+    local.get $n
+    i64.shr_u
+  )
+
+  ;; Floats. Float32 is computed in 32 bits, and checked in 64.
+
+  (func $tup_finite (param $x f64) (result i32)
+    local.get $x
+    local.get $x
+    f64.sub
+    f64.const 0
+    f64.eq
+  )
+
+  (func $tup_float (param $x f64) (result f64)
+    local.get $x
+    call $tup_finite
+    i32.eqz
+    if
+      global.get $lit_float
+      call $tup_panic
+    end
+    local.get $x
+  )
+
+  (func $tup_float32 (param $x f32) (result f32)
+    local.get $x
+    f64.promote_f32
+    call $tup_float
+    drop
+    local.get $x
+  )
+
+  (func $tup_div_float (param $x f64) (param $y f64) (result f64)
+    local.get $y
+    f64.const 0
+    f64.eq
+    if
+      global.get $lit_division
+      call $tup_panic
+    end
+    local.get $x
+    local.get $y
+    f64.div
+    call $tup_float
+  )
+
+  (func $tup_div_float32 (param $x f32) (param $y f32) (result f32)
+    local.get $y
+    f32.const 0
+    f32.eq
+    if
+      global.get $lit_division
+      call $tup_panic
+    end
+    local.get $x
+    local.get $y
+    f32.div
+    call $tup_float32
+  )
+
+  (func $tup_pow_float (param $x f64) (param $y f64) (result f64)
+    local.get $x
+    local.get $y
+    call $tup_host_pow
+    call $tup_float
+  )
+
+  ;; Text. The text of a value is written to a builder, which holds the
+  ;; address of its bytes, their length and its capacity, and a String made
+  ;; of it, which frees the builder. Strings are quoted when they are part
+  ;; of a larger value.
+
+  (func $tup_builder_new (result i32)
+    i32.const 12
+    call $tup_alloc
+  )
+
+  ;; $tup_reserve adds n bytes to the end of the builder b, returning their
+  ;; address.
+  (func $tup_reserve (param $b i32) (param $n i32) (result i32)
+    (local $len i32) (local $cap i32) (local $data i32)
+    local.get $b
+    i32.load offset=4
+    local.tee $len
+    local.get $n
+    i32.add
+    local.get $b
+    i32.load offset=8
+    i32.gt_u
+    if
+      local.get $len
+      local.get $n
+      i32.add
+      i32.const 1
+      i32.shl
+      i32.const 16
+      i32.add
+      local.tee $cap
+      call $tup_alloc
+      local.tee $data
+      local.get $b
+      i32.load
+      local.get $len
+      memory.copy
+      local.get $b
+      i32.load
+      call $tup_release
+      local.get $b
+      local.get $data
+      i32.store
+      local.get $b
+      local.get $cap
+      i32.store offset=8
+    end
+    local.get $b
+    local.get $len
+    local.get $n
+    i32.add
+    i32.store offset=4
+    local.get $b
+    i32.load
+    local.get $len
+    i32.add
+  )
+
+  (func $tup_write (param $b i32) (param $p i32) (param $n i32)
+    local.get $b
+    local.get $n
+    call $tup_reserve
+    local.get $p
+    local.get $n
+    memory.copy
+  )
+
+  (func $tup_write_byte (param $b i32) (param $c i32)
+    local.get $b
+    i32.const 1
+    call $tup_reserve
+    local.get $c
+    i32.store8
+  )
+
+  ;; $tup_puts writes the bytes of the String s.
+  (func $tup_puts (param $b i32) (param $s i32)
+    local.get $b
+    local.get $s
+    i32.const 4
+    i32.add
+    local.get $s
+    i32.load
+    call $tup_write
+  )
+
+  (func $tup_builder_string (param $b i32) (result i32)
+    (local $s i32)
+    local.get $b
+    i32.load offset=4
+    call $tup_string_new
+    local.tee $s
+    i32.const 4
+    i32.add
+    local.get $b
+    i32.load
+    local.get $b
+    i32.load offset=4
+    memory.copy
+    local.get $b
+    i32.load
+    call $tup_release
+    local.get $b
+    call $tup_release
+    local.get $s
+  )
+
+  (func $tup_fmt_uint (param $b i32) (param $v i64)
+    (local $n i32) (local $t i64) (local $p i32)
+    local.get $v
+    local.set $t
+    loop $count
+      local.get $n
+      i32.const 1
+      i32.add
+      local.set $n
+      local.get $t
+      i64.const 10
+      i64.div_u
+      local.tee $t
+      i64.eqz
+      i32.eqz
+      br_if $count
+    end
+    local.get $b
+    local.get $n
+    call $tup_reserve
+    local.set $p
+    loop $digit
+      local.get $p
+      local.get $n
+      i32.const 1
+      i32.sub
+      local.tee $n
+      i32.add
+      local.get $v
+      i64.const 10
+      i64.rem_u
+      i32.wrap_i64
+      i32.const 48
+      i32.add
+      i32.store8
+      local.get $v
+      i64.const 10
+      i64.div_u
+      local.set $v
+      local.get $n
+      br_if $digit
+    end
+  )
+
+  (func $tup_fmt_int (param $b i32) (param $v i64)
+    local.get $v
+    i64.const 0
+    i64.lt_s
+    if
+      local.get $b
+      i32.const 45
+      call $tup_write_byte
+      i64.const 0
+      local.get $v
+      i64.sub
+      local.set $v
+    end
+    local.get $b
+    local.get $v
+    call $tup_fmt_uint
+  )
+
+  (func $tup_fmt_bool (param $b i32) (param $v i32)
+    local.get $b
+    global.get $lit_true
+    global.get $lit_false
+    local.get $v
+    select
+    call $tup_puts
+  )
+
+  (func $tup_fmt_nil (param $b i32)
+    local.get $b
+    global.get $lit_nil
+    call $tup_puts
+  )
+
+  (func $tup_fmt_float (param $b i32) (param $v f64)
+    (local $n i32)
+    local.get $v
+    local.get $b
+    i32.const 32
+    call $tup_reserve
+    call $tup_format_float
+    local.set $n
+    local.get $b
+    local.get $b
+    i32.load offset=4
+    i32.const 32
+    i32.sub
+    local.get $n
+    i32.add
+    i32.store offset=4
+  )
+
+  (func $tup_fmt_string (param $b i32) (param $s i32) (param $quote i32)
+    local.get $quote
+    if
+      local.get $b
+      local.get $s
+      call $tup_fmt_quoted
+      return
+    end
+    local.get $b
+    local.get $s
+    call $tup_puts
+  )
+
+  (func $tup_fmt_symbol (param $b i32) (param $s i32)
+    local.get $b
+    i32.const 58
+    call $tup_write_byte
+    local.get $b
+    local.get $s
+    call $tup_puts
+  )
+
+  ;; $tup_fmt_error writes an error returned by checked arithmetic, held as
+  ;; its message.
+  (func $tup_fmt_error (param $b i32) (param $s i32)
+    local.get $b
+    global.get $lit_error
+    call $tup_puts
+    local.get $b
+    local.get $s
+    call $tup_fmt_quoted
+    local.get $b
+    i32.const 41
+    call $tup_write_byte
+  )
+
+  ;; $tup_escape returns the letter escaping the byte c in a quoted string,
+  ;; or 0.
+  (func $tup_escape (param $c i32) (result i32)
+    local.get $c
+    i32.const 7
+    i32.eq
+    if
+      i32.const 97
+      return
+    end
+    local.get $c
+    i32.const 8
+    i32.eq
+    if
+      i32.const 98
+      return
+    end
+    local.get $c
+    i32.const 12
+    i32.eq
+    if
+      i32.const 102
+      return
+    end
+    local.get $c
+    i32.const 10
+    i32.eq
+    if
+      i32.const 110
+      return
+    end
+    local.get $c
+    i32.const 13
+    i32.eq
+    if
+      i32.const 114
+      return
+    end
+    local.get $c
+    i32.const 9
+    i32.eq
+    if
+      i32.const 116
+      return
+    end
+    local.get $c
+    i32.const 11
+    i32.eq
+    if
+      i32.const 118
+      return
+    end
+    local.get $c
+    i32.const 34
+    i32.eq
+    local.get $c
+    i32.const 92
+    i32.eq
+    i32.or
+    if
+      local.get $c
+      return
+    end
+    i32.const 0
+  )
+
+  ;; $tup_fmt_hex writes a backslash, the letter kind and the n lowest hex
+  ;; digits of v.
+  (func $tup_fmt_hex (param $b i32) (param $kind i32) (param $v i32) (param $n i32)
+    (local $d i32)
+    local.get $b
+    i32.const 92
+    call $tup_write_byte
+    local.get $b
+    local.get $kind
+    call $tup_write_byte
+    block $done
+      loop $next
+        local.get $n
+        i32.eqz
+        br_if $done
+        local.get $v
+        local.get $n
+        i32.const 1
+        i32.sub
+        local.tee $n
+        i32.const 2
+        i32.shl
+        i32.shr_u
+        i32.const 15
+        i32.and
+        local.set $d
+        local.get $b
+        local.get $d
+        i32.const 48
+        i32.add
+        local.get $d
+        i32.const 87
+        i32.add
+        local.get $d
+        i32.const 10
+        i32.lt_u
+        select
+        call $tup_write_byte
+        br $next
+      end
+    end
+  )
+
+  ;; $tup_utf8 returns the length of the valid UTF-8 encoding of a rune at
+  ;; p, n bytes long, and the rune, or 0 and 0.
+  (func $tup_utf8 (param $p i32) (param $n i32) (result i32 i32)
+    (local $c i32) (local $len i32) (local $r i32) (local $min i32) (local $i i32) (local $d i32)
+    local.get $p
+    i32.load8_u
+    local.tee $c
+    i32.const 194
+    i32.lt_u
+    local.get $c
+    i32.const 244
+    i32.gt_u
+    i32.or
+    if
+      i32.const 0
+      i32.const 0
+      return
+    end
+    local.get $c
+    i32.const 224
+    i32.lt_u
+    if
+      i32.const 2
+      local.set $len
+      i32.const 128
+      local.set $min
+      local.get $c
+      i32.const 31
+      i32.and
+      local.set $r
+    else
+      local.get $c
+      i32.const 240
+      i32.lt_u
+      if
+        i32.const 3
+        local.set $len
+        i32.const 2048
+        local.set $min
+        local.get $c
+        i32.const 15
+        i32.and
+        local.set $r
+      else
+        i32.const 4
+        local.set $len
+        i32.const 65536
+        local.set $min
+        local.get $c
+        i32.const 7
+        i32.and
+        local.set $r
+      end
+    end
+    local.get $n
+    local.get $len
+    i32.lt_u
+    if
+      i32.const 0
+      i32.const 0
+      return
+    end
+    i32.const 1
+    local.set $i
+    block $done
+      loop $next
+        local.get $i
+        local.get $len
+        i32.ge_u
+        br_if $done
+        local.get $p
+        local.get $i
+        i32.add
+        i32.load8_u
+        local.tee $d
+        i32.const 192
+        i32.and
+        i32.const 128
+        i32.ne
+        if
+          i32.const 0
+          i32.const 0
+          return
+        end
+        local.get $r
+        i32.const 6
+        i32.shl
+        local.get $d
+        i32.const 63
+        i32.and
+        i32.or
+        local.set $r
+        local.get $i
+        i32.const 1
+        i32.add
+        local.set $i
+        br $next
+      end
+    end
+    local.get $r
+    local.get $min
+    i32.lt_u
+    local.get $r
+    i32.const 1114111
+    i32.gt_u
+    i32.or
+    local.get $r
+    i32.const 55296
+    i32.ge_u
+    local.get $r
+    i32.const 57343
+    i32.le_u
+    i32.and
+    i32.or
+    if
+      i32.const 0
+      i32.const 0
+      return
+    end
+    local.get $len
+    local.get $r
+  )
+
+  ;; $tup_fmt_quoted writes s as a double-quoted string literal: control
+  ;; characters, quotes, backslashes and invalid UTF-8 are escaped.
+  (func $tup_fmt_quoted (param $b i32) (param $s i32)
+    (local $p i32) (local $end i32) (local $c i32) (local $e i32) (local $n i32) (local $r i32)
+    local.get $b
+    i32.const 34
+    call $tup_write_byte
+    local.get $s
+    i32.const 4
+    i32.add
+    local.tee $p
+    local.get $s
+    i32.load
+    i32.add
+    local.set $end
+    block $done
+      loop $next
+        local.get $p
+        local.get $end
+        i32.ge_u
+        br_if $done
+        local.get $p
+        i32.load8_u
+        local.tee $c
+        call $tup_escape
+        local.tee $e
+        if
+          local.get $b
+          i32.const 92
+          call $tup_write_byte
+          local.get $b
+          local.get $e
+          call $tup_write_byte
+          local.get $p
+          i32.const 1
+          i32.add
+          local.set $p
+          br $next
+        end
+        local.get $c
+        i32.const 32
+        i32.ge_u
+        local.get $c
+        i32.const 127
+        i32.lt_u
+        i32.and
+        if
+          local.get $b
+          local.get $c
+          call $tup_write_byte
+          local.get $p
+          i32.const 1
+          i32.add
+          local.set $p
+          br $next
+        end
+        local.get $c
+        i32.const 128
+        i32.ge_u
+        if
+          local.get $p
+          local.get $end
+          local.get $p
+          i32.sub
+          call $tup_utf8
+          local.set $r
+          local.tee $n
+          if
+            local.get $r
+            i32.const 160
+            i32.le_u
+            local.get $r
+            i32.const 173
+            i32.eq
+            i32.or
+            if
+              local.get $b
+              i32.const 117
+              local.get $r
+              i32.const 4
+              call $tup_fmt_hex
+            else
+              local.get $b
+              local.get $p
+              local.get $n
+              call $tup_write
+            end
+            local.get $p
+            local.get $n
+            i32.add
+            local.set $p
+            br $next
+          end
+        end
+        local.get $b
+        i32.const 120
+        local.get $c
+        i32.const 2
+        call $tup_fmt_hex
+        local.get $p
+        i32.const 1
+        i32.add
+        local.set $p
+        br $next
+      end
+    end
+    local.get $b
+    i32.const 34
+    call $tup_write_byte
+  )
+)
@@ -0,0 +1,210 @@
+package wasmgen
+
+import (
+	"sort"
+
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/wasm"
+)
+
+// frameKind identifies the construct a frame of the control stack opens.
+type frameKind int
+
+const (
+	frameIf frameKind = iota
+	frameLoop
+	frameBlock
+)
+
+// frame is a construct enclosing the code being generated: a loop headed
+// by block, which branches back to it continue, or a block followed by
+// block, which branches to it leave, or the arms of an if.
+type frame struct {
+	kind  frameKind
+	block *ir.Block
+}
+
+// stackify generates the body of the function from its blocks, which it
+// arranges as in Ramsey's "Beyond Relooper". Each block is placed within
+// the code of its immediate dominator. A block that has two or more
+// forward edges into it, a merge block, follows a WebAssembly block
+// enclosing the code of its dominator, which branches into it leave; a
+// block that is the target of back edges, a loop header, begins a
+// WebAssembly loop. Any other block is placed where its only predecessor
+// branches to it.
+func (f *funcGen) stackify() {
+	fn := f.ir
+	dom := ir.Dominators(fn)
+	order := ir.ReversePostorder(fn)
+	f.rpo = map[*ir.Block]int{}
+	for i, b := range order {
+		f.rpo[b] = i
+	}
+	f.children = map[*ir.Block][]*ir.Block{}
+	for _, b := range order[1:] {
+		idom := dom.Idom(b)
+		f.children[idom] = append(f.children[idom], b)
+	}
+	f.loop = map[*ir.Block]bool{}
+	f.merge = map[*ir.Block]bool{}
+	forward := map[*ir.Block]int{}
+	for _, b := range order {
+		term := b.Terminator()
+		if term == nil {
+			f.errorf("block %s of %s has no terminator", b, fn.Name)
+		}
+		for _, s := range term.Blocks {
+			if f.rpo[s] > f.rpo[b] {
+				forward[s]++
+				continue
+			}
+			if !dom.Dominates(s, b) {
+				f.errorf("%s has irreducible control flow, which WebAssembly cannot express", fn.Name)
+			}
+			f.loop[s] = true
+		}
+	}
+	for b, n := range forward {
+		f.merge[b] = n > 1
+	}
+
+	f.tree(order[0])
+	if body := f.fn.Body; fn.Sig.Result != nil && body[len(body)-1].Op == wasm.OpEnd {
+		// every path has returned or trapped
+		f.emit(wasm.OpUnreachable, 0)
+	}
+}
+
+// tree generates the code of b and of the blocks it dominates.
+func (f *funcGen) tree(b *ir.Block) {
+	var merges []*ir.Block
+	for _, child := range f.children[b] {
+		if f.merge[child] {
+			merges = append(merges, child)
+		}
+	}
+	// the merge block last in reverse postorder follows the outermost
+	// WebAssembly block
+	sort.Slice(merges, func(i, j int) bool { return f.rpo[merges[i]] > f.rpo[merges[j]] })
+	if f.loop[b] {
+		f.enter(wasm.OpLoop, frame{frameLoop, b})
+		f.within(b, merges)
+		f.leave()
+		return
+	}
+	f.within(b, merges)
+}
+
+// within generates the code of b within WebAssembly blocks followed by the
+// merge blocks it dominates.
+func (f *funcGen) within(b *ir.Block, merges []*ir.Block) {
+	if len(merges) == 0 {
+		f.block(b)
+		return
+	}
+	f.enter(wasm.OpBlock, frame{frameBlock, merges[0]})
+	f.within(b, merges[1:])
+	f.leave()
+	f.tree(merges[0])
+}
+
+func (f *funcGen) enter(op wasm.Op, fr frame) {
+	f.emit(op, 0)
+	f.frames = append(f.frames, fr)
+}
+
+func (f *funcGen) leave() {
+	f.frames = f.frames[:len(f.frames)-1]
+	f.emit(wasm.OpEnd, 0)
+}
+
+// block generates the instructions of b.
+func (f *funcGen) block(b *ir.Block) {
+	// the phis take their references before releasing those of their old
+	// values, which may be the new values of other phis
+	phis := b.Phis()
+	for _, phi := range phis {
+		if counted(phi.Typ) {
+			f.emit(wasm.OpLocalGet, int64(f.copies[phi]))
+			f.call("tup_retain")
+		}
+	}
+	for _, phi := range phis {
+		if counted(phi.Typ) && f.loops[b] {
+			f.emit(wasm.OpLocalGet, int64(f.values[phi]))
+			f.call("tup_release")
+		}
+		f.emit(wasm.OpLocalGet, int64(f.copies[phi]))
+		f.set(phi)
+	}
+	for _, instr := range b.Instrs[len(phis):] {
+		switch op := instr.Op; {
+		case op == ir.OpJump:
+			f.branch(b, instr.Blocks[0])
+		case op == ir.OpBr:
+			f.value(instr.Args[0])()
+			f.fork(b, instr.Blocks[0], instr.Blocks[1])
+		case op.IsChecked():
+			f.checked(instr)
+			f.fork(b, instr.Blocks[0], instr.Blocks[1])
+		case op == ir.OpRet:
+			// the result takes its reference before the locals release
+			// theirs
+			if len(instr.Args) > 0 && counted(instr.Args[0].Type()) {
+				f.value(instr.Args[0])()
+				f.call("tup_retain")
+			}
+			for _, v := range f.counted {
+				f.emit(wasm.OpLocalGet, int64(f.values[v]))
+				f.call("tup_release")
+			}
+			if len(instr.Args) > 0 {
+				f.value(instr.Args[0])()
+			}
+			f.emit(wasm.OpReturn, 0)
+		case op == ir.OpTrap:
+			f.panic(instr.Msg)
+		default:
+			f.instr(instr)
+		}
+	}
+}
+
+// fork branches from b to then if the i32 on the stack is true, else to
+// els.
+func (f *funcGen) fork(b, then, els *ir.Block) {
+	f.enter(wasm.OpIf, frame{kind: frameIf})
+	f.branch(b, then)
+	f.emit(wasm.OpElse, 0)
+	f.branch(b, els)
+	f.leave()
+}
+
+// branch assigns the phis of to the values flowing in from from, and
+// continues with the code of to: it branches to the loop to heads or the
+// block to follows, or places to here if from is its only predecessor.
+func (f *funcGen) branch(from, to *ir.Block) {
+	for _, phi := range to.Phis() {
+		for i, pred := range phi.Blocks {
+			if pred == from {
+				f.value(phi.Args[i])()
+				f.emit(wasm.OpLocalSet, int64(f.copies[phi]))
+			}
+		}
+	}
+	kind := frameBlock
+	switch {
+	case f.rpo[to] <= f.rpo[from]:
+		kind = frameLoop
+	case !f.merge[to]:
+		f.tree(to)
+		return
+	}
+	for i := len(f.frames) - 1; i >= 0; i-- {
+		if fr := f.frames[i]; fr.kind == kind && fr.block == to {
+			f.emit(wasm.OpBr, int64(len(f.frames)-1-i))
+			return
+		}
+	}
+	f.errorf("no label for the branch from %s to %s in %s", from, to, f.ir.Name)
+}
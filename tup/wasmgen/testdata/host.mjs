@@ -0,0 +1,73 @@
+// host.mjs runs the main function of a module generated by package wasmgen
+// with Node.js, providing the functions it imports:
+//
+//	node host.mjs module.wasm
+//
+// A runtime error is written to stderr and stops the program with exit
+// status 2, as the executables of the other backends do. If TUP_MEMSTATS
+// is set, the statistics of the heap are written to stderr when the
+// program ends.
+import { readFileSync, writeSync } from "node:fs";
+
+let memory;
+const utf8 = new TextEncoder();
+
+function string(addr) {
+  const len = new DataView(memory.buffer).getUint32(addr, true);
+  return new Uint8Array(memory.buffer, addr + 4, len);
+}
+
+// formatFloat returns the text of v as print writes it: the shortest
+// decimal that reads back as v, in exponent form if its exponent is less
+// than -4 or at least 6, and with a ".0" added if it would otherwise read
+// as an integer.
+function formatFloat(v) {
+  if (Number.isNaN(v)) return "NaN";
+  if (!Number.isFinite(v)) return v < 0 ? "-Inf" : "+Inf";
+  if (v === 0) return Object.is(v, -0) ? "-0.0" : "0.0";
+  const sign = v < 0 ? "-" : "";
+  const [mantissa, e] = Math.abs(v).toExponential().split("e");
+  const digits = mantissa.replace(".", "");
+  const exp = Number(e);
+  if (exp < -4 || exp >= 6) {
+    const frac = digits.length > 1 ? "." + digits.slice(1) : "";
+    return sign + digits[0] + frac + "e" + (exp < 0 ? "-" : "+") + String(Math.abs(exp)).padStart(2, "0");
+  }
+  if (exp < 0) return sign + "0." + "0".repeat(-exp - 1) + digits;
+  return sign + digits.slice(0, exp + 1).padEnd(exp + 1, "0") + "." + (digits.slice(exp + 1) || "0");
+}
+
+const imports = {
+  env: {
+    print(s) {
+      writeSync(1, string(s));
+      writeSync(1, "\n");
+    },
+  },
+  tup: {
+    format_float(v, buf) {
+      const text = utf8.encode(formatFloat(v));
+      new Uint8Array(memory.buffer, buf, text.length).set(text);
+      return text.length;
+    },
+    pow: Math.pow,
+  },
+};
+
+const { instance } = await WebAssembly.instantiate(readFileSync(process.argv[2]), imports);
+memory = instance.exports.memory;
+try {
+  instance.exports.main();
+  const stats = instance.exports.tup_exit();
+  if (process.env.TUP_MEMSTATS) {
+    writeSync(2, string(stats));
+    writeSync(2, "\n");
+  }
+} catch (err) {
+  const msg = instance.exports.tup_panic_msg.value;
+  if (!(err instanceof WebAssembly.RuntimeError) || msg === 0) throw err;
+  writeSync(2, "runtime error: ");
+  writeSync(2, string(msg));
+  writeSync(2, "\n");
+  process.exit(2);
+}
@@ -0,0 +1,845 @@
+package wasmgen
+
+import (
+	"math/bits"
+	"strconv"
+
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/layout"
+	"github.com/rowland/tuppence/tup/types"
+	"github.com/rowland/tuppence/tup/wasm"
+)
+
+// valType returns the WebAssembly type holding values of type t.
+func (g *generator) valType(t types.Type) wasm.ValType {
+	if types.Identical(t, types.ErrorType) {
+		return wasm.I32
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch u.Kind() {
+		case types.Nil, types.Bool, types.String, types.Symbol, types.TypeDescriptor,
+			types.Int8, types.Int16, types.Int32, types.UInt8, types.UInt16, types.UInt32:
+			return wasm.I32
+		case types.Int64, types.UInt64:
+			return wasm.I64
+		case types.Float16, types.Float32:
+			return wasm.F32
+		case types.Float64:
+			return wasm.F64
+		}
+	case *types.Enum:
+		return wasm.I64
+	case *types.Tuple, *types.Array, *types.Union, *types.Function:
+		return wasm.I32
+	}
+	g.errorf("%s has no WebAssembly representation", t)
+	return 0
+}
+
+// inline reports whether values of type t are held in place in the blocks
+// and arrays holding them, rather than by their addresses. The values of
+// recursive types are held by their addresses, as the boxes package layout
+// makes room for.
+func inline(t types.Type) bool {
+	if types.Identical(t, types.ErrorType) || layout.Recursive(t) {
+		return false
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Tuple, *types.Union:
+		return true
+	case *types.Array:
+		return u.Fixed()
+	}
+	return false
+}
+
+// counted reports whether values of type t are objects of the heap,
+// whose references are counted: strings, symbols, the errors of checked
+// arithmetic, tuples, unions, arrays and functions.
+func counted(t types.Type) bool {
+	if types.Identical(t, types.ErrorType) {
+		return true
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return u.Kind() == types.String || u.Kind() == types.Symbol
+	case *types.Tuple, *types.Union, *types.Array, *types.Function:
+		return true
+	}
+	return false
+}
+
+// refers reports whether values of type t, held in place, refer to
+// objects: whether they are objects held by their addresses, or hold
+// such values.
+func refers(t types.Type) bool {
+	return holds(t, func(t types.Type) bool { return counted(t) && !inline(t) })
+}
+
+// cyclic reports whether the objects values of type t refer to may be
+// part of a cycle: whether the values may hold closures.
+func cyclic(t types.Type) bool {
+	return holds(t, func(t types.Type) bool {
+		_, ok := t.Underlying().(*types.Function)
+		return ok
+	})
+}
+
+// holds reports whether values of type t are or hold, directly or through
+// the elements of arrays, values of a type for which leaf reports true.
+func holds(t types.Type, leaf func(types.Type) bool) bool {
+	seen := map[types.Type]bool{}
+	var walk func(t types.Type) bool
+	walk = func(t types.Type) bool {
+		if seen[t] {
+			return false
+		}
+		seen[t] = true
+		if leaf(t) {
+			return true
+		}
+		switch u := t.Underlying().(type) {
+		case *types.Tuple:
+			for _, f := range u.Fields {
+				if walk(f.Type) {
+					return true
+				}
+			}
+		case *types.Union:
+			for _, m := range u.Members {
+				if walk(m) {
+					return true
+				}
+			}
+		case *types.Array:
+			return walk(u.Elem)
+		}
+		return false
+	}
+	return walk(t)
+}
+
+func (g *generator) layout(t types.Type) *layout.Layout {
+	l, err := layout.Target32.Of(stored(t))
+	if err != nil {
+		g.errorf("%s", err)
+	}
+	return l
+}
+
+// size returns the size of the values of type t in the blocks and arrays
+// holding them: that of an address if they are held by their addresses.
+func (g *generator) size(t types.Type) int64 {
+	if layout.Recursive(t) {
+		return layout.Target32.WordSize
+	}
+	return g.layout(t).Size
+}
+
+// stored returns a type laid out as values of type t are in memory: the
+// errors returned by checked arithmetic, which take no space in package
+// layout, are held as their messages, and Float16 as Float32. The values
+// of recursive types other values hold are boxed, and left as they are.
+func stored(t types.Type) types.Type {
+	if types.Identical(t, types.ErrorType) {
+		return types.Typ[types.String]
+	}
+	switch u := t.(type) {
+	case *types.Basic:
+		if u.Kind() == types.Float16 {
+			return types.Typ[types.Float32]
+		}
+	case *types.Named:
+		if s := stored(u.Underlying()); s != u.Underlying() {
+			return types.NewNamed(u.Name(), s, u.Annotations()...)
+		}
+	case *types.Tuple:
+		fields := make([]*types.Field, len(u.Fields))
+		changed := false
+		for i, f := range u.Fields {
+			fields[i] = types.NewField(f.Name, storedComponent(f.Type))
+			changed = changed || fields[i].Type != f.Type
+		}
+		if changed {
+			return types.NewTuple(fields...)
+		}
+	case *types.Array:
+		if u.Fixed() {
+			if elem := storedComponent(u.Elem); elem != u.Elem {
+				return types.NewFixedArray(elem, u.Len)
+			}
+		}
+	case *types.Union:
+		members := make([]types.Type, len(u.Members))
+		changed := false
+		for i, m := range u.Members {
+			members[i] = storedComponent(m)
+			changed = changed || members[i] != m
+		}
+		if changed {
+			// the members keep their order, and so their tags
+			return &types.Union{Members: members}
+		}
+	}
+	return t
+}
+
+// storedComponent is stored for the tuple fields, union members and array
+// elements of type t.
+func storedComponent(t types.Type) types.Type {
+	if layout.Recursive(t) {
+		return t
+	}
+	return stored(t)
+}
+
+// access returns the instructions loading and storing values of type t,
+// which is not inline, or 0 for Nil, which takes no space.
+func (g *generator) access(t types.Type) (load, store wasm.Op) {
+	if types.Identical(t, types.ErrorType) {
+		return wasm.OpI32Load, wasm.OpI32Store
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch u.Kind() {
+		case types.Nil:
+			return 0, 0
+		case types.Bool, types.UInt8:
+			return wasm.OpI32Load8U, wasm.OpI32Store8
+		case types.Int8:
+			return wasm.OpI32Load8S, wasm.OpI32Store8
+		case types.Int16:
+			return wasm.OpI32Load16S, wasm.OpI32Store16
+		case types.UInt16:
+			return wasm.OpI32Load16U, wasm.OpI32Store16
+		case types.Int64, types.UInt64:
+			return wasm.OpI64Load, wasm.OpI64Store
+		case types.Float16, types.Float32:
+			return wasm.OpF32Load, wasm.OpF32Store
+		case types.Float64:
+			return wasm.OpF64Load, wasm.OpF64Store
+		}
+	case *types.Enum:
+		return wasm.OpI64Load, wasm.OpI64Store
+	}
+	return wasm.OpI32Load, wasm.OpI32Store
+}
+
+// intBits returns the size in bits of the integer type t.
+func intBits(t types.Type) int {
+	switch t.Underlying().(*types.Basic).Kind() {
+	case types.Int8, types.UInt8:
+		return 8
+	case types.Int16, types.UInt16:
+		return 16
+	case types.Int32, types.UInt32:
+		return 32
+	}
+	return 64
+}
+
+// bounds returns the least and greatest values of the integer type t,
+// which is not UInt64.
+func bounds(t types.Type) (min, max int64) {
+	n := intBits(t)
+	if types.IsUnsigned(t) {
+		return 0, 1<<n - 1
+	}
+	return -1 << (n - 1), 1<<(n-1) - 1
+}
+
+// load replaces the address on the stack with the value of type t held at
+// offset from it.
+func (c *code) load(t types.Type, offset int64) {
+	if inline(t) {
+		c.offset(offset)
+		return
+	}
+	op, _ := c.access(t)
+	if op == 0 {
+		c.emit(wasm.OpDrop, 0)
+		c.emit(wasm.OpI32Const, 0)
+		return
+	}
+	c.memory(op, offset, c.layout(t).Align)
+}
+
+// store stores the value v pushes, of type t, at offset from the address
+// addr pushes, the block taking references to the objects it refers to.
+func (c *code) store(t types.Type, offset int64, addr, v func()) {
+	l := c.layout(t)
+	if l.Size == 0 {
+		return
+	}
+	addr()
+	if inline(t) {
+		c.offset(offset)
+		v()
+		c.emit(wasm.OpI32Const, l.Size)
+		c.emit(wasm.OpMemoryCopy, 0)
+		c.visit(t, addr, offset, c.i32(opRetain))
+		return
+	}
+	v()
+	_, op := c.access(t)
+	c.memory(op, offset, l.Align)
+	if counted(t) {
+		v()
+		c.call("tup_retain")
+	}
+}
+
+// The operations of $tup_visit that the generated code applies.
+const (
+	opRetain  = 0
+	opRelease = 1
+)
+
+// visit applies the operation op pushes, as $tup_visit does, to each
+// object referred to by the value of type t held at offset from the
+// address addr pushes.
+func (c *code) visit(t types.Type, addr func(), offset int64, op func()) {
+	if !refers(t) {
+		return
+	}
+	if inline(t) {
+		c.visitInPlace(t, func() {
+			addr()
+			c.offset(offset)
+		}, op)
+		return
+	}
+	addr()
+	c.memory(wasm.OpI32Load, offset, 4)
+	op()
+	c.call("tup_visit")
+}
+
+// visitInPlace applies the operation op pushes to each object referred to
+// by the value of type t, held in place at the address addr pushes.
+func (c *code) visitInPlace(t types.Type, addr func(), op func()) {
+	if !refers(t) {
+		return
+	}
+	addr()
+	op()
+	c.emit(wasm.OpCall, int64(c.helper("visit", t)))
+}
+
+// object pushes the address of a new object of size bytes, with a
+// reference, visited by the visit function of the given kind.
+func (c *code) object(size, kind int64, cyclic bool) {
+	c.emit(wasm.OpI32Const, size)
+	c.emit(wasm.OpI32Const, kind)
+	c.emit(wasm.OpI32Const, bool32(cyclic))
+	c.call("tup_new")
+}
+
+// alloc pushes the address of a new object holding a value of type t,
+// which is held in place, zeroed.
+func (c *code) alloc(t types.Type) {
+	c.object(c.layout(t).Size, c.kind("visit", t), cyclic(t))
+}
+
+// copy replaces the address on the stack of a value of type t, held in
+// place in a block, with that of a new object holding a copy of it.
+func (c *code) copy(t types.Type) {
+	src, dst := c.temp(wasm.I32), c.temp(wasm.I32)
+	c.emit(wasm.OpLocalSet, int64(src))
+	c.alloc(t)
+	c.emit(wasm.OpLocalTee, int64(dst))
+	c.emit(wasm.OpLocalGet, int64(src))
+	c.emit(wasm.OpI32Const, c.layout(t).Size)
+	c.emit(wasm.OpMemoryCopy, 0)
+	c.visitInPlace(t, c.get(dst), c.i32(opRetain))
+	c.emit(wasm.OpLocalGet, int64(dst))
+}
+
+// slab pushes the size of the elements of type t, and the kind of the
+// visit function and whether they may be part of a cycle of the slabs and
+// arrays holding them, as the runtime's functions on arrays take them.
+func (c *code) slab(t types.Type) {
+	c.emit(wasm.OpI32Const, c.size(t))
+	c.emit(wasm.OpI32Const, c.kind("slab", t))
+	c.emit(wasm.OpI32Const, bool32(cyclic(t)))
+}
+
+func bool32(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// kind returns the kind of the visit function of the objects holding
+// values of type t, the helper of the given kind, defining it first if
+// need be, or 0 if the values refer to no object.
+func (g *generator) kind(kind string, t types.Type) int64 {
+	if !refers(t) {
+		return 0
+	}
+	index := g.helper(kind, t)
+	for i, k := range g.kinds {
+		if k == index {
+			return int64(i + 1)
+		}
+	}
+	g.kinds = append(g.kinds, index)
+	return int64(len(g.kinds))
+}
+
+// offset adds offset to the address on the stack.
+func (c *code) offset(offset int64) {
+	if offset != 0 {
+		c.emit(wasm.OpI32Const, offset)
+		c.emit(wasm.OpI32Add, 0)
+	}
+}
+
+// memory emits the memory access op at offset, of the given alignment.
+func (c *code) memory(op wasm.Op, offset, align int64) {
+	c.fn.Body = append(c.fn.Body, wasm.Instr{Op: op, Imm: offset, Align: uint32(bits.TrailingZeros64(uint64(align)))})
+}
+
+// helper is a function the module defines for values of a type.
+type helper struct {
+	kind  string // fmt, eq, visit, slab or closure
+	typ   types.Type
+	index uint32
+}
+
+// helper returns the index of the helper of the given kind for values of
+// type t, defining it first if need be. The fmt helper writes the text of
+// a value to a builder, quoting strings if asked to; the eq helper reports
+// whether two values are equal. The visit helper applies an operation of
+// $tup_visit to the objects a value held in place refers to, the slab
+// helper to those the elements of a slab of the values do, and the
+// closure helper to those the block of a closure capturing a tuple of the
+// values does.
+func (g *generator) helper(kind string, t types.Type) uint32 {
+	for _, h := range g.helpers {
+		if h.kind == kind && types.Identical(h.typ, t) {
+			return h.index
+		}
+	}
+	index := uint32(len(g.out.Imports) + len(g.out.Funcs))
+	g.helpers = append(g.helpers, &helper{kind: kind, typ: t, index: index})
+	vt := g.valType(t)
+	var ft wasm.FuncType
+	var params []string
+	switch kind {
+	case "fmt":
+		ft = wasm.FuncType{Params: []wasm.ValType{wasm.I32, vt, wasm.I32}}
+		params = []string{"b", "v", "quote"}
+	case "eq":
+		ft = wasm.FuncType{Params: []wasm.ValType{vt, vt}, Results: []wasm.ValType{wasm.I32}}
+		params = []string{"x", "y"}
+	default:
+		ft = wasm.FuncType{Params: []wasm.ValType{wasm.I32, wasm.I32}}
+		params = []string{"v", "op"}
+	}
+	fn := &wasm.Func{Type: g.out.TypeIndex(ft), ID: "tup_" + kind + "_" + strconv.Itoa(len(g.helpers)-1), LocalIDs: params}
+	g.out.Funcs = append(g.out.Funcs, fn)
+	c := &code{generator: g, fn: fn}
+	switch kind {
+	case "fmt":
+		c.fmtBody(t)
+	case "eq":
+		c.eqBody(t)
+	case "visit":
+		c.visitBody(t)
+	case "slab":
+		// the slab holds the number of elements used, then the elements
+		size := c.size(t)
+		c.loop(func() {
+			c.emit(wasm.OpLocalGet, 0)
+			c.memory(wasm.OpI32Load, 0, 4)
+		}, func(i func()) {
+			c.visit(t, func() {
+				c.emit(wasm.OpLocalGet, 0)
+				i()
+				c.emit(wasm.OpI32Const, size)
+				c.emit(wasm.OpI32Mul, 0)
+				c.emit(wasm.OpI32Add, 0)
+			}, 8, c.get(1))
+		})
+	case "closure":
+		c.visit(t, c.get(0), capturesOffset, c.get(1))
+	}
+	return index
+}
+
+// get returns a function pushing the local index.
+func (c *code) get(index uint32) func() {
+	return func() { c.emit(wasm.OpLocalGet, int64(index)) }
+}
+
+// i32 returns a function pushing the i32 v.
+func (c *code) i32(v int64) func() {
+	return func() { c.emit(wasm.OpI32Const, v) }
+}
+
+// puts writes the string s to the builder b pushes.
+func (c *code) puts(b func(), s string) {
+	b()
+	c.emit(wasm.OpI32Const, int64(c.literal(s)))
+	c.call("tup_puts")
+}
+
+// format writes the text of the value v pushes, of type t, to the builder
+// b pushes, quoting strings if quote pushes true.
+func (c *code) format(t types.Type, b, v, quote func()) {
+	call := func(name string, args ...func()) {
+		for _, arg := range args {
+			arg()
+		}
+		c.call(name)
+	}
+	if types.Identical(t, types.ErrorType) {
+		call("tup_fmt_error", b, v)
+		return
+	}
+	if basic, ok := t.Underlying().(*types.Basic); ok {
+		switch {
+		case basic.Kind() == types.Nil:
+			call("tup_fmt_nil", b)
+		case basic.Kind() == types.Bool:
+			call("tup_fmt_bool", b, v)
+		case basic.Kind() == types.String:
+			call("tup_fmt_string", b, v, quote)
+		case basic.Kind() == types.Symbol:
+			call("tup_fmt_symbol", b, v)
+		case basic.Kind() == types.TypeDescriptor:
+			// the value is the address of the text of the type
+			call("tup_fmt_string", b, v, func() { c.emit(wasm.OpI32Const, 0) })
+		case types.IsInteger(basic):
+			b()
+			c.int64(t, v)
+			if types.IsUnsigned(basic) {
+				c.call("tup_fmt_uint")
+			} else {
+				c.call("tup_fmt_int")
+			}
+		case types.IsFloat(basic):
+			// Float32 is written as the Float64 it converts to
+			b()
+			v()
+			if c.valType(t) == wasm.F32 {
+				c.emit(wasm.OpF64PromoteF32, 0)
+			}
+			c.call("tup_fmt_float")
+		default:
+			c.errorf("cannot convert %s to text", t)
+		}
+		return
+	}
+	b()
+	v()
+	quote()
+	c.emit(wasm.OpCall, int64(c.helper("fmt", t)))
+}
+
+// int64 pushes the value v pushes, of the integer type t, as an i64.
+func (c *code) int64(t types.Type, v func()) {
+	v()
+	if c.valType(t) == wasm.I32 {
+		if types.IsUnsigned(t) {
+			c.emit(wasm.OpI64ExtendI32U, 0)
+		} else {
+			c.emit(wasm.OpI64ExtendI32S, 0)
+		}
+	}
+}
+
+// loop emits a loop over the indices from 0 to the i32 n pushes, calling
+// body with a function pushing the index.
+func (c *code) loop(n func(), body func(i func())) {
+	count := c.temp(wasm.I32)
+	i := c.temp(wasm.I32)
+	n()
+	c.emit(wasm.OpLocalSet, int64(count))
+	c.emit(wasm.OpBlock, 0)
+	c.emit(wasm.OpLoop, 0)
+	c.emit(wasm.OpLocalGet, int64(i))
+	c.emit(wasm.OpLocalGet, int64(count))
+	c.emit(wasm.OpI32GeU, 0)
+	c.emit(wasm.OpBrIf, 1)
+	body(c.get(i))
+	c.emit(wasm.OpLocalGet, int64(i))
+	c.emit(wasm.OpI32Const, 1)
+	c.emit(wasm.OpI32Add, 0)
+	c.emit(wasm.OpLocalSet, int64(i))
+	c.emit(wasm.OpBr, 0)
+	c.emit(wasm.OpEnd, 0)
+	c.emit(wasm.OpEnd, 0)
+}
+
+// elems returns functions pushing the number of elements of the array
+// array pushes, of type t, as an i32, and the address of element i of it.
+func (c *code) elems(t *types.Array, array func()) (n func(), elem func(i func())) {
+	size := c.size(t.Elem)
+	addr := func(i func()) {
+		i()
+		c.emit(wasm.OpI32Const, size)
+		c.emit(wasm.OpI32Mul, 0)
+		c.emit(wasm.OpI32Add, 0)
+	}
+	if t.Fixed() {
+		return c.i32(t.Len), func(i func()) {
+			array()
+			addr(i)
+		}
+	}
+	return func() {
+			array()
+			c.memory(wasm.OpI32Load, 0, 4)
+		}, func(i func()) {
+			array()
+			c.memory(wasm.OpI32Load, 4, 4)
+			c.emit(wasm.OpI32Const, 8)
+			c.emit(wasm.OpI32Add, 0)
+			addr(i)
+		}
+}
+
+// returnIf returns the i32 v from the function if the condition cond
+// pushes holds.
+func (c *code) returnIf(cond func(), v int64) {
+	cond()
+	c.emit(wasm.OpIf, 0)
+	c.emit(wasm.OpI32Const, v)
+	c.emit(wasm.OpReturn, 0)
+	c.emit(wasm.OpEnd, 0)
+}
+
+func (c *code) fmtBody(t types.Type) {
+	b, v, quote := c.get(0), c.get(1), c.get(2)
+	switch u := t.Underlying().(type) {
+	case *types.Tuple:
+		l := c.layout(t)
+		c.puts(b, "(")
+		for i, f := range u.Fields {
+			prefix := f.Name
+			if prefix != "" {
+				prefix += ": "
+			}
+			if i > 0 {
+				prefix = ", " + prefix
+			}
+			if prefix != "" {
+				c.puts(b, prefix)
+			}
+			offset := l.Field(i).Offset
+			c.format(f.Type, b, func() {
+				v()
+				c.load(f.Type, offset)
+			}, c.i32(1))
+		}
+		if len(u.Fields) == 1 && u.Fields[0].Name == "" {
+			c.puts(b, ",")
+		}
+		c.puts(b, ")")
+	case *types.Array:
+		n, elem := c.elems(u, v)
+		c.puts(b, "[")
+		c.loop(n, func(i func()) {
+			i()
+			c.emit(wasm.OpIf, 0)
+			c.puts(b, ", ")
+			c.emit(wasm.OpEnd, 0)
+			c.format(u.Elem, b, func() {
+				elem(i)
+				c.load(u.Elem, 0)
+			}, c.i32(1))
+		})
+		c.puts(b, "]")
+	case *types.Union:
+		// the members of unions are written as the values they hold
+		payload := c.layout(t).Payload
+		for i, m := range u.Members {
+			c.tagIs(v, i)
+			c.emit(wasm.OpIf, 0)
+			c.format(m, b, func() {
+				v()
+				c.load(m, payload)
+			}, quote)
+			c.emit(wasm.OpReturn, 0)
+			c.emit(wasm.OpEnd, 0)
+		}
+	case *types.Enum:
+		for _, m := range u.Members {
+			v()
+			c.emit(wasm.OpI64Const, m.Value)
+			c.emit(wasm.OpI64Eq, 0)
+			c.emit(wasm.OpIf, 0)
+			c.puts(b, t.String()+"."+m.Name)
+			c.emit(wasm.OpReturn, 0)
+			c.emit(wasm.OpEnd, 0)
+		}
+		b()
+		v()
+		c.call("tup_fmt_int")
+	case *types.Function:
+		// function values are written as the names of the functions,
+		// known by their trampolines, and closures as fn { ... }
+		for _, fv := range c.values {
+			text := ir.FuncText(fv.fn.Name)
+			if text == "fn { ... }" || !types.Identical(fv.typ, t) {
+				continue
+			}
+			v()
+			c.memory(wasm.OpI32Load, 0, 4)
+			c.emit(wasm.OpI32Const, int64(fv.index))
+			c.emit(wasm.OpI32Eq, 0)
+			c.emit(wasm.OpIf, 0)
+			c.puts(b, text)
+			c.emit(wasm.OpReturn, 0)
+			c.emit(wasm.OpEnd, 0)
+		}
+		c.puts(b, "fn { ... }")
+	default:
+		c.errorf("cannot convert %s to text", t)
+	}
+}
+
+// tagIs pushes whether the union v pushes holds member i.
+func (c *code) tagIs(v func(), i int) {
+	v()
+	c.memory(wasm.OpI32Load, 0, 4)
+	c.emit(wasm.OpI32Const, int64(i))
+	c.emit(wasm.OpI32Eq, 0)
+}
+
+// equal pushes whether the values x and y push, of type t, are equal.
+func (c *code) equal(t types.Type, x, y func()) {
+	if types.Identical(t, types.ErrorType) {
+		x()
+		y()
+		c.call("tup_string_eq")
+		return
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch u.Kind() {
+		case types.Nil:
+			c.emit(wasm.OpI32Const, 1)
+			return
+		case types.String, types.Symbol:
+			x()
+			y()
+			c.call("tup_string_eq")
+			return
+		}
+	case *types.Tuple, *types.Array, *types.Union:
+		x()
+		y()
+		c.emit(wasm.OpCall, int64(c.helper("eq", t)))
+		return
+	}
+	x()
+	y()
+	c.emit(eqOps[c.valType(t)], 0)
+}
+
+var eqOps = map[wasm.ValType]wasm.Op{
+	wasm.I32: wasm.OpI32Eq,
+	wasm.I64: wasm.OpI64Eq,
+	wasm.F32: wasm.OpF32Eq,
+	wasm.F64: wasm.OpF64Eq,
+}
+
+// differ returns from the function with false unless the values x and y
+// push, of type t, are equal.
+func (c *code) differ(t types.Type, x, y func()) {
+	c.returnIf(func() {
+		c.equal(t, x, y)
+		c.emit(wasm.OpI32Eqz, 0)
+	}, 0)
+}
+
+func (c *code) eqBody(t types.Type) {
+	x, y := c.get(0), c.get(1)
+	at := func(v func(), t types.Type, offset int64) func() {
+		return func() {
+			v()
+			c.load(t, offset)
+		}
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Tuple:
+		l := c.layout(t)
+		for i, f := range u.Fields {
+			offset := l.Field(i).Offset
+			c.differ(f.Type, at(x, f.Type, offset), at(y, f.Type, offset))
+		}
+	case *types.Array:
+		n, xelem := c.elems(u, x)
+		_, yelem := c.elems(u, y)
+		if !u.Fixed() {
+			c.returnIf(func() {
+				n()
+				y()
+				c.memory(wasm.OpI32Load, 0, 4)
+				c.emit(wasm.OpI32Ne, 0)
+			}, 0)
+		}
+		c.loop(n, func(i func()) {
+			c.differ(u.Elem, func() {
+				xelem(i)
+				c.load(u.Elem, 0)
+			}, func() {
+				yelem(i)
+				c.load(u.Elem, 0)
+			})
+		})
+	case *types.Union:
+		payload := c.layout(t).Payload
+		c.returnIf(func() {
+			x()
+			c.memory(wasm.OpI32Load, 0, 4)
+			y()
+			c.memory(wasm.OpI32Load, 0, 4)
+			c.emit(wasm.OpI32Ne, 0)
+		}, 0)
+		for i, m := range u.Members {
+			c.tagIs(x, i)
+			c.emit(wasm.OpIf, 0)
+			c.equal(m, at(x, m, payload), at(y, m, payload))
+			c.emit(wasm.OpReturn, 0)
+			c.emit(wasm.OpEnd, 0)
+		}
+	default:
+		c.errorf("cannot compare values of %s", t)
+	}
+	c.emit(wasm.OpI32Const, 1)
+}
+
+func (c *code) visitBody(t types.Type) {
+	v, op := c.get(0), c.get(1)
+	switch u := t.Underlying().(type) {
+	case *types.Tuple:
+		l := c.layout(t)
+		for i, f := range u.Fields {
+			c.visit(f.Type, v, l.Field(i).Offset, op)
+		}
+	case *types.Array:
+		n, elem := c.elems(u, v)
+		c.loop(n, func(i func()) {
+			c.visit(u.Elem, func() { elem(i) }, 0, op)
+		})
+	case *types.Union:
+		payload := c.layout(t).Payload
+		for i, m := range u.Members {
+			if !refers(m) {
+				continue
+			}
+			c.tagIs(v, i)
+			c.emit(wasm.OpIf, 0)
+			c.visit(m, v, payload, op)
+			c.emit(wasm.OpEnd, 0)
+		}
+	default:
+		c.errorf("cannot visit values of %s", t)
+	}
+}
@@ -0,0 +1,556 @@
+// Package wasmgen translates modules of the IR into WebAssembly modules,
+// which run in browsers and other sandboxes.
+//
+// A module is the runtime of runtime.wat, which provides the heap,
+// strings, dynamic arrays, the arithmetic that traps and the text of
+// values, linked with the functions of the module and the helpers that
+// write and compare values of its types; the functions no export reaches
+// are left out. Values are held as follows:
+//
+//   - Int64, UInt64 and enums as i64, narrower integers and Bools as i32,
+//     signed ones sign-extended and unsigned ones zero-extended, Float16
+//     and Float32 as f32 and Float64 as f64;
+//   - strings and symbols as the addresses of their length and bytes, and
+//     the errors returned by checked arithmetic as their messages;
+//   - tuples, unions and fixed-size arrays as the addresses of blocks laid
+//     out by package layout for 32-bit targets, which hold the tuples,
+//     unions and fixed-size arrays they are made of in place, but for the
+//     values of recursive types, which they hold by their addresses;
+//     unions begin with the index of the member held, the tag;
+//   - dynamic arrays as the addresses of their length and elements;
+//   - functions as the addresses of blocks holding the index in the
+//     module's table of their code, then from offset 8 the values a
+//     closure captures, laid out as a tuple of them;
+//   - the values of typeof as the addresses of strings holding the texts
+//     of the types they describe, one for each type described;
+//   - Nil as the i32 0.
+//
+// The code of a function value is a trampoline taking the block ahead of
+// the arguments and calling the function, with the values a closure
+// captures ahead of the arguments. The blocks of the functions that
+// capture nothing are constants.
+//
+// Memory holds the module's string and function constants from address 8,
+// and the heap after them. Strings, arrays, tuples, unions and the blocks
+// of closures are objects of the heap, whose references the runtime
+// counts, and constants have headers marking them static. Every local
+// holding a value holds a reference to it, as does every object to those
+// it refers to; values held in place in a block are copied out into
+// objects of their own when loaded. The objects that may hold closures
+// are watched for cycles, as in the C backend, through visit helpers the
+// runtime calls by kind.
+//
+// The phis of a block are assigned on each edge into it, through a copy of
+// each, and the blocks of a function are arranged into the structured
+// control flow of WebAssembly as in Norman Ramsey's "Beyond Relooper",
+// which requires it to be reducible.
+//
+// The host provides the fx functions the module declares, which it imports
+// from "env": print takes the address of a string. The runtime imports
+// from "tup" format_float, which writes the text of an f64 as print writes
+// it, 32 bytes at most, to the address given and returns its length, and
+// pow, which raises an f64 to the power of another. The module exports its
+// exported functions under their names, its main function as "main",
+// "memory", "tup_alloc", which allocates the strings and blocks passed to
+// exported functions, "tup_exit", which collects the cycles left when the
+// program ends and returns a string of the statistics of the heap, and the
+// global "tup_panic_msg", which holds the address of the message of the
+// runtime error that stopped the program at an unreachable instruction.
+package wasmgen
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/rowland/tuppence/tup/consteval"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/types"
+	"github.com/rowland/tuppence/tup/wasm"
+)
+
+//go:embed runtime.wat
+var runtime string
+
+// Error reports a module that cannot be translated, such as one using a
+// type that has no WebAssembly form.
+type Error struct {
+	Msg string
+}
+
+func (err *Error) Error() string { return "wasmgen: " + err.Msg }
+
+// genBailout is panicked with to abandon generation after an error.
+type genBailout struct{ err *Error }
+
+// dataStart is the address of the module's string constants; no value is
+// held at address 0.
+const dataStart = 8
+
+// runtimeLiterals holds the strings whose addresses the runtime's globals
+// hold, by the IDs of the globals.
+var runtimeLiterals = map[string]string{
+	"lit_overflow": "integer overflow",
+	"lit_division": "division by zero",
+	"lit_exponent": "negative exponent",
+	"lit_shift":    "negative shift count",
+	"lit_float":    "floating-point overflow",
+	"lit_memory":   "out of memory",
+	"lit_index":    "index ",
+	"lit_range":    " out of range [0:",
+	"lit_slice":    "slice [",
+	"lit_dots":     "..",
+	"lit_sliced":   "] out of range [0:",
+	"lit_true":     "true",
+	"lit_false":    "false",
+	"lit_nil":      "nil",
+	"lit_error":    "error(",
+
+	"lit_allocs":      "memstats: allocs ",
+	"lit_reused":      " (reused ",
+	"lit_frees":       "), frees ",
+	"lit_live":        ", live ",
+	"lit_max":         " (max ",
+	"lit_collections": "), collections ",
+	"lit_freed":       " (freed ",
+}
+
+// hostFuncs lists the host functions the module may import.
+var hostFuncs = map[string]bool{
+	"print": true,
+}
+
+// Generate returns the WebAssembly module translating m, which must have
+// been verified.
+func Generate(m *ir.Module) (mod *wasm.Module, err error) {
+	g := &generator{
+		module:   m,
+		out:      &wasm.Module{},
+		funcs:    map[string]uint32{},
+		rt:       map[string]uint32{},
+		globals:  map[string]uint32{},
+		literals: map[string]uint32{},
+		table:    map[string]*funcValue{},
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(genBailout)
+			if !ok {
+				panic(r)
+			}
+			err = b.err
+		}
+	}()
+	g.generate()
+	return g.out, nil
+}
+
+type generator struct {
+	module *ir.Module
+	out    *wasm.Module
+	// the indices of the functions of the module and of the runtime, and
+	// of the runtime's globals, by name
+	funcs, rt, globals map[string]uint32
+	// the helpers defined, in order, and the indices of the visit
+	// functions of the objects, by kind less one
+	helpers []*helper
+	kinds   []uint32
+	// the addresses of the string constants, by their contents, and the
+	// bytes of the data segment holding them
+	literals map[string]uint32
+	data     []byte
+	// the types described by the values of typeof, and the addresses of
+	// the strings of their texts, which are the values
+	described []types.Type
+	descAddrs []uint32
+	// the functions used as values, by name, and in the order of the
+	// table
+	table  map[string]*funcValue
+	values []*funcValue
+}
+
+func (g *generator) errorf(format string, args ...any) {
+	panic(genBailout{&Error{Msg: fmt.Sprintf(format, args...)}})
+}
+
+func (g *generator) generate() {
+	rt, err := wasm.ParseText(runtime)
+	if err != nil {
+		g.errorf("runtime: %v", err)
+	}
+	out := g.out
+	for _, imp := range rt.Imports {
+		g.rt[imp.ID] = uint32(len(out.Imports))
+		out.Imports = append(out.Imports, &wasm.Import{Module: imp.Module, Name: imp.Name, Type: out.TypeIndex(rt.Types[imp.Type]), ID: imp.ID})
+	}
+	for _, fn := range g.module.Funcs {
+		if !fn.Extern() {
+			continue
+		}
+		if !hostFuncs[fn.Name] {
+			g.errorf("host function %s is not provided by the WebAssembly host", fn.Name)
+		}
+		g.funcs[fn.Name] = uint32(len(out.Imports))
+		out.Imports = append(out.Imports, &wasm.Import{Module: "env", Name: fn.Name, Type: g.funcType(fn.Sig), ID: fn.Name})
+	}
+
+	// the runtime's functions follow the imports
+	shift := uint32(len(out.Imports) - len(rt.Imports))
+	for i, f := range rt.Funcs {
+		g.rt[f.ID] = uint32(len(out.Imports) + i)
+		for j, instr := range f.Body {
+			if instr.Op == wasm.OpCall && instr.Imm >= int64(len(rt.Imports)) {
+				f.Body[j].Imm += int64(shift)
+			}
+		}
+		f.Type = out.TypeIndex(rt.Types[f.Type])
+		out.Funcs = append(out.Funcs, f)
+	}
+	for i, glob := range rt.Globals {
+		g.globals[glob.ID] = uint32(i)
+		if s, ok := runtimeLiterals[glob.ID]; ok {
+			glob.Init.Imm = int64(g.literal(s))
+		}
+		out.Globals = append(out.Globals, glob)
+	}
+
+	var values []*ir.Instr
+	for _, fn := range g.module.Funcs {
+		if fn.Extern() {
+			continue
+		}
+		fn.Update()
+		g.funcs[fn.Name] = uint32(len(out.Imports) + len(out.Funcs))
+		out.Funcs = append(out.Funcs, &wasm.Func{Type: g.funcType(fn.Sig), ID: fn.Name})
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Op == ir.OpFunc {
+					values = append(values, instr)
+				}
+			}
+		}
+	}
+	// the table holds the trampolines of the functions used as values
+	for _, instr := range values {
+		g.funcValue(instr)
+	}
+	for _, fn := range g.module.Funcs {
+		if !fn.Extern() {
+			g.function(fn)
+		}
+	}
+
+	if len(g.values) > 0 {
+		elem := &wasm.Elem{}
+		for _, v := range g.values {
+			elem.Funcs = append(elem.Funcs, v.code)
+		}
+		out.Table = &wasm.Table{Min: uint32(len(g.values))}
+		out.Elems = []*wasm.Elem{elem}
+	}
+	g.visitKind()
+	g.exports()
+
+	heap := (dataStart + uint32(len(g.data)) + 7) &^ 7
+	out.Globals[g.globals["tup_heap"]].Init.Imm = int64(heap)
+	out.Memory = &wasm.Memory{Min: max(1, (heap+wasm.PageSize-1)/wasm.PageSize)}
+	if len(g.data) > 0 {
+		out.Data = []*wasm.Data{{Offset: dataStart, Bytes: g.data}}
+	}
+	g.link()
+}
+
+// exports exports the module's exported functions, its main function and
+// the parts of the runtime the host uses.
+func (g *generator) exports() {
+	out := g.out
+	for _, fn := range g.module.Funcs {
+		if fn.Export && !fn.Extern() && fn.Name != "main" {
+			out.Exports = append(out.Exports, &wasm.Export{Name: fn.Name, Kind: wasm.ExternFunc, Index: g.funcs[fn.Name]})
+		}
+	}
+	if main := g.module.Func("main"); main != nil {
+		if main.Extern() || len(main.Params) > 0 {
+			g.errorf("main must be a function without parameters")
+		}
+		out.Exports = append(out.Exports, &wasm.Export{Name: "main", Kind: wasm.ExternFunc, Index: g.funcs["main"]})
+	}
+	for _, exp := range []*wasm.Export{
+		{Name: "memory", Kind: wasm.ExternMemory},
+		{Name: "tup_alloc", Kind: wasm.ExternFunc, Index: g.rt["tup_alloc"]},
+		{Name: "tup_exit", Kind: wasm.ExternFunc, Index: g.rt["tup_exit"]},
+		{Name: "tup_panic_msg", Kind: wasm.ExternGlobal, Index: g.globals["tup_panic_msg"]},
+	} {
+		for _, other := range out.Exports {
+			if other.Name == exp.Name {
+				g.errorf("exported function %s has the name of an export of the runtime", exp.Name)
+			}
+		}
+		out.Exports = append(out.Exports, exp)
+	}
+}
+
+// visitKind supplies the body of the runtime's $tup_visit_kind, which
+// calls the visit function of the kind given.
+func (g *generator) visitKind() {
+	fn := g.out.Funcs[int(g.rt["tup_visit_kind"])-len(g.out.Imports)]
+	c := &code{generator: g, fn: fn}
+	for i, index := range g.kinds {
+		c.emit(wasm.OpLocalGet, 2)
+		c.emit(wasm.OpI32Const, int64(i+1))
+		c.emit(wasm.OpI32Eq, 0)
+		c.emit(wasm.OpIf, 0)
+		c.emit(wasm.OpLocalGet, 0)
+		c.emit(wasm.OpLocalGet, 1)
+		c.emit(wasm.OpCall, int64(index))
+		c.emit(wasm.OpReturn, 0)
+		c.emit(wasm.OpEnd, 0)
+	}
+}
+
+// link removes the imports and functions that the exports and the table
+// do not reach, renumbering the rest, and the types no longer used.
+func (g *generator) link() {
+	out := g.out
+	nimports := uint32(len(out.Imports))
+	used := make([]bool, int(nimports)+len(out.Funcs))
+	var visit func(index uint32)
+	visit = func(index uint32) {
+		if used[index] {
+			return
+		}
+		used[index] = true
+		if index >= nimports {
+			for _, instr := range out.Funcs[index-nimports].Body {
+				if instr.Op == wasm.OpCall {
+					visit(uint32(instr.Imm))
+				}
+			}
+		}
+	}
+	for _, exp := range out.Exports {
+		if exp.Kind == wasm.ExternFunc {
+			visit(exp.Index)
+		}
+	}
+	for _, elem := range out.Elems {
+		for _, index := range elem.Funcs {
+			visit(index)
+		}
+	}
+
+	renumber := make([]uint32, len(used))
+	var types []wasm.FuncType
+	typeIndex := func(old uint32) uint32 {
+		t := out.Types[old]
+		for i := range types {
+			if len(types[i].Params) == len(t.Params) && len(types[i].Results) == len(t.Results) && sameTypes(types[i], t) {
+				return uint32(i)
+			}
+		}
+		types = append(types, t)
+		return uint32(len(types) - 1)
+	}
+	var imports []*wasm.Import
+	var funcs []*wasm.Func
+	for i, imp := range out.Imports {
+		if used[i] {
+			renumber[i] = uint32(len(imports))
+			imp.Type = typeIndex(imp.Type)
+			imports = append(imports, imp)
+		}
+	}
+	for i, f := range out.Funcs {
+		if used[int(nimports)+i] {
+			renumber[int(nimports)+i] = uint32(len(imports) + len(funcs))
+			funcs = append(funcs, f)
+		}
+	}
+	for _, f := range funcs {
+		f.Type = typeIndex(f.Type)
+		for j, instr := range f.Body {
+			switch instr.Op {
+			case wasm.OpCall:
+				f.Body[j].Imm = int64(renumber[instr.Imm])
+			case wasm.OpCallIndirect:
+				f.Body[j].Imm = int64(typeIndex(uint32(instr.Imm)))
+			}
+		}
+	}
+	for _, exp := range out.Exports {
+		if exp.Kind == wasm.ExternFunc {
+			exp.Index = renumber[exp.Index]
+		}
+	}
+	for _, elem := range out.Elems {
+		for i, index := range elem.Funcs {
+			elem.Funcs[i] = renumber[index]
+		}
+	}
+	out.Types, out.Imports, out.Funcs = types, imports, funcs
+}
+
+func sameTypes(x, y wasm.FuncType) bool {
+	for i := range x.Params {
+		if x.Params[i] != y.Params[i] {
+			return false
+		}
+	}
+	for i := range x.Results {
+		if x.Results[i] != y.Results[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// funcType returns the index of the type of the functions of signature sig.
+func (g *generator) funcType(sig *types.Function) uint32 {
+	var t wasm.FuncType
+	for _, p := range sig.Params {
+		t.Params = append(t.Params, g.valType(p.Type))
+	}
+	if sig.Result != nil {
+		t.Results = []wasm.ValType{g.valType(sig.Result)}
+	}
+	return g.out.TypeIndex(t)
+}
+
+// closureType returns the index of the type of the trampolines of
+// function values of signature sig, which take the block of the function
+// value first.
+func (g *generator) closureType(sig *types.Function) uint32 {
+	t := wasm.FuncType{Params: []wasm.ValType{wasm.I32}}
+	for _, p := range sig.Params {
+		t.Params = append(t.Params, g.valType(p.Type))
+	}
+	if sig.Result != nil {
+		t.Results = []wasm.ValType{g.valType(sig.Result)}
+	}
+	return g.out.TypeIndex(t)
+}
+
+// capturesOffset is the offset of the values a closure captures in the
+// block of the function value.
+const capturesOffset = 8
+
+// funcValue is a function of the module used as a value: of type typ, by
+// OpFunc instructions passing the first captures parameters of fn.
+type funcValue struct {
+	fn       *ir.Func
+	typ      types.Type
+	captures int
+	// the index of its trampoline in the table and among the functions
+	index, code uint32
+	// the address of the block of a function that captures nothing
+	addr uint32
+}
+
+// capturesType returns the tuple of the types of the values v captures.
+func (v *funcValue) capturesType() types.Type {
+	fields := make([]*types.Field, v.captures)
+	for i, p := range v.fn.Params[:v.captures] {
+		fields[i] = types.NewField("", p.Typ)
+	}
+	return types.NewTuple(fields...)
+}
+
+// funcValue returns the function value of the OpFunc instr, defining its
+// trampoline, and the block of a function that captures nothing, first if
+// need be.
+func (g *generator) funcValue(instr *ir.Instr) *funcValue {
+	if v := g.table[instr.Callee]; v != nil {
+		return v
+	}
+	fn := g.module.Func(instr.Callee)
+	if fn == nil || fn.Extern() {
+		g.errorf("host function %s cannot be used as a value", instr.Callee)
+	}
+	v := &funcValue{fn: fn, typ: instr.Typ, captures: len(instr.Args), index: uint32(len(g.values))}
+	if instr.Rec {
+		v.captures++
+	}
+	g.table[fn.Name] = v
+	g.values = append(g.values, v)
+	v.code = uint32(len(g.out.Imports) + len(g.out.Funcs))
+	wf := &wasm.Func{Type: g.closureType(instr.Typ.Underlying().(*types.Function)), ID: fn.Name + ".code", LocalIDs: []string{"block"}}
+	g.out.Funcs = append(g.out.Funcs, wf)
+	for _, p := range fn.Params[v.captures:] {
+		wf.LocalIDs = append(wf.LocalIDs, p.Name)
+	}
+	c := &code{generator: g, fn: wf}
+	var copies []uint32
+	if v.captures == 0 {
+		v.addr = g.static()
+		g.data = binary.LittleEndian.AppendUint32(g.data, v.index)
+	} else {
+		l := g.layout(v.capturesType())
+		for i, p := range fn.Params[:v.captures] {
+			c.emit(wasm.OpLocalGet, 0)
+			c.load(p.Typ, capturesOffset+l.Field(i).Offset)
+			if inline(p.Typ) {
+				// the function is passed an object, which it may keep,
+				// rather than the value held in place in the block
+				c.copy(p.Typ)
+				copies = append(copies, c.temp(wasm.I32))
+				c.emit(wasm.OpLocalTee, int64(copies[len(copies)-1]))
+			}
+		}
+	}
+	for i := range fn.Params[v.captures:] {
+		c.emit(wasm.OpLocalGet, int64(i+1))
+	}
+	c.emit(wasm.OpCall, int64(g.funcs[fn.Name]))
+	for _, copy := range copies {
+		c.emit(wasm.OpLocalGet, int64(copy))
+		c.call("tup_release")
+	}
+	return v
+}
+
+// literal returns the address of a string constant holding s.
+func (g *generator) literal(s string) uint32 {
+	addr, ok := g.literals[s]
+	if !ok {
+		addr = g.string(s)
+		g.literals[s] = addr
+	}
+	return addr
+}
+
+// string adds a string holding s to the data segment and returns its
+// address.
+func (g *generator) string(s string) uint32 {
+	addr := g.static()
+	g.data = binary.LittleEndian.AppendUint32(g.data, uint32(len(s)))
+	g.data = append(g.data, s...)
+	return addr
+}
+
+// headerSize is the size of the header ahead of each object.
+const headerSize = 16
+
+// static adds the header of a constant object to the data segment, marking
+// it static, and returns the address of the object, which follows.
+func (g *generator) static() uint32 {
+	for len(g.data)%8 != 0 {
+		g.data = append(g.data, 0)
+	}
+	g.data = binary.LittleEndian.AppendUint32(g.data, 0xffffffff)
+	g.data = append(g.data, make([]byte, headerSize-4)...)
+	return dataStart + uint32(len(g.data))
+}
+
+// descriptor returns the value of typeof describing the type t: the
+// address of a string of its text that no other type shares, so that
+// values describe the same type only if they are equal.
+func (g *generator) descriptor(t types.Type) uint32 {
+	for i, d := range g.described {
+		if types.Identical(d, t) {
+			return g.descAddrs[i]
+		}
+	}
+	g.described = append(g.described, t)
+	g.descAddrs = append(g.descAddrs, g.string((&consteval.TypeValue{Typ: t}).String()))
+	return g.descAddrs[len(g.descAddrs)-1]
+}
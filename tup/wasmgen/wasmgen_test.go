@@ -0,0 +1,379 @@
+package wasmgen
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rowland/tuppence/tup/internal/testutil"
+	"github.com/rowland/tuppence/tup/ir"
+	"github.com/rowland/tuppence/tup/wasm"
+)
+
+// generate translates m and checks that the module decodes from its binary
+// format, is valid and reads back from its text format; it returns the
+// binary format and the text.
+func generate(t *testing.T, m *ir.Module) (bin []byte, text string) {
+	t.Helper()
+	mod, err := Generate(m)
+	if err != nil {
+		t.Fatalf("Generate() = %v", err)
+	}
+	bin = wasm.Encode(mod)
+	decoded, err := wasm.Decode(bin)
+	if err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	text = wasm.Text(mod)
+	if err := wasm.Validate(decoded); err != nil {
+		t.Fatalf("Validate() = %v\n%s", err, text)
+	}
+	parsed, err := wasm.ParseText(text)
+	if err != nil {
+		t.Fatalf("ParseText() = %v\n%s", err, text)
+	}
+	if !bytes.Equal(wasm.Encode(parsed), bin) {
+		t.Errorf("the text of the module encodes differently:\n%s", text)
+	}
+	return bin, text
+}
+
+// requireNode returns the node command, which runs the modules, skipping
+// the test if there is none. No runtime is needed to translate programs
+// and validate the modules, which package wasm does.
+func requireNode(t *testing.T) string {
+	t.Helper()
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skipf("no node command: %v", err)
+	}
+	return node
+}
+
+// run writes bin to a file and runs its main function with node and
+// testdata/host.mjs, returning what it wrote to stdout and stderr, which
+// ends with the statistics of the heap.
+func run(t *testing.T, node string, bin []byte) (stdout, stderr string, err error) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "prog.wasm")
+	if err := os.WriteFile(file, bin, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var out, errOut bytes.Buffer
+	cmd := exec.Command(node, filepath.Join("testdata", "host.mjs"), file)
+	cmd.Env = append(os.Environ(), "TUP_MEMSTATS=1")
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err = cmd.Run()
+	return out.String(), errOut.String(), err
+}
+
+const errorDecls = "E1 = error(message: String)\n" +
+	"E2 = error(code: Int)\n" +
+	"classify = fn(n: Int) !Int {\n\tif n < 0 { E1(\"negative\") } else if n == 0 { E2(0) } else { n }\n}\n"
+
+var programs = []struct {
+	name  string
+	input string
+	want  string
+}{
+	{"print", "main = fx() { print(1, \"a\", [1, 2], (x: 1)) }", "1 a [1, 2] (x: 1)\n"},
+	{"print in loop", "main = fx() {\n\tfor i in 1..3 { print(i) }\n}", "1\n2\n3\n"},
+	{"interpolation", "main = fx() {\n\tname = \"World\"\n\tprint(\"Hello, \\(name)!\")\n}", "Hello, World!\n"},
+	{"floats", "f = fx(x: Float) Float { x }\nmain = fx() { print(f(0.1) + f(0.2), f(1.5) * f(2.0), f(1e6), f(1e-5), f(123456.5), f(-0.25), f(2.0) ^ f(10.0)) }",
+		"0.30000000000000004 3.0 1e+06 1e-05 123456.5 -0.25 1024.0\n"},
+	{"Float32", "f = fx(x: Float32) Float32 { x }\nmain = fx() { print(f(1.5) + f(2.25), f(0.5) / f(4.0)) }", "3.75 0.125\n"},
+	{"integer types", "f = fx(x: Int8) Int8 { x }\ng = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(f(0) - f(100) - f(28), f(100) / (f(0) - f(3)), f(7) % (f(0) - f(2)), g(4294967296) * g(4294967295)) }",
+		"-128 -33 1 18446744069414584320\n"},
+	{"unsigned", "f = fx(x: UInt8) UInt8 { x }\ng = fx(x: UInt32) UInt32 { x }\nmain = fx() { print(f(200) + f(55), ~f(1), g(4000000000) + g(1), g(3) < g(4000000000), ~g(0)) }",
+		"255 254 4000000001 true 4294967295\n"},
+	{"power and shifts", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(3) ^ f(4), f(1) << f(62), f(-16) >> f(2)) }", "81 4611686018427387904 -4\n"},
+	{"bitwise", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(12) & f(10), f(12) | f(10), ~f(0)) }", "8 14 -1\n"},
+	{"nested strings are quoted", "main = fx() { print([\"a\\tb\", \"\\\"q\\\"\"], (\"x\", 1), [\"é\\u00a0\\xff\"]) }", "[\"a\\tb\", \"\\\"q\\\"\"] (\"x\", 1) [\"é\\u00a0\\xff\"]\n"},
+	{"strings", "f = fx(s: String) String { s }\nmain = fx() {\n\ts = f(\"abc\")\n\tprint(s + \"def\", len(s), s[1], s < \"abd\", s == \"abc\")\n}",
+		"abcdef 3 98 true true\n"},
+	{"symbols and nil", "main = fx() { print(:ok, nil, true) }", ":ok nil true\n"},
+	{"recursion", "fact = fn(n: Int) Int { if n <= 1 { 1 } else { n * fact(n - 1) } }\nmain = fx() { print(fact(20)) }", "2432902008176640000\n"},
+	{"function value", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\ninc = fn(n: Int) Int { n + 1 }\nmain = fx() { print(apply(41, inc), inc) }", "42 inc\n"},
+	{"function equality", "mk = fn(k: Int) fn(Int) Int {\n\taddk = fn(n: Int) Int { n + k }\n\taddk\n}\n" +
+		"inc = fn(n: Int) Int { n + 1 }\ndec = fn(n: Int) Int { n - 1 }\nsame = fx(f: fn(Int) Int, g: fn(Int) Int) Bool { f == g }\n" +
+		"main = fx() {\n\ta = mk(1)\n\tprint(same(a, a), same(inc, inc), same(inc, dec), same(a, inc))\n}", "true true false false\n"},
+	{"typeof", "Circle = type(r: Int)\nSquare = type(s: Int)\nShape = Circle | Square\nf = fx(s: Shape) Shape { s }\n" +
+		"is_circle = fn(s: Shape) Bool { typeof(s) == typeof(Circle) }\n" +
+		"main = fx() { print(is_circle(f(Circle(1))), is_circle(f(Square(2))), typeof(f(Square(2))), (typeof(Circle), 1)) }",
+		"true false typeof(Square) (typeof(Circle), 1)\n"},
+	{"slices", "f = fx(xs: []Int) []Int { xs }\ng = fx(s: String) String { s }\n" +
+		"main = fx() {\n\txs = f([1, 2, 3, 4])\n\tys = xs[1..2]\n\tprint(ys, xs[2..1], g(\"hello\")[1..3], [2]String[\"a\", \"b\"][0..0], ys << 5, xs)\n}",
+		"[2, 3] [] ell [\"a\"] [2, 3, 5] [1, 2, 3, 4]\n"},
+	{"closures", "apply = fn(v: Int, f: fn(Int) Int) Int { f(v) }\nadd = fn(a: Int, b: Int) Int { a + b }\n" +
+		"f = fn(k: Int) Int { apply(3) { apply(it) { |m| m * k + it } } }\n" +
+		"g = fn(k: Int) fn(Int) Int { add(k, *) }\n" +
+		"h = fn(k: Int) Int {\n\tsum = fn(n: Int) Int { if n == 0 { k } else { sum(n - 1) + n } }\n\tsum(4)\n}\n" +
+		"main = fx() {\n\tinc = g(1)\n\tprint(f(2), inc(41), h(10), inc, apply(1) { it + 1 })\n}",
+		"9 42 20 fn { ... } 2\n"},
+	{"labeled tuple", "Point = type(x: Int, y: Int)\nmove = fn(p: Point, dx: Int) Point { p.(x: p.x + dx) }\nmain = fx() { print(move(Point(1, 2), 3)) }", "(x: 4, y: 2)\n"},
+	{"tuple update", "Point = type(x: Int, y: Int)\nmain = fx() {\n\tp = Point(1, 2)\n\tq = p.(y: 5)\n\tprint(p)\n\tprint(q)\n}", "(x: 1, y: 2)\n(x: 1, y: 5)\n"},
+	{"mixed tuple", "Mixed = type(a: Int8, b: Int64, c: Int16, d: Bool)\nmk = fx(a: Int8, b: Int64, c: Int16, d: Bool) Mixed { Mixed(a, b, c, d) }\nmain = fx() { print(mk(1, 2, 3, true), mk(4, 5, 6, false).c) }",
+		"(a: 1, b: 2, c: 3, d: true) 6\n"},
+	{"tuple equality", "Point = type(x: Int, y: Int)\nf = fx(p: Point) Point { p }\nmain = fx() { print(f(Point(1, 2)) == Point(1, 2), f(Point(1, 2)) != Point(2, 1)) }", "true true\n"},
+	{"arrays", "f = fx(xs: []Int) []Int { xs }\nmain = fx() {\n\txs = f([1, 2, 3])\n\tys = xs << 4\n\tzs = xs << 5\n\tprint(ys, zs, len(ys), xs[2], xs == [1, 2, 3])\n}",
+		"[1, 2, 3, 4] [1, 2, 3, 5] 4 3 true\n"},
+	{"appends share", "f = fx(xs: []Int) []Int { xs }\nmain = fx() {\n\tys = f([1]) << 2\n\ta = ys << 3\n\tb = ys << 4\n\tprint(a, b, a << 5, ys)\n}",
+		"[1, 2, 3] [1, 2, 4] [1, 2, 3, 5] [1, 2]\n"},
+	{"nested arrays", "f = fx(xs: [][]Int) [][]Int { xs }\nmain = fx() { print(f([[1], [2, 3]])) }", "[[1], [2, 3]]\n"},
+	{"arrays of tuples", "P = type(x: Int8, s: String)\nf = fx(ps: []P) []P { ps }\nmain = fx() {\n\tps = f([P(1, \"a\")]) << P(2, \"b\")\n\tprint(ps, ps[1].s, ps == [P(1, \"a\"), P(2, \"b\")])\n}",
+		"[(x: 1, s: \"a\"), (x: 2, s: \"b\")] b true\n"},
+	{"enum", "Color = enum(\n\tred\n\tgreen\n\tblue\n)\nf = fx(c: Color) Color { c }\nmain = fx() { print(f(Color.green), f(Color.blue).int(), f(Color.red).string()) }",
+		"Color.green 2 red\n"},
+	{"checked arithmetic", "f = fx(x: Int8) Int8 { x }\nmain = fx() { print(f(100) ?+ f(27), f(100) ?+ f(100), f(1) ?/ f(0)) }",
+		"127 error(\"integer overflow\") error(\"division by zero\")\n"},
+	{"integer conversions", "f = fx(x: Int) Int { x }\ng = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(Int8(f(-5)), UInt8(f(200)), Int(g(7)), UInt64(f(9)), Int16(Int8(f(-3))), UInt64(~g(0))) }",
+		"-5 200 7 9 -3 18446744073709551615\n"},
+	{"errors", errorDecls + "main = fx() { print(classify(-1), classify(0), classify(3)) }", "(message: \"negative\") (code: 0) 3\n"},
+	{"try", errorDecls + "f = fn(n: Int) !Int {\n\tv = try classify(n)\n\tv + 1\n}\nmain = fx() { print(f(41), f(-1)) }", "42 (message: \"negative\")\n"},
+	{"switch on union", errorDecls + "f = fn(n: Int) String {\n\tv = classify(n)\n\tswitch v {\n\t\tE1 { \"E1\" }\n\t\tE2 { \"E2\" }\n\t\tInt { \"Int\" }\n\t}\n}\nmain = fx() { print(f(-1), f(0), f(1)) }",
+		"E1 E2 Int\n"},
+	{"named union", "IS = Int | String\nf = fx(x: Int, b: Bool) IS { if b { x } else { \"s\" } }\nmain = fx() { print(f(1, true), f(1, false), f(2, true) == f(2, true), f(2, true) == f(2, false)) }",
+		"1 s true false\n"},
+	{"union with nil", "Result = String | Nil\nf = fx(n: Int) Result { switch n { 1 { \"one\" } } }\nmain = fx() { print(f(1), f(2), f(2) == f(3)) }",
+		"one nil true\n"},
+	{"loop", "sum = fn(ns: ...Int) Int { for s = 0; n in ns { s + n } }\nmain = fx() { print(sum(1, 2, 3, 4)) }", "10\n"},
+	{"nested loops", "f = fn(n: Int) Int {\n\tfor s = 0; i in 0..n {\n\t\ts + (for t = 0; j in 0..i { if j % 2 == 0 { t + j } else { t } })\n\t}\n}\nmain = fx() { print(f(5)) }", "16\n"},
+	{"fib", "fib = fn(n: Int) []Int {\n\tfor (a, b, acc) = (0, 1, Int[]); len(acc) < n {\n\t\t(b, a + b, acc << a)\n\t}.2\n}\nmain = fx() { print(fib(12)) }",
+		"[0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89]\n"},
+	{"generics", "id[a]: fn(x: a) a { x }\npair[a, b]: fn(x: a, y: b) (a, b) { (x, y) }\nNumeric[a] = contract(\n\tmul[a] = fn(a, a) a\n)\nsqr[a]: fn(x: Numeric[a]) a { x * x }\nmain = fx() { print(id(1), id(\"s\"), pair(1, \"x\"), sqr(3), sqr(1.5)) }",
+		"1 s (1, \"x\") 9 2.25\n"},
+	{"recursive types", "IntCons = type(head: Int, tail: IntList)\nIntList = Nil | IntCons\n" +
+		"range = fn(n: Int) IntList { if n == 0 { nil } else { IntCons(n, range(n - 1)) } }\n" +
+		"sum = fn(l: IntList) Int {\n\tswitch l {\n\t\tIntCons { |c| c.head + sum(c.tail) }\n\t\tNil { 0 }\n\t}\n}\n" +
+		"main = fx() {\n\tl = range(3)\n\tprint(l, sum(l), l == range(3), [l] << range(1))\n}",
+		"(head: 3, tail: (head: 2, tail: (head: 1, tail: nil))) 6 true [(head: 3, tail: (head: 2, tail: (head: 1, tail: nil))), (head: 1, tail: nil)]\n"},
+	{"generic recursive types", "Cons[a] = type(head: a, tail: List[a])\nList[a] = Nil | Cons[a]\n" +
+		"map[a, b]: fn(list: List[a], f: fn(a) b) List[b] {\n\tswitch list {\n\t\tNil { nil }\n\t\tCons { |c| Cons(head: f(c.head), tail: map(c.tail, f)) }\n\t}\n}\n" +
+		"main = fx() { print(map(Cons(1, Cons(2, nil))) { \"<\\(it)>\" }) }",
+		"(head: \"<1>\", tail: (head: \"<2>\", tail: nil))\n"},
+}
+
+// TestGenerate checks that each program translates to a valid module.
+func TestGenerate(t *testing.T) {
+	for _, test := range programs {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := testutil.Lower(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			generate(t, m)
+		})
+	}
+}
+
+// TestRun runs each program with node and checks what its main function
+// prints, which is what the interpreter prints. It is skipped if there is
+// no node command.
+func TestRun(t *testing.T) {
+	node := requireNode(t)
+	for _, test := range programs {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			m, err := testutil.Lower(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			bin, _ := generate(t, m)
+			got, stderr, err := run(t, node, bin)
+			if err != nil {
+				t.Fatalf("node: %v\n%s", err, stderr)
+			}
+			if got != test.want {
+				t.Errorf("program wrote %q, want %q", got, test.want)
+			}
+			if !strings.Contains(stderr, " live 0 ") {
+				t.Errorf("objects live after running, want none: %s", stderr)
+			}
+		})
+	}
+}
+
+// TestMemory checks that programs free the objects they allocate, the
+// closures of recursive local functions, which capture themselves, by
+// collecting cycles. It is skipped if there is no node command.
+func TestMemory(t *testing.T) {
+	node := requireNode(t)
+	tests := []struct {
+		name  string
+		input string
+		want  string // part of the statistics of the heap
+	}{
+		{"strings in a loop", "f = fx(s: String) String { s }\nmain = fx() {\n\ts = for s = f(\"\"); i in 1..100 { s + \"\\(i)\" }\n\tprint(len(s))\n}",
+			"live 0 (max 5), collections 0"},
+		{"arrays in a loop", "main = fx() {\n\txs = for xs = String[]; i in 1..100 { xs << \"\\(i)\" }\n\tprint(len(xs[10..20]))\n}", "live 0"},
+		{"tuples in a loop", "P = type(s: String, n: Int)\nQ = type(p: P, n: Int)\nf = fx(p: P) P { p }\n" +
+			"main = fx() {\n\tq = for q = Q(f(P(\"a\", 0)), 0); i in 1..100 {\n\t\tp = q.p\n\t\tQ(p.(s: p.s + \"b\"), i)\n\t}\n\tprint(len(q.p.s), q.n)\n}",
+			"live 0 (max 12), collections 0"},
+		{"cycles", "mk = fn(k: Int) fn(Int) Int {\n\tsum = fn(n: Int) Int { if n == 0 { k } else { sum(n - 1) + n } }\n\tsum\n}\n" +
+			"main = fx() {\n\tt = for t = 0; i in 1..10 {\n\t\tf = mk(i)\n\t\tt + f(3)\n\t}\n\tprint(t)\n}",
+			"live 0 (max 13), collections 1 (freed 10)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			m, err := testutil.Lower(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			bin, _ := generate(t, m)
+			_, stderr, err := run(t, node, bin)
+			if err != nil {
+				t.Fatalf("node: %v\n%s", err, stderr)
+			}
+			if !strings.Contains(stderr, test.want) {
+				t.Errorf("program wrote %q to stderr, want %q", stderr, test.want)
+			}
+		})
+	}
+}
+
+// TestTraps checks that operations that trap stop the program with a
+// runtime error and exit status 2. It is skipped if there is no node
+// command.
+func TestTraps(t *testing.T) {
+	node := requireNode(t)
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"overflow", "f = fx(x: Int8) Int8 { x }\nmain = fx() { print(f(100) + f(100)) }", "runtime error: integer overflow"},
+		{"negation overflow", "f = fx(x: Int) Int { x }\nmain = fx() { print(-(f(-9223372036854775807) - 1)) }", "runtime error: integer overflow"},
+		{"conversion overflow", "f = fx(x: Int) Int { x }\nmain = fx() { print(Int8(f(200))) }", "runtime error: integer overflow"},
+		{"negative to unsigned", "f = fx(x: Int) Int { x }\nmain = fx() { print(UInt(f(-1))) }", "runtime error: integer overflow"},
+		{"unsigned to signed", "g = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(Int(~g(0))) }", "runtime error: integer overflow"},
+		{"unsigned overflow", "f = fx(x: UInt64) UInt64 { x }\nmain = fx() { print(f(1) - f(2)) }", "runtime error: integer overflow"},
+		{"division by zero", "f = fx(x: Int) Int { x }\nmain = fx() { print(f(1) / f(0)) }", "runtime error: division by zero"},
+		{"index out of range", "f = fx(xs: []Int) []Int { xs }\nmain = fx() { print(f([1, 2])[2]) }", "runtime error: index 2 out of range [0:2]"},
+		{"slice out of range", "f = fx(xs: []Int) []Int { xs }\nmain = fx() { print(f([1, 2])[1..2]) }", "runtime error: slice [1..2] out of range [0:2]"},
+		{"float overflow", "f = fx(x: Float) Float { x }\nmain = fx() { print(f(1e308) * f(10.0)) }", "runtime error: floating-point overflow"},
+		{"output before trap", "f = fx(x: Int) Int { x }\nmain = fx() {\n\tprint(1)\n\tprint(f(1) / f(0))\n}", "division by zero"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			m, err := testutil.Lower(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			bin, _ := generate(t, m)
+			_, stderr, err := run(t, node, bin)
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) || exit.ExitCode() != 2 {
+				t.Fatalf("program exited with %v, want exit status 2\n%s", err, stderr)
+			}
+			if !strings.Contains(stderr, test.wantErr) {
+				t.Errorf("program wrote %q to stderr, want %q", stderr, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestModule checks the imports, exports and instructions of the modules
+// of programs.
+func TestModule(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string // substrings of the text of the module
+		not   []string
+	}{
+		{"print", "main = fx() { print(\"hi\") }",
+			[]string{`(import "env" "print" (func $print`, `(export "main" (func $main))`, `(export "memory" (memory 0))`,
+				`(export "tup_panic_msg" (global $tup_panic_msg))`, `(data (i32.const 8) "\ff\ff\ff\ff`, `\02\00\00\00hi`, `(export "tup_exit" (func $tup_exit))`},
+			[]string{`"tup" "pow"`, "call_indirect", "(table"}},
+		{"exported", "inc: fn(n: Int) Int { n + 1 }\nf = fn(n: Int) Int { inc(n) }",
+			[]string{`(export "inc" (func $inc))`, "(param $n i64) (result i64)"},
+			[]string{`(import "env"`, `"main"`, "$f "}},
+		{"labeled tuple", "Mixed: type(a: Int8, b: Int64, c: Int16)\nf: fn(m: Mixed) Int16 { m.c }",
+			[]string{"i32.load16_s offset=8\n"}, nil},
+		{"union", "IS = Int | String\nf: fn(x: Int, b: Bool) IS { if b { x } else { \"s\" } }",
+			[]string{"call $tup_new", "i32.store\n", "i64.store offset=4 align=4"}, nil},
+		{"function value", "apply: fn(v: Int, f: fn(Int) Int) Int { f(v) }\ninc: fn(n: Int) Int { n + 1 }\nmain = fx() { print(apply(41, inc)) }",
+			[]string{"call_indirect (type", "(table (;0;) 1 funcref)", "(elem (i32.const 0) func $inc.code)", "call $inc\n"}, nil},
+		{"loop", "sum: fn(ns: []Int) Int { for s = 0; n in ns { s + n } }",
+			[]string{"    loop\n", "br 1\n"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := testutil.Lower(t, "test.tup", test.input)
+			if err != nil {
+				t.Fatalf("lower(%q) = %v", test.input, err)
+			}
+			_, text := generate(t, m)
+			for _, want := range test.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("module does not contain %q:\n%s", want, text)
+				}
+			}
+			for _, not := range test.not {
+				if strings.Contains(text, not) {
+					t.Errorf("module contains %q:\n%s", not, text)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"host function", "module m\n\ndeclare fx @read() String\n\nfx @f() String {\nb0:\n  %0 = call fx String @read()\n  ret %0\n}\n",
+			"host function read is not provided by the WebAssembly host"},
+		{"main with parameters", "module m\n\nfx @main(%n: Int) {\nb0:\n  ret\n}\n", "main must be a function without parameters"},
+		{"irreducible", "module m\n\nfn @f(%c: Bool) {\nb0:\n  br %c, b1, b2\nb1:\n  jump b2\nb2:\n  jump b1\n}\n",
+			"f has irreducible control flow"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ir.Parse(test.input)
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			_, err = Generate(m)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Generate() = %v, want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestExamples translates each example the IR can lower, and runs those
+// with a main function with node, comparing their output with the
+// interpreter's golden output in ../interp/testdata/<name>.out. Without a
+// node command, those are skipped once their modules are validated.
+func TestExamples(t *testing.T) {
+	testutil.Examples(t, testutil.Lower, func(t *testing.T, name string, m *ir.Module) {
+		bin, _ := generate(t, m)
+		if m.Func("main") == nil {
+			return
+		}
+		node := requireNode(t)
+		got, stderr, err := run(t, node, bin)
+		if err != nil {
+			t.Fatalf("node: %v\n%s", err, stderr)
+		}
+		want, err := os.ReadFile(filepath.Join("..", "interp", "testdata", name+".out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s wrote:\n%s\nwant:\n%s", name, got, want)
+		}
+		if !strings.Contains(stderr, " live 0 ") {
+			t.Errorf("objects live after running, want none: %s", stderr)
+		}
+	})
+}